@@ -10,6 +10,11 @@
 // entirely. Records retired by Set or Delete have their type byte
 // patched from 2 to 3 (history), so the type check at TypePos
 // naturally excludes them.
+//
+// AllWith filters by label prefix and modification time as it scans,
+// from the bytes of each record line, and only then decodes what it
+// keeps; an export of one prefix or a sync since a checkpoint skips
+// the rest without unescaping their bodies.
 package folio
 
 import (
@@ -18,6 +23,10 @@ import (
 	"fmt"
 	"io"
 	"iter"
+	"strconv"
+	"strings"
+
+	"github.com/zeebo/xxh3"
 )
 
 // Document is a label–content pair yielded by All.
@@ -26,12 +35,50 @@ type Document struct {
 	Data  string
 }
 
+// DocumentInfo is a Document with the metadata exporters and sync jobs
+// would otherwise fetch with a follow-up History call per document.
+type DocumentInfo struct {
+	Document
+	Timestamp    int64  // unix ms of the current version
+	Size         int    // length of Data in bytes
+	VersionCount int    // current version plus all history versions
+	ContentHash  string // xxHash3 of Data, 16 hex chars
+}
+
 // All yields every current document as a label–content pair. It scans
 // data records directly, avoiding the N+1 cost of List followed by
 // Get for each label. Callers consume results lazily via range and
 // can break early to stop the scan.
 func (db *DB) All() iter.Seq2[Document, error] {
+	return db.AllWith(AllOptions{})
+}
+
+// AllOptions selects the documents AllWith yields and what of each.
+type AllOptions struct {
+	// LabelPrefix keeps only labels that start with it, compared as
+	// stored, as ListPrefix does.
+	LabelPrefix string
+
+	// ModifiedSince keeps only documents whose current version was
+	// written at or after it, in unix ms.
+	ModifiedSince int64
+
+	// MaxDocs, if positive, stops after that many documents.
+	MaxDocs int
+
+	// LabelsOnly yields each document with Data left empty, without
+	// decoding its content.
+	LabelsOnly bool
+}
+
+// AllWith is All restricted to the documents opts selects. The label
+// and timestamp are read from the record line before its content is
+// unescaped, decoded or verified, so documents a filter drops cost only
+// the scan, and with LabelsOnly so do the ones it keeps.
+func (db *DB) AllWith(opts AllOptions) iter.Seq2[Document, error] {
 	return func(yield func(Document, error) bool) {
+		db.beginScan()
+		defer db.endScan()
 		if err := db.blockRead(); err != nil {
 			yield(Document{}, err)
 			return
@@ -41,60 +88,180 @@ func (db *DB) All() iter.Seq2[Document, error] {
 			db.lock.Unlock()
 		}()
 
-		sz, err := size(db.reader)
+		n := 0
+		err := db.documents(false, func(d docLine) bool {
+			if !strings.HasPrefix(d.label, opts.LabelPrefix) {
+				return true
+			}
+			if opts.ModifiedSince != 0 {
+				ts, _ := strconv.ParseInt(string(d.line[TSStart:TSEnd]), 10, 64)
+				if ts < opts.ModifiedSince {
+					return true
+				}
+			}
+			n++
+			more := opts.MaxDocs <= 0 || n < opts.MaxDocs
+			if opts.LabelsOnly {
+				return yield(Document{Label: d.label}, nil) && more
+			}
+			content, err := db.content(d)
+			if err != nil {
+				return yield(Document{Label: d.label}, fmt.Errorf("all: %w", err)) && more
+			}
+			db.usage.bytesRead.Add(uint64(len(content)))
+			return yield(Document{Label: d.label, Data: string(content)}, nil) && more
+		})
 		if err != nil {
-			yield(Document{}, fmt.Errorf("all: stat: %w", err))
+			yield(Document{}, err)
+		}
+	}
+}
+
+// AllInfo is All with per-document metadata. Version counts are
+// gathered in the same pass: every version of a document is written
+// before its current record, so by the time the scan reaches the
+// current record all of its history has already been counted.
+func (db *DB) AllInfo() iter.Seq2[DocumentInfo, error] {
+	return func(yield func(DocumentInfo, error) bool) {
+		db.beginScan()
+		defer db.endScan()
+		if err := db.blockRead(); err != nil {
+			yield(DocumentInfo{}, err)
 			return
 		}
+		defer func() {
+			db.mu.RUnlock()
+			db.lock.Unlock()
+		}()
 
-		dTag := []byte(`"_d":"`)
-		hTag := []byte(`","_h":"`)
-		seen := make(map[string]bool)
+		err := db.documents(true, func(d docLine) bool {
+			content, err := db.content(d)
+			if err != nil {
+				return yield(DocumentInfo{Document: Document{Label: d.label}}, fmt.Errorf("allinfo: %w", err))
+			}
+			db.usage.bytesRead.Add(uint64(len(content)))
+			ts, _ := strconv.ParseInt(string(d.line[TSStart:TSEnd]), 10, 64)
+			return yield(DocumentInfo{
+				Document:     Document{Label: d.label, Data: string(content)},
+				Timestamp:    ts,
+				Size:         len(content),
+				VersionCount: d.versions,
+				ContentHash:  fmt.Sprintf("%016x", xxh3.Hash(content)),
+			}, nil)
+		})
+		if err != nil {
+			yield(DocumentInfo{}, err)
+		}
+	}
+}
 
-		// scanRegion scans [start, end) for data records, extracting
-		// label and content. Returns false if the caller broke out.
-		scanRegion := func(start, end int64) bool {
-			if start >= end {
-				return true
+// docLine is a current data record found by documents. line and data
+// alias the scanner buffer and are only valid during the callback.
+type docLine struct {
+	line     []byte
+	label    string
+	data     []byte // raw _d bytes, still JSON-escaped
+	versions int    // data + history records for label; 0 unless counted
+}
+
+// content returns the document's original bytes, unescaped (or base64
+// decoded, or decrypted) and verified against the line's checksum. A
+// damaged document is reported with its label and the scan continues.
+func (db *DB) content(d docLine) ([]byte, error) {
+	if shared(d.line) {
+		r, err := db.decode(d.line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", d.label, err)
+		}
+		return []byte(r.Data), nil
+	}
+	content := unescape(d.data)
+	if encrypted(d.line) {
+		raw, err := decrypt64(db.cipher, d.data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", d.label, err)
+		}
+		content = raw
+	} else if binary(d.line) {
+		raw, err := unbase64(d.data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", d.label, err)
+		}
+		content = raw
+	}
+	if k := sum(d.line); k != 0 && k != checksum(content) {
+		return nil, fmt.Errorf("%s: %w: %w", d.label, ErrCorruptRecord, ErrChecksum)
+	}
+	return content, nil
+}
+
+// documents scans the heap and sparse regions for current data records,
+// calling fn for each until it returns false. When countVersions is set,
+// history records are tallied per label as they pass. The caller must
+// hold the read lock.
+func (db *DB) documents(countVersions bool, fn func(docLine) bool) error {
+	sz, err := size(db.reader)
+	if err != nil {
+		return fmt.Errorf("all: stat: %w", err)
+	}
+
+	dTag := []byte(`"_d":"`)
+	hTag := []byte(`","_h":"`)
+	seen := make(map[string]bool)
+	counts := make(map[string]int)
+
+	// scanRegion scans [start, end) for data records, extracting
+	// label and content. Returns false if the caller broke out.
+	scanRegion := func(start, end int64) (bool, error) {
+		if start >= end {
+			return true, nil
+		}
+		f := db.borrow()
+		defer db.giveBack(f)
+		section := io.NewSectionReader(f, start, end-start)
+		scanner := bufio.NewScanner(section)
+		scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
+
+		for scanner.Scan() {
+			ln := scanner.Bytes()
+			if !valid(ln) || len(ln) < MinRecordSize {
+				continue
+			}
+
+			if countVersions && ln[TypePos] == byte('0'+TypeHistory) {
+				counts[label(ln)]++
+				continue
 			}
-			section := io.NewSectionReader(db.reader, start, end-start)
-			scanner := bufio.NewScanner(section)
-			scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
-
-			for scanner.Scan() {
-				ln := scanner.Bytes()
-
-				if valid(ln) && len(ln) >= MinRecordSize && ln[TypePos] == byte('0'+TypeRecord) {
-					lbl := label(ln)
-					if lbl != "" && !seen[lbl] {
-						seen[lbl] = true
-						di := bytes.Index(ln, dTag)
-						if di >= 0 {
-							s := di + len(dTag)
-							hi := bytes.Index(ln[s:], hTag)
-							if hi >= 0 {
-								content := string(unescape(ln[s : s+hi]))
-								if !yield(Document{Label: lbl, Data: content}, nil) {
-									return false
-								}
+
+			if ln[TypePos] == byte('0'+TypeRecord) {
+				lbl := label(ln)
+				if countVersions {
+					counts[lbl]++
+				}
+				if lbl != "" && !seen[lbl] {
+					seen[lbl] = true
+					di := bytes.Index(ln, dTag)
+					if di >= 0 {
+						s := di + len(dTag)
+						hi := bytes.Index(ln[s:], hTag)
+						if hi >= 0 {
+							if !fn(docLine{ln, lbl, ln[s : s+hi], counts[lbl]}) {
+								return false, nil
 							}
 						}
 					}
 				}
 			}
-
-			if err := scanner.Err(); err != nil {
-				yield(Document{}, err)
-				return false
-			}
-			return true
 		}
 
-		// Heap: data records. Skip the index section.
-		if !scanRegion(HeaderSize, db.heapEnd()) {
-			return
-		}
-		// Sparse: unsorted appends since last compaction.
-		scanRegion(db.sparseStart(), sz)
+		return true, scanner.Err()
+	}
+
+	// Heap: data records. Skip the index section.
+	if ok, err := scanRegion(HeaderSize, db.heapEnd()); !ok || err != nil {
+		return err
 	}
+	// Sparse: unsorted appends since last compaction.
+	_, err = scanRegion(db.sparseStart(), sz)
+	return err
 }
@@ -0,0 +1,186 @@
+// Asynchronous writes.
+//
+// Every Set takes the write lock and the file lock, and with SyncWrites
+// pays its own fsyncs, so an ingest job that writes one document at a
+// time is bound by them however many goroutines it runs. SetAsync hands
+// the write to a queue instead and returns at once. A goroutine, started
+// by the first SetAsync, takes whatever has queued up since its last
+// pass, up to maxQueued writes, and writes them under one hold of the
+// locks with one sync at the end: one lock round trip and one fsync for
+// the lot, each write's own appends and patches unsynced until then, as
+// a Txn's retirements are. Under load the passes grow as the writes
+// arrive faster than they are written.
+//
+// Each write is validated as Set validates it before it is queued, and
+// otherwise succeeds or fails on its own: the channel SetAsync returns
+// receives its error, nil once it is written and synced as configured,
+// and is then closed. Flush waits for every write queued before it.
+// Close does too, writing what is queued before the file is closed.
+//
+// The queue's writes report to Config.MetricsCollector as OpSet, timed
+// from SetAsync to their sync, and run their after hooks on the queue's
+// goroutine, so a hook that calls Flush would wait for itself.
+package folio
+
+import (
+	"sync"
+	"time"
+)
+
+// maxQueued caps the writes the queue makes under one hold of the
+// locks, so readers are not shut out for long, and sizes its buffer.
+const maxQueued = 1024
+
+// queued is one write on the queue, or the marker a Flush waits on.
+type queued struct {
+	label, content string
+	start          time.Time
+	done           chan error
+	flush          bool
+}
+
+// writeQueue feeds the queue's goroutine.
+type writeQueue struct {
+	mu     sync.RWMutex  // write-locked to start or stop the goroutine
+	ch     chan queued   // nil until the first SetAsync
+	done   chan struct{} // closed when the goroutine exits
+	closed bool
+}
+
+// SetAsync queues a write of content to label and returns at once. The
+// channel it returns receives the write's error, nil once it is written
+// and synced as configured. A label or content Set would refuse is
+// reported without being queued.
+func (db *DB) SetAsync(label, content string) <-chan error {
+	done := make(chan error, 1)
+	err := db.checkDoc(label, content)
+	if err == nil {
+		err = db.enqueue(queued{label: label, content: content, start: time.Now(), done: done})
+	}
+	if err != nil {
+		db.observe(OpSet, label, time.Now(), &err)
+		done <- err
+		close(done)
+	}
+	return done
+}
+
+// Flush waits until every write queued by SetAsync before it has been
+// written and synced. Each write's error goes to its own channel.
+func (db *DB) Flush() error {
+	done := make(chan error, 1)
+	if err := db.enqueue(queued{done: done, flush: true}); err != nil {
+		return err
+	}
+	return <-done
+}
+
+// enqueue puts w on the queue, starting its goroutine if need be.
+func (db *DB) enqueue(w queued) error {
+	q := &db.queue
+	q.mu.RLock()
+	if q.ch == nil && !q.closed {
+		q.mu.RUnlock()
+		q.mu.Lock()
+		if q.ch == nil && !q.closed {
+			q.ch = make(chan queued, maxQueued)
+			q.done = make(chan struct{})
+			go db.drain(q.ch, q.done)
+		}
+		q.mu.Unlock()
+		q.mu.RLock()
+	}
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrClosed
+	}
+	q.ch <- w
+	return nil
+}
+
+// stopQueue writes what is queued and stops the goroutine. Called by
+// Close before the state changes, so the writes land in an open file.
+func (db *DB) stopQueue() {
+	q := &db.queue
+	q.mu.Lock()
+	ch, done := q.ch, q.done
+	q.closed = true
+	q.mu.Unlock()
+	if ch == nil {
+		return
+	}
+	close(ch)
+	<-done
+}
+
+// drain is the queue's goroutine: it writes what has queued up, one
+// pass at a time, until ch is closed.
+func (db *DB) drain(ch chan queued, done chan struct{}) {
+	defer close(done)
+	for w := range ch {
+		pass := []queued{w}
+	more:
+		for len(pass) < maxQueued {
+			select {
+			case w, ok := <-ch:
+				if !ok {
+					break more
+				}
+				pass = append(pass, w)
+			default:
+				break more
+			}
+		}
+		db.writeQueued(pass)
+	}
+}
+
+// writeQueued makes one pass of the queue's writes and reports each.
+func (db *DB) writeQueued(pass []queued) {
+	errs := make([]error, len(pass))
+	if err := db.blockWrite(); err != nil {
+		for i, w := range pass {
+			if !w.flush {
+				errs[i] = err
+			}
+		}
+	} else {
+		db.lazySync = true
+		for i, w := range pass {
+			if !w.flush {
+				errs[i] = db.setOne(w.label, w.content, 0)
+			}
+		}
+		db.lazySync = false
+		err := db.sync()
+
+		compact := db.shouldCompact()
+		events := db.takeEvents()
+		db.mu.Unlock()
+		db.lock.Unlock()
+
+		if err == nil {
+			err = db.durable()
+		}
+		if err != nil {
+			for i := range errs {
+				if errs[i] == nil && !pass[i].flush {
+					errs[i] = err
+				}
+			}
+		}
+		db.after(events)
+		if compact {
+			db.Compact()
+		}
+	}
+
+	for i, w := range pass {
+		err := errs[i]
+		if !w.flush {
+			db.observe(OpSet, w.label, w.start, &err)
+		}
+		w.done <- err
+		close(w.done)
+	}
+}
@@ -0,0 +1,104 @@
+package folio
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestSetAsync verifies that queued writes are all written, in order
+// for one label, and that each channel reports its own write.
+func TestSetAsync(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{SyncWrites: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var results []<-chan error
+	for i := range 500 {
+		results = append(results, db.SetAsync(fmt.Sprintf("doc/%d", i%50), fmt.Sprintf("v%d", i)))
+	}
+	bad := db.SetAsync("", "content")
+	if err := db.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	for i, done := range results {
+		if err := <-done; err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+	if err := <-bad; !errors.Is(err, ErrInvalidLabel) {
+		t.Errorf("SetAsync with an empty label = %v, want ErrInvalidLabel", err)
+	}
+	for i := range 50 {
+		lbl := fmt.Sprintf("doc/%d", i)
+		if got, err := db.Get(lbl); err != nil || got != fmt.Sprintf("v%d", 450+i) {
+			t.Errorf("Get(%s) = %q, %v; want v%d", lbl, got, err, 450+i)
+		}
+		if versions, _ := Collect(db.History(lbl)); len(versions) != 10 {
+			t.Errorf("History(%s) holds %d versions, want 10", lbl, len(versions))
+		}
+	}
+	if rep, err := db.Verify(VerifyOptions{}); err != nil || !rep.OK() {
+		t.Errorf("Verify = %+v, %v", rep.Problems, err)
+	}
+}
+
+// TestSetAsyncConcurrent verifies writes queued from many goroutines
+// while Flush and Close run.
+func TestSetAsyncConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Go(func() {
+			for i := range 100 {
+				if err := <-db.SetAsync(fmt.Sprintf("g%d/%d", g, i), "x"); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+		wg.Go(func() {
+			if err := db.Flush(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+
+	// Close writes whatever is still queued.
+	var pending []<-chan error
+	for i := range 100 {
+		pending = append(pending, db.SetAsync(fmt.Sprintf("late/%d", i), "y"))
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, done := range pending {
+		if err := <-done; err != nil {
+			t.Fatalf("write queued before Close: %v", err)
+		}
+	}
+	if err := <-db.SetAsync("after", "z"); !errors.Is(err, ErrClosed) {
+		t.Errorf("SetAsync after Close = %v, want ErrClosed", err)
+	}
+	if err := db.Flush(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Flush after Close = %v, want ErrClosed", err)
+	}
+
+	db, err = Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if n := db.Count(); n != 900 {
+		t.Errorf("Count = %d, want 900", n)
+	}
+}
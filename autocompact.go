@@ -0,0 +1,196 @@
+// Background auto-compaction driven by the shape of the file.
+//
+// Config.AutoCompact compacts every N writes, which is easy to reason
+// about but blind to what the writes did: a thousand appends of small
+// documents and a thousand updates of one large document leave very
+// different files. Config.CompactPolicy instead measures the damage that
+// compaction repairs and compacts once any threshold is crossed:
+//
+//   - SparseBytes: the size of the sparse region. Every lookup that misses
+//     the sorted index scans it linearly.
+//   - SparseRecords: the number of records in the sparse region, for the
+//     same reason, when documents are small.
+//   - SparseRatio: the size of the sparse region as a fraction of the
+//     sorted sections before it. Compaction rewrites the whole file, so a
+//     fixed threshold rewrites a large file as often as a small one, and
+//     each byte written is copied again more times the larger the file
+//     grows. Compacting once the young appends reach a fraction r of the
+//     sorted old ones copies each byte 1+1/r times on average, however
+//     large the file grows.
+//   - BlankRatio: the fraction of index lines, sorted or sparse, erased by
+//     updates and deletes. Binary search steps over them, and they are
+//     reclaimed only by compaction.
+//   - SegmentBytes: the size of the unsealed tail of the sparse region.
+//     Crossing it seals the tail into a sorted segment (see segment.go)
+//     instead of compacting. Set with SparseRatio, it lets the ratio be
+//     large, so the file is rewritten rarely, without lookups scanning a
+//     large sparse region.
+//
+// A goroutine started by Open checks the thresholds every Interval. Each
+// check takes the read lock and scans the index and sparse regions only,
+// never the heap, so its cost is bounded by the thresholds themselves.
+// When both a compaction and a seal are due, it compacts.
+// Close stops the goroutine, waiting for a compaction it has started.
+package folio
+
+import (
+	"bufio"
+	"io"
+	"time"
+)
+
+// defaultCompactInterval is used when CompactPolicy.Interval is zero.
+const defaultCompactInterval = time.Minute
+
+// CompactPolicy sets the thresholds for background auto-compaction.
+// Zero fields are disabled; with all of them zero no goroutine runs.
+type CompactPolicy struct {
+	SparseBytes   int64         // compact when the sparse region exceeds this size
+	SparseRecords int           // compact when the sparse region holds more records
+	SparseRatio   float64       // compact when the sparse region exceeds this fraction of the sorted sections
+	BlankRatio    float64       // compact when this fraction of index lines is erased
+	SegmentBytes  int64         // seal when the unsealed tail exceeds this size
+	Interval      time.Duration // how often to check (default 1 minute)
+}
+
+// enabled reports whether any threshold is set.
+func (p CompactPolicy) enabled() bool {
+	return p.SparseBytes > 0 || p.SparseRecords > 0 || p.SparseRatio > 0 || p.BlankRatio > 0 || p.SegmentBytes > 0
+}
+
+// layout is what a policy check measures.
+type layout struct {
+	sparseBytes   int64
+	sparseRecords int
+	unsealedBytes int64 // the sparse region after its last sealed segment
+	sortedBytes   int64 // heap, index, and tag sections
+	indexes       int   // live index lines, sorted and sparse
+	blanked       int   // erased index lines, sorted and sparse
+}
+
+// exceeds reports whether l crosses any threshold of p.
+func (p CompactPolicy) exceeds(l layout) bool {
+	if p.SparseBytes > 0 && l.sparseBytes > p.SparseBytes {
+		return true
+	}
+	if p.SparseRecords > 0 && l.sparseRecords > p.SparseRecords {
+		return true
+	}
+	// A file never compacted has no sorted sections, so its first
+	// sparse record crosses any ratio and starts the first generation.
+	if p.SparseRatio > 0 && l.sparseBytes > 0 && float64(l.sparseBytes) > p.SparseRatio*float64(l.sortedBytes) {
+		return true
+	}
+	if p.BlankRatio > 0 && l.blanked > 0 {
+		return float64(l.blanked)/float64(l.indexes+l.blanked) > p.BlankRatio
+	}
+	return false
+}
+
+// seals reports whether l crosses the seal threshold of p.
+func (p CompactPolicy) seals(l layout) bool {
+	return p.SegmentBytes > 0 && l.unsealedBytes > p.SegmentBytes
+}
+
+// startCompactor launches the background goroutine if the policy has a
+// threshold. Called once by Open.
+func (db *DB) startCompactor() {
+	policy := db.config.CompactPolicy
+	if !policy.enabled() {
+		return
+	}
+	interval := policy.Interval
+	if interval <= 0 {
+		interval = defaultCompactInterval
+	}
+	db.stopCompact = make(chan struct{})
+	db.compactDone = make(chan struct{})
+	go func() {
+		defer close(db.compactDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-db.stopCompact:
+				return
+			case <-ticker.C:
+			}
+			l, err := db.measure()
+			switch {
+			case err != nil:
+			case policy.exceeds(l):
+				db.Compact()
+			case policy.seals(l):
+				db.Seal()
+			}
+		}
+	}()
+}
+
+// stopCompactor stops the background goroutine and waits for it to exit.
+// Called by Close before the state changes, so a compaction in progress
+// finishes against open file handles.
+func (db *DB) stopCompactor() {
+	if db.stopCompact == nil {
+		return
+	}
+	close(db.stopCompact)
+	<-db.compactDone
+	db.stopCompact = nil
+}
+
+// measure scans the index and sparse regions for a policy check.
+func (db *DB) measure() (layout, error) {
+	db.beginScan()
+	defer db.endScan()
+	if err := db.blockRead(); err != nil {
+		return layout{}, err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+
+	var l layout
+	start, sparse, tail := db.indexStart(), db.tagEnd(), db.unsealed()
+	if start == 0 {
+		start = HeaderSize
+	}
+	l.sparseBytes = db.tail - sparse
+	l.unsealedBytes = db.tail - tail
+	l.sortedBytes = sparse - HeaderSize
+
+	scanner := bufio.NewScanner(io.NewSectionReader(db.reader, start, db.tail-start))
+	scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
+	off := start
+	for scanner.Scan() {
+		ln := scanner.Bytes()
+		switch {
+		case valid(ln) && len(ln) >= MinRecordSize:
+			if int(ln[TypePos]-'0') == TypeIndex {
+				l.indexes++
+			}
+			switch {
+			case off < sparse:
+			case int(ln[TypePos]-'0') == TypeMeta:
+				// The metadata record follows a tag section or a
+				// segment and is rewritten, not reclaimed, by compaction.
+				l.sparseBytes -= int64(len(ln)) + 1
+				if off >= tail {
+					l.unsealedBytes -= int64(len(ln)) + 1
+				}
+			default:
+				l.sparseRecords++
+			}
+		case len(ln) > 0 && ln[0] == ' ':
+			// An erased index line. A sparse data record's _d is
+			// blanked too, but its line still starts with '{'.
+			l.blanked++
+		}
+		off += int64(len(ln)) + 1
+	}
+	if err := scanner.Err(); err != nil {
+		return layout{}, err
+	}
+	return l, nil
+}
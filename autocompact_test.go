@@ -0,0 +1,166 @@
+// Background auto-compaction tests.
+//
+// The compactor acts on its own schedule, so the tests check the two
+// things a caller cannot observe directly: that the measurements behind
+// each threshold count what compaction actually reclaims, and that the
+// goroutine fires when a threshold is crossed and stops cleanly at Close.
+package folio
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestMeasure verifies sparse records and erased index lines are counted,
+// and that compaction clears both.
+func TestMeasure(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("a", "1")
+	db.Set("b", "1")
+	db.Set("a", "2")
+	db.Delete("b")
+
+	l, err := db.measure()
+	if err != nil {
+		t.Fatalf("measure: %v", err)
+	}
+	// Three data records, b's tombstone, and one live index; two
+	// indexes erased.
+	if l.sparseRecords != 5 || l.indexes != 1 || l.blanked != 2 {
+		t.Errorf("before Compact = %+v", l)
+	}
+	if l.sparseBytes != db.tail-HeaderSize {
+		t.Errorf("sparseBytes = %d, want %d", l.sparseBytes, db.tail-HeaderSize)
+	}
+
+	db.Compact()
+	l, _ = db.measure()
+	if l.sparseRecords != 0 || l.sparseBytes != 0 || l.blanked != 0 || l.indexes != 1 {
+		t.Errorf("after Compact = %+v", l)
+	}
+	if l.sortedBytes != db.tagEnd()-HeaderSize || l.sortedBytes == 0 {
+		t.Errorf("sortedBytes = %d, want %d", l.sortedBytes, db.tagEnd()-HeaderSize)
+	}
+}
+
+// TestCompactPolicyExceeds verifies each threshold independently, and
+// that an unset threshold never fires.
+func TestCompactPolicyExceeds(t *testing.T) {
+	l := layout{sparseBytes: 1000, sparseRecords: 10, sortedBytes: 4000, indexes: 6, blanked: 4}
+	tests := []struct {
+		policy CompactPolicy
+		want   bool
+	}{
+		{CompactPolicy{}, false},
+		{CompactPolicy{SparseBytes: 999}, true},
+		{CompactPolicy{SparseBytes: 1000}, false},
+		{CompactPolicy{SparseRecords: 9}, true},
+		{CompactPolicy{SparseRecords: 10}, false},
+		{CompactPolicy{SparseRatio: 0.2}, true},
+		{CompactPolicy{SparseRatio: 0.25}, false},
+		{CompactPolicy{BlankRatio: 0.3}, true},
+		{CompactPolicy{BlankRatio: 0.5}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.exceeds(l); got != tt.want {
+			t.Errorf("%+v.exceeds = %v, want %v", tt.policy, got, tt.want)
+		}
+	}
+
+	// A file never compacted starts its first generation at once; an
+	// empty sparse region never crosses a ratio.
+	ratio := CompactPolicy{SparseRatio: 0.5}
+	if !ratio.exceeds(layout{sparseBytes: 10}) {
+		t.Error("ratio did not fire on a file with no sorted sections")
+	}
+	if ratio.exceeds(layout{sortedBytes: 10}) {
+		t.Error("ratio fired on an empty sparse region")
+	}
+}
+
+// TestCompactPolicyBackground verifies the goroutine compacts once the
+// sparse region passes its threshold, and that Close stops it.
+func TestCompactPolicyBackground(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{
+		CompactPolicy: CompactPolicy{SparseRecords: 10, Interval: 5 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		db.Set(fmt.Sprintf("doc-%d", i), "content")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l, err := db.measure()
+		if err != nil {
+			t.Fatalf("measure: %v", err)
+		}
+		if l.sparseRecords == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no compaction: %+v", l)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got, _ := db.Get("doc-3"); got != "content" {
+		t.Errorf("Get after compaction = %q", got)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case <-db.compactDone:
+	default:
+		t.Error("compactor still running after Close")
+	}
+}
+
+// TestCompactPolicySeal verifies that SegmentBytes seals the unsealed
+// tail rather than compacting, and that a sealed tail measures empty.
+func TestCompactPolicySeal(t *testing.T) {
+	policy := CompactPolicy{SegmentBytes: 100}
+	if policy.exceeds(layout{sparseBytes: 1000, unsealedBytes: 1000}) {
+		t.Error("SegmentBytes alone compacted")
+	}
+	if !policy.seals(layout{unsealedBytes: 101}) || policy.seals(layout{unsealedBytes: 100}) {
+		t.Error("SegmentBytes threshold misjudged")
+	}
+
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{
+		CompactPolicy: CompactPolicy{SegmentBytes: 200, Interval: 5 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := range 10 {
+		db.Set(fmt.Sprintf("doc-%d", i), "content")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l, err := db.measure()
+		if err != nil {
+			t.Fatalf("measure: %v", err)
+		}
+		if l.unsealedBytes == 0 {
+			if l.sparseBytes == 0 || l.sortedBytes != 0 {
+				t.Errorf("sealed, but measured %+v", l)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no seal: %+v", l)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got, _ := db.Get("doc-3"); got != "content" {
+		t.Errorf("Get after seal = %q", got)
+	}
+}
@@ -0,0 +1,111 @@
+// Hot backup.
+//
+// Copying the file byte for byte while the database is open can catch a
+// write halfway, or a header whose dirty flag is set. Backup instead runs
+// Phase 1 of Repair against a destination of the caller's choosing: it
+// holds only the read lock, so readers carry on and writers wait no
+// longer than for any other full scan, and writes a compacted file with
+// a clean header from the records current at the time. Phase 2 is a
+// rename of the finished copy into place, so the destination path holds
+// either the previous backup or a complete new one, never a partial one.
+//
+// The copy is what Compact would produce: sorted, with expired documents
+// dropped and HistoryRetention applied, and the layout the source has.
+// Clone is Backup with options: it can leave out the history, as Purge
+// would, or encrypt the copy under a new key, decrypting each record
+// with the database's key and sealing it again as it is written.
+package folio
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Backup writes a consistent, compacted copy of the database to path,
+// replacing any file there. path may not be the database file itself.
+func (db *DB) Backup(path string) (err error) {
+	defer db.observe(OpBackup, "", time.Now(), &err)
+
+	if err := db.writeCopy(path, &CompactOptions{}); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	return nil
+}
+
+// CloneOptions controls a Clone.
+type CloneOptions struct {
+	// PurgeHistory drops every version but the current one from the copy,
+	// as Purge does, and the tombstones with them.
+	PurgeHistory bool
+
+	// EncryptionKey, if set, encrypts every record in the copy under this
+	// key (EncryptionKeySize bytes) in place of the database's own, so the
+	// copy is opened with it as Config.EncryptionKey. Records written
+	// before the database had a key are encrypted too. Every record is
+	// decrypted on the way, so each must be readable with the key the
+	// database was opened with.
+	EncryptionKey []byte
+
+	// Progress, if set, is called as the heap is copied to the new file,
+	// with done and total in bytes of it (see progress.go).
+	Progress func(done, total int64)
+}
+
+// Clone writes a compacted copy of the database to path, as Backup
+// does, with the changes opts asks for. The database stays open for
+// reads throughout, and writers wait as for a Backup.
+func (db *DB) Clone(path string, opts CloneOptions) (err error) {
+	defer db.observe(OpClone, "", time.Now(), &err)
+
+	o := &CompactOptions{PurgeHistory: opts.PurgeHistory, Progress: opts.Progress}
+	if opts.EncryptionKey != nil {
+		if o.key, err = newCipher(opts.EncryptionKey); err != nil {
+			return fmt.Errorf("clone: %w", err)
+		}
+		o.rekey = true
+	}
+	if err := db.writeCopy(path, o); err != nil {
+		return fmt.Errorf("clone: %w", err)
+	}
+	return nil
+}
+
+// writeCopy rebuilds the database into path under the read lock, in the
+// layout the database has.
+func (db *DB) writeCopy(path string, opts *CompactOptions) error {
+	db.beginScan()
+	defer db.endScan()
+	if err := db.blockRead(); err != nil {
+		return err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+
+	if dst, err := os.Stat(path); err == nil {
+		if src, err := db.reader.Stat(); err == nil && os.SameFile(src, dst) {
+			return fmt.Errorf("%s is the database file", path)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	opts.PreserveInsertionOrder = db.header.Flags&flagInsertionOrder != 0
+	if _, err := db.rebuild(tmp, opts, db.header.Algorithm); err != nil {
+		tmp.Close()
+		os.Remove(path + ".tmp")
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+// Hot backup and clone tests.
+//
+// A backup is only worth taking if it opens cleanly and holds the same
+// documents and history as the source, so each test reopens the copy
+// and reads it back rather than inspecting the bytes.
+package folio
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestBackup verifies the copy has a clean header, passes Verify, and
+// carries current content and history but not deleted documents.
+func TestBackup(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "v1")
+	db.Set("doc", "v2")
+	db.Set("gone", "x")
+	db.Delete("gone")
+	db.Set("other", "content")
+
+	path := filepath.Join(t.TempDir(), "backup.folio")
+	if err := db.Backup(path); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temp file left behind: %v", err)
+	}
+
+	// The source is still writable and unchanged.
+	if err := db.Set("doc", "v3"); err != nil {
+		t.Fatalf("Set after Backup: %v", err)
+	}
+
+	bk, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open backup: %v", err)
+	}
+	defer bk.Close()
+	if bk.header.Error != 0 {
+		t.Error("backup header is dirty")
+	}
+	if r := mustVerify(t, bk, VerifyOptions{}); !r.OK() {
+		t.Errorf("Verify backup: %v", r.Problems)
+	}
+	if got, _ := bk.Get("doc"); got != "v2" {
+		t.Errorf("Get doc = %q, want v2", got)
+	}
+	if _, err := bk.Get("gone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get gone: got %v, want ErrNotFound", err)
+	}
+	if bk.Count() != 2 {
+		t.Errorf("Count = %d, want 2", bk.Count())
+	}
+	versions, err := Collect(bk.History("doc"))
+	if err != nil || len(versions) != 2 {
+		t.Errorf("History doc: %d versions, %v", len(versions), err)
+	}
+}
+
+// TestBackupOwnFile verifies Backup refuses to overwrite the database
+// it is copying, which would replace the live file with a copy of itself
+// behind the open handles.
+func TestBackupOwnFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.folio")
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Set("doc", "content")
+
+	if err := db.Backup(path); err == nil {
+		t.Error("Backup onto the database file succeeded")
+	}
+	if got, _ := db.Get("doc"); got != "content" {
+		t.Errorf("Get after refused Backup = %q", got)
+	}
+}
+
+// TestClonePurge verifies a Clone with PurgeHistory keeps every current
+// document and none of the older versions.
+func TestClonePurge(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "v1")
+	db.Set("doc", "v2")
+	db.Set("other", "content")
+
+	path := filepath.Join(t.TempDir(), "clone.folio")
+	if err := db.Clone(path, CloneOptions{PurgeHistory: true}); err != nil {
+		t.Fatalf("Clone: %v", err)
+	}
+	cl, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open clone: %v", err)
+	}
+	defer cl.Close()
+	if got, _ := cl.Get("doc"); got != "v2" || cl.Count() != 2 {
+		t.Errorf("Get doc = %q, Count = %d, want v2 and 2", got, cl.Count())
+	}
+	if versions, _ := Collect(cl.History("doc")); len(versions) != 1 {
+		t.Errorf("History doc = %d versions, want 1", len(versions))
+	}
+	if versions, _ := Collect(db.History("doc")); len(versions) != 2 {
+		t.Errorf("source History doc = %d versions, want 2", len(versions))
+	}
+}
+
+// TestCloneRekey verifies a Clone under a new key holds every version,
+// deltas and records written before the source had a key included,
+// sealed under that key alone: the copy reads back with it, fails with
+// the old one, and has no content in plain text.
+func TestCloneRekey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Set("plain", "written before the key")
+	db.Close()
+
+	db, err = Open(path, Config{EncryptionKey: testKey, DeltaHistory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	body := strings.Repeat("line of the secret note\n", 20)
+	db.Set("doc", body+"one")
+	db.Set("doc", body+"two")
+	db.Set("doc", body+"three")
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+
+	newKey := bytes.Repeat([]byte{0x17}, EncryptionKeySize)
+	clonePath := filepath.Join(t.TempDir(), "clone.folio")
+	if err := db.Clone(clonePath, CloneOptions{EncryptionKey: newKey}); err != nil {
+		t.Fatalf("Clone: %v", err)
+	}
+	raw, _ := os.ReadFile(clonePath)
+	if bytes.Contains(raw, []byte("secret")) || bytes.Contains(raw, []byte("before the key")) {
+		t.Error("clone holds content in plain text")
+	}
+
+	cl, err := Open(clonePath, Config{EncryptionKey: newKey})
+	if err != nil {
+		t.Fatalf("Open clone: %v", err)
+	}
+	defer cl.Close()
+	mustVerify(t, cl, VerifyOptions{})
+	if got, _ := cl.Get("plain"); got != "written before the key" {
+		t.Errorf("Get plain = %q", got)
+	}
+	versions, err := Collect(cl.History("doc"))
+	if err != nil || len(versions) != 3 || versions[0].Data != body+"one" {
+		t.Errorf("History doc = %d versions, %v", len(versions), err)
+	}
+
+	old, err := Open(clonePath, Config{EncryptionKey: testKey, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Open clone with the old key: %v", err)
+	}
+	defer old.Close()
+	if _, err := old.Get("doc"); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Get with the old key = %v, want ErrDecrypt", err)
+	}
+
+	if err := db.Clone(clonePath, CloneOptions{EncryptionKey: []byte("short")}); err == nil {
+		t.Error("Clone with a short key succeeded")
+	}
+}
@@ -23,6 +23,7 @@
 package folio
 
 import (
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -35,11 +36,13 @@ func BenchmarkSet(b *testing.B) {
 	defer db.Close()
 
 	content := strings.Repeat("x", 1024) // 1KB
+	w := countWrites(db)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		db.Set("doc"+strconv.Itoa(i), content)
 	}
+	w.report(b)
 }
 
 func BenchmarkSetSameKey(b *testing.B) {
@@ -48,11 +51,27 @@ func BenchmarkSetSameKey(b *testing.B) {
 	defer db.Close()
 
 	content := strings.Repeat("x", 1024)
+	db.Set("doc", content)
+	w := countWrites(db)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		db.Set("doc", content)
 	}
+	w.report(b)
+}
+
+// countWrites counts the writes and syncs db makes from here on.
+func countWrites(db *DB) *countingWriter {
+	w := &countingWriter{File: db.writer.(*os.File)}
+	db.writer = w
+	return w
+}
+
+// report reports the writes and syncs counted per operation.
+func (c *countingWriter) report(b *testing.B) {
+	b.ReportMetric(float64(c.writes)/float64(b.N), "writes/op")
+	b.ReportMetric(float64(c.syncs)/float64(b.N), "syncs/op")
 }
 
 func BenchmarkGetSparse(b *testing.B) {
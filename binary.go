@@ -0,0 +1,48 @@
+// Binary document content.
+//
+// JSON strings must be valid UTF-8, so content that is not would be
+// mangled by the encoder (invalid bytes become U+FFFD). Such content is
+// stored base64-encoded in _d and flagged with _b. Valid UTF-8 is stored
+// as plain text whichever API wrote it, so it stays grep-searchable.
+//
+// The encoding is invisible to callers: Get, GetBytes, All, and History
+// all return the original bytes. The _h snapshot and the _k checksum are
+// computed over the original bytes, never the base64 form. Search skips
+// binary records, since matching a pattern against base64 is meaningless.
+package folio
+
+import (
+	"bytes"
+	"encoding/base64"
+)
+
+// SetBytes creates or updates a document with arbitrary content.
+func (db *DB) SetBytes(label string, data []byte) error {
+	return db.Set(label, string(data))
+}
+
+// GetBytes returns the current content of a document as bytes.
+func (db *DB) GetBytes(label string) ([]byte, error) {
+	data, err := db.Get(label)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
+// binary reports whether a record line holds base64-encoded content.
+// The marker cannot occur inside a string value because every quote
+// there is escaped.
+func binary(line []byte) bool {
+	return bytes.LastIndex(line, []byte(`"_b":true`)) >= 0
+}
+
+// unbase64 decodes the _d value of a binary record.
+func unbase64(data []byte) ([]byte, error) {
+	out := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n, err := base64.StdEncoding.Decode(out, data)
+	if err != nil {
+		return nil, ErrCorruptRecord
+	}
+	return out[:n], nil
+}
@@ -0,0 +1,77 @@
+// Binary content tests.
+//
+// Content that is not valid UTF-8 cannot pass through a JSON string
+// unchanged, so it is stored base64-encoded. These tests verify every
+// read path returns the original bytes, that text stays plain text on
+// disk, and that binary records stay out of Search results.
+package folio
+
+import (
+	"bytes"
+	"testing"
+)
+
+var blob = []byte{0x00, 0xff, 0xfe, '"', '\\', 0x80, '\n', 0x01}
+
+// TestSetBytesRoundTrip verifies binary content survives Get, GetBytes,
+// All, and History unchanged, before and after compaction. Without the
+// base64 encoding the invalid bytes would come back as U+FFFD.
+func TestSetBytesRoundTrip(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := db.SetBytes("bin", blob); err != nil {
+		t.Fatalf("SetBytes: %v", err)
+	}
+	db.Set("bin", "text")
+	db.SetBytes("bin", blob)
+
+	check := func(stage string) {
+		got, err := db.GetBytes("bin")
+		if err != nil || !bytes.Equal(got, blob) {
+			t.Errorf("%s: GetBytes = %v, %v", stage, got, err)
+		}
+		docs, err := Collect(db.All())
+		if err != nil || len(docs) != 1 || docs[0].Data != string(blob) {
+			t.Errorf("%s: All = %v, %v", stage, docs, err)
+		}
+		versions, err := Collect(db.History("bin"))
+		if err != nil || len(versions) != 3 || versions[0].Data != string(blob) {
+			t.Errorf("%s: History = %v, %v", stage, versions, err)
+		}
+	}
+	check("sparse")
+	db.Compact()
+	check("compacted")
+}
+
+// TestSetBytesTextStaysPlain verifies valid UTF-8 written through
+// SetBytes is stored as ordinary text, so grep and Search still see it.
+func TestSetBytesTextStaysPlain(t *testing.T) {
+	db := openTestDB(t)
+	db.SetBytes("doc", []byte("hello world"))
+
+	data, _ := line(db.reader, HeaderSize)
+	if binary(data) || !bytes.Contains(data, []byte(`"_d":"hello world"`)) {
+		t.Errorf("record = %s, want plain text", data)
+	}
+	matches, _ := Collect(db.Search("hello", SearchOptions{}))
+	if len(matches) != 1 {
+		t.Errorf("Search matches = %d, want 1", len(matches))
+	}
+}
+
+// TestSearchSkipsBinary verifies a pattern that happens to appear in the
+// base64 form of binary content does not match it.
+func TestSearchSkipsBinary(t *testing.T) {
+	db := openTestDB(t)
+	db.SetBytes("bin", blob)
+
+	data, _ := line(db.reader, HeaderSize)
+	i := bytes.Index(data, []byte(`"_d":"`)) + len(`"_d":"`)
+	needle := string(data[i : i+4])
+
+	matches, _ := Collect(db.Search(needle, SearchOptions{CaseSensitive: true}))
+	if len(matches) != 0 {
+		t.Errorf("Search(%q) matched binary record", needle)
+	}
+}
@@ -4,13 +4,39 @@
 // exist there pay the full scan cost. When enabled (Config.BloomFilter),
 // the filter is populated from sparse index IDs at Open and updated on
 // each Set. A negative Contains result skips the sparse scan entirely.
+//
 // The filter is deliberately small (~12KB) — sized for ~10k entries at
 // a 1% false positive rate — because false positives only add a linear
 // scan that would have happened anyway without the filter.
+//
+// A lookup that misses the sparse region has first binary searched the
+// sorted index, a seek and a line read per step. Config.IndexBloom adds a
+// second filter over the sorted index's IDs, so a lookup for an absent
+// ID can skip that too. The sorted index only changes in a rebuild, so
+// the filter is built at Open and after each one, sized to the number of
+// IDs at the same rate as the sparse filter; the lines there are never
+// given a new ID in place.
+//
+// Close saves the filters beside the file as name.bloom, with the
+// generation, the offset the sparse filter covers, and a hash of the
+// bytes just before that offset. The next Open takes up the sorted
+// filter if the generation matches, and the sparse one if the file
+// still ends at the offset as it did, scanning only what was appended
+// after it: the index lines, and the rename tombstones, since a
+// same-length rename patches an ID into a line the filter may already
+// have passed. A file from before tombstones is always scanned. The
+// sidecar only speeds up Open, so it is safe to delete.
 package folio
 
 import (
+	"fmt"
 	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+
+	json "github.com/goccy/go-json"
+	"github.com/zeebo/xxh3"
 )
 
 const (
@@ -26,14 +52,20 @@ func newBloom() *bloom {
 	return &bloom{bits: make([]byte, BloomSize)}
 }
 
+// sizedBloom returns a filter with room for n IDs at the rate BloomSize
+// gives 10,000, and no smaller than 64 bytes.
+func sizedBloom(n int) *bloom {
+	return &bloom{bits: make([]byte, max(n*BloomSize/10000, 64))}
+}
+
 func (b *bloom) Add(id string) {
-	for _, pos := range positions(id) {
+	for _, pos := range positions(id, uint(len(b.bits)*8)) {
 		b.bits[pos/8] |= 1 << (pos % 8)
 	}
 }
 
 func (b *bloom) Contains(id string) bool {
-	for _, pos := range positions(id) {
+	for _, pos := range positions(id, uint(len(b.bits)*8)) {
 		if b.bits[pos/8]&(1<<(pos%8)) == 0 {
 			return false
 		}
@@ -41,13 +73,25 @@ func (b *bloom) Contains(id string) bool {
 	return true
 }
 
+// FalsePositive estimates the current false positive rate from the
+// fraction of bits set: a lookup for an absent ID passes only if all
+// BloomK of its bits happen to be set.
+func (b *bloom) FalsePositive() float64 {
+	set := 0
+	for _, c := range b.bits {
+		set += bits.OnesCount8(c)
+	}
+	return math.Pow(float64(set)/float64(len(b.bits)*8), BloomK)
+}
+
 // Reset clears all bits. Called after compaction because the sparse region
 // is empty in the new file and the filter must be rebuilt from new appends.
 func (b *bloom) Reset() {
 	clear(b.bits)
 }
 
-// positions derives BloomK bit indices using double hashing: h(i) = h1 + i*h2.
+// positions derives BloomK bit indices of nbits using double hashing:
+// h(i) = h1 + i*h2.
 // Two independent hashes (FNV-64a, FNV-32a) simulate k independent functions.
 //
 // FNV is used here (not xxHash3, which is already a dependency) because the
@@ -55,7 +99,7 @@ func (b *bloom) Reset() {
 // same algorithm generated both IDs and bloom positions, correlated
 // collisions would produce correlated false positives, degrading the
 // filter's effectiveness. FNV provides that independence and is stdlib-only.
-func positions(id string) [BloomK]uint {
+func positions(id string, nbits uint) [BloomK]uint {
 	h64 := fnv.New64a()
 	h64.Write([]byte(id))
 	a := h64.Sum64()
@@ -64,10 +108,175 @@ func positions(id string) [BloomK]uint {
 	h32.Write([]byte(id))
 	b := uint(h32.Sum32())
 
-	nbits := uint(BloomSize * 8)
 	var pos [BloomK]uint
 	for i := range BloomK {
 		pos[i] = (uint(a) + uint(i)*b) % nbits
 	}
 	return pos
 }
+
+// bloomExt is appended to the database's name for the saved filters.
+const bloomExt = ".bloom"
+
+// bloomTail is how many bytes before the end a saved filter covers are
+// hashed to tell whether the file still ends there as it did.
+const bloomTail = 256
+
+// savedBloom is the sidecar Close writes so the next Open need not scan
+// the file to rebuild the filters. Bits covers the sparse index IDs of
+// [sparse start, End) in the file of generation Gen, whose bytes just
+// before End hashed to Tail; Index covers the sorted index section,
+// which a generation never changes.
+type savedBloom struct {
+	Gen   uint64 `json:"g"`
+	End   int64  `json:"end"`
+	Tail  uint64 `json:"tail"`
+	Bits  []byte `json:"bits,omitempty"`
+	Index []byte `json:"index,omitempty"`
+}
+
+// loadBloom fills the filters Config asks for at Open: from the sidecar
+// where it still describes the file, adding only the sparse index lines
+// appended since it was saved, and otherwise by scanning. A sidecar from
+// another generation is ignored, and its sparse filter also if the file
+// has since been cut short.
+func (db *DB) loadBloom(size int64) {
+	saved, ok := db.savedBloom()
+	if db.config.IndexBloom {
+		if ok && len(saved.Index) > 0 {
+			db.ibloom = &bloom{bits: saved.Index}
+		} else {
+			db.ibloom = db.indexBloom()
+		}
+	}
+	if !db.config.BloomFilter {
+		return
+	}
+
+	db.bloom = newBloom()
+	start := db.sparseStart()
+	ok = ok && db.covers(saved, size)
+	if ok {
+		copy(db.bloom.bits, saved.Bits)
+		start = saved.End
+	}
+	for _, e := range scanm(db.reader, start, size, 0) {
+		switch e.Type {
+		case TypeIndex:
+			db.bloom.Add(e.ID)
+		case TypeTombstone:
+			// A same-length rename patches its index in place, before
+			// the saved end perhaps; its tombstone, appended after,
+			// names the ID it took.
+			if ok {
+				db.bloomRenamed(e.SrcOff)
+			}
+		}
+	}
+}
+
+// indexBloom builds a filter over the sorted index section, sized to
+// the IDs in it.
+func (db *DB) indexBloom() *bloom {
+	entries := scanm(db.reader, db.indexStart(), db.indexEnd(), TypeIndex)
+	b := sizedBloom(len(entries))
+	for _, e := range entries {
+		b.Add(e.ID)
+	}
+	return b
+}
+
+// bloomRenamed adds the new label's ID of the rename tombstone at off.
+func (db *DB) bloomRenamed(off int64) {
+	data, err := line(db.reader, off)
+	if err != nil {
+		return
+	}
+	var t tombstone
+	if json.Unmarshal(data, &t) == nil && t.To != "" {
+		db.bloom.Add(db.id(t.To))
+	}
+}
+
+// savedBloom reads the sidecar and reports whether it was saved for this
+// generation of the file.
+func (db *DB) savedBloom() (savedBloom, bool) {
+	var saved savedBloom
+	if db.root == nil {
+		return saved, false
+	}
+	f, err := db.root.Open(db.name + bloomExt)
+	if err != nil {
+		return saved, false
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil || json.Unmarshal(data, &saved) != nil {
+		return saved, false
+	}
+	// Before tombstones, a library that writes renames without them
+	// could have patched an ID in place.
+	if db.header.Version < tombstoneVersion || saved.Gen != db.header.Generation {
+		return saved, false
+	}
+	return saved, true
+}
+
+// covers reports whether saved's sparse filter covers a prefix of the
+// file as it is now, size bytes long.
+func (db *DB) covers(saved savedBloom, size int64) bool {
+	if len(saved.Bits) != BloomSize || saved.End < db.sparseStart() || saved.End > size {
+		return false
+	}
+	tail, err := db.tailHash(saved.End)
+	return err == nil && tail == saved.Tail
+}
+
+// saveBloom writes the sidecar for the file as it ends now. It is
+// written to a temporary name and renamed over the old one, so a reader
+// sees one or the other whole. The caller must hold db.mu.
+func (db *DB) saveBloom() error {
+	tail, err := db.tailHash(db.tail)
+	if err != nil {
+		return fmt.Errorf("bloom: %w", err)
+	}
+	saved := savedBloom{Gen: db.header.Generation, End: db.tail, Tail: tail}
+	if db.bloom != nil {
+		saved.Bits = db.bloom.bits
+	}
+	if db.ibloom != nil {
+		saved.Index = db.ibloom.bits
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return fmt.Errorf("bloom: %w", err)
+	}
+	name := db.name + bloomExt
+	f, err := db.root.Create(name + ".tmp")
+	if err != nil {
+		return fmt.Errorf("bloom: %w", err)
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = db.root.Rename(name+".tmp", name)
+	}
+	if err != nil {
+		db.root.Remove(name + ".tmp")
+		return fmt.Errorf("bloom: %w", err)
+	}
+	return nil
+}
+
+// tailHash hashes the bloomTail bytes of the file before end, or as
+// many as follow the header.
+func (db *DB) tailHash(end int64) (uint64, error) {
+	start := max(end-bloomTail, HeaderSize)
+	buf := make([]byte, end-start)
+	if _, err := db.reader.ReadAt(buf, start); err != nil && err != io.EOF {
+		return 0, err
+	}
+	return xxh3.Hash(buf), nil
+}
@@ -15,6 +15,8 @@
 package folio
 
 import (
+	"errors"
+	"os"
 	"path/filepath"
 	"strconv"
 	"testing"
@@ -99,7 +101,7 @@ func TestGetBloomSkipsSparse(t *testing.T) {
 	db.Set("doc1", "content1")
 
 	_, err = db.Get("nonexistent")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Get miss: got %v, want ErrNotFound", err)
 	}
 
@@ -212,7 +214,183 @@ func TestBloomDisabled(t *testing.T) {
 	}
 
 	_, err = db.Get("nonexistent")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Get miss: got %v, want ErrNotFound", err)
 	}
 }
+
+// openBloom opens path with the bloom filter, closing it at cleanup.
+func openBloom(t *testing.T, path string) *DB {
+	t.Helper()
+	db, err := Open(path, Config{BloomFilter: true})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestBloomSaved verifies that Close saves the filter and Open takes it
+// up instead of rebuilding it. A bit set only in the saved filter, for
+// an ID no record has, survives the reopen only if the filter was loaded.
+func TestBloomSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{BloomFilter: true})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	db.Set("doc1", "content1")
+	db.bloom.Add("marker")
+	db.Close()
+
+	db = openBloom(t, path)
+	if !db.bloom.Contains("marker") {
+		t.Error("Open rebuilt the filter rather than loading it")
+	}
+	if got, _ := db.Get("doc1"); got != "content1" {
+		t.Errorf("Get = %q, want content1", got)
+	}
+}
+
+// TestBloomSavedAppended verifies that writes made after the filter was
+// saved, by a handle without one, are added at Open, including a
+// same-length rename that patched an ID the saved filter had passed.
+// Missing either would be a false negative: Get reporting ErrNotFound
+// for a document that exists.
+func TestBloomSavedAppended(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{BloomFilter: true})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	db.Set("old1", "a")
+	db.Close()
+
+	plain, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	plain.Set("doc2", "b")
+	if err := plain.Rename("old1", "new1"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	plain.Close()
+
+	db = openBloom(t, path)
+	for label, want := range map[string]string{"doc2": "b", "new1": "a"} {
+		if got, err := db.Get(label); got != want {
+			t.Errorf("Get(%s) = %q, %v; want %q", label, got, err, want)
+		}
+	}
+}
+
+// TestBloomSavedStale verifies that a saved filter is ignored once the
+// file has been rebuilt, or cut short, since it was saved.
+func TestBloomSavedStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{BloomFilter: true})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	db.Set("doc1", "content1")
+	db.bloom.Add("marker")
+	db.Close()
+
+	plain, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	plain.Compact()
+	plain.Close()
+	db = openBloom(t, path)
+	if db.bloom.Contains("marker") {
+		t.Error("Open loaded a filter saved before a rebuild")
+	}
+
+	db.bloom.Add("marker")
+	db.Set("doc2", "content2")
+	db.Close()
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-1)
+	db = openBloom(t, path)
+	if db.bloom.Contains("marker") {
+		t.Error("Open loaded a filter saved for a longer file")
+	}
+}
+
+// TestIndexBloom verifies the filter over the sorted index: every
+// compacted document is still found, an absent one is not, and the
+// filter is rebuilt to cover what a compaction moves into the index.
+func TestIndexBloom(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{IndexBloom: true})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	for i := range 100 {
+		db.Set("doc"+strconv.Itoa(i), "v")
+	}
+	db.Compact()
+	db.Set("late", "v")
+	db.Compact()
+
+	for i := range 100 {
+		if _, err := db.Get("doc" + strconv.Itoa(i)); err != nil {
+			t.Fatalf("Get(doc%d): %v", i, err)
+		}
+	}
+	if ok, _ := db.Exists("late"); !ok {
+		t.Error("Exists(late) = false after the second compaction")
+	}
+	if _, err := db.Get("absent"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(absent) = %v, want ErrNotFound", err)
+	}
+	if fp := db.Stats().IndexBloomFalsePositive; fp <= 0 || fp > 0.02 {
+		t.Errorf("IndexBloomFalsePositive = %v, want in (0, 0.02]", fp)
+	}
+}
+
+// TestIndexBloomSaved verifies that Open takes the sorted filter from
+// the sidecar while the generation matches, and rebuilds it after a
+// compaction by another handle.
+func TestIndexBloomSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	open := func() *DB {
+		db, err := Open(path, Config{IndexBloom: true})
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		return db
+	}
+	db := open()
+	db.Set("doc", "v")
+	db.Compact()
+	db.ibloom.Add("marker")
+	db.Close()
+
+	db = open()
+	if !db.ibloom.Contains("marker") {
+		t.Error("Open rebuilt the sorted filter rather than loading it")
+	}
+	if _, err := db.Get("doc"); err != nil {
+		t.Errorf("Get: %v", err)
+	}
+	db.Close()
+
+	plain, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	plain.Set("other", "v")
+	plain.Compact()
+	plain.Close()
+
+	db = open()
+	defer db.Close()
+	if db.ibloom.Contains("marker") {
+		t.Error("Open loaded a sorted filter saved before a rebuild")
+	}
+	if _, err := db.Get("other"); err != nil {
+		t.Errorf("Get(other): %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+// Optional cache of sorted index probes.
+//
+// A lookup in the sorted index section is a binary search whose every
+// step aligns to a line boundary and reads the line there as its pivot;
+// the step that matches then decodes the line. The search over a given
+// section always takes the same steps, so the pivots near the top of
+// the search are read for every lookup, and a hot document's own line
+// for every lookup of it. When enabled (Config.CacheBytes), each step's
+// pivot is kept in a least-recently-used cache keyed by the range the
+// step searches, together with its decoded index once a lookup has
+// matched it, up to the configured number of bytes.
+//
+// Index lines are patched in place: retirement blanks them and Rename
+// rewrites their ID and label. writeAt drops the cached pivots on any
+// line it touches, and compaction and Rehash, which write a new index
+// section, empty it. Patches made by another
+// process are not seen, so the cache is for a handle that is the file's
+// only writer.
+package folio
+
+import (
+	"bytes"
+	"container/list"
+	"slices"
+	"sync"
+)
+
+// cacheOverhead approximates the bytes an entry costs beyond its line.
+const cacheOverhead = 128
+
+// bounds is the range [start, end) one binary search step covers.
+type bounds struct{ start, end int64 }
+
+// probe is a cached binary search step: its pivot, the offset just past
+// the pivot's line, and the pivot decoded once it has been matched.
+// pivot is nil if the range held no valid index.
+type probe struct {
+	bounds bounds
+	pivot  *Result
+	next   int64
+	idx    *Index
+}
+
+// cache is an LRU of probes, bounded by the bytes they hold.
+type cache struct {
+	mu     sync.Mutex
+	limit  int
+	used   int
+	lru    *list.List                // front is most recent; values are *probe
+	spans  map[bounds]*list.Element  // probe by search range
+	pivots map[int64][]*list.Element // probes by pivot line offset
+}
+
+func newCache(limit int) *cache {
+	return &cache{
+		limit:  limit,
+		lru:    list.New(),
+		spans:  map[bounds]*list.Element{},
+		pivots: map[int64][]*list.Element{},
+	}
+}
+
+func (p *probe) cost() int {
+	if p.pivot == nil {
+		return cacheOverhead
+	}
+	n := cacheOverhead + len(p.pivot.Data)
+	if p.idx != nil {
+		n += cacheOverhead + len(p.idx.Label)
+	}
+	return n
+}
+
+// get returns a copy of the probe for s, marking it most recently used.
+func (c *cache) get(s bounds) (probe, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.spans[s]
+	if !ok {
+		return probe{}, false
+	}
+	c.lru.MoveToFront(e)
+	return *e.Value.(*probe), true
+}
+
+// put stores p, evicting the least recently used probes over the limit.
+func (c *cache) put(p *probe) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.spans[p.bounds]; ok {
+		c.remove(e)
+	}
+	e := c.lru.PushFront(p)
+	c.spans[p.bounds] = e
+	if p.pivot != nil {
+		c.pivots[p.pivot.Offset] = append(c.pivots[p.pivot.Offset], e)
+	}
+	c.used += p.cost()
+	c.trim()
+}
+
+// decoded records idx as the decoded pivot of the probe for s.
+func (c *cache) decoded(s bounds, idx *Index) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.spans[s]; ok {
+		p := e.Value.(*probe)
+		if p.idx == nil {
+			p.idx = idx
+			c.used += cacheOverhead + len(idx.Label)
+			c.trim()
+		}
+	}
+}
+
+// trim evicts the least recently used probes until the cache is within
+// its limit. The caller holds c.mu.
+func (c *cache) trim() {
+	for c.used > c.limit && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// drop removes every probe whose pivot is the line at off.
+func (c *cache) drop(off int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range slices.Clone(c.pivots[off]) {
+		c.remove(e)
+	}
+}
+
+// reset empties the cache.
+func (c *cache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	clear(c.spans)
+	clear(c.pivots)
+	c.used = 0
+}
+
+// remove unlinks e. The caller holds c.mu.
+func (c *cache) remove(e *list.Element) {
+	p := e.Value.(*probe)
+	c.lru.Remove(e)
+	delete(c.spans, p.bounds)
+	c.used -= p.cost()
+	if p.pivot != nil {
+		others := c.pivots[p.pivot.Offset]
+		for i, o := range others {
+			if o == e {
+				others = append(others[:i], others[i+1:]...)
+				break
+			}
+		}
+		if len(others) == 0 {
+			delete(c.pivots, p.pivot.Offset)
+		} else {
+			c.pivots[p.pivot.Offset] = others
+		}
+	}
+}
+
+// sorted looks id up in the sorted index section, as scan does, and
+// decodes the match. It returns a nil Result if there is none. With the
+// cache enabled, each step is served from it where possible, and with
+// Config.IndexBloom an ID the filter rules out is not searched for. A
+// hash table over the section is tried before either. The caller must
+// hold db.mu.
+func (db *DB) sorted(id string) (*Result, *Index, error) {
+	if db.ibloom != nil && !db.ibloom.Contains(id) {
+		return nil, nil, nil
+	}
+	if db.cache == nil {
+		result := db.scanIndex(id)
+		if result == nil {
+			return nil, nil, nil
+		}
+		idx, err := decodeIndex(result.Data)
+		if err != nil {
+			return nil, nil, faultAt("", result.Offset, err)
+		}
+		return result, idx, nil
+	}
+
+	if db.table != nil {
+		if result := db.lookup(id); result != nil {
+			idx, err := decodeIndex(result.Data)
+			if err != nil {
+				return nil, nil, faultAt("", result.Offset, err)
+			}
+			return result, idx, nil
+		}
+	}
+	s := bounds{db.indexStart(), db.indexEnd()}
+	for s.start < s.end {
+		p, ok := db.cache.get(s)
+		if !ok {
+			pv, next := db.indexPivot(s.start, s.end)
+			p = probe{bounds: s, pivot: pv, next: next}
+			db.cache.put(&p)
+		}
+		switch {
+		case p.pivot == nil:
+			return nil, nil, nil
+		case id < p.pivot.ID:
+			s.end = p.pivot.Offset
+		case id > p.pivot.ID:
+			s.start = p.next
+		default:
+			idx := p.idx
+			if idx == nil {
+				var err error
+				if idx, err = decodeIndex(p.pivot.Data); err != nil {
+					return nil, nil, faultAt("", p.pivot.Offset, err)
+				}
+				db.cache.decoded(s, idx)
+			}
+			// Callers may keep what they are given; the cache keeps its own.
+			r, i := *p.pivot, *idx
+			return &r, &i, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// uncache drops the cached probes on every index line a write of data
+// at off touches. Called by writeAt before the write.
+func (db *DB) uncache(off int64, data []byte) {
+	if db.cache == nil || off >= db.indexEnd() || off+int64(len(data)) <= db.indexStart() {
+		return
+	}
+	db.cache.drop(lineStart(db.reader, off, db.indexStart()))
+	for i, b := range data {
+		if b == '\n' {
+			db.cache.drop(off + int64(i) + 1)
+		}
+	}
+}
+
+// lineStart returns the offset of the line containing off, reading
+// backwards no further than floor.
+func lineStart(f storage, off, floor int64) int64 {
+	var buf [256]byte
+	end := off
+	for end > floor {
+		n := min(int64(len(buf)), end-floor)
+		if _, err := f.ReadAt(buf[:n], end-n); err != nil {
+			return floor
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return end - n + int64(i) + 1
+		}
+		end -= n
+	}
+	return floor
+}
@@ -0,0 +1,120 @@
+// Sorted index cache tests.
+//
+// The cache serves binary search steps over the sorted index from
+// memory, so it is only correct if every in-place patch to an index line
+// drops the steps that read it. Each test compacts first so lookups go
+// through the sorted section, then patches it the ways writes do.
+package folio
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// openCached opens a database with the index cache and n compacted
+// documents, doc0 to doc(n-1), each holding "v0".
+func openCached(t *testing.T, n, cacheBytes int) *DB {
+	t.Helper()
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{CacheBytes: cacheBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for i := range n {
+		if err := db.Set(fmt.Sprintf("doc%d", i), "v0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// TestCacheServesLookups verifies that repeated lookups fill the cache
+// and keep returning the right documents from it.
+func TestCacheServesLookups(t *testing.T) {
+	db := openCached(t, 50, 1<<20)
+
+	for range 2 {
+		for i := range 50 {
+			if got, err := db.Get(fmt.Sprintf("doc%d", i)); err != nil || got != "v0" {
+				t.Fatalf("Get(doc%d) = %q, %v", i, got, err)
+			}
+		}
+	}
+	if db.cache.lru.Len() == 0 {
+		t.Fatal("cache empty after lookups")
+	}
+	if _, err := db.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(missing) err = %v, want ErrNotFound", err)
+	}
+}
+
+// TestCacheInvalidation verifies that updates, deletes, and same-length
+// renames, which all patch sorted index lines in place, are seen by the
+// next lookup even though the old lines were cached.
+func TestCacheInvalidation(t *testing.T) {
+	db := openCached(t, 20, 1<<20)
+	for i := range 20 {
+		if _, err := db.Get(fmt.Sprintf("doc%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Set("doc3", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("doc5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Rename("doc7", "docX"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := db.Get("doc3"); err != nil || got != "v1" {
+		t.Errorf("Get(doc3) = %q, %v; want v1", got, err)
+	}
+	for _, lbl := range []string{"doc5", "doc7"} {
+		if ok, err := db.Exists(lbl); ok || err != nil {
+			t.Errorf("Exists(%s) = %v, %v; want false", lbl, ok, err)
+		}
+	}
+	for _, i := range []int{0, 10, 19} {
+		if got, err := db.Get(fmt.Sprintf("doc%d", i)); err != nil || got != "v0" {
+			t.Errorf("Get(doc%d) = %q, %v", i, got, err)
+		}
+	}
+}
+
+// TestCacheBound verifies that the cache evicts to stay within
+// CacheBytes, and that lookups stay correct while it does.
+func TestCacheBound(t *testing.T) {
+	const limit = 2048
+	db := openCached(t, 200, limit)
+	for i := range 200 {
+		if got, err := db.Get(fmt.Sprintf("doc%d", i)); err != nil || got != "v0" {
+			t.Fatalf("Get(doc%d) = %q, %v", i, got, err)
+		}
+	}
+	if db.cache.used > limit {
+		t.Errorf("cache holds %d bytes, limit %d", db.cache.used, limit)
+	}
+}
+
+// TestCacheRehash verifies that Rehash, which rewrites every ID the
+// cached steps compared against, empties the cache.
+func TestCacheRehash(t *testing.T) {
+	db := openCached(t, 20, 1<<20)
+	if _, err := db.Get("doc4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Rehash(AlgFNV1a); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.cache.lru.Len(); n != 0 {
+		t.Errorf("cache holds %d probes after Rehash, want 0", n)
+	}
+}
@@ -0,0 +1,279 @@
+// Interactive, read-only browser.
+//
+// browse opens the file with Config.ReadOnly. On a terminal it runs the
+// full-screen browser (see tui.go). Otherwise, when its input is a pipe
+// or a script, it reads commands from stdin one per line. Every command
+// is an ordinary library call, so each takes the shared lock only for
+// its own duration and a writer in another process is never blocked for
+// longer than a single lookup.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jpl-au/folio"
+)
+
+const browseHelp = `commands:
+  ls [filter]             list labels, optionally containing filter
+  cat <label>             print current content
+  history <label>         list versions with timestamps and sizes
+  show <label> <n>        print version n (1 = oldest)
+  diff <label> [a b]      diff versions a and b (default: last two)
+  help                    show this help
+  quit                    exit`
+
+func runBrowse(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: folio browse <file>")
+	}
+	db, err := folio.Open(args[0], folio.Config{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	in, isFile := stdin.(*os.File)
+	out, isTerm := stdout.(*os.File)
+	if isFile && isTerm {
+		if restore, err := makeRaw(in.Fd(), out.Fd()); err == nil {
+			defer restore()
+			// The alternate screen keeps the shell's scrollback as it was.
+			fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+			defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+			return runTUI(db, in, out, func() (int, int) {
+				if w, h, err := termSize(out.Fd()); err == nil && w > 0 && h > 0 {
+					return w, h
+				}
+				return 80, 24
+			})
+		}
+	}
+	return browse(db, stdin, stdout)
+}
+
+// browse runs the command loop until quit or end of input.
+func browse(db *folio.DB, in io.Reader, out io.Writer) error {
+	fmt.Fprintf(out, "%d documents. Type help for commands.\n", db.Count())
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "folio> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		cmd, rest, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		rest = strings.TrimSpace(rest)
+
+		var err error
+		switch cmd {
+		case "":
+		case "ls":
+			err = browseList(db, rest, out)
+		case "cat":
+			err = browseCat(db, rest, out)
+		case "history":
+			err = browseHistory(db, rest, out)
+		case "show":
+			err = browseShow(db, rest, out)
+		case "diff":
+			err = browseDiff(db, rest, out)
+		case "help":
+			fmt.Fprintln(out, browseHelp)
+		case "quit", "exit":
+			return nil
+		default:
+			err = fmt.Errorf("unknown command %q (try help)", cmd)
+		}
+		if err != nil {
+			fmt.Fprintln(out, "error:", err)
+		}
+	}
+}
+
+func browseList(db *folio.DB, filter string, out io.Writer) error {
+	var labels []string
+	for lbl, err := range db.List() {
+		if err != nil {
+			return err
+		}
+		if strings.Contains(strings.ToLower(lbl), strings.ToLower(filter)) {
+			labels = append(labels, lbl)
+		}
+	}
+	slices.Sort(labels)
+	for _, lbl := range labels {
+		fmt.Fprintln(out, lbl)
+	}
+	return nil
+}
+
+func browseCat(db *folio.DB, label string, out io.Writer) error {
+	if label == "" {
+		return errors.New("usage: cat <label>")
+	}
+	content, err := db.Get(label)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, content)
+	return nil
+}
+
+func browseHistory(db *folio.DB, label string, out io.Writer) error {
+	if label == "" {
+		return errors.New("usage: history <label>")
+	}
+	versions, err := versions(db, label)
+	if err != nil {
+		return err
+	}
+	for i, v := range versions {
+		fmt.Fprintf(out, "%3d  %s  %d bytes\n", i+1, stamp(v.TS), len(v.Data))
+	}
+	return nil
+}
+
+func browseShow(db *folio.DB, rest string, out io.Writer) error {
+	label, nums := trailingInts(rest, 1)
+	if label == "" || len(nums) != 1 {
+		return errors.New("usage: show <label> <n>")
+	}
+	versions, err := versions(db, label)
+	if err != nil {
+		return err
+	}
+	v, err := pick(versions, nums[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, v.Data)
+	return nil
+}
+
+func browseDiff(db *folio.DB, rest string, out io.Writer) error {
+	label, nums := trailingInts(rest, 2)
+	if label == "" || len(nums) == 1 {
+		return errors.New("usage: diff <label> [a b]")
+	}
+	versions, err := versions(db, label)
+	if err != nil {
+		return err
+	}
+	if len(nums) == 0 {
+		if len(versions) < 2 {
+			return errors.New("only one version")
+		}
+		nums = []int{len(versions) - 1, len(versions)}
+	}
+	a, err := pick(versions, nums[0])
+	if err != nil {
+		return err
+	}
+	b, err := pick(versions, nums[1])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "--- %s @ %s\n+++ %s @ %s\n", label, stamp(a.TS), label, stamp(b.TS))
+	for _, l := range diffLines(a.Data, b.Data) {
+		fmt.Fprintln(out, l)
+	}
+	return nil
+}
+
+// versions materialises a document's history, mapping an empty result
+// to ErrNotFound so the browser can report unknown labels.
+func versions(db *folio.DB, label string) ([]folio.Version, error) {
+	vs, err := folio.Collect(db.History(label))
+	if err != nil {
+		return nil, err
+	}
+	if len(vs) == 0 {
+		return nil, folio.ErrNotFound
+	}
+	return vs, nil
+}
+
+// pick returns version n, counting from 1 for the oldest.
+func pick(vs []folio.Version, n int) (folio.Version, error) {
+	if n < 1 || n > len(vs) {
+		return folio.Version{}, fmt.Errorf("version %d out of range 1..%d", n, len(vs))
+	}
+	return vs[n-1], nil
+}
+
+// trailingInts splits up to limit integer arguments off the end of s and
+// returns the remainder as the label. Labels may contain spaces, so the
+// label is whatever precedes the numbers rather than a single field.
+func trailingInts(s string, limit int) (string, []int) {
+	fields := strings.Fields(s)
+	var nums []int
+	for len(fields) > 1 && len(nums) < limit {
+		n, err := strconv.Atoi(fields[len(fields)-1])
+		if err != nil {
+			break
+		}
+		nums = append([]int{n}, nums...)
+		fields = fields[:len(fields)-1]
+	}
+	return strings.Join(fields, " "), nums
+}
+
+func stamp(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format("2006-01-02 15:04:05.000")
+}
+
+// diffLines produces a line-level diff of a and b from their longest
+// common subsequence. Unchanged lines are prefixed with a space,
+// removals with '-', and additions with '+'.
+func diffLines(a, b string) []string {
+	x := strings.Split(a, "\n")
+	y := strings.Split(b, "\n")
+
+	// lcs[i][j] is the LCS length of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out []string
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			out = append(out, " "+x[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "-"+x[i])
+			i++
+		default:
+			out = append(out, "+"+y[j])
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		out = append(out, "-"+x[i])
+	}
+	for ; j < len(y); j++ {
+		out = append(out, "+"+y[j])
+	}
+	return out
+}
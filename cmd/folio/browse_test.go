@@ -0,0 +1,167 @@
+// Browser tests.
+//
+// The browser is driven with scripted input so every command can be
+// exercised without a terminal. The database is populated through a
+// separate read-write handle, mirroring the real use case of inspecting
+// a file another process owns.
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jpl-au/folio"
+)
+
+// browseScript populates a database, runs the browser against it with
+// the given input, and returns everything the browser printed.
+func browseScript(t *testing.T, input string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := folio.Open(path, folio.Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	db.Set("notes/a", "line one\nline two")
+	db.Set("notes/a", "line one\nline 2\nline three")
+	db.Set("config", "theme: dark")
+
+	var out strings.Builder
+	if err := run([]string{"browse", path}, strings.NewReader(input), &out); err != nil {
+		t.Fatalf("browse: %v", err)
+	}
+	return out.String()
+}
+
+// TestBrowseList verifies that ls prints sorted labels and applies the
+// filter. Unsorted output would make the listing useless on large files.
+func TestBrowseList(t *testing.T) {
+	out := browseScript(t, "ls\nls notes\nquit\n")
+	if !strings.Contains(out, "config\nnotes/a\n") {
+		t.Errorf("ls output not sorted:\n%s", out)
+	}
+	if strings.Count(out, "config") != 1 {
+		t.Errorf("filter did not exclude config:\n%s", out)
+	}
+}
+
+// TestBrowseCatAndHistory verifies content and version listing. These
+// are the two views operators reach for first during an incident.
+func TestBrowseCatAndHistory(t *testing.T) {
+	out := browseScript(t, "cat config\nhistory notes/a\nshow notes/a 1\n")
+	if !strings.Contains(out, "theme: dark") {
+		t.Errorf("cat missing content:\n%s", out)
+	}
+	if !strings.Contains(out, "  1  ") || !strings.Contains(out, "  2  ") {
+		t.Errorf("history missing versions:\n%s", out)
+	}
+	if !strings.Contains(out, "line two") {
+		t.Errorf("show 1 missing first version:\n%s", out)
+	}
+}
+
+// TestBrowseDiff verifies the default diff compares the last two
+// versions and marks removed and added lines.
+func TestBrowseDiff(t *testing.T) {
+	out := browseScript(t, "diff notes/a\n")
+	for _, want := range []string{" line one", "-line two", "+line 2", "+line three"} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("diff missing %q:\n%s", want, out)
+		}
+	}
+}
+
+// TestBrowseErrors verifies that bad input is reported and the loop
+// keeps running, rather than exiting the session on a typo.
+func TestBrowseErrors(t *testing.T) {
+	out := browseScript(t, "cat missing\nbogus\nshow notes/a 9\ncat config\n")
+	if strings.Count(out, "error:") != 3 {
+		t.Errorf("expected 3 errors:\n%s", out)
+	}
+	if !strings.Contains(out, "theme: dark") {
+		t.Errorf("session ended after an error:\n%s", out)
+	}
+}
+
+// tuiScript populates a database as browseScript does, drives the
+// full-screen browser with keys on an 80x24 screen, and returns the
+// last frame it drew.
+func tuiScript(t *testing.T, keys string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := folio.Open(path, folio.Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	db.Set("notes/a", "line one\nline two")
+	db.Set("notes/a", "line one\nline 2\nline three")
+	db.Set("config", "theme: dark")
+
+	ro, err := folio.Open(path, folio.Config{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Open read-only: %v", err)
+	}
+	defer ro.Close()
+	var out strings.Builder
+	if err := runTUI(ro, strings.NewReader(keys), &out, func() (int, int) { return 80, 24 }); err != nil {
+		t.Fatalf("runTUI: %v", err)
+	}
+	frames := strings.Split(out.String(), "\x1b[H\x1b[2J")
+	return frames[len(frames)-1]
+}
+
+// TestTUIFilterAndOpen verifies that typing a filter narrows the list
+// and that Enter opens the selected document.
+func TestTUIFilterAndOpen(t *testing.T) {
+	frame := tuiScript(t, "/NOTES")
+	if !strings.Contains(frame, "notes/a") || strings.Contains(frame, "config") {
+		t.Errorf("filter did not narrow the list:\n%s", frame)
+	}
+	if !strings.Contains(frame, "1 of 2 documents matching NOTES") {
+		t.Errorf("title does not count the matches:\n%s", frame)
+	}
+
+	frame = tuiScript(t, "/notes\r\r")
+	if !strings.Contains(frame, "line three") {
+		t.Errorf("Enter did not open the document:\n%s", frame)
+	}
+}
+
+// TestTUIArrows verifies that arrow keys, sent as escape sequences,
+// move the selection, and that Escape goes back a screen.
+func TestTUIArrows(t *testing.T) {
+	frame := tuiScript(t, "\x1b[B\r")
+	if !strings.Contains(frame, "line three") {
+		t.Errorf("down then Enter did not open the second label:\n%s", frame)
+	}
+	frame = tuiScript(t, "\x1b[B\r\x1b")
+	if !strings.Contains(frame, "2 of 2 documents") {
+		t.Errorf("Escape did not return to the list:\n%s", frame)
+	}
+}
+
+// TestTUIHistoryAndDiff verifies the version list and that d diffs the
+// selected version against the one before it, or the marked one.
+func TestTUIHistoryAndDiff(t *testing.T) {
+	frame := tuiScript(t, "\x1b[Bh")
+	if !strings.Contains(frame, "notes/a history") || !strings.Contains(frame, "  1  ") || !strings.Contains(frame, "  2  ") {
+		t.Errorf("history missing versions:\n%s", frame)
+	}
+	frame = tuiScript(t, "\x1b[Bhd")
+	for _, want := range []string{" line one", "-line two", "+line 2", "+line three"} {
+		if !strings.Contains(frame, want) {
+			t.Errorf("diff missing %q:\n%s", want, frame)
+		}
+	}
+	frame = tuiScript(t, "\x1b[Bh\x1b[Ad")
+	if !strings.Contains(frame, "nothing before it") {
+		t.Errorf("diff of the oldest version not refused:\n%s", frame)
+	}
+	frame = tuiScript(t, "\x1b[Bh\x1b[A \x1b[B\r")
+	if !strings.Contains(frame, "version 2 of 2") {
+		t.Errorf("Enter did not show the selected version:\n%s", frame)
+	}
+}
@@ -0,0 +1,53 @@
+// Single-writer daemon.
+//
+// daemon owns a file and serves it on a Unix domain socket until
+// interrupted, so other processes can share it through ipc.Client
+// instead of each opening it and taking the flock. Like serve, it holds
+// the file open for as long as it runs and logs the database's events to
+// stderr.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/jpl-au/folio"
+	"github.com/jpl-au/folio/ipc"
+)
+
+func runDaemon(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errors.New("usage: folio daemon <file> [socket]")
+	}
+	sock := args[0] + ".sock"
+	if len(args) == 2 {
+		sock = args[1]
+	}
+	db, err := folio.Open(args[0], folio.Config{Logger: slog.New(slog.NewTextHandler(os.Stderr, nil))})
+	if err != nil {
+		return err
+	}
+	ln, err := ipc.Listen(sock)
+	if err != nil {
+		return errors.Join(err, db.Close())
+	}
+	fmt.Fprintf(stdout, "serving %s on %s\n", args[0], sock)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	srv := ipc.New(db)
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ln) }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		srv.Close()
+		err = <-done
+	}
+	return errors.Join(err, db.Close())
+}
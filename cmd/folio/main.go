@@ -0,0 +1,83 @@
+// Command folio inspects and maintains .folio database files from the shell.
+//
+// Usage:
+//
+//	folio <command> [arguments]
+//
+// Each command opens the file, does its work, and closes it again, in
+// keeping with the library's short-lived process design. Commands that
+// only read open the file with Config.ReadOnly so they can safely be
+// pointed at a database another process is writing.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// command is one subcommand. run receives the arguments after the
+// command name and the process's standard streams, so tests can drive
+// commands without touching the real terminal.
+type command struct {
+	usage   string
+	summary string
+	run     func(args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"browse":  {"browse <file>", "interactively inspect labels, content, and history (read-only)", runBrowse},
+	"daemon":  {"daemon <file> [socket]", "own the file and serve it to ipc clients (default <file>.sock)", runDaemon},
+	"get":     {"get <file> <label>", "print a document's content", runGet},
+	"set":     {"set <file> <label> [content]", "write a document, from stdin without content", runSet},
+	"rm":      {"rm <file> <label>...", "delete documents", runRm},
+	"ls":      {"ls <file> [prefix]", "list labels, optionally under prefix", runLs},
+	"history": {"history <file> <label>", "list versions: number, unix ms, time, size", runHistory},
+	"search":  {"search <file> <pattern>", "list labels whose content matches pattern", runSearch},
+	"compact": {"compact <file>", "sort the file and reclaim space", runCompact},
+	"verify":  {"verify <file>", "check every record and report problems", runVerify},
+	"export":  {"export <file>", "write a JSONL dump with history to stdout", runExport},
+	"import":  {"import <file>", "restore a dump from stdin", runImport},
+	"serve":   {"serve <file> [addr]", "serve the HTTP API (default localhost:8080)", runServe},
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "folio:", err)
+		os.Exit(1)
+	}
+}
+
+// run dispatches to the named subcommand.
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		usage(stdout)
+		return errUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		usage(stdout)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return cmd.run(args[1:], stdin, stdout)
+}
+
+var errUsage = errors.New("missing command")
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: folio <command> [arguments]")
+	fmt.Fprintln(w)
+	names := slices.Sorted(maps.Keys(commands))
+	width := 0
+	for _, name := range names {
+		width = max(width, len(commands[name].usage))
+	}
+	for _, name := range names {
+		c := commands[name]
+		fmt.Fprintf(w, "  %s%s  %s\n", c.usage, strings.Repeat(" ", width-len(c.usage)), c.summary)
+	}
+}
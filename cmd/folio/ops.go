@@ -0,0 +1,162 @@
+// One-shot commands for scripts.
+//
+// Each command is a single library call on a freshly opened file, and
+// prints its result to stdout in a plain form another program can read:
+// content exactly as stored, one label per line, tab-separated columns.
+// Failures go to stderr with a non-zero exit status, so a script can
+// test "folio get f.folio label" like any other command. Content for set
+// and export dumps for import are read from stdin.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/jpl-au/folio"
+)
+
+// open checks that args holds between lo and hi arguments (any number
+// from lo with hi -1), then opens the file args[0] names.
+func open(args []string, lo, hi int, usage string, readOnly bool) (*folio.DB, error) {
+	if len(args) < lo || hi >= 0 && len(args) > hi {
+		return nil, errors.New("usage: folio " + usage)
+	}
+	return folio.Open(args[0], folio.Config{ReadOnly: readOnly})
+}
+
+func runGet(args []string, stdin io.Reader, stdout io.Writer) error {
+	db, err := open(args, 2, 2, "get <file> <label>", true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	content, err := db.GetBytes(args[1])
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(content)
+	return err
+}
+
+func runSet(args []string, stdin io.Reader, stdout io.Writer) error {
+	db, err := open(args, 2, 3, "set <file> <label> [content]", false)
+	if err != nil {
+		return err
+	}
+	if len(args) == 3 {
+		err = db.Set(args[1], args[2])
+	} else {
+		err = db.SetReader(args[1], stdin)
+	}
+	return errors.Join(err, db.Close())
+}
+
+func runRm(args []string, stdin io.Reader, stdout io.Writer) error {
+	db, err := open(args, 2, -1, "rm <file> <label>...", false)
+	if err != nil {
+		return err
+	}
+	return errors.Join(db.DeleteMany(args[1:]...), db.Close())
+}
+
+func runLs(args []string, stdin io.Reader, stdout io.Writer) error {
+	db, err := open(args, 1, 2, "ls <file> [prefix]", true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	labels := db.List()
+	if len(args) == 2 {
+		labels = db.ListPrefix(args[1])
+	}
+	var out []string
+	for lbl, err := range labels {
+		if err != nil {
+			return err
+		}
+		out = append(out, lbl)
+	}
+	slices.Sort(out)
+	for _, lbl := range out {
+		fmt.Fprintln(stdout, lbl)
+	}
+	return nil
+}
+
+func runHistory(args []string, stdin io.Reader, stdout io.Writer) error {
+	db, err := open(args, 2, 2, "history <file> <label>", true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	vs, err := versions(db, args[1])
+	if err != nil {
+		return err
+	}
+	for i, v := range vs {
+		fmt.Fprintf(stdout, "%d\t%d\t%s\t%d\n", i+1, v.TS, stamp(v.TS), len(v.Data))
+	}
+	return nil
+}
+
+func runSearch(args []string, stdin io.Reader, stdout io.Writer) error {
+	db, err := open(args, 2, 2, "search <file> <pattern>", true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for m, err := range db.Search(args[1], folio.SearchOptions{}) {
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, m.Label)
+	}
+	return nil
+}
+
+func runCompact(args []string, stdin io.Reader, stdout io.Writer) error {
+	db, err := open(args, 1, 1, "compact <file>", false)
+	if err != nil {
+		return err
+	}
+	return errors.Join(db.Compact(), db.Close())
+}
+
+func runVerify(args []string, stdin io.Reader, stdout io.Writer) error {
+	db, err := open(args, 1, 1, "verify <file>", true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	report, err := db.Verify(folio.VerifyOptions{})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d documents, %d versions, %d indexes\n", report.Records, report.Versions, report.Indexes)
+	for _, p := range report.Problems {
+		fmt.Fprintln(stdout, p)
+	}
+	if !report.OK() {
+		return fmt.Errorf("%d problems found", len(report.Problems))
+	}
+	return nil
+}
+
+func runExport(args []string, stdin io.Reader, stdout io.Writer) error {
+	db, err := open(args, 1, 1, "export <file>", true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Export(stdout, folio.ExportOptions{})
+}
+
+func runImport(args []string, stdin io.Reader, stdout io.Writer) error {
+	db, err := open(args, 1, 1, "import <file>", false)
+	if err != nil {
+		return err
+	}
+	return errors.Join(db.Import(stdin), db.Close())
+}
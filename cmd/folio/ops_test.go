@@ -0,0 +1,95 @@
+// Script command tests.
+//
+// Each command is run through run, as main does, against a file in a
+// temp directory, checking what a script would see on stdout.
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runScript runs one command and returns its output.
+func runScript(t *testing.T, stdin string, args ...string) (string, error) {
+	t.Helper()
+	var out strings.Builder
+	err := run(args, strings.NewReader(stdin), &out)
+	return out.String(), err
+}
+
+// TestScriptRoundTrip verifies that documents written with set read
+// back exactly with get, including content piped on stdin.
+func TestScriptRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	if _, err := runScript(t, "", "set", path, "notes/a", "first"); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if _, err := runScript(t, "second\nline\n", "set", path, "notes/a"); err != nil {
+		t.Fatalf("set from stdin: %v", err)
+	}
+	runScript(t, "", "set", path, "config", "theme: dark")
+
+	if out, err := runScript(t, "", "get", path, "notes/a"); err != nil || out != "second\nline\n" {
+		t.Errorf("get = %q, %v", out, err)
+	}
+	if out, _ := runScript(t, "", "ls", path); out != "config\nnotes/a\n" {
+		t.Errorf("ls = %q", out)
+	}
+	if out, _ := runScript(t, "", "ls", path, "notes/"); out != "notes/a\n" {
+		t.Errorf("ls notes/ = %q", out)
+	}
+	out, err := runScript(t, "", "history", path, "notes/a")
+	if err != nil || strings.Count(out, "\n") != 2 || !strings.HasPrefix(out, "1\t") {
+		t.Errorf("history = %q, %v", out, err)
+	}
+	if out, _ := runScript(t, "", "search", path, "dark"); out != "config\n" {
+		t.Errorf("search = %q", out)
+	}
+
+	if _, err := runScript(t, "", "rm", path, "config"); err != nil {
+		t.Fatalf("rm: %v", err)
+	}
+	if _, err := runScript(t, "", "get", path, "config"); err == nil {
+		t.Error("get after rm succeeded")
+	}
+	if _, err := runScript(t, "", "compact", path); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if out, err := runScript(t, "", "verify", path); err != nil || !strings.HasPrefix(out, "1 documents, ") {
+		t.Errorf("verify = %q, %v", out, err)
+	}
+}
+
+// TestScriptExportImport verifies that a dump piped from export into
+// import recreates the documents with their history.
+func TestScriptExportImport(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src.folio"), filepath.Join(dir, "dst.folio")
+	runScript(t, "", "set", src, "a", "one")
+	runScript(t, "", "set", src, "a", "two")
+
+	dump, err := runScript(t, "", "export", src)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if _, err := runScript(t, dump, "import", dst); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if out, _ := runScript(t, "", "get", dst, "a"); out != "two" {
+		t.Errorf("imported content = %q", out)
+	}
+	if out, _ := runScript(t, "", "history", dst, "a"); strings.Count(out, "\n") != 2 {
+		t.Errorf("imported history = %q", out)
+	}
+}
+
+// TestScriptUsage verifies that wrong arguments are reported rather
+// than guessed at.
+func TestScriptUsage(t *testing.T) {
+	for _, args := range [][]string{{"get", "f.folio"}, {"rm", "f.folio"}, {"compact"}, {"ls", "f", "a", "b"}} {
+		if _, err := runScript(t, "", args...); err == nil || !strings.HasPrefix(err.Error(), "usage:") {
+			t.Errorf("%v: err = %v, want usage", args, err)
+		}
+	}
+}
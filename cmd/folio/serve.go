@@ -0,0 +1,57 @@
+// HTTP server.
+//
+// serve runs the httpd API on a file until interrupted, then shuts the
+// server down, letting requests in flight finish, and closes the file.
+// Unlike the other commands it holds the file open for as long as it
+// runs, so the database's own events, such as crash recovery and
+// compactions, are logged to stderr.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/jpl-au/folio"
+	"github.com/jpl-au/folio/httpd"
+)
+
+func runServe(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errors.New("usage: folio serve <file> [addr]")
+	}
+	addr := "localhost:8080"
+	if len(args) == 2 {
+		addr = args[1]
+	}
+	db, err := folio.Open(args[0], folio.Config{Logger: slog.New(slog.NewTextHandler(os.Stderr, nil))})
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Join(err, db.Close())
+	}
+	fmt.Fprintf(stdout, "serving %s on http://%s\n", args[0], ln.Addr())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	srv := &http.Server{Handler: httpd.New(db)}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ln) }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = srv.Shutdown(context.Background())
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return errors.Join(err, db.Close())
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+
+package main
+
+import "errors"
+
+var errNoTerminal = errors.New("terminal control not supported")
+
+// makeRaw always fails here, so browse keeps to its line mode.
+func makeRaw(in, out uintptr) (func(), error) {
+	return nil, errNoTerminal
+}
+
+func termSize(out uintptr) (int, int, error) {
+	return 0, 0, errNoTerminal
+}
@@ -0,0 +1,54 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+// Terminal control for the browser through termios, with the standard
+// library's syscall package rather than a terminal dependency.
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// winsize is struct winsize from <sys/ioctl.h>.
+type winsize struct {
+	Row, Col, X, Y uint16
+}
+
+func ioctl(fd, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// makeRaw puts the terminal on in into raw mode: keys arrive one at a
+// time, unechoed, and Ctrl-C is a key rather than a signal. Output
+// processing is left on, so a newline still returns the carriage. It
+// returns a function restoring the mode it found, or an error if in is
+// not a terminal.
+func makeRaw(in, out uintptr) (func(), error) {
+	var saved syscall.Termios
+	if err := ioctl(in, ioctlGetTermios, unsafe.Pointer(&saved)); err != nil {
+		return nil, err
+	}
+	raw := saved
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(in, ioctlSetTermios, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+	return func() { ioctl(in, ioctlSetTermios, unsafe.Pointer(&saved)) }, nil
+}
+
+// termSize returns the width and height of the terminal on out.
+func termSize(out uintptr) (int, int, error) {
+	var ws winsize
+	if err := ioctl(out, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
@@ -0,0 +1,78 @@
+//go:build windows
+
+// Terminal control for the browser through the console API. The
+// console is switched to virtual terminal sequences both ways, so the
+// browser reads and writes the same escape codes it does on Unix.
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                       = syscall.NewLazyDLL("kernel32.dll")
+	procSetConsoleMode             = kernel32.NewProc("SetConsoleMode")
+	procGetConsoleScreenBufferInfo = kernel32.NewProc("GetConsoleScreenBufferInfo")
+)
+
+const (
+	enableProcessedInput            = 0x0001
+	enableLineInput                 = 0x0002
+	enableEchoInput                 = 0x0004
+	enableVirtualTerminalInput      = 0x0200
+	enableProcessedOutput           = 0x0001
+	enableVirtualTerminalProcessing = 0x0004
+)
+
+func setConsoleMode(h syscall.Handle, mode uint32) error {
+	if r, _, err := procSetConsoleMode.Call(uintptr(h), uintptr(mode)); r == 0 {
+		return err
+	}
+	return nil
+}
+
+// makeRaw puts the console on in into raw mode, with virtual terminal
+// input, and turns on virtual terminal processing for out. It returns
+// a function restoring both modes, or an error if either is not a
+// console.
+func makeRaw(in, out uintptr) (func(), error) {
+	hin, hout := syscall.Handle(in), syscall.Handle(out)
+	var inMode, outMode uint32
+	if err := syscall.GetConsoleMode(hin, &inMode); err != nil {
+		return nil, err
+	}
+	if err := syscall.GetConsoleMode(hout, &outMode); err != nil {
+		return nil, err
+	}
+	raw := inMode&^(enableProcessedInput|enableLineInput|enableEchoInput) | enableVirtualTerminalInput
+	if err := setConsoleMode(hin, raw); err != nil {
+		return nil, err
+	}
+	if err := setConsoleMode(hout, outMode|enableProcessedOutput|enableVirtualTerminalProcessing); err != nil {
+		setConsoleMode(hin, inMode)
+		return nil, err
+	}
+	return func() {
+		setConsoleMode(hin, inMode)
+		setConsoleMode(hout, outMode)
+	}, nil
+}
+
+// consoleScreenBufferInfo is CONSOLE_SCREEN_BUFFER_INFO.
+type consoleScreenBufferInfo struct {
+	size       [2]int16
+	cursor     [2]int16
+	attributes uint16
+	window     [4]int16 // left, top, right, bottom
+	maxWindow  [2]int16
+}
+
+// termSize returns the width and height of the console window on out.
+func termSize(out uintptr) (int, int, error) {
+	var info consoleScreenBufferInfo
+	if r, _, err := procGetConsoleScreenBufferInfo.Call(out, uintptr(unsafe.Pointer(&info))); r == 0 {
+		return 0, 0, err
+	}
+	return int(info.window[2]-info.window[0]) + 1, int(info.window[3]-info.window[1]) + 1, nil
+}
@@ -0,0 +1,552 @@
+// Full-screen browser.
+//
+// On a terminal, browse takes over the screen: a list of labels that
+// narrows as a filter is typed, a document's content, its versions, and
+// a diff between any two of them, each moved through with the arrow
+// keys. The screen is redrawn whole after every key with plain ANSI
+// escape codes, which every terminal the command runs on understands.
+//
+// Like the line mode, every view is filled by ordinary library calls on
+// the read-only handle, each under the shared lock for its own duration
+// only. Nothing is held between keys, so a writer in another process is
+// never blocked by a browser left open, and r reloads what it changed.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jpl-au/folio"
+)
+
+// key is a decoded keypress. For keyRune, r holds the character.
+type key struct {
+	code int
+	r    rune
+}
+
+const (
+	keyNone = iota
+	keyRune
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyPgUp
+	keyPgDn
+	keyHome
+	keyEnd
+	keyEnter
+	keyEsc
+	keyBackspace
+	keyInterrupt // Ctrl-C or Ctrl-D
+)
+
+// readKey decodes the next keypress from r. An escape byte with nothing
+// after it in the same read is the Escape key; otherwise it starts a
+// CSI or SS3 sequence, and one it does not know decodes as keyNone.
+func readKey(r *bufio.Reader) (key, error) {
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return key{}, err
+	}
+	switch c {
+	case '\r', '\n':
+		return key{code: keyEnter}, nil
+	case 0x7f, 0x08:
+		return key{code: keyBackspace}, nil
+	case 0x03, 0x04:
+		return key{code: keyInterrupt}, nil
+	case 0x1b:
+	default:
+		if c < ' ' {
+			return key{}, nil
+		}
+		return key{keyRune, c}, nil
+	}
+
+	if r.Buffered() == 0 {
+		return key{code: keyEsc}, nil
+	}
+	if next, _ := r.Peek(1); next[0] != '[' && next[0] != 'O' {
+		return key{code: keyEsc}, nil
+	}
+	r.ReadByte()
+	var params []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return key{}, err
+		}
+		if b >= 0x40 && b <= 0x7e {
+			return key{code: csi(b, string(params))}, nil
+		}
+		params = append(params, b)
+	}
+}
+
+// csi maps the final byte and parameters of an escape sequence to a key.
+func csi(final byte, params string) int {
+	switch final {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		return keyRight
+	case 'D':
+		return keyLeft
+	case 'H':
+		return keyHome
+	case 'F':
+		return keyEnd
+	case '~':
+		switch params {
+		case "1", "7":
+			return keyHome
+		case "4", "8":
+			return keyEnd
+		case "5":
+			return keyPgUp
+		case "6":
+			return keyPgDn
+		}
+	}
+	return keyNone
+}
+
+// The kinds of screen.
+const (
+	screenList = iota
+	screenText
+	screenHistory
+)
+
+// screen is one view on the browser's stack. A list or history screen
+// draws from the browser's labels or versions; a text screen, a
+// document, a version or a diff, keeps its own lines.
+type screen struct {
+	kind   int
+	title  string
+	lines  []string
+	diff   bool // lines are a diff, coloured by their prefix
+	cursor int  // selected row of a list or history screen
+	top    int  // first row shown
+}
+
+// tui is the state of a full-screen browser.
+type tui struct {
+	db      *folio.DB
+	screens []*screen // the last is showing; Escape goes back one
+
+	labels  []string // every label, sorted
+	shown   []string // the labels matching filter
+	filter  string
+	editing bool // keys go to the filter
+
+	label    string          // the document history is showing
+	versions []folio.Version // its versions, oldest first
+	mark     int             // version marked for diff, 1-based; 0 for none
+
+	status string // message for the bottom line, cleared by the next key
+}
+
+const tuiHelp = "↑↓ move  / filter  enter open  h history  r reload  q quit"
+const historyHelp = "↑↓ move  enter view  space mark  d diff (marked, or previous)  esc back"
+const textHelp = "↑↓ pgup pgdn scroll  h history  esc back"
+
+// runTUI runs the full-screen browser until q, Ctrl-C, or end of input.
+// size reports the terminal's width and height, and is asked before
+// every redraw so a resized window is filled.
+func runTUI(db *folio.DB, in io.Reader, out io.Writer, size func() (int, int)) error {
+	t := &tui{db: db, screens: []*screen{{kind: screenList}}}
+	if err := t.reload(); err != nil {
+		return err
+	}
+	r := bufio.NewReader(in)
+	for {
+		w, h := size()
+		if _, err := io.WriteString(out, t.render(w, h)); err != nil {
+			return err
+		}
+		k, err := readKey(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !t.key(k, max(h-2, 1)) {
+			return nil
+		}
+	}
+}
+
+func (t *tui) top() *screen { return t.screens[len(t.screens)-1] }
+
+// reload lists the labels again, keeping the filter and, if it is still
+// there, the selected label.
+func (t *tui) reload() error {
+	var labels []string
+	for lbl, err := range t.db.List() {
+		if err != nil {
+			return err
+		}
+		labels = append(labels, lbl)
+	}
+	slices.Sort(labels)
+	t.labels = labels
+	t.refilter()
+	return nil
+}
+
+// refilter narrows the labels to those containing the filter, ignoring
+// case, and keeps the selection on the same label where it can.
+func (t *tui) refilter() {
+	list := t.screens[0]
+	var selected string
+	if list.cursor < len(t.shown) {
+		selected = t.shown[list.cursor]
+	}
+	f := strings.ToLower(t.filter)
+	t.shown = t.shown[:0]
+	for _, lbl := range t.labels {
+		if strings.Contains(strings.ToLower(lbl), f) {
+			t.shown = append(t.shown, lbl)
+		}
+	}
+	list.cursor, list.top = 0, 0
+	if i, ok := slices.BinarySearch(t.shown, selected); ok {
+		list.cursor = i
+	}
+}
+
+// key applies k to the showing screen, whose body is rows tall, and
+// reports whether the browser should keep running.
+func (t *tui) key(k key, rows int) bool {
+	t.status = ""
+	if k.code == keyInterrupt {
+		return false
+	}
+	s := t.top()
+	if t.editing {
+		t.edit(k)
+		return true
+	}
+	switch {
+	case k.code == keyRune && k.r == 'q' && len(t.screens) == 1:
+		return false
+	case k.code == keyEsc, k.code == keyLeft, k.code == keyBackspace, k.code == keyRune && k.r == 'q':
+		if len(t.screens) > 1 {
+			t.screens = t.screens[:len(t.screens)-1]
+		} else if t.filter != "" {
+			t.filter = ""
+			t.refilter()
+		}
+		return true
+	case k.code == keyRune && k.r == 'r':
+		t.refresh()
+		return true
+	}
+
+	switch s.kind {
+	case screenList:
+		switch {
+		case k.code == keyRune && k.r == '/':
+			t.editing = true
+		case k.code == keyEnter || k.code == keyRight:
+			if s.cursor < len(t.shown) {
+				t.open(t.shown[s.cursor])
+			}
+		case k.code == keyRune && k.r == 'h':
+			if s.cursor < len(t.shown) {
+				t.history(t.shown[s.cursor])
+			}
+		default:
+			s.move(k, len(t.shown), rows)
+		}
+
+	case screenHistory:
+		switch {
+		case k.code == keyEnter || k.code == keyRight:
+			v := t.versions[s.cursor]
+			t.push(&screen{kind: screenText, title: fmt.Sprintf("%s @ %s (version %d of %d)", t.label, stamp(v.TS), s.cursor+1, len(t.versions)), lines: strings.Split(v.Data, "\n")})
+		case k.code == keyRune && k.r == ' ':
+			if t.mark == s.cursor+1 {
+				t.mark = 0
+			} else {
+				t.mark = s.cursor + 1
+			}
+		case k.code == keyRune && k.r == 'd':
+			t.diff(s.cursor + 1)
+		default:
+			s.move(k, len(t.versions), rows)
+		}
+
+	case screenText:
+		if k.code == keyRune && k.r == 'h' {
+			t.history(t.label)
+		} else {
+			s.scroll(k, rows)
+		}
+	}
+	return true
+}
+
+// edit applies k to the filter being typed.
+func (t *tui) edit(k key) {
+	switch k.code {
+	case keyRune:
+		t.filter += string(k.r)
+	case keyBackspace:
+		if _, n := utf8.DecodeLastRuneInString(t.filter); n > 0 {
+			t.filter = t.filter[:len(t.filter)-n]
+		}
+	case keyEsc:
+		t.filter = ""
+		t.editing = false
+	case keyEnter:
+		t.editing = false
+	default:
+		return
+	}
+	t.refilter()
+}
+
+// move applies a movement key to the cursor of a screen of n rows, of
+// which page are shown at once, and scrolls to keep it in view.
+func (s *screen) move(k key, n, page int) {
+	switch {
+	case k.code == keyUp || k.code == keyRune && k.r == 'k':
+		s.cursor--
+	case k.code == keyDown || k.code == keyRune && k.r == 'j':
+		s.cursor++
+	case k.code == keyPgUp:
+		s.cursor -= page
+	case k.code == keyPgDn:
+		s.cursor += page
+	case k.code == keyHome || k.code == keyRune && k.r == 'g':
+		s.cursor = 0
+	case k.code == keyEnd || k.code == keyRune && k.r == 'G':
+		s.cursor = n - 1
+	}
+	s.cursor = max(min(s.cursor, n-1), 0)
+	s.follow(page)
+}
+
+// follow scrolls a list or history screen showing rows at once so its
+// cursor is in view.
+func (s *screen) follow(rows int) {
+	if s.cursor < s.top {
+		s.top = s.cursor
+	}
+	if s.cursor >= s.top+rows {
+		s.top = s.cursor - rows + 1
+	}
+}
+
+// scroll applies a movement key to a text screen showing rows at once.
+func (s *screen) scroll(k key, rows int) {
+	switch {
+	case k.code == keyUp || k.code == keyRune && k.r == 'k':
+		s.top--
+	case k.code == keyDown || k.code == keyRune && k.r == 'j':
+		s.top++
+	case k.code == keyPgUp:
+		s.top -= rows
+	case k.code == keyPgDn:
+		s.top += rows
+	case k.code == keyHome || k.code == keyRune && k.r == 'g':
+		s.top = 0
+	case k.code == keyEnd || k.code == keyRune && k.r == 'G':
+		s.top = len(s.lines)
+	}
+	s.top = max(min(s.top, len(s.lines)-rows), 0)
+}
+
+func (t *tui) push(s *screen) { t.screens = append(t.screens, s) }
+
+// open shows a document's current content.
+func (t *tui) open(label string) {
+	content, err := t.db.Get(label)
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+	t.label = label
+	t.push(&screen{kind: screenText, title: label, lines: strings.Split(content, "\n")})
+}
+
+// history shows a document's versions, newest selected.
+func (t *tui) history(label string) {
+	vs, err := versions(t.db, label)
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+	t.label, t.versions, t.mark = label, vs, 0
+	t.push(&screen{kind: screenHistory, title: label + " history", cursor: len(vs) - 1})
+}
+
+// diff shows the change from the marked version, or without a mark the
+// one before, to version n.
+func (t *tui) diff(n int) {
+	from := t.mark
+	if from == 0 || from == n {
+		from = n - 1
+	}
+	if from < 1 {
+		t.status = "the oldest version has nothing before it; mark one with space"
+		return
+	}
+	a, b := min(from, n), max(from, n)
+	va, vb := t.versions[a-1], t.versions[b-1]
+	t.push(&screen{
+		kind:  screenText,
+		title: fmt.Sprintf("%s: version %d @ %s → %d @ %s", t.label, a, stamp(va.TS), b, stamp(vb.TS)),
+		lines: diffLines(va.Data, vb.Data),
+		diff:  true,
+	})
+}
+
+// refresh reloads what the showing screen reads from the file.
+func (t *tui) refresh() {
+	s := t.top()
+	switch s.kind {
+	case screenList:
+		if err := t.reload(); err != nil {
+			t.status = err.Error()
+			return
+		}
+		t.status = fmt.Sprintf("%d documents", len(t.labels))
+	case screenHistory:
+		vs, err := versions(t.db, t.label)
+		if err != nil {
+			t.status = err.Error()
+			return
+		}
+		t.versions, t.mark = vs, 0
+		s.cursor = min(s.cursor, len(vs)-1)
+		t.status = fmt.Sprintf("%d versions", len(vs))
+	}
+}
+
+// render draws the whole screen, w columns by h rows: a title bar, the
+// showing screen's body, and a status or help line.
+func (t *tui) render(w, h int) string {
+	w, h = max(w, 20), max(h, 3)
+	rows := h - 2
+	s := t.top()
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	title := s.title
+	if s.kind == screenList {
+		title = fmt.Sprintf("%d of %d documents", len(t.shown), len(t.labels))
+		if t.filter != "" || t.editing {
+			title += " matching " + t.filter
+		}
+	}
+	b.WriteString("\x1b[7m" + pad(fit(title, w), w) + "\x1b[0m\r\n")
+
+	line := func(text, style string, selected bool) {
+		text = fit(text, w)
+		if selected {
+			style += "\x1b[7m"
+			text = pad(text, w)
+		}
+		if style != "" {
+			text = style + text + "\x1b[0m"
+		}
+		b.WriteString(text + "\r\n")
+	}
+	drawn := 0
+	if s.kind != screenText {
+		s.follow(rows)
+	}
+	switch s.kind {
+	case screenList:
+		for i := s.top; i < len(t.shown) && drawn < rows; i, drawn = i+1, drawn+1 {
+			line(t.shown[i], "", i == s.cursor)
+		}
+	case screenHistory:
+		for i := s.top; i < len(t.versions) && drawn < rows; i, drawn = i+1, drawn+1 {
+			v := t.versions[i]
+			marker := " "
+			if t.mark == i+1 {
+				marker = "*"
+			}
+			line(fmt.Sprintf("%s%3d  %s  %d bytes", marker, i+1, stamp(v.TS), len(v.Data)), "", i == s.cursor)
+		}
+	case screenText:
+		for i := s.top; i < len(s.lines) && drawn < rows; i, drawn = i+1, drawn+1 {
+			l := s.lines[i]
+			style := ""
+			if s.diff {
+				switch {
+				case strings.HasPrefix(l, "-"):
+					style = "\x1b[31m"
+				case strings.HasPrefix(l, "+"):
+					style = "\x1b[32m"
+				}
+			}
+			line(l, style, false)
+		}
+	}
+	for ; drawn < rows; drawn++ {
+		b.WriteString("\r\n")
+	}
+
+	foot := t.status
+	switch {
+	case foot != "":
+	case t.editing:
+		foot = "filter: " + t.filter + "▏ (enter done, esc clear)"
+	case s.kind == screenList:
+		foot = tuiHelp
+	case s.kind == screenHistory:
+		foot = historyHelp
+	default:
+		foot = textHelp
+	}
+	b.WriteString(fit(foot, w))
+	return b.String()
+}
+
+// fit makes text safe to draw on one row of w columns: tabs expanded,
+// other control characters shown as '?', and cut at w runes.
+func fit(text string, w int) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range text {
+		if n >= w {
+			break
+		}
+		switch {
+		case r == '\t':
+			for stop := n + 4 - n%4; n < stop && n < w; n++ {
+				b.WriteByte(' ')
+			}
+			continue
+		case !unicode.IsPrint(r) && r != ' ':
+			r = '?'
+		}
+		b.WriteRune(r)
+		n++
+	}
+	return b.String()
+}
+
+// pad fills text to w runes with spaces.
+func pad(text string, w int) string {
+	if n := utf8.RuneCountInString(text); n < w {
+		return text + strings.Repeat(" ", w-n)
+	}
+	return text
+}
@@ -0,0 +1,24 @@
+// Gathering an iterator's results.
+//
+// Every method that can yield many results, from All, List, and Search
+// to History, returns an iter.Seq2 that reads as the loop asks for more,
+// so a caller that stops early never pays for the rest; History parses
+// the records of a document up front to put them in write order, but
+// decompresses each version only as it is yielded. Collect is for the
+// caller that wants them all in a slice anyway.
+package folio
+
+import "iter"
+
+// Collect gathers the results of seq into a slice. It stops at the first
+// error, returning it with the results yielded before it.
+func Collect[T any](seq iter.Seq2[T, error]) ([]T, error) {
+	var items []T
+	for item, err := range seq {
+		if err != nil {
+			return items, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
@@ -0,0 +1,37 @@
+package folio
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// TestCollect verifies that Collect gathers every result, and stops at
+// the first error with the results before it.
+func TestCollect(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "v1")
+	db.Set("doc", "v2")
+
+	versions, err := Collect(db.History("doc"))
+	if err != nil || len(versions) != 2 || versions[1].Data != "v2" {
+		t.Errorf("Collect(History) = %v, %v", versions, err)
+	}
+
+	failed := errors.New("failed")
+	seq := func(yield func(int, error) bool) {
+		for _, n := range []int{1, 2} {
+			if !yield(n, nil) {
+				return
+			}
+		}
+		if !yield(0, failed) {
+			return
+		}
+		yield(3, nil)
+	}
+	got, err := Collect(seq)
+	if !errors.Is(err, failed) || !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("Collect = %v, %v; want [1 2], failed", got, err)
+	}
+}
@@ -12,6 +12,7 @@
 package folio
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -92,7 +93,7 @@ func TestHashCollisionDelete(t *testing.T) {
 	db.Delete("first")
 
 	_, err := db.Get("first")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Get(first) after delete: got %v, want ErrNotFound", err)
 	}
 
@@ -134,8 +135,8 @@ func TestHashCollisionHistory(t *testing.T) {
 	db.Set("history-a", "a-v2")
 	db.Set("history-b", "b-v1")
 
-	histA, _ := collect(db.History("history-a"))
-	histB, _ := collect(db.History("history-b"))
+	histA, _ := Collect(db.History("history-a"))
+	histB, _ := Collect(db.History("history-b"))
 
 	if len(histA) != 2 {
 		t.Errorf("History(history-a) = %d versions, want 2", len(histA))
@@ -0,0 +1,123 @@
+// Commit markers for torn-write recovery.
+//
+// Every write that creates a current data record appends it together
+// with its index, and the index line always comes last: Set, SetReader,
+// Batch, Rename, Revert, Import, and the body of a transaction. The
+// index is therefore the write's commit marker. A data record (_r=2)
+// in the sparse region is committed only if an index points at its
+// offset; retiring a version retypes the record before it erases the
+// index, so no committed write ever leaves a current record behind
+// without one. Only the offset is compared: a crash partway through an
+// in-place Rename leaves IDs or labels that disagree, and those writes
+// are committed all the same.
+//
+// A crash can tear an append anywhere. If the index line never reached
+// the disk, or reached it only in part, the record before it is whole
+// and parses cleanly, and a rebuild would otherwise keep it as a
+// current document that no lookup can reach but All still yields. If
+// the disk persisted the index but not every page of the record, the
+// index points at a line that is not a record. uncommitted finds both
+// cases after a crash and blanks them, so the document keeps the
+// version it had before the torn write: the earlier version is only
+// retired after the append completes.
+package folio
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// uncommitted blanks the records in the sparse region that have no
+// commit marker, and the markers whose record was torn. Called by Open
+// after settle and before repair, with exclusive access to the file.
+func (db *DB) uncommitted() error {
+	sz, err := size(db.reader)
+	if err != nil {
+		return fmt.Errorf("commit: stat: %w", err)
+	}
+	start := db.sparseStart()
+
+	type span struct {
+		off int64
+		n   int
+	}
+	type recordLine struct {
+		span
+		current bool // still _r=2
+	}
+	type indexLine struct {
+		span
+		idx *Index
+	}
+	lines := map[int64]*recordLine{}
+	var indexes []indexLine
+	torn := map[int64]span{} // non-blank lines that are not records
+
+	scanner := bufio.NewScanner(io.NewSectionReader(db.reader, start, sz-start))
+	scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
+	off := start
+	for scanner.Scan() {
+		ln := scanner.Bytes()
+		at := off
+		off += int64(len(ln)) + 1
+		if len(bytes.TrimLeft(ln, " ")) == 0 {
+			continue
+		}
+		if !valid(ln) || len(ln) < MinRecordSize {
+			torn[at] = span{at, len(ln)}
+			continue
+		}
+		switch int(ln[TypePos] - '0') {
+		case TypeRecord, TypeHistory:
+			lines[at] = &recordLine{span{at, len(ln)}, ln[TypePos] == byte('0'+TypeRecord)}
+		case TypeIndex:
+			idx, err := decodeIndex(ln)
+			if err != nil {
+				torn[at] = span{at, len(ln)}
+				continue
+			}
+			indexes = append(indexes, indexLine{span{at, len(ln)}, idx})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	var erase []span
+	committed := map[int64]bool{}
+	for _, e := range indexes {
+		if e.idx.Offset < start {
+			continue // points into the heap; not a sparse append
+		}
+		r, ok := lines[e.idx.Offset]
+		switch {
+		case ok && r.current:
+			committed[r.off] = true
+		case ok && !r.current:
+			// Interrupted retirement: finish it.
+			erase = append(erase, e.span)
+		default:
+			erase = append(erase, e.span)
+			if t, ok := torn[e.idx.Offset]; ok {
+				erase = append(erase, t)
+			}
+		}
+	}
+	for _, r := range lines {
+		if r.current && !committed[r.off] {
+			erase = append(erase, r.span)
+		}
+	}
+
+	for _, s := range erase {
+		if err := db.writeAt(s.off, bytes.Repeat([]byte(" "), s.n)); err != nil {
+			return fmt.Errorf("commit: %w", err)
+		}
+	}
+	if len(erase) > 0 && db.sizes != nil {
+		db.sizes.lost()
+	}
+	return nil
+}
@@ -1,14 +1,45 @@
-// Public entry points for the two common Repair modes.
+// Public entry points for the common Repair modes.
 package folio
 
+import "time"
+
 // Compact merges the sparse region back into sorted order, restoring
-// binary search performance. All history is preserved.
-func (db *DB) Compact() error {
-	return db.Repair(nil)
+// binary search performance. All history is preserved, except that
+// expired documents are dropped entirely (see ttl.go) and history beyond
+// Config.HistoryRetention is dropped (see retention.go).
+func (db *DB) Compact() (err error) {
+	defer db.observe(OpCompact, "", time.Now(), &err)
+
+	return db.repair(nil, true)
 }
 
 // Purge does the same as Compact but also drops history records,
 // permanently removing all previous versions of every document.
-func (db *DB) Purge() error {
-	return db.Repair(&CompactOptions{PurgeHistory: true})
+func (db *DB) Purge() (err error) {
+	defer db.observe(OpCompact, "", time.Now(), &err)
+
+	return db.repair(&CompactOptions{PurgeHistory: true}, true)
+}
+
+// Migrate rewrites a file written in an older format (see FormatVersion)
+// in the current one. The rewrite is a rebuild, as Compact's, keeping
+// history and layout. A file already current is left alone. Compact,
+// Purge, and Repair upgrade a file too, since every rebuild writes the
+// current format; Migrate is for upgrading without waiting for one.
+func (db *DB) Migrate() (err error) {
+	defer db.observe(OpMigrate, "", time.Now(), &err)
+
+	if db.config.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := db.blockRead(); err != nil {
+		return err
+	}
+	current := db.header.Version >= FormatVersion
+	db.mu.RUnlock()
+	db.lock.Unlock()
+	if current {
+		return nil
+	}
+	return db.repair(nil, true)
 }
@@ -5,6 +5,15 @@
 // a printable string that can be embedded directly in a JSON value without
 // escaping. This avoids the 33% overhead of base64 while remaining
 // newline-free (critical for the line-delimited format).
+//
+// Config.Compression can choose LZ4 (see lz4.go) or no compression
+// instead. The codec is a property of the file, fixed when it is created
+// and recorded in the header's _z, so every handle reads every snapshot
+// the same way; _d is never compressed, so the file stays greppable
+// whichever is chosen. The Zstd level is a property of the handle.
+//
+// Zstd snapshots may also be compressed against a dictionary trained on
+// the file's own documents (see dict.go).
 package folio
 
 import (
@@ -32,36 +41,152 @@ var (
 	zstdDecoder, _ = zstd.NewReader(nil)
 )
 
-func compress(data []byte) string {
+// Codec is a snapshot compression algorithm.
+type Codec int
+
+const (
+	CodecZstd Codec = iota // Zstd; the default
+	CodecLZ4               // LZ4: faster in both directions, larger snapshots
+	CodecNone              // no compression
+)
+
+// Compression selects how snapshots are compressed (see Config).
+type Compression struct {
+	// Codec applies when the file is created; an existing file keeps the
+	// codec it was created with.
+	Codec Codec
+
+	// Level is the Zstd level, 1 (fastest) to 22 (smallest) as for the
+	// zstd command, mapped to the nearest one the encoder implements.
+	// 0 keeps the default, the fastest. Other codecs ignore it. Any level
+	// reads back the same way, so it can change between opens.
+	Level int
+}
+
+// codec compresses and decompresses the snapshots of one file.
+type codec struct {
+	kind  Codec
+	level zstd.EncoderLevel
+	zstd  *zstd.Encoder
+	dec   *zstd.Decoder
+	dicts [][]byte // trained dictionaries, oldest first; the last one encodes
+}
+
+// defaultCodec is the codec of files whose header has no _z.
+var defaultCodec = codec{kind: CodecZstd, level: zstd.SpeedFastest, zstd: zstdEncoder, dec: zstdDecoder}
+
+// newCodec returns the codec for a file using kind, at the level c asks
+// for, with the file's dictionaries. dicts must be valid Zstd
+// dictionaries with distinct IDs.
+func newCodec(kind Codec, c Compression, dicts [][]byte) codec {
+	if kind != CodecZstd {
+		return codec{kind: kind, level: zstd.SpeedFastest, zstd: zstdEncoder, dec: zstdDecoder}
+	}
+	cd := codec{kind: kind, level: zstd.SpeedFastest, zstd: zstdEncoder, dec: zstdDecoder, dicts: dicts}
+	if c.Level != 0 {
+		cd.level = zstd.EncoderLevelFromZstd(c.Level)
+	}
+	// NewWriter and NewReader fail only on invalid options.
+	if c.Level != 0 || len(dicts) > 0 {
+		cd.zstd, _ = zstd.NewWriter(nil, cd.writerOptions()...)
+	}
+	if len(dicts) > 0 {
+		cd.dec, _ = zstd.NewReader(nil, cd.readerOptions()...)
+	}
+	return cd
+}
+
+// writerOptions configures a Zstd encoder to write as c does.
+func (c codec) writerOptions() []zstd.EOption {
+	opts := []zstd.EOption{zstd.WithEncoderLevel(c.level)}
+	if len(c.dicts) > 0 {
+		opts = append(opts, zstd.WithEncoderDict(c.dicts[len(c.dicts)-1]))
+	}
+	return opts
+}
+
+// readerOptions configures a Zstd decoder to read what c writes.
+func (c codec) readerOptions() []zstd.DOption {
+	if len(c.dicts) == 0 {
+		return nil
+	}
+	return []zstd.DOption{zstd.WithDecoderDicts(c.dicts...)}
+}
+
+// known reports whether k is a codec this version can read.
+func (k Codec) known() bool {
+	return k >= CodecZstd && k <= CodecNone
+}
+
+// encode compresses data, without the Ascii85 layer.
+func (c codec) encode(data []byte) []byte {
+	switch c.kind {
+	case CodecLZ4:
+		return lz4Encode(data)
+	case CodecNone:
+		return data
+	}
+	return c.zstd.EncodeAll(data, nil)
+}
+
+// decode reverses encode.
+func (c codec) decode(data []byte) ([]byte, error) {
+	switch c.kind {
+	case CodecLZ4:
+		return lz4Decode(data)
+	case CodecNone:
+		return data, nil
+	}
+	out, err := c.dec.DecodeAll(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: zstd: %w", ErrDecompress, err)
+	}
+	return out, nil
+}
+
+// compress returns data as an _h value.
+func (c codec) compress(data []byte) string {
 	if len(data) == 0 {
 		return ""
 	}
 
-	compressed := zstdEncoder.EncodeAll(data, nil)
+	return encode85(c.encode(data))
+}
+
+// decompress reverses compress.
+func (c codec) decompress(encoded string) ([]byte, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+
+	compressed, err := decode85(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return c.decode(compressed)
+}
+
+// compress and decompress use the default codec.
+func compress(data []byte) string { return defaultCodec.compress(data) }
+
+func decompress(encoded string) ([]byte, error) { return defaultCodec.decompress(encoded) }
 
+// encode85 renders bytes as Ascii85 for embedding in a JSON string.
+func encode85(data []byte) string {
 	var encoded bytes.Buffer
 	enc := ascii85.NewEncoder(&encoded)
 	// bytes.Buffer.Write never errors; enc.Close flushes trailing padding.
-	_, _ = enc.Write(compressed)
+	_, _ = enc.Write(data)
 	_ = enc.Close()
-
 	return encoded.String()
 }
 
-func decompress(encoded string) ([]byte, error) {
-	if encoded == "" {
-		return nil, nil
-	}
-
+// decode85 reverses encode85.
+func decode85(encoded string) ([]byte, error) {
 	dec := ascii85.NewDecoder(bytes.NewReader([]byte(encoded)))
-	compressed, err := io.ReadAll(dec)
+	data, err := io.ReadAll(dec)
 	if err != nil {
 		return nil, fmt.Errorf("%w: ascii85: %w", ErrDecompress, err)
 	}
-
-	out, err := zstdDecoder.DecodeAll(compressed, nil)
-	if err != nil {
-		return nil, fmt.Errorf("%w: zstd: %w", ErrDecompress, err)
-	}
-	return out, nil
+	return data, nil
 }
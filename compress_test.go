@@ -15,6 +15,8 @@ package folio
 
 import (
 	"bytes"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -154,3 +156,69 @@ func TestCompressBinaryData(t *testing.T) {
 		t.Error("binary data round trip failed")
 	}
 }
+
+// TestCompressionCodecs verifies that each codec stores and reads back
+// history, in the file and in memory, plain and encrypted, and through
+// SetReader; that the file keeps its codec when reopened with another;
+// and that CodecNone leaves the content readable in _h.
+func TestCompressionCodecs(t *testing.T) {
+	content := []string{"first version", strings.Repeat("second version, longer ", 40), "\xff\x00binary"}
+	for _, c := range []Compression{{}, {Level: 19}, {Codec: CodecLZ4}, {Codec: CodecNone}} {
+		for _, key := range [][]byte{nil, testKey} {
+			path := filepath.Join(t.TempDir(), "test.folio")
+			db, err := Open(path, Config{Compression: c, EncryptionKey: key})
+			if err != nil {
+				t.Fatalf("%+v: Open: %v", c, err)
+			}
+			for _, v := range content[:2] {
+				db.Set("doc", v)
+			}
+			if err := db.SetReader("doc", strings.NewReader(content[2])); err != nil {
+				t.Fatalf("%+v: SetReader: %v", c, err)
+			}
+			if err := db.Compact(); err != nil {
+				t.Fatalf("%+v: Compact: %v", c, err)
+			}
+			db.Close()
+
+			// Another codec in the config does not change the file's.
+			db, err = Open(path, Config{Compression: Compression{Codec: (c.Codec + 1) % 3}, EncryptionKey: key})
+			if err != nil {
+				t.Fatalf("%+v: reopen: %v", c, err)
+			}
+			if db.header.Codec != c.Codec {
+				t.Errorf("%+v: header codec after reopening = %d", c, db.header.Codec)
+			}
+			db.Set("doc", "after reopening")
+			versions, err := Collect(db.History("doc"))
+			if err != nil || len(versions) != 4 {
+				t.Fatalf("%+v: History = %d versions, %v", c, len(versions), err)
+			}
+			for i, v := range append(content, "after reopening") {
+				if versions[i].Data != v {
+					t.Errorf("%+v: version %d = %q, want %q", c, i, versions[i].Data, v)
+				}
+			}
+			if r := mustVerify(t, db, VerifyOptions{}); !r.OK() {
+				t.Errorf("%+v: Verify: %v", c, r.Problems)
+			}
+			db.Close()
+		}
+	}
+
+	db, err := Open(":memory:", Config{Compression: Compression{Codec: CodecNone}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Set("doc", "plain words")
+	_, idx, _ := db.findIndex(db.id("doc"), "doc", db.tail)
+	ln, _ := line(db.reader, idx.Offset)
+	if r, _ := parse(ln); r.History != encode85([]byte("plain words")) {
+		t.Errorf("_h under CodecNone = %q, want the content in Ascii85", r.History)
+	}
+
+	if _, err := Open(":memory:", Config{Compression: Compression{Codec: 9}}); err == nil {
+		t.Error("Open with an unknown codec succeeded")
+	}
+}
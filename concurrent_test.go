@@ -9,8 +9,8 @@
 //  1. Readers never see torn writes (partially-written JSONL lines).
 //  2. Close wakes all waiting goroutines so they return ErrClosed rather than
 //     hanging forever.
-//  3. Compaction (which transitions to StateRead, blocking writers) does not
-//     starve concurrent readers.
+//  3. Compaction, which releases its read lock between chunks, starves
+//     neither concurrent readers nor writers.
 //
 // All tests use the -race detector implicitly via `go test -race`, which
 // catches data races on the shared file handles and header fields that would
@@ -18,8 +18,13 @@
 package folio
 
 import (
+	"errors"
+	"fmt"
+	"iter"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 )
 
 // TestConcurrentReads verifies that multiple goroutines can call Get
@@ -97,7 +102,7 @@ func TestConcurrentReadWrite(t *testing.T) {
 		wg.Go(func() {
 			for range 50 {
 				_, err := db.Get("doc")
-				if err != nil && err != ErrNotFound {
+				if err != nil && !errors.Is(err, ErrNotFound) {
 					t.Errorf("Get: %v", err)
 					return
 				}
@@ -152,7 +157,7 @@ func TestCloseWakesWaiters(t *testing.T) {
 	close(errChan)
 
 	for err := range errChan {
-		if err != ErrClosed {
+		if !errors.Is(err, ErrClosed) {
 			t.Errorf("expected ErrClosed, got %v", err)
 		}
 	}
@@ -174,7 +179,7 @@ func TestConcurrentList(t *testing.T) {
 	for range 10 {
 		wg.Go(func() {
 			for range 50 {
-				labels, err := collect(db.List())
+				labels, err := Collect(db.List())
 				if err != nil {
 					t.Errorf("List: %v", err)
 					return
@@ -215,18 +220,17 @@ func TestCloseWakesWriteWaiters(t *testing.T) {
 	close(errChan)
 
 	for err := range errChan {
-		if err != ErrClosed {
+		if !errors.Is(err, ErrClosed) {
 			t.Errorf("Set during close: got %v, want ErrClosed", err)
 		}
 	}
 }
 
 // TestConcurrentCompactRead verifies that readers continue to succeed
-// while compaction is in progress. Compact transitions the state to
-// StateRead (blocking writers but allowing readers), rebuilds the file,
-// then restores StateAll. If the state transition were wrong — e.g.
-// moving to StateNone instead of StateRead — all concurrent Get calls
-// would block until compaction finishes, creating a latency spike
+// while compaction is in progress. Compact rebuilds the file under the
+// read lock and takes the write lock only to swap. If it blocked readers
+// instead — e.g. by moving the state to StateNone — all concurrent Get
+// calls would block until compaction finishes, creating a latency spike
 // proportional to the database size.
 func TestConcurrentCompactRead(t *testing.T) {
 	db := openTestDB(t)
@@ -250,7 +254,7 @@ func TestConcurrentCompactRead(t *testing.T) {
 			label := string(rune('a' + n))
 			for range 20 {
 				_, err := db.Get(label)
-				if err != nil && err != ErrNotFound {
+				if err != nil && !errors.Is(err, ErrNotFound) {
 					t.Errorf("Get during compact: %v", err)
 					return
 				}
@@ -260,3 +264,93 @@ func TestConcurrentCompactRead(t *testing.T) {
 
 	wg.Wait()
 }
+
+// TestConcurrentCompactWrite verifies that writes complete while a
+// rebuild is still copying, and that every one of them is in the file
+// it swaps in. The rebuild is throttled to a few tenths of a second and
+// copies in small chunks; if it held writers off for the whole copy, as
+// a single pass under the read lock does, no write would finish before
+// it did.
+func TestConcurrentCompactWrite(t *testing.T) {
+	db := openProgress(t)
+	compacted := make(chan struct{})
+	rate := (db.tail - HeaderSize) * 3 // about a third of a second
+	go func() {
+		defer close(compacted)
+		if err := db.Repair(&CompactOptions{MaxBytesPerSecond: rate, ChunkBytes: 64 << 10}); err != nil {
+			t.Errorf("Repair: %v", err)
+		}
+	}()
+
+	time.Sleep(20 * time.Millisecond) // let the rebuild begin
+	written, early := 0, 0
+	for ; written < 20; written++ {
+		if err := db.Set(fmt.Sprintf("during-%02d", written), "content"); err != nil {
+			t.Fatalf("Set during rebuild: %v", err)
+		}
+		select {
+		case <-compacted:
+		default:
+			early++
+		}
+	}
+	<-compacted
+	if early == 0 {
+		t.Error("no write finished before the rebuild did")
+	}
+	if db.Count() != 30+written {
+		t.Errorf("Count = %d, want %d", db.Count(), 30+written)
+	}
+	for i := range written {
+		if got, err := db.Get(fmt.Sprintf("during-%02d", i)); err != nil || got != "content" {
+			t.Errorf("Get(during-%02d) = %q, %v", i, got, err)
+		}
+	}
+	if r := mustVerify(t, db, VerifyOptions{}); !r.OK() {
+		t.Errorf("Verify: %v", r.Problems)
+	}
+}
+
+// TestMaxConcurrentScans verifies that a second full-file scan waits for
+// a free slot while point reads carry on unaffected. If the semaphore
+// were acquired after the read lock, a queued scan would still let
+// point reads through but would stall writers; if it were missing,
+// heavy scan traffic could saturate the disk.
+func TestMaxConcurrentScans(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{MaxConcurrentScans: 1})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	db.Set("a", "x")
+	db.Set("b", "y")
+
+	// Hold the only slot by pausing an All iteration mid-scan.
+	next, stop := iter.Pull2(db.All())
+	if _, err, ok := next(); !ok || err != nil {
+		t.Fatalf("All: ok=%v err=%v", ok, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		Collect(db.Search("x", SearchOptions{}))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Search ran while the only scan slot was taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, err := db.Get("a"); err != nil {
+		t.Errorf("Get during queued scan: %v", err)
+	}
+
+	stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Search never ran after the slot was released")
+	}
+}
@@ -9,9 +9,11 @@
 package folio
 
 import (
+	"errors"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestConfigSyncWrites verifies that SyncWrites=true is propagated to
@@ -147,3 +149,111 @@ func TestVeryLargeContent(t *testing.T) {
 		t.Errorf("len = %d, want %d", len(data), len(content))
 	}
 }
+
+// TestConfigReadOnly verifies that a read-only handle can read a file
+// another handle is writing, observes that handle's later writes, and
+// rejects every mutation with ErrReadOnly. If a read-only handle could
+// write, an inspection tool pointed at a production file could corrupt it.
+func TestConfigReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	rw, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer rw.Close()
+	rw.Set("doc", "v1")
+
+	ro, err := Open(path, Config{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Open read-only: %v", err)
+	}
+	defer ro.Close()
+
+	if got, _ := ro.Get("doc"); got != "v1" {
+		t.Errorf("Get = %q, want %q", got, "v1")
+	}
+
+	rw.Set("doc", "v2")
+	if got, _ := ro.Get("doc"); got != "v2" {
+		t.Errorf("Get after concurrent write = %q, want %q", got, "v2")
+	}
+
+	if err := ro.Set("doc", "x"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Set = %v, want ErrReadOnly", err)
+	}
+	if err := ro.Delete("doc"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Delete = %v, want ErrReadOnly", err)
+	}
+	if err := ro.Compact(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Compact = %v, want ErrReadOnly", err)
+	}
+	if err := ro.Rehash(AlgFNV1a); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Rehash = %v, want ErrReadOnly", err)
+	}
+}
+
+// TestConfigReadOnlyMissingFile verifies that a read-only Open does not
+// create the file. Creating it would turn a typo in an inspection
+// command into a new empty database on disk.
+func TestConfigReadOnlyMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.folio")
+	if _, err := Open(path, Config{ReadOnly: true}); err == nil {
+		t.Fatal("Open read-only on missing file should fail")
+	}
+	if matches, _ := filepath.Glob(path); len(matches) != 0 {
+		t.Error("read-only Open created the file")
+	}
+}
+
+// TestConfigLabelValidator verifies that Config.LabelValidator rejects
+// labels on every write path with an error matching both ErrInvalidLabel
+// and the validator's own error, and that labels it accepts still work.
+func TestConfigLabelValidator(t *testing.T) {
+	errPrefix := errors.New("label must start with doc/")
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{
+		LabelValidator: func(label string) error {
+			if !strings.HasPrefix(label, "doc/") {
+				return errPrefix
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Set("doc/a", "x"); err != nil {
+		t.Fatalf("Set(doc/a): %v", err)
+	}
+	writes := map[string]func() error{
+		"Set":        func() error { return db.Set("bad", "x") },
+		"Create":     func() error { return db.Create("bad", "x") },
+		"SetWithTTL": func() error { return db.SetWithTTL("bad", "x", time.Hour) },
+		"SetReader":  func() error { return db.SetReader("bad", strings.NewReader("x")) },
+		"Batch":      func() error { return db.Batch(Document{Label: "doc/b", Data: "x"}, Document{Label: "bad", Data: "x"}) },
+		"Rename":     func() error { return db.Rename("doc/a", "bad") },
+		"Txn.Set":    func() error { return db.Txn(func(tx *Txn) error { return tx.Set("bad", "x") }) },
+		"Txn.Rename": func() error { return db.Txn(func(tx *Txn) error { return tx.Rename("doc/a", "bad") }) },
+	}
+	for name, write := range writes {
+		err := write()
+		if !errors.Is(err, ErrInvalidLabel) || !errors.Is(err, errPrefix) {
+			t.Errorf("%s = %v, want ErrInvalidLabel wrapping the validator's error", name, err)
+		}
+	}
+	if _, err := db.Get("doc/b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(doc/b) = %v, want ErrNotFound: rejected Batch wrote", err)
+	}
+	if _, err := db.Get("doc/a"); err != nil {
+		t.Errorf("Get(doc/a) = %v, want the unrenamed document", err)
+	}
+
+	// The built-in checks still come first.
+	if err := db.Set(`doc/"`, "x"); !errors.Is(err, ErrInvalidLabel) || errors.Is(err, errPrefix) {
+		t.Errorf(`Set(doc/") = %v, want the built-in ErrInvalidLabel`, err)
+	}
+	if err := db.Rename("doc/a", "doc/c"); err != nil {
+		t.Errorf("Rename to a valid label: %v", err)
+	}
+}
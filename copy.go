@@ -0,0 +1,135 @@
+// Document copying.
+//
+// Copy writes a document's current content under a new label without
+// the caller reading it first: a Get followed by a Set can interleave
+// with another writer, so the copy may not be of the version that was
+// read, and it never carries the history. Both steps here happen under
+// one hold of the write lock. CopyWithHistory also rewrites every
+// earlier version under the new label, keeping their timestamps, as
+// Transfer does when it moves a document between files.
+//
+// The copy is a new document: it is not tagged, does not expire, and
+// the source is left as it was.
+package folio
+
+import (
+	"fmt"
+	"time"
+)
+
+// Copy writes the current content of src as a new document at dst.
+// Returns ErrNotFound if src does not exist, or ErrExists if dst does.
+func (db *DB) Copy(src, dst string) error {
+	return db.copyDoc(src, dst, false)
+}
+
+// CopyWithHistory is Copy that also gives dst every earlier version of
+// src, with its original timestamp.
+func (db *DB) CopyWithHistory(src, dst string) error {
+	return db.copyDoc(src, dst, true)
+}
+
+// copyDoc validates and takes the write lock for Copy and
+// CopyWithHistory.
+func (db *DB) copyDoc(src, dst string, history bool) (err error) {
+	defer db.observe(OpCopy, src, time.Now(), &err)
+
+	if src == "" {
+		return ErrInvalidLabel
+	}
+	if err := db.checkLabel(dst); err != nil {
+		return err
+	}
+
+	if err := db.blockWrite(); err != nil {
+		return err
+	}
+
+	err = db.copy(src, dst, history)
+
+	// Check threshold under lock, compact after release (see set.go).
+	compact := err == nil && db.shouldCompact()
+	events := db.takeEvents()
+	db.mu.Unlock()
+	db.lock.Unlock()
+
+	if err == nil {
+		err = db.durable()
+	}
+	db.after(events)
+	if compact {
+		db.Compact()
+	}
+	return err
+}
+
+// copy performs the copy. The write lock must be held.
+func (db *DB) copy(src, dst string, history bool) error {
+	sz, err := size(db.reader)
+	if err != nil {
+		return fmt.Errorf("copy: stat: %w", err)
+	}
+
+	ts := now()
+	srcResult, idx, err := db.findIndex(db.id(src), src, sz)
+	if err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+	if srcResult == nil || idx.expired(ts) {
+		return ErrNotFound
+	}
+
+	id := db.id(dst)
+	prev, old, err := db.findIndex(id, dst, sz)
+	if err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+	if prev != nil && !old.expired(ts) {
+		return ErrExists
+	}
+
+	var versions []Version
+	created := ts
+	if history {
+		if versions, err = db.versions(idx.Label); err != nil {
+			return fmt.Errorf("copy: %w", err)
+		}
+		if len(versions) == 0 {
+			return fmt.Errorf("copy: %s: %w", src, ErrCorruptRecord)
+		}
+		// Files written before _c existed fall back to the oldest version.
+		if created = idx.Created; created == 0 {
+			created = versions[0].TS
+		}
+	} else {
+		data, err := line(db.reader, idx.Offset)
+		if err != nil {
+			return fmt.Errorf("copy: read record: %w", faultAt(src, idx.Offset, err))
+		}
+		record, err := db.decode(data)
+		if err != nil {
+			return fmt.Errorf("copy: %w", faultAt(src, idx.Offset, err))
+		}
+		versions = []Version{{Data: record.Data, TS: ts}}
+	}
+
+	content := versions[len(versions)-1].Data
+	if err := db.beforeSet(dst, content); err != nil {
+		return err
+	}
+	buf, err := db.encodeDoc(nil, db.tail, id, dst, versions, created, 0)
+	if err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+	// raw() appends the final newline.
+	if _, err := db.raw(buf[:len(buf)-1]); err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+
+	// An expired document at dst is retired as Set would retire it.
+	if err := db.supersede(id, dst, 0, prev, old); err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+	db.wroteSet(dst, content)
+	return nil
+}
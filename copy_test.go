@@ -0,0 +1,127 @@
+// Copy tests.
+//
+// A copy is a new document made from another's current version, or from
+// all of its versions. These check that the copy and the source stay
+// independent, that the history is carried only when asked for, and that
+// both survive compaction.
+package folio
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestCopy verifies that Copy writes the source's current content as a
+// new document with a history of its own, leaving the source unchanged,
+// and that later writes to either do not reach the other.
+func TestCopy(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("src", "v1")
+	time.Sleep(2 * time.Millisecond)
+	db.Set("src", "v2")
+	db.Tag("src", "red")
+
+	if err := db.Copy("src", "dst"); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	if data, err := db.Get("dst"); err != nil || data != "v2" {
+		t.Errorf("Get(dst) = %q, %v, want v2", data, err)
+	}
+	if versions, _ := Collect(db.History("dst")); len(versions) != 1 {
+		t.Errorf("History(dst) has %d versions, want 1", len(versions))
+	}
+	if got := byTag(t, db, "red"); !slices.Equal(got, []string{"src"}) {
+		t.Errorf("ByTag(red) = %v, want only src", got)
+	}
+	if db.Count() != 2 {
+		t.Errorf("Count = %d, want 2", db.Count())
+	}
+
+	db.Set("dst", "changed")
+	if data, _ := db.Get("src"); data != "v2" {
+		t.Errorf("Get(src) after writing dst = %q, want v2", data)
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if data, _ := db.Get("dst"); data != "changed" {
+		t.Errorf("Get(dst) after compaction = %q, want changed", data)
+	}
+	mustVerify(t, db, VerifyOptions{})
+}
+
+// TestCopyWithHistory verifies that the copy receives every version of
+// the source with its timestamp, and that binary content is copied
+// byte for byte.
+func TestCopyWithHistory(t *testing.T) {
+	db := openTestDB(t)
+	for _, v := range []string{"v1", "v2", "\xff\x00v3"} {
+		db.Set("src", v)
+		time.Sleep(2 * time.Millisecond)
+	}
+	want, _ := Collect(db.History("src"))
+
+	if err := db.CopyWithHistory("src", "dst"); err != nil {
+		t.Fatalf("CopyWithHistory: %v", err)
+	}
+	check := func(when string) {
+		t.Helper()
+		got, err := Collect(db.History("dst"))
+		if err != nil {
+			t.Fatalf("%s: History(dst): %v", when, err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: History(dst) = %v, want %v", when, got, want)
+		}
+		if data, _ := db.Get("dst"); data != "\xff\x00v3" {
+			t.Errorf("%s: Get(dst) = %q, want the binary version", when, data)
+		}
+	}
+	check("appended")
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	check("compacted")
+	mustVerify(t, db, VerifyOptions{})
+}
+
+// TestCopyErrors verifies that Copy needs a current source and a free
+// destination, that an expired document does not count as either, and
+// that a failed copy writes nothing.
+func TestCopyErrors(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("a", "x")
+	db.Set("b", "y")
+
+	if err := db.Copy("missing", "c"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Copy(missing) = %v, want ErrNotFound", err)
+	}
+	if err := db.Copy("a", "b"); !errors.Is(err, ErrExists) {
+		t.Errorf("Copy onto b = %v, want ErrExists", err)
+	}
+	if err := db.Copy("a", "a"); !errors.Is(err, ErrExists) {
+		t.Errorf("Copy onto itself = %v, want ErrExists", err)
+	}
+	if err := db.Copy("a", `c"`); !errors.Is(err, ErrInvalidLabel) {
+		t.Errorf(`Copy onto c" = %v, want ErrInvalidLabel`, err)
+	}
+	if data, _ := db.Get("b"); data != "y" {
+		t.Errorf("Get(b) = %q, want y", data)
+	}
+
+	db.SetWithTTL("old", "gone", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if err := db.Copy("old", "c"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Copy(expired) = %v, want ErrNotFound", err)
+	}
+	if err := db.Copy("a", "old"); err != nil {
+		t.Fatalf("Copy onto an expired document: %v", err)
+	}
+	if data, _ := db.Get("old"); data != "x" {
+		t.Errorf("Get(old) = %q, want x", data)
+	}
+	mustVerify(t, db, VerifyOptions{})
+}
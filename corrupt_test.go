@@ -17,9 +17,9 @@
 // that damages the middle of a record while leaving the header intact.
 //
 // Type-mismatch injection (raw with "_o":"bad"): The sparse scanner
-// pre-validates each line by calling decode() into a Record struct.
+// pre-validates each line by calling parse() into a Record struct.
 // Record has no _o field, so a string value for _o is silently ignored
-// and decode succeeds. But when the caller then calls decodeIndex() into
+// and parse succeeds. But when the caller then calls decodeIndex() into
 // an Index struct, _o maps to Offset (int64) and the string value causes
 // an unmarshal error. This is the only way to reach the decodeIndex error
 // path after a sparse scan, because sparse already filters out lines with
@@ -32,6 +32,8 @@ import (
 	"errors"
 	"fmt"
 	"testing"
+
+	json "github.com/goccy/go-json"
 )
 
 // --- Get ---
@@ -330,7 +332,7 @@ func TestHistoryCorruptRecord(t *testing.T) {
 
 	db.writeAt(HeaderSize+34, []byte("!!!!"))
 
-	_, err := collect(db.History("doc"))
+	_, err := Collect(db.History("doc"))
 	if !errors.Is(err, ErrCorruptRecord) {
 		t.Errorf("got %v, want ErrCorruptRecord", err)
 	}
@@ -358,7 +360,7 @@ func TestHistoryCorruptHistory(t *testing.T) {
 	}
 	db.writeAt(HeaderSize+int64(i)+6, []byte("AAAAA"))
 
-	_, err := collect(db.History("doc"))
+	_, err := Collect(db.History("doc"))
 	if !errors.Is(err, ErrDecompress) {
 		t.Errorf("got %v, want ErrDecompress", err)
 	}
@@ -385,7 +387,7 @@ func TestHistoryCorruptLabel(t *testing.T) {
 	// Overwrite "doc" with "zzz" — same length, different label.
 	db.writeAt(HeaderSize+int64(i)+6, []byte("zzz"))
 
-	versions, err := collect(db.History("doc"))
+	versions, err := Collect(db.History("doc"))
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -408,7 +410,7 @@ func TestHistoryCorruptType(t *testing.T) {
 	// TypePos of the record is the type digit. Change '2' to '1'.
 	db.writeAt(HeaderSize+TypePos, []byte("1"))
 
-	versions, err := collect(db.History("doc"))
+	versions, err := Collect(db.History("doc"))
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -437,7 +439,7 @@ func TestGroupSkipsInvalidRecord(t *testing.T) {
 	// false, so group() skips it. The second record (v2) is untouched.
 	db.writeAt(HeaderSize, []byte(" "))
 
-	versions, err := collect(db.History("doc"))
+	versions, err := Collect(db.History("doc"))
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -462,7 +464,7 @@ func TestListCorruptIndexStillReturnsLabel(t *testing.T) {
 	bad := fmt.Sprintf(`{"_r":1,"_id":"%s","_ts":1234567890123,"_o":"bad","_l":"doc2"}`, id)
 	db.raw([]byte(bad))
 
-	labels, err := collect(db.List())
+	labels, err := Collect(db.List())
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -494,3 +496,110 @@ func TestDecompressInvalidZstd(t *testing.T) {
 		t.Errorf("got %v, want ErrDecompress", err)
 	}
 }
+
+// --- Checksums ---
+//
+// A flipped byte inside a string value leaves the JSON valid, so only the
+// _k checksum can catch it. These tests damage content without breaking
+// the syntax and expect every read path to refuse the result.
+
+// flipContent swaps the first byte of a record's _d value for another
+// letter, keeping the line valid JSON.
+func flipContent(t *testing.T, db *DB, off int64) {
+	t.Helper()
+	data, _ := line(db.reader, off)
+	i := bytes.Index(data, []byte(`"_d":"`))
+	if i == -1 {
+		t.Fatal("could not locate _d field")
+	}
+	pos := i + len(`"_d":"`)
+	db.writeAt(off+int64(pos), []byte{data[pos] ^ 0x01})
+}
+
+// TestGetChecksumMismatch verifies Get reports damaged content rather
+// than returning it. Before checksums this returned "dontent" silently.
+func TestGetChecksumMismatch(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "content")
+	db.Compact()
+	flipContent(t, db, HeaderSize)
+
+	_, err := db.Get("doc")
+	if !errors.Is(err, ErrChecksum) || !errors.Is(err, ErrCorruptRecord) {
+		t.Errorf("got %v, want ErrChecksum wrapping ErrCorruptRecord", err)
+	}
+}
+
+// TestAllChecksumMismatch verifies All reports the damaged document by
+// label and still yields the healthy ones, so one bad record does not
+// hide the rest of the file from an export.
+func TestAllChecksumMismatch(t *testing.T) {
+	db := openTestDB(t)
+	off := db.tail
+	db.Set("bad", "content")
+	db.Set("good", "content")
+	flipContent(t, db, off)
+
+	var good int
+	var bad error
+	for doc, err := range db.All() {
+		if err != nil {
+			bad = err
+			continue
+		}
+		if doc.Label == "good" {
+			good++
+		}
+	}
+	if !errors.Is(bad, ErrChecksum) {
+		t.Errorf("All error = %v, want ErrChecksum", bad)
+	}
+	if good != 1 {
+		t.Errorf("healthy documents yielded = %d, want 1", good)
+	}
+}
+
+// TestHistoryChecksumMismatch verifies retired versions are checked
+// against their decompressed snapshot. The _d of a history record is
+// blank, so the checksum must cover content, not line bytes.
+func TestHistoryChecksumMismatch(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "v1")
+	db.Set("doc", "v2")
+
+	// Rewrite one digit of the retired record's _k. Its _h is intact, so
+	// only the checksum comparison can notice.
+	data, _ := line(db.reader, HeaderSize)
+	i := bytes.Index(data, []byte(`"_k":`)) + len(`"_k":`)
+	digit := byte('1')
+	if data[i] == '1' {
+		digit = '2'
+	}
+	db.writeAt(HeaderSize+int64(i), []byte{digit})
+
+	_, err := Collect(db.History("doc"))
+	if !errors.Is(err, ErrChecksum) {
+		t.Errorf("got %v, want ErrChecksum", err)
+	}
+}
+
+// TestLegacyRecordWithoutChecksum verifies records written before _k
+// existed are still readable. Treating a missing checksum as a mismatch
+// would make every existing file unreadable after upgrading.
+func TestLegacyRecordWithoutChecksum(t *testing.T) {
+	db := openTestDB(t)
+	id := hash("old", db.header.Algorithm)
+	rec, _ := json.Marshal(&Record{Type: TypeRecord, ID: id, Timestamp: 1234567890123, Label: "old", Data: "legacy", History: compress([]byte("legacy"))})
+	if bytes.Contains(rec, []byte(`"_k"`)) {
+		t.Fatal("zero checksum should be omitted")
+	}
+	recOff, _ := db.raw(rec)
+	db.raw([]byte(fmt.Sprintf(`{"_r":1,"_id":"%s","_ts":1234567890123,"_o":%d,"_l":"old"}`, id, recOff)))
+
+	if data, err := db.Get("old"); err != nil || data != "legacy" {
+		t.Errorf("Get = %q, %v", data, err)
+	}
+	if versions, err := Collect(db.History("old")); err != nil || len(versions) != 1 {
+		t.Errorf("History = %v, %v", versions, err)
+	}
+}
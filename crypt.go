@@ -0,0 +1,189 @@
+// At-rest encryption of document content.
+//
+// With Config.EncryptionKey set, every record written carries its content
+// encrypted with XChaCha20-Poly1305 and is flagged with _x. The _d field
+// holds base64(nonce || ciphertext) of the plain content; the _h field
+// holds the Ascii85 encoding of nonce || ciphertext of the Zstd-compressed
+// content, compressing first because ciphertext does not compress. Each
+// encryption uses a fresh random 24-byte nonce, which XChaCha20 makes safe
+// without a counter.
+//
+// Only content is protected. The header, labels, IDs, timestamps, and
+// index records stay plaintext so lookups, binary search, and compaction
+// work unchanged. No additional data is bound into the ciphertext, so
+// byte-level patches such as a same-length Rename of _l keep working.
+// The _k checksum covers the plaintext and is verified after decryption.
+//
+// Records written before a key was configured are still read as plain
+// text; they are re-encrypted only when next rewritten by Set or Rename.
+// Compaction copies records as they are and never re-encrypts; Clone
+// with CloneOptions.EncryptionKey writes a copy with every record sealed
+// under a new key.
+package folio
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"unicode/utf8"
+
+	json "github.com/goccy/go-json"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// EncryptionKeySize is the required length of Config.EncryptionKey.
+const EncryptionKeySize = chacha20poly1305.KeySize
+
+// newCipher builds the AEAD for a key, or returns nil for no key.
+func newCipher(key []byte) (cipher.AEAD, error) {
+	if key == nil {
+		return nil, nil
+	}
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", EncryptionKeySize, len(key))
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+// encrypt returns nonce || ciphertext.
+func encrypt(aead cipher.AEAD, plain []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	// crypto/rand.Read never returns an error.
+	rand.Read(nonce)
+	return aead.Seal(nonce, nonce, plain, nil)
+}
+
+// decrypt reverses encrypt. Fails with ErrDecrypt when no key is set,
+// the key is wrong, or the ciphertext has been altered.
+func decrypt(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if aead == nil {
+		return nil, fmt.Errorf("%w: no encryption key", ErrDecrypt)
+	}
+	n := aead.NonceSize()
+	if len(sealed) < n {
+		return nil, fmt.Errorf("%w: truncated", ErrDecrypt)
+	}
+	plain, err := aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+	return plain, nil
+}
+
+// decrypt64 decrypts the base64 _d value of an encrypted record.
+func decrypt64(aead cipher.AEAD, data []byte) ([]byte, error) {
+	sealed, err := unbase64(data)
+	if err != nil {
+		return nil, err
+	}
+	return decrypt(aead, sealed)
+}
+
+// encrypted reports whether a record line holds encrypted content. As
+// with binary, the marker cannot occur inside an escaped string value.
+func encrypted(line []byte) bool {
+	return bytes.LastIndex(line, []byte(`"_x":true`)) >= 0
+}
+
+// seal prepares a record for writing from its plain content: it stamps
+// the checksum, fills _h with the compressed snapshot, and for a data
+// record fills _d. Content is encrypted when a key is configured, and is
+// otherwise base64-encoded if JSON cannot carry it verbatim.
+func (db *DB) seal(r *Record, content string) {
+	db.sealWith(r, content, db.cipher)
+}
+
+// sealWith is seal with aead in place of the handle's key.
+func (db *DB) sealWith(r *Record, content string, aead cipher.AEAD) {
+	r.Checksum = checksum([]byte(content))
+	if aead != nil {
+		r.Encrypted = true
+		if content != "" {
+			r.History = encode85(encrypt(aead, db.codec.encode([]byte(content))))
+		}
+		if r.Type == TypeRecord {
+			r.Data = base64.StdEncoding.EncodeToString(encrypt(aead, []byte(content)))
+		}
+		return
+	}
+
+	r.History = db.codec.compress([]byte(content))
+	if r.Type == TypeRecord {
+		r.Data = content
+		if !utf8.ValidString(content) {
+			r.Binary = true
+			r.Data = base64.StdEncoding.EncodeToString([]byte(content))
+		}
+	}
+}
+
+// reseal returns a data or history record line with its content sealed
+// under aead instead of the handle's key, as Clone writes it. A delta
+// keeps its patch and the checksum of the version it restores.
+func (db *DB) reseal(line []byte, aead cipher.AEAD) ([]byte, error) {
+	r, err := parse(line)
+	if err != nil {
+		return nil, err
+	}
+	var content []byte
+	if r.Type == TypeRecord {
+		d, err := db.decode(line)
+		if err != nil {
+			return nil, err
+		}
+		content = []byte(d.Data)
+	} else if content, err = db.snapshot(r); err != nil {
+		return nil, err
+	}
+	rec := &Record{Type: r.Type, ID: r.ID, Timestamp: r.Timestamp, Label: r.Label}
+	db.sealWith(rec, string(content), aead)
+	if r.Delta {
+		rec.Checksum, rec.Delta = r.Checksum, true
+	}
+	return json.Marshal(rec)
+}
+
+// resealDicts returns the stored dictionaries sealed under aead instead
+// of the handle's key.
+func (db *DB) resealDicts(dicts []Dict, aead cipher.AEAD) ([]Dict, error) {
+	out := make([]Dict, len(dicts))
+	for i, d := range dicts {
+		raw, err := decode85(d.Data)
+		if err == nil && d.Encrypted {
+			raw, err = decrypt(db.cipher, raw)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("dictionary %d: %w", i, err)
+		}
+		out[i] = Dict{Data: encode85(raw)}
+		if aead != nil {
+			out[i] = Dict{Data: encode85(encrypt(aead, raw)), Encrypted: true}
+		}
+	}
+	return out, nil
+}
+
+// snapshot returns the decompressed (and if necessary decrypted) content
+// of a record's _h field, or of its body's if it is shared.
+func (db *DB) snapshot(r *Record) ([]byte, error) {
+	if r.Shared != 0 {
+		return db.body(r.Shared, r.Checksum)
+	}
+	if !r.Encrypted {
+		return db.codec.decompress(r.History)
+	}
+	if r.History == "" {
+		return nil, nil
+	}
+	sealed, err := decode85(r.History)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := decrypt(db.cipher, sealed)
+	if err != nil {
+		return nil, err
+	}
+	return db.codec.decode(compressed)
+}
@@ -0,0 +1,122 @@
+// Encryption tests.
+//
+// Encrypted content must never reach the disk in plain text, must come
+// back byte for byte through every read path, and must fail loudly with
+// the wrong key instead of returning garbage.
+package folio
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, EncryptionKeySize)
+
+// TestEncryptionRoundTrip verifies Get, All, History, and Search all see
+// plain content while the file holds none of it, before and after
+// compaction.
+func TestEncryptionRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{EncryptionKey: testKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Set("doc", "secret one")
+	db.Set("doc", `secret "two"`)
+	db.SetBytes("bin", []byte{0xff, 0x00})
+
+	check := func(stage string) {
+		raw, _ := os.ReadFile(path)
+		if bytes.Contains(raw, []byte("secret")) {
+			t.Errorf("%s: plain text found on disk", stage)
+		}
+		if data, err := db.Get("doc"); err != nil || data != `secret "two"` {
+			t.Errorf("%s: Get = %q, %v", stage, data, err)
+		}
+		if b, _ := db.GetBytes("bin"); !bytes.Equal(b, []byte{0xff, 0x00}) {
+			t.Errorf("%s: GetBytes = %v", stage, b)
+		}
+		docs, err := Collect(db.All())
+		if err != nil || len(docs) != 2 {
+			t.Errorf("%s: All = %v, %v", stage, docs, err)
+		}
+		versions, err := Collect(db.History("doc"))
+		if err != nil || len(versions) != 2 || versions[0].Data != "secret one" {
+			t.Errorf("%s: History = %v, %v", stage, versions, err)
+		}
+		matches, err := Collect(db.Search(`"two"`, SearchOptions{}))
+		if err != nil || len(matches) != 1 || matches[0].Label != "doc" {
+			t.Errorf("%s: Search = %v, %v", stage, matches, err)
+		}
+	}
+	check("sparse")
+	db.Compact()
+	check("compacted")
+}
+
+// TestEncryptionWrongKey verifies reads with a missing or different key
+// report ErrDecrypt. Authenticated encryption makes this detectable; a
+// bare cipher would decrypt to noise.
+func TestEncryptionWrongKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, _ := Open(path, Config{EncryptionKey: testKey})
+	db.Set("doc", "secret")
+	db.Close()
+
+	other := bytes.Repeat([]byte{0x17}, EncryptionKeySize)
+	for name, key := range map[string][]byte{"none": nil, "other": other} {
+		t.Run(name, func(t *testing.T) {
+			db, err := Open(path, Config{EncryptionKey: key})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if _, err := db.Get("doc"); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("Get = %v, want ErrDecrypt", err)
+			}
+			if _, err := Collect(db.History("doc")); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("History = %v, want ErrDecrypt", err)
+			}
+			if matches, _ := Collect(db.Search("secret", SearchOptions{})); len(matches) != 0 {
+				t.Errorf("Search matched ciphertext: %v", matches)
+			}
+		})
+	}
+}
+
+// TestEncryptionKeySize verifies a key of the wrong length is rejected
+// at Open rather than failing on the first write.
+func TestEncryptionKeySize(t *testing.T) {
+	_, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{EncryptionKey: []byte("short")})
+	if err == nil {
+		t.Error("Open accepted a 5-byte key")
+	}
+}
+
+// TestEncryptionMixedRecords verifies a file written without a key stays
+// readable after one is configured, and new writes are encrypted.
+func TestEncryptionMixedRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, _ := Open(path, Config{})
+	db.Set("old", "plain")
+	db.Close()
+
+	db, _ = Open(path, Config{EncryptionKey: testKey})
+	defer db.Close()
+	db.Set("new", "hidden")
+	if data, err := db.Get("old"); err != nil || data != "plain" {
+		t.Errorf("Get(old) = %q, %v", data, err)
+	}
+	if data, err := db.Get("new"); err != nil || data != "hidden" {
+		t.Errorf("Get(new) = %q, %v", data, err)
+	}
+	raw, _ := os.ReadFile(path)
+	if bytes.Contains(raw, []byte("hidden")) {
+		t.Error("new write stored in plain text")
+	}
+}
@@ -17,12 +17,15 @@
 package folio
 
 import (
+	"crypto/cipher"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // State machine values. Transitions are monotonic during shutdown
@@ -43,7 +46,119 @@ type Config struct {
 	MaxRecordSize int  // largest allowed record (default 16MB)
 	SyncWrites    bool // fsync after every write (durability vs throughput)
 	BloomFilter   bool // maintain bloom filter over the sparse region
+	IndexBloom    bool // maintain bloom filter over the sorted index section
+	SparseMap     bool // keep a label→offset map of the sparse region in memory
+	LabelTrie     bool // keep a trie of labels in memory for CountPrefix
+	FullTextIndex bool // keep an inverted word index in memory for SearchText
 	AutoCompact   int  // compact every N writes; persisted to header, 0 = leave stored value unchanged
+	ReadOnly      bool // open without a writer fd; never creates, repairs, or modifies the file
+	MmapReads     bool // memory-map the file for reads (see mmap.go); ignored where unsupported
+	ReaderPool    int  // extra read handles lent to scans (see pool.go); 0 = none
+	CacheBytes    int  // LRU cache of sorted index lookups (see cache.go); 0 = disabled
+	MissCache     int  // remember up to N labels found absent (see miss.go); 0 = disabled
+	RecentDocs    int  // keep the N newest documents in memory for Recent (see recent.go); 0 = disabled
+
+	// MaxDatabaseSize refuses appends that would take the file past N
+	// bytes, and MaxVersionsPerDocument writes that would give a document
+	// more than N versions, with ErrQuotaExceeded (see quota.go). 0 = no
+	// limit.
+	MaxDatabaseSize        int64
+	MaxVersionsPerDocument int
+
+	// CaseInsensitiveLabels makes labels differing only in case name one
+	// document (see fold.go). It applies when the file is created; an
+	// existing file keeps the mode it was created with.
+	CaseInsensitiveLabels bool
+
+	// LabelValidator, if set, is called with every label about to be
+	// written by Set, Batch, Rename, a Txn, Import, or Transfer into this
+	// database, after the built-in checks. A non-nil result rejects the
+	// write with ErrInvalidLabel wrapping it. Labels already in the file
+	// are not checked.
+	LabelValidator func(label string) error
+
+	// Validator, if set, is called with the content of every document
+	// about to be written, after the built-in checks. A non-nil result
+	// rejects the write with a *ValidationError wrapping it and
+	// ErrInvalidContent (see validate.go).
+	Validator func(label string, content []byte) error
+
+	// SyncInterval, if set, replaces per-write fsyncs with group commit:
+	// writes are synced together at most once per interval, and each
+	// write call returns once a sync covering it has finished (see
+	// durable.go). SyncWrites is then ignored.
+	SyncInterval time.Duration
+
+	// MaxConcurrentScans caps how many full-file scans (Search,
+	// MatchLabel, All, List, ListInfo) run at once. Further scans queue
+	// before taking any lock, so point reads and writes are never stuck
+	// behind them. 0 = unlimited.
+	MaxConcurrentScans int
+
+	// LockTimeout bounds how long a call waits for another process to
+	// release the file lock, after which it fails with ErrLockTimeout
+	// (see lock.go). 0 waits as long as it takes.
+	LockTimeout time.Duration
+
+	// PersistUsage saves cumulative operation counters (see Usage) to
+	// the file at Close and resumes them at Open.
+	PersistUsage bool
+
+	// EncryptionKey, if set, must be EncryptionKeySize bytes. Document
+	// content written through this handle is encrypted at rest; labels
+	// and timestamps are not (see crypt.go). The key is not stored, so
+	// every Open of the file must supply the same one.
+	EncryptionKey []byte
+
+	// Compression chooses the codec and level of the compressed snapshots
+	// in _h (see compress.go). The zero value is the fastest Zstd level.
+	Compression Compression
+
+	// HistoryRetention bounds the history kept for each document. It is
+	// enforced whenever the file is rebuilt: Compact, Repair, and auto-
+	// compaction (see retention.go). The zero value keeps everything.
+	HistoryRetention Retention
+
+	// DedupContent makes Compact and Repair store content held by several
+	// current documents once, with the others referring to it (see
+	// dedup.go). Reading a shared document costs a second read.
+	DedupContent bool
+
+	// DeltaHistory makes Compact and Repair store each history version
+	// as a patch on the one before it where that is smaller, with a full
+	// snapshot at regular intervals (see delta.go). Reads are unchanged.
+	// A rebuild without it stores every version in full again.
+	DeltaHistory bool
+
+	// HashTable makes Compact and Repair write a hash table over the
+	// sorted index, so a lookup finds a compacted document's index line
+	// in a read or two rather than a binary search (see hashtable.go).
+	// It costs 56 to 112 bytes per document. A rebuild without it drops
+	// the table.
+	HashTable bool
+
+	// CompactPolicy compacts in the background when the sparse region
+	// or the share of erased index lines grows past a threshold (see
+	// autocompact.go). It works alongside AutoCompact; either may fire.
+	CompactPolicy CompactPolicy
+
+	// MetricsCollector, if set, observes the latency and outcome of
+	// every point operation and compaction (see metrics.go).
+	MetricsCollector Collector
+
+	// Hooks are called around every document write (see hooks.go), for
+	// validation, audit logging, or cache invalidation.
+	Hooks Hooks
+
+	// Logger, if set, receives structured events for opens, crash
+	// recovery, rebuilds, slow lock waits, and corruption (see log.go).
+	Logger *slog.Logger
+
+	// Quarantine makes Get and History that fail on a damaged line move
+	// it to a .quarantine file beside the database, blank it, and read
+	// again, so a document whose current record is damaged reads as
+	// deleted rather than failing (see quarantine.go).
+	Quarantine bool
 }
 
 // DB is an open database handle. Two separate file descriptors are held
@@ -54,28 +169,74 @@ type Config struct {
 type DB struct {
 	root   *os.Root
 	name   string
-	reader *os.File  // read-only fd, shared by concurrent readers (ReadAt is position-independent)
-	writer *os.File  // read-write fd, used for appends and patches
-	lock   *fileLock // OS-level flock on the writer fd (see lock.go)
-	header *Header   // cached, rewritten on Repair/Rehash
+	reader storage    // read-only fd, shared by concurrent readers (ReadAt is position-independent)
+	writer storage    // read-write fd, used for appends and patches
+	lock   *fileLock  // OS-level flock on the writer fd (see lock.go)
+	header *Header    // cached, rewritten on Repair/Rehash
+	width  int64      // length of every index section line; 0 if they vary (see fixed.go)
+	table  *hashTable // hash table over the index section; nil if the file has none
 	config Config
-	bloom  *bloom // nil unless Config.BloomFilter is set
-	tail   int64  // next append position (current end of file)
-	count  atomic.Uint64
-	state  atomic.Int32
+	bloom  *bloom                 // nil unless Config.BloomFilter is set
+	ibloom *bloom                 // nil unless Config.IndexBloom is set
+	smap   *sparseMap             // nil unless Config.SparseMap is set
+	cache  *cache                 // nil unless Config.CacheBytes is set
+	misses *misses                // nil unless Config.MissCache is set
+	recent *recents               // nil unless Config.RecentDocs is set
+	sizes  *sizes                 // nil until ContentBytes or HistoryBytes is first called (see size.go)
+	labels *trie                  // nil unless Config.LabelTrie is set
+	fields map[string]*fieldIndex // secondary indexes by name (see query.go)
+	text   *textIndex             // nil unless Config.FullTextIndex is set
+	scans  chan struct{}          // scan semaphore; nil unless Config.MaxConcurrentScans is set
+	meta   *Meta                  // header extension record; nil if the file has none
+	cipher cipher.AEAD            // content encryption; nil unless Config.EncryptionKey is set
+	log    *slog.Logger           // Config.Logger with the path attached; never nil
+	codec  codec                  // snapshot compression, from the header's _z
+	usage  usage                  // session operation counters
+	ops    ops                    // Get/Set/Delete counts since Open, never persisted
+	tail   int64                  // next append position (current end of file)
+	// lazySync defers the fsync of each write while a transaction
+	// retires many versions or the write queue makes a pass; each syncs
+	// once when done. Write lock only.
+	lazySync  bool
+	sizesMu   sync.Mutex        // serialises the first count of sizes under the read lock
+	events    []hookEvent       // writes awaiting their after hooks; write lock only
+	sidecar   []QuarantinedLine // quarantined lines of a memory database
+	snapshots atomic.Int64      // open Snapshots, each holding a file handle
+	written   atomic.Uint64     // writes awaiting a group sync (SyncInterval)
+	group     groupSync
+	count     atomic.Uint64
+	state     atomic.Int32
 	// cond uses its own mutex, not db.mu, because sync.Cond requires a
 	// plain Locker (Lock/Unlock). Using db.mu.Lock() would block all
 	// readers during state waits. The separate mutex ensures state
 	// transitions don't hold the RWMutex.
 	cond *sync.Cond
 	mu   sync.RWMutex // in-process read/write coordination
+
+	// maint serialises rebuilds, which may now start from the background
+	// compactor as well as from writers and callers.
+	maint   sync.Mutex
+	partial *compaction // CompactStep in progress, nil if none (see incremental.go)
+	// fieldsMu guards the fields map itself, which CreateIndex changes
+	// under the read lock. Writers update the indexes in it under the
+	// write lock.
+	fieldsMu    sync.RWMutex
+	stopCompact chan struct{} // closed by Close; nil unless CompactPolicy is set
+	compactDone chan struct{} // closed when the compactor goroutine exits
+	queue       writeQueue    // SetAsync's writes (see async.go)
+	pool        *readerPool   // nil unless Config.ReaderPool is set (see pool.go)
+	unrecovered bool          // Open's recovery did not finish; Close leaves the file dirty
 }
 
-// Open opens or creates a database at the given path. If a previous
-// session crashed (dirty flag set, or .tmp file left behind), an automatic
-// Repair is attempted under an exclusive lock to restore consistency
-// before returning.
+// Open opens or creates a database at the given path, or in memory if
+// the path is ":memory:" (see memory.go). If a previous
+// session crashed (dirty flag set, or .tmp file left behind), a finished
+// .tmp is promoted if the original cannot be trusted; otherwise an
+// automatic Repair is attempted under an exclusive lock to restore
+// consistency before returning. A file written in a newer format than
+// FormatVersion is refused, untouched, with ErrFormatVersion.
 func Open(path string, config Config) (*DB, error) {
+	start := time.Now()
 	dir := filepath.Dir(path)
 	name := filepath.Base(path)
 	if config.HashAlgorithm == 0 {
@@ -87,6 +248,17 @@ func Open(path string, config Config) (*DB, error) {
 	if config.MaxRecordSize == 0 {
 		config.MaxRecordSize = 16 * 1024 * 1024
 	}
+	aead, err := newCipher(config.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	if !config.Compression.Codec.known() {
+		return nil, fmt.Errorf("unknown compression codec %d", config.Compression.Codec)
+	}
+	log := newLogger(config.Logger, path)
+	if path == memoryPath {
+		return openMemory(config, aead, log, start)
+	}
 
 	root, err := os.OpenRoot(dir)
 	if err != nil {
@@ -94,6 +266,10 @@ func Open(path string, config Config) (*DB, error) {
 	}
 
 	_, err = root.Stat(name)
+	if os.IsNotExist(err) && config.ReadOnly {
+		root.Close()
+		return nil, err
+	}
 	if os.IsNotExist(err) {
 		file, err := root.Create(name)
 		if err != nil {
@@ -101,9 +277,13 @@ func Open(path string, config Config) (*DB, error) {
 			return nil, err
 		}
 		hdr := Header{
-			Version:   1,
+			Version:   FormatVersion,
 			Timestamp: now(),
 			Algorithm: config.HashAlgorithm,
+			Codec:     config.Compression.Codec,
+		}
+		if config.CaseInsensitiveLabels {
+			hdr.Flags |= flagFoldLabels
 		}
 		hdr.State[stThreshold] = uint64(config.AutoCompact)
 		buf, err := hdr.encode()
@@ -131,6 +311,26 @@ func Open(path string, config Config) (*DB, error) {
 		return nil, err
 	}
 
+	// A read-only handle has no writer fd. The flock is taken on the
+	// reader instead — a shared lock needs no write access. It cannot
+	// finish an interrupted seal, and the file may be half copied until
+	// one is.
+	if config.ReadOnly {
+		if _, err := root.Stat(name + sealExt); err == nil {
+			reader.Close()
+			root.Close()
+			return nil, fmt.Errorf("%w: a seal was interrupted; open the file writable to finish it", ErrReadOnly)
+		}
+		return openReadOnly(root, name, reader, config, aead, log, start)
+	}
+
+	// Prefer a finished rebuild over a dirty or unreadable original.
+	reader, err = salvage(root, name, reader, config, log)
+	if err != nil {
+		root.Close()
+		return nil, err
+	}
+
 	writer, err := root.OpenFile(name, os.O_RDWR, 0644)
 	if err != nil {
 		reader.Close()
@@ -138,7 +338,15 @@ func Open(path string, config Config) (*DB, error) {
 		return nil, err
 	}
 
-	flock := &fileLock{f: writer}
+	flock := &fileLock{f: writer, timeout: config.LockTimeout}
+
+	// Finish a seal a crash interrupted, before the header is read.
+	if err := resumeSeal(root, name, writer, flock, log); err != nil {
+		reader.Close()
+		writer.Close()
+		root.Close()
+		return nil, err
+	}
 
 	info, err := writer.Stat()
 	if err != nil {
@@ -158,15 +366,25 @@ func Open(path string, config Config) (*DB, error) {
 	db := &DB{
 		root:   root,
 		name:   name,
-		reader: reader,
+		reader: mapped(reader, config),
 		writer: writer,
 		lock:   flock,
 		header: hdr,
 		config: config,
+		cipher: aead,
+		log:    log,
 		tail:   info.Size(),
 		cond:   sync.NewCond(&sync.Mutex{}),
 	}
 	db.count.Store(hdr.State[stCount])
+	db.loadMeta()
+	db.table = db.loadTable()
+	db.width = db.indexWidth()
+	db.loadSizes(info.Size())
+	db.codec = newCodec(hdr.Codec, config.Compression, db.dictionaries())
+	if config.MaxConcurrentScans > 0 {
+		db.scans = make(chan struct{}, config.MaxConcurrentScans)
+	}
 
 	// A non-zero AutoCompact is a deliberate change — persist it to the
 	// header so it survives future opens without needing to be repeated.
@@ -187,37 +405,174 @@ func Open(path string, config Config) (*DB, error) {
 		}
 	}
 
-	if config.BloomFilter {
-		db.bloom = newBloom()
-		entries := scanm(reader, db.sparseStart(), info.Size(), TypeIndex)
-		for _, e := range entries {
-			db.bloom.Add(e.ID)
-		}
+	if config.BloomFilter || config.IndexBloom {
+		db.loadBloom(info.Size())
+	}
+	if config.SparseMap {
+		db.smap = newSparseMap()
+	}
+	if config.CacheBytes > 0 {
+		db.cache = newCache(config.CacheBytes)
+	}
+	if config.MissCache > 0 {
+		db.misses = newMisses(config.MissCache)
+	}
+	if config.RecentDocs > 0 {
+		db.recent = newRecents(config.RecentDocs)
 	}
 
 	// A leftover .tmp file or a dirty header means the previous session
 	// crashed mid-write. Repair rebuilds the file from its surviving records.
+	// A .tmp still present here was not salvageable and is discarded.
+	// Interrupted transactions are settled first (see txn.go), then
+	// torn appends discarded (see commit.go).
 	_, tmpErr := root.Stat(name + ".tmp")
 	tmpExists := tmpErr == nil
 	needsRepair := tmpExists || db.header.Error == 1
 
 	if needsRepair {
+		log.Warn("recovering from unclean shutdown", "dirty", db.header.Error == 1, "tmp_discarded", tmpExists)
 		if tmpExists {
 			root.Remove(name + ".tmp")
 		}
 		// Attempt to acquire exclusive lock for repair
 		if err := db.lock.Lock(LockExclusive); err == nil {
 			defer db.lock.Unlock()
-			db.Repair(&CompactOptions{BlockReaders: true})
+			if err := db.settle(); err != nil {
+				log.Error("recovery: settling transactions failed", "error", err)
+			}
+			// A rebuild would keep the torn writes uncommitted failed to
+			// discard as current documents, so it is skipped, and the
+			// file left dirty for the next Open to try again.
+			if err := db.uncommitted(); err != nil {
+				log.Error("recovery: discarding torn writes failed; repair skipped", "error", err)
+				db.unrecovered = true
+			} else if err := db.repair(&CompactOptions{BlockReaders: true}, true); err == nil {
+				log.Warn("recovered")
+			}
+		} else {
+			log.Error("recovery skipped: cannot lock file", "error", err)
+		}
+	}
+	if db.smap != nil {
+		db.smap.extend(db, db.tail)
+	}
+	if config.LabelTrie {
+		if err := db.buildTrie(); err != nil {
+			db.reader.Close()
+			db.writer.Close()
+			root.Close()
+			return nil, fmt.Errorf("label trie: %w", err)
+		}
+	}
+	if config.FullTextIndex {
+		if err := db.buildText(); err != nil {
+			db.reader.Close()
+			db.writer.Close()
+			root.Close()
+			return nil, fmt.Errorf("full-text index: %w", err)
 		}
 	}
 
+	if err := db.openPool(); err != nil {
+		db.reader.Close()
+		db.writer.Close()
+		root.Close()
+		return nil, err
+	}
+
+	db.startCompactor()
+	db.opened(start)
+	return db, nil
+}
+
+// openReadOnly finishes Open for Config.ReadOnly. A dirty header is not
+// repaired: the file may belong to a live writer in another process, and
+// every record after the index section is still found by sparse scans.
+func openReadOnly(root *os.Root, name string, reader *os.File, config Config, aead cipher.AEAD, log *slog.Logger, start time.Time) (*DB, error) {
+	info, err := reader.Stat()
+	if err != nil {
+		reader.Close()
+		root.Close()
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+	hdr, err := header(reader)
+	if err != nil {
+		reader.Close()
+		root.Close()
+		return nil, err
+	}
+
+	db := &DB{
+		root:   root,
+		name:   name,
+		reader: mapped(reader, config),
+		lock:   &fileLock{f: reader, timeout: config.LockTimeout},
+		header: hdr,
+		config: config,
+		cipher: aead,
+		log:    log,
+		tail:   info.Size(),
+		cond:   sync.NewCond(&sync.Mutex{}),
+	}
+	db.count.Store(hdr.State[stCount])
+	db.loadMeta()
+	db.table = db.loadTable()
+	db.width = db.indexWidth()
+	db.loadSizes(info.Size())
+	db.codec = newCodec(hdr.Codec, config.Compression, db.dictionaries())
+	if config.MaxConcurrentScans > 0 {
+		db.scans = make(chan struct{}, config.MaxConcurrentScans)
+	}
+
+	if config.BloomFilter || config.IndexBloom {
+		db.loadBloom(info.Size())
+	}
+	if config.SparseMap {
+		db.smap = newSparseMap()
+		db.smap.extend(db, db.tail)
+	}
+	if config.LabelTrie {
+		if err := db.buildTrie(); err != nil {
+			reader.Close()
+			root.Close()
+			return nil, fmt.Errorf("label trie: %w", err)
+		}
+	}
+	if config.FullTextIndex {
+		if err := db.buildText(); err != nil {
+			reader.Close()
+			root.Close()
+			return nil, fmt.Errorf("full-text index: %w", err)
+		}
+	}
+	if config.CacheBytes > 0 {
+		db.cache = newCache(config.CacheBytes)
+	}
+	if config.MissCache > 0 {
+		db.misses = newMisses(config.MissCache)
+	}
+	if config.RecentDocs > 0 {
+		db.recent = newRecents(config.RecentDocs)
+	}
+	if err := db.openPool(); err != nil {
+		reader.Close()
+		root.Close()
+		return nil, err
+	}
+	if hdr.Error == 1 {
+		log.Warn("dirty file opened read-only: not repaired")
+	}
+	db.opened(start)
 	return db, nil
 }
 
 // Close flushes state, clears the dirty flag if set, and releases all
 // file handles. Any blocked operations wake up and receive ErrClosed.
 func (db *DB) Close() error {
+	db.stopQueue()
+	db.stopCompactor()
+
 	db.cond.L.Lock()
 	db.state.Store(StateClosed)
 	db.cond.Broadcast()
@@ -225,6 +580,7 @@ func (db *DB) Close() error {
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	db.dropPartial()
 
 	// Drain in-flight flock calls before closing the fd (see lock.go)
 	if db.lock != nil {
@@ -233,8 +589,20 @@ func (db *DB) Close() error {
 
 	var errs []error
 
-	if db.header.Error == 1 {
-		db.header.Error = 0
+	var oldMeta int64
+	saved := false
+	if (db.config.PersistUsage || db.sizes != nil) && !db.config.ReadOnly {
+		off, err := db.saveMeta()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		oldMeta, saved = off, err == nil
+	}
+
+	if (db.header.Error == 1 || saved) && !db.config.ReadOnly {
+		if !db.unrecovered {
+			db.header.Error = 0
+		}
 		db.header.State[stCount] = db.count.Load()
 		hdrBytes, err := db.header.encode()
 		if err != nil {
@@ -245,15 +613,33 @@ func (db *DB) Close() error {
 		if err := db.writer.Sync(); err != nil {
 			errs = append(errs, err)
 		}
+		// The header now points at the new metadata record.
+		if saved {
+			if err := db.blankMeta(oldMeta); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if (db.bloom != nil || db.ibloom != nil) && db.root != nil && !db.config.ReadOnly {
+		if err := db.saveBloom(); err != nil {
+			errs = append(errs, err)
+		}
 	}
 	if err := db.reader.Close(); err != nil {
 		errs = append(errs, err)
 	}
-	if err := db.writer.Close(); err != nil {
+	if err := db.closePool(); err != nil {
 		errs = append(errs, err)
 	}
-	if err := db.root.Close(); err != nil {
-		errs = append(errs, err)
+	if db.writer != nil {
+		if err := db.writer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if db.root != nil {
+		if err := db.root.Close(); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
 	return errors.Join(errs...)
@@ -275,7 +661,15 @@ func (db *DB) Count() int { return int(db.count.Load()) }
 func (db *DB) heapEnd() int64 { return int64(db.header.State[stHeap]) }
 
 func (db *DB) indexStart() int64 { return int64(db.header.State[stHeap]) }
-func (db *DB) indexEnd() int64   { return int64(db.header.State[stIndex]) }
+
+// indexEnd is the end of the index lines, before the hash table if the
+// file has one.
+func (db *DB) indexEnd() int64 {
+	if db.table != nil {
+		return db.table.start
+	}
+	return int64(db.header.State[stIndex])
+}
 
 func (db *DB) sparseStart() int64 {
 	if db.header.State[stIndex] == 0 {
@@ -293,8 +687,13 @@ func (db *DB) blockWrite() error {
 	if db.state.Load() == StateClosed {
 		return ErrClosed
 	}
+	if db.config.ReadOnly {
+		return ErrReadOnly
+	}
+	start := time.Now()
 
 	if err := db.lock.Lock(LockExclusive); err != nil {
+		db.lockFailed("write", err)
 		return err
 	}
 
@@ -309,6 +708,7 @@ func (db *DB) blockWrite() error {
 	}
 	db.mu.Lock()
 	db.cond.L.Unlock()
+	db.waited("write", start)
 	return nil
 }
 
@@ -316,8 +716,10 @@ func (db *DB) blockRead() error {
 	if db.state.Load() == StateClosed {
 		return ErrClosed
 	}
+	start := time.Now()
 
 	if err := db.lock.Lock(LockShared); err != nil {
+		db.lockFailed("read", err)
 		return err
 	}
 
@@ -332,5 +734,24 @@ func (db *DB) blockRead() error {
 	}
 	db.mu.RLock()
 	db.cond.L.Unlock()
+	db.waited("read", start)
 	return nil
 }
+
+// beginScan and endScan bracket a full-file scan with the optional
+// Config.MaxConcurrentScans semaphore, and beginScan counts the scan
+// toward Usage. beginScan must be called before
+// blockRead: a scan waiting for a slot must not hold the read lock, or
+// it would stall writers for as long as the running scans take.
+func (db *DB) beginScan() {
+	db.usage.scans.Add(1)
+	if db.scans != nil {
+		db.scans <- struct{}{}
+	}
+}
+
+func (db *DB) endScan() {
+	if db.scans != nil {
+		<-db.scans
+	}
+}
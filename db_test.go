@@ -10,27 +10,17 @@
 package folio
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
-	"iter"
+	"maps"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
-// collect materialises an iter.Seq2[T, error] into a slice, stopping
-// on the first error. Used across the test suite wherever callers need
-// to inspect the full result set (length checks, index access, etc.).
-func collect[T any](seq iter.Seq2[T, error]) ([]T, error) {
-	var items []T
-	for item, err := range seq {
-		if err != nil {
-			return items, err
-		}
-		items = append(items, item)
-	}
-	return items, nil
-}
-
 // openTestDB creates a fresh database in a temporary directory and
 // registers cleanup to close it when the test finishes. Used by
 // nearly every test in the suite.
@@ -119,7 +109,7 @@ func TestClose(t *testing.T) {
 	}
 
 	_, err := db.Get("doc")
-	if err != ErrClosed {
+	if !errors.Is(err, ErrClosed) {
 		t.Errorf("Get after close: got %v, want ErrClosed", err)
 	}
 }
@@ -158,6 +148,42 @@ func TestSetUpdate(t *testing.T) {
 	}
 }
 
+// TestCreateUpdate verifies the conditional writes: Create refuses an
+// existing label and Update a missing one, each without writing, while
+// an expired document counts as absent. Without the check under the
+// write lock, two processes creating one label would both succeed.
+func TestCreateUpdate(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := db.Update("doc", "v0"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update missing: got %v, want ErrNotFound", err)
+	}
+	if err := db.Create("doc", "v1"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := db.Create("doc", "v2"); !errors.Is(err, ErrExists) {
+		t.Errorf("Create existing: got %v, want ErrExists", err)
+	}
+	if err := db.Update("doc", "v3"); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got, _ := db.Get("doc"); got != "v3" {
+		t.Errorf("Get = %q, want v3", got)
+	}
+	if db.Count() != 1 {
+		t.Errorf("Count = %d, want 1", db.Count())
+	}
+
+	db.SetWithTTL("ttl", "old", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if err := db.Update("ttl", "new"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update expired: got %v, want ErrNotFound", err)
+	}
+	if err := db.Create("ttl", "new"); err != nil {
+		t.Errorf("Create over expired: %v", err)
+	}
+}
+
 // TestSetLabelTooLong verifies that labels exceeding MaxLabelSize are
 // rejected. Without this limit, a very long label would produce a
 // record too large for the fixed-position field extraction.
@@ -166,7 +192,7 @@ func TestSetLabelTooLong(t *testing.T) {
 
 	label := string(make([]byte, MaxLabelSize+1))
 	err := db.Set(label, "content")
-	if err != ErrLabelTooLong {
+	if !errors.Is(err, ErrLabelTooLong) {
 		t.Errorf("Set long label: got %v, want ErrLabelTooLong", err)
 	}
 }
@@ -179,7 +205,7 @@ func TestSetLabelWithQuote(t *testing.T) {
 	db := openTestDB(t)
 
 	err := db.Set(`my"label`, "content")
-	if err != ErrInvalidLabel {
+	if !errors.Is(err, ErrInvalidLabel) {
 		t.Errorf("Set label with quote: got %v, want ErrInvalidLabel", err)
 	}
 }
@@ -191,7 +217,7 @@ func TestSetEmptyContent(t *testing.T) {
 	db := openTestDB(t)
 
 	err := db.Set("doc", "")
-	if err != ErrEmptyContent {
+	if !errors.Is(err, ErrEmptyContent) {
 		t.Errorf("Set empty: got %v, want ErrEmptyContent", err)
 	}
 }
@@ -203,7 +229,7 @@ func TestGetNotFound(t *testing.T) {
 	db := openTestDB(t)
 
 	_, err := db.Get("nonexistent")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Get missing: got %v, want ErrNotFound", err)
 	}
 }
@@ -220,7 +246,7 @@ func TestDelete(t *testing.T) {
 	}
 
 	_, err := db.Get("doc")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Get after delete: got %v, want ErrNotFound", err)
 	}
 }
@@ -233,11 +259,60 @@ func TestDeleteNotFound(t *testing.T) {
 	db := openTestDB(t)
 
 	err := db.Delete("nonexistent")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Delete missing: got %v, want ErrNotFound", err)
 	}
 }
 
+// TestDeleteMany verifies that DeleteMany removes every label, tolerates
+// duplicates and a compacted heap, and that one missing label leaves
+// every document in place. A partial delete would leave callers unable
+// to tell which documents are gone.
+func TestDeleteMany(t *testing.T) {
+	db := openTestDB(t)
+
+	db.Set("a", "1")
+	db.Set("b", "2")
+	db.Compact()
+	db.Set("c", "3")
+	db.Set("d", "4")
+
+	if err := db.DeleteMany("a", "c", "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("DeleteMany with missing label: got %v, want ErrNotFound", err)
+	}
+	if db.Count() != 4 {
+		t.Fatalf("Count after failed DeleteMany = %d, want 4", db.Count())
+	}
+	if err := db.DeleteMany("a", ""); !errors.Is(err, ErrInvalidLabel) {
+		t.Errorf("DeleteMany empty label: got %v, want ErrInvalidLabel", err)
+	}
+
+	if err := db.DeleteMany("a", "c", "a"); err != nil {
+		t.Fatalf("DeleteMany: %v", err)
+	}
+	for _, lbl := range []string{"a", "c"} {
+		if _, err := db.Get(lbl); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get %s after DeleteMany: got %v, want ErrNotFound", lbl, err)
+		}
+	}
+	if got, _ := db.Get("b"); got != "2" {
+		t.Errorf("Get b = %q, want 2", got)
+	}
+	if db.Count() != 2 {
+		t.Errorf("Count = %d, want 2", db.Count())
+	}
+	var versions int
+	for _, err := range db.History("a") {
+		if err != nil {
+			t.Fatalf("History: %v", err)
+		}
+		versions++
+	}
+	if versions != 1 {
+		t.Errorf("History a: %d versions, want 1", versions)
+	}
+}
+
 // TestExistsAfterCompact verifies that Exists works after compaction
 // moves records from the sparse region into the sorted heap. Compaction
 // rebuilds the file with new section boundaries; if Exists only checked
@@ -282,7 +357,7 @@ func TestGetAfterCompact(t *testing.T) {
 	}
 
 	_, err = db.Get("missing")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Get missing: got %v, want ErrNotFound", err)
 	}
 }
@@ -333,7 +408,7 @@ func TestExists(t *testing.T) {
 func TestList(t *testing.T) {
 	db := openTestDB(t)
 
-	labels, _ := collect(db.List())
+	labels, _ := Collect(db.List())
 	if len(labels) != 0 {
 		t.Errorf("List empty db: got %d, want 0", len(labels))
 	}
@@ -342,7 +417,7 @@ func TestList(t *testing.T) {
 	db.Set("b", "2")
 	db.Set("c", "3")
 
-	labels, _ = collect(db.List())
+	labels, _ = Collect(db.List())
 	if len(labels) != 3 {
 		t.Errorf("List: got %d labels, want 3", len(labels))
 	}
@@ -359,7 +434,7 @@ func TestListAfterDelete(t *testing.T) {
 	db.Set("b", "2")
 	db.Delete("a")
 
-	labels, _ := collect(db.List())
+	labels, _ := Collect(db.List())
 	if len(labels) != 1 {
 		t.Errorf("List after delete: got %d, want 1", len(labels))
 	}
@@ -368,6 +443,102 @@ func TestListAfterDelete(t *testing.T) {
 	}
 }
 
+// TestListPrefix verifies that only labels under the prefix are yielded,
+// from both the sorted index and the sparse region. A label that merely
+// contains the prefix further in must not match, and a label written
+// again after compaction must appear once.
+func TestListPrefix(t *testing.T) {
+	db := openTestDB(t)
+
+	db.Set("config/db/host", "h")
+	db.Set("config/db/port", "p")
+	db.Set("config/cache", "c")
+	db.Set("old/config/db/host", "x")
+	db.Compact()
+	db.Set("config/db/user", "u")
+	db.Set("config/db/host", "h2")
+
+	labels, err := Collect(db.ListPrefix("config/db/"))
+	if err != nil {
+		t.Fatalf("ListPrefix: %v", err)
+	}
+	slices.Sort(labels)
+	want := []string{"config/db/host", "config/db/port", "config/db/user"}
+	if !slices.Equal(labels, want) {
+		t.Errorf("ListPrefix = %v, want %v", labels, want)
+	}
+
+	all, _ := Collect(db.ListPrefix(""))
+	if len(all) != 5 {
+		t.Errorf("ListPrefix(\"\") = %d labels, want 5", len(all))
+	}
+}
+
+// pages walks ListPage to the end and returns every label seen.
+func pages(t *testing.T, db *DB, limit int, between func()) []string {
+	t.Helper()
+	var all []string
+	cursor := ""
+	for {
+		labels, next, err := db.ListPage(cursor, limit)
+		if err != nil {
+			t.Fatalf("ListPage: %v", err)
+		}
+		if len(labels) > limit {
+			t.Fatalf("ListPage returned %d labels, limit %d", len(labels), limit)
+		}
+		all = append(all, labels...)
+		if next == "" {
+			return all
+		}
+		cursor = next
+		if between != nil {
+			between()
+		}
+	}
+}
+
+// TestListPage verifies that paging visits every document exactly once
+// whether the documents sit in the sorted index, the sparse region, or
+// both, and that a compaction between pages does not disturb the walk.
+func TestListPage(t *testing.T) {
+	db := openTestDB(t)
+	for i := range 25 {
+		db.Set(fmt.Sprintf("doc-%02d", i), "x")
+	}
+	db.Compact()
+	for i := 25; i < 40; i++ {
+		db.Set(fmt.Sprintf("doc-%02d", i), "x")
+	}
+
+	for _, limit := range []int{1, 7, 40, 100} {
+		compacted := false
+		got := pages(t, db, limit, func() {
+			if !compacted {
+				db.Compact()
+				compacted = true
+			}
+		})
+		slices.Sort(got)
+		if len(got) != 40 || len(slices.Compact(got)) != 40 {
+			t.Errorf("limit %d: %d labels, want 40 distinct", limit, len(got))
+		}
+	}
+}
+
+// TestListPageInvalid verifies bad arguments are rejected rather than
+// silently restarting from the first page.
+func TestListPageInvalid(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("a", "1")
+	if _, _, err := db.ListPage("!!not base64", 10); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("bad cursor = %v, want ErrInvalidCursor", err)
+	}
+	if _, _, err := db.ListPage("", 0); err == nil {
+		t.Error("limit 0 accepted")
+	}
+}
+
 // TestAll verifies that All returns every document with correct content.
 // This is the single-pass alternative to List+Get — if All missed any
 // document or returned wrong content, export and backup use cases would
@@ -375,7 +546,7 @@ func TestListAfterDelete(t *testing.T) {
 func TestAll(t *testing.T) {
 	db := openTestDB(t)
 
-	docs, _ := collect(db.All())
+	docs, _ := Collect(db.All())
 	if len(docs) != 0 {
 		t.Errorf("All empty db: got %d, want 0", len(docs))
 	}
@@ -384,7 +555,7 @@ func TestAll(t *testing.T) {
 	db.Set("b", "bravo")
 	db.Set("c", "charlie")
 
-	docs, _ = collect(db.All())
+	docs, _ = Collect(db.All())
 	if len(docs) != 3 {
 		t.Errorf("All: got %d docs, want 3", len(docs))
 	}
@@ -412,7 +583,7 @@ func TestAllAfterUpdate(t *testing.T) {
 	db.Set("doc", "v1")
 	db.Set("doc", "v2")
 
-	docs, _ := collect(db.All())
+	docs, _ := Collect(db.All())
 	if len(docs) != 1 {
 		t.Fatalf("All after update: got %d, want 1", len(docs))
 	}
@@ -432,7 +603,7 @@ func TestAllAfterDelete(t *testing.T) {
 	db.Set("b", "2")
 	db.Delete("a")
 
-	docs, _ := collect(db.All())
+	docs, _ := Collect(db.All())
 	if len(docs) != 1 {
 		t.Fatalf("All after delete: got %d, want 1", len(docs))
 	}
@@ -453,7 +624,7 @@ func TestAllAfterCompact(t *testing.T) {
 	db.Set("a", "updated")
 	db.Compact()
 
-	docs, _ := collect(db.All())
+	docs, _ := Collect(db.All())
 	if len(docs) != 2 {
 		t.Fatalf("All after compact: got %d, want 2", len(docs))
 	}
@@ -480,7 +651,7 @@ func TestAllEscapedContent(t *testing.T) {
 	content := "line1\nline2\ttab\"quote\\backslash"
 	db.Set("escaped", content)
 
-	docs, _ := collect(db.All())
+	docs, _ := Collect(db.All())
 	if len(docs) != 1 {
 		t.Fatalf("All escaped: got %d, want 1", len(docs))
 	}
@@ -510,6 +681,115 @@ func TestAllEarlyBreak(t *testing.T) {
 	}
 }
 
+// TestAllInfo verifies the metadata AllInfo attaches to each document,
+// including version counts that span the heap and sparse regions. A
+// count that only looked at one region would under-report documents
+// edited after the last compaction.
+func TestAllInfo(t *testing.T) {
+	db := openTestDB(t)
+
+	db.Set("a", "v1")
+	db.Set("a", "v2")
+	db.Set("b", "only")
+	db.Compact()
+	db.Set("a", "v3 \"quoted\"")
+
+	infos, err := Collect(db.AllInfo())
+	if err != nil {
+		t.Fatalf("AllInfo: %v", err)
+	}
+	got := map[string]DocumentInfo{}
+	for _, info := range infos {
+		got[info.Label] = info
+	}
+	if len(got) != 2 {
+		t.Fatalf("AllInfo: got %d documents, want 2", len(got))
+	}
+
+	a := got["a"]
+	if a.Data != `v3 "quoted"` || a.Size != len(a.Data) {
+		t.Errorf("a: Data=%q Size=%d", a.Data, a.Size)
+	}
+	if a.VersionCount != 3 {
+		t.Errorf("a: VersionCount = %d, want 3", a.VersionCount)
+	}
+	if b := got["b"]; b.VersionCount != 1 {
+		t.Errorf("b: VersionCount = %d, want 1", b.VersionCount)
+	}
+
+	versions, _ := Collect(db.History("a"))
+	if a.Timestamp != versions[len(versions)-1].TS {
+		t.Errorf("a: Timestamp = %d, want %d", a.Timestamp, versions[len(versions)-1].TS)
+	}
+
+	// Identical content must hash identically; different content must not.
+	db.Set("c", `v3 "quoted"`)
+	infos, _ = Collect(db.AllInfo())
+	hashes := map[string]string{}
+	for _, info := range infos {
+		hashes[info.Label] = info.ContentHash
+	}
+	if len(hashes["a"]) != 16 || hashes["a"] != hashes["c"] || hashes["a"] == hashes["b"] {
+		t.Errorf("ContentHash: a=%s b=%s c=%s", hashes["a"], hashes["b"], hashes["c"])
+	}
+}
+
+// TestAllWith verifies that each AllOptions filter narrows the scan to
+// the documents it names, across the heap and sparse regions, and that
+// LabelsOnly leaves Data empty.
+func TestAllWith(t *testing.T) {
+	db := openTestDB(t)
+
+	db.Set("users/a", "1")
+	db.Set("users/b", "2")
+	db.Set("posts/a", "3")
+	db.Compact()
+	db.Set("users/c", "4")
+
+	labels := func(opts AllOptions) []string {
+		t.Helper()
+		docs, err := Collect(db.AllWith(opts))
+		if err != nil {
+			t.Fatalf("AllWith(%+v): %v", opts, err)
+		}
+		var got []string
+		for _, d := range docs {
+			if opts.LabelsOnly != (d.Data == "") {
+				t.Errorf("AllWith(%+v): %s has Data %q", opts, d.Label, d.Data)
+			}
+			got = append(got, d.Label)
+		}
+		slices.Sort(got)
+		return got
+	}
+
+	if got := labels(AllOptions{LabelPrefix: "users/"}); !slices.Equal(got, []string{"users/a", "users/b", "users/c"}) {
+		t.Errorf("LabelPrefix: got %v", got)
+	}
+	if got := labels(AllOptions{LabelPrefix: "posts/", LabelsOnly: true}); !slices.Equal(got, []string{"posts/a"}) {
+		t.Errorf("LabelsOnly: got %v", got)
+	}
+	if got := labels(AllOptions{MaxDocs: 2}); len(got) != 2 {
+		t.Errorf("MaxDocs 2: got %v", got)
+	}
+
+	versions, _ := Collect(db.History("users/c"))
+	since := versions[0].TS
+	infos, _ := Collect(db.AllInfo())
+	want := 0
+	for _, d := range infos {
+		if d.Timestamp >= since {
+			want++
+		}
+	}
+	if got := labels(AllOptions{ModifiedSince: since}); len(got) != want || !slices.Contains(got, "users/c") {
+		t.Errorf("ModifiedSince: got %v, want %d including users/c", got, want)
+	}
+	if got := labels(AllOptions{ModifiedSince: since + 1_000_000}); len(got) != 0 {
+		t.Errorf("ModifiedSince in the future: got %v", got)
+	}
+}
+
 // TestRenameSameLength verifies the in-place patch path: when old and
 // new labels have the same byte length, Rename patches _id and _l
 // directly without creating a new record. Get(old) must return
@@ -523,7 +803,7 @@ func TestRenameSameLength(t *testing.T) {
 	}
 
 	_, err := db.Get("aaa")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Get(old) = %v, want ErrNotFound", err)
 	}
 
@@ -549,7 +829,7 @@ func TestRenameDifferentLength(t *testing.T) {
 	}
 
 	_, err := db.Get("short")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Get(old) = %v, want ErrNotFound", err)
 	}
 
@@ -568,7 +848,7 @@ func TestRenameNotFound(t *testing.T) {
 	db := openTestDB(t)
 
 	err := db.Rename("nonexistent", "new")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Rename missing: got %v, want ErrNotFound", err)
 	}
 }
@@ -582,7 +862,7 @@ func TestRenameExists(t *testing.T) {
 	db.Set("b", "bravo")
 
 	err := db.Rename("a", "b")
-	if err != ErrExists {
+	if !errors.Is(err, ErrExists) {
 		t.Errorf("Rename to existing: got %v, want ErrExists", err)
 	}
 
@@ -636,6 +916,46 @@ func TestRenameAfterCompact(t *testing.T) {
 	}
 }
 
+// TestRenameSameLengthSorted verifies that a same-length rename of a
+// document in the sorted index is not patched in place. The new ID
+// would sit out of order among its neighbours, where binary search
+// could not find it, and neither the hash table nor the index bloom
+// filter would hold it. Each lookup path is checked, before and after
+// the rename is compacted away.
+func TestRenameSameLengthSorted(t *testing.T) {
+	for name, config := range map[string]Config{
+		"search":     {},
+		"hash table": {HashTable: true},
+		"bloom":      {IndexBloom: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, err := Open(filepath.Join(t.TempDir(), "test.folio"), config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			for i := range 50 {
+				db.Set(fmt.Sprintf("doc%02d", i), "content")
+			}
+			db.Compact()
+
+			if err := db.Rename("doc10", "zzz10"); err != nil {
+				t.Fatalf("Rename: %v", err)
+			}
+			for _, when := range []string{"renamed", "compacted"} {
+				if data, err := db.Get("zzz10"); err != nil || data != "content" {
+					t.Errorf("%s: Get(new) = %q, %v; want content", when, data, err)
+				}
+				if _, err := db.Get("doc10"); !errors.Is(err, ErrNotFound) {
+					t.Errorf("%s: Get(old) = %v, want ErrNotFound", when, err)
+				}
+				mustVerify(t, db, VerifyOptions{})
+				db.Compact()
+			}
+		})
+	}
+}
+
 // TestRenameListReflects verifies that List returns the new label and
 // not the old one after a rename.
 func TestRenameListReflects(t *testing.T) {
@@ -644,7 +964,7 @@ func TestRenameListReflects(t *testing.T) {
 	db.Set("before", "content")
 	db.Rename("before", "after")
 
-	labels, _ := collect(db.List())
+	labels, _ := Collect(db.List())
 	if len(labels) != 1 {
 		t.Fatalf("List: got %d, want 1", len(labels))
 	}
@@ -711,12 +1031,12 @@ func TestBatchValidation(t *testing.T) {
 		Document{"valid", "content"},
 		Document{"", "content"},
 	)
-	if err != ErrInvalidLabel {
+	if !errors.Is(err, ErrInvalidLabel) {
 		t.Errorf("Batch invalid: got %v, want ErrInvalidLabel", err)
 	}
 
 	_, err = db.Get("valid")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Get(valid) after failed batch: got %v, want ErrNotFound", err)
 	}
 }
@@ -730,6 +1050,72 @@ func TestBatchEmpty(t *testing.T) {
 	}
 }
 
+// TestBatchMixed verifies that a Batch applies puts, deletes, and
+// renames in order, each seeing the effect of those before it.
+func TestBatchMixed(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("a", "alpha")
+	db.Set("b", "bravo")
+
+	err := db.Batch(
+		Put("c", "charlie"),
+		Delete("a"),
+		Rename("b", "d"),
+		Put("d", "delta"),
+		Document{"e", "echo"},
+	)
+	if err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	want := map[string]string{"c": "charlie", "d": "delta", "e": "echo"}
+	for lbl, content := range want {
+		if got, err := db.Get(lbl); err != nil || got != content {
+			t.Errorf("Get(%s) = %q, %v; want %q", lbl, got, err, content)
+		}
+	}
+	for _, lbl := range []string{"a", "b"} {
+		if _, err := db.Get(lbl); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%s) = %v, want ErrNotFound", lbl, err)
+		}
+	}
+	if n := db.Count(); n != len(want) {
+		t.Errorf("Count = %d, want %d", n, len(want))
+	}
+}
+
+// TestBatchMixedFailure verifies that an invalid operation anywhere in a
+// Batch stops it before anything is written, and that one failing on
+// the file's contents stops it with the operations before it written.
+func TestBatchMixedFailure(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("a", "alpha")
+
+	err := db.Batch(Put("first", "x"), Delete("a"), Rename("a", `bad"label`))
+	if !errors.Is(err, ErrInvalidLabel) {
+		t.Errorf("Batch with an invalid rename = %v, want ErrInvalidLabel", err)
+	}
+	if err := db.Batch(Put("first", "x"), Delete("")); !errors.Is(err, ErrInvalidLabel) {
+		t.Errorf("Batch with an empty delete = %v, want ErrInvalidLabel", err)
+	}
+	if _, err := db.Get("first"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(first) after rejected batches = %v, want ErrNotFound", err)
+	}
+	if _, err := db.Get("a"); err != nil {
+		t.Errorf("Get(a) after rejected batches = %v", err)
+	}
+
+	err = db.Batch(Put("first", "x"), Delete("missing"), Put("after", "y"))
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Batch deleting a missing label = %v, want ErrNotFound", err)
+	}
+	if _, err := db.Get("first"); err != nil {
+		t.Errorf("Get(first) = %v, want it written", err)
+	}
+	if _, err := db.Get("after"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(after) = %v, want ErrNotFound", err)
+	}
+}
+
 // TestCount verifies that Count tracks creates and returns 0 for an
 // empty database. Count is maintained atomically by Set and Delete
 // and requires no I/O or locking.
@@ -853,7 +1239,7 @@ func TestHistoryMultiDocCompact(t *testing.T) {
 	db.Compact()
 
 	// Exercises group() forward walk stopping at a different ID boundary
-	versions, err := collect(db.History("a"))
+	versions, err := Collect(db.History("a"))
 	if err != nil {
 		t.Fatalf("History: %v", err)
 	}
@@ -875,7 +1261,7 @@ func TestHistorySparseOnly(t *testing.T) {
 	db.Set("b", "new") // only in sparse region
 
 	// Exercises group() returning nil (ID not in heap)
-	versions, err := collect(db.History("b"))
+	versions, err := Collect(db.History("b"))
 	if err != nil {
 		t.Fatalf("History: %v", err)
 	}
@@ -895,7 +1281,7 @@ func TestHistory(t *testing.T) {
 	db.Set("doc", "v2")
 	db.Set("doc", "v3")
 
-	versions, err := collect(db.History("doc"))
+	versions, err := Collect(db.History("doc"))
 	if err != nil {
 		t.Fatalf("History: %v", err)
 	}
@@ -923,7 +1309,7 @@ func TestHistoryAfterDelete(t *testing.T) {
 	db.Set("doc", "v2")
 	db.Delete("doc")
 
-	versions, _ := collect(db.History("doc"))
+	versions, _ := Collect(db.History("doc"))
 	if len(versions) != 2 {
 		t.Errorf("History after delete: got %d, want 2", len(versions))
 	}
@@ -936,12 +1322,173 @@ func TestHistoryAfterDelete(t *testing.T) {
 func TestHistoryNonexistent(t *testing.T) {
 	db := openTestDB(t)
 
-	versions, _ := collect(db.History("nonexistent"))
+	versions, _ := Collect(db.History("nonexistent"))
 	if len(versions) != 0 {
 		t.Errorf("History nonexistent: got %d, want 0", len(versions))
 	}
 }
 
+// TestHistoryWith verifies that HistoryOptions bound, order, and limit
+// the versions yielded, and that versions left out are never
+// decompressed: a corrupt snapshot outside the selection is not seen.
+func TestHistoryWith(t *testing.T) {
+	db := openTestDB(t)
+	for _, v := range []string{"v1", "v2", "v3", "v4"} {
+		db.Set("doc", v)
+		time.Sleep(2 * time.Millisecond)
+	}
+	all, _ := Collect(db.History("doc"))
+	if len(all) != 4 {
+		t.Fatalf("History: got %d versions, want 4", len(all))
+	}
+
+	data := func(opts HistoryOptions) []string {
+		t.Helper()
+		versions, err := Collect(db.HistoryWith("doc", opts))
+		if err != nil {
+			t.Fatalf("HistoryWith(%+v): %v", opts, err)
+		}
+		var out []string
+		for _, v := range versions {
+			out = append(out, v.Data)
+		}
+		return out
+	}
+	tests := []struct {
+		opts HistoryOptions
+		want []string
+	}{
+		{HistoryOptions{}, []string{"v1", "v2", "v3", "v4"}},
+		{HistoryOptions{Limit: 2}, []string{"v1", "v2"}},
+		{HistoryOptions{Reverse: true, Limit: 2}, []string{"v4", "v3"}},
+		{HistoryOptions{Since: all[1].TS}, []string{"v2", "v3", "v4"}},
+		{HistoryOptions{Until: all[2].TS}, []string{"v1", "v2", "v3"}},
+		{HistoryOptions{Since: all[1].TS, Until: all[2].TS, Reverse: true}, []string{"v3", "v2"}},
+		{HistoryOptions{Since: all[3].TS + 1}, nil},
+	}
+	for _, tt := range tests {
+		if got := data(tt.opts); !slices.Equal(got, tt.want) {
+			t.Errorf("HistoryWith(%+v) = %v, want %v", tt.opts, got, tt.want)
+		}
+	}
+
+	// Break the oldest version's checksum; the latest still reads.
+	first, _ := line(db.reader, HeaderSize)
+	i := bytes.Index(first, []byte(`"_k":`)) + len(`"_k":`)
+	digit := byte('1')
+	if first[i] == '1' {
+		digit = '2'
+	}
+	db.writeAt(HeaderSize+int64(i), []byte{digit})
+	if got := data(HistoryOptions{Reverse: true, Limit: 1}); !slices.Equal(got, []string{"v4"}) {
+		t.Errorf("latest version after corrupting the oldest = %v, want v4", got)
+	}
+	if _, err := Collect(db.History("doc")); !errors.Is(err, ErrChecksum) {
+		t.Errorf("History after corrupting the oldest = %v, want ErrChecksum", err)
+	}
+}
+
+// TestGetAt verifies point-in-time reads pick the newest version at or
+// before the timestamp, across the heap and sparse region. Picking the
+// oldest match, or the first after ts, would return the wrong revision
+// to an audit query.
+func TestGetAt(t *testing.T) {
+	db := openTestDB(t)
+
+	db.Set("doc", "v1")
+	time.Sleep(2 * time.Millisecond)
+	db.Set("doc", "v2")
+	db.Compact()
+	time.Sleep(2 * time.Millisecond)
+	db.Set("doc", "v3")
+
+	versions, _ := Collect(db.History("doc"))
+	if len(versions) != 3 {
+		t.Fatalf("History: got %d versions, want 3", len(versions))
+	}
+	tests := []struct {
+		ts   int64
+		want string
+	}{
+		{versions[0].TS, "v1"},
+		{versions[1].TS - 1, "v1"},
+		{versions[1].TS, "v2"},
+		{versions[2].TS + 1000, "v3"},
+	}
+	for _, tt := range tests {
+		if got, err := db.GetAt("doc", tt.ts); err != nil || got != tt.want {
+			t.Errorf("GetAt(%d) = %q, %v; want %q", tt.ts, got, err, tt.want)
+		}
+	}
+	if _, err := db.GetAt("doc", versions[0].TS-1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetAt before first version = %v, want ErrNotFound", err)
+	}
+}
+
+// TestGetVersion verifies versions are numbered oldest first, matching
+// History, and that out-of-range numbers report ErrNotFound.
+func TestGetVersion(t *testing.T) {
+	db := openTestDB(t)
+
+	db.Set("doc", "v1")
+	db.Set("doc", "v2")
+	db.Set("doc", "v3")
+
+	for n, want := range []string{"v1", "v2", "v3"} {
+		if got, err := db.GetVersion("doc", n); err != nil || got != want {
+			t.Errorf("GetVersion(%d) = %q, %v; want %q", n, got, err, want)
+		}
+	}
+	for _, n := range []int{-1, 3} {
+		if _, err := db.GetVersion("doc", n); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetVersion(%d) = %v, want ErrNotFound", n, err)
+		}
+	}
+	if _, err := db.GetVersion("missing", 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetVersion(missing) = %v, want ErrNotFound", err)
+	}
+}
+
+// TestRevert verifies a reverted version becomes current while every
+// intermediate version stays in History, and that reverting a deleted
+// document restores it. Rewinding by dropping later versions would
+// destroy the audit trail Revert exists to keep.
+func TestRevert(t *testing.T) {
+	db := openTestDB(t)
+
+	db.Set("doc", "v1")
+	time.Sleep(2 * time.Millisecond)
+	db.Set("doc", "v2")
+	versions, _ := Collect(db.History("doc"))
+
+	time.Sleep(2 * time.Millisecond)
+	if err := db.Revert("doc", versions[0].TS); err != nil {
+		t.Fatalf("Revert: %v", err)
+	}
+	if data, _ := db.Get("doc"); data != "v1" {
+		t.Errorf("Get after Revert = %q, want v1", data)
+	}
+	after, _ := Collect(db.History("doc"))
+	if len(after) != 3 || after[1].Data != "v2" || after[2].Data != "v1" {
+		t.Errorf("History after Revert = %v, want v1 v2 v1", after)
+	}
+
+	db.Delete("doc")
+	if err := db.Revert("doc", versions[1].TS); err != nil {
+		t.Fatalf("Revert deleted: %v", err)
+	}
+	if data, _ := db.Get("doc"); data != "v2" {
+		t.Errorf("Get after reverting a deleted doc = %q, want v2", data)
+	}
+	if db.Count() != 1 {
+		t.Errorf("Count = %d, want 1", db.Count())
+	}
+
+	if err := db.Revert("doc", 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revert unknown ts = %v, want ErrNotFound", err)
+	}
+}
+
 // TestCompact verifies that Compact preserves the latest version of
 // each document and retains full version history. Compaction rebuilds
 // the file: it sorts records into the heap, rewrites indexes with new
@@ -963,7 +1510,7 @@ func TestCompact(t *testing.T) {
 		t.Errorf("Get after compact = %q, want %q", data, "1-updated")
 	}
 
-	versions, _ := collect(db.History("a"))
+	versions, _ := Collect(db.History("a"))
 	if len(versions) != 2 {
 		t.Errorf("History after compact: got %d, want 2", len(versions))
 	}
@@ -990,7 +1537,7 @@ func TestPurge(t *testing.T) {
 		t.Errorf("Get after purge = %q, want %q", data, "v3")
 	}
 
-	versions, _ := collect(db.History("doc"))
+	versions, _ := Collect(db.History("doc"))
 	if len(versions) != 1 {
 		t.Errorf("History after purge: got %d, want 1 (current only)", len(versions))
 	}
@@ -1190,9 +1737,8 @@ func TestAutoCompactConfigOverride(t *testing.T) {
 
 // TestAutoCompactDeleteDoesNotCount verifies that Delete does not
 // increment the write counter. Delete patches records in place via
-// writeAt rather than appending through raw(), so it does not grow
-// the sparse region and should not count toward the compaction
-// threshold.
+// writeAt and appends only a small tombstone, so it barely grows the
+// sparse region and should not count toward the compaction threshold.
 func TestAutoCompactDeleteDoesNotCount(t *testing.T) {
 	dir := t.TempDir()
 	db, _ := Open(filepath.Join(dir, "test.folio"), Config{AutoCompact: 5})
@@ -1267,3 +1813,32 @@ func TestAutoCompactMultipleCycles(t *testing.T) {
 		t.Error("State[stHeap] unchanged after second compaction")
 	}
 }
+
+// TestGetMany verifies one call returns what Get returns for each label
+// across the sorted and sparse regions, skipping missing, deleted, and
+// expired labels, with updates after compaction taking precedence.
+func TestGetMany(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("sorted", "s1")
+	db.Set("updated", "u1")
+	db.Set("deleted", "d1")
+	db.Compact()
+	db.Set("updated", "u2")
+	db.Set("sparse", "p1")
+	db.Delete("deleted")
+	db.SetWithTTL("expired", "e1", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	got, err := db.GetMany("sorted", "updated", "sparse", "deleted", "expired", "missing", "sorted")
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+	want := map[string]string{"sorted": "s1", "updated": "u2", "sparse": "p1"}
+	if !maps.Equal(got, want) {
+		t.Errorf("GetMany = %v, want %v", got, want)
+	}
+
+	if got, err := db.GetMany(); err != nil || len(got) != 0 {
+		t.Errorf("GetMany() = %v, %v", got, err)
+	}
+}
@@ -0,0 +1,152 @@
+// Content shared between documents.
+//
+// A file that holds the same content under many labels, such as
+// templates copied per tenant or a default config written for every
+// user, normally stores that content in full in each document's record.
+// With Config.DedupContent set, Compact and Repair instead write it
+// once: the first current record with a given content is written as it
+// is, and every later one with the same content as a shared record, with
+// _d and _h empty and _s holding the offset of the first, its body.
+// Content under minShared bytes is always written in full, as the second
+// read a shared record costs is not worth the space it saves.
+//
+// A shared record's content is its body's: the body's _d while the body
+// is current, or its _h once a write or delete has retired it, which
+// leaves the snapshot in place. _k is the shared record's own checksum,
+// so a body that does not hold the content it should is reported as
+// corrupt. Bodies are never shared records themselves, and never deltas,
+// so one read at the offset in _s restores the content. A shared record
+// retired in place keeps _s and becomes a shared history record.
+//
+// Offsets only hold until the next rebuild, so every rebuild writes
+// shared records out in full again before deciding afresh which to
+// share, and Compact rebuilds in one pass rather than online. Records
+// written between compactions are never shared. The header records with
+// _f bit 4 that the file may hold shared records.
+package folio
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"fmt"
+
+	json "github.com/goccy/go-json"
+	"github.com/zeebo/xxh3"
+)
+
+// minShared is the smallest content, in bytes, a rebuild writes as a
+// shared record.
+const minShared = 64
+
+// shared reports whether a record line takes its content from a body
+// (_s). As with binary, the marker cannot occur inside an escaped string
+// value.
+func shared(line []byte) bool {
+	return bytes.LastIndex(line, []byte(`"_s":`)) >= 0
+}
+
+// body returns the content of the body at off, for a shared record whose
+// checksum is sum.
+func (db *DB) body(off int64, sum uint32) ([]byte, error) {
+	ln, err := line(db.reader, off)
+	if err != nil {
+		return nil, fmt.Errorf("read shared content at %d: %w", off, err)
+	}
+	r, err := decodeWith(ln, db.cipher)
+	if err != nil {
+		return nil, fmt.Errorf("shared content at %d: %w", off, err)
+	}
+	content := []byte(r.Data)
+	switch {
+	case r.Shared != 0 || r.Delta:
+		return nil, fmt.Errorf("%w: shared content at %d is not a body", ErrCorruptRecord, off)
+	case r.Type == TypeHistory:
+		if content, err = db.snapshot(r); err != nil {
+			return nil, fmt.Errorf("shared content at %d: %w", off, err)
+		}
+	case r.Type != TypeRecord:
+		return nil, fmt.Errorf("%w: shared content at %d is not a body", ErrCorruptRecord, off)
+	}
+	if sum != 0 && checksum(content) != sum {
+		return nil, fmt.Errorf("%w: %w", ErrCorruptRecord, ErrChecksum)
+	}
+	return content, nil
+}
+
+// dataSize is contentSize for a data line that may be shared.
+func (db *DB) dataSize(ln []byte) (int64, error) {
+	if !shared(ln) {
+		return contentSize(ln), nil
+	}
+	r, err := parse(ln)
+	if err != nil {
+		return 0, err
+	}
+	content, err := db.body(r.Shared, r.Checksum)
+	return int64(len(content)), err
+}
+
+// unshare returns a shared data or history line written out in full.
+func (db *DB) unshare(ln []byte) ([]byte, error) {
+	r, err := parse(ln)
+	if err != nil {
+		return nil, err
+	}
+	content, err := db.body(r.Shared, r.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	rec := &Record{Type: r.Type, ID: r.ID, Timestamp: r.Timestamp, Label: r.Label}
+	db.seal(rec, string(content))
+	return json.Marshal(rec)
+}
+
+// deduper picks the bodies a rebuild shares, by the hash of their
+// content, as the records are written to out.
+type deduper struct {
+	out    storage
+	aead   cipher.AEAD // the key the output is sealed with
+	bodies map[uint64]int64
+	n      int // shared records written
+}
+
+// share returns the line to write at off for the current record ln:
+// a shared record if an earlier body holds the same content, or ln
+// itself, noted as a body if it may become one.
+func (d *deduper) share(ln []byte, off int64) ([]byte, error) {
+	r, err := decodeWith(ln, d.aead)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Data) < minShared {
+		return ln, nil
+	}
+	h := xxh3.HashString(r.Data)
+	at, ok := d.bodies[h]
+	if !ok {
+		d.bodies[h] = off
+		return ln, nil
+	}
+	// A hash match is confirmed against the body as written.
+	body, err := line(d.out, at)
+	if err != nil {
+		return nil, fmt.Errorf("read body at %d: %w", at, err)
+	}
+	b, err := decodeWith(body, d.aead)
+	if err != nil || b.Data != r.Data {
+		return ln, err
+	}
+	ref, err := json.Marshal(&Record{
+		Type:      r.Type,
+		ID:        r.ID,
+		Timestamp: r.Timestamp,
+		Label:     r.Label,
+		Checksum:  checksum([]byte(r.Data)),
+		Shared:    at,
+	})
+	if err != nil || len(ref) >= len(ln) {
+		return ln, err
+	}
+	d.n++
+	return ref, nil
+}
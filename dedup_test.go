@@ -0,0 +1,219 @@
+package folio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sharedRecords counts the shared records in db's file.
+func sharedRecords(t *testing.T, db *DB) int {
+	t.Helper()
+	n := 0
+	for rec, err := range db.Scan(ScanOptions{Types: []int{TypeRecord, TypeHistory}}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shared(rec.Raw) {
+			n++
+		}
+	}
+	return n
+}
+
+// checkShared verifies that every read path returns each label's
+// content.
+func checkShared(t *testing.T, db *DB, want map[string]string, when string) {
+	t.Helper()
+	for lbl, content := range want {
+		if got, err := db.Get(lbl); err != nil || got != content {
+			t.Errorf("%s: Get(%s) = %.20q, %v", when, lbl, got, err)
+		}
+		r, err := db.GetReader(lbl)
+		if err != nil {
+			t.Fatalf("%s: GetReader(%s): %v", when, lbl, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || string(got) != content {
+			t.Errorf("%s: GetReader(%s) = %.20q, %v", when, lbl, got, err)
+		}
+		if st, err := db.Stat(lbl); err != nil || st.Size != int64(len(content)) {
+			t.Errorf("%s: Stat(%s).Size = %d, %v; want %d", when, lbl, st.Size, err, len(content))
+		}
+	}
+	n := 0
+	for d, err := range db.All() {
+		if err != nil {
+			t.Fatalf("%s: All: %v", when, err)
+		}
+		if d.Data != want[d.Label] {
+			t.Errorf("%s: All yields %s = %.20q", when, d.Label, d.Data)
+		}
+		n++
+	}
+	if n != len(want) {
+		t.Errorf("%s: All yields %d documents, want %d", when, n, len(want))
+	}
+	checkSizes(t, db, when)
+	if rep, err := db.Verify(VerifyOptions{}); err != nil || !rep.OK() {
+		t.Errorf("%s: Verify = %+v, %v", when, rep.Problems, err)
+	}
+}
+
+// TestDedupContent verifies that compaction stores content shared by
+// several documents once, that every read path restores it, including
+// once its body is retired, and that a rebuild without the option writes
+// it out in full again.
+func TestDedupContent(t *testing.T) {
+	for _, key := range [][]byte{nil, testKey} {
+		t.Run(fmt.Sprintf("encrypted=%v", key != nil), func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "dedup.folio")
+			db, err := Open(path, Config{DedupContent: true, EncryptionKey: key})
+			if err != nil {
+				t.Fatal(err)
+			}
+			full, err := Open(filepath.Join(dir, "full.folio"), Config{EncryptionKey: key})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer full.Close()
+
+			template := strings.Repeat(`{"theme":"dark","layout":"wide"} `, 20)
+			want := map[string]string{"other": "unrelated", "short": "x"}
+			for i := range 10 {
+				want[fmt.Sprintf("tenant/%d", i)] = template
+			}
+			for _, d := range []*DB{db, full} {
+				for lbl, content := range want {
+					d.Set(lbl, content)
+				}
+				d.Set("short2", "x")
+				if err := d.Compact(); err != nil {
+					t.Fatalf("Compact: %v", err)
+				}
+			}
+			want["short2"] = "x"
+
+			if got := sharedRecords(t, db); got != 9 {
+				t.Errorf("file holds %d shared records, want 9", got)
+			}
+			if db.header.Flags&flagSharedContent == 0 {
+				t.Error("header does not have the shared flag")
+			}
+			small, _ := size(db.reader)
+			large, _ := size(full.reader)
+			if small*3 > large {
+				t.Errorf("deduplicated file is %d bytes, full file %d: want under a third", small, large)
+			}
+			checkShared(t, db, want, "compacted")
+
+			n := 0
+			for m, err := range db.Search("layout", SearchOptions{}) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasPrefix(m.Label, "tenant/") {
+					t.Errorf("Search matched %s", m.Label)
+				}
+				n++
+			}
+			if n < 10 {
+				t.Errorf("Search matched %d times, want at least 10", n)
+			}
+
+			// Retire the body and a shared record: the others read the
+			// body's snapshot.
+			var body string
+			for rec, err := range db.Scan(ScanOptions{Types: []int{TypeRecord}}) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if lbl := label(rec.Raw); strings.HasPrefix(lbl, "tenant/") && !shared(rec.Raw) {
+					body = lbl
+				}
+			}
+			for _, lbl := range []string{body, "tenant/4"} {
+				db.Set(lbl, "changed "+lbl)
+				want[lbl] = "changed " + lbl
+			}
+			db.Delete("tenant/9")
+			delete(want, "tenant/9")
+			checkShared(t, db, want, "bodies retired")
+			for _, lbl := range []string{body, "tenant/4"} {
+				var got []string
+				for v, err := range db.History(lbl) {
+					if err != nil {
+						t.Fatalf("History(%s): %v", lbl, err)
+					}
+					got = append(got, v.Data)
+				}
+				if len(got) != 2 || got[0] != template || got[1] != want[lbl] {
+					t.Errorf("History(%s) = %.20q", lbl, got)
+				}
+			}
+
+			if err := db.Compact(); err != nil {
+				t.Fatalf("second Compact: %v", err)
+			}
+			checkShared(t, db, want, "compacted again")
+			db.Close()
+
+			// Without the option, the next rebuild writes it all out.
+			db, err = Open(path, Config{EncryptionKey: key})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if err := db.Compact(); err != nil {
+				t.Fatalf("Compact without DedupContent: %v", err)
+			}
+			if got := sharedRecords(t, db); got != 0 || db.header.Flags&flagSharedContent != 0 {
+				t.Errorf("after a full rebuild: %d shared records, flags %b", got, db.header.Flags)
+			}
+			checkShared(t, db, want, "unshared")
+		})
+	}
+}
+
+// TestDedupCorruptBody verifies that a shared record whose body no
+// longer holds its content is reported as corrupt, not returned.
+func TestDedupCorruptBody(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{DedupContent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	content := strings.Repeat("shared content ", 10)
+	db.Set("a", content)
+	db.Set("b", content)
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	var body int64 = -1
+	for rec, err := range db.Scan(ScanOptions{Types: []int{TypeRecord}}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !shared(rec.Raw) {
+			body = rec.Offset
+		}
+	}
+	if body < 0 {
+		t.Fatal("no body found")
+	}
+	ln, _ := line(db.reader, body)
+	i := int64(bytes.Index(ln, []byte("shared content")))
+	db.writer.WriteAt([]byte("SHARED"), body+i)
+
+	for _, lbl := range []string{"a", "b"} {
+		if _, err := db.Get(lbl); err == nil {
+			t.Errorf("Get(%s) succeeded with a damaged body", lbl)
+		}
+	}
+}
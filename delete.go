@@ -1,28 +1,86 @@
 // Soft deletion — the record is converted to history so its compressed
 // snapshot survives for version retrieval, but it no longer appears in
-// lookups or listings because its index is erased.
+// lookups or listings because its index is erased. A tombstone is then
+// appended to date the delete for Changes (see journal.go).
 package folio
 
 import (
 	"bytes"
 	"fmt"
 	"strings"
+	"time"
 )
 
 // Delete soft-removes a document. The record's compressed history snapshot
 // is preserved; only Purge permanently removes it.
-func (db *DB) Delete(label string) error {
+func (db *DB) Delete(label string) (err error) {
+	defer db.observe(OpDelete, label, time.Now(), &err)
+
 	if err := db.blockWrite(); err != nil {
 		return err
 	}
 
-	err := db.delete(label)
+	err = db.delete(label)
 
 	// Check threshold under lock, compact after release (see set.go).
 	compact := err == nil && db.shouldCompact()
+	events := db.takeEvents()
+	db.mu.Unlock()
+	db.lock.Unlock()
+
+	if err == nil {
+		err = db.durable()
+	}
+	db.after(events)
+	if compact {
+		db.Compact()
+	}
+	return err
+}
+
+// DeleteMany soft-removes several documents as one transaction: either
+// all of them are deleted or, if any label is empty or not found, none
+// are and the error names the first such label. Duplicate labels are
+// deleted once. With SyncWrites the whole call costs two fsyncs — the
+// transaction record, then the retirements — however many labels it
+// is given.
+func (db *DB) DeleteMany(labels ...string) (err error) {
+	defer db.observe(OpDeleteMany, "", time.Now(), &err)
+
+	for _, lbl := range labels {
+		if lbl == "" {
+			return ErrInvalidLabel
+		}
+	}
+
+	if err := db.blockWrite(); err != nil {
+		return err
+	}
+
+	tx := &Txn{db: db, docs: map[string]*txnDoc{}}
+	for _, lbl := range labels {
+		if d, ok := tx.docs[db.fold(lbl)]; ok && !d.present {
+			continue // duplicate
+		}
+		if err = tx.Delete(lbl); err != nil {
+			err = fmt.Errorf("delete: %s: %w", lbl, err)
+			break
+		}
+	}
+	if err == nil {
+		err = tx.commit()
+	}
+
+	// Same pattern as Set: check threshold under lock, compact after release.
+	compact := err == nil && db.shouldCompact()
+	events := db.takeEvents()
 	db.mu.Unlock()
 	db.lock.Unlock()
 
+	if err == nil {
+		err = db.durable()
+	}
+	db.after(events)
 	if compact {
 		db.Compact()
 	}
@@ -31,42 +89,68 @@ func (db *DB) Delete(label string) error {
 
 // delete performs the soft-removal. The write lock must be held.
 func (db *DB) delete(label string) error {
-	id := hash(label, db.header.Algorithm)
+	id := db.id(label)
 
-	result := scan(db.reader, id, db.indexStart(), db.indexEnd(), TypeIndex)
-	if result != nil {
-		idx, err := decodeIndex(result.Data)
-		if err != nil {
+	result, idx, err := db.sorted(id)
+	if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	if result != nil && db.same(idx.Label, label) {
+		label = idx.Label // as stored, for the in-memory structures
+		if err := db.beforeDelete(label); err != nil {
+			return err
+		}
+		if err := blank(db, idx.Offset, result); err != nil {
 			return fmt.Errorf("delete: %w", err)
 		}
-		if idx.Label == label {
-			if err := blank(db, idx.Offset, result); err != nil {
-				return fmt.Errorf("delete: %w", err)
-			}
-			db.count.Add(^uint64(0)) // unsigned decrement: ^uint64(0) == max uint64 == -1 in twos-complement
-			return nil
+		db.usage.writes.Add(1)
+		db.ops.deletes.Add(1)
+		if db.labels != nil {
+			db.labels.remove(label)
 		}
+		if err := db.dropTags(label); err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+		db.unindex(label)
+		db.count.Add(^uint64(0)) // unsigned decrement: ^uint64(0) == max uint64 == -1 in twos-complement
+		if err := db.tombstone(label, "", now()); err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+		db.wroteDelete(label)
+		return nil
 	}
 
 	sz, err := size(db.reader)
 	if err != nil {
 		return fmt.Errorf("delete: stat: %w", err)
 	}
-	// Reverse iterate: newest version is at the highest offset (see set.go).
-	results := sparse(db.reader, id, db.sparseStart(), sz, TypeIndex)
-	for i := len(results) - 1; i >= 0; i-- {
-		result := results[i]
-		idx, err := decodeIndex(result.Data)
-		if err != nil {
+	result, idx, err = db.newest(id, label, sz)
+	if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	if result != nil {
+		label = idx.Label
+		if err := db.beforeDelete(label); err != nil {
+			return err
+		}
+		if err := blank(db, idx.Offset, result); err != nil {
 			return fmt.Errorf("delete: %w", err)
 		}
-		if idx.Label == label {
-			if err := blank(db, idx.Offset, &result); err != nil {
-				return fmt.Errorf("delete: %w", err)
-			}
-			db.count.Add(^uint64(0)) // unsigned decrement
-			return nil
+		db.usage.writes.Add(1)
+		db.ops.deletes.Add(1)
+		if db.labels != nil {
+			db.labels.remove(label)
 		}
+		if err := db.dropTags(label); err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+		db.unindex(label)
+		db.count.Add(^uint64(0)) // unsigned decrement
+		if err := db.tombstone(label, "", now()); err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+		db.wroteDelete(label)
+		return nil
 	}
 
 	return ErrNotFound
@@ -75,26 +159,43 @@ func (db *DB) delete(label string) error {
 // blank retires a record: patches its type from Record to History (2→3),
 // overwrites _d with spaces so it doesn't appear in content searches,
 // and erases the index line so the document is no longer discoverable.
-// The _h field is left intact for version retrieval.
+// The _h field is left intact for version retrieval. The type byte and
+// _d lie on the same line, so they are patched with one write, and the
+// patches are synced together once both are made rather than each alone.
 func blank(db *DB, dataOff int64, idx *Result) error {
-	if err := db.writeAt(dataOff+TypePos, []byte("3")); err != nil {
-		return fmt.Errorf("retype record: %w", err)
-	}
-
 	record, err := line(db.reader, dataOff)
 	if err != nil {
 		return fmt.Errorf("read record: %w", err)
 	}
+	if db.sizes != nil {
+		if n, err := db.dataSize(record); err != nil {
+			db.sizes.lost()
+		} else {
+			db.sizes.retired(n, len(record))
+		}
+	}
+
+	lazy := db.lazySync
+	db.lazySync = true
+	defer func() { db.lazySync = lazy }()
+
+	patch := record[TypePos : TypePos+1]
 	dStart := strings.Index(string(record), `"_d":"`) + 6
 	dEnd := strings.Index(string(record), `","_h":"`)
 	if dStart > 5 && dEnd > dStart {
-		if err := db.writeAt(dataOff+int64(dStart), bytes.Repeat([]byte(" "), dEnd-dStart)); err != nil {
-			return fmt.Errorf("blank content: %w", err)
-		}
+		patch = record[TypePos:dEnd]
+		copy(patch[dStart-TypePos:], bytes.Repeat([]byte(" "), dEnd-dStart))
+	}
+	patch[0] = '0' + TypeHistory
+	if err := db.writeAt(dataOff+TypePos, patch); err != nil {
+		return fmt.Errorf("retire record: %w", err)
 	}
 
 	if err := db.writeAt(idx.Offset, bytes.Repeat([]byte(" "), idx.Length)); err != nil {
 		return fmt.Errorf("erase index: %w", err)
 	}
-	return nil
+	if lazy {
+		return nil
+	}
+	return db.sync()
 }
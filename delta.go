@@ -0,0 +1,335 @@
+// Delta-encoded history.
+//
+// Every record's _h is normally a complete compressed snapshot, so a
+// large document edited often stores its full content once per version.
+// With Config.DeltaHistory set, Compact and Repair instead write each
+// history record's _h as a patch against the version before it, flagged
+// with _p, whenever the patch is smaller than the content. A full
+// snapshot is kept at least every maxDeltaRun+1 versions, which bounds
+// the patches applied to restore any one version.
+//
+// Deltas point backwards, at older versions, because the newer end of a
+// chain is the end that changes between compactions: Set retires the
+// current record in place with its full snapshot, and a same-length
+// Rename moves it to another label. The older versions a delta is built
+// on only ever disappear at a rebuild, which restores every version
+// first and re-chunks what retention keeps. Records written between
+// compactions are always full, and current records are never deltas.
+//
+// The base of a delta is the record before it among its document's data
+// and history records in write order, as revisions returns them. Readers
+// walk a document's versions forward, patching each delta onto the
+// version just restored (see chain). _k is the checksum of the restored
+// content, not of the patch.
+//
+// A patch is a sequence of operations, each a uvarint holding a length
+// shifted left by one with the low bit set for an insert. A copy is
+// followed by a uvarint offset and copies that many bytes of the base
+// from it; an insert is followed by that many literal bytes. Patches are
+// found by the line diff Diff uses and stored as snapshots are: Zstd,
+// then encrypted if the file is, then Ascii85.
+//
+// The header records with _f bit 3 that the file may hold deltas, so a
+// rebuild without the option converts them back to full snapshots.
+package folio
+
+import (
+	"cmp"
+	enc "encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	json "github.com/goccy/go-json"
+)
+
+// maxDeltaRun is the most delta records written in a row for one
+// document before the next history record is a full snapshot.
+const maxDeltaRun = 15
+
+// deltaBudget bounds the memory, in ints, the diff behind one patch may
+// use. Versions that differ too much for it are stored in full.
+const deltaBudget = 1 << 22
+
+// makeDelta returns a patch that turns base into target, or nil if it
+// would not be smaller than target.
+func makeDelta(base, target []byte) []byte {
+	script, ok := boundedEdits(strings.SplitAfter(string(base), "\n"), strings.SplitAfter(string(target), "\n"), deltaBudget)
+	if !ok {
+		return nil
+	}
+
+	var patch []byte
+	pos := 0          // offset in base of the next line
+	copyAt, n := 0, 0 // pending copy
+	var insert []byte // pending insert
+	flush := func() {
+		if n > 0 {
+			patch = enc.AppendUvarint(patch, uint64(n)<<1)
+			patch = enc.AppendUvarint(patch, uint64(copyAt))
+			n = 0
+		}
+		if len(insert) > 0 {
+			patch = enc.AppendUvarint(patch, uint64(len(insert))<<1|1)
+			patch = append(patch, insert...)
+			insert = insert[:0]
+		}
+	}
+	for _, l := range script {
+		switch l.Op {
+		case ' ':
+			if len(insert) > 0 || n > 0 && copyAt+n != pos {
+				flush()
+			}
+			if n == 0 {
+				copyAt = pos
+			}
+			n += len(l.Text)
+			pos += len(l.Text)
+		case '-':
+			pos += len(l.Text)
+		case '+':
+			if n > 0 {
+				flush()
+			}
+			insert = append(insert, l.Text...)
+		}
+		if len(patch) >= len(target) {
+			return nil
+		}
+	}
+	flush()
+	if len(patch) >= len(target) {
+		return nil
+	}
+	return patch
+}
+
+// applyDelta returns base with patch applied.
+func applyDelta(base, patch []byte) ([]byte, error) {
+	var out []byte
+	for len(patch) > 0 {
+		op, k := enc.Uvarint(patch)
+		if k <= 0 {
+			return nil, fmt.Errorf("%w: malformed delta", ErrCorruptRecord)
+		}
+		patch = patch[k:]
+		n := op >> 1
+		if op&1 == 1 {
+			if n > uint64(len(patch)) {
+				return nil, fmt.Errorf("%w: malformed delta", ErrCorruptRecord)
+			}
+			out = append(out, patch[:n]...)
+			patch = patch[n:]
+			continue
+		}
+		from, k := enc.Uvarint(patch)
+		if k <= 0 || from > uint64(len(base)) || n > uint64(len(base))-from {
+			return nil, fmt.Errorf("%w: malformed delta", ErrCorruptRecord)
+		}
+		patch = patch[k:]
+		out = append(out, base[from:from+n]...)
+	}
+	return out, nil
+}
+
+// restore returns the content of r, given the content of the version
+// before it if r is a delta, and verifies it against _k.
+func (db *DB) restore(r *Record, prev []byte) ([]byte, error) {
+	content, err := db.snapshot(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Delta {
+		if prev == nil {
+			return nil, fmt.Errorf("%w: delta without a base", ErrCorruptRecord)
+		}
+		if content, err = applyDelta(prev, content); err != nil {
+			return nil, err
+		}
+	}
+	if !r.verify(content) {
+		return nil, fmt.Errorf("%w: %w", ErrCorruptRecord, ErrChecksum)
+	}
+	if content == nil {
+		content = []byte{} // nil is no base
+	}
+	return content, nil
+}
+
+// restoreAt returns the version of label held by the record at offset,
+// restored from the versions before it. The caller must hold db.mu
+// (read or write).
+func (db *DB) restoreAt(label string, offset int64) (Version, error) {
+	records, offsets, err := db.located(label)
+	if err != nil {
+		return Version{}, err
+	}
+	i := slices.Index(offsets, offset)
+	if i < 0 {
+		return Version{}, fmt.Errorf("history: %w: record at %d not in its document", ErrCorruptRecord, offset)
+	}
+	return db.chain(records).version(i)
+}
+
+// chain restores versions from one document's records in write order,
+// as revisions returns them. It keeps the last version it restored, so
+// walking forward patches each delta once. It is not safe for
+// concurrent use.
+type chain struct {
+	db      *DB
+	records []*Record
+	at      int // index of content, or -1
+	content []byte
+}
+
+func (db *DB) chain(records []*Record) *chain {
+	return &chain{db: db, records: records, at: -1}
+}
+
+// version returns the version records[i] holds.
+func (c *chain) version(i int) (Version, error) {
+	if i != c.at {
+		// Back to a full snapshot, or to the version after the one held.
+		j := i
+		for c.records[j].Delta && j-1 != c.at && j > 0 {
+			j--
+		}
+		for ; j <= i; j++ {
+			var prev []byte
+			if j-1 == c.at {
+				prev = c.content
+			}
+			content, err := c.db.restore(c.records[j], prev)
+			if err != nil {
+				c.at, c.content = -1, nil
+				return Version{}, fmt.Errorf("history: %w", err)
+			}
+			c.at, c.content = j, content
+		}
+	}
+	return Version{string(c.content), c.records[i].Timestamp}, nil
+}
+
+// rechunk works out the history records a rebuild writes differently
+// from the file. heap is sorted by ID then timestamp, the order the
+// rebuild writes it in, and kept is the part of it retention keeps.
+// Every version in heap is restored first, in the order it was written,
+// so a delta whose base is dropped can still be rewritten. Kept history
+// records become deltas on the kept version before them when deltas is
+// set and the patch is smaller, or full snapshots if they are deltas
+// now; others are copied as they are. It returns the new lines by
+// source offset, the number of deltas among them, and kept without the
+// records that could not be restored, which only salvage allows.
+func (db *DB) rechunk(heap, kept []Entry, deltas, salvage bool) (map[int64][]byte, int, []Entry, error) {
+	keep := make(map[int64]bool, len(kept))
+	for _, e := range kept {
+		keep[e.SrcOff] = true
+	}
+	out := map[int64][]byte{}
+	drop := map[int64]bool{}
+	written := 0
+
+	type doc struct {
+		base []byte // the last kept version; nil if it cannot be read
+		run  int    // deltas since the last full snapshot
+	}
+	for i := 0; i < len(heap); {
+		j := i
+		for j < len(heap) && heap[j].ID == heap[i].ID {
+			j++
+		}
+
+		// Restore the group's versions in write order.
+		order := slices.SortedFunc(slices.Values(heap[i:j]), func(a, b Entry) int {
+			return cmp.Compare(a.SrcOff, b.SrcOff)
+		})
+		records := map[int64]*Record{}
+		contents := map[int64][]byte{}
+		prev := map[string][]byte{}
+		for _, e := range order {
+			data, err := line(db.reader, e.SrcOff)
+			var r *Record
+			if err == nil {
+				r, err = parse(data)
+			}
+			if err != nil {
+				if !salvage {
+					return nil, 0, nil, fmt.Errorf("repair: read record at %d: %w", e.SrcOff, err)
+				}
+				drop[e.SrcOff] = true
+				continue
+			}
+			records[e.SrcOff] = r
+			key := db.fold(r.Label)
+			content, err := db.restore(r, prev[key])
+			if errors.Is(err, ErrDecrypt) && !r.Delta {
+				// Encrypted under a key this handle lacks: copied as it
+				// is, with nothing built on it.
+				prev[key] = nil
+				continue
+			}
+			if err != nil {
+				if !salvage {
+					return nil, 0, nil, fmt.Errorf("repair: record at %d: %w", e.SrcOff, err)
+				}
+				prev[key] = nil
+				drop[e.SrcOff] = true
+				continue
+			}
+			prev[key] = content
+			contents[e.SrcOff] = content
+		}
+
+		// Encode the kept ones in the order they will be written.
+		docs := map[string]*doc{}
+		for _, e := range heap[i:j] {
+			if !keep[e.SrcOff] || drop[e.SrcOff] {
+				continue
+			}
+			r := records[e.SrcOff]
+			d := docs[db.fold(r.Label)]
+			if d == nil {
+				d = &doc{}
+				docs[db.fold(r.Label)] = d
+			}
+			content, ok := contents[e.SrcOff]
+			if !ok {
+				d.base, d.run = nil, 0
+				continue
+			}
+
+			rec := &Record{Type: TypeHistory, ID: r.ID, Timestamp: r.Timestamp, Label: r.Label}
+			var patch []byte
+			if e.Type == TypeHistory && deltas && d.base != nil && d.run < maxDeltaRun {
+				patch = makeDelta(d.base, content)
+			}
+			d.base = content
+			switch {
+			case patch != nil:
+				db.seal(rec, string(patch))
+				rec.Checksum = checksum(content)
+				rec.Delta = true
+				d.run++
+				written++
+			case r.Delta:
+				db.seal(rec, string(content))
+				d.run = 0
+			default:
+				d.run = 0
+				continue // copied as it is
+			}
+			var err error
+			if out[e.SrcOff], err = json.Marshal(rec); err != nil {
+				return nil, 0, nil, fmt.Errorf("repair: marshal record: %w", err)
+			}
+		}
+		i = j
+	}
+
+	if len(drop) > 0 {
+		kept = slices.DeleteFunc(kept, func(e Entry) bool { return drop[e.SrcOff] })
+	}
+	return out, written, kept, nil
+}
@@ -0,0 +1,366 @@
+// Delta history tests.
+//
+// A delta is only as good as the version it restores, so the patch
+// format is checked by round trip on random edits, and the file format
+// by reading a compacted delta chain through every path that restores
+// history: History, GetAt, GetVersion, Diff, Search, Export, a Snapshot,
+// and Verify. Rebuilds must keep the chains readable whatever retention
+// drops, and turn them back into full snapshots when the option is off.
+package folio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDeltaRoundTrip verifies that applying a patch gives back the
+// target for random line edits, that a small edit to a large document
+// makes a small patch, and that malformed patches are rejected.
+func TestDeltaRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	gen := func() []byte {
+		var b []byte
+		for range r.IntN(40) {
+			b = append(b, strings.Repeat(string(rune('a'+r.IntN(4))), 1+r.IntN(30))...)
+			if r.IntN(5) > 0 {
+				b = append(b, '\n')
+			}
+		}
+		return b
+	}
+	for range 2000 {
+		base, target := gen(), gen()
+		patch := makeDelta(base, target)
+		if patch == nil {
+			continue
+		}
+		if len(patch) >= len(target) {
+			t.Fatalf("makeDelta(%q, %q) is %d bytes, not smaller than the target", base, target, len(patch))
+		}
+		got, err := applyDelta(base, patch)
+		if err != nil || !bytes.Equal(got, target) {
+			t.Fatalf("applyDelta(%q, makeDelta) = %q, %v, want %q", base, got, err, target)
+		}
+	}
+
+	var doc strings.Builder
+	for i := range 1000 {
+		fmt.Fprintf(&doc, "line %d of a long document\n", i)
+	}
+	base := []byte(doc.String())
+	target := bytes.Replace(base, []byte("line 500 "), []byte("LINE 500 "), 1)
+	patch := makeDelta(base, target)
+	if patch == nil || len(patch) > 64 {
+		t.Fatalf("patch for a one-line edit is %d bytes, want a few", len(patch))
+	}
+	for n := range len(patch) {
+		if got, err := applyDelta(base, patch[:n]); err == nil && bytes.Equal(got, target) {
+			t.Fatalf("applyDelta of a patch truncated to %d bytes gave the target", n)
+		}
+	}
+	if _, err := applyDelta([]byte("short"), patch); !errors.Is(err, ErrCorruptRecord) {
+		t.Errorf("applyDelta on the wrong base = %v, want ErrCorruptRecord", err)
+	}
+}
+
+// deltaDoc is the content of version v of a long document, which each
+// version changes by one line.
+func deltaDoc(v int) string {
+	var b strings.Builder
+	for i := range 200 {
+		if i == v*7%200 {
+			fmt.Fprintf(&b, "version %d marker%d\n", v, v)
+		} else {
+			fmt.Fprintf(&b, "unchanged line %d of the document\n", i)
+		}
+	}
+	return b.String()
+}
+
+// writeVersions sets versions 0 to n-1 of deltaDoc at doc.
+func writeVersions(t *testing.T, db *DB, n int) {
+	t.Helper()
+	for v := range n {
+		if err := db.Set("doc", deltaDoc(v)); err != nil {
+			t.Fatalf("Set version %d: %v", v, err)
+		}
+		time.Sleep(time.Millisecond) // distinct timestamps, for GetAt
+	}
+}
+
+// checkVersions checks every read path against the versions written by
+// writeVersions, from start.
+func checkVersions(t *testing.T, db *DB, start, n int) {
+	t.Helper()
+	versions, err := Collect(db.History("doc"))
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(versions) != n-start {
+		t.Fatalf("History has %d versions, want %d", len(versions), n-start)
+	}
+	for i, v := range versions {
+		if v.Data != deltaDoc(start+i) {
+			t.Fatalf("History version %d differs from what was written", i)
+		}
+	}
+	reversed, err := Collect(db.HistoryWith("doc", HistoryOptions{Reverse: true}))
+	if err != nil || len(reversed) != len(versions) || reversed[0] != versions[len(versions)-1] {
+		t.Errorf("HistoryWith(Reverse) = %d versions, %v", len(reversed), err)
+	}
+	for _, i := range []int{0, len(versions) / 2, len(versions) - 1} {
+		if got, err := db.GetVersion("doc", i); err != nil || got != versions[i].Data {
+			t.Errorf("GetVersion(%d) = %v, want version %d", i, err, start+i)
+		}
+		if got, err := db.GetAt("doc", versions[i].TS); err != nil || got != versions[i].Data {
+			t.Errorf("GetAt(version %d) = %v, want version %d", start+i, err, start+i)
+		}
+	}
+	if d, err := db.Diff("doc", versions[0].TS, versions[1].TS); err != nil || len(d.Hunks) == 0 {
+		t.Errorf("Diff of the two oldest versions = %+v, %v", d, err)
+	}
+
+	mid := start + (n-start)/2
+	var hits []Match
+	for m, err := range db.Search(fmt.Sprintf("marker%d\n", mid), SearchOptions{IncludeHistory: true}) {
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		hits = append(hits, m)
+	}
+	if len(hits) != 1 || !hits[0].History {
+		t.Errorf("Search(IncludeHistory) for version %d = %+v, want one history match", mid, hits)
+	}
+
+	var exported, pinned bytes.Buffer
+	if err := db.Export(&exported, ExportOptions{}); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	snap, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	defer snap.Close()
+	if err := snap.Export(&pinned, ExportOptions{}); err != nil {
+		t.Fatalf("Snapshot.Export: %v", err)
+	}
+	if !bytes.Equal(exported.Bytes(), pinned.Bytes()) {
+		t.Error("Snapshot.Export differs from Export")
+	}
+	found := 0
+	for _, err := range snap.Search(fmt.Sprintf("marker%d\n", mid), SearchOptions{IncludeHistory: true}) {
+		if err != nil {
+			t.Fatalf("Snapshot.Search: %v", err)
+		}
+		found++
+	}
+	if found != 1 {
+		t.Errorf("Snapshot.Search(IncludeHistory) found %d matches, want 1", found)
+	}
+
+	if r := mustVerify(t, db, VerifyOptions{}); !r.OK() {
+		t.Errorf("Verify: %v", r.Problems)
+	}
+}
+
+// deltas counts the delta records in the file.
+func deltas(t *testing.T, db *DB) int {
+	t.Helper()
+	n := 0
+	for off, ln := range recordLines(t, db) {
+		r, err := parse(ln)
+		if err == nil && r.Delta {
+			if r.Type != TypeHistory {
+				t.Errorf("record at %d is a delta of type %d", off, r.Type)
+			}
+			n++
+		}
+	}
+	return n
+}
+
+// recordLines returns the file's lines by offset.
+func recordLines(t *testing.T, db *DB) map[int64][]byte {
+	t.Helper()
+	sz, err := size(db.reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[int64][]byte{}
+	for off := int64(HeaderSize); off < sz; {
+		ln, err := line(db.reader, off)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[off] = ln
+		off += int64(len(ln)) + 1
+	}
+	return out
+}
+
+// TestDeltaHistory verifies that compaction with DeltaHistory stores
+// history as deltas with a full snapshot after each run of
+// maxDeltaRun, that every read path restores the versions, and that the
+// file shrinks. Writes after compaction start a new run on the heap's
+// chain.
+func TestDeltaHistory(t *testing.T) {
+	for _, key := range [][]byte{nil, testKey} {
+		t.Run(fmt.Sprintf("encrypted=%v", key != nil), func(t *testing.T) {
+			dir := t.TempDir()
+			db, err := Open(filepath.Join(dir, "delta.folio"), Config{DeltaHistory: true, EncryptionKey: key})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			full, err := Open(filepath.Join(dir, "full.folio"), Config{EncryptionKey: key})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer full.Close()
+
+			const n = 20
+			writeVersions(t, db, n)
+			writeVersions(t, full, n)
+			db.Set("other", "unrelated")
+			for _, d := range []*DB{db, full} {
+				if err := d.Compact(); err != nil {
+					t.Fatalf("Compact: %v", err)
+				}
+			}
+
+			// 19 history records: a full one, 15 deltas, a full one, 2 deltas.
+			if got := deltas(t, db); got != n-3 {
+				t.Errorf("file holds %d deltas, want %d", got, n-3)
+			}
+			if db.header.Flags&flagDeltaHistory == 0 {
+				t.Error("header does not have the delta flag")
+			}
+			small, _ := size(db.reader)
+			large, _ := size(full.reader)
+			if small*3 > large {
+				t.Errorf("delta file is %d bytes, full file %d: want under a third", small, large)
+			}
+			checkVersions(t, db, 0, n)
+
+			// Appended versions are full; the next compaction chains them.
+			db.Set("doc", deltaDoc(n))
+			db.Set("doc", deltaDoc(n+1))
+			checkVersions(t, db, 0, n+2)
+			if err := db.Compact(); err != nil {
+				t.Fatalf("Compact: %v", err)
+			}
+			if got := deltas(t, db); got != n-1 {
+				t.Errorf("after a second compaction the file holds %d deltas, want %d", got, n-1)
+			}
+			checkVersions(t, db, 0, n+2)
+		})
+	}
+}
+
+// TestDeltaRetention verifies that a rebuild restores versions whose
+// bases retention drops, rewriting the oldest kept version in full.
+func TestDeltaRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{DeltaHistory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeVersions(t, db, 10)
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	db.Close()
+
+	db, err = Open(path, Config{DeltaHistory: true, HistoryRetention: Retention{MaxVersions: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact with retention: %v", err)
+	}
+	if got := deltas(t, db); got != 2 {
+		t.Errorf("file holds %d deltas, want 2", got)
+	}
+	checkVersions(t, db, 6, 10)
+}
+
+// TestDeltaHistoryOff verifies that a file with deltas reads the same
+// through a handle without the option, and that its next rebuild stores
+// every version in full and clears the flag.
+func TestDeltaHistoryOff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{DeltaHistory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeVersions(t, db, 8)
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	db.Close()
+
+	db, err = Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkVersions(t, db, 0, 8)
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if got := deltas(t, db); got != 0 {
+		t.Errorf("file holds %d deltas after compacting without the option, want 0", got)
+	}
+	if db.header.Flags&flagDeltaHistory != 0 {
+		t.Error("header still has the delta flag")
+	}
+	checkVersions(t, db, 0, 8)
+}
+
+// TestDeltaCorrupt verifies that a damaged delta is reported by the
+// reads that need it and by Verify, and that the versions before it
+// still read.
+func TestDeltaCorrupt(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{DeltaHistory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	writeVersions(t, db, 4)
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+
+	// Rewrite the first delta with a checksum that does not match.
+	for _, off := range slices.Sorted(maps.Keys(recordLines(t, db))) {
+		ln, _ := line(db.reader, off)
+		if r, err := parse(ln); err == nil && r.Delta {
+			bad := bytes.Replace(ln, []byte(fmt.Sprintf(`"_k":%d`, r.Checksum)), []byte(fmt.Sprintf(`"_k":%d`, r.Checksum^1)), 1)
+			if err := db.writeAt(off, bad); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+	}
+
+	if _, err := db.GetVersion("doc", 0); err != nil {
+		t.Errorf("GetVersion(0) before the damage: %v", err)
+	}
+	for _, i := range []int{1, 2} {
+		if _, err := db.GetVersion("doc", i); !errors.Is(err, ErrChecksum) {
+			t.Errorf("GetVersion(%d) = %v, want ErrChecksum", i, err)
+		}
+	}
+	if r := mustVerify(t, db, VerifyOptions{}); len(r.Problems) != 2 {
+		t.Errorf("Verify found %v, want the damaged delta and the one on it", r.Problems)
+	}
+}
@@ -0,0 +1,218 @@
+// Trained compression dictionaries.
+//
+// Zstd finds repeats within the content it is given, so a document of a
+// few kilobytes gives it little to work with and its snapshot is barely
+// smaller than the document. A dictionary trained on the documents
+// already stored supplies the field names and boilerplate they share up
+// front, and small snapshots compressed against it shrink several times
+// over.
+//
+// TrainDictionary samples the current documents, builds a dictionary,
+// stores it in the metadata record's _zd, and compresses every snapshot
+// written after it against it, as do later handles on the file. Each
+// Zstd frame names the dictionary it needs and frames written before any
+// training name none, so nothing already in the file is rewritten.
+// Dictionaries are never dropped for the same reason: training again
+// adds one and switches to it, and the older ones stay to read the
+// snapshots written with them.
+//
+// A dictionary is made of pieces of the documents it was trained on, so
+// with Config.EncryptionKey set it is stored encrypted. Dictionaries
+// apply to CodecZstd only.
+package folio
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	dictSize        = 32 << 10 // most bytes in one dictionary
+	dictSamples     = 2000     // most documents sampled
+	dictSampleBytes = 8 << 10  // bytes sampled from the start of each
+	dictMinSamples  = 8        // fewest documents worth training on
+)
+
+// dictFirstID is the first dictionary ID: Zstd reserves those below it.
+const dictFirstID = 32768
+
+// Dict is a dictionary stored in the metadata record.
+type Dict struct {
+	Data      string `json:"d"`           // Ascii85 of the dictionary, encrypted if Encrypted
+	Encrypted bool   `json:"x,omitempty"` // sealed with the file's key
+}
+
+// TrainDictionary builds a Zstd dictionary from a sample of the current
+// documents and compresses snapshots written from then on against it.
+// Existing snapshots keep reading as they are. Returns ErrNoDictionary
+// if there are too few documents, or too little they share, to build
+// one.
+//
+// Readers carry on throughout, and writers while the dictionary is
+// built, which is the slow part.
+func (db *DB) TrainDictionary() (err error) {
+	defer db.observe(OpTrain, "", time.Now(), &err)
+
+	if db.config.ReadOnly {
+		return ErrReadOnly
+	}
+	if db.header.Codec != CodecZstd {
+		return fmt.Errorf("train: dictionaries need CodecZstd, file uses codec %d", db.header.Codec)
+	}
+	// Held so two trainings cannot both take the next ID.
+	db.maint.Lock()
+	defer db.maint.Unlock()
+
+	db.beginScan()
+	defer db.endScan()
+	if err := db.blockRead(); err != nil {
+		return err
+	}
+	samples, err := db.samples()
+	id := uint32(dictFirstID)
+	if db.meta != nil {
+		id += uint32(len(db.meta.Dicts))
+	}
+	db.mu.RUnlock()
+	db.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	if len(samples) < dictMinSamples {
+		return ErrNoDictionary
+	}
+
+	raw, err := buildDict(samples, id, db.codec.level)
+	if err != nil {
+		return err
+	}
+
+	if err := db.blockWrite(); err != nil {
+		return err
+	}
+	defer func() {
+		db.mu.Unlock()
+		db.lock.Unlock()
+	}()
+	return db.addDict(raw)
+}
+
+// samples returns up to dictSamples documents spread evenly through the
+// file, each cut to dictSampleBytes. The caller must hold the read
+// lock.
+func (db *DB) samples() ([][]byte, error) {
+	every := max(1, int(db.count.Load())/dictSamples)
+	var out [][]byte
+	var cerr error
+	n := 0
+	err := db.documents(false, func(d docLine) bool {
+		n++
+		if (n-1)%every != 0 {
+			return true
+		}
+		content, err := db.content(d)
+		if err != nil {
+			cerr = fmt.Errorf("train: %w", err)
+			return false
+		}
+		// content may alias the scanner's buffer.
+		out = append(out, slices.Clone(content[:min(len(content), dictSampleBytes)]))
+		return len(out) < dictSamples
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, cerr
+}
+
+// buildDict trains a dictionary with the given ID on samples, tuned for
+// level.
+func buildDict(samples [][]byte, id uint32, level zstd.EncoderLevel) (raw []byte, err error) {
+	// The builder panics when the samples have nothing in common.
+	defer func() {
+		if recover() != nil {
+			raw, err = nil, ErrNoDictionary
+		}
+	}()
+	raw, err = dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: dictSize,
+		HashBytes:   6,
+		ZstdDictID:  id,
+		ZstdLevel:   level,
+	})
+	if err == nil {
+		_, err = zstd.InspectDictionary(raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoDictionary, err)
+	}
+	return raw, nil
+}
+
+// addDict stores raw in a new metadata record and switches the handle
+// to it. The caller must hold the write lock.
+func (db *DB) addDict(raw []byte) error {
+	d := Dict{Data: encode85(raw)}
+	if db.cipher != nil {
+		d = Dict{Data: encode85(encrypt(db.cipher, raw)), Encrypted: true}
+	}
+	prev := db.meta
+	m := Meta{Type: TypeMeta, ID: metaID}
+	if prev != nil {
+		m = *prev
+	}
+	m.Dicts = append(slices.Clone(m.Dicts), d)
+	db.meta = &m
+	old, err := db.saveMeta()
+	if err != nil {
+		db.meta = prev
+		return fmt.Errorf("train: %w", err)
+	}
+
+	db.header.Timestamp = max(now(), db.header.Timestamp+1)
+	hdrBytes, err := db.header.encode()
+	if err != nil {
+		return fmt.Errorf("train: encode header: %w", err)
+	}
+	if _, err := db.writer.WriteAt(hdrBytes, 0); err != nil {
+		return fmt.Errorf("train: write header: %w", err)
+	}
+	if err := db.writer.Sync(); err != nil {
+		return fmt.Errorf("train: sync: %w", err)
+	}
+	// The header now points at the new metadata record.
+	if err := db.blankMeta(old); err != nil {
+		return fmt.Errorf("train: %w", err)
+	}
+
+	db.codec = newCodec(db.header.Codec, db.config.Compression, db.dictionaries())
+	return nil
+}
+
+// dictionaries returns the stored dictionaries this handle can use,
+// oldest first. One it cannot decrypt or parse is left out, and the
+// snapshots written with it fail to decompress.
+func (db *DB) dictionaries() [][]byte {
+	if db.meta == nil {
+		return nil
+	}
+	var out [][]byte
+	for _, d := range db.meta.Dicts {
+		raw, err := decode85(d.Data)
+		if err == nil && d.Encrypted {
+			raw, err = decrypt(db.cipher, raw)
+		}
+		if err != nil {
+			continue
+		}
+		if _, err := zstd.InspectDictionary(raw); err != nil {
+			continue
+		}
+		out = append(out, raw)
+	}
+	return out
+}
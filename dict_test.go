@@ -0,0 +1,141 @@
+package folio
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// userDoc is a small JSON document of the kind dictionaries help.
+func userDoc(i int) string {
+	return fmt.Sprintf(`{"name":"user %d","email":"user%d@example.com","role":"member","active":true,"created":"2024-01-%02d","tags":["alpha","beta"]}`, i, i, i%28+1)
+}
+
+// snapshotSize returns the length of the _h of label's current record.
+func snapshotSize(t *testing.T, db *DB, label string) int {
+	t.Helper()
+	_, idx, err := db.findIndex(db.id(label), label, db.tail)
+	if err != nil || idx == nil {
+		t.Fatalf("findIndex(%q): %v", label, err)
+	}
+	ln, err := line(db.reader, idx.Offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := parse(ln)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(r.History)
+}
+
+func TestTrainDictionary(t *testing.T) {
+	for _, key := range [][]byte{nil, testKey} {
+		path := filepath.Join(t.TempDir(), "test.folio")
+		db, err := Open(path, Config{EncryptionKey: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range 200 {
+			db.Set(fmt.Sprintf("user-%d", i), userDoc(i))
+		}
+		before := snapshotSize(t, db, "user-7")
+
+		if err := db.TrainDictionary(); err != nil {
+			t.Fatalf("key %v: TrainDictionary: %v", key != nil, err)
+		}
+		db.Set("user-7", userDoc(1007))
+		if after := snapshotSize(t, db, "user-7"); after >= before*3/4 {
+			t.Errorf("key %v: snapshot with a dictionary = %d bytes, without = %d", key != nil, after, before)
+		}
+		if err := db.SetReader("streamed", strings.NewReader(userDoc(2000))); err != nil {
+			t.Fatalf("SetReader: %v", err)
+		}
+		if err := db.Compact(); err != nil {
+			t.Fatalf("Compact: %v", err)
+		}
+		db.Close()
+
+		db, err = Open(path, Config{EncryptionKey: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if db.meta == nil || len(db.meta.Dicts) != 1 || db.meta.Dicts[0].Encrypted != (key != nil) {
+			t.Fatalf("key %v: stored dictionaries = %+v", key != nil, db.meta)
+		}
+		// A second dictionary leaves snapshots written with the first
+		// readable.
+		db.Set("user-8", userDoc(1008))
+		if err := db.TrainDictionary(); err != nil {
+			t.Fatalf("second TrainDictionary: %v", err)
+		}
+		db.Set("user-8", userDoc(2008))
+		if len(db.codec.dicts) != 2 {
+			t.Fatalf("dictionaries in use = %d, want 2", len(db.codec.dicts))
+		}
+
+		for label, want := range map[string][]string{
+			"user-7":   {userDoc(7), userDoc(1007)},
+			"user-8":   {userDoc(8), userDoc(1008), userDoc(2008)},
+			"streamed": {userDoc(2000)},
+		} {
+			versions, err := Collect(db.History(label))
+			if err != nil || len(versions) != len(want) {
+				t.Fatalf("History(%q) = %d versions, %v", label, len(versions), err)
+			}
+			for i, v := range want {
+				if versions[i].Data != v {
+					t.Errorf("%s version %d = %q, want %q", label, i, versions[i].Data, v)
+				}
+			}
+		}
+		if r := mustVerify(t, db, VerifyOptions{}); !r.OK() {
+			t.Errorf("Verify: %v", r.Problems)
+		}
+		db.Close()
+	}
+}
+
+func TestTrainDictionaryErrors(t *testing.T) {
+	db, err := Open(":memory:", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Set("a", userDoc(1))
+	if err := db.TrainDictionary(); !errors.Is(err, ErrNoDictionary) {
+		t.Errorf("TrainDictionary with one document = %v, want ErrNoDictionary", err)
+	}
+	if db.meta != nil {
+		t.Errorf("failed training stored %+v", db.meta)
+	}
+
+	lz4, err := Open(":memory:", Config{Compression: Compression{Codec: CodecLZ4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lz4.Close()
+	for i := range 20 {
+		lz4.Set(fmt.Sprint(i), userDoc(i))
+	}
+	if err := lz4.TrainDictionary(); err == nil {
+		t.Error("TrainDictionary under CodecLZ4 succeeded")
+	}
+
+	path := filepath.Join(t.TempDir(), "test.folio")
+	w, err := Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	ro, err := Open(path, Config{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if err := ro.TrainDictionary(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("read-only TrainDictionary = %v, want ErrReadOnly", err)
+	}
+}
@@ -0,0 +1,287 @@
+// Line differences between versions.
+//
+// Diff reads two versions of a document under one read lock and compares
+// them line by line with Myers' algorithm, which finds a shortest edit
+// script in time proportional to the lengths times the number of edits,
+// so versions that differ by a few lines are cheap to compare however
+// long they are. Lines common to the start and end of both versions are
+// set aside before it runs.
+//
+// The result is grouped into hunks with three lines of context, as in a
+// unified diff, and Diff.String renders it in that format. Content is
+// split at '\n'; a final newline does not start another line, and
+// whether each version ends with one is not compared.
+package folio
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// diffContext is the number of unchanged lines kept around each change.
+const diffContext = 3
+
+// DiffLine is one line of a hunk. Op is ' ' for a line both versions
+// have, '-' for one only the first has, and '+' for one only the second
+// has.
+type DiffLine struct {
+	Op   byte
+	Text string
+}
+
+// Hunk is a run of changed lines with their context. Starts are 1-based
+// line numbers; a hunk that removes or adds every line it covers gives,
+// for the side with no lines, the number of the line before it.
+type Hunk struct {
+	FromStart, FromLines int
+	ToStart, ToLines     int
+	Lines                []DiffLine
+}
+
+// Diff is the line difference between two versions of a document.
+type Diff struct {
+	Label    string
+	From, To int64 // timestamps of the versions compared
+	Hunks    []Hunk
+}
+
+// String renders d as a unified diff, or "" if the versions are equal.
+func (d Diff) String() string {
+	if len(d.Hunks) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\t%d\n+++ %s\t%d\n", d.Label, d.From, d.Label, d.To)
+	for _, h := range d.Hunks {
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", span(h.FromStart, h.FromLines), span(h.ToStart, h.ToLines))
+		for _, l := range h.Lines {
+			b.WriteByte(l.Op)
+			b.WriteString(l.Text)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// span formats a hunk range, leaving out a count of one as diff does.
+func span(start, n int) string {
+	if n == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, n)
+}
+
+// Diff compares label as it was at unix ms time tsA with label as it
+// was at tsB, choosing each version as GetAt does. Returns ErrNotFound
+// if either time is before the first version.
+func (db *DB) Diff(label string, tsA, tsB int64) (_ Diff, err error) {
+	defer db.observe(OpDiff, label, time.Now(), &err)
+
+	if err := db.blockRead(); err != nil {
+		return Diff{}, err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+	db.usage.reads.Add(1)
+
+	records, err := db.revisions(label)
+	if err != nil {
+		return Diff{}, err
+	}
+	versions := db.chain(records)
+	at := func(ts int64) (*Record, Version, error) {
+		hit := -1
+		for i, r := range records {
+			if r.Timestamp <= ts {
+				hit = i
+			}
+		}
+		if hit < 0 {
+			return nil, Version{}, ErrNotFound
+		}
+		v, err := versions.version(hit)
+		return records[hit], v, err
+	}
+	_, a, err := at(tsA)
+	if err != nil {
+		return Diff{}, err
+	}
+	r, b, err := at(tsB)
+	if err != nil {
+		return Diff{}, err
+	}
+	db.usage.bytesRead.Add(uint64(len(a.Data) + len(b.Data)))
+
+	return Diff{
+		Label: r.Label,
+		From:  a.TS,
+		To:    b.TS,
+		Hunks: hunks(edits(lines(a.Data), lines(b.Data)), diffContext),
+	}, nil
+}
+
+// lines splits content into lines, not counting a final newline.
+func lines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// edits returns a shortest edit script turning x into y, one DiffLine
+// per line of either.
+func edits(x, y []string) []DiffLine {
+	script, _ := boundedEdits(x, y, -1)
+	return script
+}
+
+// boundedEdits is edits that gives up, returning false, once the
+// search's memory would pass budget ints. A negative budget is no limit.
+func boundedEdits(x, y []string, budget int) ([]DiffLine, bool) {
+	// Set aside the common prefix and suffix.
+	pre := 0
+	for pre < len(x) && pre < len(y) && x[pre] == y[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(x)-pre && suf < len(y)-pre && x[len(x)-1-suf] == y[len(y)-1-suf] {
+		suf++
+	}
+
+	mx, my := x[pre:len(x)-suf], y[pre:len(y)-suf]
+	limit := len(mx) + len(my)
+	if budget >= 0 {
+		// Each round of the search keeps a copy of v.
+		limit = min(limit, budget/(2*(len(mx)+len(my))+3))
+	}
+	middle, ok := myers(mx, my, limit)
+	if !ok {
+		return nil, false
+	}
+
+	var out []DiffLine
+	for _, l := range x[:pre] {
+		out = append(out, DiffLine{' ', l})
+	}
+	out = append(out, middle...)
+	for _, l := range x[len(x)-suf:] {
+		out = append(out, DiffLine{' ', l})
+	}
+	return out, true
+}
+
+// myers is Myers' greedy algorithm. v[k] holds, for each diagonal k =
+// i-j, the furthest i reached with d edits; the v of every round is kept
+// so the path can be traced back from the end. It returns false if more
+// than limit edits are needed.
+func myers(x, y []string, limit int) ([]DiffLine, bool) {
+	n, m := len(x), len(y)
+	off := n + m + 1
+	v := make([]int, 2*off+1)
+	var trace [][]int
+
+search:
+	for d := 0; ; d++ {
+		if d > limit {
+			return nil, false
+		}
+		trace = append(trace, slices.Clone(v))
+		for k := -d; k <= d; k += 2 {
+			var i int
+			if k == -d || k != d && v[off+k-1] < v[off+k+1] {
+				i = v[off+k+1] // down: a line of y inserted
+			} else {
+				i = v[off+k-1] + 1 // right: a line of x deleted
+			}
+			j := i - k
+			for i < n && j < m && x[i] == y[j] {
+				i, j = i+1, j+1
+			}
+			v[off+k] = i
+			if i >= n && j >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk back from (n, m), collecting the script in reverse.
+	var out []DiffLine
+	i, j := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := i - j
+		prev := k - 1
+		if k == -d || k != d && v[off+k-1] < v[off+k+1] {
+			prev = k + 1
+		}
+		pi := v[off+prev]
+		pj := pi - prev
+		for i > pi && j > pj {
+			i, j = i-1, j-1
+			out = append(out, DiffLine{' ', x[i]})
+		}
+		if d > 0 {
+			if i == pi {
+				j--
+				out = append(out, DiffLine{'+', y[j]})
+			} else {
+				i--
+				out = append(out, DiffLine{'-', x[i]})
+			}
+		}
+	}
+	slices.Reverse(out)
+	return out, true
+}
+
+// hunks groups an edit script into hunks, keeping up to context
+// unchanged lines around each change and merging hunks whose context
+// would touch.
+func hunks(script []DiffLine, context int) []Hunk {
+	var out []Hunk
+	from, to, at := 0, 0, 0 // lines of each version in script[:at]
+	advance := func(n int) {
+		for ; at < n; at++ {
+			switch script[at].Op {
+			case ' ':
+				from, to = from+1, to+1
+			case '-':
+				from++
+			case '+':
+				to++
+			}
+		}
+	}
+
+	for i := 0; i < len(script); {
+		if script[i].Op == ' ' {
+			i++
+			continue
+		}
+		end := i // the hunk's last change
+		for j := i + 1; j < len(script) && j-end-1 <= 2*context; j++ {
+			if script[j].Op != ' ' {
+				end = j
+			}
+		}
+		lo, hi := max(0, i-context), min(len(script), end+1+context)
+
+		advance(lo)
+		h := Hunk{FromStart: from, ToStart: to, Lines: script[lo:hi]}
+		advance(hi)
+		h.FromLines, h.ToLines = from-h.FromStart, to-h.ToStart
+		if h.FromLines > 0 {
+			h.FromStart++
+		}
+		if h.ToLines > 0 {
+			h.ToStart++
+		}
+		out = append(out, h)
+		i = hi
+	}
+	return out
+}
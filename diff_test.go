@@ -0,0 +1,152 @@
+// Diff tests.
+//
+// An edit script is correct if its kept and removed lines give back the
+// first version, its kept and added lines the second, and it changes
+// no more lines than the longest common subsequence requires. The
+// random test checks all three against a plain dynamic-programming LCS;
+// the others pin down hunk grouping and the unified format.
+package folio
+
+import (
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lcsLen is the textbook quadratic LCS length, to check myers against.
+func lcsLen(x, y []string) int {
+	prev := make([]int, len(y)+1)
+	for i := range x {
+		cur := make([]int, len(y)+1)
+		for j := range y {
+			if x[i] == y[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev = cur
+	}
+	return prev[len(y)]
+}
+
+// TestEdits verifies edit scripts for random line lists over a small
+// alphabet, where repeated lines make many scripts possible.
+func TestEdits(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	gen := func() []string {
+		out := make([]string, r.IntN(12))
+		for i := range out {
+			out[i] = string(rune('a' + r.IntN(4)))
+		}
+		return out
+	}
+	for range 2000 {
+		x, y := gen(), gen()
+		script := edits(x, y)
+		var gotX, gotY []string
+		changed := 0
+		for _, l := range script {
+			if l.Op != '+' {
+				gotX = append(gotX, l.Text)
+			}
+			if l.Op != '-' {
+				gotY = append(gotY, l.Text)
+			}
+			if l.Op != ' ' {
+				changed++
+			}
+		}
+		if !slices.Equal(gotX, x) || !slices.Equal(gotY, y) {
+			t.Fatalf("edits(%v, %v) = %v, does not give back both sides", x, y, script)
+		}
+		if want := len(x) + len(y) - 2*lcsLen(x, y); changed != want {
+			t.Fatalf("edits(%v, %v) changes %d lines, want %d", x, y, changed, want)
+		}
+	}
+}
+
+// TestDiffString verifies hunk grouping, context, line numbers, and the
+// rendered format: changes more than twice the context apart get their
+// own hunks, and an insertion into an empty version counts from line 0.
+func TestDiffString(t *testing.T) {
+	var a []string
+	for i := 1; i <= 20; i++ {
+		a = append(a, string(rune('a'+i-1)))
+	}
+	b := slices.Clone(a)
+	b[1] = "B"                             // line 2
+	b = slices.Insert(b, 16, "new")        // after line 16
+	b = slices.Delete(b, len(b)-1, len(b)) // line 20
+
+	d := Diff{Label: "doc", From: 1, To: 2, Hunks: hunks(edits(a, b), diffContext)}
+	want := `--- doc	1
++++ doc	2
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -14,7 +14,7 @@
+ n
+ o
+ p
++new
+ q
+ r
+ s
+-t
+`
+	if got := d.String(); got != want {
+		t.Errorf("String() =\n%s\nwant\n%s", got, want)
+	}
+
+	created := Diff{Hunks: hunks(edits(nil, []string{"x"}), diffContext)}
+	if h := created.Hunks[0]; h.FromStart != 0 || h.FromLines != 0 || h.ToStart != 1 || h.ToLines != 1 {
+		t.Errorf("hunk for an insertion into nothing = %+v, want -0,0 +1", h)
+	}
+	if s := (Diff{Hunks: hunks(edits(a, a), diffContext)}).String(); s != "" {
+		t.Errorf("String() of equal versions = %q, want empty", s)
+	}
+}
+
+// TestDiff verifies that Diff picks the versions GetAt would, across the
+// heap and the sparse region, and reports times before the first
+// version as ErrNotFound.
+func TestDiff(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "one\ntwo\nthree\n")
+	time.Sleep(2 * time.Millisecond)
+	db.Set("doc", "one\n2\nthree\n")
+	db.Compact()
+	time.Sleep(2 * time.Millisecond)
+	db.Set("doc", "one\n2\nthree\nfour\n")
+	versions, _ := Collect(db.History("doc"))
+
+	d, err := db.Diff("doc", versions[0].TS, versions[2].TS+1000)
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if d.From != versions[0].TS || d.To != versions[2].TS {
+		t.Errorf("Diff compared %d and %d, want %d and %d", d.From, d.To, versions[0].TS, versions[2].TS)
+	}
+	if got, want := d.String(), "@@ -1,3 +1,4 @@\n one\n-two\n+2\n three\n+four\n"; !strings.HasSuffix(got, want) {
+		t.Errorf("Diff =\n%s\nwant hunk\n%s", got, want)
+	}
+
+	back, _ := db.Diff("doc", versions[1].TS, versions[0].TS)
+	if len(back.Hunks) != 1 || back.Hunks[0].Lines[1] != (DiffLine{'-', "2"}) {
+		t.Errorf("Diff backwards = %+v, want 2 replaced by two", back.Hunks)
+	}
+	if _, err := db.Diff("doc", versions[0].TS-1, versions[1].TS); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Diff before the first version = %v, want ErrNotFound", err)
+	}
+	if _, err := db.Diff("missing", 0, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Diff(missing) = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,172 @@
+// Directory import and export.
+//
+// ImportDir and ExportDir map a directory tree onto labels, the file at
+// notes/2024/plan.md becoming the document "notes/2024/plan.md", so a
+// store of notes or config files can be moved in from the file system
+// and out again. Modification times travel with the content: a file is
+// imported dated by its mtime, and exported with its document's latest
+// write as its mtime.
+//
+// Both directions compare content first and skip what has not changed:
+// ImportDir hashes each file against the ContentHash of the document it
+// would replace, and ExportDir each document against the file it would
+// overwrite. Running either again after a small change writes only that
+// change, and an unchanged file adds no version to history.
+//
+// Neither deletes anything. A document whose file is gone, or a file
+// whose document is gone, is left where it is.
+package folio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zeebo/xxh3"
+)
+
+// ImportDir writes every regular file under dir as the document its
+// slash-separated path names, dated by the file's modification time, and
+// skips files whose content the document already has. A time before the
+// file format can hold (September 2001) dates the version at the earliest
+// time it can; a time older than the document's current version dates it
+// at that version's. Empty files are skipped, since a document cannot be
+// empty, and so are symbolic links.
+//
+// Each file is written as a Set of its own, so a failure leaves the files
+// before it imported. The error names the file.
+func (db *DB) ImportDir(dir string) (err error) {
+	defer db.observe(OpImport, "", time.Now(), &err)
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return fmt.Errorf("importdir: %w", err)
+	}
+	defer root.Close()
+
+	hashes := map[string]string{}
+	for d, err := range db.AllInfo() {
+		if err != nil {
+			return fmt.Errorf("importdir: %w", err)
+		}
+		hashes[db.fold(d.Label)] = d.ContentHash
+	}
+
+	return fs.WalkDir(root.FS(), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("importdir: %w", err)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("importdir: %w", err)
+		}
+		content, err := root.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("importdir: %w", err)
+		}
+		if len(content) == 0 || hashes[db.fold(path)] == fmt.Sprintf("%016x", xxh3.Hash(content)) {
+			return nil
+		}
+		at := min(max(info.ModTime().UnixMilli(), 1e12), 1e13-1)
+		if err := db.setAt(path, string(content), at); err != nil {
+			return fmt.Errorf("importdir: %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// setAt is Set dated at unix ms time at rather than now.
+func (db *DB) setAt(label, content string, at int64) error {
+	if err := db.checkDoc(label, content); err != nil {
+		return err
+	}
+	if err := db.blockWrite(); err != nil {
+		return err
+	}
+
+	err := db.setIf(label, content, 0, condAny, at)
+
+	// Same pattern as Set: check threshold under lock, compact after release.
+	compact := err == nil && db.shouldCompact()
+	events := db.takeEvents()
+	db.mu.Unlock()
+	db.lock.Unlock()
+
+	if err == nil {
+		err = db.durable()
+	}
+	db.after(events)
+	if compact {
+		db.Compact()
+	}
+	return err
+}
+
+// ExportDir writes every current document to the file under dir its
+// label names, creating directories as needed, and sets the file's
+// modification time to the document's latest write. A file that already
+// holds the content is left alone. Labels that are not valid fs paths
+// (see FS) are skipped. A label that is also the directory of others,
+// such as "a" beside "a/b", cannot be both a file and a directory, and
+// fails the export.
+//
+// The read lock is held throughout, so the files are a consistent
+// snapshot of the database.
+func (db *DB) ExportDir(dir string) (err error) {
+	defer db.observe(OpExport, "", time.Now(), &err)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("exportdir: %w", err)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return fmt.Errorf("exportdir: %w", err)
+	}
+	defer root.Close()
+
+	for d, err := range db.AllInfo() {
+		if err != nil {
+			return fmt.Errorf("exportdir: %w", err)
+		}
+		if !fs.ValidPath(d.Label) {
+			continue
+		}
+		name, err := filepath.Localize(d.Label)
+		if err != nil {
+			continue
+		}
+		if err := exportFile(root, name, d); err != nil {
+			return fmt.Errorf("exportdir: %s: %w", d.Label, err)
+		}
+	}
+	return nil
+}
+
+// exportFile writes one document under root unless the file there
+// already holds it.
+func exportFile(root *os.Root, name string, d DocumentInfo) error {
+	old, err := root.ReadFile(name)
+	if err == nil && bytes.Equal(old, []byte(d.Data)) {
+		return nil
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if dir := filepath.Dir(name); dir != "." {
+		if err := root.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	if err := root.WriteFile(name, []byte(d.Data), 0o644); err != nil {
+		return err
+	}
+	mtime := time.UnixMilli(d.Timestamp)
+	return root.Chtimes(name, mtime, mtime)
+}
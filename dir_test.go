@@ -0,0 +1,90 @@
+package folio
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestImportExportDir(t *testing.T) {
+	src := t.TempDir()
+	mtime := time.UnixMilli(1700000000000)
+	files := map[string]string{
+		"notes/plan.md":   "# Plan",
+		"notes/a/deep.md": "deep",
+		"config.yaml":     "theme: dark",
+	}
+	for name, content := range files {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mtime, mtime)
+	}
+	os.WriteFile(filepath.Join(src, "empty.txt"), nil, 0o644)
+
+	db, err := Open(":memory:", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.ImportDir(src); err != nil {
+		t.Fatalf("ImportDir: %v", err)
+	}
+	if db.Count() != len(files) {
+		t.Errorf("Count = %d, want %d", db.Count(), len(files))
+	}
+	for name, content := range files {
+		if got, err := db.Get(name); err != nil || got != content {
+			t.Errorf("Get(%q) = %q, %v", name, got, err)
+		}
+		if info, _ := db.Info(name); info.Modified != mtime.UnixMilli() {
+			t.Errorf("%s: Modified = %d, want the file's mtime %d", name, info.Modified, mtime.UnixMilli())
+		}
+	}
+
+	// Unchanged files add no versions; a changed one adds one.
+	os.WriteFile(filepath.Join(src, "config.yaml"), []byte("theme: light"), 0o644)
+	if err := db.ImportDir(src); err != nil {
+		t.Fatalf("second ImportDir: %v", err)
+	}
+	if vs, err := Collect(db.History("notes/plan.md")); err != nil || len(vs) != 1 {
+		t.Errorf("unchanged file has %d versions, want 1 (%v)", len(vs), err)
+	}
+	if vs, err := Collect(db.History("config.yaml")); err != nil || len(vs) != 2 {
+		t.Errorf("changed file has %d versions, want 2 (%v)", len(vs), err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "out")
+	db.Set("/not/a/path", "skipped")
+	if err := db.ExportDir(dst); err != nil {
+		t.Fatalf("ExportDir: %v", err)
+	}
+	for name, content := range files {
+		if name == "config.yaml" {
+			content = "theme: light"
+		}
+		path := filepath.Join(dst, filepath.FromSlash(name))
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != content {
+			t.Errorf("exported %s = %q, %v", name, got, err)
+		}
+		info, _ := db.Info(name)
+		if st, _ := os.Stat(path); !st.ModTime().Equal(time.UnixMilli(info.Modified)) {
+			t.Errorf("exported %s mtime = %v, want %v", name, st.ModTime(), time.UnixMilli(info.Modified))
+		}
+	}
+
+	// A second export leaves files already holding their content alone.
+	marker := time.UnixMilli(1600000000000)
+	plan := filepath.Join(dst, "notes", "plan.md")
+	os.Chtimes(plan, marker, marker)
+	if err := db.ExportDir(dst); err != nil {
+		t.Fatalf("second ExportDir: %v", err)
+	}
+	if st, _ := os.Stat(plan); !st.ModTime().Equal(marker) {
+		t.Errorf("unchanged file was rewritten")
+	}
+}
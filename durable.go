@@ -0,0 +1,97 @@
+// Group commit.
+//
+// SyncWrites makes every write durable before it returns by calling
+// fsync after each append and each in-place patch, so a Set that
+// replaces a document costs four of them and writers queue behind each
+// other's syncs. SyncInterval trades latency for throughput instead:
+// writes are not synced under the write lock, only counted. Once the
+// lock is released, each write call waits in durable until a sync that
+// covers it has finished. The first waiter becomes the leader: it waits
+// out the rest of the interval since the previous sync, so writes from
+// other goroutines join the batch, then syncs once for all of them and
+// wakes the rest. Under load that is one fsync per interval however
+// many writes land in it; a lone writer waits at most one interval.
+//
+// A write is acknowledged only once it is on disk, as with SyncWrites,
+// but the order of its appends and patches on disk is not enforced: the
+// kernel may write back the patch that retires a document's previous
+// version before the append that replaced it. A crash of the process
+// loses nothing, since the page cache survives it. A crash of the
+// machine within the interval can lose a write still waiting for its
+// sync and, for an update, the version it was replacing.
+package folio
+
+import (
+	"sync"
+	"time"
+)
+
+// groupSync coordinates the waiters of one DB.
+type groupSync struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	busy   bool      // a leader is waiting or syncing
+	synced uint64    // value of written covered by the last sync
+	last   time.Time // when the last sync finished
+	err    error     // result of the last sync
+}
+
+// sync makes the writes so far durable as configured: immediately with
+// SyncWrites, or, with SyncInterval, by counting them for the next
+// group sync. The write lock must be held.
+func (db *DB) sync() error {
+	switch {
+	case db.config.SyncInterval > 0:
+		db.written.Add(1)
+	case db.config.SyncWrites:
+		return db.writer.Sync()
+	}
+	return nil
+}
+
+// durable waits until every write counted so far has been synced. It
+// is a no-op unless SyncInterval is set. Write methods call it after
+// releasing their locks.
+func (db *DB) durable() error {
+	if db.config.SyncInterval <= 0 {
+		return nil
+	}
+	target := db.written.Load()
+	g := &db.group
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cond == nil {
+		g.cond = sync.NewCond(&g.mu)
+	}
+	for g.synced < target {
+		if g.busy {
+			g.cond.Wait()
+			continue
+		}
+		g.busy = true
+		wait := time.Until(g.last.Add(db.config.SyncInterval))
+		g.mu.Unlock()
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+		upto := db.written.Load()
+		err := db.syncFile()
+		g.mu.Lock()
+		g.busy = false
+		g.synced, g.err, g.last = upto, err, time.Now()
+		g.cond.Broadcast()
+	}
+	return g.err
+}
+
+// syncFile fsyncs the writer under the read lock, so a compaction
+// cannot swap the handle mid-call. After Close there is nothing to do:
+// Close syncs a file it has written to.
+func (db *DB) syncFile() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.state.Load() == StateClosed {
+		return nil
+	}
+	return db.writer.Sync()
+}
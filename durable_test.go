@@ -0,0 +1,71 @@
+// Group commit tests.
+//
+// SyncInterval is only worth having if concurrent writers share syncs
+// and no write returns before one has covered it; each test checks one
+// of those. Timings are generous so a slow machine does not fail them.
+package folio
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSyncIntervalCovers verifies that by the time a write returns, a
+// sync has covered every write counted before it.
+func TestSyncIntervalCovers(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{SyncInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := range 3 {
+		if err := db.Set("doc", fmt.Sprint(i)); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		db.group.mu.Lock()
+		synced := db.group.synced
+		db.group.mu.Unlock()
+		if w := db.written.Load(); synced < w {
+			t.Errorf("after Set %d: synced %d of %d writes", i, synced, w)
+		}
+	}
+	if err := db.Delete("doc"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+}
+
+// TestSyncIntervalGroups verifies concurrent writers share syncs: many
+// writes complete in a few intervals, not one interval each.
+func TestSyncIntervalGroups(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	const writers = 20
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{SyncInterval: interval})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Set("warm", "up") // the first sync has no interval to wait out
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.Set(fmt.Sprintf("doc-%d", i), "content"); err != nil {
+				t.Errorf("Set: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > writers/2*interval {
+		t.Errorf("%d writers took %v; syncs are not being shared", writers, elapsed)
+	}
+	if db.Count() != writers+1 {
+		t.Errorf("Count = %d, want %d", db.Count(), writers+1)
+	}
+}
@@ -9,10 +9,13 @@
 package folio
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestLabelExactly256Bytes verifies that a label at exactly MaxLabelSize
@@ -67,7 +70,7 @@ func TestEmptyLabel(t *testing.T) {
 	db := openTestDB(t)
 
 	err := db.Set("", "content")
-	if err != ErrInvalidLabel {
+	if !errors.Is(err, ErrInvalidLabel) {
 		t.Errorf("Set empty label: got %v, want ErrInvalidLabel", err)
 	}
 }
@@ -86,18 +89,18 @@ func TestFreshDatabaseEdgeCases(t *testing.T) {
 
 	// Get on empty
 	_, err := db.Get("nonexistent")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Get on empty: got %v, want ErrNotFound", err)
 	}
 
 	// Delete on empty
 	err = db.Delete("nonexistent")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Delete on empty: got %v, want ErrNotFound", err)
 	}
 
 	// List on empty
-	labels, _ := collect(db.List())
+	labels, _ := Collect(db.List())
 	if len(labels) != 0 {
 		t.Errorf("List on empty: got %d, want 0", len(labels))
 	}
@@ -109,7 +112,7 @@ func TestFreshDatabaseEdgeCases(t *testing.T) {
 	}
 
 	// History on empty
-	versions, _ := collect(db.History("nonexistent"))
+	versions, _ := Collect(db.History("nonexistent"))
 	if len(versions) != 0 {
 		t.Errorf("History on empty: got %d, want 0", len(versions))
 	}
@@ -207,6 +210,115 @@ func TestCrashRecoveryTmpFile(t *testing.T) {
 	}
 }
 
+// rebuilt returns the bytes of a freshly compacted file holding a single
+// document, standing in for a .tmp that Repair finished but never renamed.
+func rebuilt(t *testing.T, content string) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "src.folio")
+	db, _ := Open(path, Config{})
+	db.Set("doc", content)
+	db.Compact()
+	db.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestSalvageTmpCorruptOriginal verifies a complete .tmp is promoted when
+// the original's header is unreadable. Discarding it would turn a crash
+// mid-compaction into an unopenable database.
+func TestSalvageTmpCorruptOriginal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.folio")
+	os.WriteFile(path, append(bytes.Repeat([]byte("x"), HeaderSize-1), '\n'), 0644)
+	os.WriteFile(path+".tmp", rebuilt(t, "rebuilt"), 0644)
+
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	if data, _ := db.Get("doc"); data != "rebuilt" {
+		t.Errorf("Get = %q, want %q", data, "rebuilt")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error(".tmp should have been renamed over the original")
+	}
+}
+
+// TestSalvageTmpDirtyOriginal verifies a complete .tmp at least as new as
+// a dirty original is preferred over re-running repair on the original.
+func TestSalvageTmpDirtyOriginal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.folio")
+	db, _ := Open(path, Config{})
+	db.Set("doc", "original")
+	db.Close()
+	f, _ := os.OpenFile(path, os.O_RDWR, 0644)
+	dirty(f, true)
+	f.Close()
+
+	os.WriteFile(path+".tmp", rebuilt(t, "rebuilt"), 0644)
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(path+".tmp", future, future)
+
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	if data, _ := db.Get("doc"); data != "rebuilt" {
+		t.Errorf("Get = %q, want %q", data, "rebuilt")
+	}
+	if db.header.Error != 0 {
+		t.Error("promoted file should be clean")
+	}
+}
+
+// TestSalvageTmpRejected verifies a .tmp is discarded when it is torn or
+// older than a dirty original. Promoting it would lose the writes that
+// only the original holds.
+func TestSalvageTmpRejected(t *testing.T) {
+	tmpData := rebuilt(t, "rebuilt")
+	cases := map[string]struct {
+		tmp []byte
+		age time.Duration
+	}{
+		"torn":  {tmpData[:len(tmpData)-3], time.Hour},
+		"stale": {tmpData, -time.Hour},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "test.folio")
+			db, _ := Open(path, Config{})
+			db.Set("doc", "original")
+			db.Close()
+			f, _ := os.OpenFile(path, os.O_RDWR, 0644)
+			dirty(f, true)
+			f.Close()
+
+			os.WriteFile(path+".tmp", tc.tmp, 0644)
+			when := time.Now().Add(tc.age)
+			os.Chtimes(path+".tmp", when, when)
+
+			db, err := Open(path, Config{})
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer db.Close()
+			if data, _ := db.Get("doc"); data != "original" {
+				t.Errorf("Get = %q, want %q", data, "original")
+			}
+			if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+				t.Error(".tmp should be discarded")
+			}
+		})
+	}
+}
+
 // TestDoubleClose verifies that calling Close twice returns an error
 // on the second call rather than panicking. In production, a deferred
 // Close can run after an explicit Close in an error path. If the second
@@ -239,32 +351,32 @@ func TestOperationsAfterClose(t *testing.T) {
 	db.Close()
 
 	_, err := db.Get("doc")
-	if err != ErrClosed {
+	if !errors.Is(err, ErrClosed) {
 		t.Errorf("Get after close: got %v, want ErrClosed", err)
 	}
 
 	err = db.Set("doc", "new")
-	if err != ErrClosed {
+	if !errors.Is(err, ErrClosed) {
 		t.Errorf("Set after close: got %v, want ErrClosed", err)
 	}
 
 	err = db.Delete("doc")
-	if err != ErrClosed {
+	if !errors.Is(err, ErrClosed) {
 		t.Errorf("Delete after close: got %v, want ErrClosed", err)
 	}
 
 	_, err = db.Exists("doc")
-	if err != ErrClosed {
+	if !errors.Is(err, ErrClosed) {
 		t.Errorf("Exists after close: got %v, want ErrClosed", err)
 	}
 
-	_, err = collect(db.List())
-	if err != ErrClosed {
+	_, err = Collect(db.List())
+	if !errors.Is(err, ErrClosed) {
 		t.Errorf("List after close: got %v, want ErrClosed", err)
 	}
 
-	_, err = collect(db.History("doc"))
-	if err != ErrClosed {
+	_, err = Collect(db.History("doc"))
+	if !errors.Is(err, ErrClosed) {
 		t.Errorf("History after close: got %v, want ErrClosed", err)
 	}
 }
@@ -287,7 +399,7 @@ func TestDeleteFromSparse(t *testing.T) {
 	}
 
 	_, err = db.Get("doc")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Get after delete: got %v, want ErrNotFound", err)
 	}
 }
@@ -309,7 +421,7 @@ func TestDeleteFromSorted(t *testing.T) {
 	}
 
 	_, err = db.Get("doc")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Get after delete: got %v, want ErrNotFound", err)
 	}
 }
@@ -324,7 +436,7 @@ func TestExistsClosed(t *testing.T) {
 	db.Close()
 
 	_, err := db.Exists("doc")
-	if err != ErrClosed {
+	if !errors.Is(err, ErrClosed) {
 		t.Errorf("Exists on closed: got %v, want ErrClosed", err)
 	}
 }
@@ -343,7 +455,7 @@ func TestHistoryAfterCompact(t *testing.T) {
 	db.Set("doc", "v3")
 	db.Compact()
 
-	versions, err := collect(db.History("doc"))
+	versions, err := Collect(db.History("doc"))
 	if err != nil {
 		t.Fatalf("History: %v", err)
 	}
@@ -372,7 +484,7 @@ func TestHistoryMixedRegions(t *testing.T) {
 	db.Compact()
 	db.Set("doc", "v3")
 
-	versions, err := collect(db.History("doc"))
+	versions, err := Collect(db.History("doc"))
 	if err != nil {
 		t.Fatalf("History: %v", err)
 	}
@@ -401,7 +513,7 @@ func TestDeleteAfterCompactFromSorted(t *testing.T) {
 	}
 
 	_, err := db.Get("a")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Get deleted: got %v, want ErrNotFound", err)
 	}
 
@@ -429,7 +541,7 @@ func TestSetUpdateSorted(t *testing.T) {
 		t.Errorf("Get = %q, want %q", data, "v2")
 	}
 
-	versions, _ := collect(db.History("doc"))
+	versions, _ := Collect(db.History("doc"))
 	if len(versions) != 2 {
 		t.Errorf("History: got %d, want 2", len(versions))
 	}
@@ -12,11 +12,18 @@
 // bloom filter can accelerate negative lookups in the sparse region.
 package folio
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 // Sentinel errors for programmatic handling. Callers can use errors.Is to
 // distinguish recoverable conditions (ErrNotFound) from corruption
 // (ErrCorruptHeader, ErrCorruptRecord, ErrCorruptIndex, ErrDecompress).
+// A checksum failure wraps both ErrCorruptRecord and ErrChecksum. The
+// point operations and maintenance methods return them inside an *Error,
+// so compare with errors.Is, not ==.
 var (
 	ErrNotFound       = errors.New("document not found")
 	ErrExists         = errors.New("document already exists")
@@ -24,9 +31,91 @@ var (
 	ErrInvalidLabel   = errors.New("label contains invalid characters")
 	ErrEmptyContent   = errors.New("content cannot be empty")
 	ErrClosed         = errors.New("database is closed")
+	ErrReadOnly       = errors.New("database is read-only")
 	ErrInvalidPattern = errors.New("invalid regex pattern")
 	ErrCorruptHeader  = errors.New("corrupt header")
+	ErrFormatVersion  = errors.New("file format is newer than this library supports")
 	ErrCorruptRecord  = errors.New("corrupt record")
 	ErrCorruptIndex   = errors.New("corrupt index")
 	ErrDecompress     = errors.New("decompression failed")
+	ErrChecksum       = errors.New("checksum mismatch")
+	ErrDecrypt        = errors.New("decryption failed")
+	ErrInvalidTTL     = errors.New("ttl must be positive")
+	ErrInvalidCursor  = errors.New("invalid page cursor")
+	ErrInvalidTag     = errors.New("tag is empty, too long, or contains invalid characters")
+	ErrInvalidPath    = errors.New("invalid index name or field path")
+	ErrNoIndex        = errors.New("no such index")
+	ErrNoDictionary   = errors.New("too few similar documents to train a dictionary")
+	ErrRejected       = errors.New("write rejected by hook")
+	ErrLockTimeout    = errors.New("timed out waiting for the file lock")
+	ErrQuotaExceeded  = errors.New("quota exceeded")
+	ErrInvalidContent = errors.New("content rejected by validator")
+	ErrInvalidPatch   = errors.New("merge patch is not valid JSON")
+	ErrNotJSON        = errors.New("document content is not JSON")
 )
+
+// Error is the error the point operations and maintenance methods
+// return: the one that stopped them, with the operation, the document,
+// and, for a damaged line, where in the file it is, so a corrupt record
+// in a file of many gigabytes can be found from a log line. It unwraps
+// to the error it describes, so errors.Is matches the sentinels as
+// before, and errors.As retrieves the detail.
+type Error struct {
+	Op     string // the operation, as reported to Config.MetricsCollector
+	Label  string // the document, or "" if the operation has none
+	Offset int64  // byte offset of the line at fault, or -1 if none is
+	Err    error
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Op)
+	if e.Label != "" {
+		fmt.Fprintf(&b, " %q", e.Label)
+	}
+	if e.Offset >= 0 {
+		fmt.Fprintf(&b, " at offset %d", e.Offset)
+	}
+	msg := e.Err.Error()
+	if b.Len() == 0 {
+		return msg
+	}
+	// Most messages already start with the operation.
+	return b.String() + ": " + strings.TrimPrefix(msg, e.Op+": ")
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// fault marks an error as found at the line at off, which belongs to
+// label if that is known. It adds nothing to the message: observe lifts
+// the location into the Error it returns.
+type fault struct {
+	label string
+	off   int64
+	err   error
+}
+
+func (f *fault) Error() string { return f.err.Error() }
+func (f *fault) Unwrap() error { return f.err }
+
+// faultAt returns err marked as found at the line at off.
+func faultAt(label string, off int64, err error) error {
+	return &fault{label, off, err}
+}
+
+// describe returns err as the Error op returns for label. An err that
+// already holds one, from a public method op called, is returned as it
+// is.
+func describe(op, label string, err error) error {
+	if e := (*Error)(nil); errors.As(err, &e) {
+		return err
+	}
+	e := &Error{Op: op, Label: label, Offset: -1, Err: err}
+	if f := (*fault)(nil); errors.As(err, &f) {
+		e.Offset = f.off
+		if f.label != "" {
+			e.Label = f.label
+		}
+	}
+	return e
+}
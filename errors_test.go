@@ -10,6 +10,7 @@ package folio
 
 import (
 	"errors"
+	"strings"
 	"testing"
 )
 
@@ -25,11 +26,20 @@ func TestErrors(t *testing.T) {
 		ErrInvalidLabel,
 		ErrEmptyContent,
 		ErrClosed,
+		ErrReadOnly,
 		ErrInvalidPattern,
 		ErrCorruptHeader,
 		ErrCorruptRecord,
 		ErrCorruptIndex,
 		ErrDecompress,
+		ErrChecksum,
+		ErrDecrypt,
+		ErrInvalidTTL,
+		ErrInvalidCursor,
+		ErrInvalidTag,
+		ErrInvalidPath,
+		ErrNoIndex,
+		ErrRejected,
 	}
 
 	// Check none are nil
@@ -66,11 +76,19 @@ func TestErrorsAreErrors(t *testing.T) {
 		{"ErrInvalidLabel", ErrInvalidLabel},
 		{"ErrEmptyContent", ErrEmptyContent},
 		{"ErrClosed", ErrClosed},
+		{"ErrReadOnly", ErrReadOnly},
 		{"ErrInvalidPattern", ErrInvalidPattern},
 		{"ErrCorruptHeader", ErrCorruptHeader},
 		{"ErrCorruptRecord", ErrCorruptRecord},
 		{"ErrCorruptIndex", ErrCorruptIndex},
 		{"ErrDecompress", ErrDecompress},
+		{"ErrChecksum", ErrChecksum},
+		{"ErrDecrypt", ErrDecrypt},
+		{"ErrInvalidTTL", ErrInvalidTTL},
+		{"ErrInvalidCursor", ErrInvalidCursor},
+		{"ErrInvalidTag", ErrInvalidTag},
+		{"ErrInvalidPath", ErrInvalidPath},
+		{"ErrNoIndex", ErrNoIndex},
 	}
 
 	for _, tt := range tests {
@@ -81,3 +99,48 @@ func TestErrorsAreErrors(t *testing.T) {
 		})
 	}
 }
+
+// TestErrorContext verifies that a failed call returns an Error naming
+// the operation and document, that one caused by a damaged line gives
+// that line's offset, and that errors.Is still finds the sentinel.
+func TestErrorContext(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "content")
+	db.Compact()
+
+	check := func(err error, op, label string, off int64, target error) {
+		t.Helper()
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Fatalf("%v is not an *Error", err)
+		}
+		if e.Op != op || e.Label != label || e.Offset != off {
+			t.Errorf("Error = {%s %q %d}, want {%s %q %d}", e.Op, e.Label, e.Offset, op, label, off)
+		}
+		if !errors.Is(err, target) {
+			t.Errorf("%v does not wrap %v", err, target)
+		}
+	}
+
+	_, err := db.Get("missing")
+	check(err, OpGet, "missing", -1, ErrNotFound)
+	if want := `get "missing": document not found`; err.Error() != want {
+		t.Errorf("message = %q, want %q", err, want)
+	}
+
+	// A damaged record: the offset is the record's, not its index's.
+	db.writeAt(HeaderSize+34, []byte("!!!!"))
+	_, err = db.Get("doc")
+	check(err, OpGet, "doc", HeaderSize, ErrCorruptRecord)
+	if !strings.Contains(err.Error(), "at offset 128") {
+		t.Errorf("message %q does not give the offset", err)
+	}
+	err = db.Patch("doc", []byte(`{}`))
+	check(err, OpPatch, "doc", HeaderSize, ErrCorruptRecord)
+
+	// A damaged index line in the sorted section.
+	idx := db.indexStart()
+	db.writeAt(idx+34, []byte("!!!!"))
+	_, err = db.Get("doc")
+	check(err, OpGet, "doc", idx, ErrCorruptIndex)
+}
@@ -1,6 +1,7 @@
 package folio_test
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -58,7 +59,7 @@ func ExampleDB_Get() {
 	db.Set("greeting", "Hello, World!")
 
 	content, err := db.Get("greeting")
-	if err == folio.ErrNotFound {
+	if errors.Is(err, folio.ErrNotFound) {
 		fmt.Println("Document not found")
 		return
 	}
@@ -127,7 +128,7 @@ func ExampleDB_Delete() {
 	db.Delete("temp")
 
 	_, err := db.Get("temp")
-	fmt.Println(err == folio.ErrNotFound)
+	fmt.Println(errors.Is(err, folio.ErrNotFound))
 	// Output: true
 }
 
@@ -0,0 +1,288 @@
+// Export and Import: a portable dump of documents and their history.
+//
+// The dump is JSONL, independent of the on-disk layout. The first line
+// identifies the format; every following line is one document with all
+// of its versions, oldest first:
+//
+//	{"folio_export":1}
+//	{"l":"my-doc","c":1706000000000,"v":[{"ts":1706000000000,"d":"v1"},{"ts":1706000500000,"d":"v2"}]}
+//
+// A document that expires also carries the time, in unix ms, in "ex".
+// Documents appear in label order, so exporting the same content twice
+// produces the same bytes. Content that is not valid UTF-8 is carried in
+// "b" (base64) instead of "d". Labels are stored, not IDs, so importing
+// into a file opened with a different HashAlgorithm migrates it.
+//
+// Import streams the dump and writes each document as soon as it is
+// read: history records, then the current record and its index, in one
+// append. Every label and version is validated before the document is
+// written. If Import fails partway, the documents before the failing
+// line remain imported.
+package folio
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	json "github.com/goccy/go-json"
+)
+
+// dumpFormat is the format number written in the dump's first line.
+const dumpFormat = 1
+
+// ExportOptions controls what Export writes.
+type ExportOptions struct {
+	Prefix      string // only export labels starting with Prefix
+	CurrentOnly bool   // omit history, exporting only the current version
+
+	// Progress, if set, is called after each document is written, with
+	// done and total in documents (see progress.go).
+	Progress func(done, total int64)
+}
+
+// dumpHeader is the first line of a dump.
+type dumpHeader struct {
+	Version int `json:"folio_export"`
+}
+
+// dumpDoc is one document line of a dump.
+type dumpDoc struct {
+	Label    string        `json:"l"`
+	Created  int64         `json:"c,omitempty"`
+	Expires  int64         `json:"ex,omitempty"`
+	Versions []dumpVersion `json:"v"`
+}
+
+// dumpVersion is one version within a dumpDoc. Exactly one of Data
+// and Binary is set.
+type dumpVersion struct {
+	TS     int64  `json:"ts"`
+	Data   string `json:"d,omitempty"`
+	Binary string `json:"b,omitempty"`
+}
+
+// Export writes every current document, with its history unless
+// opts.CurrentOnly is set, to w. Deleted documents are not exported.
+// The read lock is held for the whole export so the dump is a
+// consistent snapshot.
+func (db *DB) Export(w io.Writer, opts ExportOptions) (err error) {
+	defer db.observe(OpExport, "", time.Now(), &err)
+
+	db.beginScan()
+	defer db.endScan()
+	if err := db.blockRead(); err != nil {
+		return err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+
+	sz, err := size(db.reader)
+	if err != nil {
+		return fmt.Errorf("export: stat: %w", err)
+	}
+
+	created, expires := map[string]int64{}, map[string]int64{}
+	var labels []string
+	for _, e := range scanm(db.reader, HeaderSize, sz, TypeIndex) {
+		if !strings.HasPrefix(e.Label, opts.Prefix) {
+			continue
+		}
+		if _, ok := created[e.Label]; ok {
+			continue
+		}
+		result, idx, err := db.findIndex(e.ID, e.Label, sz)
+		if err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+		if result == nil {
+			continue
+		}
+		created[e.Label] = idx.Created
+		if idx.Expires != 0 {
+			expires[e.Label] = idx.Expires
+		}
+		labels = append(labels, e.Label)
+	}
+	slices.Sort(labels)
+	return db.dump(w, labels, created, expires, db.versions, opts)
+}
+
+// dump writes the dump of labels, in the order given, fetching each
+// label's versions with history. expires holds the expiry of each label
+// that has one.
+func (db *DB) dump(w io.Writer, labels []string, created, expires map[string]int64, history func(string) ([]Version, error), opts ExportOptions) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(dumpHeader{Version: dumpFormat}); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	progress := newProgress(opts.Progress, int64(len(labels)), 1)
+	for _, lbl := range labels {
+		versions, err := history(lbl)
+		if err != nil {
+			return fmt.Errorf("export: %s: %w", lbl, err)
+		}
+		if opts.CurrentOnly && len(versions) > 1 {
+			versions = versions[len(versions)-1:]
+		}
+
+		doc := dumpDoc{Label: lbl, Created: created[lbl], Expires: expires[lbl]}
+		for _, v := range versions {
+			ev := dumpVersion{TS: v.TS}
+			if utf8.ValidString(v.Data) {
+				ev.Data = v.Data
+			} else {
+				ev.Binary = base64.StdEncoding.EncodeToString([]byte(v.Data))
+			}
+			doc.Versions = append(doc.Versions, ev)
+			db.usage.bytesRead.Add(uint64(len(v.Data)))
+		}
+		if err := enc.Encode(doc); err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+		progress.add(1)
+	}
+	progress.finish()
+	return nil
+}
+
+// Import reads a dump written by Export and recreates each document with
+// its history and original timestamps. Returns ErrExists, leaving the
+// document unwritten, if a label already exists in db; one that has
+// expired is replaced, as Set replaces it.
+func (db *DB) Import(r io.Reader) (err error) {
+	defer db.observe(OpImport, "", time.Now(), &err)
+
+	if err := db.blockWrite(); err != nil {
+		return err
+	}
+
+	err = db.importDump(r)
+
+	compact := err == nil && db.shouldCompact()
+	events := db.takeEvents()
+	db.mu.Unlock()
+	db.lock.Unlock()
+
+	if err == nil {
+		err = db.durable()
+	}
+	db.after(events)
+	if compact {
+		db.Compact()
+	}
+	return err
+}
+
+// importDump performs the import. The write lock must be held.
+func (db *DB) importDump(r io.Reader) error {
+	dec := json.NewDecoder(r)
+
+	var hdr dumpHeader
+	if err := dec.Decode(&hdr); err != nil {
+		return fmt.Errorf("import: header: %w", err)
+	}
+	if hdr.Version != dumpFormat {
+		return fmt.Errorf("import: unsupported export version %d", hdr.Version)
+	}
+
+	for n := 2; ; n++ {
+		var doc dumpDoc
+		if err := dec.Decode(&doc); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("import: line %d: %w", n, err)
+		}
+		if err := db.importDoc(doc); err != nil {
+			return fmt.Errorf("import: line %d: %w", n, err)
+		}
+	}
+}
+
+// importDoc validates and writes one document from a dump.
+func (db *DB) importDoc(doc dumpDoc) error {
+	if len(doc.Versions) == 0 {
+		return fmt.Errorf("%s: no versions", doc.Label)
+	}
+	versions := make([]Version, len(doc.Versions))
+	for i, ev := range doc.Versions {
+		data := ev.Data
+		if ev.Binary != "" {
+			raw, err := base64.StdEncoding.DecodeString(ev.Binary)
+			if err != nil {
+				return fmt.Errorf("%s: %w", doc.Label, err)
+			}
+			data = string(raw)
+		}
+		// Only the current version must satisfy Config.Validator.
+		check := validateDoc
+		if i == len(doc.Versions)-1 {
+			check = db.checkDoc
+		}
+		if err := check(doc.Label, data); err != nil {
+			return fmt.Errorf("%s: %w", doc.Label, err)
+		}
+		// Timestamps sit at fixed byte positions, so they must have
+		// exactly 13 digits like every timestamp Set writes.
+		if ev.TS < 1e12 || ev.TS >= 1e13 {
+			return fmt.Errorf("%s: invalid timestamp %d", doc.Label, ev.TS)
+		}
+		versions[i] = Version{Data: data, TS: ev.TS}
+	}
+
+	sz, err := size(db.reader)
+	if err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+	id := db.id(doc.Label)
+	existing, idx, err := db.findIndex(id, doc.Label, sz)
+	if err != nil {
+		return err
+	}
+	if existing != nil && !idx.expired(now()) {
+		return fmt.Errorf("%s: %w", doc.Label, ErrExists)
+	}
+
+	content := versions[len(versions)-1].Data
+	if err := db.beforeSet(doc.Label, content); err != nil {
+		return fmt.Errorf("%s: %w", doc.Label, err)
+	}
+	ct := doc.Created
+	if ct == 0 {
+		ct = versions[0].TS
+	}
+	buf, err := db.encodeDoc(nil, db.tail, id, doc.Label, versions, ct, doc.Expires)
+	if err != nil {
+		return err
+	}
+	// raw() appends the final newline.
+	if _, err := db.raw(buf[:len(buf)-1]); err != nil {
+		return err
+	}
+
+	if db.bloom != nil {
+		db.bloom.Add(id)
+	}
+	if db.labels != nil {
+		db.labels.put(doc.Label, doc.Expires)
+	}
+	if existing == nil {
+		db.count.Add(1)
+	} else if err := blank(db, idx.Offset, existing); err != nil {
+		return err
+	}
+	db.usage.writes.Add(1)
+	if err := db.reindex(doc.Label); err != nil {
+		return err
+	}
+	db.wroteSet(doc.Label, content)
+	return nil
+}
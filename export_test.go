@@ -0,0 +1,147 @@
+// Export and Import tests.
+//
+// A dump is only useful if restoring it gives back what was exported:
+// every live document, every version in order, the original timestamps,
+// and binary content byte for byte. These tests round-trip through a
+// fresh file, including one opened with a different hash algorithm.
+package folio
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestExportImportRoundTrip verifies history, timestamps, creation time,
+// and binary content survive a dump into a file with another hash
+// algorithm, and that deleted documents are left out.
+func TestExportImportRoundTrip(t *testing.T) {
+	src := openTestDB(t)
+	src.Set("doc", "v1")
+	time.Sleep(2 * time.Millisecond)
+	src.Set("doc", "v2")
+	src.SetBytes("bin", []byte{0xff, 0x00, 0xfe})
+	src.Set("gone", "x")
+	src.Delete("gone")
+
+	var dump bytes.Buffer
+	if err := src.Export(&dump, ExportOptions{}); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	dst, err := Open(filepath.Join(t.TempDir(), "dst.folio"), Config{HashAlgorithm: AlgBlake2b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if err := dst.Import(&dump); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	if dst.Count() != 2 {
+		t.Errorf("Count = %d, want 2", dst.Count())
+	}
+	if ok, _ := dst.Exists("gone"); ok {
+		t.Error("deleted document was exported")
+	}
+	want, _ := Collect(src.History("doc"))
+	got, _ := Collect(dst.History("doc"))
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("History = %v, want %v", got, want)
+	}
+	srcInfo, _ := src.Info("doc")
+	dstInfo, _ := dst.Info("doc")
+	if srcInfo != dstInfo {
+		t.Errorf("Info = %+v, want %+v", dstInfo, srcInfo)
+	}
+	if b, _ := dst.GetBytes("bin"); !bytes.Equal(b, []byte{0xff, 0x00, 0xfe}) {
+		t.Errorf("binary content = %v", b)
+	}
+}
+
+// TestExportStable verifies two exports of the same content are byte
+// identical, and that Prefix and CurrentOnly narrow the dump.
+func TestExportStable(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("b/2", "two")
+	db.Set("a/1", "one")
+	db.Set("a/1", "uno")
+
+	var first, second bytes.Buffer
+	db.Export(&first, ExportOptions{})
+	db.Compact()
+	db.Export(&second, ExportOptions{})
+	if first.String() != second.String() {
+		t.Errorf("exports differ:\n%s\n%s", first.String(), second.String())
+	}
+
+	var narrow bytes.Buffer
+	db.Export(&narrow, ExportOptions{Prefix: "a/", CurrentOnly: true})
+	lines := strings.Split(strings.TrimSpace(narrow.String()), "\n")
+	if len(lines) != 2 || strings.Contains(lines[1], `"one"`) || !strings.Contains(lines[1], `"uno"`) {
+		t.Errorf("narrow export = %q", lines)
+	}
+}
+
+// TestImportRejects verifies Import refuses existing labels, invalid
+// labels, and malformed timestamps rather than writing records that
+// would shadow or corrupt the file.
+func TestImportRejects(t *testing.T) {
+	cases := map[string]struct {
+		line string
+		want error
+	}{
+		"exists":    {`{"l":"doc","v":[{"ts":1706000000000,"d":"x"}]}`, ErrExists},
+		"label":     {`{"l":"bad\"label","v":[{"ts":1706000000000,"d":"x"}]}`, ErrInvalidLabel},
+		"empty":     {`{"l":"new","v":[{"ts":1706000000000,"d":""}]}`, ErrEmptyContent},
+		"timestamp": {`{"l":"new","v":[{"ts":17,"d":"x"}]}`, nil},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t)
+			db.Set("doc", "original")
+			err := db.Import(strings.NewReader("{\"folio_export\":1}\n" + tc.line + "\n"))
+			if err == nil || (tc.want != nil && !errors.Is(err, tc.want)) {
+				t.Errorf("Import = %v, want %v", err, tc.want)
+			}
+			if db.Count() != 1 {
+				t.Errorf("Count = %d, want 1", db.Count())
+			}
+		})
+	}
+}
+
+// TestExportImportExpiry verifies that a document's expiry survives a
+// dump, and that Import replaces a document that has expired, as Set
+// does, rather than refusing it as existing.
+func TestExportImportExpiry(t *testing.T) {
+	src := openTestDB(t)
+	src.SetWithTTL("doc", "expiring", time.Hour)
+	want, _ := src.Info("doc")
+
+	var dump bytes.Buffer
+	if err := src.Export(&dump, ExportOptions{}); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	dst := openTestDB(t)
+	dst.SetWithTTL("doc", "old", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if err := dst.Import(&dump); err != nil {
+		t.Fatalf("Import over an expired document: %v", err)
+	}
+	if got, err := dst.Info("doc"); err != nil || got.Expires != want.Expires {
+		t.Errorf("Info after Import = %+v, %v; want expiry %d", got, err, want.Expires)
+	}
+	if got, _ := dst.Get("doc"); got != "expiring" {
+		t.Errorf("Get = %q, want %q", got, "expiring")
+	}
+	if dst.Count() != 1 {
+		t.Errorf("Count = %d, want 1", dst.Count())
+	}
+	if rep, err := dst.Verify(VerifyOptions{}); err != nil || !rep.OK() {
+		t.Errorf("Verify = %+v, %v", rep.Problems, err)
+	}
+}
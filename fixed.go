@@ -0,0 +1,151 @@
+// Fixed-width index section.
+//
+// Index records vary in length with their labels, so a binary search
+// over them lands at an arbitrary byte, must read on to the next
+// newline to find a line to compare against, and fall back to walking
+// backwards when that line lies past the range. Since format version 5
+// every rebuild pads the index lines it writes with spaces to the length
+// of the longest, which JSON ignores. With every line one width, the
+// k-th entry lies at a computed offset, so each step of the search is a
+// single read of one line, and neither align nor scanBack is needed.
+// The padding costs at most the difference between the longest label
+// and the others, on each line of a section that holds no content.
+//
+// The width is read from the first line when the file is opened and
+// after every rebuild. A file of an earlier version keeps its variable
+// lines, and is searched as before, until its next rebuild. Writes into
+// the section patch lines in place without changing their lengths, and a
+// quarantined line is blanked to its length, so the width holds until the
+// next rebuild. A blank entry is skipped by reading its neighbours.
+package folio
+
+import (
+	"bytes"
+	"sort"
+)
+
+// fixedIndexVersion is the format version from which the index section
+// of a rebuilt file is of one width.
+const fixedIndexVersion = 5
+
+// indexWidth returns the length of every line of the index section, not
+// counting its newline, or 0 if the lines vary or the section is empty.
+func (db *DB) indexWidth() int64 {
+	start, end := db.indexStart(), db.indexEnd()
+	if db.header.Version < fixedIndexVersion || start == 0 || start >= end {
+		return 0
+	}
+	data, err := line(db.reader, start)
+	if err != nil || len(data) == 0 {
+		return 0
+	}
+	w := int64(len(data))
+	if (end-start)%(w+1) != 0 {
+		return 0
+	}
+	return w
+}
+
+// padIndexes pads each of lines with spaces to the length of the longest.
+func padIndexes(lines [][]byte) {
+	w := 0
+	for _, ln := range lines {
+		w = max(w, len(ln))
+	}
+	for i, ln := range lines {
+		lines[i] = append(ln, bytes.Repeat([]byte{' '}, w-len(ln))...)
+	}
+}
+
+// entry returns the index line at off, or nil if it is blank or not an
+// index. off must be on a line of a fixed-width section.
+func (db *DB) entry(off int64) *Result {
+	buf := make([]byte, db.width)
+	if n, _ := db.reader.ReadAt(buf, off); n < len(buf) {
+		return nil
+	}
+	if !valid(buf) || len(buf) < MinRecordSize || buf[TypePos] != '0'+TypeIndex {
+		return nil
+	}
+	return &Result{off, len(buf), buf, string(buf[IDStart:IDEnd])}
+}
+
+// entryFrom returns the first index line at or after the k-th of the n
+// in the fixed-width section from start, or nil if there is none.
+func (db *DB) entryFrom(start, k, n int64) *Result {
+	for ; k < n; k++ {
+		if r := db.entry(start + k*(db.width+1)); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// indexPivot finds the index line a search between start and end, both
+// line boundaries of the index section, compares against, and the offset
+// just past it, as pivot does for a section of any layout. With a fixed
+// width it is the middle line, read at its offset, or if that one is
+// blank the nearest line after it, or else before it.
+func (db *DB) indexPivot(start, end int64) (*Result, int64) {
+	if db.width == 0 {
+		return pivot(db.reader, start, end, TypeIndex)
+	}
+	step := db.width + 1
+	n := (end - start) / step
+	if r := db.entryFrom(start, n/2, n); r != nil {
+		return r, r.Offset + step
+	}
+	for k := n/2 - 1; k >= 0; k-- {
+		if r := db.entry(start + k*step); r != nil {
+			return r, r.Offset + step
+		}
+	}
+	return nil, 0
+}
+
+// scanIndex finds the line of id in the index section, through the hash
+// table if there is one and it can answer, else by binary search, or
+// returns nil if the section has none.
+func (db *DB) scanIndex(id string) *Result {
+	if db.table != nil {
+		if r := db.lookup(id); r != nil {
+			return r
+		}
+	}
+	start, end := db.indexStart(), db.indexEnd()
+	if db.width == 0 {
+		return scan(db.reader, id, start, end, TypeIndex)
+	}
+	for start < end {
+		p, next := db.indexPivot(start, end)
+		switch {
+		case p == nil:
+			return nil
+		case id == p.ID:
+			return p
+		case id < p.ID:
+			end = p.Offset
+		default:
+			start = next
+		}
+	}
+	return nil
+}
+
+// seekIndex returns the offset of the first index line whose ID is not
+// less than id, or the end of the section if there is none, as seek does.
+func (db *DB) seekIndex(id string) int64 {
+	start, end := db.indexStart(), db.indexEnd()
+	if db.width == 0 {
+		return seek(db.reader, id, start, end)
+	}
+	n := (end - start) / (db.width + 1)
+	k := sort.Search(int(n), func(k int) bool {
+		r := db.entryFrom(start, int64(k), n)
+		return r == nil || r.ID >= id
+	})
+	if r := db.entryFrom(start, int64(k), n); r != nil {
+		return r.Offset
+	}
+	return end
+}
@@ -0,0 +1,149 @@
+package folio
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fixedDB opens a database at path holding n documents with labels of
+// varied lengths, compacted, and returns it with the labels.
+func fixedDB(t *testing.T, path string, n int) (*DB, []string) {
+	t.Helper()
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var labels []string
+	for i := range n {
+		lbl := fmt.Sprintf("doc/%d/%s", i, strings.Repeat("x", i%40))
+		if err := db.Set(lbl, fmt.Sprintf("content %d", i)); err != nil {
+			t.Fatal(err)
+		}
+		labels = append(labels, lbl)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	return db, labels
+}
+
+// TestFixedIndex verifies that a rebuild writes every index line at one
+// width, that lookups find each document with a read per step of the
+// search, and that listing from a cursor still works.
+func TestFixedIndex(t *testing.T) {
+	db, labels := fixedDB(t, filepath.Join(t.TempDir(), "test.folio"), 300)
+	defer db.Close()
+
+	if db.header.Version != FormatVersion || db.width == 0 {
+		t.Fatalf("version %d, width %d: want a fixed width", db.header.Version, db.width)
+	}
+	section := make([]byte, db.indexEnd()-db.indexStart())
+	db.reader.ReadAt(section, db.indexStart())
+	for i, ln := range bytes.Split(bytes.TrimSuffix(section, []byte{'\n'}), []byte{'\n'}) {
+		if int64(len(ln)) != db.width {
+			t.Fatalf("index line %d is %d bytes, want %d", i, len(ln), db.width)
+		}
+	}
+
+	f := &countingFile{File: db.reader.(*os.File)}
+	db.reader = f
+	for _, lbl := range labels {
+		f.reads = 0
+		r, idx, err := db.sorted(db.id(lbl))
+		if err != nil || r == nil || idx.Label != lbl {
+			t.Fatalf("sorted(%s) = %v, %v", lbl, idx, err)
+		}
+		if limit := bits.Len(uint(len(labels))); f.reads > limit {
+			t.Errorf("lookup of %s made %d reads, want at most %d", lbl, f.reads, limit)
+		}
+	}
+	db.reader = f.File
+
+	for _, lbl := range labels {
+		if got, err := db.Get(lbl); err != nil || !strings.HasPrefix(got, "content ") {
+			t.Errorf("Get(%s) = %q, %v", lbl, got, err)
+		}
+	}
+	var listed []string
+	for cursor := ""; ; {
+		page, next, err := db.ListPage(cursor, 70)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listed = append(listed, page...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(listed) != len(labels) {
+		t.Errorf("ListPage listed %d labels, want %d", len(listed), len(labels))
+	}
+	if rep, err := db.Verify(VerifyOptions{}); err != nil || !rep.OK() {
+		t.Errorf("Verify = %+v, %v", rep.Problems, err)
+	}
+}
+
+// TestFixedIndexBlank verifies that a blank index line is stepped over:
+// every other document is still found.
+func TestFixedIndexBlank(t *testing.T) {
+	db, labels := fixedDB(t, filepath.Join(t.TempDir(), "test.folio"), 50)
+	defer db.Close()
+
+	r, _, err := db.sorted(db.id(labels[0]))
+	if err != nil || r == nil {
+		t.Fatal("no index line for the first label")
+	}
+	step := db.width + 1
+	mid := db.indexStart() + (db.indexEnd()-db.indexStart())/step/2*step
+	if mid == r.Offset {
+		mid += step
+	}
+	for _, off := range []int64{mid, r.Offset} {
+		db.writer.WriteAt(bytes.Repeat([]byte{' '}, int(db.width)), off)
+	}
+	found := 0
+	for _, lbl := range labels {
+		if r, _, err := db.sorted(db.id(lbl)); err == nil && r != nil {
+			found++
+		}
+	}
+	if found != len(labels)-2 {
+		t.Errorf("found %d of %d documents with two index lines blank", found, len(labels))
+	}
+}
+
+// TestFixedIndexOlderVersion verifies that an index section from before
+// version 5 is searched by byte range, and that Migrate rewrites it at
+// one width.
+func TestFixedIndexOlderVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, labels := fixedDB(t, path, 50)
+	db.Close()
+	writeVersion(t, path, tombstoneVersion)
+
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.width != 0 {
+		t.Errorf("width of a version %d file = %d, want 0", tombstoneVersion, db.width)
+	}
+	for _, lbl := range labels {
+		if _, err := db.Get(lbl); err != nil {
+			t.Errorf("Get(%s): %v", lbl, err)
+		}
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if db.width == 0 {
+		t.Error("Migrate left the index section without a fixed width")
+	}
+}
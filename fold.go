@@ -0,0 +1,67 @@
+// Case-insensitive labels.
+//
+// With Config.CaseInsensitiveLabels set when a file is created, labels
+// that differ only in case name the same document: Get("Config") finds
+// what Set("config") wrote. The _id of a record is the hash of its label
+// case-folded, so both spellings reach the same index lines, and labels
+// found there are compared folded too. _l keeps the label as it was
+// first written, and a later write under another spelling updates that
+// document without changing it; only Rename changes the case of a label.
+// Listings, prefixes, and patterns see the stored labels.
+//
+// Folding is Unicode simple case folding, as strings.EqualFold applies:
+// each character is replaced by the smallest member of its case orbit,
+// so "K", "k", and the Kelvin sign fold alike. Multi-character foldings
+// such as "ß" to "ss" are not applied.
+//
+// The mode changes every _id, so it belongs to the file rather than to a
+// handle. It is recorded as a header flag when the file is created, and
+// an existing file keeps its mode whatever Config says, as it keeps its
+// hash algorithm.
+package folio
+
+import (
+	"strings"
+	"unicode"
+)
+
+// foldCase returns s with each rune replaced by the smallest rune that
+// is equal to it under simple case folding.
+func foldCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		low := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			low = min(low, f)
+		}
+		return low
+	}, s)
+}
+
+// fold returns the form of label that identifies its document: label
+// itself, or its case folding in a case-insensitive file.
+func (db *DB) fold(label string) string {
+	if db.header.Flags&flagFoldLabels == 0 {
+		return label
+	}
+	return foldCase(label)
+}
+
+// id returns the _id of the document at label.
+func (db *DB) id(label string) string {
+	return hash(db.fold(label), db.header.Algorithm)
+}
+
+// stored returns the label a write at ts should record: that of the
+// current document idx, if there is one, so that a write under another
+// spelling keeps the document's label.
+func stored(label string, idx *Index, ts int64) string {
+	if idx != nil && !idx.expired(ts) {
+		return idx.Label
+	}
+	return label
+}
+
+// same reports whether labels a and b name the same document.
+func (db *DB) same(a, b string) bool {
+	return a == b || db.header.Flags&flagFoldLabels != 0 && strings.EqualFold(a, b)
+}
@@ -0,0 +1,149 @@
+// Case-insensitive label tests.
+//
+// A case-insensitive file must behave as if every label were written in
+// one spelling: reads, writes, deletes, renames, tags, and transactions
+// under any spelling reach the same document, while listings keep the
+// label as it was first written. The mode is a property of the file, so
+// it must survive compaction and reopening without the option.
+package folio
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestFoldCase verifies that foldCase agrees with strings.EqualFold,
+// including characters whose case orbit has more than two members.
+func TestFoldCase(t *testing.T) {
+	for _, pair := range [][2]string{
+		{"Config", "config"},
+		{"K", "K"}, // Kelvin sign
+		{"s", "ſ"}, // long s
+		{"ΣΑΣ", "σας"},
+	} {
+		if a, b := foldCase(pair[0]), foldCase(pair[1]); a != b {
+			t.Errorf("foldCase(%q) = %q, foldCase(%q) = %q, want equal", pair[0], a, pair[1], b)
+		}
+	}
+	if foldCase("a") == foldCase("b") {
+		t.Error("foldCase(a) == foldCase(b)")
+	}
+}
+
+// TestCaseInsensitiveLabels verifies each operation under a spelling
+// other than the stored one, before and after compaction.
+func TestCaseInsensitiveLabels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{CaseInsensitiveLabels: true, SparseMap: true, LabelTrie: true})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { db.Close() }()
+
+	db.Set("Config", "v1")
+	if err := db.Set("CONFIG", "v2"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := db.Create("config", "v3"); !errors.Is(err, ErrExists) {
+		t.Errorf("Create(config) = %v, want ErrExists", err)
+	}
+	check := func(when string) {
+		t.Helper()
+		if got, err := db.Get("config"); err != nil || got != "v2" {
+			t.Errorf("%s: Get(config) = %q, %v, want v2", when, got, err)
+		}
+		if labels, _ := Collect(db.List()); !slices.Equal(labels, []string{"Config"}) {
+			t.Errorf("%s: List = %v, want [Config]", when, labels)
+		}
+		if versions, _ := Collect(db.History("cOnFiG")); len(versions) != 2 {
+			t.Errorf("%s: History = %d versions, want 2", when, len(versions))
+		}
+		if n, _ := db.CountPrefix("Conf"); n != 1 {
+			t.Errorf("%s: CountPrefix(Conf) = %d, want 1", when, n)
+		}
+	}
+	check("appended")
+	db.Compact()
+	check("compacted")
+
+	got, err := db.GetMany("config", "CONFIG", "missing")
+	if err != nil || len(got) != 2 || got["config"] != "v2" || got["CONFIG"] != "v2" {
+		t.Errorf("GetMany = %v, %v, want both spellings", got, err)
+	}
+	snap, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if got, err := snap.Get("CONFIG"); err != nil || got != "v2" {
+		t.Errorf("Snapshot.Get = %q, %v, want v2", got, err)
+	}
+	snap.Close()
+
+	db.Tag("config", "t")
+	if labels := byTag(t, db, "t"); !slices.Equal(labels, []string{"Config"}) {
+		t.Errorf("ByTag = %v, want [Config]", labels)
+	}
+	if err := db.Rename("config", "CONFIG"); err != nil {
+		t.Fatalf("Rename to another case: %v", err)
+	}
+	if labels, _ := Collect(db.List()); !slices.Equal(labels, []string{"CONFIG"}) {
+		t.Errorf("List after Rename = %v, want [CONFIG]", labels)
+	}
+	if labels := byTag(t, db, "t"); !slices.Equal(labels, []string{"CONFIG"}) {
+		t.Errorf("ByTag after Rename = %v, want [CONFIG]", labels)
+	}
+
+	err = db.Txn(func(tx *Txn) error {
+		if got, err := tx.Get("Config"); err != nil || got != "v2" {
+			t.Errorf("tx.Get = %q, %v, want v2", got, err)
+		}
+		tx.Set("Other", "x")
+		return tx.Set("OTHER", "y")
+	})
+	if err != nil {
+		t.Fatalf("Txn: %v", err)
+	}
+	if got, _ := db.Get("other"); got != "y" {
+		t.Errorf("Get(other) after Txn = %q, want y", got)
+	}
+	if err := db.Delete("config"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if ok, _ := db.Exists("CONFIG"); ok {
+		t.Error("Exists after Delete = true")
+	}
+	mustVerify(t, db, VerifyOptions{})
+
+	// The mode belongs to the file.
+	db.Close()
+	if db, err = Open(path, Config{}); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if got, err := db.Get("OTHER"); err != nil || got != "y" {
+		t.Errorf("Get after reopen = %q, %v, want y", got, err)
+	}
+}
+
+// TestCaseSensitiveByDefault verifies that without the option labels
+// differing in case are different documents, and that the option does
+// not change an existing file.
+func TestCaseSensitiveByDefault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	db.Set("Config", "a")
+	db.Close()
+
+	db, err = Open(path, Config{CaseInsensitiveLabels: true})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Get("config"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(config) = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,220 @@
+// Read-only io/fs view of a database.
+//
+// FS presents labels as slash-separated paths: "site/css/main.css" is
+// the file main.css in the directory site/css. Directories are not
+// stored; one exists while any label lies under it. Labels that are not
+// valid fs paths (a leading or doubled slash, a "." or ".." element) are
+// left out, since no fs path could name them. A label that is also the
+// directory of others, such as "a" beside "a/b", is a file: Open("a")
+// reads the document and ReadDir lists it once, as a file, but
+// Open("a/b") still finds the other.
+//
+// Every call reads the database as it is at that moment; an open file
+// holds the content it was opened with.
+package folio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FS returns a read-only file system over db's documents, usable with
+// http.FileServerFS, template.ParseFS, and fs.WalkDir. It implements
+// fs.ReadDirFS, fs.ReadFileFS, and fs.StatFS.
+func FS(db *DB) fs.FS {
+	return docFS{db}
+}
+
+type docFS struct{ db *DB }
+
+// Open opens the document or directory at name.
+func (f docFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		content, err := f.db.GetBytes(name)
+		if err == nil {
+			info, err := f.db.Stat(name)
+			if err != nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+			}
+			return &docFile{fileInfo{info}, bytes.NewReader(content)}, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+	entries, err := f.readDir("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &dirFile{name: name, entries: entries}, nil
+}
+
+// ReadDir lists the directory at name, sorted by name.
+func (f docFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return f.readDir("readdir", name)
+}
+
+// ReadFile returns the content of the document at name.
+func (f docFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+	content, err := f.db.GetBytes(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fsErr(err)}
+	}
+	return content, nil
+}
+
+// Stat describes the document or directory at name.
+func (f docFS) Stat(name string) (fs.FileInfo, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return file.Stat()
+}
+
+// readDir lists the directory at name for op, or fails with
+// fs.ErrNotExist if no label lies under it.
+func (f docFS) readDir(op, name string) ([]fs.DirEntry, error) {
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+	files := map[string]bool{} // entry name: whether it is a document
+	for lbl, err := range f.db.ListPrefix(prefix) {
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		if !fs.ValidPath(lbl) {
+			continue
+		}
+		elem, rest, _ := strings.Cut(lbl[len(prefix):], "/")
+		files[elem] = files[elem] || rest == ""
+	}
+	if len(files) == 0 && name != "." {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(files))
+	for _, elem := range slices.Sorted(maps.Keys(files)) {
+		entries = append(entries, dirEntry{f.db, prefix + elem, files[elem]})
+	}
+	return entries, nil
+}
+
+// fsErr maps ErrNotFound to fs.ErrNotExist, which callers of an fs.FS
+// test for.
+func fsErr(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return fs.ErrNotExist
+	}
+	return err
+}
+
+// fileInfo describes a document.
+type fileInfo struct{ info StatInfo }
+
+func (i fileInfo) Name() string       { return pathBase(i.info.Label) }
+func (i fileInfo) Size() int64        { return i.info.Size }
+func (i fileInfo) Mode() fs.FileMode  { return 0o444 }
+func (i fileInfo) ModTime() time.Time { return time.UnixMilli(i.info.Modified) }
+func (i fileInfo) IsDir() bool        { return false }
+func (i fileInfo) Sys() any           { return i.info }
+
+// dirInfo describes a directory.
+type dirInfo struct{ name string }
+
+func (i dirInfo) Name() string       { return pathBase(i.name) }
+func (i dirInfo) Size() int64        { return 0 }
+func (i dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (i dirInfo) ModTime() time.Time { return time.Time{} }
+func (i dirInfo) IsDir() bool        { return true }
+func (i dirInfo) Sys() any           { return nil }
+
+// dirEntry is an entry of a directory listing. A document's size and
+// time are read when Info is called.
+type dirEntry struct {
+	db   *DB
+	path string
+	file bool
+}
+
+func (e dirEntry) Name() string { return pathBase(e.path) }
+func (e dirEntry) IsDir() bool  { return !e.file }
+
+func (e dirEntry) Type() fs.FileMode {
+	if e.file {
+		return 0
+	}
+	return fs.ModeDir
+}
+
+func (e dirEntry) Info() (fs.FileInfo, error) {
+	if !e.file {
+		return dirInfo{e.path}, nil
+	}
+	info, err := e.db.Stat(e.path)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: e.path, Err: fsErr(err)}
+	}
+	return fileInfo{info}, nil
+}
+
+// docFile is an open document. It seeks and reads at offsets, as
+// http.FileServerFS needs for range requests.
+type docFile struct {
+	info fileInfo
+	*bytes.Reader
+}
+
+func (f *docFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *docFile) Close() error               { return nil }
+
+// dirFile is an open directory.
+type dirFile struct {
+	name    string
+	entries []fs.DirEntry
+	at      int
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) { return dirInfo{d.name}, nil }
+func (d *dirFile) Close() error               { return nil }
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir returns the next n entries, or all that remain if n <= 0.
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.at:]
+	if n <= 0 {
+		d.at = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	rest = rest[:min(n, len(rest))]
+	d.at += len(rest)
+	return rest, nil
+}
+
+// pathBase is path.Base for the valid fs paths FS deals in.
+func pathBase(name string) string {
+	return name[strings.LastIndexByte(name, '/')+1:]
+}
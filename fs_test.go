@@ -0,0 +1,80 @@
+package folio
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	db, err := Open(":memory:", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Set("index.html", "<h1>home</h1>")
+	db.Set("css/main.css", "body{}")
+	db.Set("docs/a/one.md", "one")
+	db.Set("docs/two.md", "two")
+	db.Set("/rooted", "not a valid path")
+
+	fsys := FS(db)
+	if err := fstest.TestFS(fsys, "index.html", "css/main.css", "docs/a/one.md", "docs/two.md"); err != nil {
+		t.Fatal(err)
+	}
+
+	var walked []string
+	fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !d.IsDir() {
+			walked = append(walked, path)
+		}
+		return nil
+	})
+	if want := []string{"css/main.css", "docs/a/one.md", "docs/two.md", "index.html"}; !slices.Equal(walked, want) {
+		t.Errorf("WalkDir files = %v, want %v", walked, want)
+	}
+	if _, err := fs.Stat(fsys, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat missing = %v, want fs.ErrNotExist", err)
+	}
+
+	srv := httptest.NewServer(http.FileServerFS(fsys))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/css/main.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "body{}" {
+		t.Errorf("FileServer = %d %q", resp.StatusCode, body)
+	}
+}
+
+// TestFSFileAndDir checks that a label that is also a directory of
+// others reads as the document.
+func TestFSFileAndDir(t *testing.T) {
+	db, err := Open(":memory:", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Set("a", "file")
+	db.Set("a/b", "nested")
+
+	fsys := FS(db)
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil || len(entries) != 1 || entries[0].IsDir() {
+		t.Fatalf("ReadDir = %v, %v", entries, err)
+	}
+	if data, err := fs.ReadFile(fsys, "a/b"); err != nil || string(data) != "nested" {
+		t.Errorf("ReadFile a/b = %q, %v", data, err)
+	}
+}
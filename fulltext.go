@@ -0,0 +1,203 @@
+// Optional in-memory full-text index.
+//
+// Search matches a pattern against every document in the file, so its
+// cost grows with the file rather than with the answer. When enabled
+// (Config.FullTextIndex), an inverted index from each word to the labels
+// of the documents containing it is built at Open by reading every
+// document once, kept current by every write through this handle, and
+// rebuilt after compaction, which also picks up writes made by other
+// processes in the meantime. SearchText then answers from it.
+//
+// A word is a run of letters and digits, compared case-insensitively;
+// everything else separates words. A query is a list of words, all of
+// which a document must contain, and OR separates alternatives, binding
+// more loosely: "red apple OR pear" finds documents containing both red
+// and apple, or pear. Binary documents are not indexed, nor are those
+// that cannot be decrypted. Without the option SearchText reads every
+// document and applies the same rules.
+package folio
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"maps"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// textIndex maps words to the labels of the documents containing them.
+type textIndex struct {
+	words  map[string]map[string]bool // word → labels
+	labels map[string][]string        // label → its distinct words
+}
+
+func newTextIndex() *textIndex {
+	return &textIndex{words: map[string]map[string]bool{}, labels: map[string][]string{}}
+}
+
+// words returns the distinct lowercased words of s.
+func words(s string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, w := range strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		w = strings.ToLower(w)
+		if !seen[w] {
+			seen[w] = true
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// set indexes content as label's, replacing what it had.
+func (x *textIndex) set(label, content string) {
+	x.remove(label)
+	ws := words(content)
+	for _, w := range ws {
+		if x.words[w] == nil {
+			x.words[w] = map[string]bool{}
+		}
+		x.words[w][label] = true
+	}
+	x.labels[label] = ws
+}
+
+// remove forgets label.
+func (x *textIndex) remove(label string) {
+	for _, w := range x.labels[label] {
+		delete(x.words[w], label)
+		if len(x.words[w]) == 0 {
+			delete(x.words, w)
+		}
+	}
+	delete(x.labels, label)
+}
+
+// match returns the labels satisfying q, unsorted.
+func (x *textIndex) match(q [][]string) map[string]bool {
+	out := map[string]bool{}
+	for _, all := range q {
+		// Start from the rarest word so the intersection stays small.
+		rarest := slices.MinFunc(all, func(a, b string) int { return len(x.words[a]) - len(x.words[b]) })
+		for lbl := range x.words[rarest] {
+			if !out[lbl] && slices.IndexFunc(all, func(w string) bool { return !x.words[w][lbl] }) < 0 {
+				out[lbl] = true
+			}
+		}
+	}
+	return out
+}
+
+// parseText splits a query into alternatives, each a list of words that
+// must all be present. Alternatives with no words are dropped.
+func parseText(query string) [][]string {
+	var q [][]string
+	var all []string
+	flush := func() {
+		if len(all) > 0 {
+			q = append(q, all)
+		}
+		all = nil
+	}
+	for _, f := range strings.Fields(query) {
+		if f == "OR" {
+			flush()
+			continue
+		}
+		all = append(all, words(f)...)
+	}
+	flush()
+	return q
+}
+
+// matches reports whether content satisfies q, for the scan without an
+// index.
+func matches(content string, q [][]string) bool {
+	have := map[string]bool{}
+	for _, w := range words(content) {
+		have[w] = true
+	}
+	return slices.ContainsFunc(q, func(all []string) bool {
+		return !slices.ContainsFunc(all, func(w string) bool { return !have[w] })
+	})
+}
+
+// eachText calls fn with the label and content of every current
+// document that is not binary. Documents whose content cannot be read,
+// such as those encrypted under another key, are skipped. The caller
+// must hold the read lock, or be the only user of the handle.
+func (db *DB) eachText(fn func(label, content string)) error {
+	return db.documents(false, func(d docLine) bool {
+		if binary(d.line) {
+			return true
+		}
+		if content, err := db.content(d); err == nil {
+			fn(string(unescape([]byte(d.label))), string(content)) // scanned labels are still escaped
+		}
+		return true
+	})
+}
+
+// buildText fills the full-text index from every current document.
+// Called at Open and after compaction replaces the file, with no
+// writers running.
+func (db *DB) buildText() error {
+	db.text = newTextIndex()
+	return db.eachText(db.text.set)
+}
+
+// SearchText yields, in sorted order, the labels of current documents
+// matching query (see the file comment for its syntax). It answers from
+// the full-text index if Config.FullTextIndex is set, and otherwise
+// reads every document.
+func (db *DB) SearchText(query string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		q := parseText(query)
+		if len(q) == 0 {
+			return
+		}
+		if !db.config.FullTextIndex {
+			db.beginScan()
+			defer db.endScan()
+		}
+		if err := db.blockRead(); err != nil {
+			yield("", err)
+			return
+		}
+		defer func() {
+			db.mu.RUnlock()
+			db.lock.Unlock()
+		}()
+
+		var found map[string]bool
+		if db.text != nil {
+			found = db.text.match(q)
+		} else {
+			found = map[string]bool{}
+			err := db.eachText(func(lbl, content string) {
+				if matches(content, q) {
+					found[lbl] = true
+				}
+			})
+			if err != nil {
+				yield("", fmt.Errorf("searchtext: %w", err))
+				return
+			}
+		}
+		for _, lbl := range slices.Sorted(maps.Keys(found)) {
+			if _, err := db.current(lbl); errors.Is(err, ErrNotFound) {
+				continue // expired, or removed by another process
+			} else if err != nil {
+				yield("", fmt.Errorf("searchtext: %w", err))
+				return
+			}
+			if !yield(lbl, nil) {
+				return
+			}
+		}
+	}
+}
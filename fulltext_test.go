@@ -0,0 +1,74 @@
+// Full-text index tests.
+//
+// SearchText answers the same with or without Config.FullTextIndex, so
+// each test runs its queries against a handle with the index and checks
+// them against a scan of the same file.
+package folio
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestSearchText verifies query syntax and that the index follows sets,
+// deletes, renames, transactions, compaction, and reopening, agreeing
+// with the scan throughout.
+func TestSearchText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{FullTextIndex: true})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { db.Close() }()
+
+	db.Set("a", "Red apple, green pear")
+	db.Set("b", "red pepper")
+	db.Set("c", "a PEAR tree")
+	db.SetBytes("bin", []byte("red apple \xff")) // not UTF-8, so stored as binary
+
+	check := func(when, query string, want ...string) {
+		t.Helper()
+		got, err := Collect(db.SearchText(query))
+		if err != nil {
+			t.Fatalf("%s: SearchText(%q): %v", when, query, err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: SearchText(%q) = %v, want %v", when, query, got, want)
+		}
+		scan, err := Open(path, Config{ReadOnly: true})
+		if err != nil {
+			t.Fatalf("open scan handle: %v", err)
+		}
+		defer scan.Close()
+		if got, _ := Collect(scan.SearchText(query)); !slices.Equal(got, want) {
+			t.Errorf("%s: scan SearchText(%q) = %v, want %v", when, query, got, want)
+		}
+	}
+
+	check("initial", "red", "a", "b")
+	check("initial", "red apple", "a")
+	check("initial", "apple OR tree", "a", "c")
+	check("initial", "RED pepper OR pear", "a", "b", "c")
+	check("initial", "plum")
+	check("initial", "OR")
+
+	db.Set("b", "yellow pepper")
+	db.Delete("c")
+	db.Rename("a", "aa")
+	db.Txn(func(tx *Txn) error { return tx.Set("d", "red pear") })
+	check("after writes", "red", "aa", "d")
+	check("after writes", "pear", "aa", "d")
+	check("after writes", "pepper", "b")
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	check("after compaction", "red pear", "aa", "d")
+
+	db.Close()
+	if db, err = Open(path, Config{FullTextIndex: true}); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	check("after reopen", "red OR yellow", "aa", "b", "d")
+}
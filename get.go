@@ -3,16 +3,33 @@
 // Lookups check the sorted index section first (binary search, O(log n)),
 // then fall back to the sparse region (linear scan) for records written
 // since the last compaction. The optional bloom filter can skip the sparse
-// scan entirely when an ID is definitively absent.
+// scan entirely when an ID is definitively absent, the optional sparse
+// map (see sparsemap.go) replaces it with a single read, and the optional
+// miss cache (see miss.go) skips both regions for a label recently found
+// absent.
 package folio
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // Get returns the current content of a document identified by label.
 // The lookup follows the index (not the data records directly) because
 // the index is smaller and faster to binary search, then a single seek
 // to the data record's offset retrieves the content.
-func (db *DB) Get(label string) (string, error) {
+func (db *DB) Get(label string) (_ string, err error) {
+	defer db.observe(OpGet, label, time.Now(), &err)
+
+	content, err := db.get(label)
+	if db.quarantineOn(label, err) {
+		content, err = db.get(label)
+	}
+	return content, err
+}
+
+// get implements Get.
+func (db *DB) get(label string) (string, error) {
 	if err := db.blockRead(); err != nil {
 		return "", err
 	}
@@ -20,63 +37,76 @@ func (db *DB) Get(label string) (string, error) {
 		db.mu.RUnlock()
 		db.lock.Unlock()
 	}()
+	db.usage.reads.Add(1)
+	db.ops.gets.Add(1)
 
-	id := hash(label, db.header.Algorithm)
+	idx, err := db.current(label)
+	if err != nil {
+		return "", err
+	}
+	content, err := line(db.reader, idx.Offset)
+	if err != nil {
+		return "", fmt.Errorf("get: read record: %w", faultAt(label, idx.Offset, err))
+	}
+	record, err := db.decode(content)
+	if err != nil {
+		return "", fmt.Errorf("get: %w", faultAt(label, idx.Offset, err))
+	}
+	db.usage.bytesRead.Add(uint64(len(record.Data)))
+	return record.Data, nil
+}
+
+// current finds the index of label's current version: the sorted index
+// section first, then the newest matching index in the sparse region.
+// Returns ErrNotFound if there is none or it has expired. The caller
+// must hold db.mu.
+func (db *DB) current(label string) (*Index, error) {
+	id := db.id(label)
+	if db.missing(label, id) {
+		return nil, ErrNotFound
+	}
+	sz, err := size(db.reader)
+	if err != nil {
+		return nil, fmt.Errorf("get: stat: %w", err)
+	}
 
 	// Sorted index section — fast path after compaction
-	result := scan(db.reader, id, db.indexStart(), db.indexEnd(), TypeIndex)
-	if result != nil {
-		idx, err := decodeIndex(result.Data)
-		if err != nil {
-			return "", fmt.Errorf("get: %w", err)
-		}
-		if idx.Label == label {
-			content, err := line(db.reader, idx.Offset)
-			if err != nil {
-				return "", fmt.Errorf("get: read record: %w", err)
-			}
-			record, err := decode(content)
-			if err != nil {
-				return "", fmt.Errorf("get: %w", err)
-			}
-			return record.Data, nil
+	result, idx, err := db.sorted(id)
+	if err != nil {
+		return nil, fmt.Errorf("get: %w", err)
+	}
+	if result != nil && db.same(idx.Label, label) {
+		if idx.expired(now()) {
+			return nil, ErrNotFound
 		}
+		return idx, nil
 	}
 
 	if db.bloom != nil && !db.bloom.Contains(id) {
-		return "", ErrNotFound
+		db.miss(label, id, sz)
+		return nil, ErrNotFound
 	}
 
 	// Sparse region — reverse scan so the newest matching index wins
-	sz, err := size(db.reader)
+	result, idx, err = db.newest(id, label, sz)
 	if err != nil {
-		return "", fmt.Errorf("get: stat: %w", err)
+		return nil, fmt.Errorf("get: %w", err)
 	}
-	results := sparse(db.reader, id, db.sparseStart(), sz, TypeIndex)
-	for i := len(results) - 1; i >= 0; i-- {
-		idx, err := decodeIndex(results[i].Data)
-		if err != nil {
-			return "", fmt.Errorf("get: %w", err)
-		}
-		if idx.Label == label {
-			content, err := line(db.reader, idx.Offset)
-			if err != nil {
-				return "", fmt.Errorf("get: read record: %w", err)
-			}
-			record, err := decode(content)
-			if err != nil {
-				return "", fmt.Errorf("get: %w", err)
-			}
-			return record.Data, nil
-		}
+	if result == nil {
+		db.miss(label, id, sz)
+		return nil, ErrNotFound
 	}
-
-	return "", ErrNotFound
+	if idx.expired(now()) {
+		return nil, ErrNotFound
+	}
+	return idx, nil
 }
 
 // Exists performs the same two-region lookup as Get but returns as soon
 // as a matching index is found, without reading the data record.
-func (db *DB) Exists(label string) (bool, error) {
+func (db *DB) Exists(label string) (_ bool, err error) {
+	defer db.observe(OpExists, label, time.Now(), &err)
+
 	if err := db.blockRead(); err != nil {
 		return false, err
 	}
@@ -84,38 +114,37 @@ func (db *DB) Exists(label string) (bool, error) {
 		db.mu.RUnlock()
 		db.lock.Unlock()
 	}()
+	db.usage.reads.Add(1)
 
-	id := hash(label, db.header.Algorithm)
+	id := db.id(label)
+	if db.missing(label, id) {
+		return false, nil
+	}
+	sz, err := size(db.reader)
+	if err != nil {
+		return false, fmt.Errorf("exists: stat: %w", err)
+	}
 
-	result := scan(db.reader, id, db.indexStart(), db.indexEnd(), TypeIndex)
-	if result != nil {
-		idx, err := decodeIndex(result.Data)
-		if err != nil {
-			return false, fmt.Errorf("exists: %w", err)
-		}
-		if idx.Label == label {
-			return true, nil
-		}
+	result, idx, err := db.sorted(id)
+	if err != nil {
+		return false, fmt.Errorf("exists: %w", err)
+	}
+	if result != nil && db.same(idx.Label, label) {
+		return !idx.expired(now()), nil
 	}
 
 	if db.bloom != nil && !db.bloom.Contains(id) {
+		db.miss(label, id, sz)
 		return false, nil
 	}
 
-	sz, err := size(db.reader)
+	result, idx, err = db.newest(id, label, sz)
 	if err != nil {
-		return false, fmt.Errorf("exists: stat: %w", err)
+		return false, fmt.Errorf("exists: %w", err)
 	}
-	results := sparse(db.reader, id, db.sparseStart(), sz, TypeIndex)
-	for i := len(results) - 1; i >= 0; i-- {
-		idx, err := decodeIndex(results[i].Data)
-		if err != nil {
-			return false, fmt.Errorf("exists: %w", err)
-		}
-		if idx.Label == label {
-			return true, nil
-		}
+	if result == nil {
+		db.miss(label, id, sz)
+		return false, nil
 	}
-
-	return false, nil
+	return !idx.expired(now()), nil
 }
@@ -0,0 +1,154 @@
+// Multi-document retrieval.
+//
+// Calling Get in a loop pays for the lock, a binary search, and — for
+// every label not in the sorted index — a full scan of the sparse region,
+// once per label. GetMany resolves all of them under one read lock. The
+// requested IDs are sorted so the binary searches walk the index section
+// in order, labels still unresolved after that share a single pass over
+// the sparse region, and the data records are then read in file order.
+package folio
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// GetMany returns the current content of each label that exists, keyed
+// by label. Missing and expired labels are left out rather than failing
+// the call; an error means a record could not be read or decoded.
+// Duplicate labels are looked up once.
+func (db *DB) GetMany(labels ...string) (_ map[string]string, err error) {
+	defer db.observe(OpGetMany, "", time.Now(), &err)
+
+	if err := db.blockRead(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+	db.usage.reads.Add(uint64(len(labels)))
+	db.ops.gets.Add(uint64(len(labels)))
+
+	type want struct {
+		id, label string
+	}
+	wanted := make([]want, 0, len(labels))
+	seen := map[string]bool{}
+	for _, lbl := range labels {
+		if !seen[lbl] {
+			seen[lbl] = true
+			wanted = append(wanted, want{db.id(lbl), lbl})
+		}
+	}
+	slices.SortFunc(wanted, func(a, b want) int { return cmp.Compare(a.id, b.id) })
+
+	// Sorted index section: a live index there is always current.
+	found := map[string]*Index{}
+	pending := map[string]map[string]bool{} // id → labels still unresolved
+	for _, w := range wanted {
+		result, idx, err := db.sorted(w.id)
+		if err != nil {
+			return nil, fmt.Errorf("get: %w", err)
+		}
+		if result != nil && db.same(idx.Label, w.label) {
+			found[w.label] = idx
+			continue
+		}
+		if db.bloom != nil && !db.bloom.Contains(w.id) {
+			continue
+		}
+		if pending[w.id] == nil {
+			pending[w.id] = map[string]bool{}
+		}
+		pending[w.id][w.label] = true
+	}
+
+	// Sparse region: one lookup per label in the sparse map, or else one
+	// pass in which the newest matching index wins.
+	if len(pending) > 0 && db.smap != nil {
+		sz, err := size(db.reader)
+		if err != nil {
+			return nil, fmt.Errorf("get: stat: %w", err)
+		}
+		for id, lbls := range pending {
+			for lbl := range lbls {
+				result, idx, err := db.newest(id, lbl, sz)
+				if err != nil {
+					return nil, fmt.Errorf("get: %w", err)
+				}
+				if result != nil {
+					found[lbl] = idx
+				}
+			}
+		}
+	} else if len(pending) > 0 {
+		sz, err := size(db.reader)
+		if err != nil {
+			return nil, fmt.Errorf("get: stat: %w", err)
+		}
+		start := db.sparseStart()
+		f := db.borrow()
+		defer db.giveBack(f)
+		scanner := bufio.NewScanner(io.NewSectionReader(f, start, sz-start))
+		scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
+		for scanner.Scan() {
+			data := scanner.Bytes()
+			if !valid(data) || len(data) < MinRecordSize || data[TypePos] != byte('0'+TypeIndex) {
+				continue
+			}
+			lbls := pending[string(data[IDStart:IDEnd])]
+			if lbls == nil {
+				continue
+			}
+			stored := string(unescape([]byte(label(data))))
+			for lbl := range lbls {
+				if !db.same(lbl, stored) {
+					continue
+				}
+				idx, err := decodeIndex(data)
+				if err != nil {
+					return nil, fmt.Errorf("get: %w", err)
+				}
+				found[lbl] = idx
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("get: %w", err)
+		}
+	}
+
+	// Keyed by the label asked for, which in a case-insensitive file
+	// may differ from the stored one.
+	type hit struct {
+		label string
+		idx   *Index
+	}
+	t := now()
+	hits := make([]hit, 0, len(found))
+	for lbl, idx := range found {
+		if !idx.expired(t) {
+			hits = append(hits, hit{lbl, idx})
+		}
+	}
+	slices.SortFunc(hits, func(a, b hit) int { return cmp.Compare(a.idx.Offset, b.idx.Offset) })
+
+	out := make(map[string]string, len(hits))
+	for _, h := range hits {
+		content, err := line(db.reader, h.idx.Offset)
+		if err != nil {
+			return nil, fmt.Errorf("get: read record: %w", faultAt(h.label, h.idx.Offset, err))
+		}
+		record, err := db.decode(content)
+		if err != nil {
+			return nil, fmt.Errorf("get: %s: %w", h.label, faultAt(h.label, h.idx.Offset, err))
+		}
+		db.usage.bytesRead.Add(uint64(len(record.Data)))
+		out[h.label] = record.Data
+	}
+	return out, nil
+}
@@ -0,0 +1,71 @@
+// Lookups with a fallback.
+//
+// A document created lazily, such as a config read with a default until
+// someone first saves one, would otherwise take an Exists or a Get that
+// fails with ErrNotFound, then a Set, and two callers racing through
+// that gap would both write. GetOr returns a default for a missing
+// document under the one read lock its lookup takes. GetOrSet looks the
+// document up and, if it is missing, writes the initial content, all
+// under the write lock, so of any number of racing callers one writes
+// and every one of them returns what it wrote. An expired document
+// counts as missing to both.
+package folio
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GetOr returns the current content of label, or def if it does not
+// exist.
+func (db *DB) GetOr(label, def string) (_ string, err error) {
+	defer db.observe(OpGet, label, time.Now(), &err)
+
+	content, err := db.get(label)
+	if db.quarantineOn(label, err) {
+		content, err = db.get(label)
+	}
+	if errors.Is(err, ErrNotFound) {
+		return def, nil
+	}
+	return content, err
+}
+
+// GetOrSet returns the current content of label, first creating it with
+// initial if it does not exist. Creating it is an ordinary write: it
+// runs the hooks and Config.Validator, and initial is checked as Set
+// would check it even if the document exists. It takes the write lock
+// either way, so a read-only handle fails with ErrReadOnly.
+func (db *DB) GetOrSet(label, initial string) (_ string, err error) {
+	defer db.observe(OpSet, label, time.Now(), &err)
+
+	if err := db.checkDoc(label, initial); err != nil {
+		return "", err
+	}
+	var content string
+	err = db.single(func() error {
+		idx, err := db.current(label)
+		if errors.Is(err, ErrNotFound) {
+			content = initial
+			return db.setIf(label, initial, 0, condAbsent, 0)
+		}
+		if err != nil {
+			return err
+		}
+		data, err := line(db.reader, idx.Offset)
+		if err != nil {
+			return fmt.Errorf("getorset: read record: %w", faultAt(label, idx.Offset, err))
+		}
+		record, err := db.decode(data)
+		if err != nil {
+			return fmt.Errorf("getorset: %w", faultAt(label, idx.Offset, err))
+		}
+		content = record.Data
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return content, nil
+}
@@ -0,0 +1,87 @@
+package folio
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestGetOr verifies that GetOr returns the content of a document that
+// exists, and the default for one that is missing, deleted, or expired.
+func TestGetOr(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("config", `{"theme":"dark"}`)
+	db.Set("gone", "x")
+	db.Delete("gone")
+	db.SetWithTTL("stale", "x", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if got, err := db.GetOr("config", "{}"); err != nil || got != `{"theme":"dark"}` {
+		t.Errorf("GetOr(config) = %q, %v", got, err)
+	}
+	for _, lbl := range []string{"missing", "gone", "stale"} {
+		if got, err := db.GetOr(lbl, "{}"); err != nil || got != "{}" {
+			t.Errorf("GetOr(%s) = %q, %v; want the default", lbl, got, err)
+		}
+	}
+	db.Close()
+	if _, err := db.GetOr("config", "{}"); !errors.Is(err, ErrClosed) {
+		t.Errorf("GetOr after Close = %v, want ErrClosed", err)
+	}
+}
+
+// TestGetOrSet verifies that GetOrSet creates a missing document with
+// the initial content and leaves an existing one as it is.
+func TestGetOrSet(t *testing.T) {
+	db := openTestDB(t)
+
+	if got, err := db.GetOrSet("config", "first"); err != nil || got != "first" {
+		t.Fatalf("GetOrSet on a missing document = %q, %v", got, err)
+	}
+	if got, err := db.GetOrSet("config", "second"); err != nil || got != "first" {
+		t.Errorf("GetOrSet on an existing document = %q, %v; want first", got, err)
+	}
+	if versions, _ := Collect(db.History("config")); len(versions) != 1 {
+		t.Errorf("History holds %d versions, want 1", len(versions))
+	}
+
+	db.SetWithTTL("stale", "old", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if got, err := db.GetOrSet("stale", "fresh"); err != nil || got != "fresh" {
+		t.Errorf("GetOrSet on an expired document = %q, %v; want fresh", got, err)
+	}
+	if _, err := db.GetOrSet("config", ""); !errors.Is(err, ErrEmptyContent) {
+		t.Errorf("GetOrSet with empty content = %v, want ErrEmptyContent", err)
+	}
+}
+
+// TestGetOrSetConcurrent verifies that of many callers racing to create
+// one document, exactly one writes and all return its content.
+func TestGetOrSetConcurrent(t *testing.T) {
+	db := openTestDB(t)
+
+	var wg sync.WaitGroup
+	got := make([]string, 20)
+	for i := range got {
+		wg.Go(func() {
+			content, err := db.GetOrSet("config", fmt.Sprintf("writer %d", i))
+			if err != nil {
+				t.Error(err)
+			}
+			got[i] = content
+		})
+	}
+	wg.Wait()
+
+	want, _ := db.Get("config")
+	for i, content := range got {
+		if content != want {
+			t.Errorf("caller %d got %q, stored %q", i, content, want)
+		}
+	}
+	if versions, _ := Collect(db.History("config")); len(versions) != 1 {
+		t.Errorf("History holds %d versions, want 1", len(versions))
+	}
+}
@@ -0,0 +1,258 @@
+// Glob-style label matching.
+//
+// Glob yields the labels of current documents matching a shell-style
+// pattern. A star matches any run of characters, including none and
+// including '/', and a question mark any one character. [abc] matches
+// one of a, b, or c, [a-z] one of a range, and [!a-z] or [^a-z] one not
+// in it. A backslash makes the character after it match only itself.
+//
+// Labels are flat strings rather than paths, so unlike path.Match and
+// SearchOptions.LabelPattern, "notes/*" also matches "notes/a/b".
+//
+// The matcher works on the pattern directly, with the usual backtracking
+// to the last star, rather than compiling it to a regex. The bytes before
+// the first metacharacter are a literal prefix that every match begins
+// with. The index section is sorted by ID, not by label, so the prefix
+// cannot be binary searched; instead, as in ListPrefix, each index line
+// is checked for the prefix as raw bytes and only those that have it are
+// matched, and the heap is skipped altogether. A pattern with no
+// metacharacters names one label, which is looked up by its hash.
+package folio
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+	"unicode/utf8"
+
+	json "github.com/goccy/go-json"
+)
+
+// Glob yields the labels of current documents matching pattern (see the
+// file comment for its syntax). Labels are deduplicated but not sorted.
+// Returns ErrInvalidPattern for an unclosed class or a trailing
+// backslash.
+func (db *DB) Glob(pattern string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		if !validGlob(pattern) {
+			yield("", ErrInvalidPattern)
+			return
+		}
+		prefix := pattern[:strings.IndexAny(pattern+"*", `*?[\`)]
+		if prefix == pattern {
+			db.globExact(pattern, yield)
+			return
+		}
+
+		db.beginScan()
+		defer db.endScan()
+		if err := db.blockRead(); err != nil {
+			yield("", err)
+			return
+		}
+		defer func() {
+			db.mu.RUnlock()
+			db.lock.Unlock()
+		}()
+
+		sz, err := size(db.reader)
+		if err != nil {
+			yield("", fmt.Errorf("glob: stat: %w", err))
+			return
+		}
+
+		// Escape the prefix as it is stored, as Search does its needle.
+		raw, _ := json.Marshal(prefix)
+		marker := append([]byte(`"_l":"`), raw[1:len(raw)-1]...)
+		seen := make(map[string]bool)
+		t := now()
+
+		// scanRegion scans [start, end) for matching index records.
+		// Returns false if the caller broke out of the range loop.
+		scanRegion := func(start, end int64) bool {
+			if start >= end {
+				return true
+			}
+			f := db.borrow()
+			defer db.giveBack(f)
+			section := io.NewSectionReader(f, start, end-start)
+			scanner := bufio.NewScanner(section)
+			scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
+
+			for scanner.Scan() {
+				data := scanner.Bytes()
+
+				if !valid(data) || len(data) < MinRecordSize || data[TypePos] != byte('0'+TypeIndex) {
+					continue
+				}
+				if !bytes.Contains(data, marker) {
+					continue
+				}
+				if ex := expires(data); ex != 0 && ex <= t {
+					continue
+				}
+				lbl := string(unescape([]byte(label(data))))
+				if !seen[lbl] && globMatch(pattern, lbl) {
+					seen[lbl] = true
+					if !yield(lbl, nil) {
+						return false
+					}
+				}
+			}
+
+			if err := scanner.Err(); err != nil {
+				yield("", err)
+				return false
+			}
+			return true
+		}
+
+		if !scanRegion(db.indexStart(), db.indexEnd()) {
+			return
+		}
+		scanRegion(db.sparseStart(), sz)
+	}
+}
+
+// globExact yields label if it is a current document.
+func (db *DB) globExact(label string, yield func(string, error) bool) {
+	if validateLabel(label) != nil {
+		return // no document can have it
+	}
+	if err := db.blockRead(); err != nil {
+		yield("", err)
+		return
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+
+	if _, err := db.current(label); errors.Is(err, ErrNotFound) {
+		return
+	} else if err != nil {
+		yield("", fmt.Errorf("glob: %w", err))
+		return
+	}
+	yield(label, nil)
+}
+
+// validGlob reports whether every class in pattern is closed and every
+// backslash escapes something.
+func validGlob(pattern string) bool {
+	for p := 0; p < len(pattern); p++ {
+		switch pattern[p] {
+		case '\\':
+			if p++; p == len(pattern) {
+				return false
+			}
+		case '[':
+			_, n := matchClass(pattern[p:], 0)
+			if n == 0 {
+				return false
+			}
+			p += n - 1
+		}
+	}
+	return true
+}
+
+// globMatch reports whether s matches pattern, which must be valid. On
+// a mismatch after a star, the star takes one more character and the
+// match resumes after it, so the work is at most the product of their
+// lengths.
+func globMatch(pattern, s string) bool {
+	p, i := 0, 0
+	star, retry := -1, 0 // position of the last star, and of s when it was reached
+	for i < len(s) {
+		if p < len(pattern) {
+			r, n := utf8.DecodeRuneInString(s[i:])
+			switch pattern[p] {
+			case '*':
+				star, retry = p, i
+				p++
+				continue
+			case '?':
+				p, i = p+1, i+n
+				continue
+			case '[':
+				if ok, w := matchClass(pattern[p:], r); ok {
+					p, i = p+w, i+n
+					continue
+				}
+			case '\\':
+				if pattern[p+1] == s[i] {
+					p, i = p+2, i+1
+					continue
+				}
+			default:
+				if pattern[p] == s[i] {
+					p, i = p+1, i+1
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		_, n := utf8.DecodeRuneInString(s[retry:])
+		retry += n
+		p, i = star+1, retry
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches r against the class at the start of pattern, which
+// begins with '['. It returns whether r is in the class and the class's
+// width in bytes, or 0 if the class is not closed. A ']' first in the
+// class is an ordinary character.
+func matchClass(pattern string, r rune) (bool, int) {
+	p := 1
+	negate := p < len(pattern) && (pattern[p] == '!' || pattern[p] == '^')
+	if negate {
+		p++
+	}
+	// char reads one possibly escaped character of the class.
+	char := func() (rune, bool) {
+		if p < len(pattern) && pattern[p] == '\\' {
+			p++
+		}
+		if p >= len(pattern) {
+			return 0, false
+		}
+		c, n := utf8.DecodeRuneInString(pattern[p:])
+		p += n
+		return c, true
+	}
+
+	in := false
+	for first := true; ; first = false {
+		if p >= len(pattern) {
+			return false, 0
+		}
+		if pattern[p] == ']' && !first {
+			return in != negate, p + 1
+		}
+		lo, ok := char()
+		if !ok {
+			return false, 0
+		}
+		hi := lo
+		if p+1 < len(pattern) && pattern[p] == '-' && pattern[p+1] != ']' {
+			p++
+			if hi, ok = char(); !ok {
+				return false, 0
+			}
+		}
+		if lo <= r && r <= hi {
+			in = true
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Glob tests.
+//
+// The matcher is checked against a table of patterns first, then Glob
+// against a file with labels in the sorted index section and in the
+// sparse region, so that the literal-prefix filter and the matcher are
+// exercised together.
+package folio
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestGlobMatch verifies each element of the pattern syntax, including
+// backtracking past a star and characters outside ASCII.
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"abc", "abc", true},
+		{"abc", "abd", false},
+		{"a*", "a", true},
+		{"a*", "a/b/c", true},
+		{"*c", "abc", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"*ab", "aab", true},
+		{"?", "é", true},
+		{"??", "é", false},
+		{"[abc]x", "bx", true},
+		{"[a-c]x", "dx", false},
+		{"[!a-c]x", "dx", true},
+		{"[^a-c]x", "ax", false},
+		{"[]]", "]", true},
+		{"[é-ë]", "ê", true},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{`[\]]`, "]", true},
+		{"", "", true},
+		{"*", "", true},
+	}
+	for _, tt := range tests {
+		if !validGlob(tt.pattern) {
+			t.Errorf("validGlob(%q) = false", tt.pattern)
+			continue
+		}
+		if got := globMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+	for _, bad := range []string{"[abc", `abc\`, "[", "[a-"} {
+		if validGlob(bad) {
+			t.Errorf("validGlob(%q) = true, want false", bad)
+		}
+	}
+}
+
+// TestGlob verifies Glob over both regions of the file, the exact
+// lookup used when the pattern has no metacharacters, and that expired
+// documents and invalid patterns are handled.
+func TestGlob(t *testing.T) {
+	db := openTestDB(t)
+	for _, lbl := range []string{"notes/a", "notes/b1", "notes/deep/c", "todo/a"} {
+		db.Set(lbl, "x")
+	}
+	db.Compact()
+	db.Set("notes/b2", "x")
+	db.SetWithTTL("notes/old", "x", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	glob := func(pattern string) []string {
+		t.Helper()
+		labels, err := Collect(db.Glob(pattern))
+		if err != nil {
+			t.Fatalf("Glob(%q): %v", pattern, err)
+		}
+		slices.Sort(labels)
+		return labels
+	}
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"notes/*", []string{"notes/a", "notes/b1", "notes/b2", "notes/deep/c"}},
+		{"notes/b?", []string{"notes/b1", "notes/b2"}},
+		{"*/a", []string{"notes/a", "todo/a"}},
+		{"notes/[!a]*", []string{"notes/b1", "notes/b2", "notes/deep/c"}},
+		{"todo/a", []string{"todo/a"}},
+		{"todo/z", nil},
+		{"notes/old", nil},
+	}
+	for _, tt := range tests {
+		if got := glob(tt.pattern); !slices.Equal(got, tt.want) {
+			t.Errorf("Glob(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+
+	if _, err := Collect(db.Glob("notes/[")); !errors.Is(err, ErrInvalidPattern) {
+		t.Errorf("Glob(unclosed class) = %v, want ErrInvalidPattern", err)
+	}
+}
@@ -3,8 +3,8 @@
 // _id is always 16 hex characters (64 bits). This fixed width is what
 // allows scanm to extract IDs at a known byte offset without parsing.
 // The algorithm is stored in the header so all records in a file use the
-// same one; Rehash can migrate between algorithms in place because the
-// output width is identical across all three.
+// same one; Rehash can migrate between algorithms without moving a byte
+// of any record because the output width is identical across all three.
 //
 // xxHash3 is the default because it has the best throughput for short
 // strings (document labels) and excellent distribution. FNV-1a exists
@@ -47,3 +47,13 @@ func hash(label string, alg int) string {
 		return ""
 	}
 }
+
+// ID returns the _id the file gives label: its hash under the file's
+// algorithm, case-folded first if the file folds labels. Records are
+// sorted by it, so a layer spreading labels across files by ID range
+// can route them with it.
+func (db *DB) ID(label string) string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.id(label)
+}
@@ -17,6 +17,7 @@
 package folio
 
 import (
+	"path/filepath"
 	"regexp"
 	"testing"
 )
@@ -136,3 +137,17 @@ func TestHashAlgorithmConstants(t *testing.T) {
 		t.Errorf("AlgBlake2b = %d, want 3", AlgBlake2b)
 	}
 }
+
+// TestID guards the exported ID against the IDs records are written
+// with: a layer routing labels by it must agree with the file, case
+// folding included.
+func TestID(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{CaseInsensitiveLabels: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if got, want := db.ID("Notes/A"), hash(foldCase("notes/a"), AlgXXHash3); got != want {
+		t.Errorf("ID = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,166 @@
+// On-disk hash table over the sorted index.
+//
+// A lookup in the sorted index section is a binary search, a read per
+// step however the section is laid out (see fixed.go): twenty for a
+// million documents. With Config.HashTable, every rebuild also writes a
+// table mapping each indexed ID to the offset of its index line, by open
+// addressing with linear probing, so a lookup reads the slots from the
+// ID's own until it meets the ID or an empty one, which at the table's
+// load of a half is one read, then the index line it names. ID and
+// offset are checked against that line before it is trusted; a lookup
+// the table cannot answer, because the ID is not in it or its line has
+// since been patched, falls back to the binary search, so the table is
+// never patched along with the index and never has to be right to keep
+// lookups right.
+//
+// The table follows the index lines, before the end of the index
+// section the header records, so the sparse scans that start there
+// never read it, and the metadata record holds where it starts. It is
+// JSONL like the rest of the file: lines of type 8, each holding
+// tableSlots slots in its _t string, and every line the same length, so
+// a slot's byte position is computed from its number. A slot is the ID
+// in 16 hex digits and the offset in 12, or all zeros if it is empty.
+// An ID is its own hash: its value modulo the slot count, a power of
+// two, is its first slot.
+package folio
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// TypeTable marks a line of the hash table. Like the metadata record it
+// has no label and is never returned by document lookups or scans.
+const TypeTable = 8
+
+// tableVersion is the format version that added the hash table.
+const tableVersion = 6
+
+// Hash table geometry. A line is the fixed prefix every record shares,
+// up to the end of _ts, then ,"_t":" and the slots, then "}.
+const (
+	tableSlots  = 128                    // slots per line
+	slotSize    = 28                     // 16 hex digits of ID, 12 of offset
+	slotsStart  = TSEnd + len(`,"_t":"`) // first slot of a line
+	tableLine   = slotsStart + tableSlots*slotSize + len(`"}`)
+	probeSlots  = 16 // slots read at once while probing
+	emptyOffset = "000000000000"
+)
+
+// hashTable locates the hash table in the file.
+type hashTable struct {
+	start int64  // offset of its first line
+	slots uint64 // slot count, a power of two
+}
+
+// loadTable finds the hash table the metadata record points at, or
+// returns nil if the file has none or its size does not add up.
+func (db *DB) loadTable() *hashTable {
+	if db.header.Version < tableVersion || db.meta == nil || db.meta.Table == 0 {
+		return nil
+	}
+	start, end := db.meta.Table, int64(db.header.State[stIndex])
+	n := (end - start) / int64(tableLine+1)
+	if start < db.indexStart() || n <= 0 || (end-start)%int64(tableLine+1) != 0 || n&(n-1) != 0 {
+		return nil
+	}
+	return &hashTable{start: start, slots: uint64(n) * tableSlots}
+}
+
+// tableSize returns the slot count of a table over n IDs: the power of
+// two at least twice n, and at least a line's worth.
+func tableSize(n int) uint64 {
+	slots := uint64(tableSlots)
+	for slots < 2*uint64(n) {
+		slots *= 2
+	}
+	return slots
+}
+
+// home returns the first slot of id in a table of slots slots.
+func home(id string, slots uint64) uint64 {
+	h, _ := strconv.ParseUint(id, 16, 64)
+	return h & (slots - 1)
+}
+
+// writeTable writes a hash table mapping ids to offs, the IDs of the
+// index lines just written and their offsets, at ow's position.
+func writeTable(ow *offsetWriter, ids []string, offs []int64) error {
+	slots := tableSize(len(ids))
+	table := bytes.Repeat([]byte{'0'}, int(slots)*slotSize)
+	for i, id := range ids {
+		s := home(id, slots)
+		for string(table[s*slotSize+16:(s+1)*slotSize]) != emptyOffset {
+			s = (s + 1) & (slots - 1)
+		}
+		copy(table[s*slotSize:], id)
+		copy(table[s*slotSize+16:], fmt.Sprintf("%012x", offs[i]))
+	}
+
+	ts := now()
+	for at := 0; at < len(table); at += tableSlots * slotSize {
+		ln := fmt.Appendf(nil, `{"_r":%d,"_id":"%s","_ts":%d,"_t":"%s"}`, TypeTable, metaID, ts, table[at:at+tableSlots*slotSize])
+		if len(ln) != tableLine {
+			return fmt.Errorf("repair: hash table line of %d bytes", len(ln))
+		}
+		if _, err := ow.Write(append(ln, '\n')); err != nil {
+			return fmt.Errorf("repair: write hash table: %w", err)
+		}
+	}
+	return nil
+}
+
+// slotAt returns the byte position of slot s.
+func (t *hashTable) slotAt(s uint64) int64 {
+	return t.start + int64(s/tableSlots)*int64(tableLine+1) + int64(slotsStart) + int64(s%tableSlots)*slotSize
+}
+
+// find returns the offset of the index line the table holds for id, or
+// -1 if it holds none.
+func (t *hashTable) find(f storage, id string) int64 {
+	var buf [probeSlots * slotSize]byte
+	s := home(id, t.slots)
+	for probed := uint64(0); probed < t.slots; {
+		n := min(probeSlots, tableSlots-s%tableSlots, t.slots-probed)
+		b := buf[:n*slotSize]
+		if m, _ := f.ReadAt(b, t.slotAt(s)); m < len(b) {
+			return -1
+		}
+		for i := range n {
+			slot := b[i*slotSize : (i+1)*slotSize]
+			if string(slot[16:]) == emptyOffset {
+				return -1
+			}
+			if string(slot[:16]) == id {
+				off, err := strconv.ParseInt(string(slot[16:]), 16, 64)
+				if err != nil {
+					return -1
+				}
+				return off
+			}
+		}
+		probed += n
+		s = (s + n) & (t.slots - 1)
+	}
+	return -1
+}
+
+// lookup returns the index line of id through the hash table, or nil if
+// the table cannot answer.
+func (db *DB) lookup(id string) *Result {
+	off := db.table.find(db.reader, id)
+	if off < db.indexStart() || off >= db.indexEnd() {
+		return nil
+	}
+	var r *Result
+	if db.width > 0 {
+		r = db.entry(off)
+	} else if data, err := line(db.reader, off); err == nil && valid(data) && len(data) >= MinRecordSize && data[TypePos] == '0'+TypeIndex {
+		r = &Result{off, len(data), data, string(data[IDStart:IDEnd])}
+	}
+	if r == nil || r.ID != id {
+		return nil
+	}
+	return r
+}
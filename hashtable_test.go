@@ -0,0 +1,142 @@
+package folio
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestHashTable verifies that a rebuild with Config.HashTable writes a
+// table that lookups go through in a few reads, that the file still
+// verifies and reopens with it, and that a rebuild without the option
+// drops it.
+func TestHashTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{HashTable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var labels []string
+	for i := range 500 {
+		lbl := fmt.Sprintf("doc/%d", i)
+		db.Set(lbl, fmt.Sprintf("content %d", i))
+		labels = append(labels, lbl)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if db.table == nil || db.meta == nil || db.meta.Table != db.table.start {
+		t.Fatalf("no hash table after Compact: %+v", db.table)
+	}
+	if db.table.slots < 1000 {
+		t.Errorf("%d slots for 500 documents, want at least 1000", db.table.slots)
+	}
+
+	f := &countingFile{File: db.reader.(*os.File)}
+	db.reader = f
+	for _, lbl := range labels {
+		f.reads = 0
+		r, idx, err := db.sorted(db.id(lbl))
+		if err != nil || r == nil || idx.Label != lbl {
+			t.Fatalf("sorted(%s) = %v, %v", lbl, idx, err)
+		}
+		if f.reads > 3 {
+			t.Errorf("lookup of %s made %d reads, want at most 3", lbl, f.reads)
+		}
+	}
+	db.reader = f.File
+
+	for _, lbl := range labels {
+		if got, err := db.Get(lbl); err != nil || got == "" {
+			t.Errorf("Get(%s) = %q, %v", lbl, got, err)
+		}
+	}
+	if _, err := db.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(missing) = %v, want ErrNotFound", err)
+	}
+	if listed, err := Collect(db.List()); err != nil || len(listed) != len(labels) {
+		t.Errorf("List = %d labels, %v; want %d", len(listed), err, len(labels))
+	}
+	if rep, err := db.Verify(VerifyOptions{}); err != nil || !rep.OK() {
+		t.Errorf("Verify = %+v, %v", rep.Problems, err)
+	}
+	db.Close()
+
+	db, err = Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.table == nil {
+		t.Fatal("hash table not found on reopen")
+	}
+	if got, err := db.Get("doc/7"); err != nil || got != "content 7" {
+		t.Errorf("Get after reopen = %q, %v", got, err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if db.table != nil || db.meta != nil && db.meta.Table != 0 {
+		t.Error("a rebuild without the option kept the hash table")
+	}
+	if got, err := db.Get("doc/7"); err != nil || got != "content 7" {
+		t.Errorf("Get without the table = %q, %v", got, err)
+	}
+}
+
+// TestHashTableStale verifies that lookups stay right once the index
+// has moved on from the table: a same-length rename patches the index
+// in place, and documents written since are found in the sparse region.
+func TestHashTableStale(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{HashTable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := range 50 {
+		db.Set(fmt.Sprintf("doc/%02d", i), fmt.Sprintf("content %d", i))
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Rename("doc/01", "new/01"); err != nil {
+		t.Fatal(err)
+	}
+	db.Set("doc/02", "changed")
+	db.Set("late", "appended")
+
+	if _, err := db.Get("doc/01"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of the renamed label = %v, want ErrNotFound", err)
+	}
+	for lbl, want := range map[string]string{"new/01": "content 1", "doc/02": "changed", "late": "appended", "doc/03": "content 3"} {
+		if got, err := db.Get(lbl); err != nil || got != want {
+			t.Errorf("Get(%s) = %q, %v; want %q", lbl, got, err, want)
+		}
+	}
+}
+
+// TestHashTableCompactStep verifies that an incremental rebuild writes
+// the table too.
+func TestHashTableCompactStep(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{HashTable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := range 50 {
+		db.Set(fmt.Sprintf("doc/%d", i), "content")
+	}
+	for done := false; !done; {
+		if done, err = db.CompactStep(&CompactOptions{ChunkBytes: 512}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if db.table == nil {
+		t.Fatal("no hash table after CompactStep")
+	}
+	if rep, err := db.Verify(VerifyOptions{}); err != nil || !rep.OK() {
+		t.Errorf("Verify = %+v, %v", rep.Problems, err)
+	}
+}
@@ -2,15 +2,31 @@
 // section boundaries, document count, and a dirty flag for crash recovery.
 // The fixed size allows the dirty flag to be toggled with a single-byte
 // write at a known offset, avoiding a full header rewrite on every mutation.
+//
+// It also records the format version the file was written in, so a file
+// from a newer library is refused rather than misread, and a generation
+// that every rebuild advances, so a process holding offsets into the file
+// can tell they no longer mean anything.
 package folio
 
 import (
 	"bytes"
-	"os"
+	"fmt"
 
 	json "github.com/goccy/go-json"
 )
 
+// FormatVersion is the file format this library writes. Version 2 added
+// the header's generation (_g), version 3 tombstones (see journal.go),
+// version 4 sealed segments of the sparse region (see segment.go),
+// version 5 an index section of one line width (see fixed.go), and
+// version 6 the hash table that may follow it (see hashtable.go). Open
+// refuses a file of a later version with ErrFormatVersion. An earlier
+// one is read and appended to as it is, stamped with version 3 before
+// its first tombstone and version 4 by its first seal, and rewritten in
+// the current version by Migrate or the next rebuild.
+const FormatVersion = 6
+
 // HeaderSize is fixed so the dirty flag can be patched at a known byte
 // offset without rewriting the whole header.
 const HeaderSize = 128
@@ -20,7 +36,7 @@ const HeaderSize = 128
 const (
 	stHeap      = 0 // end of heap section (byte offset)
 	stIndex     = 1 // end of index section (byte offset)
-	stReserved  = 2 // reserved (0)
+	stMeta      = 2 // offset of the metadata record (0 = none, see meta.go)
 	stCount     = 3 // best-guess document count; corrected by Compact/Repair
 	stWrites    = 4 // writes since last compaction
 	stThreshold = 5 // auto-compaction modulus (0 = disabled)
@@ -31,22 +47,34 @@ const (
 //
 //	[0..128)                Header (this struct, space-padded, newline-terminated)
 //	[128..State[stHeap])    Heap: data + history sorted by ID then timestamp
-//	[State[stHeap]..State[stIndex])  Sorted index records
+//	[State[stHeap]..State[stIndex])  Sorted index records, then the hash table if any
 //	[State[stIndex]..EOF)   Sparse region (unsorted appends since last compaction)
 //
 // Within each ID group in the heap, records are sorted oldest-first.
 // History records (_r=3) precede the current data record (_r=2).
 // A zero offset means that section is empty or not yet established.
 type Header struct {
-	Version   int       `json:"_v"`   // Format version: 1 = current
-	Error     int       `json:"_e"`   // Dirty flag: 1 = unclean shutdown detected
-	Algorithm int       `json:"_alg"` // Hash algorithm used to derive _id from label
-	Timestamp int64     `json:"_ts"`  // Unix ms when this header was last written
-	State     [6]uint64 `json:"_s"`   // Section boundaries, counts, compaction state
+	Version    int       `json:"_v"`           // Format version (see FormatVersion)
+	Error      int       `json:"_e"`           // Dirty flag: 1 = unclean shutdown detected
+	Algorithm  int       `json:"_alg"`         // Hash algorithm used to derive _id from label
+	Timestamp  int64     `json:"_ts"`          // Unix ms when this header was last written
+	State      [6]uint64 `json:"_s"`           // Section boundaries, counts, compaction state
+	Generation uint64    `json:"_g,omitempty"` // Rebuilds the file has been through; omitted before the first
+	Flags      int       `json:"_f,omitempty"` // Layout flags (see flag constants); omitted when 0
+	Codec      Codec     `json:"_z,omitempty"` // Snapshot compression (see compress.go); omitted for Zstd
 }
 
+// Header flags. Each bit records a property of the layout written by the
+// last compaction that readers must know to search the file correctly.
+const (
+	flagInsertionOrder = 1 << 0 // heap grouped by creation time, not sorted by ID
+	flagFoldLabels     = 1 << 1 // labels are case-insensitive; set at creation (see fold.go)
+	flagDeltaHistory   = 1 << 2 // history may hold delta records (see delta.go)
+	flagSharedContent  = 1 << 3 // records may share content (see dedup.go)
+)
+
 // header parses the fixed-size header from byte 0 of the file.
-func header(f *os.File) (*Header, error) {
+func header(f storage) (*Header, error) {
 	buf := make([]byte, HeaderSize)
 	if _, err := f.ReadAt(buf, 0); err != nil {
 		return nil, err
@@ -56,6 +84,9 @@ func header(f *os.File) (*Header, error) {
 	if err := json.Unmarshal(bytes.TrimSpace(buf), &hdr); err != nil {
 		return nil, ErrCorruptHeader
 	}
+	if hdr.Version > FormatVersion {
+		return nil, fmt.Errorf("%w: file is version %d, this library reads up to %d", ErrFormatVersion, hdr.Version, FormatVersion)
+	}
 	heap, idx := hdr.State[stHeap], hdr.State[stIndex]
 	if heap != 0 && heap < HeaderSize {
 		return nil, ErrCorruptHeader
@@ -66,13 +97,19 @@ func header(f *os.File) (*Header, error) {
 	if heap != 0 && idx != 0 && heap > idx {
 		return nil, ErrCorruptHeader
 	}
+	if !hdr.Codec.known() {
+		return nil, ErrCorruptHeader
+	}
 	return &hdr, nil
 }
 
+// versionPos is the byte offset of the single _v digit: {"_v":N.
+const versionPos = 6
+
 // dirty patches the _e field in place without rewriting the full header.
 // The value sits at byte 13: {"_v":1,"_e":X — this position is stable
 // because _v and _e are always serialised first and _v is single-digit.
-func dirty(w *os.File, v bool) error {
+func dirty(w storage, v bool) error {
 	b := byte('0')
 	if v {
 		b = '1'
@@ -10,11 +10,14 @@
 // These tests verify: encoding produces exactly 128 bytes, round-trip
 // encode/decode preserves all fields, the dirty flag occupies the expected
 // byte position (so writeAt can flip it without re-encoding the full
-// header), and invalid headers are rejected before any data operations
-// begin.
+// header), invalid headers are rejected before any data operations
+// begin, files from a newer format version are refused, and the
+// generation advances with each rebuild.
 package folio
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -335,3 +338,128 @@ func TestHeaderAllAlgorithms(t *testing.T) {
 		}
 	}
 }
+
+// writeVersion rewrites the header of the closed database at path as
+// version v, as a library of that version would have left it.
+func writeVersion(t *testing.T, path string, v int) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	hdr, err := header(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr.Version = v
+	if v < 2 {
+		hdr.Generation = 0 // version 1 had none
+	}
+	buf, err := hdr.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestHeaderNewerVersion verifies that Open refuses a file written by a
+// newer library, read-only or not, and leaves it untouched: a guess at
+// its layout could return wrong answers, and recovery would rewrite it
+// in a format its own library no longer reads as it wrote it.
+func TestHeaderNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	db.Set("doc", "content")
+	db.Close()
+	writeVersion(t, path, FormatVersion+1)
+	before, _ := os.ReadFile(path)
+
+	for _, cfg := range []Config{{}, {ReadOnly: true}} {
+		if db, err := Open(path, cfg); !errors.Is(err, ErrFormatVersion) {
+			if err == nil {
+				db.Close()
+			}
+			t.Errorf("Open(ReadOnly=%v) = %v, want ErrFormatVersion", cfg.ReadOnly, err)
+		}
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(before, after) {
+		t.Error("refused file was modified")
+	}
+}
+
+// TestMigrate verifies that a version 1 file opens and reads as it is,
+// that Migrate rewrites it as the current version with its documents and
+// history, and that a second Migrate has nothing to do.
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	db.Set("doc", "one")
+	db.Set("doc", "two")
+	db.Close()
+	writeVersion(t, path, 1)
+
+	db, err = Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open of version 1: %v", err)
+	}
+	defer db.Close()
+	if s := db.Stats(); s.Version != 1 || s.Generation != 0 {
+		t.Fatalf("Stats = version %d generation %d, want 1 and 0", s.Version, s.Generation)
+	}
+	if got, _ := db.Get("doc"); got != "two" {
+		t.Errorf("Get before Migrate = %q", got)
+	}
+
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if s := db.Stats(); s.Version != FormatVersion || s.Generation != 1 {
+		t.Errorf("Stats after Migrate = version %d generation %d, want %d and 1", s.Version, s.Generation, FormatVersion)
+	}
+	if versions, _ := Collect(db.History("doc")); len(versions) != 2 {
+		t.Errorf("History after Migrate = %d versions, want 2", len(versions))
+	}
+	if err := db.Migrate(); err != nil || db.Stats().Generation != 1 {
+		t.Errorf("second Migrate = %v, generation %d, want nothing done", err, db.Stats().Generation)
+	}
+}
+
+// TestHeaderGeneration verifies that every rebuild advances the
+// generation and that it survives a reopen; other header writes, such as
+// the one Close makes, leave it alone.
+func TestHeaderGeneration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	db.Set("doc", "content")
+	if g := db.Stats().Generation; g != 0 {
+		t.Errorf("new file generation = %d, want 0", g)
+	}
+	db.Compact()
+	db.Purge()
+	db.Rehash(AlgFNV1a)
+	if g := db.Stats().Generation; g != 3 {
+		t.Errorf("generation after three rebuilds = %d, want 3", g)
+	}
+	db.Close()
+
+	db, err = Open(path, Config{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	if g := db.Stats().Generation; g != 3 {
+		t.Errorf("generation after reopen = %d, want 3", g)
+	}
+}
@@ -7,12 +7,21 @@
 // After compaction, all versions of a document are contiguous in the heap
 // (sorted by ID then timestamp). History uses group() to binary-search the
 // heap for the ID group, then linearly scans the sparse region for any
-// records appended since the last compaction.
+// records appended since the last compaction. A heap compacted with
+// PreserveInsertionOrder is not sorted by ID, so History reaches the group
+// through the sorted index instead (see insertionGroup).
 //
 // Because results must be sorted by file offset (the ground truth for write
 // order), all versions are collected and sorted before yielding. The
 // iterator API provides consistency with Search, MatchLabel, and List even
-// though this method buffers internally.
+// though this method buffers internally. What is buffered is the parsed
+// records; each snapshot is decompressed only as it is yielded, so
+// HistoryWith can bound, reverse, and limit the versions first.
+//
+// GetAt and GetVersion select a single version the same way but only
+// decompress the one they return, and for a delta the versions it is
+// patched onto (see delta.go). Revert writes a selected version back
+// as a new current version, so the audit trail only ever grows.
 package folio
 
 import (
@@ -20,6 +29,7 @@ import (
 	"fmt"
 	"iter"
 	"slices"
+	"time"
 )
 
 // Version is a single point-in-time snapshot of a document's content.
@@ -32,74 +42,330 @@ type Version struct {
 // It searches the heap via binary search (O(log n) + group size), then
 // scans the sparse region for records appended since the last compaction.
 func (db *DB) History(label string) iter.Seq2[Version, error] {
+	return db.HistoryWith(label, HistoryOptions{})
+}
+
+// HistoryOptions selects the versions HistoryWith yields.
+type HistoryOptions struct {
+	// Since and Until bound the versions by timestamp, in unix ms, both
+	// inclusive. A zero Until means no upper bound.
+	Since, Until int64
+
+	// Reverse yields the newest version first.
+	Reverse bool
+
+	// Limit, if positive, stops after that many versions, counted in the
+	// order they are yielded: with Reverse, Limit 5 is the latest five.
+	Limit int
+}
+
+// choose returns the indices of the records opts keeps, in the order
+// they are yielded.
+func (opts HistoryOptions) choose(records []*Record) []int {
+	var picked []int
+	for i, r := range records {
+		if r.Timestamp >= opts.Since && (opts.Until == 0 || r.Timestamp <= opts.Until) {
+			picked = append(picked, i)
+		}
+	}
+	if opts.Reverse {
+		slices.Reverse(picked)
+	}
+	if opts.Limit > 0 && len(picked) > opts.Limit {
+		picked = picked[:opts.Limit]
+	}
+	return picked
+}
+
+// HistoryWith is History restricted to the versions opts selects. The
+// records are chosen by their timestamps before any is decompressed, so
+// a small Limit on a long history costs only the versions it yields.
+func (db *DB) HistoryWith(label string, opts HistoryOptions) iter.Seq2[Version, error] {
 	return func(yield func(Version, error) bool) {
-		if err := db.blockRead(); err != nil {
+		// With quarantine, a second pass after moving damaged lines
+		// skips the versions the first already yielded.
+		yielded := map[int64]bool{}
+		err := db.history(label, opts, yielded, yield)
+		if db.quarantineOn(label, err) {
+			err = db.history(label, opts, yielded, yield)
+		}
+		if err != nil {
 			yield(Version{}, err)
-			return
 		}
-		defer func() {
-			db.mu.RUnlock()
-			db.lock.Unlock()
-		}()
+	}
+}
 
-		id := hash(label, db.header.Algorithm)
+// history yields the versions of label opts selects, except those at
+// the offsets in yielded, and adds each it yields; opts.Limit counts
+// those yielded before too. It returns the error that stopped it, or
+// nil if it finished or yield asked it to stop.
+func (db *DB) history(label string, opts HistoryOptions, yielded map[int64]bool, yield func(Version, error) bool) error {
+	if err := db.blockRead(); err != nil {
+		return err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
 
-		sz, err := size(db.reader)
+	db.usage.reads.Add(1)
+	records, offsets, err := db.located(label)
+	if err != nil {
+		return err
+	}
+
+	versions := db.chain(records)
+	limit := opts.Limit
+	opts.Limit = 0
+	for _, i := range opts.choose(records) {
+		if limit > 0 && len(yielded) == limit {
+			return nil
+		}
+		if yielded[offsets[i]] {
+			continue
+		}
+		v, err := versions.version(i)
 		if err != nil {
-			yield(Version{}, fmt.Errorf("history: stat: %w", err))
-			return
+			return err
+		}
+		db.usage.bytesRead.Add(uint64(len(v.Data)))
+		yielded[offsets[i]] = true
+		if !yield(v, nil) {
+			return nil
 		}
+	}
+	return nil
+}
 
-		type versionWithOffset struct {
-			Version
-			offset int64
+// versions collects every version of label in write order. The caller
+// must hold db.mu (read or write).
+func (db *DB) versions(label string) ([]Version, error) {
+	records, err := db.revisions(label)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]Version, len(records))
+	c := db.chain(records)
+	for i := range records {
+		if versions[i], err = c.version(i); err != nil {
+			return nil, err
 		}
-		var versions []versionWithOffset
+	}
+	return versions, nil
+}
+
+// revisions collects the data and history records of label in write
+// order without decompressing them, so callers that need one version
+// pay for one snapshot. The caller must hold db.mu (read or write).
+func (db *DB) revisions(label string) ([]*Record, error) {
+	records, _, err := db.located(label)
+	return records, err
+}
 
-		// Heap: binary search for the ID group, collect all contiguous records.
-		heapResults := group(db.reader, id, HeaderSize, db.heapEnd())
+// located is revisions that also returns each record's offset.
+func (db *DB) located(label string) ([]*Record, []int64, error) {
+	id := db.id(label)
+
+	sz, err := size(db.reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("history: stat: %w", err)
+	}
 
-		// Sparse: linear scan for matching records of any data/history type.
-		for _, t := range []int{TypeRecord, TypeHistory} {
-			sparseResults := sparse(db.reader, id, db.sparseStart(), sz, t)
-			heapResults = append(heapResults, sparseResults...)
+	type recordWithOffset struct {
+		record *Record
+		offset int64
+	}
+	var found []recordWithOffset
+
+	for _, result := range db.lines(id, sz) {
+		record, err := db.decode(result.Data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("history: %w", faultAt(label, result.Offset, err))
+		}
+		if record.Type != TypeRecord && record.Type != TypeHistory {
+			continue
+		}
+		if !db.same(record.Label, label) {
+			continue
 		}
+		found = append(found, recordWithOffset{record, result.Offset})
+	}
 
-		for _, result := range heapResults {
-			record, err := decode(result.Data)
-			if err != nil {
-				yield(Version{}, fmt.Errorf("history: %w", err))
-				return
-			}
-			if record.Type != TypeRecord && record.Type != TypeHistory {
-				continue
-			}
-			if record.Label != label {
-				continue
+	// Sort by file offset, not timestamp. Timestamps can collide (same
+	// millisecond) but file offsets are strictly ordered — the append
+	// position is the ground truth for write order. Do not "fix" this
+	// to sort by timestamp; it would silently reorder concurrent writes.
+	slices.SortFunc(found, func(a, b recordWithOffset) int {
+		return cmp.Compare(a.offset, b.offset)
+	})
+
+	records := make([]*Record, len(found))
+	offsets := make([]int64, len(found))
+	for i, f := range found {
+		records[i], offsets[i] = f.record, f.offset
+	}
+	return records, offsets, nil
+}
+
+// lines returns the data and history lines with id, in no particular
+// order. The caller must hold db.mu (read or write).
+func (db *DB) lines(id string, sz int64) []Result {
+	// Heap: binary search for the ID group, collect all contiguous records.
+	var results []Result
+	if db.header.Flags&flagInsertionOrder != 0 {
+		results = db.insertionGroup(id)
+	} else {
+		results = group(db.reader, id, HeaderSize, db.heapEnd())
+	}
+
+	// Sealed segments: the ID's group in each, among its tags and
+	// tombstones (see segment.go).
+	for _, s := range db.segments() {
+		for _, r := range group(db.reader, id, s.Start, s.Index) {
+			if t := int(r.Data[TypePos] - '0'); t == TypeRecord || t == TypeHistory {
+				results = append(results, r)
 			}
-			content, err := decompress(record.History)
-			if err != nil {
-				yield(Version{}, fmt.Errorf("history: %w", err))
-				return
+		}
+	}
+
+	// Unsealed tail: linear scan for matching records of any data/history type.
+	for _, t := range []int{TypeRecord, TypeHistory} {
+		results = append(results, sparse(db.reader, id, db.unsealed(), sz, t)...)
+	}
+	return results
+}
+
+// GetAt returns the content of label as it was at unix ms time ts: the
+// newest version written at or before ts. Tombstones are not consulted,
+// so a document deleted before ts still reads as its last version.
+// Returns ErrNotFound if no version is that old.
+func (db *DB) GetAt(label string, ts int64) (_ string, err error) {
+	defer db.observe(OpGetAt, label, time.Now(), &err)
+
+	return db.pick(label, func(records []*Record) int {
+		hit := -1
+		for i, r := range records {
+			if r.Timestamp <= ts {
+				hit = i
 			}
-			versions = append(versions, versionWithOffset{
-				Version: Version{string(content), record.Timestamp},
-				offset:  result.Offset,
-			})
-		}
-
-		// Sort by file offset, not timestamp. Timestamps can collide (same
-		// millisecond) but file offsets are strictly ordered — the append
-		// position is the ground truth for write order. Do not "fix" this
-		// to sort by timestamp; it would silently reorder concurrent writes.
-		slices.SortFunc(versions, func(a, b versionWithOffset) int {
-			return cmp.Compare(a.offset, b.offset)
-		})
-
-		for _, v := range versions {
-			if !yield(v.Version, nil) {
-				return
+		}
+		return hit
+	})
+}
+
+// GetVersion returns the nth version of label, counting from 0 for the
+// oldest, in the order History yields them. Returns ErrNotFound if n is
+// out of range.
+func (db *DB) GetVersion(label string, n int) (_ string, err error) {
+	defer db.observe(OpGetVersion, label, time.Now(), &err)
+
+	return db.pick(label, func(records []*Record) int {
+		if n < 0 || n >= len(records) {
+			return -1
+		}
+		return n
+	})
+}
+
+// Revert makes the version of label written at unix ms time ts current
+// again by writing its content as a new version. Nothing is removed: the
+// versions after ts stay in History, followed by the restored one. A
+// deleted document can be reverted, which restores it. If several
+// versions share the millisecond, the latest of them is restored.
+// Returns ErrNotFound if no version has that timestamp.
+func (db *DB) Revert(label string, ts int64) (err error) {
+	defer db.observe(OpRevert, label, time.Now(), &err)
+
+	if err := db.blockWrite(); err != nil {
+		return err
+	}
+
+	err = db.revert(label, ts)
+
+	// Same pattern as Set: check threshold under lock, compact after release.
+	compact := err == nil && db.shouldCompact()
+	events := db.takeEvents()
+	db.mu.Unlock()
+	db.lock.Unlock()
+
+	if err == nil {
+		err = db.durable()
+	}
+	db.after(events)
+	if compact {
+		db.Compact()
+	}
+	return err
+}
+
+// revert performs the restore. The write lock must be held.
+func (db *DB) revert(label string, ts int64) error {
+	records, err := db.revisions(label)
+	if err != nil {
+		return fmt.Errorf("revert: %w", err)
+	}
+	hit := -1
+	for i, r := range records {
+		if r.Timestamp == ts {
+			hit = i
+		}
+	}
+	if hit < 0 {
+		return ErrNotFound
+	}
+	v, err := db.chain(records).version(hit)
+	if err != nil {
+		return fmt.Errorf("revert: %w", err)
+	}
+	return db.setOne(label, v.Data, 0)
+}
+
+// pick returns the content of the version chosen from label's records.
+// choose returns the index of the record, or -1 for none.
+func (db *DB) pick(label string, choose func([]*Record) int) (string, error) {
+	if err := db.blockRead(); err != nil {
+		return "", err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+	db.usage.reads.Add(1)
+
+	records, err := db.revisions(label)
+	if err != nil {
+		return "", err
+	}
+	i := choose(records)
+	if i < 0 {
+		return "", ErrNotFound
+	}
+	v, err := db.chain(records).version(i)
+	if err != nil {
+		return "", err
+	}
+	db.usage.bytesRead.Add(uint64(len(v.Data)))
+	return v.Data, nil
+}
+
+// insertionGroup finds an ID's heap records when the heap is laid out
+// by creation time (CompactOptions.PreserveInsertionOrder) and cannot
+// be binary-searched. The sorted index still points at the current
+// record, which sits at the end of its contiguous group. Documents
+// without a heap index (deleted, or updated since compaction) fall back
+// to a linear scan of the heap.
+func (db *DB) insertionGroup(id string) []Result {
+	if hit := db.scanIndex(id); hit != nil {
+		if idx, err := decodeIndex(hit.Data); err == nil && idx.Offset < db.heapEnd() {
+			if data, err := line(db.reader, idx.Offset); err == nil && len(data) >= MinRecordSize {
+				rec := &Result{idx.Offset, len(data), data, id}
+				return groupAt(db.reader, rec, id, HeaderSize, db.heapEnd())
 			}
 		}
 	}
+	var results []Result
+	for _, t := range []int{TypeRecord, TypeHistory} {
+		results = append(results, sparse(db.reader, id, HeaderSize, db.heapEnd(), t)...)
+	}
+	return results
 }
@@ -0,0 +1,110 @@
+// Write hooks.
+//
+// Config.Hooks lets an application see every document write without
+// wrapping each call that makes one. A write is a set if it gives a label
+// new current content, and a delete if it removes the document:
+//
+//   - Set, Create, Update, SetWithTTL, SetReader, SetAsync, Revert,
+//     Copy, and ImportDir set their labels.
+//   - Delete and DeleteMany delete theirs.
+//   - Rename deletes the old label and sets the new one, unless only the
+//     case changes in a case-insensitive file, which is a set.
+//   - A Batch, Txn, Import, or Transfer runs the hooks for each label it
+//     changes. A Txn or Transfer calls every before hook before writing
+//     anything, so one rejection writes nothing; Import writes document
+//     by document and stops at the first rejection.
+//
+// Tagging, Touch, expiry, and compaction change no document and run no
+// hooks.
+//
+// The before hooks run under the write lock, once the write has been
+// validated and just before anything is written. An error from one
+// rejects the write with ErrRejected wrapping it. Holding the lock, they
+// see exactly the state the write changes, but must not call db.
+//
+// The after hooks run once the call that made the writes has released
+// its locks and, with SyncInterval, waited for their sync, in the order
+// the writes were made. They may call db. A call that fails part way,
+// such as a Batch rejected at its third operation, still runs them for
+// the writes it made.
+package folio
+
+import "fmt"
+
+// Hooks are callbacks around document writes. Any of them may be nil.
+type Hooks struct {
+	BeforeSet    func(label, content string) error
+	AfterSet     func(label, content string)
+	BeforeDelete func(label string) error
+	AfterDelete  func(label string)
+}
+
+// sets reports whether either set hook is installed, so a write that
+// would not otherwise have the content in hand needs to read it.
+func (h Hooks) sets() bool {
+	return h.BeforeSet != nil || h.AfterSet != nil
+}
+
+// hookEvent is a write awaiting its after hook.
+type hookEvent struct {
+	label   string
+	content string
+	deleted bool
+}
+
+// beforeSet runs Hooks.BeforeSet for a set of label. The write lock must
+// be held.
+func (db *DB) beforeSet(label, content string) error {
+	if h := db.config.Hooks.BeforeSet; h != nil {
+		if err := h(label, content); err != nil {
+			return fmt.Errorf("%w: %w", ErrRejected, err)
+		}
+	}
+	return nil
+}
+
+// beforeDelete runs Hooks.BeforeDelete for a delete of label. The write
+// lock must be held.
+func (db *DB) beforeDelete(label string) error {
+	if h := db.config.Hooks.BeforeDelete; h != nil {
+		if err := h(label); err != nil {
+			return fmt.Errorf("%w: %w", ErrRejected, err)
+		}
+	}
+	return nil
+}
+
+// wroteSet queues Hooks.AfterSet for a set that has been written. The
+// write lock must be held.
+func (db *DB) wroteSet(label, content string) {
+	if db.config.Hooks.AfterSet != nil {
+		db.events = append(db.events, hookEvent{label: label, content: content})
+	}
+}
+
+// wroteDelete queues Hooks.AfterDelete for a delete that has been
+// written. The write lock must be held.
+func (db *DB) wroteDelete(label string) {
+	if db.config.Hooks.AfterDelete != nil {
+		db.events = append(db.events, hookEvent{label: label, deleted: true})
+	}
+}
+
+// takeEvents returns the queued writes and clears the queue. Write
+// methods call it before releasing the write lock.
+func (db *DB) takeEvents() []hookEvent {
+	events := db.events
+	db.events = nil
+	return events
+}
+
+// after runs the after hooks for events. No lock may be held.
+func (db *DB) after(events []hookEvent) {
+	for _, e := range events {
+		if e.deleted {
+			db.config.Hooks.AfterDelete(e.label)
+		} else {
+			db.config.Hooks.AfterSet(e.label, e.content)
+		}
+	}
+}
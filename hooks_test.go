@@ -0,0 +1,163 @@
+// Write hook tests.
+//
+// Hooks are only useful for audit logging and cache invalidation if no
+// write path skips them, and only useful for validation if a rejection
+// really writes nothing. These check both, and that after hooks run
+// outside the lock, where they can read the database they observe.
+package folio
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// openHooked opens a database whose hooks log every event to *log,
+// rejecting sets of content "bad" and deletes of labels starting with
+// "keep". AfterSet reads the label back, which would deadlock if it ran
+// under the write lock.
+func openHooked(t *testing.T, log *[]string) *DB {
+	t.Helper()
+	var db *DB
+	errBad := errors.New("bad content")
+	hooks := Hooks{
+		BeforeSet: func(label, content string) error {
+			if content == "bad" {
+				return errBad
+			}
+			*log = append(*log, "before set "+label+"="+content)
+			return nil
+		},
+		AfterSet: func(label, content string) {
+			got, err := db.Get(label)
+			if err != nil || got != content {
+				// A later write in the same call may have replaced it.
+				got = "(" + got + ")"
+			}
+			*log = append(*log, "after set "+label+"="+got)
+		},
+		BeforeDelete: func(label string) error {
+			if strings.HasPrefix(label, "keep") {
+				return errors.New("protected")
+			}
+			*log = append(*log, "before delete "+label)
+			return nil
+		},
+		AfterDelete: func(label string) {
+			*log = append(*log, "after delete "+label)
+		},
+	}
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{Hooks: hooks})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// expect checks the events logged since the last call and clears them.
+func expect(t *testing.T, log *[]string, want ...string) {
+	t.Helper()
+	if !slices.Equal(*log, want) {
+		t.Errorf("events = %q, want %q", *log, want)
+	}
+	*log = nil
+}
+
+// TestHooks verifies the events each kind of write produces: sets,
+// deletes, a rename as a delete and a set, and a transaction's changes
+// in the order it made them.
+func TestHooks(t *testing.T) {
+	var log []string
+	db := openHooked(t, &log)
+
+	db.Set("a", "one")
+	expect(t, &log, "before set a=one", "after set a=one")
+
+	db.Batch(Document{Label: "b", Data: "two"}, Document{Label: "c", Data: "three"})
+	expect(t, &log, "before set b=two", "before set c=three", "after set b=two", "after set c=three")
+
+	db.SetReader("d", strings.NewReader("streamed"))
+	expect(t, &log, "before set d=streamed", "after set d=streamed")
+
+	db.Rename("d", "e")
+	expect(t, &log, "before delete d", "before set e=streamed", "after delete d", "after set e=streamed")
+
+	db.Copy("e", "f")
+	expect(t, &log, "before set f=streamed", "after set f=streamed")
+
+	db.Delete("f")
+	expect(t, &log, "before delete f", "after delete f")
+
+	err := db.Txn(func(tx *Txn) error {
+		tx.Set("a", "new")
+		tx.Delete("b")
+		return tx.Rename("c", "g")
+	})
+	if err != nil {
+		t.Fatalf("Txn: %v", err)
+	}
+	expect(t, &log,
+		"before set a=new", "before delete b", "before delete c", "before set g=three",
+		"after set a=new", "after delete b", "after delete c", "after set g=three")
+
+	// Failed writes and writes of nothing run no hooks.
+	db.Delete("missing")
+	db.Create("a", "exists")
+	db.Txn(func(tx *Txn) error { return nil })
+	expect(t, &log)
+}
+
+// TestHooksReject verifies that a before hook's error fails the write
+// with ErrRejected wrapping it and leaves the document as it was, and
+// that a rejection in a transaction writes none of it.
+func TestHooksReject(t *testing.T) {
+	var log []string
+	db := openHooked(t, &log)
+	db.Set("a", "one")
+	db.Set("keep", "safe")
+	log = nil
+
+	if err := db.Set("a", "bad"); !errors.Is(err, ErrRejected) {
+		t.Errorf("Set(bad) = %v, want ErrRejected", err)
+	}
+	if err := db.Delete("keep"); !errors.Is(err, ErrRejected) || !strings.Contains(err.Error(), "protected") {
+		t.Errorf("Delete(keep) = %v, want ErrRejected wrapping the hook's error", err)
+	}
+	if err := db.Rename("keep", "moved"); !errors.Is(err, ErrRejected) {
+		t.Errorf("Rename(keep) = %v, want ErrRejected", err)
+	}
+	err := db.Txn(func(tx *Txn) error {
+		tx.Set("b", "fine")
+		return tx.Set("c", "bad")
+	})
+	if !errors.Is(err, ErrRejected) {
+		t.Errorf("Txn = %v, want ErrRejected", err)
+	}
+	if err := db.DeleteMany("a", "keep"); !errors.Is(err, ErrRejected) {
+		t.Errorf("DeleteMany = %v, want ErrRejected", err)
+	}
+	expect(t, &log, "before set b=fine", "before delete a")
+
+	if got, _ := db.Get("a"); got != "one" {
+		t.Errorf("Get(a) = %q, want one", got)
+	}
+	if ok, _ := db.Exists("keep"); !ok {
+		t.Error("keep was removed")
+	}
+	for _, lbl := range []string{"b", "moved"} {
+		if ok, _ := db.Exists(lbl); ok {
+			t.Errorf("%s was written", lbl)
+		}
+	}
+
+	// A Batch stops at the rejection, and the writes before it are
+	// reported as made.
+	if err := db.Batch(Document{Label: "x", Data: "ok"}, Document{Label: "y", Data: "bad"}); !errors.Is(err, ErrRejected) {
+		t.Errorf("Batch = %v, want ErrRejected", err)
+	}
+	expect(t, &log, "before set x=ok", "after set x=ok")
+	mustVerify(t, db, VerifyOptions{})
+}
@@ -0,0 +1,303 @@
+// Package httpd serves a folio database over HTTP, so processes not
+// written in Go can share a store with one that is.
+//
+// A Server is an http.Handler; mount it on any mux or serve it directly:
+//
+//	db, _ := folio.Open(path, folio.Config{})
+//	http.ListenAndServe(":8080", httpd.New(db))
+//
+// The API is small and maps one request to one library call:
+//
+//	GET    /docs/{label}            the content, with an ETag
+//	PUT    /docs/{label}            write the request body; 201 if new
+//	DELETE /docs/{label}            delete the document
+//	GET    /docs/?prefix=p          labels, as a JSON array
+//	GET    /history/{label}         versions as [{"ts":ms,"size":n}], oldest first
+//	GET    /history/{label}?at=ms   the content as it was at that time
+//	GET    /search?q=pattern        matches as [{"label":l,"ts":ms,"start":i,"end":j}]
+//
+// Labels may contain slashes: everything after /docs/ is the label.
+//
+// The ETag of a document is the xxHash3 of its content, the same value
+// as DocumentInfo.ContentHash, so it changes exactly when the content
+// does. PUT and DELETE honour If-Match (write only if the document
+// still has one of the given ETags, or exists at all for *) and PUT
+// honours If-None-Match: * (write only if it does not exist), answering
+// 412 Precondition Failed otherwise. The check and the write run in one
+// transaction under the write lock, so two clients updating from the
+// same ETag cannot both succeed. GET honours If-None-Match with 304.
+//
+// Errors are plain text with a status for the folio error behind them:
+// 404 for ErrNotFound, 400 for a bad label, content, or pattern or a
+// write a hook rejected (see folio.Hooks), 405 for a read-only database,
+// 507 for a write past a quota, 503 once it is closed, and 500 for
+// anything else.
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	json "github.com/goccy/go-json"
+	"github.com/jpl-au/folio"
+	"github.com/zeebo/xxh3"
+)
+
+// DefaultMaxBody is the largest request body a Server accepts unless
+// MaxBody says otherwise.
+const DefaultMaxBody = 32 << 20
+
+// errPrecondition reports that a conditional write found the document
+// not in the state its headers asked for.
+var errPrecondition = errors.New("precondition failed")
+
+// Server serves one database.
+type Server struct {
+	db  *folio.DB
+	mux *http.ServeMux
+
+	// MaxBody bounds the bytes read from a PUT body. 0 means
+	// DefaultMaxBody.
+	MaxBody int64
+}
+
+// New returns a Server for db. The caller keeps ownership of db and
+// closes it once the server has stopped.
+func New(db *folio.DB) *Server {
+	s := &Server{db: db, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /docs/{label...}", s.get)
+	s.mux.HandleFunc("PUT /docs/{label...}", s.put)
+	s.mux.HandleFunc("DELETE /docs/{label...}", s.delete)
+	s.mux.HandleFunc("GET /history/{label...}", s.history)
+	s.mux.HandleFunc("GET /search", s.search)
+	return s
+}
+
+// ServeHTTP dispatches a request to the API.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	label := r.PathValue("label")
+	if label == "" {
+		s.list(w, r)
+		return
+	}
+	content, err := s.db.GetBytes(label)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	tag := etag(content)
+	w.Header().Set("ETag", tag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && matches(inm, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Write(content)
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	labels := []string{}
+	for lbl, err := range s.db.ListPrefix(r.URL.Query().Get("prefix")) {
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		labels = append(labels, lbl)
+	}
+	reply(w, labels)
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request) {
+	label := r.PathValue("label")
+	limit := s.MaxBody
+	if limit == 0 {
+		limit = DefaultMaxBody
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created := false
+	err = s.db.Txn(func(tx *folio.Txn) error {
+		current, err := tx.Get(label)
+		exists := err == nil
+		if err != nil && !errors.Is(err, folio.ErrNotFound) {
+			return err
+		}
+		if err := check(r, exists, current); err != nil {
+			return err
+		}
+		created = !exists
+		return tx.Set(label, string(body))
+	})
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(body))
+	if created {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request) {
+	label := r.PathValue("label")
+	err := s.db.Txn(func(tx *folio.Txn) error {
+		current, err := tx.Get(label)
+		if err != nil {
+			return err
+		}
+		if err := check(r, true, current); err != nil {
+			return err
+		}
+		return tx.Delete(label)
+	})
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// version is one entry of a history listing.
+type version struct {
+	TS   int64 `json:"ts"`
+	Size int   `json:"size"`
+}
+
+func (s *Server) history(w http.ResponseWriter, r *http.Request) {
+	label := r.PathValue("label")
+	if at := r.URL.Query().Get("at"); at != "" {
+		ts, err := strconv.ParseInt(at, 10, 64)
+		if err != nil {
+			http.Error(w, "at: not a unix ms time", http.StatusBadRequest)
+			return
+		}
+		content, err := s.db.GetAt(label, ts)
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		w.Header().Set("ETag", etag([]byte(content)))
+		io.WriteString(w, content)
+		return
+	}
+
+	versions := []version{}
+	for v, err := range s.db.History(label) {
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		versions = append(versions, version{v.TS, len(v.Data)})
+	}
+	if len(versions) == 0 {
+		fail(w, folio.ErrNotFound)
+		return
+	}
+	reply(w, versions)
+}
+
+// match is one entry of a search result.
+type match struct {
+	Label string `json:"label"`
+	TS    int64  `json:"ts"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		http.Error(w, "q: missing pattern", http.StatusBadRequest)
+		return
+	}
+	matches := []match{}
+	for m, err := range s.db.Search(q, folio.SearchOptions{}) {
+		if err != nil {
+			fail(w, err)
+			return
+		}
+		matches = append(matches, match{m.Label, m.Timestamp, m.MatchStart, m.MatchEnd})
+	}
+	reply(w, matches)
+}
+
+// check applies the request's If-Match and If-None-Match headers to a
+// document that exists with content current, or does not.
+func check(r *http.Request, exists bool, current string) error {
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !exists || !matches(im, etag([]byte(current))) {
+			return errPrecondition
+		}
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if exists && matches(inm, etag([]byte(current))) {
+			return errPrecondition
+		}
+	}
+	return nil
+}
+
+// etag returns the quoted ETag of content.
+func etag(content []byte) string {
+	return fmt.Sprintf(`"%016x"`, xxh3.Hash(content))
+}
+
+// matches reports whether an If-Match or If-None-Match value names tag.
+// ETags here are strong, so a weak one in the list never matches.
+func matches(header, tag string) bool {
+	for t := range strings.SplitSeq(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// fail writes the status for err.
+func fail(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errPrecondition):
+		status = http.StatusPreconditionFailed
+	case errors.Is(err, folio.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, folio.ErrInvalidLabel), errors.Is(err, folio.ErrLabelTooLong),
+		errors.Is(err, folio.ErrEmptyContent), errors.Is(err, folio.ErrInvalidPattern),
+		errors.Is(err, folio.ErrRejected), errors.Is(err, folio.ErrInvalidContent),
+		errors.Is(err, folio.ErrInvalidPatch), errors.Is(err, folio.ErrNotJSON):
+		status = http.StatusBadRequest
+	case errors.Is(err, folio.ErrQuotaExceeded):
+		status = http.StatusInsufficientStorage
+	case errors.Is(err, folio.ErrReadOnly):
+		status = http.StatusMethodNotAllowed
+	case errors.Is(err, folio.ErrClosed):
+		status = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// reply writes v as JSON.
+func reply(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
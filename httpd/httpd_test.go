@@ -0,0 +1,209 @@
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	json "github.com/goccy/go-json"
+	"github.com/jpl-au/folio"
+)
+
+// client sends requests to a Server over a real listener.
+type client struct {
+	t   *testing.T
+	url string
+}
+
+func newClient(t *testing.T, config folio.Config) (*client, *folio.DB) {
+	t.Helper()
+	db, err := folio.Open(":memory:", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(New(db))
+	t.Cleanup(func() {
+		srv.Close()
+		db.Close()
+	})
+	return &client{t, srv.URL}, db
+}
+
+// do sends a request and returns the status, ETag, and body.
+func (c *client) do(method, path, body string, header ...string) (int, string, string) {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.url+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, resp.Header.Get("ETag"), string(data)
+}
+
+func TestDocs(t *testing.T) {
+	c, _ := newClient(t, folio.Config{})
+
+	if code, _, _ := c.do("GET", "/docs/notes/a", ""); code != http.StatusNotFound {
+		t.Errorf("GET missing = %d, want 404", code)
+	}
+	code, tag1, _ := c.do("PUT", "/docs/notes/a", "first")
+	if code != http.StatusCreated || tag1 == "" {
+		t.Fatalf("PUT new = %d, ETag %q", code, tag1)
+	}
+	code, tag, body := c.do("GET", "/docs/notes/a", "")
+	if code != http.StatusOK || body != "first" || tag != tag1 {
+		t.Errorf("GET = %d %q, ETag %q, want 200 first %q", code, body, tag, tag1)
+	}
+	if code, _, _ := c.do("GET", "/docs/notes/a", "", "If-None-Match", tag1); code != http.StatusNotModified {
+		t.Errorf("GET If-None-Match current = %d, want 304", code)
+	}
+	code, tag2, _ := c.do("PUT", "/docs/notes/a", "second")
+	if code != http.StatusNoContent || tag2 == tag1 {
+		t.Errorf("PUT update = %d, ETag %q", code, tag2)
+	}
+
+	c.do("PUT", "/docs/config", "theme: dark")
+	var labels []string
+	_, _, body = c.do("GET", "/docs/?prefix=notes/", "")
+	if err := json.Unmarshal([]byte(body), &labels); err != nil || len(labels) != 1 || labels[0] != "notes/a" {
+		t.Errorf("list = %s, %v", body, err)
+	}
+
+	if code, _, _ := c.do("DELETE", "/docs/config", ""); code != http.StatusNoContent {
+		t.Errorf("DELETE = %d, want 204", code)
+	}
+	if code, _, _ := c.do("DELETE", "/docs/config", ""); code != http.StatusNotFound {
+		t.Errorf("DELETE again = %d, want 404", code)
+	}
+	if code, _, _ := c.do("PUT", "/docs/a\"b", "x"); code != http.StatusBadRequest {
+		t.Errorf("PUT bad label = %d, want 400", code)
+	}
+	if code, _, _ := c.do("PUT", "/docs/empty", ""); code != http.StatusBadRequest {
+		t.Errorf("PUT empty = %d, want 400", code)
+	}
+}
+
+// TestConditional verifies the compare-and-swap mapping: a write based
+// on a stale ETag fails, and only one of two writers from the same ETag
+// succeeds.
+func TestConditional(t *testing.T) {
+	c, db := newClient(t, folio.Config{})
+
+	if code, _, _ := c.do("PUT", "/docs/a", "one", "If-Match", "*"); code != http.StatusPreconditionFailed {
+		t.Errorf("PUT If-Match * on a missing document = %d, want 412", code)
+	}
+	_, tag, _ := c.do("PUT", "/docs/a", "one", "If-None-Match", "*")
+	if code, _, _ := c.do("PUT", "/docs/a", "again", "If-None-Match", "*"); code != http.StatusPreconditionFailed {
+		t.Errorf("PUT If-None-Match * on an existing document = %d, want 412", code)
+	}
+
+	if code, _, _ := c.do("PUT", "/docs/a", "two", "If-Match", tag); code != http.StatusNoContent {
+		t.Errorf("first PUT from the ETag = %d, want 204", code)
+	}
+	if code, _, _ := c.do("PUT", "/docs/a", "three", "If-Match", tag); code != http.StatusPreconditionFailed {
+		t.Errorf("second PUT from the ETag = %d, want 412", code)
+	}
+	if code, _, _ := c.do("DELETE", "/docs/a", "", "If-Match", tag); code != http.StatusPreconditionFailed {
+		t.Errorf("DELETE from a stale ETag = %d, want 412", code)
+	}
+	if got, _ := db.Get("a"); got != "two" {
+		t.Errorf("content = %q, want two", got)
+	}
+}
+
+func TestHistoryAndSearch(t *testing.T) {
+	c, db := newClient(t, folio.Config{})
+	db.Set("a", "one")
+	time.Sleep(2 * time.Millisecond) // GetAt needs the versions apart
+	db.Set("a", "two words")
+
+	var versions []struct {
+		TS   int64 `json:"ts"`
+		Size int   `json:"size"`
+	}
+	_, _, body := c.do("GET", "/history/a", "")
+	if err := json.Unmarshal([]byte(body), &versions); err != nil || len(versions) != 2 || versions[0].Size != 3 {
+		t.Fatalf("history = %s, %v", body, err)
+	}
+	path := "/history/a?at=" + strconv.FormatInt(versions[0].TS, 10)
+	if code, _, body := c.do("GET", path, ""); code != http.StatusOK || body != "one" {
+		t.Errorf("history at first = %d %q", code, body)
+	}
+	if code, _, _ := c.do("GET", "/history/missing", ""); code != http.StatusNotFound {
+		t.Errorf("history of a missing document = %d, want 404", code)
+	}
+
+	var matches []struct {
+		Label string `json:"label"`
+		Start int    `json:"start"`
+	}
+	_, _, body = c.do("GET", "/search?q=words", "")
+	if err := json.Unmarshal([]byte(body), &matches); err != nil || len(matches) != 1 || matches[0].Label != "a" || matches[0].Start != 4 {
+		t.Errorf("search = %s, %v", body, err)
+	}
+	if code, _, _ := c.do("GET", "/search?q=(", ""); code != http.StatusBadRequest {
+		t.Errorf("search with a bad pattern = %d, want 400", code)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	path := t.TempDir() + "/test.folio"
+	db, err := folio.Open(path, folio.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Set("a", "one")
+	db.Close()
+
+	ro, err := folio.Open(path, folio.Config{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	srv := httptest.NewServer(New(ro))
+	defer srv.Close()
+	c := &client{t, srv.URL}
+	if code, _, body := c.do("GET", "/docs/a", ""); code != http.StatusOK || body != "one" {
+		t.Errorf("GET = %d %q", code, body)
+	}
+	if code, _, _ := c.do("PUT", "/docs/a", "two"); code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT to a read-only database = %d, want 405", code)
+	}
+}
+
+// TestErrorStatus verifies that errors the caller can act on are given
+// their own status rather than 500.
+func TestErrorStatus(t *testing.T) {
+	c, _ := newClient(t, folio.Config{MaxVersionsPerDocument: 1})
+	c.do("PUT", "/docs/a", "one")
+	if code, _, _ := c.do("PUT", "/docs/a", "two"); code != http.StatusInsufficientStorage {
+		t.Errorf("PUT past the version quota = %d, want 507", code)
+	}
+
+	v, _ := newClient(t, folio.Config{Validator: func(string, []byte) error { return errors.New("no") }})
+	if code, _, _ := v.do("PUT", "/docs/a", "one"); code != http.StatusBadRequest {
+		t.Errorf("PUT the validator refuses = %d, want 400", code)
+	}
+
+	for _, err := range []error{folio.ErrInvalidPatch, folio.ErrNotJSON} {
+		w := httptest.NewRecorder()
+		fail(w, fmt.Errorf("patch: %w", err))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%v = %d, want 400", err, w.Code)
+		}
+	}
+}
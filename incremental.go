@@ -0,0 +1,489 @@
+// Online, throttled, and incremental compaction.
+//
+// A rebuild of a multi-gigabyte file reads and writes all of it, and
+// writers used to wait for the whole of it. Now it works a chunk at a
+// time under the read lock and releases the lock between chunks, so
+// writers are held off for one chunk at a time. MaxBytesPerSecond paces
+// the copy so other users of the disk keep some of it. CompactStep
+// spreads the same work over many calls, one chunk each, for a caller
+// that wants to choose when it runs.
+//
+// The first chunk notes the file's size; everything before it is what
+// the chunks sort into the new heap, in the same order a single pass
+// would. Writes carry on between chunks. One that patches a line in place, as
+// retiring a version or erasing an index does, is noted by writeAt, and
+// the last chunk copies each noted line again once every other line has
+// been copied, so the new file holds what the old one holds by then.
+// Lines appended since the first chunk are copied after the new index
+// and tag sections as its sparse region, their index offsets rewritten,
+// where the next compaction sorts them in. The last chunk holds the
+// write lock, briefly when little has been written meanwhile, and swaps
+// the files.
+package folio
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// defaultChunkBytes is CompactOptions.ChunkBytes when it is 0.
+const defaultChunkBytes = 4 << 20
+
+// compaction is the state of a CompactStep compaction in progress.
+type compaction struct {
+	opts  CompactOptions // of the call that began it
+	tmp   storage
+	ow    *offsetWriter
+	start time.Time
+
+	end     int64   // the file's size when it began
+	scanned int64   // the metadata of [HeaderSize, scanned) is in entries
+	entries []Entry // in file order
+
+	planned  bool
+	heap     []Entry // in output order, DstOff set once copied
+	copied   int     // heap entries copied
+	indexMap map[string]*Entry
+	expired  map[string]bool
+	earliest map[string]int64
+	dst      map[int64]int64 // where each copied line went, by source offset
+	progress *progress
+
+	touched []int64 // offsets below end patched in place since it began
+}
+
+// CompactStep does up to opts.ChunkBytes of an incremental compaction
+// and reports whether it has finished; see the package comment. Call it
+// until it returns true, pausing between calls as the load allows. The
+// call that begins a compaction decides what it keeps: PurgeHistory is
+// honoured and the layout is kept, as Compact keeps it. Later calls only
+// take ChunkBytes, MaxBytesPerSecond, and Progress from opts, which may
+// be nil. A Compact, Purge, Repair, or Rehash in the meantime, including
+// auto-compaction, abandons the compaction, and the next call starts
+// again. A file with delta history cannot be compacted incrementally.
+func (db *DB) CompactStep(opts *CompactOptions) (done bool, err error) {
+	defer db.observe(OpCompact, "", time.Now(), &err)
+
+	if opts == nil {
+		opts = &CompactOptions{}
+	}
+	if db.config.ReadOnly {
+		return false, ErrReadOnly
+	}
+	db.maint.Lock()
+	defer db.maint.Unlock()
+	if db.state.Load() == StateClosed {
+		return false, ErrClosed
+	}
+
+	db.mu.RLock()
+	c := db.partial
+	flags := db.header.Flags
+	db.mu.RUnlock()
+	if c == nil {
+		if db.config.DeltaHistory || flags&flagDeltaHistory != 0 {
+			return false, errors.New("compact: delta history cannot be compacted incrementally")
+		}
+		if db.config.DedupContent || flags&flagSharedContent != 0 {
+			return false, errors.New("compact: shared content cannot be compacted incrementally")
+		}
+		o := *opts
+		o.PreserveInsertionOrder = flags&flagInsertionOrder != 0
+		if c, err = db.beginPartial(&o); err != nil {
+			return false, err
+		}
+	}
+	if opts.Progress != nil {
+		c.opts.Progress = opts.Progress
+		if c.progress != nil {
+			c.progress.fn = opts.Progress
+		}
+	}
+	budget := opts.ChunkBytes
+	if budget <= 0 {
+		budget = defaultChunkBytes
+	}
+	done, err = db.advance(c, budget, &throttle{rate: opts.MaxBytesPerSecond, start: time.Now()})
+	if errors.Is(err, errAbandoned) {
+		return false, ErrClosed
+	}
+	return done, err
+}
+
+// errAbandoned reports a compaction in progress dropped by a Close
+// while its step held no lock.
+var errAbandoned = errors.New("compaction abandoned")
+
+// advance does up to budget bytes of c under the read lock and, once
+// every line is copied, finishes it and swaps the files under the write
+// lock. db.maint must be held. On an error other than errAbandoned, c
+// is dropped.
+func (db *DB) advance(c *compaction, budget int64, pace *throttle) (bool, error) {
+	db.mu.RLock()
+	if db.partial != c {
+		db.mu.RUnlock()
+		return false, errAbandoned
+	}
+	err := c.step(db, budget, pace)
+	db.mu.RUnlock()
+	if err != nil || c.copied < len(c.heap) || !c.planned {
+		if err != nil {
+			db.abandon()
+		}
+		return false, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.partial != c {
+		return false, errAbandoned
+	}
+	if n := db.snapshots.Load(); n > 0 && !replaceOpen && db.root != nil {
+		db.dropPartial()
+		return false, fmt.Errorf("compact: %d open snapshots hold the file", n)
+	}
+	before := db.tail
+	tail, err := c.finish(db)
+	if err != nil {
+		db.dropPartial()
+		return false, err
+	}
+	db.partial = nil
+	if err := db.swap(c.tmp, tail); err != nil {
+		return false, err
+	}
+	db.rebuilt(c.start, before, tail, &c.opts)
+	return true, nil
+}
+
+// beginPartial starts a compaction that a rebuild or CompactStep calls
+// advance on, keeping what opts says. db.maint must be held.
+func (db *DB) beginPartial(opts *CompactOptions) (*compaction, error) {
+	tmp, err := db.createTemp()
+	if err != nil {
+		return nil, fmt.Errorf("compact: create temp: %w", err)
+	}
+	if _, err := tmp.WriteAt(make([]byte, HeaderSize), 0); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("compact: write header placeholder: %w", err)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.partial = &compaction{
+		opts:     *opts,
+		tmp:      tmp,
+		ow:       &offsetWriter{w: tmp, off: HeaderSize},
+		start:    time.Now(),
+		end:      db.tail,
+		scanned:  HeaderSize,
+		earliest: map[string]int64{},
+		dst:      map[int64]int64{},
+	}
+	return db.partial, nil
+}
+
+// online is a rebuild that lets writers carry on: the file is copied a
+// chunk at a time under the read lock, released between chunks, and what
+// was written meanwhile is brought across before the swap. A Close in
+// the meantime ends it with ErrClosed. db.maint must be held.
+func (db *DB) online(opts *CompactOptions) error {
+	budget := opts.ChunkBytes
+	if budget <= 0 {
+		budget = defaultChunkBytes
+	}
+	pace := &throttle{rate: opts.MaxBytesPerSecond, start: time.Now()}
+	c, err := db.beginPartial(opts)
+	if err != nil {
+		return err
+	}
+	done := false
+	for !done && err == nil {
+		done, err = db.advance(c, budget, pace)
+	}
+	if errors.Is(err, errAbandoned) {
+		return ErrClosed
+	}
+	return err
+}
+
+// abandon drops the incremental compaction in progress, if any.
+func (db *DB) abandon() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.dropPartial()
+}
+
+// dropPartial is abandon with the write lock held.
+func (db *DB) dropPartial() {
+	c := db.partial
+	if c == nil {
+		return
+	}
+	db.partial = nil
+	c.tmp.Close()
+	if db.root != nil {
+		db.root.Remove(db.name + ".tmp")
+	}
+}
+
+// touch notes an in-place patch at off, from writeAt.
+func (c *compaction) touch(off int64) {
+	if off < c.end {
+		c.touched = append(c.touched, off)
+	}
+}
+
+// step scans and copies up to budget bytes. The read lock must be held.
+func (c *compaction) step(db *DB, budget int64, pace *throttle) error {
+	for c.scanned < c.end && budget > 0 {
+		stop := c.end
+		if c.scanned+budget < c.end {
+			nl, err := align(db.reader, c.scanned+budget)
+			if err != nil {
+				return fmt.Errorf("compact: %w", err)
+			}
+			if nl >= 0 && nl+1 < c.end {
+				stop = nl + 1
+			}
+		}
+		c.entries = append(c.entries, scanm(db.reader, c.scanned, stop, 0)...)
+		budget -= stop - c.scanned
+		pace.wait(stop - c.scanned)
+		c.scanned = stop
+	}
+	if c.scanned < c.end {
+		return nil
+	}
+
+	if !c.planned {
+		exclude := []int{TypeMeta, TypeTxn, TypeTag, TypeTombstone, TypeTable}
+		if c.opts.PurgeHistory {
+			exclude = append(exclude, TypeHistory)
+		}
+		heap, indexes := unpack(c.entries, exclude...)
+		slices.SortFunc(heap, byIDThenTS)
+		heap = retain(heap, db.config.HistoryRetention, now())
+		if c.opts.PreserveInsertionOrder {
+			heap = byInsertion(heap, indexes)
+		}
+		c.heap = heap
+		c.indexMap, c.expired = liveIndexes(indexes, now())
+		var total int64
+		for _, e := range heap {
+			total += int64(e.Length) + 1
+		}
+		c.progress = newProgress(c.opts.Progress, total, progressStep)
+		c.planned = true
+	}
+
+	for ; c.copied < len(c.heap) && budget > 0; c.copied++ {
+		entry := &c.heap[c.copied]
+		c.progress.add(int64(entry.Length) + 1)
+		record, err := line(db.reader, entry.SrcOff)
+		if err != nil {
+			return fmt.Errorf("compact: read record at %d: %w", entry.SrcOff, err)
+		}
+		if !valid(record) {
+			continue // blanked whole since the scan
+		}
+		lbl := label(record)
+		if c.expired[lbl] {
+			continue
+		}
+		entry.Type = int(record[TypePos] - '0') // it may have been retired since the scan
+		entry.DstOff = c.ow.off
+		c.dst[entry.SrcOff] = entry.DstOff
+		if _, err := c.ow.Write(append(record, '\n')); err != nil {
+			return fmt.Errorf("compact: write record: %w", err)
+		}
+		budget -= int64(len(record)) + 1
+		pace.wait(int64(len(record)) + 1)
+
+		if _, ok := c.earliest[lbl]; !ok {
+			c.earliest[lbl] = entry.TS
+		}
+		if entry.Type == TypeRecord {
+			if idx, ok := c.indexMap[lbl]; ok {
+				idx.DstOff = entry.DstOff
+				idx.TS = entry.TS
+			}
+		}
+	}
+	return nil
+}
+
+// finish brings the copied lines up to date, writes the rest of the new
+// file, and returns its size. The write lock must be held.
+func (c *compaction) finish(db *DB) (int64, error) {
+	// The line each patch landed in, by its offset in entries.
+	patched := map[int64]bool{}
+	for _, off := range c.touched {
+		i, found := slices.BinarySearchFunc(c.entries, off, func(e Entry, off int64) int {
+			switch {
+			case off < e.SrcOff:
+				return 1
+			case off >= e.SrcOff+int64(e.Length):
+				return -1
+			}
+			return 0
+		})
+		if found {
+			patched[c.entries[i].SrcOff] = true
+		}
+	}
+
+	// Copied lines patched since, in place: blanking never changes a
+	// line's length.
+	for src := range patched {
+		dst, ok := c.dst[src]
+		if !ok {
+			continue
+		}
+		data, err := line(db.reader, src)
+		if err != nil {
+			return 0, fmt.Errorf("compact: read record at %d: %w", src, err)
+		}
+		if _, err := c.tmp.WriteAt(data, dst); err != nil {
+			return 0, fmt.Errorf("compact: write record: %w", err)
+		}
+	}
+	heapEnd := c.ow.off
+
+	// Patched indexes are read again: an erased one is dropped, and a
+	// renamed or touched one taken as it is now.
+	for _, e := range c.entries {
+		if e.Type != TypeIndex || !patched[e.SrcOff] {
+			continue
+		}
+		if idx, ok := c.indexMap[e.Label]; ok && idx.SrcOff == e.SrcOff {
+			delete(c.indexMap, e.Label)
+		}
+		data, err := line(db.reader, e.SrcOff)
+		if err != nil {
+			return 0, fmt.Errorf("compact: read index at %d: %w", e.SrcOff, err)
+		}
+		idx, err := decodeIndex(data)
+		if err != nil || c.expired[e.Label] {
+			continue // erased, or damaged beyond use
+		}
+		dst, ok := c.dst[idx.Offset]
+		if !ok {
+			continue
+		}
+		lbl := label(data)
+		c.indexMap[lbl] = &Entry{ID: idx.ID, TS: idx.Timestamp, Type: TypeIndex, SrcOff: e.SrcOff, DstOff: dst, Label: lbl, Created: idx.Created, Expires: idx.Expires}
+	}
+	for lbl, idx := range c.indexMap {
+		if idx.DstOff == 0 {
+			delete(c.indexMap, lbl) // its record was not copied
+		}
+	}
+	created, table, err := writeIndexes(c.ow, c.indexMap, c.earliest, db.config.HashTable)
+	if err != nil {
+		return 0, err
+	}
+	indexEnd := c.ow.off
+
+	// Documents written since keep their tags too.
+	appended := scanm(db.reader, c.end, db.tail, TypeIndex)
+	live := maps.Clone(c.indexMap)
+	for i, e := range appended {
+		live[e.Label] = &appended[i]
+		created[e.Label] = e.Created
+	}
+	var tags []Entry
+	for _, e := range c.entries {
+		if e.Type == TypeTag && !patched[e.SrcOff] {
+			tags = append(tags, e)
+		}
+	}
+	kept, err := db.liveTags(tags, live, created, false, db.header.Algorithm)
+	if err != nil {
+		return 0, err
+	}
+	var tombs []tombstone
+	if !c.opts.PurgeHistory {
+		if tombs, err = db.liveTombstones(c.entries, live, false, db.header.Algorithm); err != nil {
+			return 0, err
+		}
+	}
+	metaOff, err := db.writeMeta(c.ow, db.meta, kept, tombs, table)
+	if err != nil {
+		return 0, err
+	}
+
+	// Lines appended since, as the sparse region.
+	scanner := bufio.NewScanner(io.NewSectionReader(db.reader, c.end, db.tail-c.end))
+	scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
+	moved := map[int64]int64{}
+	for at := c.end; scanner.Scan(); {
+		data := scanner.Bytes()
+		src := at
+		at += int64(len(data)) + 1
+		if !valid(data) || len(data) < MinRecordSize {
+			continue // blanked
+		}
+		switch int(data[TypePos] - '0') {
+		case TypeMeta, TypeTxn, TypeTable:
+			continue // written afresh above, or settled
+		case TypeIndex:
+			idx, err := decodeIndex(data)
+			if err != nil {
+				return 0, fmt.Errorf("compact: index at %d: %w", src, err)
+			}
+			off, ok := moved[idx.Offset]
+			if !ok {
+				off, ok = c.dst[idx.Offset]
+			}
+			if !ok {
+				return 0, fmt.Errorf("compact: %w: index at %d points at %d", ErrCorruptIndex, src, idx.Offset)
+			}
+			idx.Offset = off
+			if data, err = json.Marshal(idx); err != nil {
+				return 0, fmt.Errorf("compact: marshal index: %w", err)
+			}
+		}
+		moved[src] = c.ow.off
+		if _, err := c.ow.Write(append(bytes.Clone(data), '\n')); err != nil {
+			return 0, fmt.Errorf("compact: write: %w", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("compact: %w", err)
+	}
+
+	count := len(live)
+	if err := db.writeHeader(c.tmp, heapEnd, indexEnd, metaOff, count, db.header.Algorithm, c.opts.PreserveInsertionOrder, false, false); err != nil {
+		return 0, err
+	}
+	c.progress.finish()
+	return c.ow.off, nil
+}
+
+// throttle paces a copy to rate bytes per second, or not at all if rate
+// is 0 or less.
+type throttle struct {
+	rate  int64
+	start time.Time
+	n     int64
+}
+
+// wait sleeps until n more bytes are within the rate.
+func (t *throttle) wait(n int64) {
+	if t.rate <= 0 {
+		return
+	}
+	t.n += n
+	due := time.Duration(float64(t.n) / float64(t.rate) * float64(time.Second))
+	if ahead := due - time.Since(t.start); ahead > 0 {
+		time.Sleep(ahead)
+	}
+}
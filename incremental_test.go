@@ -0,0 +1,192 @@
+// Incremental and throttled compaction tests.
+//
+// The incremental tests write between steps, the way a live service
+// would, and check that the finished file reads exactly as the old one
+// did by then: contents, versions, tags, and count, before and after a
+// reopen.
+package folio
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// checkDocs fails t unless db holds exactly want, each document with
+// versions[label] versions.
+func checkDocs(t *testing.T, db *DB, want map[string]string, versions map[string]int) {
+	t.Helper()
+	for label, content := range want {
+		if got, err := db.Get(label); err != nil || got != content {
+			t.Errorf("Get(%s) = %q, %v, want %q", label, got, err, content)
+		}
+		if h, err := Collect(db.History(label)); err != nil || len(h) != versions[label] {
+			t.Errorf("History(%s) = %d versions, %v, want %d", label, len(h), err, versions[label])
+		}
+	}
+	if db.Count() != len(want) {
+		t.Errorf("Count = %d, want %d", db.Count(), len(want))
+	}
+	if r := mustVerify(t, db, VerifyOptions{}); !r.OK() {
+		t.Errorf("Verify: %v", r.Problems)
+	}
+}
+
+// TestCompactStep verifies that a compaction spread over many steps,
+// with sets, deletes, tags, and touches between them, leaves a file that
+// holds every change and drops the versions a Compact would have kept.
+func TestCompactStep(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { db.Close() }()
+
+	want := map[string]string{}
+	versions := map[string]int{}
+	set := func(label, content string) {
+		t.Helper()
+		if err := db.Set(label, content); err != nil {
+			t.Fatalf("Set(%s): %v", label, err)
+		}
+		want[label] = content
+		versions[label]++
+	}
+	for i := range 40 {
+		set(fmt.Sprintf("doc-%02d", i), fmt.Sprintf("first %d", i))
+	}
+	for i := range 20 {
+		set(fmt.Sprintf("doc-%02d", i), fmt.Sprintf("second %d", i))
+	}
+	db.Tag("doc-05", "kept")
+	db.Tag("doc-06", "dropped")
+
+	steps := 0
+	for done := false; !done; steps++ {
+		switch steps {
+		case 1:
+			set("doc-01", "third 1") // retires a version the steps have read
+			set("new-a", "appended")
+		case 2:
+			db.Delete("doc-30")
+			delete(want, "doc-30")
+			db.Untag("doc-06", "dropped")
+			db.Tag("doc-07", "late")
+		case 3:
+			db.Touch("doc-10")
+			set("new-a", "appended again")
+			set("doc-39", "second 39")
+		}
+		done, err = db.CompactStep(&CompactOptions{ChunkBytes: 512})
+		if err != nil {
+			t.Fatalf("CompactStep %d: %v", steps, err)
+		}
+	}
+	if steps < 5 {
+		t.Errorf("finished in %d steps, want several", steps)
+	}
+	if db.heapEnd() == 0 {
+		t.Fatal("no sorted heap after the last step")
+	}
+	checkDocs(t, db, want, versions)
+	for tag, label := range map[string]string{"kept": "doc-05", "late": "doc-07"} {
+		if labels, _ := Collect(db.ByTag(tag)); len(labels) != 1 || labels[0] != label {
+			t.Errorf("ByTag(%s) = %v, want %s", tag, labels, label)
+		}
+	}
+	if labels, _ := Collect(db.ByTag("dropped")); len(labels) != 0 {
+		t.Errorf("ByTag(dropped) = %v, want none", labels)
+	}
+
+	// The sparse region carried over is sorted in by the next rebuild.
+	db.Close()
+	if db, err = Open(path, Config{}); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	checkDocs(t, db, want, versions)
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	checkDocs(t, db, want, versions)
+}
+
+// TestCompactStepPurge verifies that the first call's PurgeHistory holds
+// for the whole compaction.
+func TestCompactStepPurge(t *testing.T) {
+	db := openTestDB(t)
+	for _, v := range []string{"one", "two", "three"} {
+		db.Set("doc", v)
+	}
+	db.Set("other", "only")
+	done, err := db.CompactStep(&CompactOptions{PurgeHistory: true, ChunkBytes: 64})
+	for ; !done && err == nil; done, err = db.CompactStep(nil) {
+	}
+	if err != nil {
+		t.Fatalf("CompactStep: %v", err)
+	}
+	checkDocs(t, db, map[string]string{"doc": "three", "other": "only"}, map[string]int{"doc": 1, "other": 1})
+}
+
+// TestCompactStepAbandoned verifies that a Compact between steps drops
+// the compaction in progress, and that the next step starts again.
+func TestCompactStepAbandoned(t *testing.T) {
+	db := openTestDB(t)
+	for i := range 20 {
+		db.Set(fmt.Sprintf("doc-%02d", i), "content")
+	}
+	if done, err := db.CompactStep(&CompactOptions{ChunkBytes: 256}); done || err != nil {
+		t.Fatalf("first step = %v, %v, want unfinished", done, err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if db.partial != nil {
+		t.Fatal("Compact left the incremental compaction in progress")
+	}
+	db.Set("doc-20", "content")
+
+	done, err := false, error(nil)
+	for !done && err == nil {
+		done, err = db.CompactStep(&CompactOptions{ChunkBytes: 256})
+	}
+	if err != nil {
+		t.Fatalf("CompactStep: %v", err)
+	}
+	if db.Count() != 21 || db.tagEnd() != db.tail {
+		t.Errorf("Count = %d, sparse bytes %d, want 21 and none", db.Count(), db.tail-db.tagEnd())
+	}
+}
+
+// TestCompactStepDelta verifies that a file with delta history is refused.
+func TestCompactStepDelta(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{DeltaHistory: true})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	db.Set("doc", "content")
+	if _, err := db.CompactStep(nil); err == nil {
+		t.Error("CompactStep with delta history succeeded")
+	}
+}
+
+// TestCompactThrottle verifies that MaxBytesPerSecond slows the heap
+// copy to about its rate.
+func TestCompactThrottle(t *testing.T) {
+	db := openProgress(t) // about 3 MB of heap
+	heap := db.tail - HeaderSize
+	rate := heap * 5 // a fifth of a second
+
+	start := time.Now()
+	if err := db.Repair(&CompactOptions{MaxBytesPerSecond: rate}); err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Repair took %v, want about 200ms", elapsed)
+	}
+	if got, _ := db.Get("doc-07"); len(got) != 100_000 {
+		t.Errorf("Get after throttled repair = %d bytes", len(got))
+	}
+}
@@ -0,0 +1,199 @@
+// Document metadata from index records alone.
+//
+// Every index record carries the document's last-modified time (_ts)
+// and its creation time (_c), so callers can sort or filter by age
+// without reading data records or decompressing history. Files written
+// before _c existed report Created as 0 until the next Compact, which
+// backfills it from the oldest surviving version.
+//
+// Stat goes further for one document: it also parses the current record
+// and finds the history records, to report the content's size and the
+// number of versions, but decompresses no snapshots. The size is worked
+// out from the length of the stored _d value.
+package folio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"iter"
+	"time"
+)
+
+// DocInfo describes a document without its content.
+type DocInfo struct {
+	Label    string
+	Created  int64 // unix ms of the first write; 0 if not yet recorded
+	Modified int64 // unix ms of the latest write
+	Expires  int64 // unix ms the document expires at; 0 if it does not
+}
+
+// Info returns metadata for a single document, or ErrNotFound.
+func (db *DB) Info(label string) (_ DocInfo, err error) {
+	defer db.observe(OpInfo, label, time.Now(), &err)
+
+	if err := db.blockRead(); err != nil {
+		return DocInfo{}, err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+	db.usage.reads.Add(1)
+
+	sz, err := size(db.reader)
+	if err != nil {
+		return DocInfo{}, fmt.Errorf("info: stat: %w", err)
+	}
+	result, idx, err := db.findIndex(db.id(label), label, sz)
+	if err != nil {
+		return DocInfo{}, fmt.Errorf("info: %w", err)
+	}
+	if result == nil || idx.expired(now()) {
+		return DocInfo{}, ErrNotFound
+	}
+	return DocInfo{Label: idx.Label, Created: idx.Created, Modified: idx.Timestamp, Expires: idx.Expires}, nil
+}
+
+// Region names the part of the file a document's current version is in.
+type Region string
+
+// Regions reported by Stat.
+const (
+	RegionSorted Region = "sorted" // the heap and index written by compaction
+	RegionSparse Region = "sparse" // appended since the last compaction
+)
+
+// StatInfo describes a document and its stored versions.
+type StatInfo struct {
+	Label    string // as stored
+	ID       string // the _id of its records
+	Size     int64  // bytes of current content
+	Versions int    // current version included, as History yields them
+	Created  int64  // unix ms of the first write; 0 if not yet recorded
+	Modified int64  // unix ms of the latest write
+	Region   Region
+}
+
+// Stat returns a document's metadata, content size, and version count
+// without reading its content, or ErrNotFound.
+func (db *DB) Stat(label string) (_ StatInfo, err error) {
+	defer db.observe(OpStat, label, time.Now(), &err)
+
+	if err := db.blockRead(); err != nil {
+		return StatInfo{}, err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+	db.usage.reads.Add(1)
+
+	sz, err := size(db.reader)
+	if err != nil {
+		return StatInfo{}, fmt.Errorf("stat: %w", err)
+	}
+	result, idx, err := db.findIndex(db.id(label), label, sz)
+	if err != nil {
+		return StatInfo{}, fmt.Errorf("stat: %w", err)
+	}
+	if result == nil || idx.expired(now()) {
+		return StatInfo{}, ErrNotFound
+	}
+
+	data, err := line(db.reader, idx.Offset)
+	if err != nil {
+		return StatInfo{}, fmt.Errorf("stat: read record: %w", err)
+	}
+	record, err := parse(data)
+	if err != nil {
+		return StatInfo{}, fmt.Errorf("stat: %w", err)
+	}
+	size := storedSize(record)
+	if record.Shared != 0 {
+		if size, err = db.dataSize(data); err != nil {
+			return StatInfo{}, fmt.Errorf("stat: %w", err)
+		}
+	}
+	records, err := db.revisions(idx.Label)
+	if err != nil {
+		return StatInfo{}, fmt.Errorf("stat: %w", err)
+	}
+
+	region := RegionSorted
+	if result.Offset >= db.sparseStart() {
+		region = RegionSparse
+	}
+	return StatInfo{
+		Label:    idx.Label,
+		ID:       idx.ID,
+		Size:     size,
+		Versions: len(records),
+		Created:  idx.Created,
+		Modified: idx.Timestamp,
+		Region:   region,
+	}, nil
+}
+
+// storedSize returns the length of a parsed data record's content from
+// its _d value, without decoding or decrypting it.
+func storedSize(r *Record) int64 {
+	if !r.Binary && !r.Encrypted {
+		return int64(len(r.Data))
+	}
+	return decodedSize([]byte(r.Data), r.Encrypted)
+}
+
+// ListInfo yields metadata for every current document, in the same
+// order and with the same deduplication as List.
+func (db *DB) ListInfo() iter.Seq2[DocInfo, error] {
+	return func(yield func(DocInfo, error) bool) {
+		db.beginScan()
+		defer db.endScan()
+		if err := db.blockRead(); err != nil {
+			yield(DocInfo{}, err)
+			return
+		}
+		defer func() {
+			db.mu.RUnlock()
+			db.lock.Unlock()
+		}()
+
+		sz, err := size(db.reader)
+		if err != nil {
+			yield(DocInfo{}, fmt.Errorf("listinfo: stat: %w", err))
+			return
+		}
+
+		seen := make(map[string]bool)
+		t := now()
+
+		f := db.borrow()
+		defer db.giveBack(f)
+		section := io.NewSectionReader(f, HeaderSize, sz-HeaderSize)
+		scanner := bufio.NewScanner(section)
+		scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
+
+		for scanner.Scan() {
+			data := scanner.Bytes()
+
+			if valid(data) && len(data) >= MinRecordSize && data[TypePos] == byte('0'+TypeIndex) {
+				idx, err := decodeIndex(data)
+				if err != nil {
+					yield(DocInfo{}, fmt.Errorf("listinfo: %w", err))
+					return
+				}
+				if !seen[idx.Label] && !idx.expired(t) {
+					seen[idx.Label] = true
+					if !yield(DocInfo{Label: idx.Label, Created: idx.Created, Modified: idx.Timestamp, Expires: idx.Expires}, nil) {
+						return
+					}
+				}
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			yield(DocInfo{}, err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Document metadata tests.
+//
+// Info and ListInfo report creation and modification times from index
+// records. The creation time must survive every path that rewrites an
+// index — update, rename, and compaction — or sorting by age would
+// silently reset to "last touched". Stat adds figures read from the
+// data and history records.
+package folio
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestInfoCreatedSurvivesUpdate verifies that Created stays at the first
+// write while Modified advances. If Set stamped Created afresh, every
+// edit would make a document look new.
+func TestInfoCreatedSurvivesUpdate(t *testing.T) {
+	db := openTestDB(t)
+
+	db.Set("doc", "v1")
+	first, err := db.Info("doc")
+	if err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+	if first.Created == 0 || first.Created != first.Modified {
+		t.Fatalf("new doc: Created=%d Modified=%d, want equal and non-zero", first.Created, first.Modified)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	db.Set("doc", "v2")
+	second, _ := db.Info("doc")
+	if second.Created != first.Created {
+		t.Errorf("Created changed on update: %d -> %d", first.Created, second.Created)
+	}
+	if second.Modified <= first.Modified {
+		t.Errorf("Modified did not advance: %d -> %d", first.Modified, second.Modified)
+	}
+}
+
+// TestInfoSurvivesCompactAndRename verifies both timestamps are preserved
+// when compaction rewrites the index and when Rename appends a new one.
+// Compaction previously stamped indexes with the compaction time.
+func TestInfoSurvivesCompactAndRename(t *testing.T) {
+	db := openTestDB(t)
+
+	db.Set("doc", "v1")
+	time.Sleep(2 * time.Millisecond)
+	db.Set("doc", "v2")
+	before, _ := db.Info("doc")
+
+	time.Sleep(2 * time.Millisecond)
+	db.Compact()
+	after, err := db.Info("doc")
+	if err != nil {
+		t.Fatalf("Info after compact: %v", err)
+	}
+	if after != before {
+		t.Errorf("after compact = %+v, want %+v", after, before)
+	}
+
+	db.Rename("doc", "renamed-doc")
+	renamed, err := db.Info("renamed-doc")
+	if err != nil {
+		t.Fatalf("Info after rename: %v", err)
+	}
+	if renamed.Created != before.Created {
+		t.Errorf("Created after rename = %d, want %d", renamed.Created, before.Created)
+	}
+}
+
+// TestInfoBackfillsCreated verifies that compaction fills in Created for
+// indexes written before the field existed, using the oldest version.
+func TestInfoBackfillsCreated(t *testing.T) {
+	db := openTestDB(t)
+
+	// Simulate a legacy file: a record and index with no _c.
+	ts := now() - 1000
+	id := hash("legacy", db.header.Algorithm)
+	db.blockWrite()
+	db.append(&Record{Type: TypeRecord, ID: id, Timestamp: ts, Label: "legacy", Data: "x", History: compress([]byte("x"))},
+		&Index{Type: TypeIndex, ID: id, Timestamp: ts, Label: "legacy"})
+	db.mu.Unlock()
+	db.lock.Unlock()
+
+	info, _ := db.Info("legacy")
+	if info.Created != 0 {
+		t.Fatalf("legacy Created = %d, want 0", info.Created)
+	}
+
+	db.Compact()
+	info, _ = db.Info("legacy")
+	if info.Created != ts {
+		t.Errorf("Created after compact = %d, want %d", info.Created, ts)
+	}
+}
+
+// TestInfoNotFound verifies missing and deleted documents report
+// ErrNotFound rather than a zero DocInfo.
+func TestInfoNotFound(t *testing.T) {
+	db := openTestDB(t)
+
+	if _, err := db.Info("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Info(missing) = %v, want ErrNotFound", err)
+	}
+	db.Set("doc", "x")
+	db.Delete("doc")
+	if _, err := db.Info("doc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Info(deleted) = %v, want ErrNotFound", err)
+	}
+}
+
+// TestListInfo verifies every live document is listed once with its
+// timestamps, across both the sorted and sparse regions.
+func TestListInfo(t *testing.T) {
+	db := openTestDB(t)
+
+	db.Set("a", "1")
+	db.Set("b", "2")
+	db.Compact()
+	db.Set("c", "3")
+	db.Set("a", "1b")
+
+	infos, err := Collect(db.ListInfo())
+	if err != nil {
+		t.Fatalf("ListInfo: %v", err)
+	}
+	if len(infos) != 3 {
+		t.Fatalf("ListInfo: got %d entries, want 3", len(infos))
+	}
+	for _, info := range infos {
+		if info.Created == 0 || info.Modified < info.Created {
+			t.Errorf("%s: Created=%d Modified=%d", info.Label, info.Created, info.Modified)
+		}
+	}
+}
+
+// TestStat verifies the size, version count, and region Stat reports,
+// before and after compaction, for text, binary, and encrypted content.
+// The size is computed from the stored form, so each encoding of _d is
+// checked against the content's real length.
+func TestStat(t *testing.T) {
+	for _, cfg := range []struct {
+		name string
+		key  []byte
+	}{{"plain", nil}, {"encrypted", testKey}} {
+		t.Run(cfg.name, func(t *testing.T) {
+			db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{EncryptionKey: cfg.key})
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer db.Close()
+
+			docs := map[string][]string{
+				"text":   {"v1", `"quoted" and é`},
+				"binary": {"\xff\x00\x01", "\xfe\xff", "\x00"},
+			}
+			for lbl, versions := range docs {
+				for _, v := range versions {
+					db.Set(lbl, v)
+				}
+			}
+
+			for _, region := range []Region{RegionSparse, RegionSorted} {
+				if region == RegionSorted {
+					if err := db.Compact(); err != nil {
+						t.Fatalf("Compact: %v", err)
+					}
+				}
+				for lbl, versions := range docs {
+					st, err := db.Stat(lbl)
+					if err != nil {
+						t.Fatalf("Stat(%s): %v", lbl, err)
+					}
+					info, _ := db.Info(lbl)
+					current := versions[len(versions)-1]
+					if st.Size != int64(len(current)) || st.Versions != len(versions) || st.Region != region {
+						t.Errorf("Stat(%s) = %+v, want Size %d, Versions %d, Region %s", lbl, st, len(current), len(versions), region)
+					}
+					if st.Label != lbl || st.ID != db.id(lbl) || st.Created != info.Created || st.Modified != info.Modified {
+						t.Errorf("Stat(%s) = %+v, disagrees with Info %+v", lbl, st, info)
+					}
+				}
+			}
+
+			db.Delete("text")
+			if _, err := db.Stat("text"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Stat(deleted) = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+// Client side.
+//
+// A Client keeps a pool of connections to the server and takes one per
+// call, dialling another when every open one is busy, so goroutines
+// sharing a Client do not queue behind each other's calls. A connection
+// that fails mid-call is dropped rather than returned to the pool: the
+// call may or may not have run on the server, and its error says so
+// without the Client retrying a write that could land twice.
+package ipc
+
+import (
+	"bufio"
+	"fmt"
+	"iter"
+	"net"
+	"sync"
+	"time"
+
+	json "github.com/goccy/go-json"
+	"github.com/jpl-au/folio"
+)
+
+// maxIdle bounds the connections a Client keeps open between calls.
+const maxIdle = 8
+
+// Client is a connection to a Server, with the document methods of
+// folio.DB. It is safe for concurrent use.
+type Client struct {
+	path string
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// conn is one connection with its buffered ends.
+type conn struct {
+	net.Conn
+	dec *json.Decoder
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// Dial connects to the server listening on the Unix domain socket at
+// path.
+func Dial(path string) (*Client, error) {
+	c := &Client{path: path}
+	cn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	c.idle = append(c.idle, cn)
+	return c, nil
+}
+
+func (c *Client) dial() (*conn, error) {
+	nc, err := net.Dial("unix", c.path)
+	if err != nil {
+		return nil, fmt.Errorf("ipc: %w", err)
+	}
+	w := bufio.NewWriter(nc)
+	return &conn{Conn: nc, dec: json.NewDecoder(bufio.NewReader(nc)), w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// Close closes the Client's connections. Calls made after it return
+// folio.ErrClosed.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, cn := range c.idle {
+		cn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+// call sends req and decodes the result into out, which may be nil for
+// a call that returns only an error.
+func (c *Client) call(req *request, out any) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return folio.ErrClosed
+	}
+	var cn *conn
+	if n := len(c.idle); n > 0 {
+		cn, c.idle = c.idle[n-1], c.idle[:n-1]
+	}
+	c.mu.Unlock()
+	if cn == nil {
+		var err error
+		if cn, err = c.dial(); err != nil {
+			return err
+		}
+	}
+
+	var resp response
+	err := cn.enc.Encode(req)
+	if err == nil {
+		err = cn.w.Flush()
+	}
+	if err == nil {
+		err = cn.dec.Decode(&resp)
+	}
+	if err != nil {
+		cn.Close()
+		return fmt.Errorf("ipc: %s: %w", req.Op, err)
+	}
+
+	c.mu.Lock()
+	if c.closed || len(c.idle) >= maxIdle {
+		cn.Close()
+	} else {
+		c.idle = append(c.idle, cn)
+	}
+	c.mu.Unlock()
+
+	if resp.Err != "" {
+		return remote(&resp)
+	}
+	if out != nil && resp.Value != nil {
+		if err := json.Unmarshal(resp.Value, out); err != nil {
+			return fmt.Errorf("ipc: %s: %w", req.Op, err)
+		}
+	}
+	return nil
+}
+
+// seq yields the elements of the slice a listing call returns, or its
+// error on its own.
+func seq[T any](c *Client, req *request) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var out []T
+		if err := c.call(req, &out); err != nil {
+			var zero T
+			yield(zero, err)
+			return
+		}
+		for _, v := range out {
+			if !yield(v, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Get returns the current content of a document. See folio.DB.Get.
+func (c *Client) Get(label string) (string, error) {
+	var s string
+	err := c.call(&request{Op: "get", Label: label}, &s)
+	return s, err
+}
+
+// GetBytes returns the content of a document written with SetBytes. See
+// folio.DB.GetBytes.
+func (c *Client) GetBytes(label string) ([]byte, error) {
+	var b []byte
+	err := c.call(&request{Op: "getbytes", Label: label}, &b)
+	return b, err
+}
+
+// GetAt returns the content as it was at ts. See folio.DB.GetAt.
+func (c *Client) GetAt(label string, ts int64) (string, error) {
+	var s string
+	err := c.call(&request{Op: "getat", Label: label, TS: ts}, &s)
+	return s, err
+}
+
+// GetVersion returns the nth version of a document. See
+// folio.DB.GetVersion.
+func (c *Client) GetVersion(label string, n int) (string, error) {
+	var s string
+	err := c.call(&request{Op: "getver", Label: label, N: n}, &s)
+	return s, err
+}
+
+// GetMany returns the content of each label that exists. See
+// folio.DB.GetMany.
+func (c *Client) GetMany(labels ...string) (map[string]string, error) {
+	var m map[string]string
+	err := c.call(&request{Op: "getmany", Labels: labels}, &m)
+	return m, err
+}
+
+// Exists reports whether a document exists. See folio.DB.Exists.
+func (c *Client) Exists(label string) (bool, error) {
+	var ok bool
+	err := c.call(&request{Op: "exists", Label: label}, &ok)
+	return ok, err
+}
+
+// Info returns a document's metadata. See folio.DB.Info.
+func (c *Client) Info(label string) (folio.DocInfo, error) {
+	var info folio.DocInfo
+	err := c.call(&request{Op: "info", Label: label}, &info)
+	return info, err
+}
+
+// Set writes a document. See folio.DB.Set.
+func (c *Client) Set(label, content string) error {
+	return c.call(&request{Op: "set", Label: label, Content: content}, nil)
+}
+
+// SetBytes writes binary content. See folio.DB.SetBytes.
+func (c *Client) SetBytes(label string, data []byte) error {
+	return c.call(&request{Op: "setbytes", Label: label, Data: data}, nil)
+}
+
+// SetWithTTL writes a document that expires after ttl. See
+// folio.DB.SetWithTTL.
+func (c *Client) SetWithTTL(label, content string, ttl time.Duration) error {
+	return c.call(&request{Op: "setttl", Label: label, Content: content, TTL: ttl}, nil)
+}
+
+// Create writes a document only if it does not exist. See
+// folio.DB.Create.
+func (c *Client) Create(label, content string) error {
+	return c.call(&request{Op: "create", Label: label, Content: content}, nil)
+}
+
+// Update writes a document only if it exists. See folio.DB.Update.
+func (c *Client) Update(label, content string) error {
+	return c.call(&request{Op: "update", Label: label, Content: content}, nil)
+}
+
+// Delete removes a document. See folio.DB.Delete.
+func (c *Client) Delete(label string) error {
+	return c.call(&request{Op: "delete", Label: label}, nil)
+}
+
+// DeleteMany removes every label or none. See folio.DB.DeleteMany.
+func (c *Client) DeleteMany(labels ...string) error {
+	return c.call(&request{Op: "delmany", Labels: labels}, nil)
+}
+
+// Rename moves a document to a new label. See folio.DB.Rename.
+func (c *Client) Rename(old, new string) error {
+	return c.call(&request{Op: "rename", Label: old, Dst: new}, nil)
+}
+
+// Copy writes src's current content to dst. See folio.DB.Copy.
+func (c *Client) Copy(src, dst string) error {
+	return c.call(&request{Op: "copy", Label: src, Dst: dst}, nil)
+}
+
+// Touch refreshes a document's timestamp. See folio.DB.Touch.
+func (c *Client) Touch(label string) error {
+	return c.call(&request{Op: "touch", Label: label}, nil)
+}
+
+// Revert restores the version written at ts. See folio.DB.Revert.
+func (c *Client) Revert(label string, ts int64) error {
+	return c.call(&request{Op: "revert", Label: label, TS: ts}, nil)
+}
+
+// Tag adds tags to a document. See folio.DB.Tag.
+func (c *Client) Tag(label string, tags ...string) error {
+	return c.call(&request{Op: "tag", Label: label, Tags: tags}, nil)
+}
+
+// Untag removes tags from a document. See folio.DB.Untag.
+func (c *Client) Untag(label string, tags ...string) error {
+	return c.call(&request{Op: "untag", Label: label, Tags: tags}, nil)
+}
+
+// Count returns the number of documents. Unlike folio.DB.Count it can
+// fail, as the count comes from the server.
+func (c *Client) Count() (int, error) {
+	var n int
+	err := c.call(&request{Op: "count"}, &n)
+	return n, err
+}
+
+// Compact compacts the file on the server. See folio.DB.Compact.
+func (c *Client) Compact() error {
+	return c.call(&request{Op: "compact"}, nil)
+}
+
+// List yields the label of every document. See folio.DB.List.
+func (c *Client) List() iter.Seq2[string, error] {
+	return c.ListPrefix("")
+}
+
+// ListPrefix yields the labels under prefix. See folio.DB.ListPrefix.
+func (c *Client) ListPrefix(prefix string) iter.Seq2[string, error] {
+	return seq[string](c, &request{Op: "list", Pattern: prefix})
+}
+
+// Glob yields the labels matching a shell pattern. See folio.DB.Glob.
+func (c *Client) Glob(pattern string) iter.Seq2[string, error] {
+	return seq[string](c, &request{Op: "glob", Pattern: pattern})
+}
+
+// ByTag yields the labels carrying tag. See folio.DB.ByTag.
+func (c *Client) ByTag(tag string) iter.Seq2[string, error] {
+	return seq[string](c, &request{Op: "bytag", Pattern: tag})
+}
+
+// History yields every version of a document, oldest first. See
+// folio.DB.History.
+func (c *Client) History(label string) iter.Seq2[folio.Version, error] {
+	return seq[folio.Version](c, &request{Op: "history", Label: label})
+}
+
+// Search yields the documents whose content matches pattern. See
+// folio.DB.Search.
+func (c *Client) Search(pattern string, opts folio.SearchOptions) iter.Seq2[folio.Match, error] {
+	return seq[folio.Match](c, &request{Op: "search", Pattern: pattern, Search: &opts})
+}
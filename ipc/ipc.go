@@ -0,0 +1,112 @@
+// Package ipc lets several processes share one folio database through a
+// single process that owns the file.
+//
+// Every process that opens a file directly takes the flock for each call
+// and scans the sparse region on its own, so many short-lived processes
+// writing one busy file spend their time waiting on each other and
+// repeating the same work. Instead, one process opens the file and serves
+// it on a Unix domain socket, and the others dial it:
+//
+//	db, _ := folio.Open(path, folio.Config{})
+//	ln, _ := ipc.Listen(path + ".sock")
+//	srv := ipc.New(db)
+//	go srv.Serve(ln)
+//
+//	// in another process
+//	c, _ := ipc.Dial(path + ".sock")
+//	c.Set("notes/a", "hello")
+//	content, _ := c.Get("notes/a")
+//
+// A Client has the methods of a DB that read and write documents, with
+// the same signatures, so code can move between the two with little more
+// than a change of type. Count returns an error as well, since the answer
+// crosses the socket. Errors keep their identity: errors.Is(err,
+// folio.ErrNotFound) holds for a document the server did not find, and
+// likewise for every sentinel in the folio package.
+//
+// The wire format is one JSON object per line each way: a request naming
+// the operation and its arguments, and a response carrying the result or
+// the error. Iterators such as List and History are answered in one
+// response and yielded from memory, as the HTTP API does, so a listing
+// the size of the whole database is held by both sides at once.
+//
+// Transactions, snapshots, and the streaming readers have no remote form;
+// they hold state between calls that would outlive a dropped connection.
+// Create and Update cover the usual need for a conditional write.
+package ipc
+
+import (
+	"errors"
+	"time"
+
+	json "github.com/goccy/go-json"
+	"github.com/jpl-au/folio"
+)
+
+// request is one call, as sent by a Client. Only the fields the
+// operation uses are set.
+type request struct {
+	Op      string               `json:"op"`
+	Label   string               `json:"label,omitempty"`
+	Labels  []string             `json:"labels,omitempty"`
+	Dst     string               `json:"dst,omitempty"` // Rename and Copy
+	Content string               `json:"content,omitempty"`
+	Data    []byte               `json:"data,omitempty"`
+	TTL     time.Duration        `json:"ttl,omitempty"`
+	TS      int64                `json:"ts,omitempty"`
+	N       int                  `json:"n,omitempty"`
+	Pattern string               `json:"pattern,omitempty"` // prefix, glob, tag, or search pattern
+	Tags    []string             `json:"tags,omitempty"`
+	Search  *folio.SearchOptions `json:"search,omitempty"`
+}
+
+// response is the answer to one request: Value holds the result as the
+// JSON of whatever the operation returns, or Err the error message and
+// Kind the message of the folio sentinel it wraps, if any.
+type response struct {
+	Value json.RawMessage `json:"value,omitempty"`
+	Err   string          `json:"err,omitempty"`
+	Kind  string          `json:"kind,omitempty"`
+}
+
+// sentinels are the folio errors a Client gives back as themselves.
+var sentinels = []error{
+	folio.ErrNotFound, folio.ErrExists, folio.ErrLabelTooLong, folio.ErrInvalidLabel,
+	folio.ErrEmptyContent, folio.ErrClosed, folio.ErrReadOnly, folio.ErrInvalidPattern,
+	folio.ErrCorruptHeader, folio.ErrFormatVersion, folio.ErrCorruptRecord, folio.ErrCorruptIndex,
+	folio.ErrDecompress, folio.ErrChecksum, folio.ErrDecrypt, folio.ErrInvalidTTL,
+	folio.ErrInvalidCursor, folio.ErrInvalidTag, folio.ErrInvalidPath, folio.ErrNoIndex,
+	folio.ErrNoDictionary, folio.ErrRejected, folio.ErrLockTimeout, folio.ErrQuotaExceeded,
+	folio.ErrInvalidContent, folio.ErrInvalidPatch, folio.ErrNotJSON,
+}
+
+// kind returns the message of the sentinel err wraps, or "".
+func kind(err error) string {
+	for _, s := range sentinels {
+		if errors.Is(err, s) {
+			return s.Error()
+		}
+	}
+	return ""
+}
+
+// remoteError is an error returned by the server. It reads as the
+// server's message and unwraps to the sentinel it named.
+type remoteError struct {
+	msg  string
+	kind error
+}
+
+func (e *remoteError) Error() string { return e.msg }
+func (e *remoteError) Unwrap() error { return e.kind }
+
+// remote rebuilds the error a response carries.
+func remote(r *response) error {
+	e := &remoteError{msg: r.Err}
+	for _, s := range sentinels {
+		if r.Kind != "" && s.Error() == r.Kind {
+			e.kind = s
+		}
+	}
+	return e
+}
@@ -0,0 +1,292 @@
+package ipc
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jpl-au/folio"
+)
+
+// serve opens a database, serves it on a socket in a temp directory,
+// and returns a client dialled to it.
+func serve(t *testing.T) (*Client, *Server, string) {
+	t.Helper()
+	return serveConfig(t, folio.Config{})
+}
+
+// serveConfig is serve for a database opened with config.
+func serveConfig(t *testing.T, config folio.Config) (*Client, *Server, string) {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := folio.Open(filepath.Join(dir, "test.folio"), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(dir, "test.sock")
+	ln, err := Listen(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(db)
+	go srv.Serve(ln)
+	c, err := Dial(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		srv.Close()
+		db.Close()
+	})
+	return c, srv, sock
+}
+
+// collect drains an iterator, failing t on an error.
+func collect[T any](t *testing.T, seq func(func(T, error) bool)) []T {
+	t.Helper()
+	var out []T
+	for v, err := range seq {
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// sorted sorts labels, which listings yield in file order.
+func sorted(labels []string) []string {
+	slices.Sort(labels)
+	return labels
+}
+
+// TestRoundTrip verifies that writes through a Client read back through
+// it, listings and history included.
+func TestRoundTrip(t *testing.T) {
+	c, _, _ := serve(t)
+
+	if err := c.Set("notes/a", "first"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	c.Set("notes/a", "second")
+	c.Set("notes/b", "other")
+	c.SetBytes("blob", []byte{0, 1, 0xff})
+	c.Tag("notes/b", "todo")
+
+	if got, err := c.Get("notes/a"); err != nil || got != "second" {
+		t.Errorf("Get = %q, %v", got, err)
+	}
+	if got, err := c.GetBytes("blob"); err != nil || string(got) != "\x00\x01\xff" {
+		t.Errorf("GetBytes = %v, %v", got, err)
+	}
+	if ok, err := c.Exists("notes/b"); err != nil || !ok {
+		t.Errorf("Exists = %v, %v", ok, err)
+	}
+	if n, err := c.Count(); err != nil || n != 3 {
+		t.Errorf("Count = %d, %v, want 3", n, err)
+	}
+	if got := sorted(collect(t, c.ListPrefix("notes/"))); !slices.Equal(got, []string{"notes/a", "notes/b"}) {
+		t.Errorf("ListPrefix = %v", got)
+	}
+	if got := collect(t, c.ByTag("todo")); !slices.Equal(got, []string{"notes/b"}) {
+		t.Errorf("ByTag = %v", got)
+	}
+	h := collect(t, c.History("notes/a"))
+	if len(h) != 2 || h[0].Data != "first" || h[1].Data != "second" {
+		t.Fatalf("History = %+v", h)
+	}
+	if got, err := c.GetVersion("notes/a", 0); err != nil || got != "first" {
+		t.Errorf("GetVersion = %q, %v", got, err)
+	}
+	m := collect(t, c.Search("oth", folio.SearchOptions{}))
+	if len(m) != 1 || m[0].Label != "notes/b" {
+		t.Errorf("Search = %+v", m)
+	}
+	if got := collect(t, c.Glob("nothing/*")); len(got) != 0 {
+		t.Errorf("Glob = %v, want none", got)
+	}
+
+	if err := c.Rename("notes/b", "notes/c"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if err := c.Delete("notes/a"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got := sorted(collect(t, c.List())); !slices.Equal(got, []string{"blob", "notes/c"}) {
+		t.Errorf("List = %v", got)
+	}
+}
+
+// TestErrors verifies that an error from the database keeps its
+// identity and message across the socket.
+func TestErrors(t *testing.T) {
+	c, _, _ := serve(t)
+	c.Set("doc", "content")
+
+	_, err := c.Get("missing")
+	if !errors.Is(err, folio.ErrNotFound) {
+		t.Errorf("Get missing = %v, want ErrNotFound", err)
+	}
+	if err := c.Create("doc", "again"); !errors.Is(err, folio.ErrExists) {
+		t.Errorf("Create existing = %v, want ErrExists", err)
+	}
+	if err := c.Set("doc", ""); !errors.Is(err, folio.ErrEmptyContent) {
+		t.Errorf("Set empty = %v, want ErrEmptyContent", err)
+	}
+	for _, err := range c.Search("(", folio.SearchOptions{}) {
+		if !errors.Is(err, folio.ErrInvalidPattern) {
+			t.Errorf("Search bad pattern = %v, want ErrInvalidPattern", err)
+		}
+	}
+	if err := c.call(&request{Op: "nonsense"}, nil); err == nil || errors.Unwrap(err) != nil {
+		t.Errorf("unknown op = %v, want a plain error", err)
+	}
+
+	q, _, _ := serveConfig(t, folio.Config{MaxVersionsPerDocument: 1})
+	q.Set("doc", "v1")
+	if err := q.Set("doc", "v2"); !errors.Is(err, folio.ErrQuotaExceeded) {
+		t.Errorf("Set past the version quota = %v, want ErrQuotaExceeded", err)
+	}
+
+	v, _, _ := serveConfig(t, folio.Config{Validator: func(string, []byte) error { return errors.New("no") }})
+	if err := v.Set("doc", "content"); !errors.Is(err, folio.ErrInvalidContent) {
+		t.Errorf("Set the validator refuses = %v, want ErrInvalidContent", err)
+	}
+}
+
+// TestSentinels verifies that every sentinel declared in the folio
+// package is one a Client gives back as itself, so one added there
+// without being added here is caught.
+func TestSentinels(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "../errors.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := map[string]bool{}
+	for _, s := range sentinels {
+		listed[s.Error()] = true
+	}
+	n := 0
+	ast.Inspect(f, func(node ast.Node) bool {
+		spec, ok := node.(*ast.ValueSpec)
+		if !ok {
+			return true
+		}
+		for i, name := range spec.Names {
+			if i >= len(spec.Values) {
+				break
+			}
+			call, ok := spec.Values[i].(*ast.CallExpr)
+			if !ok || !strings.HasPrefix(name.Name, "Err") || len(call.Args) != 1 {
+				continue
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok {
+				continue
+			}
+			msg, _ := strconv.Unquote(lit.Value)
+			if n++; !listed[msg] {
+				t.Errorf("folio.%s is not in sentinels", name.Name)
+			}
+		}
+		return true
+	})
+	if n != len(sentinels) {
+		t.Errorf("errors.go declares %d sentinels, sentinels lists %d", n, len(sentinels))
+	}
+}
+
+// TestConcurrentClients verifies that many goroutines, over two clients,
+// can write through one server without losing a write.
+func TestConcurrentClients(t *testing.T) {
+	c1, _, sock := serve(t)
+	c2, err := Dial(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := range 40 {
+		c := c1
+		if i%2 == 1 {
+			c = c2
+		}
+		wg.Go(func() {
+			label := fmt.Sprintf("doc-%02d", i)
+			if err := c.Set(label, label); err != nil {
+				errs <- err
+				return
+			}
+			if got, err := c.Get(label); err != nil || got != label {
+				errs <- fmt.Errorf("Get(%s) = %q, %v", label, got, err)
+			}
+		})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if n, _ := c2.Count(); n != 40 {
+		t.Errorf("Count = %d, want 40", n)
+	}
+}
+
+// TestListen verifies that Listen refuses a socket being served and
+// takes over one whose server has gone.
+func TestListen(t *testing.T) {
+	_, srv, sock := serve(t)
+	if _, err := Listen(sock); !errors.Is(err, ErrServing) {
+		t.Fatalf("Listen on a served socket = %v, want ErrServing", err)
+	}
+
+	// A listener closed without unlinking leaves the socket file behind,
+	// as a server that crashed would.
+	srv.Close()
+	ln, err := net.Listen("unix", sock+".stale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	if ln, err = Listen(sock + ".stale"); err != nil {
+		t.Fatalf("Listen on a stale socket: %v", err)
+	}
+	ln.Close()
+}
+
+// TestClose verifies that a closed Client and a closed Server both
+// fail calls instead of hanging.
+func TestClose(t *testing.T) {
+	c, srv, sock := serve(t)
+	c.Set("doc", "content")
+
+	other, err := Dial(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+	if _, err := other.Get("doc"); !errors.Is(err, folio.ErrClosed) {
+		t.Errorf("Get after Client.Close = %v, want ErrClosed", err)
+	}
+
+	srv.Close()
+	if _, err := c.Get("doc"); err == nil {
+		t.Error("Get after Server.Close succeeded")
+	}
+	if _, err := Dial(sock); err == nil {
+		t.Error("Dial after Server.Close succeeded")
+	}
+}
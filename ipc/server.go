@@ -0,0 +1,219 @@
+// Server side.
+//
+// Each connection is served by its own goroutine, one request at a time,
+// so the database sees as many concurrent callers as there are connected
+// clients and its own locks order them as they would order goroutines in
+// one process.
+package ipc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net"
+	"os"
+	"sync"
+
+	json "github.com/goccy/go-json"
+	"github.com/jpl-au/folio"
+)
+
+// ErrServing is returned by Listen when another process is already
+// serving on the socket.
+var ErrServing = errors.New("ipc: socket is already being served")
+
+// Listen listens on the Unix domain socket at path. A socket file left
+// behind by a server that exited without closing is removed first; one
+// that still answers is left alone, and Listen returns ErrServing, so a
+// second daemon on the same path fails instead of taking it over.
+func Listen(path string) (net.Listener, error) {
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return nil, fmt.Errorf("listen %s: %w", path, ErrServing)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("listen %s: %w", path, err)
+	}
+	return net.Listen("unix", path)
+}
+
+// Server serves one database to the clients that connect to it.
+type Server struct {
+	db *folio.DB
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New returns a Server for db. The caller keeps ownership of db and
+// closes it once the server has stopped.
+func New(db *folio.DB) *Server {
+	return &Server{
+		db:        db,
+		listeners: map[net.Listener]struct{}{},
+		conns:     map[net.Conn]struct{}{},
+	}
+}
+
+// Serve accepts connections on ln until Close, and returns nil then, or
+// the error that stopped it accepting. ln is closed on return.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return nil
+	}
+	s.listeners[ln] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, ln)
+		s.mu.Unlock()
+		ln.Close()
+	}()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return fmt.Errorf("ipc: %w", err)
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return nil
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(c)
+	}
+}
+
+// Close stops every Serve, drops the connected clients, and waits for
+// the requests in flight to finish. It does not close the database.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// serveConn answers the requests on one connection until the client
+// hangs up or sends something that is not a request.
+func (s *Server) serveConn(c net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+		s.wg.Done()
+	}()
+
+	dec := json.NewDecoder(bufio.NewReader(c))
+	w := bufio.NewWriter(c)
+	enc := json.NewEncoder(w)
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			if !errors.Is(err, io.EOF) {
+				enc.Encode(response{Err: fmt.Sprintf("ipc: bad request: %v", err)})
+				w.Flush()
+			}
+			return
+		}
+		if enc.Encode(s.handle(&req)) != nil || w.Flush() != nil {
+			return
+		}
+	}
+}
+
+// handle runs one request against the database.
+func (s *Server) handle(req *request) response {
+	op, ok := ops[req.Op]
+	if !ok {
+		return response{Err: fmt.Sprintf("ipc: unknown operation %q", req.Op)}
+	}
+	v, err := op(s.db, req)
+	if err != nil {
+		return response{Err: err.Error(), Kind: kind(err)}
+	}
+	if v == nil {
+		return response{}
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return response{Err: fmt.Sprintf("ipc: %v", err)}
+	}
+	return response{Value: data}
+}
+
+// ops maps each operation a Client sends to the DB method that does it.
+// A nil result with a nil error is a call that returns only an error.
+var ops = map[string]func(db *folio.DB, r *request) (any, error){
+	"get":      func(db *folio.DB, r *request) (any, error) { return db.Get(r.Label) },
+	"getbytes": func(db *folio.DB, r *request) (any, error) { return db.GetBytes(r.Label) },
+	"getat":    func(db *folio.DB, r *request) (any, error) { return db.GetAt(r.Label, r.TS) },
+	"getver":   func(db *folio.DB, r *request) (any, error) { return db.GetVersion(r.Label, r.N) },
+	"getmany":  func(db *folio.DB, r *request) (any, error) { return db.GetMany(r.Labels...) },
+	"exists":   func(db *folio.DB, r *request) (any, error) { return db.Exists(r.Label) },
+	"info":     func(db *folio.DB, r *request) (any, error) { return db.Info(r.Label) },
+	"set":      func(db *folio.DB, r *request) (any, error) { return nil, db.Set(r.Label, r.Content) },
+	"setbytes": func(db *folio.DB, r *request) (any, error) { return nil, db.SetBytes(r.Label, r.Data) },
+	"setttl":   func(db *folio.DB, r *request) (any, error) { return nil, db.SetWithTTL(r.Label, r.Content, r.TTL) },
+	"create":   func(db *folio.DB, r *request) (any, error) { return nil, db.Create(r.Label, r.Content) },
+	"update":   func(db *folio.DB, r *request) (any, error) { return nil, db.Update(r.Label, r.Content) },
+	"delete":   func(db *folio.DB, r *request) (any, error) { return nil, db.Delete(r.Label) },
+	"delmany":  func(db *folio.DB, r *request) (any, error) { return nil, db.DeleteMany(r.Labels...) },
+	"rename":   func(db *folio.DB, r *request) (any, error) { return nil, db.Rename(r.Label, r.Dst) },
+	"copy":     func(db *folio.DB, r *request) (any, error) { return nil, db.Copy(r.Label, r.Dst) },
+	"touch":    func(db *folio.DB, r *request) (any, error) { return nil, db.Touch(r.Label) },
+	"revert":   func(db *folio.DB, r *request) (any, error) { return nil, db.Revert(r.Label, r.TS) },
+	"tag":      func(db *folio.DB, r *request) (any, error) { return nil, db.Tag(r.Label, r.Tags...) },
+	"untag":    func(db *folio.DB, r *request) (any, error) { return nil, db.Untag(r.Label, r.Tags...) },
+	"count":    func(db *folio.DB, r *request) (any, error) { return db.Count(), nil },
+	"compact":  func(db *folio.DB, r *request) (any, error) { return nil, db.Compact() },
+	"list":     func(db *folio.DB, r *request) (any, error) { return gather(db.ListPrefix(r.Pattern)) },
+	"glob":     func(db *folio.DB, r *request) (any, error) { return gather(db.Glob(r.Pattern)) },
+	"bytag":    func(db *folio.DB, r *request) (any, error) { return gather(db.ByTag(r.Pattern)) },
+	"history":  func(db *folio.DB, r *request) (any, error) { return gather(db.History(r.Label)) },
+	"search": func(db *folio.DB, r *request) (any, error) {
+		var opts folio.SearchOptions
+		if r.Search != nil {
+			opts = *r.Search
+		}
+		return gather(db.Search(r.Pattern, opts))
+	},
+}
+
+// gather collects an iterator into a slice, stopping at its first error.
+// The slice is never nil, so an empty result still crosses the wire.
+func gather[T any](seq iter.Seq2[T, error]) ([]T, error) {
+	out := []T{}
+	for v, err := range seq {
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
@@ -0,0 +1,367 @@
+// Change journal for replication.
+//
+// Every version a document has had is already in the file with the time
+// it was written; what a delete leaves behind, a retired record, says
+// nothing of when. So each delete, and each rename, also appends a
+// tombstone (_r=7) naming the label it removed and, for a rename, the
+// label it moved to:
+//
+//	{"_r":7,"_id":"a1b2c3d4e5f60718","_ts":1706000000000,"_l":"old","_n":"new"}
+//
+// Changes replays the versions and tombstones written after a time, in
+// timestamp order, and Apply writes them into another database, so a
+// replica that remembers the time of the last event it applied can
+// follow a primary by polling, and a copy taken offline can be brought
+// up to date when it reconnects.
+//
+// Compaction gathers tombstones into the sorted tag section with the tag
+// records, where a tag lookup passes over them. A delete tombstone is
+// left out once its label holds a document again, since that document's
+// versions bring a replica to the same state; a Purge drops them all,
+// with the history. A replica further behind than the last Purge must be
+// seeded afresh from a Backup or an Export.
+//
+// Apply lets the newer write win. A Set older than the document it would
+// replace, or a delete or rename of a document written after it, does
+// nothing, so applying the same events twice, or an event the replica
+// already has, is harmless.
+package folio
+
+import (
+	"cmp"
+	"fmt"
+	"iter"
+	"slices"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// TypeTombstone marks a tombstone. Like a tag record it has no data
+// record and no index, and is never returned by document lookups.
+const TypeTombstone = 7
+
+// tombstone records that a label was deleted, or renamed to To.
+type tombstone struct {
+	Type      int    `json:"_r"`
+	ID        string `json:"_id"` // hash of Label
+	Timestamp int64  `json:"_ts"`
+	Label     string `json:"_l"`
+	To        string `json:"_n,omitempty"` // the new label of a rename
+}
+
+// ChangeOp is the kind of a ChangeEvent.
+type ChangeOp int
+
+const (
+	ChangeSet    ChangeOp = iota + 1 // a version of Label was written with Data
+	ChangeDelete                     // Label was deleted
+	ChangeRename                     // Label was renamed to NewLabel
+)
+
+// ChangeEvent is one change as Changes replays it and Apply writes it.
+type ChangeEvent struct {
+	Op       ChangeOp
+	Label    string
+	NewLabel string // ChangeRename only
+	Data     string // ChangeSet only
+	TS       int64  // unix ms of the change
+}
+
+// tombstone appends a tombstone for label, renamed to to if it is set,
+// dated ts. It does not count toward Config.AutoCompact, so a delete
+// still does not. The write lock must be held.
+func (db *DB) tombstone(label, to string, ts int64) error {
+	if err := db.stamp(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(tombstone{Type: TypeTombstone, ID: db.id(label), Timestamp: ts, Label: label, To: to})
+	if err != nil {
+		return err
+	}
+	_, err = db.put(data)
+	return err
+}
+
+// tombstoneVersion is the format version that added tombstones.
+const tombstoneVersion = 3
+
+// stamp raises an older file to the version that added tombstones, in
+// place, before the first is written, so a library that does not know
+// them refuses the file rather than misreading it. It goes no further:
+// the versions after it describe layouts the file does not have. The
+// write lock must be held.
+func (db *DB) stamp() error {
+	if db.header.Version >= tombstoneVersion {
+		return nil
+	}
+	db.markDirty()
+	if _, err := db.writer.WriteAt([]byte{'0' + tombstoneVersion}, versionPos); err != nil {
+		return fmt.Errorf("stamp version: %w", err)
+	}
+	db.header.Version = tombstoneVersion
+	return nil
+}
+
+// Changes yields every change written at or after unix ms time since,
+// oldest first: each version of a document, each delete, and each
+// rename. A replica passes the TS of the last event it applied to pick
+// up where it left off; the events of that millisecond come again, as
+// another may have been written in it since, and Apply skips the ones it
+// has. Versions a rebuild has dropped, by expiry, PurgeHistory, or
+// Config.HistoryRetention, are not replayed, and neither is expiry
+// itself.
+//
+// The events are gathered under the read lock and yielded after it is
+// released, so the loop may write to the same database, but everything
+// since is held in memory at once; seed a new replica from a Backup or
+// an Export and follow it with Changes from then on. A Rename leaves
+// the document's versions under the new label, so they come back as
+// Sets of the new label before the rename, which Apply takes in its
+// stride. Like a scan it counts toward Config.MaxConcurrentScans.
+func (db *DB) Changes(since int64) iter.Seq2[ChangeEvent, error] {
+	return func(yield func(ChangeEvent, error) bool) {
+		events, err := db.changes(since)
+		if err != nil {
+			yield(ChangeEvent{}, err)
+			return
+		}
+		for _, ev := range events {
+			if !yield(ev, nil) {
+				return
+			}
+		}
+	}
+}
+
+// changes collects the events Changes yields.
+func (db *DB) changes(since int64) ([]ChangeEvent, error) {
+	db.beginScan()
+	defer db.endScan()
+	if err := db.blockRead(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+
+	type event struct {
+		ChangeEvent
+		off int64
+	}
+	var events []event
+	labels := map[string]bool{} // stored labels with versions from since on
+	for _, e := range scanm(db.reader, HeaderSize, db.tail, 0) {
+		if e.TS < since {
+			continue
+		}
+		switch e.Type {
+		case TypeRecord, TypeHistory:
+			data, err := line(db.reader, e.SrcOff)
+			if err != nil {
+				return nil, fmt.Errorf("changes: %w", err)
+			}
+			r, err := parse(data)
+			if err != nil {
+				return nil, fmt.Errorf("changes: record at %d: %w", e.SrcOff, err)
+			}
+			labels[r.Label] = true
+		case TypeTombstone:
+			data, err := line(db.reader, e.SrcOff)
+			if err != nil {
+				return nil, fmt.Errorf("changes: %w", err)
+			}
+			var t tombstone
+			if err := json.Unmarshal(data, &t); err != nil {
+				return nil, fmt.Errorf("changes: tombstone at %d: %w", e.SrcOff, ErrCorruptRecord)
+			}
+			ev := ChangeEvent{Op: ChangeDelete, Label: t.Label, TS: t.Timestamp}
+			if t.To != "" {
+				ev.Op, ev.NewLabel = ChangeRename, t.To
+			}
+			events = append(events, event{ev, e.SrcOff})
+		}
+	}
+
+	// Versions come from each label's chain, which resolves deltas.
+	for lbl := range labels {
+		records, offsets, err := db.located(lbl)
+		if err != nil {
+			return nil, fmt.Errorf("changes: %w", err)
+		}
+		versions := db.chain(records)
+		for i, r := range records {
+			if r.Timestamp < since || r.Label != lbl {
+				continue
+			}
+			v, err := versions.version(i)
+			if err != nil {
+				return nil, fmt.Errorf("changes: %w", err)
+			}
+			events = append(events, event{ChangeEvent{Op: ChangeSet, Label: lbl, Data: v.Data, TS: v.TS}, offsets[i]})
+		}
+	}
+
+	slices.SortFunc(events, func(a, b event) int {
+		return cmp.Or(cmp.Compare(a.TS, b.TS), cmp.Compare(a.off, b.off))
+	})
+	out := make([]ChangeEvent, len(events))
+	for i, ev := range events {
+		out[i] = ev.ChangeEvent
+	}
+	return out, nil
+}
+
+// Apply writes one event from another database's Changes. A Set keeps
+// the event's timestamp, so the replica's history, GetAt, and Changes
+// agree with the primary's. Deletes and renames are dated by this
+// database's clock. An event that is older than what this database
+// holds is skipped with a nil error (see the package comment).
+func (db *DB) Apply(ev ChangeEvent) (err error) {
+	defer db.observe(OpApply, ev.Label, time.Now(), &err)
+
+	if ev.Op == ChangeSet {
+		if err := db.checkDoc(ev.Label, ev.Data); err != nil {
+			return err
+		}
+	}
+	// Timestamps sit at fixed byte positions (see Import).
+	if ev.TS < 1e12 || ev.TS >= 1e13 {
+		return fmt.Errorf("apply: invalid timestamp %d", ev.TS)
+	}
+	if ev.Op == ChangeRename {
+		if err := db.checkLabel(ev.NewLabel); err != nil {
+			return err
+		}
+	}
+
+	if err := db.blockWrite(); err != nil {
+		return err
+	}
+
+	err = db.apply(ev)
+
+	// Same pattern as Set: check threshold under lock, compact after release.
+	compact := err == nil && db.shouldCompact()
+	events := db.takeEvents()
+	db.mu.Unlock()
+	db.lock.Unlock()
+
+	if err == nil {
+		err = db.durable()
+	}
+	db.after(events)
+	if compact {
+		db.Compact()
+	}
+	return err
+}
+
+// apply performs Apply. The write lock must be held.
+func (db *DB) apply(ev ChangeEvent) error {
+	sz, err := size(db.reader)
+	if err != nil {
+		return fmt.Errorf("apply: stat: %w", err)
+	}
+	result, idx, err := db.findIndex(db.id(ev.Label), ev.Label, sz)
+	if err != nil {
+		return fmt.Errorf("apply: %w", err)
+	}
+
+	switch ev.Op {
+	case ChangeSet:
+		if result != nil && idx.Timestamp > ev.TS {
+			return nil
+		}
+		// Versions can share a millisecond, so a tie is told apart by
+		// content: only a version this database already holds is skipped.
+		if result != nil && idx.Timestamp == ev.TS {
+			held, err := db.holds(ev.Label, ev.Data, ev.TS)
+			if err != nil || held {
+				return err
+			}
+		}
+		return db.setIf(ev.Label, ev.Data, 0, condAny, ev.TS)
+	case ChangeDelete:
+		if result == nil || idx.Timestamp > ev.TS {
+			return nil
+		}
+		return db.delete(ev.Label)
+	case ChangeRename:
+		if result == nil || idx.Timestamp > ev.TS {
+			return nil
+		}
+		// Where the new label already arrived by a Set, as after a
+		// same-length rename, only the old one is left to remove.
+		if !db.same(ev.Label, ev.NewLabel) {
+			moved, _, err := db.findIndex(db.id(ev.NewLabel), ev.NewLabel, sz)
+			if err != nil {
+				return fmt.Errorf("apply: %w", err)
+			}
+			if moved != nil {
+				return db.delete(ev.Label)
+			}
+		}
+		return db.rename(ev.Label, ev.NewLabel)
+	}
+	return fmt.Errorf("apply: unknown change op %d", ev.Op)
+}
+
+// holds reports whether label has a version of content written at ts.
+// The write lock must be held.
+func (db *DB) holds(label, content string, ts int64) (bool, error) {
+	records, _, err := db.located(label)
+	if err != nil {
+		return false, fmt.Errorf("apply: %w", err)
+	}
+	versions := db.chain(records)
+	for i, r := range records {
+		if r.Timestamp != ts || r.Label != label {
+			continue
+		}
+		v, err := versions.version(i)
+		if err != nil {
+			return false, fmt.Errorf("apply: %w", err)
+		}
+		if v.Data == content {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// liveTombstones returns the tombstones a rebuild keeps, sorted by ID:
+// every one but a delete's whose label holds a document again. salvage
+// skips unreadable ones.
+func (db *DB) liveTombstones(entries []Entry, indexMap map[string]*Entry, salvage bool, alg int) ([]tombstone, error) {
+	var out []tombstone
+	for _, e := range entries {
+		if e.Type != TypeTombstone {
+			continue
+		}
+		data, err := line(db.reader, e.SrcOff)
+		if err != nil {
+			if salvage {
+				continue
+			}
+			return nil, fmt.Errorf("repair: read tombstone at %d: %w", e.SrcOff, err)
+		}
+		var t tombstone
+		if err := json.Unmarshal(data, &t); err != nil {
+			if salvage {
+				continue
+			}
+			return nil, fmt.Errorf("repair: tombstone at %d: %w", e.SrcOff, ErrCorruptRecord)
+		}
+		if _, ok := indexMap[label(data)]; ok && t.To == "" {
+			continue
+		}
+		t.ID = hash(db.fold(t.Label), alg)
+		out = append(out, t)
+	}
+	slices.SortFunc(out, func(a, b tombstone) int {
+		return cmp.Or(cmp.Compare(a.ID, b.ID), cmp.Compare(a.Timestamp, b.Timestamp))
+	})
+	return out, nil
+}
@@ -0,0 +1,237 @@
+// Change journal tests.
+//
+// The guarantees under test are that Changes replays what was written
+// after a time, deletes and renames included, in the order it happened;
+// that applying the events to another database leaves it holding the
+// same documents, however often they are applied; and that compaction
+// keeps the tombstones a replica still needs.
+package folio
+
+import (
+	"maps"
+	"path/filepath"
+	"testing"
+)
+
+// changes collects db.Changes(since), failing the test on error.
+func changes(t *testing.T, db *DB, since int64) []ChangeEvent {
+	t.Helper()
+	events, err := Collect(db.Changes(since))
+	if err != nil {
+		t.Fatalf("Changes: %v", err)
+	}
+	return events
+}
+
+// replicate applies every event to dst, failing the test on error.
+func replicate(t *testing.T, dst *DB, events []ChangeEvent) {
+	t.Helper()
+	for _, ev := range events {
+		if err := dst.Apply(ev); err != nil {
+			t.Fatalf("Apply(%+v): %v", ev, err)
+		}
+	}
+}
+
+// documents returns every document in db by label.
+func documents(t *testing.T, db *DB) map[string]string {
+	t.Helper()
+	labels, err := Collect(db.List())
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	docs := map[string]string{}
+	for _, lbl := range labels {
+		docs[lbl], _ = db.Get(lbl)
+	}
+	return docs
+}
+
+// TestChanges verifies the events for a set, an overwrite, a delete, and
+// a rename, in order, and that since leaves out what came before it. A
+// delete that left no event would never reach a replica. The rename
+// comes with a Set of the new label, as the versions move to it.
+func TestChanges(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("a", "1")
+	db.Set("a", "2")
+	db.Set("b", "x")
+	db.Delete("b")
+	db.Set("c", "y")
+	db.Rename("c", "renamed")
+
+	events := changes(t, db, 0)
+	var ops []ChangeOp
+	for i, ev := range events {
+		ops = append(ops, ev.Op)
+		if i > 0 && ev.TS < events[i-1].TS {
+			t.Errorf("event %d at %d before event %d at %d", i, ev.TS, i-1, events[i-1].TS)
+		}
+	}
+	want := []ChangeOp{ChangeSet, ChangeSet, ChangeSet, ChangeDelete, ChangeSet, ChangeSet, ChangeRename}
+	if len(ops) != len(want) {
+		t.Fatalf("ops = %v, want %v", ops, want)
+	}
+	for i := range want {
+		if ops[i] != want[i] {
+			t.Fatalf("ops = %v, want %v", ops, want)
+		}
+	}
+	if ev := events[1]; ev.Label != "a" || ev.Data != "2" {
+		t.Errorf("second event = %+v, want a set to 2", ev)
+	}
+	if ev := events[3]; ev.Label != "b" {
+		t.Errorf("delete event = %+v, want b", ev)
+	}
+	if ev := events[5]; ev.Label != "renamed" || ev.Data != "y" {
+		t.Errorf("sixth event = %+v, want renamed set to y", ev)
+	}
+	if ev := events[6]; ev.Label != "c" || ev.NewLabel != "renamed" {
+		t.Errorf("rename event = %+v, want c to renamed", ev)
+	}
+
+	last := events[len(events)-1].TS
+	if got := changes(t, db, last+1); len(got) != 0 {
+		t.Errorf("Changes(last+1) = %+v, want none", got)
+	}
+}
+
+// TestApplyConverges verifies that a replica fed a primary's changes,
+// from the start and then from the last event it applied, ends up with
+// the primary's documents, across a same-length rename, a transaction
+// delete, and a delete of a label that is later set again.
+func TestApplyConverges(t *testing.T) {
+	src, dst := openPair(t)
+	src.Set("a", "1")
+	src.Set("b", "2")
+	src.Set("doc", "3")
+	src.Rename("doc", "new") // same length: rewritten in place
+	src.Delete("a")
+	src.Set("a", "again")
+
+	events := changes(t, src, 0)
+	replicate(t, dst, events)
+	if got, want := documents(t, dst), documents(t, src); !maps.Equal(got, want) {
+		t.Fatalf("after first pass: replica = %v, want %v", got, want)
+	}
+
+	since := events[len(events)-1].TS
+	if err := src.Txn(func(tx *Txn) error {
+		tx.Set("c", "txn")
+		return tx.Delete("b")
+	}); err != nil {
+		t.Fatalf("Txn: %v", err)
+	}
+	src.Rename("new", "longer label")
+
+	replicate(t, dst, changes(t, src, since))
+	if got, want := documents(t, dst), documents(t, src); !maps.Equal(got, want) {
+		t.Errorf("after second pass: replica = %v, want %v", got, want)
+	}
+	mustVerify(t, dst, VerifyOptions{})
+}
+
+// TestApplyIdempotent verifies that applying the same events again
+// changes nothing: a replica that lost track of where it was can replay
+// from an earlier time without duplicating versions or undoing deletes.
+func TestApplyIdempotent(t *testing.T) {
+	src, dst := openPair(t)
+	src.Set("a", "1")
+	src.Set("a", "2")
+	src.Set("b", "x")
+	src.Delete("b")
+
+	events := changes(t, src, 0)
+	replicate(t, dst, events)
+	replicate(t, dst, events)
+
+	if got, want := documents(t, dst), documents(t, src); !maps.Equal(got, want) {
+		t.Errorf("replica = %v, want %v", got, want)
+	}
+	if versions, _ := Collect(dst.History("a")); len(versions) != 2 {
+		t.Errorf("History(a) = %d versions, want 2", len(versions))
+	}
+}
+
+// TestApplyOlder verifies that a Set older than the replica's document
+// is skipped, so the newer write wins whichever side made it.
+func TestApplyOlder(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("a", "current")
+	info, _ := db.Info("a")
+
+	if err := db.Apply(ChangeEvent{Op: ChangeSet, Label: "a", Data: "stale", TS: info.Modified - 1000}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if got, _ := db.Get("a"); got != "current" {
+		t.Errorf("Get = %q, want current", got)
+	}
+	if err := db.Apply(ChangeEvent{Op: ChangeSet, Label: "a", Data: "x", TS: 42}); err == nil {
+		t.Error("Apply with a timestamp that is not unix ms succeeded")
+	}
+}
+
+// TestTombstonesSurviveCompact verifies that compaction keeps a deleted
+// label's tombstone, so a replica that catches up afterwards still
+// deletes it, and that Purge drops tombstones along with the history.
+func TestTombstonesSurviveCompact(t *testing.T) {
+	src, dst := openPair(t)
+	src.Set("keep", "1")
+	src.Set("gone", "2")
+	replicate(t, dst, changes(t, src, 0))
+	since := changes(t, src, 0)[1].TS
+
+	src.Delete("gone")
+	if err := src.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	mustVerify(t, src, VerifyOptions{})
+	replicate(t, dst, changes(t, src, since))
+	if ok, _ := dst.Exists("gone"); ok {
+		t.Error("replica still holds a label deleted before the primary compacted")
+	}
+
+	if err := src.Purge(); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	for _, ev := range changes(t, src, 0) {
+		if ev.Op == ChangeDelete {
+			t.Errorf("tombstone survived Purge: %+v", ev)
+		}
+	}
+}
+
+// TestTombstoneStampsVersion verifies that a file of the version before
+// tombstones is stamped with the version that added them by its first
+// delete, and no later one, so an older library refuses it rather than
+// misreading the new record.
+func TestTombstoneStampsVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	db.Set("a", "1")
+	db.Close()
+	writeVersion(t, path, 2)
+
+	db, err = Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	db.Set("b", "2")
+	if v := db.Stats().Version; v != 2 {
+		t.Errorf("version after Set = %d, want 2", v)
+	}
+	db.Delete("a")
+	db.Close()
+
+	f, err := Open(path, Config{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer f.Close()
+	if v := f.Stats().Version; v != tombstoneVersion {
+		t.Errorf("version after Delete = %d, want %d", v, tombstoneVersion)
+	}
+}
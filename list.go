@@ -3,9 +3,13 @@ package folio
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"iter"
+	"slices"
 )
 
 // List yields labels for all current documents. It scans the entire file
@@ -15,6 +19,8 @@ import (
 // results lazily via range and can break early to stop the scan.
 func (db *DB) List() iter.Seq2[string, error] {
 	return func(yield func(string, error) bool) {
+		db.beginScan()
+		defer db.endScan()
 		if err := db.blockRead(); err != nil {
 			yield("", err)
 			return
@@ -31,8 +37,11 @@ func (db *DB) List() iter.Seq2[string, error] {
 		}
 
 		seen := make(map[string]bool)
+		t := now()
 
-		section := io.NewSectionReader(db.reader, HeaderSize, sz-HeaderSize)
+		f := db.borrow()
+		defer db.giveBack(f)
+		section := io.NewSectionReader(f, HeaderSize, sz-HeaderSize)
 		scanner := bufio.NewScanner(section)
 		scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
 
@@ -40,6 +49,9 @@ func (db *DB) List() iter.Seq2[string, error] {
 			data := scanner.Bytes()
 
 			if valid(data) && len(data) >= MinRecordSize && data[TypePos] == byte('0'+TypeIndex) {
+				if ex := expires(data); ex != 0 && ex <= t {
+					continue
+				}
 				lbl := label(data)
 				if !seen[lbl] {
 					seen[lbl] = true
@@ -55,3 +67,289 @@ func (db *DB) List() iter.Seq2[string, error] {
 		}
 	}
 }
+
+// ListPrefix yields the labels of current documents that start with
+// prefix, such as every "config/db/" key of a hierarchical namespace.
+// The index section is sorted by ID, not by label, so prefixes cannot be
+// binary searched; instead the scan visits only the index section and
+// the sparse region, skipping the heap and its document content, and
+// compares the prefix against _l before any decoding. Labels are
+// deduplicated but not sorted.
+func (db *DB) ListPrefix(prefix string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		db.beginScan()
+		defer db.endScan()
+		if err := db.blockRead(); err != nil {
+			yield("", err)
+			return
+		}
+		defer func() {
+			db.mu.RUnlock()
+			db.lock.Unlock()
+		}()
+
+		sz, err := size(db.reader)
+		if err != nil {
+			yield("", fmt.Errorf("listprefix: stat: %w", err))
+			return
+		}
+
+		marker := []byte(`"_l":"` + prefix)
+		seen := make(map[string]bool)
+		t := now()
+
+		// scanRegion scans [start, end) for matching index records.
+		// Returns false if the caller broke out of the range loop.
+		scanRegion := func(start, end int64) bool {
+			if start >= end {
+				return true
+			}
+			f := db.borrow()
+			defer db.giveBack(f)
+			section := io.NewSectionReader(f, start, end-start)
+			scanner := bufio.NewScanner(section)
+			scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
+
+			for scanner.Scan() {
+				data := scanner.Bytes()
+
+				if !valid(data) || len(data) < MinRecordSize || data[TypePos] != byte('0'+TypeIndex) {
+					continue
+				}
+				if !bytes.Contains(data, marker) {
+					continue
+				}
+				if ex := expires(data); ex != 0 && ex <= t {
+					continue
+				}
+				lbl := label(data)
+				if !seen[lbl] {
+					seen[lbl] = true
+					if !yield(lbl, nil) {
+						return false
+					}
+				}
+			}
+
+			if err := scanner.Err(); err != nil {
+				yield("", err)
+				return false
+			}
+			return true
+		}
+
+		if !scanRegion(db.indexStart(), db.indexEnd()) {
+			return
+		}
+		scanRegion(db.sparseStart(), sz)
+	}
+}
+
+// CountPrefix returns the number of current documents whose label
+// starts with prefix; "" counts every document. With Config.LabelTrie it
+// reads the in-memory trie (see trie.go); otherwise it scans as
+// ListPrefix does.
+func (db *DB) CountPrefix(prefix string) (int, error) {
+	if db.labels == nil {
+		n := 0
+		for _, err := range db.ListPrefix(prefix) {
+			if err != nil {
+				return 0, fmt.Errorf("countprefix: %w", err)
+			}
+			n++
+		}
+		return n, nil
+	}
+
+	if err := db.blockRead(); err != nil {
+		return 0, err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+	return db.labels.count(prefix, now()), nil
+}
+
+// ListSorted yields the labels of all current documents in byte order,
+// for exports, diffs and listings that must come out the same each time.
+// The index section is sorted by ID, not by label, so there is no order
+// on disk to walk: with Config.LabelTrie the labels come from the
+// in-memory trie, already in order; otherwise they are gathered as
+// ListPrefix gathers them and sorted before the first is yielded, which
+// holds every label in memory at once.
+func (db *DB) ListSorted() iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		if db.labels == nil {
+			var labels []string
+			for lbl, err := range db.ListPrefix("") {
+				if err != nil {
+					yield("", fmt.Errorf("listsorted: %w", err))
+					return
+				}
+				labels = append(labels, lbl)
+			}
+			slices.Sort(labels)
+			for _, lbl := range labels {
+				if !yield(lbl, nil) {
+					return
+				}
+			}
+			return
+		}
+
+		if err := db.blockRead(); err != nil {
+			yield("", err)
+			return
+		}
+		defer func() {
+			db.mu.RUnlock()
+			db.lock.Unlock()
+		}()
+		db.labels.walk(now(), func(lbl string) bool {
+			return yield(lbl, nil)
+		})
+	}
+}
+
+// ListPage returns up to limit labels following cursor, and the cursor
+// for the next page, or "" once there are no more. Pass "" to start.
+//
+// Pages are ordered by document ID (then label), the order of the
+// sorted index section, so the cursor stays valid across writes and
+// compactions: a page picks up after the last label returned, wherever
+// it now lives. Documents written or deleted between calls appear or
+// disappear according to where their ID falls. Rehash changes every ID
+// and invalidates outstanding cursors.
+//
+// Each call holds the read lock only for its own page. The sorted index
+// section is entered by binary search at the cursor; the sparse region
+// is scanned in full, keeping only the nearest limit+1 candidates, so
+// memory stays proportional to limit.
+func (db *DB) ListPage(cursor string, limit int) ([]string, string, error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("listpage: limit must be positive, got %d", limit)
+	}
+	after, err := parseCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	db.beginScan()
+	defer db.endScan()
+	if err := db.blockRead(); err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+
+	sz, err := size(db.reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("listpage: stat: %w", err)
+	}
+
+	t := now()
+	want := limit + 1 // one extra shows whether another page follows
+	var keys []pageKey
+	take := func(data []byte) {
+		if !valid(data) || len(data) < MinRecordSize || data[TypePos] != byte('0'+TypeIndex) {
+			return
+		}
+		if ex := expires(data); ex != 0 && ex <= t {
+			return
+		}
+		k := pageKey{id: string(data[IDStart:IDEnd]), label: label(data)}
+		if after.less(k) {
+			keys = append(keys, k)
+		}
+	}
+
+	// Sorted section: already in key order from the cursor onward, so
+	// stop as soon as enough candidates have been read.
+	pos, end := db.seekIndex(after.id), db.indexEnd()
+	for pos < end && len(keys) < want {
+		data, err := line(db.reader, pos)
+		if err != nil {
+			return nil, "", fmt.Errorf("listpage: %w", err)
+		}
+		take(data)
+		pos += int64(len(data)) + 1
+	}
+
+	// Sparse region: unordered, so every index line is a candidate.
+	// Trim periodically to bound memory.
+	if start := db.sparseStart(); start < sz {
+		f := db.borrow()
+		defer db.giveBack(f)
+		section := io.NewSectionReader(f, start, sz-start)
+		scanner := bufio.NewScanner(section)
+		scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
+		for scanner.Scan() {
+			take(scanner.Bytes())
+			if len(keys) > 2*want {
+				keys = trimKeys(keys, want)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, "", fmt.Errorf("listpage: %w", err)
+		}
+	}
+	keys = trimKeys(keys, want)
+
+	var next string
+	if len(keys) > limit {
+		keys = keys[:limit]
+		next = keys[limit-1].cursor()
+	}
+	labels := make([]string, len(keys))
+	for i, k := range keys {
+		labels[i] = k.label
+	}
+	return labels, next, nil
+}
+
+// pageKey orders documents for ListPage.
+type pageKey struct {
+	id, label string
+}
+
+// less reports whether k sorts before o. The zero key sorts first.
+func (k pageKey) less(o pageKey) bool {
+	if k.id != o.id {
+		return k.id < o.id
+	}
+	return k.label < o.label
+}
+
+// cursor encodes k as an opaque ListPage token.
+func (k pageKey) cursor() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(k.id + k.label))
+}
+
+// parseCursor decodes a ListPage token. The empty token is the zero key.
+func parseCursor(s string) (pageKey, error) {
+	if s == "" {
+		return pageKey{}, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(raw) <= IDEnd-IDStart {
+		return pageKey{}, ErrInvalidCursor
+	}
+	n := IDEnd - IDStart
+	return pageKey{id: string(raw[:n]), label: string(raw[n:])}, nil
+}
+
+// trimKeys sorts keys, drops duplicates (a label indexed in both regions
+// mid-crash), and keeps the first n.
+func trimKeys(keys []pageKey, n int) []pageKey {
+	slices.SortFunc(keys, func(a, b pageKey) int {
+		return cmp.Or(cmp.Compare(a.id, b.id), cmp.Compare(a.label, b.label))
+	})
+	keys = slices.Compact(keys)
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
@@ -7,11 +7,27 @@
 // Callers use setFile(nil) before closing the underlying file. This blocks
 // until any in-flight flock completes, then makes subsequent Lock/Unlock
 // calls no-ops. After reopening, setFile(f) restores normal operation.
+//
+// With Config.LockTimeout set, a lock is taken by non-blocking attempts
+// instead, retried with a doubling backoff until the timeout passes and
+// ErrLockTimeout is returned, so a process stuck holding the file cannot
+// hang every other one. The mutex is released between attempts, so Close
+// is never held up by a wait.
+//
+// The lock covers the whole file on every platform. On Unix it stays a
+// flock rather than a POSIX byte-range lock: those belong to the process
+// rather than the handle, so two handles in one process would not exclude
+// each other and closing either would drop both; and they do not conflict
+// with flock, so a process on an older folio would not see them at all.
+// Which processes hold it is read from /proc/locks where that exists
+// (see LockOwner).
 package folio
 
 import (
+	"fmt"
 	"os"
 	"sync"
+	"time"
 )
 
 // LockMode selects shared (read) or exclusive (write) locking.
@@ -26,19 +42,43 @@ const (
 // The mu field serialises flock syscalls against setFile so that a
 // concurrent Close cannot invalidate the fd mid-syscall.
 type fileLock struct {
-	mu sync.Mutex
-	f  *os.File
+	mu      sync.Mutex
+	f       *os.File
+	timeout time.Duration // Config.LockTimeout; 0 blocks until the lock is free
 }
 
+// maxBackoff caps the pause between two attempts at a contended lock.
+const maxBackoff = 50 * time.Millisecond
+
 // Lock acquires a shared or exclusive flock. Returns nil immediately
 // if the handle has been cleared via setFile(nil).
 func (l *fileLock) Lock(mode LockMode) error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	if l.f == nil {
-		return nil
+	if l.timeout <= 0 {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.f == nil {
+			return nil
+		}
+		return l.lock(mode)
+	}
+
+	deadline := time.Now().Add(l.timeout)
+	for pause := time.Millisecond; ; pause = min(2*pause, maxBackoff) {
+		l.mu.Lock()
+		ok, err := true, error(nil)
+		if l.f != nil {
+			ok, err = l.tryLock(mode)
+		}
+		l.mu.Unlock()
+		if ok || err != nil {
+			return err
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			return ErrLockTimeout
+		}
+		time.Sleep(min(pause, left))
 	}
-	return l.lock(mode)
 }
 
 // Unlock releases the flock. Returns nil immediately if the handle
@@ -60,3 +100,36 @@ func (l *fileLock) setFile(f *os.File) {
 	l.f = f
 	l.mu.Unlock()
 }
+
+// LockHolder is a process holding, or waiting for, the database file's
+// lock, as LockOwner reports it.
+type LockHolder struct {
+	PID     int
+	Mode    LockMode
+	Waiting bool // queued behind the holders rather than holding it
+}
+
+// LockOwner reports which processes hold the file's lock, and which are
+// waiting for it, for diagnosing a writer that is stuck or timing out
+// with ErrLockTimeout. This process is included when it holds the lock;
+// compare PID with os.Getpid. The lock is normally held only for the
+// duration of a call, so an empty result is the usual answer. It needs
+// /proc/locks and returns errors.ErrUnsupported elsewhere; a memory
+// database has no lock, and no holders.
+func (db *DB) LockOwner() ([]LockHolder, error) {
+	db.lock.mu.Lock()
+	f := db.lock.f
+	db.lock.mu.Unlock()
+	if f == nil {
+		return nil, nil
+	}
+	info, err := os.Stat(f.Name())
+	if err != nil {
+		return nil, fmt.Errorf("lock owner: %w", err)
+	}
+	holders, err := lockHolders(info)
+	if err != nil {
+		return nil, fmt.Errorf("lock owner: %w", err)
+	}
+	return holders, nil
+}
@@ -11,10 +11,15 @@
 // processes) and verify that an exclusive lock blocks a second
 // exclusive lock, and that a shared lock blocks an exclusive lock.
 // The 100ms timeout detects blocking without causing the test to hang.
+// Config.LockTimeout, and LockOwner's report of who holds the lock, are
+// tested the same way.
 package folio
 
 import (
+	"errors"
+	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 )
@@ -133,3 +138,87 @@ func TestReadWriteLocking(t *testing.T) {
 		t.Fatal("db2 stuck")
 	}
 }
+
+// TestLockTimeout verifies that with Config.LockTimeout a call waiting on
+// another handle's lock gives up with ErrLockTimeout, while a call the
+// held lock does not conflict with carries on. Without the timeout, the
+// write below would block until the test itself timed out.
+func TestLockTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db1, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db1.Close()
+	db2, err := Open(path, Config{LockTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Open with LockTimeout: %v", err)
+	}
+	defer db2.Close()
+	if err := db2.Set("doc", "content"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	if err := db1.lock.Lock(LockShared); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db2.Get("doc"); err != nil || got != "content" {
+		t.Errorf("Get under a shared lock = %q, %v", got, err)
+	}
+	start := time.Now()
+	if err := db2.Set("doc", "blocked"); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("Set under another handle's lock = %v, want ErrLockTimeout", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond || d > time.Second {
+		t.Errorf("Set gave up after %v, want about 50ms", d)
+	}
+	db1.lock.Unlock()
+
+	if err := db2.Set("doc", "free"); err != nil {
+		t.Errorf("Set once the lock is free: %v", err)
+	}
+}
+
+// TestLockOwner verifies that LockOwner reports this process while one
+// of its handles holds the lock, in the mode it holds it, and nothing
+// once released.
+func TestLockOwner(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("LockOwner reads /proc/locks")
+	}
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db1, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db1.Close()
+	db2, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db2.Close()
+
+	if err := db1.lock.Lock(LockExclusive); err != nil {
+		t.Fatal(err)
+	}
+	holders, err := db2.LockOwner()
+	db1.lock.Unlock()
+	if err != nil {
+		t.Fatalf("LockOwner: %v", err)
+	}
+	if len(holders) != 1 || holders[0].PID != os.Getpid() || holders[0].Mode != LockExclusive || holders[0].Waiting {
+		t.Errorf("LockOwner = %+v, want this process holding it exclusively", holders)
+	}
+	if holders, err := db2.LockOwner(); err != nil || len(holders) != 0 {
+		t.Errorf("LockOwner after release = %+v, %v, want none", holders, err)
+	}
+
+	mem, err := Open(memoryPath, Config{})
+	if err != nil {
+		t.Fatalf("Open memory: %v", err)
+	}
+	defer mem.Close()
+	if holders, err := mem.LockOwner(); err != nil || holders != nil {
+		t.Errorf("LockOwner of memory database = %v, %v", holders, err)
+	}
+}
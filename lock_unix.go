@@ -1,11 +1,15 @@
 //go:build unix || linux || darwin
 
 // flock(2) implementation for Unix platforms.
-// Both methods are called with l.mu held by the exported Lock/Unlock.
+// All three methods are called with l.mu held by the exported Lock/Unlock.
 package folio
 
 import "syscall"
 
+// replaceOpen reports whether a file can be renamed over while another
+// handle, such as a Snapshot's, holds it open.
+const replaceOpen = true
+
 func (l *fileLock) lock(mode LockMode) error {
 	op := syscall.LOCK_SH
 	if mode == LockExclusive {
@@ -15,6 +19,20 @@ func (l *fileLock) lock(mode LockMode) error {
 	return syscall.Flock(int(l.f.Fd()), op)
 }
 
+// tryLock is lock without waiting: false if another handle holds the
+// lock in a conflicting mode.
+func (l *fileLock) tryLock(mode LockMode) (bool, error) {
+	op := syscall.LOCK_SH
+	if mode == LockExclusive {
+		op = syscall.LOCK_EX
+	}
+	err := syscall.Flock(int(l.f.Fd()), op|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func (l *fileLock) unlock() error {
 	return syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
 }
@@ -1,7 +1,7 @@
 //go:build windows
 
 // LockFileEx/UnlockFileEx implementation for Windows.
-// Both methods are called with l.mu held by the exported Lock/Unlock.
+// The methods are called with l.mu held by the exported Lock/Unlock.
 package folio
 
 import (
@@ -9,6 +9,11 @@ import (
 	"unsafe"
 )
 
+// replaceOpen reports whether a file can be renamed over while another
+// handle, such as a Snapshot's, holds it open. Windows refuses, so
+// compaction fails until open snapshots are closed.
+const replaceOpen = false
+
 var (
 	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
 	procLockFileEx   = modkernel32.NewProc("LockFileEx")
@@ -20,13 +25,36 @@ const (
 	LOCKFILE_FAIL_IMMEDIATELY = 0x00000001
 )
 
+// errLockViolation is ERROR_LOCK_VIOLATION, returned by a
+// LOCKFILE_FAIL_IMMEDIATELY attempt at a lock held elsewhere.
+const errLockViolation = syscall.Errno(33)
+
 func (l *fileLock) lock(mode LockMode) error {
 	var flags uint32 = 0
 	if mode == LockExclusive {
 		flags |= LOCKFILE_EXCLUSIVE_LOCK
 	}
+	return l.lockFile(flags)
+}
+
+// tryLock is lock without waiting: false if another handle holds the
+// lock in a conflicting mode.
+func (l *fileLock) tryLock(mode LockMode) (bool, error) {
+	var flags uint32 = LOCKFILE_FAIL_IMMEDIATELY
+	if mode == LockExclusive {
+		flags |= LOCKFILE_EXCLUSIVE_LOCK
+	}
+	err := l.lockFile(flags)
+	if err == errLockViolation {
+		return false, nil
+	}
+	return err == nil, err
+}
 
-	// Blocking lock over the entire file region (0 to max).
+// lockFile calls LockFileEx with flags over the entire file region.
+func (l *fileLock) lockFile(flags uint32) error {
+	// Lock over the entire file region (0 to max); blocking unless
+	// flags has LOCKFILE_FAIL_IMMEDIATELY.
 	h := syscall.Handle(l.f.Fd())
 	var overlapped syscall.Overlapped
 
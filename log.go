@@ -0,0 +1,89 @@
+// Structured logging.
+//
+// Config.Logger receives the events an operator of a long-running
+// process needs to see and no return value carries: the file opening,
+// crash recovery at Open, every rebuild with how long it took and what
+// it reclaimed, waits for a lock held by a rebuild or another process,
+// and corruption found by a read or by Verify. Routine reads and writes
+// are not logged; MetricsCollector observes those. Every event carries
+// the file's path as "file".
+//
+// Opens, rebuilds, and lock waits log at Info, crash recovery and lock
+// timeouts at Warn, and corruption and failed locks and rebuilds at Error. Auto-compaction and the
+// background compactor discard a failed rebuild's error, so the log is
+// the only place it shows.
+package folio
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// slowLock is the shortest wait for a lock that is logged. A write
+// queued behind another write takes microseconds; one that waits this
+// long is stuck behind a rebuild, a long scan, or another process.
+const slowLock = 100 * time.Millisecond
+
+// newLogger returns the logger a database at path logs to: Config.Logger
+// with the path attached, or one that discards everything.
+func newLogger(l *slog.Logger, path string) *slog.Logger {
+	if l == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return l.With("file", path)
+}
+
+// opened logs a finished Open that started at start.
+func (db *DB) opened(start time.Time) {
+	db.log.Info("opened",
+		"documents", db.Count(),
+		"bytes", db.tail,
+		"read_only", db.config.ReadOnly,
+		"duration", time.Since(start))
+}
+
+// rebuilt logs a rebuild that started at start and shrank the file from
+// before to after bytes.
+func (db *DB) rebuilt(start time.Time, before, after int64, opts *CompactOptions) {
+	db.log.Info("rebuilt",
+		"duration", time.Since(start),
+		"bytes_before", before,
+		"bytes_after", after,
+		"reclaimed", before-after,
+		"documents", db.Count(),
+		"purge", opts.PurgeHistory)
+}
+
+// waited logs a lock acquisition of mode that started at start, if it
+// took long enough to be worth knowing about.
+func (db *DB) waited(mode string, start time.Time) {
+	if d := time.Since(start); d >= slowLock {
+		db.log.Info("lock wait", "mode", mode, "wait", d)
+	}
+}
+
+// lockFailed logs a failure to take the file lock in mode, with the
+// processes holding it when a timeout is to blame and they can be found.
+func (db *DB) lockFailed(mode string, err error) {
+	if !errors.Is(err, ErrLockTimeout) {
+		db.log.Error("lock failed", "mode", mode, "error", err)
+		return
+	}
+	var pids []int
+	if holders, err := db.LockOwner(); err == nil {
+		for _, h := range holders {
+			if !h.Waiting {
+				pids = append(pids, h.PID)
+			}
+		}
+	}
+	db.log.Warn("lock timed out", "mode", mode, "timeout", db.config.LockTimeout, "holders", pids)
+}
+
+// corrupt reports whether err is one of the errors that mean the file's
+// contents are damaged, rather than the call or the caller being wrong.
+func corrupt(err error) bool {
+	return errors.Is(err, ErrCorruptHeader) || errors.Is(err, ErrCorruptRecord) ||
+		errors.Is(err, ErrCorruptIndex) || errors.Is(err, ErrDecompress)
+}
@@ -0,0 +1,172 @@
+// Structured logging tests.
+//
+// Each event is checked through a JSON handler, as an operator would
+// read it: by message and attribute, with the file's path on every one.
+package folio
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// logBuffer collects the JSON lines a logger writes. Lock waits are
+// logged from other goroutines, so writes are serialised.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// events returns the events logged so far with message msg.
+func (b *logBuffer) events(t *testing.T, msg string) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var found []map[string]any
+	for line := range bytes.Lines(b.buf.Bytes()) {
+		var e map[string]any
+		if err := json.Unmarshal(line, &e); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		if e["msg"] == msg {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func newLogBuffer() (*logBuffer, *slog.Logger) {
+	b := &logBuffer{}
+	return b, slog.New(slog.NewJSONHandler(b, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// TestLogOpenAndRebuild verifies that Open and Compact log what they did,
+// a rebuild with the bytes it reclaimed, each event naming the file.
+func TestLogOpenAndRebuild(t *testing.T) {
+	logs, logger := newLogBuffer()
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{Logger: logger})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+
+	opened := logs.events(t, "opened")
+	if len(opened) != 1 || opened[0]["file"] != path || opened[0]["level"] != "INFO" {
+		t.Fatalf("opened events = %v", opened)
+	}
+
+	for i := range 20 {
+		db.Set("doc", fmt.Sprintf("version %d", i))
+	}
+	if err := db.Purge(); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	rebuilt := logs.events(t, "rebuilt")
+	if len(rebuilt) != 1 {
+		t.Fatalf("rebuilt events = %v", rebuilt)
+	}
+	e := rebuilt[0]
+	if e["file"] != path || e["purge"] != true || e["documents"] != 1.0 {
+		t.Errorf("rebuilt event = %v", e)
+	}
+	if before, after := e["bytes_before"].(float64), e["bytes_after"].(float64); e["reclaimed"] != before-after || after >= before {
+		t.Errorf("rebuilt event = %v, want the file to shrink", e)
+	}
+}
+
+// TestLogRecovery verifies that the automatic repair of a crashed file
+// at Open is logged rather than silent.
+func TestLogRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db1, _ := Open(path, Config{})
+	db1.Set("doc", "content")
+	dirty(db1.writer, true)
+	db1.writer.Sync()
+	db1.reader.Close()
+	db1.writer.Close()
+	db1.root.Close()
+
+	logs, logger := newLogBuffer()
+	db2, err := Open(path, Config{Logger: logger})
+	if err != nil {
+		t.Fatalf("Open after crash: %v", err)
+	}
+	defer db2.Close()
+
+	if e := logs.events(t, "recovering from unclean shutdown"); len(e) != 1 || e[0]["level"] != "WARN" || e[0]["dirty"] != true {
+		t.Errorf("recovery events = %v", e)
+	}
+	if e := logs.events(t, "recovered"); len(e) != 1 {
+		t.Errorf("recovered events = %v", e)
+	}
+}
+
+// TestLogCorruption verifies that a read failing on a damaged record is
+// logged as corruption, naming the operation.
+func TestLogCorruption(t *testing.T) {
+	logs, logger := newLogBuffer()
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{Logger: logger})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	db.Set("doc", "content")
+	db.Compact()
+	db.writeAt(db.indexStart()+34, []byte("!!!!"))
+
+	if _, err := db.Get("doc"); err == nil {
+		t.Fatal("Get of corrupt index succeeded")
+	}
+	db.Get("missing") // not found is not corruption
+	e := logs.events(t, "corruption detected")
+	if len(e) != 1 || e[0]["op"] != OpGet || e[0]["level"] != "ERROR" ||
+		e[0]["label"] != "doc" || e[0]["offset"] != float64(db.indexStart()) {
+		t.Errorf("corruption events = %v", e)
+	}
+}
+
+// TestLogLockWait verifies that a read held up behind a long write is
+// logged with how long it waited, and that an uncontended one is not.
+func TestLogLockWait(t *testing.T) {
+	logs, logger := newLogBuffer()
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{Logger: logger})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	db.Set("doc", "content")
+
+	locked := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- db.Txn(func(tx *Txn) error {
+			close(locked)
+			time.Sleep(slowLock + 20*time.Millisecond)
+			return nil
+		})
+	}()
+	<-locked
+	db.Get("doc")
+	if err := <-done; err != nil {
+		t.Fatalf("Txn: %v", err)
+	}
+	db.Get("doc")
+
+	e := logs.events(t, "lock wait")
+	if len(e) != 1 || e[0]["mode"] != "read" {
+		t.Errorf("lock wait events = %v", e)
+	}
+}
@@ -0,0 +1,166 @@
+// LZ4 block compression for CodecLZ4.
+//
+// LZ4 trades ratio for speed: it finds repeats with a single hash of
+// four bytes and writes them as byte-aligned copies, with no entropy
+// coding, so both directions run several times faster than Zstd. Only
+// the block format is needed, and it is small enough to carry here
+// rather than as another dependency.
+//
+// A compressed snapshot is the content's length as four little-endian
+// bytes followed by one LZ4 block, the layout the lz4 Python package
+// reads with store_size. The encoder is greedy and keeps to the block
+// format's end rules (the last five bytes are literals, and no match
+// starts in the last twelve), so any LZ4 block decoder can read it.
+package folio
+
+import "fmt"
+
+const (
+	lz4MinMatch     = 4
+	lz4LastLiterals = 5  // a block ends with at least this many literals
+	lz4MatchLimit   = 12 // no match starts this close to the end
+	lz4HashLog      = 16 // bits of the largest match table
+	lz4MaxOffset    = 1<<16 - 1
+)
+
+// lz4Encode compresses src.
+func lz4Encode(src []byte) []byte {
+	n := len(src)
+	dst := make([]byte, 4, 4+n+n/255+16)
+	dst[0], dst[1], dst[2], dst[3] = byte(n), byte(n>>8), byte(n>>16), byte(n>>24)
+
+	// The position+1 of the last four bytes seen with each hash, sized to
+	// the input so small snapshots do not clear a large table.
+	bits := 8
+	for bits < lz4HashLog && 1<<bits < n {
+		bits++
+	}
+	table := make([]int32, 1<<bits)
+	anchor := 0
+	for i := 0; i < n-lz4MatchLimit; {
+		seq := le32(src[i:])
+		h := seq * 2654435761 >> (32 - bits)
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if ref < 0 || i-ref > lz4MaxOffset || le32(src[ref:]) != seq {
+			i++
+			continue
+		}
+		m := lz4MinMatch
+		for i+m < n-lz4LastLiterals && src[ref+m] == src[i+m] {
+			m++
+		}
+		dst = lz4Sequence(dst, src[anchor:i], i-ref, m)
+		i += m
+		anchor = i
+	}
+	return lz4Sequence(dst, src[anchor:], 0, 0)
+}
+
+// lz4Sequence appends one sequence: literals, then a match of m bytes
+// from off back. The final sequence has literals only, and m 0.
+func lz4Sequence(dst, literals []byte, off, m int) []byte {
+	token := min(len(literals), 15) << 4
+	if m > 0 {
+		token |= min(m-lz4MinMatch, 15)
+	}
+	dst = append(dst, byte(token))
+	if len(literals) >= 15 {
+		dst = lz4Length(dst, len(literals)-15)
+	}
+	dst = append(dst, literals...)
+	if m == 0 {
+		return dst
+	}
+	dst = append(dst, byte(off), byte(off>>8))
+	if m-lz4MinMatch >= 15 {
+		dst = lz4Length(dst, m-lz4MinMatch-15)
+	}
+	return dst
+}
+
+// lz4Length appends the continuation bytes of a length of 15 or more,
+// given the part past 15.
+func lz4Length(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+// lz4Decode reverses lz4Encode, and reads any LZ4 block with its length
+// in front.
+func lz4Decode(src []byte) ([]byte, error) {
+	if len(src) < 4 {
+		return nil, fmt.Errorf("%w: lz4: short block", ErrDecompress)
+	}
+	n := int(le32(src))
+	src = src[4:]
+	if n > 255*len(src)+16 {
+		// More than any block can expand to: do not allocate for it.
+		return nil, fmt.Errorf("%w: lz4: bad length %d", ErrDecompress, n)
+	}
+	dst := make([]byte, 0, n)
+
+	errCorrupt := fmt.Errorf("%w: lz4: corrupt block", ErrDecompress)
+	length := func(i, base int) (int, int, error) {
+		if base < 15 {
+			return base, i, nil
+		}
+		for {
+			if i >= len(src) {
+				return 0, 0, errCorrupt
+			}
+			b := int(src[i])
+			i++
+			base += b
+			if b != 255 {
+				return base, i, nil
+			}
+		}
+	}
+	for i := 0; i < len(src); {
+		token := int(src[i])
+		lit, i2, err := length(i+1, token>>4)
+		if err != nil {
+			return nil, err
+		}
+		i = i2
+		if lit > len(src)-i || len(dst)+lit > n {
+			return nil, errCorrupt
+		}
+		dst = append(dst, src[i:i+lit]...)
+		i += lit
+		if i == len(src) {
+			break // the final sequence has no match
+		}
+
+		if len(src)-i < 2 {
+			return nil, errCorrupt
+		}
+		off := int(src[i]) | int(src[i+1])<<8
+		m, i2, err := length(i+2, token&15)
+		if err != nil {
+			return nil, err
+		}
+		i = i2
+		m += lz4MinMatch
+		if off == 0 || off > len(dst) || len(dst)+m > n {
+			return nil, errCorrupt
+		}
+		// The copy may overlap what it writes, repeating a short run.
+		start := len(dst) - off
+		for k := range m {
+			dst = append(dst, dst[start+k])
+		}
+	}
+	if len(dst) != n {
+		return nil, errCorrupt
+	}
+	return dst, nil
+}
+
+// le32 reads four little-endian bytes.
+func le32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
@@ -0,0 +1,178 @@
+// In-memory databases.
+//
+// Opening the path ":memory:" gives a database that lives in a byte
+// buffer instead of a file. Everything above the storage layer is the
+// same code: the buffer holds the same header, records, and sections a
+// file would, compaction rebuilds it into a fresh buffer, and Export or
+// Backup write it out like any other database. Nothing touches the
+// filesystem and no OS lock is taken, so each Open(":memory:") is a
+// separate, private database that disappears when it is closed. This
+// suits tests and caches that would otherwise need a temporary directory.
+package folio
+
+import (
+	"crypto/cipher"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// memoryPath is the path Open treats as a request for a memory database.
+const memoryPath = ":memory:"
+
+// storage is what the DB needs from the file under it. *os.File and
+// memFile provide it.
+type storage interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	Stat() (fs.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// memFile is a storage held in memory. Its own lock makes ReadAt and
+// WriteAt safe to call concurrently, as they are on a file.
+type memFile struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+func (m *memFile) ReadAt(p []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if off < 0 {
+		return 0, errors.New("memfile: negative offset")
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memFile) WriteAt(p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if off < 0 {
+		return 0, errors.New("memfile: negative offset")
+	}
+	m.grow(off + int64(len(p)))
+	return copy(m.data[off:], p), nil
+}
+
+// Truncate changes the size, zero-filling any growth as a file would.
+func (m *memFile) Truncate(size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if size < int64(len(m.data)) {
+		m.data = m.data[:size]
+		return nil
+	}
+	m.grow(size)
+	return nil
+}
+
+// grow extends data to at least n bytes. The caller holds mu.
+func (m *memFile) grow(n int64) {
+	old := len(m.data)
+	if n <= int64(old) {
+		return
+	}
+	m.data = slices.Grow(m.data, int(n)-old)[:n]
+	clear(m.data[old:])
+}
+
+func (m *memFile) Stat() (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return memInfo(len(m.data)), nil
+}
+
+func (m *memFile) Sync() error  { return nil }
+func (m *memFile) Close() error { return nil }
+
+// memInfo is the fs.FileInfo of a memFile: its size.
+type memInfo int64
+
+func (i memInfo) Name() string       { return memoryPath }
+func (i memInfo) Size() int64        { return int64(i) }
+func (i memInfo) Mode() fs.FileMode  { return 0600 }
+func (i memInfo) ModTime() time.Time { return time.Time{} }
+func (i memInfo) IsDir() bool        { return false }
+func (i memInfo) Sys() any           { return nil }
+
+// openMemory finishes Open for ":memory:". There is nothing to recover,
+// so only the parts of Open that apply to a new file run.
+func openMemory(config Config, aead cipher.AEAD, log *slog.Logger, start time.Time) (*DB, error) {
+	if config.ReadOnly {
+		// Like a file that does not exist: read-only never creates.
+		return nil, &fs.PathError{Op: "open", Path: memoryPath, Err: fs.ErrNotExist}
+	}
+	hdr := &Header{
+		Version:   FormatVersion,
+		Timestamp: now(),
+		Algorithm: config.HashAlgorithm,
+		Codec:     config.Compression.Codec,
+	}
+	if config.CaseInsensitiveLabels {
+		hdr.Flags |= flagFoldLabels
+	}
+	hdr.State[stThreshold] = uint64(config.AutoCompact)
+	buf, err := hdr.encode()
+	if err != nil {
+		return nil, err
+	}
+	f := &memFile{data: buf}
+
+	db := &DB{
+		name:   memoryPath,
+		reader: f,
+		writer: f,
+		lock:   &fileLock{}, // no file handle: Lock and Unlock are no-ops
+		header: hdr,
+		config: config,
+		cipher: aead,
+		log:    log,
+		codec:  newCodec(hdr.Codec, config.Compression, nil),
+		tail:   int64(len(buf)),
+		cond:   sync.NewCond(&sync.Mutex{}),
+	}
+	if config.MaxConcurrentScans > 0 {
+		db.scans = make(chan struct{}, config.MaxConcurrentScans)
+	}
+	if config.BloomFilter {
+		db.bloom = newBloom()
+	}
+	if config.IndexBloom {
+		db.ibloom = sizedBloom(0)
+	}
+	if config.SparseMap {
+		db.smap = newSparseMap()
+	}
+	if config.CacheBytes > 0 {
+		db.cache = newCache(config.CacheBytes)
+	}
+	if config.MissCache > 0 {
+		db.misses = newMisses(config.MissCache)
+	}
+	if config.RecentDocs > 0 {
+		db.recent = newRecents(config.RecentDocs)
+	}
+	if config.LabelTrie {
+		db.labels = newTrie()
+	}
+	if config.FullTextIndex {
+		db.text = newTextIndex()
+	}
+	db.startCompactor()
+	db.opened(start)
+	return db, nil
+}
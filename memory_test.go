@@ -0,0 +1,103 @@
+// In-memory database tests.
+//
+// A memory database runs the same code as a file one above the storage
+// layer, so these tests cover what differs: that it needs no directory,
+// that rebuilds swap buffers correctly, that instances are private, and
+// that the buffer behaves like a file at its edges.
+package folio
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// TestMemory verifies writes, history, compaction, and a snapshot taken
+// before the compaction all work without a file.
+func TestMemory(t *testing.T) {
+	db, err := Open(":memory:", Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+
+	db.Set("doc", "v1")
+	db.Set("doc", "v2")
+	db.Set("other", "x")
+	s, err := db.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db.Delete("other")
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+
+	if got, _ := db.Get("doc"); got != "v2" {
+		t.Errorf("Get = %q, want v2", got)
+	}
+	if _, err := db.Get("other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get deleted: got %v, want ErrNotFound", err)
+	}
+	if versions, err := Collect(db.History("doc")); err != nil || len(versions) != 2 {
+		t.Errorf("History: %d versions, %v", len(versions), err)
+	}
+	if got, _ := s.Get("other"); got != "x" {
+		t.Errorf("snapshot Get other = %q, want x", got)
+	}
+	if r := mustVerify(t, db, VerifyOptions{}); !r.OK() {
+		t.Errorf("Verify: %v", r.Problems)
+	}
+}
+
+// TestMemoryPrivate verifies two memory databases share nothing, and
+// that a backup of one opens as an ordinary file.
+func TestMemoryPrivate(t *testing.T) {
+	a, _ := Open(":memory:", Config{})
+	defer a.Close()
+	b, _ := Open(":memory:", Config{})
+	defer b.Close()
+
+	a.Set("doc", "in a")
+	if _, err := b.Get("doc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("b sees a's document: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "backup.folio")
+	if err := a.Backup(path); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	f, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open backup: %v", err)
+	}
+	defer f.Close()
+	if got, _ := f.Get("doc"); got != "in a" {
+		t.Errorf("backup Get = %q", got)
+	}
+
+	if _, err := Open(":memory:", Config{ReadOnly: true}); err == nil {
+		t.Error("read-only memory database opened")
+	}
+}
+
+// TestMemFile verifies the buffer reports EOF past its end and zero-fills
+// growth after a truncation, as a file does; stale bytes there would
+// read back as records.
+func TestMemFile(t *testing.T) {
+	m := &memFile{}
+	m.WriteAt([]byte("hello"), 0)
+	m.Truncate(2)
+	m.WriteAt([]byte("!"), 4)
+
+	buf := make([]byte, 6)
+	n, err := m.ReadAt(buf, 0)
+	if n != 5 || err != io.EOF || string(buf[:n]) != "he\x00\x00!" {
+		t.Errorf("ReadAt = %d %v %q", n, err, buf[:n])
+	}
+	if info, _ := m.Stat(); info.Size() != 5 {
+		t.Errorf("Size = %d, want 5", info.Size())
+	}
+}
@@ -0,0 +1,313 @@
+// Merging one database into another.
+//
+// Merge brings a copy of a database made elsewhere, a laptop's notes
+// and the server's, back together with the original. A document only
+// src holds is copied with its history. One both hold is compared
+// version by version: if dst's current version is in src's history,
+// dst has fallen behind and takes the versions after it; if src's is in
+// dst's, there is nothing to do; otherwise each side changed it since
+// they last agreed, and the ConflictPolicy decides.
+//
+// Versions are told apart by timestamp and content, so merging the same
+// files again finds nothing new, and merging each into the other leaves
+// both holding the same current versions. Versions are only ever
+// appended after dst's current one, so the current version stays the
+// newest: a losing side's versions that did not reach dst stay in its
+// own history.
+//
+// Merge copies documents, it does not delete them: a document deleted
+// on one side and still present on the other comes back. Where deletes
+// must travel, follow Changes with Apply instead. Tags are not copied.
+package folio
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// ConflictPolicy decides a document both databases changed since they
+// last agreed. The zero value keeps the newer version.
+type ConflictPolicy struct {
+	// KeepBoth also copies the older version to a label of its own, the
+	// document's label with ".conflict-" and the version's timestamp
+	// appended, so neither edit is lost from view.
+	KeepBoth bool
+
+	// Resolve, if set, decides in place of the timestamps and KeepBoth.
+	// It is given dst's and src's current versions and returns the
+	// content to keep, which is written as a new version unless it is
+	// the newer of the two. An error stops the Merge. It runs with both
+	// databases locked, so it must not call either.
+	Resolve func(label string, dst, src Version) (string, error)
+}
+
+// Merge copies the documents and histories of src into dst, settling
+// documents both have changed by policy. Each document lands in one
+// append; a Merge cut short leaves some merged, and running it again
+// finishes the rest. Every Hooks.BeforeSet of dst runs before anything
+// is written, so one rejection writes nothing.
+func Merge(dst, src *DB, policy ConflictPolicy) error {
+	if src == dst {
+		return nil
+	}
+	if src.path() == dst.path() {
+		return errors.New("merge: source and destination are the same file")
+	}
+
+	// Lock in a stable order, as Transfer does, so two Merges in
+	// opposite directions cannot deadlock.
+	lock := func(db *DB) error {
+		if db == dst {
+			return db.blockWrite()
+		}
+		return db.blockRead()
+	}
+	unlock := func(db *DB) {
+		if db == dst {
+			db.mu.Unlock()
+		} else {
+			db.mu.RUnlock()
+		}
+		db.lock.Unlock()
+	}
+	first, second := src, dst
+	if dst.path() < src.path() {
+		first, second = dst, src
+	}
+	if err := lock(first); err != nil {
+		return err
+	}
+	if err := lock(second); err != nil {
+		unlock(first)
+		return err
+	}
+
+	err := merge(dst, src, policy)
+
+	compact := err == nil && dst.shouldCompact()
+	events := dst.takeEvents()
+	unlock(second)
+	unlock(first)
+
+	if err == nil {
+		err = dst.durable()
+	}
+	dst.after(events)
+	if compact {
+		dst.Compact()
+	}
+	return err
+}
+
+// mergeDoc is one document Merge appends to dst: versions in order,
+// the last becoming current, replacing prev if dst held the label.
+type mergeDoc struct {
+	label    string
+	versions []Version
+	created  int64
+	prev     *Result
+	idx      *Index
+}
+
+// merge performs the Merge. dst's write lock and src's read lock must
+// be held.
+func merge(dst, src *DB, policy ConflictPolicy) error {
+	srcSize, err := size(src.reader)
+	if err != nil {
+		return fmt.Errorf("merge: stat: %w", err)
+	}
+	dstSize, err := size(dst.reader)
+	if err != nil {
+		return fmt.Errorf("merge: stat: %w", err)
+	}
+
+	var labels []string
+	seen := map[string]bool{}
+	for _, e := range scanm(src.reader, HeaderSize, srcSize, TypeIndex) {
+		lbl := string(unescape([]byte(e.Label)))
+		if !seen[lbl] {
+			seen[lbl] = true
+			labels = append(labels, lbl)
+		}
+	}
+	slices.Sort(labels)
+
+	ts := now()
+	var plan []mergeDoc
+	planned := map[string]bool{}
+	// add plans doc unless dst already holds its label or it is planned.
+	add := func(doc mergeDoc) error {
+		if planned[doc.label] {
+			return nil
+		}
+		if doc.prev == nil {
+			if err := dst.checkLabel(doc.label); err != nil {
+				return fmt.Errorf("merge: %s: %w", doc.label, err)
+			}
+			result, idx, err := dst.findIndex(dst.id(doc.label), doc.label, dstSize)
+			if err != nil {
+				return fmt.Errorf("merge: %w", err)
+			}
+			if result != nil && !idx.expired(ts) {
+				return nil
+			}
+			doc.prev, doc.idx = result, idx
+		}
+		planned[doc.label] = true
+		plan = append(plan, doc)
+		return nil
+	}
+
+	for _, lbl := range labels {
+		result, idx, err := src.findIndex(src.id(lbl), lbl, srcSize)
+		if err != nil {
+			return fmt.Errorf("merge: %w", err)
+		}
+		if result == nil || idx.expired(ts) {
+			continue
+		}
+		sv, err := src.versions(lbl)
+		if err != nil {
+			return fmt.Errorf("merge: %w", err)
+		}
+		if len(sv) == 0 {
+			return fmt.Errorf("merge: %s: %w", lbl, ErrCorruptRecord)
+		}
+		sc := sv[len(sv)-1]
+
+		prev, pidx, err := dst.findIndex(dst.id(lbl), lbl, dstSize)
+		if err != nil {
+			return fmt.Errorf("merge: %w", err)
+		}
+		if prev == nil || pidx.expired(ts) {
+			// Files written before _c existed fall back to the oldest version.
+			ct := idx.Created
+			if ct == 0 {
+				ct = sv[0].TS
+			}
+			if err := add(mergeDoc{label: lbl, versions: sv, created: ct}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		dv, err := dst.versions(lbl)
+		if err != nil {
+			return fmt.Errorf("merge: %w", err)
+		}
+		if len(dv) == 0 {
+			return fmt.Errorf("merge: %s: %w", lbl, ErrCorruptRecord)
+		}
+		dc := dv[len(dv)-1]
+		if slices.Contains(dv, sc) {
+			continue // dst has src's current version, or is ahead of it
+		}
+
+		// The versions src has since the last one both hold.
+		common := lastHeld(sv, dv)
+		branch := sv[common+1:]
+		if common < 0 || sv[common] != dc {
+			keep, err := settle(lbl, dc, sc, policy, ts)
+			if err != nil {
+				return err
+			}
+			switch {
+			case keep == nil:
+				if policy.KeepBoth && policy.Resolve == nil {
+					if err := add(conflicted(lbl, sc)); err != nil {
+						return err
+					}
+				}
+				continue
+			case *keep != sc:
+				if err := dst.checkDoc(lbl, keep.Data); err != nil {
+					return fmt.Errorf("merge: %s: %w", lbl, err)
+				}
+				branch = append(slices.Clip(branch), *keep)
+			case policy.KeepBoth && policy.Resolve == nil:
+				if err := add(conflicted(lbl, dc)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := add(mergeDoc{
+			label:    stored(lbl, pidx, ts),
+			versions: branch,
+			created:  carried(pidx, ts),
+			prev:     prev,
+			idx:      pidx,
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, doc := range plan {
+		if err := dst.beforeSet(doc.label, doc.versions[len(doc.versions)-1].Data); err != nil {
+			return fmt.Errorf("merge: %w", err)
+		}
+	}
+	for _, doc := range plan {
+		id := dst.id(doc.label)
+		buf, err := dst.encodeDoc(nil, dst.tail, id, doc.label, doc.versions, doc.created, 0)
+		if err != nil {
+			return fmt.Errorf("merge: %w", err)
+		}
+		// raw() appends the final newline.
+		if _, err := dst.raw(buf[:len(buf)-1]); err != nil {
+			return fmt.Errorf("merge: %w", err)
+		}
+		if err := dst.supersede(id, doc.label, 0, doc.prev, doc.idx); err != nil {
+			return fmt.Errorf("merge: %w", err)
+		}
+		dst.wroteSet(doc.label, doc.versions[len(doc.versions)-1].Data)
+	}
+	return nil
+}
+
+// settle decides a conflict between dst's current version dc and src's
+// sc, returning the version to make current in dst, or nil to keep dc.
+func settle(label string, dc, sc Version, policy ConflictPolicy, ts int64) (*Version, error) {
+	newer := sc
+	if dc.TS > sc.TS || dc.TS == sc.TS && dc.Data > sc.Data {
+		newer = dc
+	}
+	content := newer.Data
+	if policy.Resolve != nil && dc.Data != sc.Data {
+		var err error
+		if content, err = policy.Resolve(label, dc, sc); err != nil {
+			return nil, fmt.Errorf("merge: %s: %w", label, err)
+		}
+	}
+	switch {
+	case content != newer.Data:
+		// A new version, dated after both so it stays the newest.
+		return &Version{Data: content, TS: max(ts, dc.TS+1, sc.TS+1)}, nil
+	case newer == dc:
+		return nil, nil
+	default:
+		return &sc, nil
+	}
+}
+
+// conflicted is the copy KeepBoth writes of the version v of label that
+// lost a conflict.
+func conflicted(label string, v Version) mergeDoc {
+	return mergeDoc{
+		label:    label + ".conflict-" + strconv.FormatInt(v.TS, 10),
+		versions: []Version{v},
+		created:  v.TS,
+	}
+}
+
+// lastHeld returns the index of the last version in vs that held also
+// has, or -1.
+func lastHeld(vs, held []Version) int {
+	for i := len(vs) - 1; i >= 0; i-- {
+		if slices.Contains(held, vs[i]) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,219 @@
+// Merge tests.
+//
+// The guarantees under test are that a document only one side has is
+// copied with its history, that a side which fell behind catches up
+// without a conflict, that a real conflict is settled by the policy the
+// same way from either side, and that merging again writes nothing.
+package folio
+
+import (
+	"errors"
+	"maps"
+	"path/filepath"
+	"testing"
+)
+
+// setAt writes a version of label dated ts, so tests can order edits
+// on two databases without sleeping.
+func setAt(t *testing.T, db *DB, label, content string, ts int64) {
+	t.Helper()
+	if err := db.Apply(ChangeEvent{Op: ChangeSet, Label: label, Data: content, TS: ts}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+}
+
+// TestMergeCopies verifies that documents only src holds arrive with
+// their history and creation time, and that dst's own are left alone.
+func TestMergeCopies(t *testing.T) {
+	src, dst := openPair(t)
+	src.Set("notes/a", "one")
+	src.Set("notes/a", "two")
+	dst.Set("notes/b", "mine")
+
+	if err := Merge(dst, src, ConflictPolicy{}); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if got, _ := dst.Get("notes/a"); got != "two" {
+		t.Errorf("Get(notes/a) = %q, want two", got)
+	}
+	if versions, _ := Collect(dst.History("notes/a")); len(versions) != 2 {
+		t.Errorf("History(notes/a) = %d versions, want 2", len(versions))
+	}
+	if got, _ := dst.Get("notes/b"); got != "mine" {
+		t.Errorf("Get(notes/b) = %q, want mine", got)
+	}
+	srcInfo, _ := src.Info("notes/a")
+	dstInfo, _ := dst.Info("notes/a")
+	if srcInfo.Created != dstInfo.Created {
+		t.Errorf("Created = %d, want %d", dstInfo.Created, srcInfo.Created)
+	}
+	if dst.Count() != 2 {
+		t.Errorf("Count = %d, want 2", dst.Count())
+	}
+	mustVerify(t, dst, VerifyOptions{})
+}
+
+// TestMergeFastForward verifies that when only src changed a document
+// since the last merge, dst takes the new versions with no conflict.
+func TestMergeFastForward(t *testing.T) {
+	src, dst := openPair(t)
+	setAt(t, src, "doc", "v1", 1700000000000)
+	Merge(dst, src, ConflictPolicy{KeepBoth: true})
+	setAt(t, src, "doc", "v2", 1700000001000)
+	setAt(t, src, "doc", "v3", 1700000002000)
+
+	if err := Merge(dst, src, ConflictPolicy{KeepBoth: true}); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	versions, _ := Collect(dst.History("doc"))
+	if len(versions) != 3 || versions[2].Data != "v3" {
+		t.Errorf("History = %+v, want v1, v2, v3", versions)
+	}
+	if dst.Count() != 1 {
+		t.Errorf("Count = %d, want 1: a fast-forward is no conflict", dst.Count())
+	}
+}
+
+// TestMergeIdempotent verifies that merging again, in either direction,
+// appends nothing once both sides agree.
+func TestMergeIdempotent(t *testing.T) {
+	src, dst := openPair(t)
+	setAt(t, src, "a", "1", 1700000000000)
+	setAt(t, dst, "b", "2", 1700000000000)
+	Merge(dst, src, ConflictPolicy{})
+	Merge(src, dst, ConflictPolicy{})
+
+	srcTail, dstTail := src.tail, dst.tail
+	for range 2 {
+		if err := Merge(dst, src, ConflictPolicy{}); err != nil {
+			t.Fatalf("Merge: %v", err)
+		}
+		if err := Merge(src, dst, ConflictPolicy{}); err != nil {
+			t.Fatalf("Merge back: %v", err)
+		}
+	}
+	if src.tail != srcTail || dst.tail != dstTail {
+		t.Errorf("tails grew from %d/%d to %d/%d", srcTail, dstTail, src.tail, dst.tail)
+	}
+}
+
+// conflict opens two databases that agreed on doc at v0 and then each
+// changed it: dst to "laptop" and src, later, to "server".
+func conflict(t *testing.T) (dst, src *DB) {
+	t.Helper()
+	src, dst = openPair(t)
+	setAt(t, src, "doc", "v0", 1700000000000)
+	Merge(dst, src, ConflictPolicy{})
+	setAt(t, dst, "doc", "laptop", 1700000001000)
+	setAt(t, src, "doc", "server", 1700000002000)
+	return dst, src
+}
+
+// TestMergeConflictNewer verifies the default policy: the newer edit
+// wins on both sides, whichever is merged into which.
+func TestMergeConflictNewer(t *testing.T) {
+	dst, src := conflict(t)
+	if err := Merge(dst, src, ConflictPolicy{}); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if err := Merge(src, dst, ConflictPolicy{}); err != nil {
+		t.Fatalf("Merge back: %v", err)
+	}
+	for _, db := range []*DB{dst, src} {
+		if got, _ := db.Get("doc"); got != "server" {
+			t.Errorf("Get = %q, want server", got)
+		}
+	}
+	// The losing edit is kept in the history of the side that made it.
+	versions, _ := Collect(dst.History("doc"))
+	if len(versions) != 3 || versions[1].Data != "laptop" {
+		t.Errorf("dst History = %+v, want v0, laptop, server", versions)
+	}
+	mustVerify(t, dst, VerifyOptions{})
+}
+
+// TestMergeKeepBoth verifies that KeepBoth copies the older edit to a
+// conflict label, and that both sides end up with the same documents.
+func TestMergeKeepBoth(t *testing.T) {
+	dst, src := conflict(t)
+	policy := ConflictPolicy{KeepBoth: true}
+	if err := Merge(dst, src, policy); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if err := Merge(src, dst, policy); err != nil {
+		t.Fatalf("Merge back: %v", err)
+	}
+	want := map[string]string{"doc": "server", "doc.conflict-1700000001000": "laptop"}
+	for name, db := range map[string]*DB{"dst": dst, "src": src} {
+		if got := documents(t, db); !maps.Equal(got, want) {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// TestMergeResolve verifies that Resolve's content is written as a new
+// version, newer than both edits, and carried back to src as a plain
+// catch-up rather than a second conflict.
+func TestMergeResolve(t *testing.T) {
+	dst, src := conflict(t)
+	calls := 0
+	policy := ConflictPolicy{Resolve: func(label string, d, s Version) (string, error) {
+		calls++
+		return d.Data + "+" + s.Data, nil
+	}}
+	if err := Merge(dst, src, policy); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if err := Merge(src, dst, policy); err != nil {
+		t.Fatalf("Merge back: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Resolve called %d times, want 1", calls)
+	}
+	for _, db := range []*DB{dst, src} {
+		if got, _ := db.Get("doc"); got != "laptop+server" {
+			t.Errorf("Get = %q, want laptop+server", got)
+		}
+	}
+
+	dst, src = conflict(t)
+	boom := errors.New("boom")
+	policy.Resolve = func(string, Version, Version) (string, error) { return "", boom }
+	if err := Merge(dst, src, policy); !errors.Is(err, boom) {
+		t.Errorf("Merge = %v, want the Resolve error", err)
+	}
+	if got, _ := dst.Get("doc"); got != "laptop" {
+		t.Errorf("Get after failed Resolve = %q, want laptop", got)
+	}
+}
+
+// TestMergeRejected verifies that a BeforeSet rejection of any document
+// leaves dst unwritten.
+func TestMergeRejected(t *testing.T) {
+	src, err := Open(filepath.Join(t.TempDir(), "src.folio"), Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+	dst, err := Open(filepath.Join(t.TempDir(), "dst.folio"), Config{Hooks: Hooks{
+		BeforeSet: func(label, content string) error {
+			if label == "b" {
+				return errors.New("no")
+			}
+			return nil
+		},
+	}})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer dst.Close()
+	src.Set("a", "1")
+	src.Set("b", "2")
+
+	if err := Merge(dst, src, ConflictPolicy{}); !errors.Is(err, ErrRejected) {
+		t.Fatalf("Merge = %v, want ErrRejected", err)
+	}
+	if dst.Count() != 0 {
+		t.Errorf("Count = %d, want 0", dst.Count())
+	}
+}
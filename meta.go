@@ -0,0 +1,177 @@
+// Header extension: a metadata record referenced from the header.
+//
+// The header is fixed at 128 bytes so the dirty flag can be patched at a
+// known offset, which leaves no room for state that grows with use. State
+// that must survive between sessions instead lives in a single metadata
+// record (_r=4) whose byte offset is stored in the header at _s[2]. The
+// record shares the fixed {"_r":N,"_id":"...","_ts":N prefix of every
+// other line, so scans that filter by type skip it without parsing, and
+// older readers that do not know the type simply ignore it.
+//
+// Replacing the record appends the new version, rewrites the header to
+// point at it, and only then blanks the old one, so a crash at any point
+// leaves the header pointing at a complete record. Compaction carries the
+// current record forward as the first line after the new index and tag
+// sections.
+package folio
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+
+	json "github.com/goccy/go-json"
+)
+
+// TypeMeta marks the header extension record. It has no label, no
+// index, and is never returned by document lookups or scans.
+const TypeMeta = 4
+
+// metaID fills the fixed-width _id slot of the metadata record. It is
+// not the hash of any label, only a placeholder that keeps the record
+// readable at the standard byte positions.
+const metaID = "0000000000000000"
+
+// Meta is the header extension record. Every field is optional so the
+// record stays small until a feature needs it.
+type Meta struct {
+	Type      int       `json:"_r"`
+	ID        string    `json:"_id"`
+	Timestamp int64     `json:"_ts"`
+	Usage     *Usage    `json:"_u,omitempty"`  // cumulative counters (Config.PersistUsage)
+	Tags      int64     `json:"_g,omitempty"`  // end of the sorted tag section (see tag.go)
+	Dicts     []Dict    `json:"_zd,omitempty"` // trained Zstd dictionaries, oldest first (see dict.go)
+	Sizes     *Sizes    `json:"_sz,omitempty"` // content and history totals (see size.go)
+	Table     int64     `json:"_ht,omitempty"` // start of the hash table over the index (see hashtable.go)
+	Segments  []Segment `json:"_sg,omitempty"` // sealed segments of the sparse region, oldest first (see segment.go)
+}
+
+// Usage holds cumulative operation counters. With Config.PersistUsage
+// they are saved at Close and resumed at Open, so a file can be metered
+// across every process that has ever opened it.
+type Usage struct {
+	Reads        uint64 `json:"r"`  // point reads: Get, Exists, Info, History
+	Scans        uint64 `json:"s"`  // full-file scans: Search, MatchLabel, All, List
+	Writes       uint64 `json:"w"`  // documents written, deleted, or renamed
+	BytesRead    uint64 `json:"br"` // document content bytes returned to callers
+	BytesWritten uint64 `json:"bw"` // bytes appended to the file
+}
+
+// usage accumulates counters for the current session. Reads happen
+// under a shared lock, so every field is atomic.
+type usage struct {
+	reads, scans, writes, bytesRead, bytesWritten atomic.Uint64
+}
+
+// add returns base plus the session counters.
+func (u *usage) add(base Usage) Usage {
+	return Usage{
+		Reads:        base.Reads + u.reads.Load(),
+		Scans:        base.Scans + u.scans.Load(),
+		Writes:       base.Writes + u.writes.Load(),
+		BytesRead:    base.BytesRead + u.bytesRead.Load(),
+		BytesWritten: base.BytesWritten + u.bytesWritten.Load(),
+	}
+}
+
+// reset zeroes the session counters once they have been folded into
+// a saved metadata record.
+func (u *usage) reset() {
+	u.reads.Store(0)
+	u.scans.Store(0)
+	u.writes.Store(0)
+	u.bytesRead.Store(0)
+	u.bytesWritten.Store(0)
+}
+
+// loadMeta reads the metadata record the header points at. A missing or
+// unreadable record is not an error: the header extension only carries
+// optional state, and the next save writes a fresh record.
+func (db *DB) loadMeta() {
+	off := int64(db.header.State[stMeta])
+	if off < HeaderSize {
+		db.meta = nil
+		return
+	}
+	data, err := line(db.reader, off)
+	if err != nil || len(data) < MinRecordSize || data[TypePos] != byte('0'+TypeMeta) {
+		db.meta = nil
+		return
+	}
+	var m Meta
+	if err := json.Unmarshal(data, &m); err != nil {
+		db.meta = nil
+		return
+	}
+	db.meta = &m
+}
+
+// currentMeta returns the metadata record to persist now, or nil if
+// there is nothing to store. Session counters are folded in only when
+// Config.PersistUsage is set, so opening a metered file without it
+// neither loses nor inflates the stored totals.
+func (db *DB) currentMeta() *Meta {
+	m := Meta{Type: TypeMeta, ID: metaID, Timestamp: now()}
+	if db.meta != nil {
+		m = *db.meta
+		m.Timestamp = now()
+	}
+	if db.config.PersistUsage {
+		var base Usage
+		if m.Usage != nil {
+			base = *m.Usage
+		}
+		u := db.usage.add(base)
+		m.Usage = &u
+	}
+	m.Sizes = db.savedSizes(db.tail)
+	if m.Usage == nil && m.Tags == 0 && len(m.Dicts) == 0 && m.Sizes == nil && len(m.Segments) == 0 {
+		return nil
+	}
+	return &m
+}
+
+// saveMeta appends the current metadata record and repoints the header
+// at it. The caller must hold the write lock (or be closing) and is
+// responsible for writing the header afterwards; the previous record is
+// returned so it can be blanked once the new header is durable.
+func (db *DB) saveMeta() (old int64, err error) {
+	m := db.currentMeta()
+	if m == nil {
+		return 0, nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return 0, fmt.Errorf("meta: marshal: %w", err)
+	}
+	off := db.tail
+	if _, err := db.writer.WriteAt(append(data, '\n'), off); err != nil {
+		return 0, fmt.Errorf("meta: write: %w", err)
+	}
+	db.tail += int64(len(data)) + 1
+	if err := db.writer.Sync(); err != nil {
+		return 0, fmt.Errorf("meta: sync: %w", err)
+	}
+	if db.sizes != nil && db.sizes.at == off {
+		db.sizes.at = db.tail // the record holds no content
+	}
+
+	old = int64(db.header.State[stMeta])
+	db.header.State[stMeta] = uint64(off)
+	db.meta = m
+	db.usage.reset()
+	return old, nil
+}
+
+// blankMeta erases a superseded metadata record.
+func (db *DB) blankMeta(off int64) error {
+	if off < HeaderSize {
+		return nil
+	}
+	data, err := line(db.reader, off)
+	if err != nil || len(data) < MinRecordSize || data[TypePos] != byte('0'+TypeMeta) {
+		return nil
+	}
+	_, err = db.writer.WriteAt(bytes.Repeat([]byte(" "), len(data)), off)
+	return err
+}
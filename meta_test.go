@@ -0,0 +1,129 @@
+// Header extension and usage counter tests.
+//
+// The metadata record stores state that does not fit in the fixed-size
+// header. These tests verify that usage counters accumulate within a
+// session, resume across sessions, survive compaction and crash
+// recovery, and that the record never leaks into document scans.
+package folio
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestStatsUsageSession verifies the counters for a single session.
+// Billing built on these numbers is only as good as each increment.
+func TestStatsUsageSession(t *testing.T) {
+	db := openTestDB(t)
+
+	db.Set("a", "hello")
+	db.Set("b", "world")
+	db.Get("a")
+	db.Exists("b")
+	db.Delete("b")
+	Collect(db.List())
+
+	u := db.Stats().Usage
+	if u.Writes != 3 {
+		t.Errorf("Writes = %d, want 3", u.Writes)
+	}
+	if u.Reads != 2 {
+		t.Errorf("Reads = %d, want 2", u.Reads)
+	}
+	if u.Scans != 1 {
+		t.Errorf("Scans = %d, want 1", u.Scans)
+	}
+	if u.BytesRead != 5 {
+		t.Errorf("BytesRead = %d, want 5", u.BytesRead)
+	}
+	if u.BytesWritten == 0 {
+		t.Error("BytesWritten = 0")
+	}
+}
+
+// TestStatsUsagePersist verifies that PersistUsage carries counters from
+// one session to the next, and that a session without the flag neither
+// adds to nor erases the stored totals.
+func TestStatsUsagePersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	cfg := Config{PersistUsage: true}
+
+	db, _ := Open(path, cfg)
+	db.Set("a", "1")
+	db.Get("a")
+	db.Close()
+
+	db, _ = Open(path, cfg)
+	if u := db.Stats().Usage; u.Writes != 1 || u.Reads != 1 {
+		t.Errorf("resumed usage = %+v, want 1 write and 1 read", u)
+	}
+	db.Get("a")
+	db.Close()
+
+	// Unmetered session: its own counters are not saved.
+	db, _ = Open(path, Config{})
+	db.Get("a")
+	db.Get("a")
+	db.Close()
+
+	db, _ = Open(path, cfg)
+	defer db.Close()
+	if u := db.Stats().Usage; u.Writes != 1 || u.Reads != 2 {
+		t.Errorf("usage after unmetered session = %+v, want 1 write and 2 reads", u)
+	}
+}
+
+// TestMetaSurvivesCompact verifies the metadata record is carried into
+// the rebuilt file and stays invisible to document operations. If
+// compaction dropped it, metering would silently reset; if scans
+// returned it, users would see a phantom document.
+func TestMetaSurvivesCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	cfg := Config{PersistUsage: true}
+
+	db, _ := Open(path, cfg)
+	db.Set("a", "1")
+	db.Close()
+
+	db, _ = Open(path, cfg)
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if db.meta == nil || db.meta.Usage == nil || db.meta.Usage.Writes != 1 {
+		t.Fatalf("meta after compact = %+v", db.meta)
+	}
+	db.Close()
+
+	db, _ = Open(path, cfg)
+	defer db.Close()
+	if u := db.Stats().Usage; u.Writes != 1 {
+		t.Errorf("Writes after compact and reopen = %d, want 1", u.Writes)
+	}
+
+	labels, _ := Collect(db.List())
+	docs, _ := Collect(db.All())
+	if len(labels) != 1 || len(docs) != 1 {
+		t.Errorf("List=%v All=%d, want only the one document", labels, len(docs))
+	}
+	if db.Count() != 1 {
+		t.Errorf("Count = %d, want 1", db.Count())
+	}
+}
+
+// TestMetaBlanksPrevious verifies each save leaves exactly one live
+// metadata record, so repeated sessions do not accumulate stale copies.
+func TestMetaBlanksPrevious(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	for range 3 {
+		db, _ := Open(path, Config{PersistUsage: true})
+		db.Set("a", "1")
+		db.Close()
+	}
+
+	db, _ := Open(path, Config{})
+	defer db.Close()
+	metas := scanm(db.reader, HeaderSize, dbsize(t, db), TypeMeta)
+	if len(metas) != 1 {
+		t.Errorf("live metadata records = %d, want 1", len(metas))
+	}
+}
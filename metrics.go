@@ -0,0 +1,84 @@
+// Operation metrics.
+//
+// Config.MetricsCollector, if set, is told about every call to the
+// point operations and maintenance methods as it returns: which operation
+// ran, how long it took from the caller's point of view (waiting for
+// locks included), and the error it returned. A write that triggers
+// auto-compaction reports the compaction as its own OpCompact, and its
+// own latency includes it. Iterators (All, List, Search, History,
+// Changes) are not timed, since their duration is set by the caller's
+// loop.
+//
+// Observe runs on the caller's goroutine, after every lock has been
+// released, so it must be fast and safe for concurrent use but may call
+// back into the DB. The folio/metrics package has ready-made collectors
+// for expvar and the Prometheus text format.
+package folio
+
+import (
+	"errors"
+	"time"
+)
+
+// Collector receives one observation per operation. err is the error the
+// operation returned, nil on success; ErrNotFound from a lookup counts as
+// an outcome, not a failure, and collectors may want to report it apart.
+type Collector interface {
+	Observe(op string, elapsed time.Duration, err error)
+}
+
+// Operation names passed to Collector.Observe. SetBytes, SetWithTTL,
+// SetReader, SetAsync, Create, Update, and GetOrSet report as OpSet;
+// CopyWithHistory as OpCopy; GetBytes, GetReader, GetOr, and
+// Snapshot.Get as OpGet;
+// Snapshot.Export and ExportDir as OpExport; ImportDir as OpImport;
+// RepairLabel as OpRepair; Purge, CompactStep, and auto-compaction as
+// OpCompact.
+const (
+	OpGet        = "get"
+	OpGetMany    = "get_many"
+	OpExists     = "exists"
+	OpInfo       = "info"
+	OpStat       = "stat"
+	OpGetAt      = "get_at"
+	OpGetVersion = "get_version"
+	OpDiff       = "diff"
+	OpSet        = "set"
+	OpBatch      = "batch"
+	OpDelete     = "delete"
+	OpDeleteMany = "delete_many"
+	OpRename     = "rename"
+	OpCopy       = "copy"
+	OpTouch      = "touch"
+	OpPatch      = "patch"
+	OpTag        = "tag"
+	OpUntag      = "untag"
+	OpRevert     = "revert"
+	OpTxn        = "txn"
+	OpCompact    = "compact"
+	OpRepair     = "repair"
+	OpRehash     = "rehash"
+	OpMigrate    = "migrate"
+	OpTrain      = "train_dictionary"
+	OpExport     = "export"
+	OpBackup     = "backup"
+	OpClone      = "clone"
+	OpImport     = "import"
+	OpApply      = "apply"
+	OpSeal       = "seal"
+)
+
+// observe reports an operation on label ("" if it has none) that started
+// at start and returned *err, and turns *err into the Error it returns.
+// Deferred at the top of each instrumented method.
+func (db *DB) observe(op, label string, start time.Time, err *error) {
+	if *err != nil {
+		*err = describe(op, label, *err)
+	}
+	if db.config.MetricsCollector != nil {
+		db.config.MetricsCollector.Observe(op, time.Since(start), *err)
+	}
+	if e := (*Error)(nil); errors.As(*err, &e) && corrupt(e) {
+		db.log.Error("corruption detected", "op", op, "label", e.Label, "offset", e.Offset, "error", *err)
+	}
+}
@@ -0,0 +1,205 @@
+// Package metrics provides ready-made folio.Collector implementations.
+//
+// Expvar publishes per-operation counters under a name in the standard
+// expvar registry, so they appear at /debug/vars with no other setup:
+//
+//	db, _ := folio.Open(path, folio.Config{
+//		MetricsCollector: metrics.NewExpvar("folio"),
+//	})
+//
+// Prometheus keeps a latency histogram per operation and outcome and
+// serves it in the Prometheus text exposition format. It has no
+// dependency on the Prometheus client library; mount it on any mux:
+//
+//	prom := metrics.NewPrometheus()
+//	db, _ := folio.Open(path, folio.Config{MetricsCollector: prom})
+//	http.Handle("/metrics", prom)
+//
+// Both classify each observation by outcome: "ok", "not_found" for
+// folio.ErrNotFound, and "error" for anything else, so lookups for
+// missing documents do not read as failures.
+package metrics
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jpl-au/folio"
+)
+
+// Outcomes reported for each observation.
+const (
+	OutcomeOK       = "ok"
+	OutcomeNotFound = "not_found"
+	OutcomeError    = "error"
+)
+
+// Outcome classifies an operation's error.
+func Outcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeOK
+	case errors.Is(err, folio.ErrNotFound):
+		return OutcomeNotFound
+	default:
+		return OutcomeError
+	}
+}
+
+// Expvar counts operations in an expvar.Map. For each operation it keeps
+// "<op>.count", "<op>.<outcome>" for every outcome seen, and "<op>.ns",
+// the total time spent, from which a dashboard derives mean latency.
+type Expvar struct {
+	m *expvar.Map
+}
+
+// NewExpvar publishes a new map under name. Like expvar.Publish it
+// panics if name is already in use, so create one per process.
+func NewExpvar(name string) *Expvar {
+	return &Expvar{m: expvar.NewMap(name)}
+}
+
+// Map returns the underlying map.
+func (e *Expvar) Map() *expvar.Map { return e.m }
+
+// Observe implements folio.Collector.
+func (e *Expvar) Observe(op string, elapsed time.Duration, err error) {
+	e.m.Add(op+".count", 1)
+	e.m.Add(op+"."+Outcome(err), 1)
+	e.m.Add(op+".ns", elapsed.Nanoseconds())
+}
+
+// DefaultBuckets are the histogram upper bounds, in seconds, used by
+// NewPrometheus. They span a cached point read to a large compaction.
+var DefaultBuckets = []float64{
+	0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005,
+	0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// metricName is the histogram's name in the exposition output.
+const metricName = "folio_operation_duration_seconds"
+
+// series identifies one histogram.
+type series struct {
+	op, outcome string
+}
+
+// histogram holds a count per bucket. The exposition format wants
+// cumulative counts, so they are summed as they are written.
+type histogram struct {
+	counts []uint64 // per bucket, plus one for +Inf
+	sum    float64
+}
+
+// Prometheus records operation latencies as histograms labelled by op
+// and outcome, and serves them over HTTP.
+type Prometheus struct {
+	buckets []float64
+	mu      sync.Mutex
+	series  map[series]*histogram
+}
+
+// NewPrometheus returns a collector using DefaultBuckets.
+func NewPrometheus() *Prometheus {
+	return NewPrometheusBuckets(DefaultBuckets)
+}
+
+// NewPrometheusBuckets returns a collector with the given upper bounds,
+// in seconds. They are sorted; +Inf is implied.
+func NewPrometheusBuckets(buckets []float64) *Prometheus {
+	b := slices.Clone(buckets)
+	slices.Sort(b)
+	return &Prometheus{buckets: b, series: map[series]*histogram{}}
+}
+
+// Observe implements folio.Collector.
+func (p *Prometheus) Observe(op string, elapsed time.Duration, err error) {
+	s := series{op, Outcome(err)}
+	secs := elapsed.Seconds()
+	i, _ := slices.BinarySearch(p.buckets, secs)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := p.series[s]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(p.buckets)+1)}
+		p.series[s] = h
+	}
+	h.counts[i]++
+	h.sum += secs
+}
+
+// WriteTo writes every histogram in the text exposition format, sorted
+// by op and outcome so the output is stable.
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	keys := make([]series, 0, len(p.series))
+	snap := make(map[series]histogram, len(p.series))
+	for s, h := range p.series {
+		keys = append(keys, s)
+		snap[s] = histogram{counts: slices.Clone(h.counts), sum: h.sum}
+	}
+	p.mu.Unlock()
+	slices.SortFunc(keys, func(a, b series) int {
+		if c := cmp.Compare(a.op, b.op); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.outcome, b.outcome)
+	})
+
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	fmt.Fprintf(cw, "# HELP %s Latency of folio operations.\n", metricName)
+	fmt.Fprintf(cw, "# TYPE %s histogram\n", metricName)
+	for _, s := range keys {
+		h := snap[s]
+		labels := fmt.Sprintf(`op=%q,outcome=%q`, s.op, s.outcome)
+		var total uint64
+		for i, n := range h.counts {
+			total += n
+			le := "+Inf"
+			if i < len(p.buckets) {
+				le = strconv.FormatFloat(p.buckets[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(cw, "%s_bucket{%s,le=%q} %d\n", metricName, labels, le, total)
+		}
+		fmt.Fprintf(cw, "%s_sum{%s} %s\n", metricName, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(cw, "%s_count{%s} %d\n", metricName, labels, total)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP serves the histograms for a Prometheus scrape.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteTo(w)
+}
+
+// countWriter counts bytes written and keeps the first error, so
+// WriteTo can format freely and check once at the end.
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,99 @@
+// Collector tests.
+//
+// Dashboards are built on the exact names and label values these
+// collectors emit, so the tests pin them, along with the outcome that
+// keeps a missing-document lookup from being charted as an error.
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpl-au/folio"
+)
+
+// TestOutcome verifies ErrNotFound is told apart from real failures,
+// including when wrapped.
+func TestOutcome(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, OutcomeOK},
+		{folio.ErrNotFound, OutcomeNotFound},
+		{errors.Join(errors.New("ctx"), folio.ErrNotFound), OutcomeNotFound},
+		{folio.ErrCorruptRecord, OutcomeError},
+	}
+	for _, tt := range tests {
+		if got := Outcome(tt.err); got != tt.want {
+			t.Errorf("Outcome(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+// TestExpvar verifies counters accumulate through a real database.
+func TestExpvar(t *testing.T) {
+	e := NewExpvar("folio_test_expvar")
+	db, err := folio.Open(filepath.Join(t.TempDir(), "test.folio"), folio.Config{MetricsCollector: e})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Set("a", "1")
+	db.Get("a")
+	db.Get("missing")
+
+	m := e.Map()
+	for key, want := range map[string]string{
+		"set.count":     "1",
+		"set.ok":        "1",
+		"get.count":     "2",
+		"get.ok":        "1",
+		"get.not_found": "1",
+	} {
+		if v := m.Get(key); v == nil || v.String() != want {
+			t.Errorf("%s = %v, want %s", key, v, want)
+		}
+	}
+	if v := m.Get("get.ns"); v == nil || v.String() == "0" {
+		t.Errorf("get.ns = %v, want a duration", v)
+	}
+}
+
+// TestPrometheus verifies the exposition output: cumulative buckets, a
+// +Inf bucket equal to the count, and one series per op and outcome.
+func TestPrometheus(t *testing.T) {
+	p := NewPrometheusBuckets([]float64{0.1, 0.01})
+	p.Observe("get", 5*time.Millisecond, nil)
+	p.Observe("get", 50*time.Millisecond, nil)
+	p.Observe("get", time.Second, nil)
+	p.Observe("get", time.Millisecond, folio.ErrNotFound)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	out := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE folio_operation_duration_seconds histogram\n",
+		`folio_operation_duration_seconds_bucket{op="get",outcome="ok",le="0.01"} 1` + "\n",
+		`folio_operation_duration_seconds_bucket{op="get",outcome="ok",le="0.1"} 2` + "\n",
+		`folio_operation_duration_seconds_bucket{op="get",outcome="ok",le="+Inf"} 3` + "\n",
+		`folio_operation_duration_seconds_sum{op="get",outcome="ok"} 1.055` + "\n",
+		`folio_operation_duration_seconds_count{op="get",outcome="ok"} 3` + "\n",
+		`folio_operation_duration_seconds_count{op="get",outcome="not_found"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Index(out, `outcome="not_found"`) > strings.Index(out, `outcome="ok"`) {
+		t.Error("series not sorted by outcome")
+	}
+}
@@ -0,0 +1,57 @@
+// Operation metrics tests.
+//
+// The collector is only useful if every instrumented call reports
+// exactly once, under the documented name, with the error it returned.
+package folio
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a Collector that keeps every observation.
+type recorder struct {
+	mu   sync.Mutex
+	ops  []string
+	errs []error
+}
+
+func (r *recorder) Observe(op string, elapsed time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, op)
+	r.errs = append(r.errs, err)
+}
+
+// TestMetricsCollector verifies each call is observed once, wrappers
+// report as the operation they wrap, and errors are passed through.
+func TestMetricsCollector(t *testing.T) {
+	rec := &recorder{}
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{MetricsCollector: rec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Set("a", "1")
+	db.SetBytes("b", []byte("2"))
+	db.GetBytes("a")
+	db.Get("missing")
+	db.Delete("b")
+	db.Compact()
+
+	want := []string{OpSet, OpSet, OpGet, OpGet, OpDelete, OpCompact}
+	if !slices.Equal(rec.ops, want) {
+		t.Errorf("ops = %v, want %v", rec.ops, want)
+	}
+	if !errors.Is(rec.errs[3], ErrNotFound) {
+		t.Errorf("missing Get err = %v, want ErrNotFound", rec.errs[3])
+	}
+	if rec.errs[0] != nil {
+		t.Errorf("Set err = %v", rec.errs[0])
+	}
+}
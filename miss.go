@@ -0,0 +1,177 @@
+// Optional cache of labels recently found absent.
+//
+// A lookup for a label that does not exist pays for the whole search:
+// the binary search of the sorted index and the scan of the sparse
+// region, unless a bloom filter rules the ID out. An application that
+// probes again and again for an optional document, a config override
+// most installs never write, pays it every time. When enabled
+// (Config.MissCache), Get and Exists remember up to that many labels they
+// found absent, least recently missed dropped first, and answer a repeat
+// without searching.
+//
+// A miss holds for as long as the file has not grown. Every append made
+// through this handle forgets the IDs of the index lines it writes and
+// keeps the rest, and a same-length Rename forgets the ID it patches in;
+// a file found longer than the cache last knew it, grown by another
+// process or by a streamed write, empties it. A rebuild empties it too.
+package folio
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+)
+
+// missed is one label a lookup found absent.
+type missed struct {
+	label string // folded
+	id    string
+}
+
+// misses is an LRU of labels found absent, which hold while the file is
+// at bytes long.
+type misses struct {
+	mu     sync.Mutex
+	limit  int
+	at     int64
+	lru    *list.List               // front is most recent; values are *missed
+	labels map[string]*list.Element // by folded label
+	ids    map[string][]string      // folded labels by ID
+}
+
+func newMisses(limit int) *misses {
+	return &misses{
+		limit:  limit,
+		lru:    list.New(),
+		labels: map[string]*list.Element{},
+		ids:    map[string][]string{},
+	}
+}
+
+// has reports whether label, with ID id, was found absent in a file of
+// size bytes.
+func (m *misses) has(label, id string, size int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if size != m.at {
+		m.clear(size)
+		return false
+	}
+	e, ok := m.labels[label]
+	if !ok || e.Value.(*missed).id != id {
+		return false
+	}
+	m.lru.MoveToFront(e)
+	return true
+}
+
+// add records that label, with ID id, is absent from a file of size
+// bytes.
+func (m *misses) add(label, id string, size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if size != m.at {
+		m.clear(size)
+	}
+	if e, ok := m.labels[label]; ok {
+		m.lru.MoveToFront(e)
+		return
+	}
+	m.labels[label] = m.lru.PushFront(&missed{label: label, id: id})
+	m.ids[id] = append(m.ids[id], label)
+	for m.lru.Len() > m.limit {
+		m.remove(m.lru.Back())
+	}
+}
+
+// appended notes that lines were written at off, forgetting the ID of
+// each index line among them. A write anywhere but the known end of the
+// file empties the cache.
+func (m *misses) appended(off int64, lines []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if off != m.at {
+		m.clear(off + int64(len(lines)))
+		return
+	}
+	m.at = off + int64(len(lines))
+	for len(lines) > 0 {
+		ln := lines
+		if i := bytes.IndexByte(lines, '\n'); i >= 0 {
+			ln, lines = lines[:i], lines[i+1:]
+		} else {
+			lines = nil
+		}
+		if len(ln) >= MinRecordSize && ln[TypePos] == '0'+TypeIndex {
+			m.forgetLocked(string(ln[IDStart:IDEnd]))
+		}
+	}
+}
+
+// forget drops every label missed with ID id.
+func (m *misses) forget(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.forgetLocked(id)
+}
+
+func (m *misses) forgetLocked(id string) {
+	for _, lbl := range m.ids[id] {
+		if e, ok := m.labels[lbl]; ok {
+			m.lru.Remove(e)
+			delete(m.labels, lbl)
+		}
+	}
+	delete(m.ids, id)
+}
+
+// remove drops one entry. The caller holds m.mu.
+func (m *misses) remove(e *list.Element) {
+	v := m.lru.Remove(e).(*missed)
+	delete(m.labels, v.label)
+	rest := m.ids[v.id][:0]
+	for _, lbl := range m.ids[v.id] {
+		if lbl != v.label {
+			rest = append(rest, lbl)
+		}
+	}
+	if len(rest) == 0 {
+		delete(m.ids, v.id)
+	} else {
+		m.ids[v.id] = rest
+	}
+}
+
+// clear empties the cache for a file of size bytes. The caller holds
+// m.mu.
+func (m *misses) clear(size int64) {
+	m.at = size
+	m.lru.Init()
+	clear(m.labels)
+	clear(m.ids)
+}
+
+// reset empties the cache after a rebuild.
+func (m *misses) reset(size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clear(size)
+}
+
+// missing reports whether label, with ID id, is in the miss cache. The
+// caller must hold db.mu.
+func (db *DB) missing(label, id string) bool {
+	if db.misses == nil {
+		return false
+	}
+	sz, err := size(db.reader)
+	return err == nil && db.misses.has(db.fold(label), id, sz)
+}
+
+// miss notes that a search of a file sz bytes long found no label with
+// ID id. The caller must hold db.mu.
+func (db *DB) miss(label, id string, sz int64) {
+	if db.misses != nil {
+		db.misses.add(db.fold(label), id, sz)
+	}
+}
@@ -0,0 +1,107 @@
+// Miss cache tests.
+//
+// A miss the cache holds past the write that creates its label makes a
+// document invisible, so the tests are mostly about each way a label
+// can appear: a Set through the handle, a same-length rename patched in
+// place, and a file that grew by a write the cache did not see.
+package folio
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// openMisses opens a database with a miss cache of n labels.
+func openMisses(t *testing.T, path string, n int) *DB {
+	t.Helper()
+	db, err := Open(path, Config{MissCache: n})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestMissCache verifies that a miss is remembered, answered again
+// without a search, and forgotten by the Set that creates the label.
+func TestMissCache(t *testing.T) {
+	db := openMisses(t, filepath.Join(t.TempDir(), "test.folio"), 8)
+	db.Set("other", "v")
+
+	if _, err := db.Get("config/override"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get = %v, want ErrNotFound", err)
+	}
+	if !db.missing("config/override", db.id("config/override")) {
+		t.Fatal("miss not cached")
+	}
+	if ok, _ := db.Exists("config/override"); ok {
+		t.Error("Exists = true for a cached miss")
+	}
+
+	db.Set("other", "v2") // an unrelated write keeps the miss
+	if !db.missing("config/override", db.id("config/override")) {
+		t.Error("unrelated Set dropped the miss")
+	}
+	if err := db.Set("config/override", "debug"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if got, err := db.Get("config/override"); err != nil || got != "debug" {
+		t.Errorf("Get after Set = %q, %v; want debug", got, err)
+	}
+}
+
+// TestMissCacheRename verifies that a same-length rename onto a missed
+// label, which appends no index for it, still forgets the miss.
+func TestMissCacheRename(t *testing.T) {
+	db := openMisses(t, filepath.Join(t.TempDir(), "test.folio"), 8)
+	db.Set("aaa", "content")
+	db.Exists("bbb")
+	if err := db.Rename("aaa", "bbb"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if ok, _ := db.Exists("bbb"); !ok {
+		t.Error("Exists(bbb) = false after renaming onto a cached miss")
+	}
+}
+
+// TestMissesGrown verifies that a miss lapses once the file is longer
+// than when it was noted, as when another process has appended to it,
+// and that an append at the known end keeps the misses it does not
+// touch.
+func TestMissesGrown(t *testing.T) {
+	m := newMisses(4)
+	m.add("a", "id-a", 100)
+	m.add("b", "id-b", 100)
+	m.appended(100, []byte("not an index line\n"))
+	if !m.has("a", "id-a", 118) || !m.has("b", "id-b", 118) {
+		t.Error("an append at the end dropped misses it did not touch")
+	}
+	if m.has("a", "id-a", 200) {
+		t.Error("miss held in a file that grew without the cache seeing it")
+	}
+	if m.has("b", "id-b", 118) {
+		t.Error("misses survived the file growing")
+	}
+}
+
+// TestMissCacheLimit verifies that the least recently missed label is
+// dropped first, and that a compaction empties the cache.
+func TestMissCacheLimit(t *testing.T) {
+	db := openMisses(t, filepath.Join(t.TempDir(), "test.folio"), 2)
+	db.Set("seed", "v")
+	db.Get("a")
+	db.Get("b")
+	db.Get("a")
+	db.Get("c")
+
+	for label, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if got := db.missing(label, db.id(label)); got != want {
+			t.Errorf("missing(%s) = %v, want %v", label, got, want)
+		}
+	}
+	db.Compact()
+	if db.missing("a", db.id("a")) {
+		t.Error("miss survived Compact")
+	}
+}
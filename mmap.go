@@ -0,0 +1,58 @@
+// Memory-mapped reads.
+//
+// Lookups in the sorted sections are many small reads: each binary
+// search pivot aligns to a line with 1-byte reads and scanBack walks
+// backwards one byte at a time, every one of them a ReadAt syscall.
+// With Config.MmapReads the read handle maps the file as it stands when
+// opened or compacted, and serves any read that falls inside the
+// mapping with a copy instead. Appends land past the mapping and are
+// read from the file as before; in-place patches go through the writer
+// to the same page cache the shared mapping reads, so they are seen at
+// once. Compaction replaces the file and maps the new one.
+//
+// Where mapping is unsupported or fails, the handle is used unmapped.
+package folio
+
+import "os"
+
+// mappedFile is a read handle whose leading bytes are memory-mapped.
+type mappedFile struct {
+	*os.File
+	data []byte
+}
+
+// mapped returns f with the file's current contents mapped if
+// config.MmapReads is set, or f itself.
+func mapped(f *os.File, config Config) storage {
+	if !config.MmapReads {
+		return f
+	}
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 || int64(int(info.Size())) != info.Size() {
+		return f
+	}
+	data, err := mmap(f, int(info.Size()))
+	if err != nil {
+		return f
+	}
+	return &mappedFile{File: f, data: data}
+}
+
+// ReadAt serves reads inside the mapping from memory, and the rest from
+// the file.
+func (m *mappedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= 0 && off+int64(len(p)) <= int64(len(m.data)) {
+		return copy(p, m.data[off:]), nil
+	}
+	return m.File.ReadAt(p, off)
+}
+
+// Close unmaps the file before closing it.
+func (m *mappedFile) Close() error {
+	err := munmap(m.data)
+	m.data = nil
+	if cerr := m.File.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
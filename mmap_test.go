@@ -0,0 +1,90 @@
+// Memory-mapped read tests.
+//
+// A mapping is only safe if every byte it serves matches the file: the
+// reads inside it must see in-place patches, the reads past it must see
+// appends, and compaction must move it onto the new file.
+package folio
+
+import (
+	"errors"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestMmapReads verifies that a mapped database reads its mapped heap,
+// patches made to it since, and appends past it, and maps the file
+// again after compaction.
+func TestMmapReads(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("mmap unsupported")
+	}
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{MmapReads: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, lbl := range []string{"a", "b", "c"} {
+		if err := db.Set(lbl, lbl+"1"); err != nil {
+			t.Fatalf("Set(%s): %v", lbl, err)
+		}
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	m, ok := db.reader.(*mappedFile)
+	if !ok || len(m.data) == 0 {
+		t.Fatalf("reader = %T, want mapped file", db.reader)
+	}
+
+	// Retires a's heap record in place and appends past the mapping.
+	if err := db.Set("a", "a2"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if err := db.Delete("b"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	want := map[string]string{"a": "a2", "c": "c1"}
+	for lbl, content := range want {
+		if got, err := db.Get(lbl); err != nil || got != content {
+			t.Errorf("Get(%s) = %q, %v; want %q", lbl, got, err, content)
+		}
+	}
+	if _, err := db.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(b) err = %v, want ErrNotFound", err)
+	}
+	versions, err := Collect(db.History("a"))
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("History(a) = %d versions, %v; want 2", len(versions), err)
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if m2, ok := db.reader.(*mappedFile); !ok || m2 == m {
+		t.Fatalf("reader not remapped after compaction")
+	}
+	for lbl, content := range want {
+		if got, err := db.Get(lbl); err != nil || got != content {
+			t.Errorf("after compact Get(%s) = %q, %v; want %q", lbl, got, err, content)
+		}
+	}
+	mustVerify(t, db, VerifyOptions{})
+}
+
+// TestMmapReadsMemory verifies that the option is ignored for a memory
+// database rather than failing Open.
+func TestMmapReadsMemory(t *testing.T) {
+	db, err := Open(memoryPath, Config{MmapReads: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Set("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.Get("a"); err != nil || got != "1" {
+		t.Errorf("Get = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build unix || linux || darwin
+
+// mmap(2) implementation for Unix platforms.
+package folio
+
+import (
+	"os"
+	"syscall"
+)
+
+func mmap(f *os.File, n int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, n, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	if data == nil {
+		return nil
+	}
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+// Windows has no mmap(2); Config.MmapReads falls back to plain reads.
+package folio
+
+import (
+	"errors"
+	"os"
+)
+
+func mmap(f *os.File, n int) ([]byte, error) {
+	return nil, errors.New("mmap: not supported on windows")
+}
+
+func munmap(data []byte) error { return nil }
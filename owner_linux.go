@@ -0,0 +1,66 @@
+//go:build linux
+
+// Lock holders from /proc/locks, which lists every file lock on the
+// system with the PID that took it and the device and inode it is on:
+//
+//	1: FLOCK  ADVISORY  WRITE 2314 08:01:1835011 0 EOF
+//	1: -> FLOCK  ADVISORY  WRITE 2320 08:01:1835011 0 EOF
+//
+// The second line is a request queued behind the first. The device is
+// printed as hex major:minor, the inode in decimal.
+package folio
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockHolders lists the flock holders and waiters of the file described
+// by info.
+func lockHolders(info os.FileInfo) ([]LockHolder, error) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, fmt.Errorf("no inode for %s", info.Name())
+	}
+	// The kernel's encoding of a dev_t: 12 bits of major and 20 of
+	// minor, split around the low byte of the minor.
+	dev := uint64(st.Dev)
+	major := (dev>>8)&0xfff | (dev>>32)&^uint64(0xfff)
+	minor := dev&0xff | (dev>>12)&^uint64(0xff)
+	want := fmt.Sprintf("%02x:%02x:%d", major, minor, st.Ino)
+
+	f, err := os.Open("/proc/locks")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var holders []LockHolder
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		var h LockHolder
+		if len(fields) > 1 && fields[1] == "->" {
+			h.Waiting = true
+			fields = append(fields[:1], fields[2:]...)
+		}
+		if len(fields) < 6 || fields[1] != "FLOCK" || fields[5] != want {
+			continue
+		}
+		if fields[3] == "WRITE" {
+			h.Mode = LockExclusive
+		}
+		if h.PID, err = strconv.Atoi(fields[4]); err != nil {
+			continue
+		}
+		holders = append(holders, h)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return holders, nil
+}
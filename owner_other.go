@@ -0,0 +1,14 @@
+//go:build !linux
+
+// Without /proc/locks there is no portable way to learn who holds a
+// flock or LockFileEx lock; LockOwner reports errors.ErrUnsupported.
+package folio
+
+import (
+	"errors"
+	"os"
+)
+
+func lockHolders(os.FileInfo) ([]LockHolder, error) {
+	return nil, errors.ErrUnsupported
+}
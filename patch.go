@@ -0,0 +1,174 @@
+// JSON merge patches.
+//
+// A caller changing one field of a JSON document would otherwise Get it,
+// edit it, and Set it back, and lose any write another caller made in
+// between. Patch applies an RFC 7386 merge patch under the write lock
+// instead, so the document read and the version written are one step:
+// members of the patch replace those of the document, null removes one,
+// and objects are merged member by member, to any depth. A patch that is
+// not an object replaces the document outright.
+//
+// Members keep their place in the document, and new ones follow in the
+// patch's order, so a patched document reads like the one it replaces;
+// the result is written compact. The new version is an ordinary write:
+// it runs the hooks and Config.Validator, and keeps the document's
+// creation time and expiry.
+package folio
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// Patch applies the JSON merge patch to label's content and writes the
+// result as a new version. Returns ErrNotFound if label does not exist,
+// ErrInvalidPatch if patch is not JSON, and ErrNotJSON if the document's
+// content is not.
+func (db *DB) Patch(label string, patch []byte) (err error) {
+	defer db.observe(OpPatch, label, time.Now(), &err)
+
+	if err := db.checkLabel(label); err != nil {
+		return err
+	}
+	if !json.Valid(patch) {
+		return ErrInvalidPatch
+	}
+	return db.single(func() error {
+		return db.patch(label, patch)
+	})
+}
+
+// patch merges patch into label's current content. The write lock must
+// be held.
+func (db *DB) patch(label string, patch []byte) error {
+	sz, err := size(db.reader)
+	if err != nil {
+		return fmt.Errorf("patch: stat: %w", err)
+	}
+	result, idx, err := db.findIndex(db.id(label), label, sz)
+	if err != nil {
+		return fmt.Errorf("patch: %w", err)
+	}
+	if result == nil || idx.expired(now()) {
+		return ErrNotFound
+	}
+	data, err := line(db.reader, idx.Offset)
+	if err != nil {
+		return fmt.Errorf("patch: read record: %w", faultAt(label, idx.Offset, err))
+	}
+	record, err := db.decode(data)
+	if err != nil {
+		return fmt.Errorf("patch: %w", faultAt(label, idx.Offset, err))
+	}
+	if !json.Valid([]byte(record.Data)) {
+		return fmt.Errorf("patch: %s: %w", label, ErrNotJSON)
+	}
+
+	merged, err := mergePatch([]byte(record.Data), patch)
+	if err != nil {
+		return fmt.Errorf("patch: %w", err)
+	}
+	var out bytes.Buffer
+	if err := json.Compact(&out, merged); err != nil {
+		return fmt.Errorf("patch: %w", err)
+	}
+	content := out.String()
+	if err := db.checkDoc(label, content); err != nil {
+		return err
+	}
+	return db.setIf(label, content, idx.Expires, condExists, 0)
+}
+
+// member is one name and value of a JSON object, in document order.
+type member struct {
+	name  string
+	value json.RawMessage
+}
+
+// mergePatch returns target with patch applied as RFC 7386 specifies.
+// target may be nil, for a member the document does not have. Both must
+// be valid JSON.
+func mergePatch(target, patch []byte) ([]byte, error) {
+	changes, ok, err := members(patch)
+	if err != nil || !ok {
+		return patch, err
+	}
+	doc, ok, err := members(target)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		doc = nil // a value that is not an object is replaced by one
+	}
+	for _, c := range changes {
+		i := -1
+		for j, m := range doc {
+			if m.name == c.name {
+				i = j
+				break
+			}
+		}
+		if string(bytes.TrimSpace(c.value)) == "null" {
+			if i >= 0 {
+				doc = append(doc[:i], doc[i+1:]...)
+			}
+			continue
+		}
+		var cur []byte
+		if i >= 0 {
+			cur = doc[i].value
+		}
+		v, err := mergePatch(cur, c.value)
+		if err != nil {
+			return nil, err
+		}
+		if i >= 0 {
+			doc[i].value = v
+		} else {
+			doc = append(doc, member{c.name, v})
+		}
+	}
+
+	out := []byte{'{'}
+	for i, m := range doc {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		name, err := json.Marshal(m.name)
+		if err != nil {
+			return nil, err
+		}
+		out = append(append(append(out, name...), ':'), m.value...)
+	}
+	return append(out, '}'), nil
+}
+
+// members returns the members of a JSON object in order, or false if
+// raw is not an object.
+func members(raw []byte) ([]member, bool, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || raw[0] != '{' {
+		return nil, false, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil {
+		return nil, false, err
+	}
+	var out []member
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, false, err
+		}
+		name, _ := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, false, err
+		}
+		out = append(out, member{name, value})
+	}
+	return out, true, nil
+}
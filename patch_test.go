@@ -0,0 +1,98 @@
+package folio
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMergePatch runs the examples of RFC 7386, appendix A.
+func TestMergePatch(t *testing.T) {
+	tests := []struct{ target, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		got, err := mergePatch([]byte(tt.target), []byte(tt.patch))
+		if err != nil || string(got) != tt.want {
+			t.Errorf("mergePatch(%s, %s) = %s, %v; want %s", tt.target, tt.patch, got, err, tt.want)
+		}
+	}
+}
+
+// TestPatch verifies that Patch writes one new version with the patch
+// applied, keeping member order and expiry, and rejects what it cannot
+// apply without writing.
+func TestPatch(t *testing.T) {
+	db := openTestDB(t)
+
+	db.SetWithTTL("user", `{"name": "Ada", "email": "ada@example.com", "prefs": {"theme": "light", "lang": "en"}}`, time.Hour)
+	before, _ := db.Info("user")
+	if err := db.Patch("user", []byte(`{"email":null,"prefs":{"theme":"dark"},"age":36}`)); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := db.Get("user")
+	if want := `{"name":"Ada","prefs":{"theme":"dark","lang":"en"},"age":36}`; got != want {
+		t.Errorf("patched = %s, want %s", got, want)
+	}
+	if versions, _ := Collect(db.History("user")); len(versions) != 2 {
+		t.Errorf("History holds %d versions, want 2", len(versions))
+	}
+	after, _ := db.Info("user")
+	if after.Created != before.Created {
+		t.Errorf("Created changed from %d to %d", before.Created, after.Created)
+	}
+	sz, _ := size(db.reader)
+	if _, idx, err := db.findIndex(db.id("user"), "user", sz); err != nil || idx.Expires == 0 {
+		t.Errorf("Patch cleared the expiry: %+v, %v", idx, err)
+	}
+
+	if err := db.Patch("missing", []byte(`{}`)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Patch(missing) = %v, want ErrNotFound", err)
+	}
+	if err := db.Patch("user", []byte(`{"a":`)); !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("Patch with bad JSON = %v, want ErrInvalidPatch", err)
+	}
+	db.Set("text", "plain text")
+	if err := db.Patch("text", []byte(`{"a":1}`)); !errors.Is(err, ErrNotJSON) {
+		t.Errorf("Patch of text = %v, want ErrNotJSON", err)
+	}
+	if got, _ := db.Get("text"); got != "plain text" {
+		t.Errorf("rejected Patch wrote %q", got)
+	}
+}
+
+// TestPatchConcurrent verifies that concurrent patches to different
+// members of one document are all kept.
+func TestPatchConcurrent(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("counter", `{}`)
+
+	var wg sync.WaitGroup
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		wg.Go(func() {
+			if err := db.Patch("counter", []byte(`{"`+k+`":true}`)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+	got, err := GetJSON[map[string]bool](db, "counter")
+	if err != nil || len(got) != 8 {
+		t.Errorf("after 8 patches: %v, %v", got, err)
+	}
+}
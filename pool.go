@@ -0,0 +1,89 @@
+// Extra read handles for scans.
+//
+// Every read goes through the one read handle by default. ReadAt does
+// not move a shared position, so concurrent reads are safe, but the
+// kernel keeps its readahead state per open file: two scans interleaving
+// their reads on one handle look to it like a single reader jumping
+// about, and it stops reading ahead for either. With Config.ReaderPool
+// set to N, Open opens N more handles on the file, and each linear scan
+// (Search, each of its parallel workers, MatchLabel, All, List and its
+// variants, Glob, GetMany, and the sparse scan of a Get) borrows one for
+// its duration, so concurrent scans each read ahead on their own. A scan
+// that finds every handle borrowed uses the main one rather than wait.
+// Point reads stay on the main handle.
+//
+// The handles are opened again whenever compaction replaces the file.
+// No handle is lent while the write lock is held, so none is in use
+// then. The pool is ignored with Config.MmapReads, whose reads are
+// served from the mapping, and in memory.
+package folio
+
+import "fmt"
+
+// readerPool holds the handles of Config.ReaderPool.
+type readerPool struct {
+	all  []storage
+	free chan storage // the handles not lent out
+}
+
+// openPool opens the Config.ReaderPool handles on the current file.
+func (db *DB) openPool() error {
+	n := db.config.ReaderPool
+	if n <= 0 || db.root == nil || db.config.MmapReads {
+		return nil
+	}
+	p := &readerPool{free: make(chan storage, n)}
+	for range n {
+		f, err := db.root.Open(db.name)
+		if err != nil {
+			p.close()
+			return fmt.Errorf("reader pool: %w", err)
+		}
+		p.all = append(p.all, f)
+		p.free <- f
+	}
+	db.pool = p
+	return nil
+}
+
+// closePool closes the pool's handles. The write lock must be held, or
+// the DB not yet shared.
+func (db *DB) closePool() error {
+	if db.pool == nil {
+		return nil
+	}
+	err := db.pool.close()
+	db.pool = nil
+	return err
+}
+
+// close closes every handle of p.
+func (p *readerPool) close() error {
+	var first error
+	for _, f := range p.all {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// borrow returns a read handle for one scan: a free one from the pool,
+// or the main handle. The read lock must be held until it is given back.
+func (db *DB) borrow() storage {
+	if db.pool != nil {
+		select {
+		case f := <-db.pool.free:
+			return f
+		default:
+		}
+	}
+	return db.reader
+}
+
+// giveBack returns a handle borrow lent.
+func (db *DB) giveBack(f storage) {
+	if f != db.reader {
+		db.pool.free <- f
+	}
+}
@@ -0,0 +1,75 @@
+package folio
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestReaderPool verifies that scans borrow the pool's handles, fall
+// back to the main one when all are lent, and that compaction reopens
+// them on the new file.
+func TestReaderPool(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{ReaderPool: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := range 100 {
+		db.Set(fmt.Sprintf("doc/%d", i), fmt.Sprintf("content %d", i))
+	}
+
+	a, b, c := db.borrow(), db.borrow(), db.borrow()
+	if a == db.reader || b == db.reader || a == b {
+		t.Error("the pool did not lend two handles of its own")
+	}
+	if c != db.reader {
+		t.Error("with every handle lent, borrow did not fall back to the main one")
+	}
+	db.giveBack(c)
+	db.giveBack(b)
+	db.giveBack(a)
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	main, _ := db.reader.Stat()
+	for _, f := range db.pool.all {
+		st, err := f.Stat()
+		if err != nil || !os.SameFile(st, main) {
+			t.Errorf("pooled handle not on the compacted file: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Go(func() {
+			for i := range 20 {
+				lbl := fmt.Sprintf("doc/%d", (g*20+i)%100)
+				if got, err := db.Get(lbl); err != nil || got == "" {
+					t.Errorf("Get(%s) = %q, %v", lbl, got, err)
+				}
+				if labels, err := Collect(db.List()); err != nil || len(labels) < 100 {
+					t.Errorf("List = %d labels, %v", len(labels), err)
+				}
+				if ms, err := Collect(db.Search("content 42", SearchOptions{Parallelism: 2})); err != nil || len(ms) != 1 {
+					t.Errorf("Search = %d matches, %v", len(ms), err)
+				}
+			}
+		})
+	}
+	wg.Go(func() {
+		for i := range 20 {
+			db.Set(fmt.Sprintf("new/%d", i), "x")
+			if i%5 == 0 {
+				db.Compact()
+			}
+		}
+	})
+	wg.Wait()
+	if n := len(db.pool.free); n != 2 {
+		t.Errorf("%d handles returned to the pool, want 2", n)
+	}
+}
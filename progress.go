@@ -0,0 +1,50 @@
+// Progress reporting for long operations.
+//
+// A rebuild, Rehash, Verify, or Export of a multi-gigabyte file runs for
+// minutes, silently. Each takes an optional Progress func(done, total)
+// in its options, called on the operation's goroutine as the work
+// advances, so a CLI can draw a progress bar and a service can tell a
+// slow operation from a stalled one. Calls are spaced by progressStep
+// of work, however small the records, and the last, once the work is
+// complete, has done equal to total. The callback runs with the
+// operation's locks held, so it must not call the database.
+package folio
+
+// progressStep is the work, in bytes, between two Progress calls. An
+// Export counts documents, and reports after each one.
+const progressStep = 1 << 20
+
+// progress reports work done towards total to fn, every step of it.
+// The zero value, and a progress with a nil fn, report nothing.
+type progress struct {
+	fn    func(done, total int64)
+	total int64
+	step  int64
+	done  int64
+	next  int64 // report once done reaches this
+}
+
+func newProgress(fn func(done, total int64), total, step int64) *progress {
+	return &progress{fn: fn, total: total, step: step, next: step}
+}
+
+// add counts n more units of work done.
+func (p *progress) add(n int64) {
+	p.to(p.done + n)
+}
+
+// to records that done units of the work are complete.
+func (p *progress) to(done int64) {
+	p.done = min(done, p.total)
+	if p.fn != nil && p.done >= p.next && p.done < p.total {
+		p.fn(p.done, p.total)
+		p.next = p.done + p.step
+	}
+}
+
+// finish reports the work complete.
+func (p *progress) finish() {
+	if p.fn != nil {
+		p.fn(p.total, p.total)
+	}
+}
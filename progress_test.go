@@ -0,0 +1,93 @@
+// Progress callback tests.
+//
+// Each long operation runs over a file of a few megabytes, several
+// progress steps, and the calls it makes are checked as a progress bar
+// would read them: rising, within the total, and ending at it.
+package folio
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// progressCalls records Progress calls.
+type progressCalls [][2]int64
+
+func (p *progressCalls) record(done, total int64) {
+	*p = append(*p, [2]int64{done, total})
+}
+
+// check fails t unless the calls rise to a total of want, at least min
+// of them.
+func (p progressCalls) check(t *testing.T, what string, want int64, min int) {
+	t.Helper()
+	if len(p) < min {
+		t.Fatalf("%s: %d progress calls, want at least %d", what, len(p), min)
+	}
+	var last int64
+	for _, c := range p {
+		if c[1] != want || c[0] < last || c[0] > c[1] {
+			t.Fatalf("%s: progress calls %v, want rising to %d", what, p, want)
+		}
+		last = c[0]
+	}
+	if last != want {
+		t.Errorf("%s: last progress call at %d of %d", what, last, want)
+	}
+}
+
+// openProgress returns a database holding several megabytes in 30
+// documents.
+func openProgress(t *testing.T) *DB {
+	t.Helper()
+	db := openTestDB(t)
+	for i := range 30 {
+		db.Set(fmt.Sprintf("doc-%02d", i), strings.Repeat(fmt.Sprint(i), 100_000))
+	}
+	return db
+}
+
+// TestProgressRepair verifies a rebuild reports the heap as it copies it.
+func TestProgressRepair(t *testing.T) {
+	db := openProgress(t)
+	var calls progressCalls
+	if err := db.Repair(&CompactOptions{Progress: calls.record}); err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	calls.check(t, "Repair", db.heapEnd()-HeaderSize, 3)
+}
+
+// TestProgressVerify verifies Verify reports its walk through the file.
+func TestProgressVerify(t *testing.T) {
+	db := openProgress(t)
+	var calls progressCalls
+	if r := mustVerify(t, db, VerifyOptions{Progress: calls.record}); !r.OK() {
+		t.Fatalf("Verify: %v", r.Problems)
+	}
+	calls.check(t, "Verify", db.tail, 3)
+}
+
+// TestProgressRehash verifies Rehash reports the heap as it rebuilds it.
+func TestProgressRehash(t *testing.T) {
+	db := openProgress(t)
+	var calls progressCalls
+	if err := db.RehashWith(AlgFNV1a, RehashOptions{Progress: calls.record}); err != nil {
+		t.Fatalf("RehashWith: %v", err)
+	}
+	calls.check(t, "Rehash", db.heapEnd()-HeaderSize, 3)
+	if got, _ := db.Get("doc-07"); len(got) != 100_000 {
+		t.Errorf("Get after rehash = %d bytes", len(got))
+	}
+}
+
+// TestProgressExport verifies Export counts documents.
+func TestProgressExport(t *testing.T) {
+	db := openProgress(t)
+	var calls progressCalls
+	if err := db.Export(io.Discard, ExportOptions{Progress: calls.record}); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	calls.check(t, "Export", 30, 30)
+}
@@ -0,0 +1,404 @@
+// Quarantine of damaged lines.
+//
+// Without it, one bitrotted record breaks its label until someone runs
+// Repair: every Get fails with ErrCorruptRecord, and so does History,
+// however many healthy versions sit beside the damaged one. With
+// Config.Quarantine set, Get and History that fail on corruption take
+// the write lock, move every damaged line of the label out of the file,
+// and read again.
+//
+// A line is damaged if it does not decode: an index line that is not
+// valid JSON, a record that does not parse or fails its checksum, or a
+// version whose snapshot cannot be decompressed. A moved line is first
+// appended to the sidecar file name.quarantine, one JSON object per line
+// with the raw bytes, and synced; only then is it blanked in place,
+// keeping its newline so the lines around it keep their offsets. If it
+// held the document's current version, the index pointing at it is
+// erased too and the document reads as deleted, so Get returns
+// ErrNotFound and History the versions that survive. An index line that
+// is itself damaged is moved and, if its record is intact, a new index
+// appended for it, since a rebuild keeps only documents with an index. A
+// current record whose content decodes is never moved, even if its
+// snapshot in _h is damaged, since Get can still read it.
+//
+// RepairLabel does the same for one document on request, and instead of
+// leaving it deleted gives it back its newest surviving version, so one
+// damaged document need not cost a rewrite of the file.
+//
+// Quarantined lists what has been moved, for salvage by hand. Nothing
+// removes the sidecar; delete it once its lines are dealt with.
+package folio
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// quarantineExt is appended to the database's name for the sidecar.
+const quarantineExt = ".quarantine"
+
+// QuarantinedLine is a damaged line moved out of the file.
+type QuarantinedLine struct {
+	Label  string `json:"label"`  // the document that was being read
+	Offset int64  `json:"offset"` // where the line was in the file
+	Moved  int64  `json:"moved"`  // when it was moved, unix ms
+	Error  string `json:"error"`  // why it could not be read
+	Line   []byte `json:"line"`   // the line as it was, without its newline
+}
+
+// Quarantined returns the lines moved out of the file so far, oldest
+// first, whether or not Config.Quarantine is set now.
+func (db *DB) Quarantined() ([]QuarantinedLine, error) {
+	if err := db.blockRead(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+
+	if db.root == nil {
+		return slices.Clone(db.sidecar), nil
+	}
+	data, err := db.root.ReadFile(db.name + quarantineExt)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("quarantined: %w", err)
+	}
+	var lines []QuarantinedLine
+	for l := range bytes.Lines(data) {
+		var q QuarantinedLine
+		if err := json.Unmarshal(l, &q); err != nil {
+			return lines, fmt.Errorf("quarantined: %w", err)
+		}
+		lines = append(lines, q)
+	}
+	return lines, nil
+}
+
+// quarantineOn quarantines label's damaged lines if err says a read of
+// it found damage and Config.Quarantine is set. It reports whether any
+// were moved, in which case the read is worth retrying. A failure to
+// quarantine is logged and leaves the read's own error to be returned.
+func (db *DB) quarantineOn(label string, err error) bool {
+	if !db.config.Quarantine || db.config.ReadOnly || !corrupt(err) {
+		return false
+	}
+	if err := db.blockWrite(); err != nil {
+		return false
+	}
+	q, err := db.quarantine(label, false)
+	db.mu.Unlock()
+	db.lock.Unlock()
+	if err == nil {
+		err = db.durable()
+	}
+	if err != nil {
+		db.log.Error("quarantine failed", "label", label, "error", err)
+	}
+	return len(q.offsets) > 0
+}
+
+// RepairLabel repairs one document in place, for when Verify or a read
+// has found damage confined to it and a rebuild of the whole file is
+// more I/O than the damage deserves. Its damaged lines are moved to the
+// sidecar as Config.Quarantine would move them, whether or not that is
+// set. A current record left without an index is indexed again, and if
+// the current record itself was damaged, the newest version that
+// survives is appended as a clean record and index, so the document
+// reads as it was before its last write. A document with no version
+// left is deleted. Tags are kept. Returns ErrNotFound if label has no
+// current version and none of its lines were damaged.
+func (db *DB) RepairLabel(label string) (err error) {
+	defer db.observe(OpRepair, label, time.Now(), &err)
+
+	if err := db.blockWrite(); err != nil {
+		return err
+	}
+
+	q, err := db.quarantine(label, true)
+	if err == nil && q.current == nil && len(q.offsets) == 0 {
+		err = ErrNotFound
+	}
+
+	// Same pattern as Set: check threshold under lock, compact after release.
+	compact := err == nil && db.shouldCompact()
+	db.mu.Unlock()
+	db.lock.Unlock()
+
+	if err == nil {
+		err = db.durable()
+	}
+	if compact {
+		db.Compact()
+	}
+	return err
+}
+
+// quarantine moves every damaged line of label out of the file. A
+// current record that lost its index to the damage is indexed again. If
+// the current record itself was lost, with restore set the newest
+// surviving version takes its place, and otherwise the document is
+// deleted. The write lock must be held.
+func (db *DB) quarantine(label string, restore bool) (*quarantine, error) {
+	id := db.id(label)
+	q := &quarantine{db: db, label: label}
+	sz, err := size(db.reader)
+	if err != nil {
+		return q, fmt.Errorf("quarantine: stat: %w", err)
+	}
+
+	// Index lines that do not decode.
+	var indexes []Result
+	if r := db.scanIndex(id); r != nil {
+		indexes = append(indexes, *r)
+	}
+	indexes = append(indexes, sparse(db.reader, id, db.sparseStart(), sz, TypeIndex)...)
+	for _, r := range indexes {
+		if _, err := decodeIndex(r.Data); err != nil {
+			if err := q.move(r.Offset, r.Data, err); err != nil {
+				return q, err
+			}
+		}
+	}
+
+	// The current record, read through its index: its ID bytes may be
+	// too damaged for the scans below to find it.
+	res, idx, err := db.findIndex(id, label, sz)
+	if err != nil {
+		return q, fmt.Errorf("quarantine: %w", err)
+	}
+	if res != nil {
+		q.current, q.index = idx, res
+		data, err := line(db.reader, idx.Offset)
+		if err != nil {
+			return q, fmt.Errorf("quarantine: %w", err)
+		}
+		if _, err := db.decode(data); corrupt(err) {
+			if err := q.move(idx.Offset, data, err); err != nil {
+				return q, err
+			}
+		}
+	}
+
+	// Every other version: first lines that do not decode, then, in
+	// write order, versions that cannot be restored.
+	var found []placed
+	for _, r := range db.lines(id, sz) {
+		if q.done(r.Offset) {
+			continue
+		}
+		record, err := db.decode(r.Data)
+		if err != nil {
+			if err := q.move(r.Offset, r.Data, err); err != nil {
+				return q, err
+			}
+			continue
+		}
+		if (record.Type == TypeRecord || record.Type == TypeHistory) && db.same(record.Label, label) {
+			found = append(found, placed{record, r.Offset})
+		}
+	}
+	slices.SortFunc(found, func(a, b placed) int { return cmp.Compare(a.offset, b.offset) })
+	records := make([]*Record, len(found))
+	for i, f := range found {
+		records[i] = f.record
+	}
+	versions := db.chain(records)
+	for i, f := range found {
+		if f.record.Type == TypeRecord {
+			continue // it decoded, so Get can read it
+		}
+		if _, err := versions.version(i); corrupt(err) {
+			data, err2 := line(db.reader, f.offset)
+			if err2 != nil {
+				return q, fmt.Errorf("quarantine: %w", err2)
+			}
+			if err := q.move(f.offset, data, err); err != nil {
+				return q, err
+			}
+		}
+	}
+
+	if q.current != nil || !q.live {
+		return q, nil
+	}
+	return q, q.settle(found, versions, restore)
+}
+
+// placed is a decoded record and the offset of its line.
+type placed struct {
+	record *Record
+	offset int64
+}
+
+// quarantine is the state of one call to DB.quarantine.
+type quarantine struct {
+	db      *DB
+	label   string
+	current *Index  // the label's current index, while it stands
+	index   *Result // the line it was read from
+	live    bool    // a moved line was the label's current record or index
+	created int64   // the creation time of the current index, once erased
+	expires int64   // and its expiry
+	offsets []int64 // lines moved so far
+}
+
+// done reports whether the line at off has been moved.
+func (q *quarantine) done(off int64) bool {
+	return slices.Contains(q.offsets, off)
+}
+
+// move copies the line data at off to the sidecar, then blanks it. If it
+// held the current version, the document's index is erased as well.
+func (q *quarantine) move(off int64, data []byte, reason error) error {
+	db := q.db
+	entry := QuarantinedLine{
+		Label:  q.label,
+		Offset: off,
+		Moved:  now(),
+		Error:  reason.Error(),
+		Line:   slices.Clone(data),
+	}
+	if err := db.sidecarAppend(entry); err != nil {
+		return fmt.Errorf("quarantine: %w", err)
+	}
+	if err := db.writeAt(off, bytes.Repeat([]byte(" "), len(data))); err != nil {
+		return fmt.Errorf("quarantine: %w", err)
+	}
+	if db.sizes != nil {
+		db.sizes.lost()
+	}
+	q.offsets = append(q.offsets, off)
+	db.log.Warn("quarantined damaged line", "label", q.label, "offset", off, "error", reason)
+
+	if len(data) > TypePos && (data[TypePos] == '0'+TypeRecord || data[TypePos] == '0'+TypeIndex) && db.same(label(data), q.label) {
+		q.live = true
+	}
+	if q.current != nil && off == q.current.Offset {
+		if err := db.writeAt(q.index.Offset, bytes.Repeat([]byte(" "), q.index.Length)); err != nil {
+			return fmt.Errorf("quarantine: erase index: %w", err)
+		}
+		q.live, q.created, q.expires = true, q.current.Created, q.current.Expires
+		q.current = nil
+	}
+	return nil
+}
+
+// settle gives a document whose current record or index was moved a
+// current version again from the versions found, in write order: its
+// current record if that survived, or with restore set the newest
+// version that does. Failing both, the document is deleted.
+func (q *quarantine) settle(found []placed, versions *chain, restore bool) error {
+	db := q.db
+	created := q.created
+	if created == 0 && len(found) > 0 {
+		created = found[0].record.Timestamp
+	}
+	for i := len(found) - 1; i >= 0; i-- {
+		if found[i].record.Type != TypeRecord {
+			continue
+		}
+		r := found[i].record
+		return q.relink(&Index{
+			Type:      TypeIndex,
+			ID:        r.ID,
+			Offset:    found[i].offset,
+			Label:     r.Label,
+			Timestamp: r.Timestamp,
+			Created:   created,
+			Expires:   q.expires,
+		})
+	}
+	for i := len(found) - 1; restore && i >= 0; i-- {
+		if q.done(found[i].offset) {
+			continue
+		}
+		v, err := versions.version(i)
+		if err != nil {
+			continue
+		}
+		r := found[i].record
+		ts := max(now(), found[len(found)-1].record.Timestamp)
+		record := &Record{Type: TypeRecord, ID: r.ID, Label: r.Label, Timestamp: ts, Data: v.Data}
+		idx := &Index{Type: TypeIndex, ID: r.ID, Label: r.Label, Timestamp: ts, Created: created, Expires: q.expires}
+		if _, err := db.append(record, idx); err != nil {
+			return fmt.Errorf("quarantine: %w", err)
+		}
+		return q.indexed(idx)
+	}
+
+	// Nothing survives to be current: the document is lost.
+	if db.labels != nil {
+		db.labels.remove(q.label)
+	}
+	if err := db.dropTags(q.label); err != nil {
+		return fmt.Errorf("quarantine: %w", err)
+	}
+	db.unindex(q.label)
+	db.count.Add(^uint64(0)) // unsigned decrement
+	db.usage.writes.Add(1)
+	return nil
+}
+
+// relink appends idx for a current record that has lost its own.
+func (q *quarantine) relink(idx *Index) error {
+	db := q.db
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("quarantine: %w", err)
+	}
+	off, err := db.raw(data)
+	if err != nil {
+		return fmt.Errorf("quarantine: %w", err)
+	}
+	if db.smap != nil {
+		db.smap.add(db.fold(idx.Label), off, db.tail)
+	}
+	return q.indexed(idx)
+}
+
+// indexed records idx as the document's current index.
+func (q *quarantine) indexed(idx *Index) error {
+	db := q.db
+	q.current = idx
+	if db.labels != nil {
+		db.labels.put(idx.Label, idx.Expires)
+	}
+	db.usage.writes.Add(1)
+	if err := db.reindex(idx.Label); err != nil {
+		return fmt.Errorf("quarantine: %w", err)
+	}
+	return nil
+}
+
+// sidecarAppend adds entry to the sidecar and syncs it.
+func (db *DB) sidecarAppend(entry QuarantinedLine) error {
+	if db.root == nil {
+		db.sidecar = append(db.sidecar, entry)
+		return nil
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := db.root.OpenFile(db.name+quarantineExt, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return errors.Join(f.Sync(), f.Close())
+}
@@ -0,0 +1,302 @@
+// Quarantine tests.
+//
+// Each test damages a line the way bitrot would, reads through the
+// public API, and checks that the damaged line ended up in the sidecar
+// byte for byte, that the file reads cleanly around it, and that the
+// rest of the document survives.
+package folio
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// openQuarantine opens a database at path with Config.Quarantine set.
+func openQuarantine(t *testing.T, path string) *DB {
+	t.Helper()
+	db, err := Open(path, Config{Quarantine: true})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// damage flips a byte of the content of the record at off, so it still
+// parses but fails its checksum, and returns the line as damaged.
+func damage(t *testing.T, db *DB, off int64) []byte {
+	t.Helper()
+	data, err := line(db.reader, off)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := bytes.Index(data, []byte(`"_d":"`)) + 6
+	db.writeAt(off+int64(at), []byte("X"))
+	data, _ = line(db.reader, off)
+	return bytes.Clone(data)
+}
+
+// currentOffset returns the offset of label's current record.
+func currentOffset(t *testing.T, db *DB, label string) int64 {
+	t.Helper()
+	sz, _ := size(db.reader)
+	_, idx, err := db.findIndex(db.id(label), label, sz)
+	if err != nil || idx == nil {
+		t.Fatalf("findIndex(%s): %v", label, err)
+	}
+	return idx.Offset
+}
+
+// TestQuarantineGet verifies that a current record failing its checksum
+// is moved to the sidecar, after which the document reads as deleted,
+// keeps its earlier versions, and survives a reopen and a compaction.
+func TestQuarantineGet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db := openQuarantine(t, path)
+	db.Set("doc", "first")
+	db.Set("doc", "second")
+	db.Set("other", "fine")
+
+	off := currentOffset(t, db, "doc")
+	damaged := damage(t, db, off)
+
+	if _, err := db.Get("doc"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get of damaged record = %v, want ErrNotFound", err)
+	}
+	if got, _ := db.Get("other"); got != "fine" {
+		t.Errorf("Get(other) = %q", got)
+	}
+	versions, err := Collect(db.History("doc"))
+	if err != nil || len(versions) != 1 || versions[0].Data != "first" {
+		t.Errorf("History = %v, %v, want only the first version", versions, err)
+	}
+	if db.Count() != 1 {
+		t.Errorf("Count = %d, want 1", db.Count())
+	}
+
+	q, err := db.Quarantined()
+	if err != nil || len(q) != 1 {
+		t.Fatalf("Quarantined = %v, %v, want one line", q, err)
+	}
+	if q[0].Label != "doc" || q[0].Offset != off || !bytes.Equal(q[0].Line, damaged) || q[0].Error == "" {
+		t.Errorf("Quarantined[0] = %+v", q[0])
+	}
+	if blanked, _ := line(db.reader, off); len(bytes.TrimSpace(blanked)) != 0 {
+		t.Errorf("damaged line still in the file: %q", blanked)
+	}
+	if r := mustVerify(t, db, VerifyOptions{}); !r.OK() {
+		t.Errorf("Verify after quarantine: %v", r.Problems)
+	}
+
+	db.Close()
+	db = openQuarantine(t, path)
+	if q, _ := db.Quarantined(); len(q) != 1 {
+		t.Errorf("Quarantined after reopen = %d lines, want 1", len(q))
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if _, err := db.Get("doc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after compaction = %v, want ErrNotFound", err)
+	}
+	if got, _ := db.Get("other"); got != "fine" {
+		t.Errorf("Get(other) after compaction = %q", got)
+	}
+	if r := mustVerify(t, db, VerifyOptions{}); !r.OK() {
+		t.Errorf("Verify after compaction: %v", r.Problems)
+	}
+}
+
+// TestQuarantineHistory verifies that a damaged history line in the
+// compacted heap is moved aside, leaving History the versions around it
+// and Get the current one.
+func TestQuarantineHistory(t *testing.T) {
+	db := openQuarantine(t, filepath.Join(t.TempDir(), "test.folio"))
+	for _, v := range []string{"one", "two", "three"} {
+		db.Set("doc", v)
+	}
+	db.Compact()
+
+	records, offsets, err := db.located("doc")
+	if err != nil || len(records) != 3 {
+		t.Fatalf("located = %d records, %v", len(records), err)
+	}
+	db.writeAt(offsets[1]+34, []byte("!!!!"))
+
+	versions, err := Collect(db.History("doc"))
+	if err != nil || len(versions) != 2 || versions[0].Data != "one" || versions[1].Data != "three" {
+		t.Fatalf("History = %v, %v, want one and three", versions, err)
+	}
+	if got, err := db.Get("doc"); err != nil || got != "three" {
+		t.Errorf("Get = %q, %v, want three", got, err)
+	}
+	if q, _ := db.Quarantined(); len(q) != 1 || q[0].Offset != offsets[1] {
+		t.Errorf("Quarantined = %+v, want the middle version", q)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if versions, _ := Collect(db.History("doc")); len(versions) != 2 {
+		t.Errorf("History after compaction = %d versions, want 2", len(versions))
+	}
+}
+
+// TestQuarantineIndex verifies that a damaged index line is moved aside
+// and its intact record indexed again, so the document still reads.
+func TestQuarantineIndex(t *testing.T) {
+	db := openQuarantine(t, filepath.Join(t.TempDir(), "test.folio"))
+	db.Set("doc", "content")
+	db.Compact()
+	db.writeAt(db.indexStart()+34, []byte("!!!!"))
+
+	if got, err := db.Get("doc"); err != nil || got != "content" {
+		t.Fatalf("Get = %q, %v, want content", got, err)
+	}
+	if db.Count() != 1 {
+		t.Errorf("Count = %d, want 1", db.Count())
+	}
+	if q, _ := db.Quarantined(); len(q) != 1 || q[0].Offset != db.indexStart() {
+		t.Errorf("Quarantined = %+v, want the index line", q)
+	}
+	if r := mustVerify(t, db, VerifyOptions{}); !r.OK() {
+		t.Errorf("Verify: %v", r.Problems)
+	}
+}
+
+// TestQuarantineOff verifies that without Config.Quarantine the damage
+// is reported and nothing is moved.
+func TestQuarantineOff(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "content")
+	damage(t, db, currentOffset(t, db, "doc"))
+
+	if _, err := db.Get("doc"); !errors.Is(err, ErrChecksum) {
+		t.Errorf("Get = %v, want ErrChecksum", err)
+	}
+	if q, err := db.Quarantined(); err != nil || len(q) != 0 {
+		t.Errorf("Quarantined = %v, %v, want none", q, err)
+	}
+}
+
+// TestQuarantineMemory verifies that a memory database keeps its
+// quarantined lines in memory.
+func TestQuarantineMemory(t *testing.T) {
+	db, err := Open(memoryPath, Config{Quarantine: true})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	db.Set("doc", "content")
+	damage(t, db, currentOffset(t, db, "doc"))
+
+	if _, err := db.Get("doc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get = %v, want ErrNotFound", err)
+	}
+	if q, _ := db.Quarantined(); len(q) != 1 {
+		t.Errorf("Quarantined = %d lines, want 1", len(q))
+	}
+}
+
+// TestRepairLabel verifies that RepairLabel gives a document whose
+// current record was damaged its newest surviving version back as a new
+// version, keeping its tags, and leaves the rest of the file alone.
+func TestRepairLabel(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "first")
+	db.Set("doc", "second")
+	db.Tag("doc", "keep")
+	db.Set("other", "fine")
+	damage(t, db, currentOffset(t, db, "doc"))
+	tail := db.tail
+
+	if err := db.RepairLabel("doc"); err != nil {
+		t.Fatalf("RepairLabel: %v", err)
+	}
+	if got, err := db.Get("doc"); err != nil || got != "first" {
+		t.Errorf("Get = %q, %v, want first", got, err)
+	}
+	versions, err := Collect(db.History("doc"))
+	if err != nil || len(versions) != 2 || versions[0].Data != "first" || versions[1].Data != "first" {
+		t.Errorf("History = %v, %v, want first twice", versions, err)
+	}
+	if labels, _ := Collect(db.ByTag("keep")); len(labels) != 1 || labels[0] != "doc" {
+		t.Errorf("ByTag(keep) = %v, want doc", labels)
+	}
+	if db.Count() != 2 {
+		t.Errorf("Count = %d, want 2", db.Count())
+	}
+	if q, _ := db.Quarantined(); len(q) != 1 {
+		t.Errorf("Quarantined = %d lines, want 1", len(q))
+	}
+	if db.tail-tail > 1024 {
+		t.Errorf("RepairLabel appended %d bytes, want one version", db.tail-tail)
+	}
+	if r := mustVerify(t, db, VerifyOptions{}); !r.OK() {
+		t.Errorf("Verify: %v", r.Problems)
+	}
+}
+
+// TestRepairLabelIndex verifies that a document whose sorted index line
+// was damaged is indexed again in place, with its tags.
+func TestRepairLabelIndex(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "content")
+	db.Tag("doc", "keep")
+	db.Compact()
+	db.writeAt(db.indexStart()+34, []byte("!!!!"))
+	if _, err := db.Get("doc"); err == nil {
+		t.Fatal("Get of damaged index succeeded")
+	}
+
+	if err := db.RepairLabel("doc"); err != nil {
+		t.Fatalf("RepairLabel: %v", err)
+	}
+	if got, err := db.Get("doc"); err != nil || got != "content" {
+		t.Errorf("Get = %q, %v, want content", got, err)
+	}
+	if versions, _ := Collect(db.History("doc")); len(versions) != 1 {
+		t.Errorf("History = %d versions, want 1", len(versions))
+	}
+	if labels, _ := Collect(db.ByTag("keep")); len(labels) != 1 {
+		t.Errorf("ByTag(keep) = %v, want doc", labels)
+	}
+	if r := mustVerify(t, db, VerifyOptions{}); !r.OK() {
+		t.Errorf("Verify: %v", r.Problems)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if got, _ := db.Get("doc"); got != "content" {
+		t.Errorf("Get after compaction = %q", got)
+	}
+}
+
+// TestRepairLabelLost verifies that a document with no version left is
+// deleted, and that a healthy or missing one is left as it is.
+func TestRepairLabelLost(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "only")
+	db.Set("other", "fine")
+	damage(t, db, currentOffset(t, db, "doc"))
+
+	if err := db.RepairLabel("doc"); err != nil {
+		t.Fatalf("RepairLabel: %v", err)
+	}
+	if _, err := db.Get("doc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get = %v, want ErrNotFound", err)
+	}
+	if db.Count() != 1 {
+		t.Errorf("Count = %d, want 1", db.Count())
+	}
+	if err := db.RepairLabel("other"); err != nil {
+		t.Errorf("RepairLabel of healthy document: %v", err)
+	}
+	if err := db.RepairLabel("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RepairLabel(missing) = %v, want ErrNotFound", err)
+	}
+	if r := mustVerify(t, db, VerifyOptions{}); !r.OK() {
+		t.Errorf("Verify: %v", r.Problems)
+	}
+}
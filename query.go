@@ -0,0 +1,264 @@
+// Secondary indexes on JSON content fields.
+//
+// Documents are often JSON, and finding those whose "user.email" is a
+// given value otherwise means a Search over the whole file. CreateIndex
+// names a field by its path, reads every current document once to
+// extract it, and keeps the result in memory: a map from the field's
+// value to the labels that hold it. Every write through this handle
+// keeps the map current, and Query answers from it.
+//
+// A path is a dot-separated list of object keys, optionally preceded by
+// "$.", where a segment made only of digits indexes into an array:
+// "items.0.sku" is the sku of the first item. Values are compared by
+// their JSON encoding after decoding, so 42 and 42.0 are the same value,
+// and an object or array value matches only an equal one. Documents that
+// are not JSON, or lack the field, are left out of the index.
+//
+// Like the label trie, an index lives in memory only: it is built by
+// CreateIndex, not stored in the file, and must be created again after
+// each Open. It sees writes made through this handle, so Query checks
+// that each label it returns is still a current document, but a value
+// changed by another process is not seen until the index is recreated.
+package folio
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"iter"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	json "github.com/goccy/go-json"
+)
+
+// fieldIndex maps the values of one JSON field to the labels that hold
+// them.
+type fieldIndex struct {
+	path   []string
+	values map[string]map[string]bool // encoded value → labels
+	labels map[string]string          // label → encoded value
+}
+
+// parsePath splits a path such as "$.user.email" into its segments.
+func parsePath(path string) ([]string, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil, ErrInvalidPath
+	}
+	segs := strings.Split(path, ".")
+	if slices.Contains(segs, "") {
+		return nil, ErrInvalidPath
+	}
+	return segs, nil
+}
+
+// extract returns the encoded value at path in content, or false if
+// content is not JSON or has nothing there.
+func extract(content []byte, path []string) (string, bool) {
+	var v any
+	if err := json.Unmarshal(content, &v); err != nil {
+		return "", false
+	}
+	for _, seg := range path {
+		switch node := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = node[seg]; !ok {
+				return "", false
+			}
+		case []any:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			v = node[i]
+		default:
+			return "", false
+		}
+	}
+	key, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+	return string(key), true
+}
+
+// set records label's value, replacing any it had.
+func (f *fieldIndex) set(label, key string) {
+	f.remove(label)
+	if f.values[key] == nil {
+		f.values[key] = map[string]bool{}
+	}
+	f.values[key][label] = true
+	f.labels[label] = key
+}
+
+// remove forgets label.
+func (f *fieldIndex) remove(label string) {
+	key, ok := f.labels[label]
+	if !ok {
+		return
+	}
+	delete(f.labels, label)
+	delete(f.values[key], label)
+	if len(f.values[key]) == 0 {
+		delete(f.values, key)
+	}
+}
+
+// CreateIndex indexes the JSON field at path in every current document
+// under name, replacing any index of that name. It reads every document,
+// holding the read lock while it does.
+func (db *DB) CreateIndex(name, path string) error {
+	segs, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return ErrInvalidPath
+	}
+
+	db.beginScan()
+	defer db.endScan()
+	if err := db.blockRead(); err != nil {
+		return err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+
+	// Writers wait until the index is installed, so none is missed.
+	f := &fieldIndex{path: segs, values: map[string]map[string]bool{}, labels: map[string]string{}}
+	var readErr error
+	err = db.documents(false, func(d docLine) bool {
+		content, err := db.content(d)
+		if err != nil {
+			readErr = err
+			return false
+		}
+		if key, ok := extract(content, segs); ok {
+			f.set(string(unescape([]byte(d.label))), key) // scanned labels are still escaped
+		}
+		return true
+	})
+	if err = cmp.Or(readErr, err); err != nil {
+		return fmt.Errorf("createindex: %w", err)
+	}
+
+	db.fieldsMu.Lock()
+	defer db.fieldsMu.Unlock()
+	if db.fields == nil {
+		db.fields = map[string]*fieldIndex{}
+	}
+	db.fields[name] = f
+	return nil
+}
+
+// DropIndex discards the index called name, if there is one.
+func (db *DB) DropIndex(name string) {
+	db.fieldsMu.Lock()
+	defer db.fieldsMu.Unlock()
+	delete(db.fields, name)
+}
+
+// Query yields, in sorted order, the labels of current documents whose
+// field indexed as name holds value. value is compared by its JSON
+// encoding, so a string matches a JSON string and any Go number a JSON
+// number. Returns ErrNoIndex if there is no index called name.
+func (db *DB) Query(name string, value any) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		key, err := json.Marshal(value)
+		if err != nil {
+			yield("", fmt.Errorf("query: %w", err))
+			return
+		}
+		if err := db.blockRead(); err != nil {
+			yield("", err)
+			return
+		}
+		defer func() {
+			db.mu.RUnlock()
+			db.lock.Unlock()
+		}()
+
+		db.fieldsMu.RLock()
+		f, ok := db.fields[name]
+		var labels []string
+		if ok {
+			labels = slices.Sorted(maps.Keys(f.values[string(key)]))
+		}
+		db.fieldsMu.RUnlock()
+		if !ok {
+			yield("", ErrNoIndex)
+			return
+		}
+
+		for _, lbl := range labels {
+			if _, err := db.current(lbl); errors.Is(err, ErrNotFound) {
+				continue // expired, or removed by another process
+			} else if err != nil {
+				yield("", fmt.Errorf("query: %w", err))
+				return
+			}
+			if !yield(lbl, nil) {
+				return
+			}
+		}
+	}
+}
+
+// reindex updates every field index, and the full-text index, for label
+// after a write, reading the new version back. The write lock must be
+// held.
+func (db *DB) reindex(label string) error {
+	if len(db.fields) == 0 && db.text == nil {
+		return nil
+	}
+	idx, err := db.current(label)
+	if errors.Is(err, ErrNotFound) {
+		db.unindex(label)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reindex: %w", err)
+	}
+	data, err := line(db.reader, idx.Offset)
+	if err != nil {
+		return fmt.Errorf("reindex: read record: %w", faultAt(label, idx.Offset, err))
+	}
+	record, err := db.decode(data)
+	if err != nil {
+		return fmt.Errorf("reindex: %w", faultAt(label, idx.Offset, err))
+	}
+	for _, f := range db.fields {
+		if key, ok := extract([]byte(record.Data), f.path); ok {
+			f.set(label, key)
+		} else {
+			f.remove(label)
+		}
+	}
+	if db.text != nil {
+		if record.Binary {
+			db.text.remove(label)
+		} else {
+			db.text.set(label, record.Data)
+		}
+	}
+	return nil
+}
+
+// unindex removes label from every field index, and the full-text
+// index, after its document is deleted or renamed away. The write lock
+// must be held.
+func (db *DB) unindex(label string) {
+	for _, f := range db.fields {
+		f.remove(label)
+	}
+	if db.text != nil {
+		db.text.remove(label)
+	}
+}
@@ -0,0 +1,92 @@
+// Secondary index tests.
+//
+// An index is built once from the file and then kept current by every
+// write, so each test checks Query after the kinds of write that add,
+// change, or remove a document's value.
+package folio
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// query collects Query or fails the test.
+func query(t *testing.T, db *DB, name string, value any) []string {
+	t.Helper()
+	labels, err := Collect(db.Query(name, value))
+	if err != nil {
+		t.Fatalf("Query(%q, %v): %v", name, value, err)
+	}
+	return labels
+}
+
+// TestQuery verifies that an index built over existing documents is
+// kept current by Set, Delete, Rename, a Txn, and SetReader.
+func TestQuery(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("u/1", `{"user":{"name":"ann","age":30},"tags":["a","b"]}`)
+	db.Set("u/2", `{"user":{"name":"bob","age":30}}`)
+	db.Set("u/3", `{"user":{"name":"ann","age":41.0}}`)
+	db.Set("plain", "not json")
+
+	if err := db.CreateIndex("name", "$.user.name"); err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+	if err := db.CreateIndex("age", "user.age"); err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+	if err := db.CreateIndex("tag0", "tags.0"); err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+
+	if got, want := query(t, db, "name", "ann"), []string{"u/1", "u/3"}; !slices.Equal(got, want) {
+		t.Errorf("name=ann: %v, want %v", got, want)
+	}
+	if got, want := query(t, db, "age", 41), []string{"u/3"}; !slices.Equal(got, want) {
+		t.Errorf("age=41: %v, want %v", got, want)
+	}
+	if got, want := query(t, db, "tag0", "a"), []string{"u/1"}; !slices.Equal(got, want) {
+		t.Errorf("tag0=a: %v, want %v", got, want)
+	}
+
+	db.Set("u/2", `{"user":{"name":"ann"}}`)
+	db.Delete("u/1")
+	db.Rename("u/3", "u/33")
+	db.Txn(func(tx *Txn) error {
+		return tx.Set("u/4", `{"user":{"name":"ann"}}`)
+	})
+	if got, want := query(t, db, "name", "ann"), []string{"u/2", "u/33", "u/4"}; !slices.Equal(got, want) {
+		t.Errorf("name=ann after writes: %v, want %v", got, want)
+	}
+	if got := query(t, db, "name", "bob"); len(got) != 0 {
+		t.Errorf("name=bob after update: %v, want none", got)
+	}
+	if got := query(t, db, "age", 30); len(got) != 0 {
+		t.Errorf("age=30 after update and delete: %v, want none", got)
+	}
+
+	db.Compact()
+	if got, want := query(t, db, "name", "ann"), []string{"u/2", "u/33", "u/4"}; !slices.Equal(got, want) {
+		t.Errorf("name=ann after compaction: %v, want %v", got, want)
+	}
+}
+
+// TestQueryErrors verifies path validation and queries of an index
+// that does not exist or has been dropped.
+func TestQueryErrors(t *testing.T) {
+	db := openTestDB(t)
+	for _, path := range []string{"", "$", "a..b", "a."} {
+		if err := db.CreateIndex("x", path); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("CreateIndex(%q) = %v, want ErrInvalidPath", path, err)
+		}
+	}
+	if _, err := Collect(db.Query("missing", "v")); !errors.Is(err, ErrNoIndex) {
+		t.Errorf("Query(missing) = %v, want ErrNoIndex", err)
+	}
+	db.CreateIndex("x", "a")
+	db.DropIndex("x")
+	if _, err := Collect(db.Query("x", "v")); !errors.Is(err, ErrNoIndex) {
+		t.Errorf("Query after DropIndex = %v, want ErrNoIndex", err)
+	}
+}
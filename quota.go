@@ -0,0 +1,80 @@
+// Quotas on file size and versions per document.
+//
+// Config.MaxDatabaseSize refuses any append that would take the file
+// past that many bytes, so a runaway writer fills its quota rather than
+// the disk. Every write that grows the file is checked before anything
+// is written, streamed records included, and fails with
+// ErrQuotaExceeded. Writes that only patch the file in place, such as
+// Delete, Touch, and a same-length Rename, are never refused, so space
+// can always be given back. A delete's tombstone still grows the file
+// by a line. With auto-compaction on, a single-document Set, Create,
+// Update, or SetWithTTL refused for size compacts first and tries once
+// more if that made the file smaller; a Batch, Txn, or Import is not
+// retried, as it may have written part of its work.
+//
+// Config.MaxVersionsPerDocument refuses a write that would give a
+// document more versions, current one included, than that. The count
+// is of the versions the file holds, so Config.HistoryRetention, which
+// drops old ones at compaction, is what makes room again.
+package folio
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errFull marks a write refused for Config.MaxDatabaseSize, which a
+// compaction may make room for.
+var errFull = fmt.Errorf("%w: file size", ErrQuotaExceeded)
+
+// room returns an error if appending n bytes at the tail would take the
+// file past Config.MaxDatabaseSize. The write lock must be held.
+func (db *DB) room(n int64) error {
+	if limit := db.config.MaxDatabaseSize; limit > 0 && db.tail+n > limit {
+		return fmt.Errorf("%w: %d bytes at %d would pass %d", errFull, n, db.tail, limit)
+	}
+	return nil
+}
+
+// versionRoom returns an error if label, with ID id, already has
+// Config.MaxVersionsPerDocument versions in a file of sz bytes. The
+// write lock must be held.
+func (db *DB) versionRoom(id, label string, sz int64) error {
+	limit := db.config.MaxVersionsPerDocument
+	if limit <= 0 {
+		return nil
+	}
+	n := 0
+	for _, r := range db.lines(id, sz) {
+		ln := r.Data
+		if len(ln) < MinRecordSize || ln[TypePos] != '0'+TypeRecord && ln[TypePos] != '0'+TypeHistory {
+			continue
+		}
+		if db.same(string(unescape(between(ln, `"_l":"`, `"`))), label) {
+			n++
+		}
+	}
+	if n >= limit {
+		return fmt.Errorf("%w: %s has %d versions", ErrQuotaExceeded, label, n)
+	}
+	return nil
+}
+
+// compactedRoom reports whether err refused a write for
+// Config.MaxDatabaseSize and, auto-compaction being on, a compaction
+// has since made the file smaller, so the write is worth trying again.
+// No lock may be held.
+func (db *DB) compactedRoom(err error) bool {
+	if !errors.Is(err, errFull) {
+		return false
+	}
+	db.mu.RLock()
+	on, before := db.header.State[stThreshold] > 0, db.tail
+	db.mu.RUnlock()
+	if !on || db.Compact() != nil {
+		return false
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.tail < before
+}
@@ -0,0 +1,111 @@
+package folio
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMaxDatabaseSize verifies that a write that would pass the limit is
+// refused with ErrQuotaExceeded and leaves the file as it was, and that
+// space can still be given back with Delete.
+func TestMaxDatabaseSize(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{MaxDatabaseSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Set("small", "fits"); err != nil {
+		t.Fatalf("Set under the limit: %v", err)
+	}
+	before := db.tail
+	err = db.Set("big", strings.Repeat("x", 8192))
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Set past the limit = %v, want ErrQuotaExceeded", err)
+	}
+	if db.tail != before {
+		t.Errorf("refused Set grew the file from %d to %d", before, db.tail)
+	}
+	if _, err := db.Get("big"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(big) = %v, want ErrNotFound", err)
+	}
+
+	err = db.SetReader("streamed", strings.NewReader(strings.Repeat("y", 8192)))
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("streamed Set past the limit = %v, want ErrQuotaExceeded", err)
+	}
+	if db.tail != before {
+		t.Errorf("refused stream left the file at %d, want %d", db.tail, before)
+	}
+
+	if err := db.Delete("small"); err != nil {
+		t.Errorf("Delete at the limit: %v", err)
+	}
+}
+
+// TestMaxDatabaseSizeCompacts verifies that with auto-compaction on, a
+// Set refused for size compacts the file and succeeds if that made room.
+func TestMaxDatabaseSizeCompacts(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{
+		MaxDatabaseSize:  4096,
+		AutoCompact:      1000,
+		HistoryRetention: Retention{MaxVersions: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Far more rewrites than fit without dropping the history.
+	content := strings.Repeat("z", 256)
+	for i := range 50 {
+		if err := db.Set("doc", fmt.Sprint(i, content)); err != nil {
+			t.Fatalf("Set %d: %v", i, err)
+		}
+	}
+	if db.tail > 4096 {
+		t.Errorf("file is %d bytes, past the limit", db.tail)
+	}
+	// Without auto-compaction the same writes are refused.
+	db.header.State[stThreshold] = 0
+	for i := range 50 {
+		if err = db.Set("doc", fmt.Sprint(i, content)); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Set without auto-compaction = %v, want ErrQuotaExceeded", err)
+	}
+}
+
+// TestMaxVersionsPerDocument verifies that a document may be written up
+// to the limit and no further, while other documents are unaffected.
+func TestMaxVersionsPerDocument(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{MaxVersionsPerDocument: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i := range 3 {
+		if err := db.Set("doc", fmt.Sprint("v", i)); err != nil {
+			t.Fatalf("Set %d: %v", i, err)
+		}
+	}
+	if err := db.Set("doc", "v3"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("fourth Set = %v, want ErrQuotaExceeded", err)
+	}
+	err = db.Txn(func(tx *Txn) error { return tx.Set("doc", "v3") })
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("fourth Set in a Txn = %v, want ErrQuotaExceeded", err)
+	}
+	if got, _ := db.Get("doc"); got != "v2" {
+		t.Errorf("Get(doc) = %q, want v2", got)
+	}
+	if err := db.Set("other", "v0"); err != nil {
+		t.Errorf("Set(other): %v", err)
+	}
+}
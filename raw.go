@@ -0,0 +1,84 @@
+// Raw record iteration for tooling.
+//
+// Scan walks the file after the header line by line, in file order, and
+// yields each line as it is on disk with its type and position, so dump
+// tools, checkers, and converters can be written against the file format
+// (see PORTING.md) without the rest of the API deciding what they see.
+// Nothing is parsed or verified: a retired record is yielded as the
+// history record its type byte now says it is, and a blanked line as
+// spaces if asked for.
+package folio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+)
+
+// ScanOptions controls which lines Scan yields.
+type ScanOptions struct {
+	// Types limits the records yielded to these _r values. Empty yields
+	// every type.
+	Types []int
+
+	// Blank also yields the lines that are not records, such as the
+	// spaces left where a record was blanked. Their Type is 0, and Types
+	// does not filter them.
+	Blank bool
+}
+
+// RawRecord is one line of the file.
+type RawRecord struct {
+	Type   int    // _r, or 0 for a line that is not a record
+	Offset int64  // byte offset of the line's first byte
+	Length int    // bytes in the line, not counting its newline
+	Raw    []byte // the line, without its newline
+}
+
+// Scan yields every line after the header in file order. It holds the
+// read lock until the loop ends, so writers wait for it. A line longer
+// than Config.MaxRecordSize ends the scan with an error.
+func (db *DB) Scan(opts ScanOptions) iter.Seq2[RawRecord, error] {
+	return func(yield func(RawRecord, error) bool) {
+		db.beginScan()
+		defer db.endScan()
+		if err := db.blockRead(); err != nil {
+			yield(RawRecord{}, err)
+			return
+		}
+		defer func() {
+			db.mu.RUnlock()
+			db.lock.Unlock()
+		}()
+
+		sz, err := size(db.reader)
+		if err != nil {
+			yield(RawRecord{}, fmt.Errorf("scan: stat: %w", err))
+			return
+		}
+		scanner := bufio.NewScanner(io.NewSectionReader(db.reader, HeaderSize, sz-HeaderSize))
+		scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
+		off := int64(HeaderSize)
+		for scanner.Scan() {
+			ln := scanner.Bytes()
+			at := off
+			off += int64(len(ln)) + 1
+
+			typ := 0
+			if valid(ln) && len(ln) >= MinRecordSize && ln[TypePos] >= '0' && ln[TypePos] <= '9' {
+				typ = int(ln[TypePos] - '0')
+			}
+			if typ == 0 && !opts.Blank || typ != 0 && len(opts.Types) > 0 && !slices.Contains(opts.Types, typ) {
+				continue
+			}
+			if !yield(RawRecord{Type: typ, Offset: at, Length: len(ln), Raw: slices.Clone(ln)}, nil) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			yield(RawRecord{Offset: off}, fmt.Errorf("scan: line at %d: %w", off, err))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package folio
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	db, err := Open(":memory:", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Set("a", "one")
+	db.Set("a", "two")
+	db.Set("b", "three")
+	db.Delete("b")
+
+	var all []RawRecord
+	for r, err := range db.Scan(ScanOptions{Blank: true}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, r)
+	}
+	off := int64(HeaderSize)
+	counts := map[int]int{}
+	for _, r := range all {
+		if r.Offset != off {
+			t.Fatalf("record at %d, want %d", r.Offset, off)
+		}
+		got, err := line(db.reader, r.Offset)
+		if err != nil || !bytes.Equal(got, r.Raw) || r.Length != len(r.Raw) {
+			t.Errorf("record at %d = %q, file has %q", r.Offset, r.Raw, got)
+		}
+		off += int64(r.Length) + 1
+		counts[r.Type]++
+	}
+	if off != db.tail {
+		t.Errorf("scan ended at %d, file is %d bytes", off, db.tail)
+	}
+	if counts[TypeHistory] != 2 || counts[TypeRecord] != 1 || counts[0] == 0 {
+		t.Errorf("records by type = %v", counts)
+	}
+
+	n := 0
+	for r, err := range db.Scan(ScanOptions{Types: []int{TypeHistory}}) {
+		if err != nil || r.Type != TypeHistory {
+			t.Fatalf("filtered scan yielded %d, %v", r.Type, err)
+		}
+		n++
+	}
+	if n != 2 {
+		t.Errorf("history records = %d, want 2", n)
+	}
+}
@@ -3,19 +3,26 @@
 // Every record is a single JSON line terminated by '\n'. These functions
 // read individual lines, find record boundaries, and query file size —
 // all via SectionReader or ReadAt so that concurrent readers sharing a
-// single *os.File do not interfere with each other's offsets.
+// single handle do not interfere with each other's offsets.
 package folio
 
 import (
 	"bufio"
+	"bytes"
 	"io"
 	"os"
 )
 
+// blockSize is how much a binary search step reads at once. A lookup
+// that read a byte per call to find a record boundary paid a syscall for
+// every byte of the record it landed in; one block holds the boundary
+// and, for most records, the whole line after it.
+const blockSize = 4096
+
 // line reads the record starting at offset up to the next newline.
 // SectionReader is used so the read is bounded by file size and does not
 // affect the shared file position.
-func line(f *os.File, offset int64) ([]byte, error) {
+func line(f storage, offset int64) ([]byte, error) {
 	info, err := f.Stat()
 	if err != nil {
 		return nil, err
@@ -42,7 +49,7 @@ func line(f *os.File, offset int64) ([]byte, error) {
 // align finds the next newline at or after offset, returning its byte
 // position. Binary search lands at an arbitrary byte, so align is called
 // to advance to the nearest record boundary before reading a pivot.
-func align(f *os.File, offset int64) (int64, error) {
+func align(f storage, offset int64) (int64, error) {
 	info, err := f.Stat()
 	if err != nil {
 		return -1, err
@@ -72,7 +79,66 @@ func align(f *os.File, offset int64) (int64, error) {
 	}
 }
 
-func size(f *os.File) (int64, error) {
+// lineAfter returns the line that begins after the first newline at or
+// after pos, and its offset, or -1 if no line begins before end. It reads
+// one block from pos, and reads on past it only for a record too long to
+// fit.
+func lineAfter(f storage, pos, end int64) ([]byte, int64, error) {
+	if pos >= end {
+		return nil, -1, nil
+	}
+	buf := make([]byte, min(end-pos, blockSize))
+	n, err := f.ReadAt(buf, pos)
+	if err != nil && err != io.EOF {
+		return nil, -1, err
+	}
+	buf = buf[:n]
+
+	i := bytes.IndexByte(buf, '\n')
+	if i < 0 {
+		if n < blockSize || pos+int64(n) >= end {
+			return nil, -1, nil
+		}
+		nl, err := align(f, pos+int64(n))
+		if err != nil || nl < 0 || nl+1 >= end {
+			return nil, -1, err
+		}
+		data, err := line(f, nl+1)
+		return data, nl + 1, err
+	}
+	start := pos + int64(i) + 1
+	if start >= end {
+		return nil, -1, nil
+	}
+	if j := bytes.IndexByte(buf[i+1:], '\n'); j >= 0 {
+		return bytes.Clone(buf[i+1 : i+1+j]), start, nil
+	}
+	data, err := line(f, start)
+	return data, start, err
+}
+
+// lastNewline returns the position of the last newline in [lo, hi), or
+// -1 if there is none, reading back from hi a block at a time.
+func lastNewline(f storage, lo, hi int64) (int64, error) {
+	var buf [blockSize]byte
+	for hi > lo {
+		n := min(hi-lo, blockSize)
+		b := buf[:n]
+		if m, err := f.ReadAt(b, hi-n); m < len(b) {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return -1, err
+		}
+		if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+			return hi - n + int64(i), nil
+		}
+		hi -= n
+	}
+	return -1, nil
+}
+
+func size(f storage) (int64, error) {
 	info, err := f.Stat()
 	if err != nil {
 		return 0, err
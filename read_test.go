@@ -17,6 +17,7 @@ package folio
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -289,3 +290,47 @@ func TestPositionAfterRead(t *testing.T) {
 		t.Errorf("after read: position = %d, want 3", pos)
 	}
 }
+
+// TestLineAfter verifies that lineAfter returns the line following the
+// first newline from a mid-line position, including one too long to fit
+// in the block it reads first, and nothing when that line starts at or
+// past the end of the range.
+func TestLineAfter(t *testing.T) {
+	long := strings.Repeat("x", 2*blockSize)
+	f := createTestFile(t, "first\nsecond\n"+long+"\nlast\n")
+
+	data, at, err := lineAfter(f, 2, fsize(t, f))
+	if err != nil || at != 6 || string(data) != "second" {
+		t.Errorf("lineAfter(2) = %q at %d, %v; want second at 6", data, at, err)
+	}
+	data, at, err = lineAfter(f, 8, fsize(t, f))
+	if err != nil || at != 13 || string(data) != long {
+		t.Errorf("lineAfter(8) = %d bytes at %d, %v; want the long line at 13", len(data), at, err)
+	}
+	data, at, err = lineAfter(f, 20, fsize(t, f))
+	if err != nil || string(data) != "last" {
+		t.Errorf("lineAfter inside the long line = %q at %d, %v; want last", data, at, err)
+	}
+	if _, at, _ := lineAfter(f, 2, 6); at != -1 {
+		t.Errorf("lineAfter past the range = %d, want -1", at)
+	}
+}
+
+// TestLastNewline verifies that lastNewline finds the last newline
+// before a position across more than one block, and reports -1 when the
+// range has none.
+func TestLastNewline(t *testing.T) {
+	long := strings.Repeat("x", 3*blockSize)
+	f := createTestFile(t, "a\n"+long+"\n")
+	end := fsize(t, f)
+
+	if nl, err := lastNewline(f, 0, end-1); err != nil || nl != 1 {
+		t.Errorf("lastNewline = %d, %v; want 1", nl, err)
+	}
+	if nl, err := lastNewline(f, 0, end); err != nil || nl != end-1 {
+		t.Errorf("lastNewline to the end = %d, %v; want %d", nl, err, end-1)
+	}
+	if nl, err := lastNewline(f, 2, end-1); err != nil || nl != -1 {
+		t.Errorf("lastNewline in the long line = %d, %v; want -1", nl, err)
+	}
+}
@@ -0,0 +1,284 @@
+// Most recently modified documents.
+//
+// Recent returns the documents written last, newest first, by the _ts of
+// their index lines. Without help that is a scan of every index line,
+// keeping the newest n in a heap as it goes. When enabled
+// (Config.RecentDocs), a heap of that many documents is filled by the
+// first call and then kept up to date by every index line this handle
+// appends, and by Touch and same-length Rename, which patch lines in
+// place, so a "recent files" list costs a lookup per document listed.
+//
+// Deletes and renames are not tracked: each kept document is looked up
+// when Recent lists it, and one that has gone, or changed since without
+// passing through this handle, is dropped. The heap holds every current
+// document newer than the oldest it has let go, so while at least n of
+// them are still current, they are the newest n. A call that finds
+// fewer scans again. Like the label trie, it only sees writes made
+// through its own handle; a rebuild empties it.
+package folio
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// recent is a document's label and the _ts of its current version.
+type recent struct {
+	label string
+	ts    int64
+}
+
+// older reports whether a sorts after b in Recent's order: by timestamp,
+// newest first, then by label.
+func (a recent) older(b recent) bool {
+	return a.ts < b.ts || a.ts == b.ts && a.label > b.label
+}
+
+// recentHeap is a min-heap of documents, oldest at the root.
+type recentHeap []recent
+
+func (h recentHeap) Len() int           { return len(h) }
+func (h recentHeap) Less(i, j int) bool { return h[i].older(h[j]) }
+func (h recentHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *recentHeap) Push(x any)        { *h = append(*h, x.(recent)) }
+func (h *recentHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// keep pushes r, dropping the oldest once more than limit are held. It
+// reports whether one was dropped.
+func (h *recentHeap) keep(r recent, limit int) bool {
+	heap.Push(h, r)
+	if h.Len() <= limit {
+		return false
+	}
+	heap.Pop(h)
+	return true
+}
+
+// newest returns the documents held, newest first.
+func (h recentHeap) newest() []recent {
+	docs := slices.Clone(h)
+	slices.SortFunc(docs, func(a, b recent) int {
+		if a.older(b) {
+			return 1
+		}
+		return -1
+	})
+	return docs
+}
+
+// recents tracks the newest documents for Config.RecentDocs. docs maps
+// each label held to its timestamp; heap orders them, and may also hold
+// entries since replaced or dropped from docs, which are skipped when
+// they reach the root.
+type recents struct {
+	mu     sync.Mutex
+	limit  int
+	filled bool   // a scan has filled docs; until then writes are ignored
+	all    bool   // docs holds every current document
+	floor  recent // every current document newer than this is in docs
+	docs   map[string]int64
+	heap   recentHeap
+}
+
+func newRecents(limit int) *recents {
+	return &recents{limit: limit, docs: map[string]int64{}}
+}
+
+// put notes that label's current version was written at ts.
+func (r *recents) put(label string, ts int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.filled {
+		return
+	}
+	doc := recent{label, ts}
+	if !r.all && !r.floor.older(doc) {
+		delete(r.docs, label)
+		return
+	}
+	r.docs[label] = ts
+	heap.Push(&r.heap, doc)
+	for len(r.docs) > r.limit {
+		d := heap.Pop(&r.heap).(recent)
+		if ts, ok := r.docs[d.label]; ok && ts == d.ts {
+			delete(r.docs, d.label)
+			r.floor, r.all = d, false
+		}
+	}
+	// Replaced entries pile up under a document rewritten again and
+	// again; rebuild once they outnumber the live ones.
+	if len(r.heap) > 2*r.limit {
+		r.rebuild()
+	}
+}
+
+// rebuild remakes the heap from docs. The caller holds r.mu.
+func (r *recents) rebuild() {
+	r.heap = r.heap[:0]
+	for lbl, ts := range r.docs {
+		r.heap = append(r.heap, recent{lbl, ts})
+	}
+	heap.Init(&r.heap)
+}
+
+// appended notes the index lines among lines, just written.
+func (r *recents) appended(lines []byte) {
+	for ln := range bytes.SplitSeq(lines, []byte{'\n'}) {
+		if len(ln) >= MinRecordSize && ln[TypePos] == '0'+TypeIndex {
+			ts, _ := strconv.ParseInt(string(ln[TSStart:TSEnd]), 10, 64)
+			r.put(string(unescape([]byte(label(ln)))), ts)
+		}
+	}
+}
+
+// fill replaces the documents held with the result of a scan: the
+// newest kept, and whether they were all there were. The caller holds
+// r.mu.
+func (r *recents) fill(kept recentHeap, all bool) {
+	clear(r.docs)
+	for _, d := range kept {
+		r.docs[d.label] = d.ts
+	}
+	r.heap = slices.Clone(kept)
+	r.filled, r.all = true, all
+	if !all {
+		r.floor = kept[0] // the root: the oldest kept
+	}
+}
+
+// drop forgets label. The caller holds r.mu.
+func (r *recents) drop(label string) {
+	delete(r.docs, label)
+}
+
+// reset empties the heap after a rebuild; the next Recent scans.
+func (r *recents) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.filled = false
+	clear(r.docs)
+	r.heap = nil
+}
+
+// Recent returns the n most recently modified documents, newest first;
+// documents written in the same millisecond are ordered by label. With
+// Config.RecentDocs of at least n it is answered from memory (see
+// above); otherwise the index lines are scanned. It returns fewer than n
+// if there are fewer documents.
+func (db *DB) Recent(n int) ([]DocInfo, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	db.beginScan()
+	defer db.endScan()
+	if err := db.blockRead(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+
+	if db.recent == nil || n > db.recent.limit {
+		kept, _, err := db.scanRecent(n)
+		if err != nil {
+			return nil, err
+		}
+		return db.recentInfo(nil, kept.newest(), n)
+	}
+
+	r := db.recent
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.filled {
+		docs := make(recentHeap, 0, len(r.docs))
+		for lbl, ts := range r.docs {
+			docs = append(docs, recent{lbl, ts})
+		}
+		infos, err := db.recentInfo(r, docs.newest(), n)
+		if err != nil || len(infos) == n || r.all {
+			return infos, err
+		}
+	}
+	kept, all, err := db.scanRecent(r.limit)
+	if err != nil {
+		return nil, err
+	}
+	r.fill(kept, all)
+	return db.recentInfo(r, kept.newest(), n)
+}
+
+// recentInfo looks up docs in order and returns the first n that are
+// still current as they were noted, dropping the others from r if it is
+// not nil. The caller holds the read lock, and r.mu.
+func (db *DB) recentInfo(r *recents, docs []recent, n int) ([]DocInfo, error) {
+	sz, err := size(db.reader)
+	if err != nil {
+		return nil, fmt.Errorf("recent: stat: %w", err)
+	}
+	t := now()
+	var infos []DocInfo
+	for _, d := range docs {
+		if len(infos) == n {
+			break
+		}
+		result, idx, err := db.findIndex(db.id(d.label), d.label, sz)
+		if err != nil {
+			return nil, fmt.Errorf("recent: %w", err)
+		}
+		if result == nil || idx.expired(t) || idx.Label != d.label || idx.Timestamp != d.ts {
+			if r != nil {
+				r.drop(d.label)
+			}
+			continue
+		}
+		infos = append(infos, DocInfo{Label: idx.Label, Created: idx.Created, Modified: idx.Timestamp, Expires: idx.Expires})
+	}
+	return infos, nil
+}
+
+// scanRecent scans the index lines for the newest limit documents, and
+// reports whether they are all the file holds. The caller must hold the
+// read lock.
+func (db *DB) scanRecent(limit int) (recentHeap, bool, error) {
+	sz, err := size(db.reader)
+	if err != nil {
+		return nil, false, fmt.Errorf("recent: stat: %w", err)
+	}
+
+	var kept recentHeap
+	all := true
+	t := now()
+	section := io.NewSectionReader(db.reader, HeaderSize, sz-HeaderSize)
+	scanner := bufio.NewScanner(section)
+	scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
+
+	for scanner.Scan() {
+		data := scanner.Bytes()
+		if !valid(data) || len(data) < MinRecordSize || data[TypePos] != byte('0'+TypeIndex) {
+			continue
+		}
+		if ex := expires(data); ex != 0 && ex <= t {
+			continue
+		}
+		ts, _ := strconv.ParseInt(string(data[TSStart:TSEnd]), 10, 64)
+		if kept.keep(recent{string(unescape([]byte(label(data)))), ts}, limit) {
+			all = false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, fmt.Errorf("recent: %w", err)
+	}
+	return kept, all, nil
+}
@@ -0,0 +1,112 @@
+package folio
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestRecent verifies that Recent from the heap matches the scan it
+// replaces through sets, updates, deletes, renames, Touch, expiry and
+// compaction, and that small and large n both work.
+func TestRecent(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{RecentDocs: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	labels := func(infos []DocInfo) []string {
+		var got []string
+		for _, info := range infos {
+			got = append(got, info.Label)
+		}
+		return got
+	}
+	check := func(when string, n int, want ...string) {
+		t.Helper()
+		got, err := db.Recent(n)
+		if err != nil || !slices.Equal(labels(got), want) {
+			t.Errorf("%s: Recent(%d) = %v, %v; want %v", when, n, labels(got), err, want)
+		}
+		saved := db.recent
+		db.recent = nil
+		scanned, err := db.Recent(n)
+		db.recent = saved
+		if err != nil || !slices.Equal(labels(scanned), want) {
+			t.Errorf("%s: scanned Recent(%d) = %v, %v; want %v", when, n, labels(scanned), err, want)
+		}
+	}
+	set := func(label string) {
+		t.Helper()
+		time.Sleep(2 * time.Millisecond)
+		if err := db.Set(label, "x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check("empty", 3)
+	for i := range 5 {
+		set(fmt.Sprintf("doc/%d", i))
+	}
+	check("sets", 2, "doc/4", "doc/3")
+	check("more than kept", 10, "doc/4", "doc/3", "doc/2", "doc/1", "doc/0")
+
+	set("doc/0")
+	check("update", 3, "doc/0", "doc/4", "doc/3")
+
+	db.Delete("doc/4")
+	db.Delete("doc/0")
+	check("deletes", 3, "doc/3", "doc/2", "doc/1")
+
+	time.Sleep(2 * time.Millisecond)
+	db.Rename("doc/3", "doc/X") // same length, patched in place
+	db.Rename("doc/2", "moved/2")
+	check("renames", 3, "moved/2", "doc/X", "doc/1")
+
+	time.Sleep(2 * time.Millisecond)
+	db.Touch("doc/1")
+	check("touch", 2, "doc/1", "moved/2")
+
+	db.SetWithTTL("tmp", "x", time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	check("expired", 1, "doc/1")
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check("compacted", 3, "doc/1", "moved/2", "doc/X")
+	set("new")
+	check("after compact", 3, "new", "doc/1", "moved/2")
+
+	if got, _ := db.Recent(0); got != nil {
+		t.Errorf("Recent(0) = %v, want nil", got)
+	}
+}
+
+// TestRecentRewrites verifies that one document written over and over
+// neither crowds out the others nor grows the heap without bound.
+func TestRecentRewrites(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{RecentDocs: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Set("a", "x")
+	time.Sleep(2 * time.Millisecond)
+	db.Set("b", "x")
+	db.Recent(1) // fills the heap
+	for i := range 50 {
+		db.Set("b", fmt.Sprint(i))
+	}
+	got, err := db.Recent(2)
+	if err != nil || len(got) != 2 || got[0].Label != "b" || got[1].Label != "a" {
+		t.Errorf("Recent(2) = %v, %v; want b, a", got, err)
+	}
+	if n := len(db.recent.heap); n > 2*db.recent.limit {
+		t.Errorf("heap holds %d entries for a limit of %d", n, db.recent.limit)
+	}
+}
@@ -17,7 +17,10 @@ package folio
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"encoding/hex"
+	"fmt"
+	"strconv"
 	"time"
 	"unicode/utf8"
 
@@ -43,8 +46,13 @@ type Record struct {
 	ID        string `json:"_id"` // 16 hex chars, hash of Label
 	Timestamp int64  `json:"_ts"` // unix ms
 	Label     string `json:"_l"`
-	Data      string `json:"_d"` // current content (blank for history)
-	History   string `json:"_h"` // zstd+ascii85 compressed snapshot
+	Data      string `json:"_d"`           // current content (blank for history)
+	History   string `json:"_h"`           // zstd+ascii85 compressed snapshot
+	Checksum  uint32 `json:"_k,omitempty"` // CRC-32C of the content (see checksum.go)
+	Binary    bool   `json:"_b,omitempty"` // _d is base64 (see binary.go)
+	Encrypted bool   `json:"_x,omitempty"` // _d and _h are encrypted (see crypt.go)
+	Delta     bool   `json:"_p,omitempty"` // _h is a patch on the version before (see delta.go)
+	Shared    int64  `json:"_s,omitempty"` // content is the record's at this offset (see dedup.go)
 }
 
 // Index maps a label's hashed ID to the byte offset of its data Record.
@@ -56,6 +64,8 @@ type Index struct {
 	Timestamp int64  `json:"_ts"`
 	Offset    int64  `json:"_o"` // byte position of the corresponding Record
 	Label     string `json:"_l"`
+	Created   int64  `json:"_c,omitempty"`  // unix ms of the document's first write
+	Expires   int64  `json:"_ex,omitempty"` // unix ms after which the document is absent (see ttl.go)
 }
 
 // Result carries a record's position and raw bytes from a scan. Callers
@@ -71,13 +81,15 @@ type Result struct {
 // is skipped — fields are read at fixed byte positions. DstOff is zero
 // until compaction fills it with the record's new position in the output.
 type Entry struct {
-	ID     string
-	TS     int64
-	Type   int
-	SrcOff int64 // position in the source file
-	DstOff int64 // position in the compaction output (set during write)
-	Length int
-	Label  string // populated only for index entries
+	ID      string
+	TS      int64
+	Type    int
+	SrcOff  int64 // position in the source file
+	DstOff  int64 // position in the compaction output (set during write)
+	Length  int
+	Label   string // populated only for index entries
+	Created int64  // populated only for index entries
+	Expires int64  // populated only for index entries
 }
 
 // Fixed byte positions within a record line. Every record starts with
@@ -93,8 +105,62 @@ const (
 	MinRecordSize = 52 // shortest valid line (must reach TSEnd)
 )
 
-// decode performs full JSON parsing of a record line.
+// decode performs full JSON parsing of a record line. For a current
+// record it also restores binary content and verifies the checksum, so
+// Data always holds the original bytes. Encrypted records need a key;
+// use the DB method.
 func decode(data []byte) (*Record, error) {
+	return decodeWith(data, nil)
+}
+
+// decode is the package-level decode using this handle's encryption key.
+// It also restores the content of a shared record from its body.
+func (db *DB) decode(data []byte) (*Record, error) {
+	r, err := decodeWith(data, db.cipher)
+	if err != nil || r.Type != TypeRecord || r.Shared == 0 {
+		return r, err
+	}
+	content, err := db.body(r.Shared, r.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	r.Data = string(content)
+	return r, nil
+}
+
+// decodeWith is decode with aead as the key. A shared record is returned
+// with its content empty.
+func decodeWith(data []byte, aead cipher.AEAD) (*Record, error) {
+	r, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if r.Type != TypeRecord || r.Shared != 0 {
+		return r, nil
+	}
+	switch {
+	case r.Encrypted:
+		raw, err := decrypt64(aead, []byte(r.Data))
+		if err != nil {
+			return nil, err
+		}
+		r.Data = string(raw)
+	case r.Binary:
+		raw, err := unbase64([]byte(r.Data))
+		if err != nil {
+			return nil, err
+		}
+		r.Data = string(raw)
+	}
+	if !r.verify([]byte(r.Data)) {
+		return nil, fmt.Errorf("%w: %w", ErrCorruptRecord, ErrChecksum)
+	}
+	return r, nil
+}
+
+// parse is decode without checksum verification, for scans that only
+// need a line's type and ID and never return its content.
+func parse(data []byte) (*Record, error) {
 	var r Record
 	if err := json.Unmarshal(data, &r); err != nil {
 		return nil, ErrCorruptRecord
@@ -134,6 +200,33 @@ func label(line []byte) string {
 	return string(line[start : start+end])
 }
 
+// created extracts the _c value from an index line by byte scanning.
+// Returns 0 when the field is absent (files written before it existed).
+func created(line []byte) int64 {
+	return number(line, `"_c":`)
+}
+
+// expires extracts the _ex value from an index line by byte scanning.
+// Returns 0 when the document has no expiry.
+func expires(line []byte) int64 {
+	return number(line, `"_ex":`)
+}
+
+// number reads the unsigned integer following marker, or 0 if absent.
+func number(line []byte, marker string) int64 {
+	start := bytes.Index(line, []byte(marker))
+	if start == -1 {
+		return 0
+	}
+	start += len(marker)
+	end := start
+	for end < len(line) && line[end] >= '0' && line[end] <= '9' {
+		end++
+	}
+	ts, _ := strconv.ParseInt(string(line[start:end]), 10, 64)
+	return ts
+}
+
 func now() int64 {
 	return time.Now().UnixMilli()
 }
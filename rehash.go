@@ -1,21 +1,52 @@
-// In-place hash algorithm migration.
+// Hash algorithm migration.
 //
 // All three algorithms produce a 16 hex character (8 byte) _id, and the _id
-// field sits at a fixed byte offset in every record. This means Rehash can
-// overwrite each _id in place without moving or resizing any records —
-// no temp file, no rewrite, just a linear scan with targeted byte patches.
+// field sits at a fixed byte offset in every record, so a record's new ID
+// is patched into it without resizing it. The heap and index sections are
+// sorted by ID, though, and new IDs sort differently, so patching the file
+// in place would leave every lookup in a compacted file binary-searching
+// sections that are no longer in order. Rehash is therefore a rebuild:
+// IDs are recomputed from labels before the sort, and the rebuilt file is
+// written to name.tmp and renamed over the original as Repair's is.
 //
-// The dirty flag is set before any patches begin and cleared after the
-// header is updated. A crash mid-rehash leaves the flag set, so the next
-// Open triggers automatic Repair — which rebuilds all IDs from labels,
-// restoring consistency regardless of how many patches completed.
+// The original is never patched or marked dirty, so the file on disk is
+// always wholly one algorithm or the other. A crash before the rename
+// leaves the original intact beside a .tmp that is discarded on the next
+// Open, as an orphaned rebuild's is; one after it leaves the finished file.
+// Like Compact, the rebuild drops expired documents and the versions
+// Config.HistoryRetention does not keep.
 package folio
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
+
+// RehashOptions controls a RehashWith.
+type RehashOptions struct {
+	// Progress, if set, is called as the heap is copied to the new file,
+	// with done and total in bytes of it (see progress.go).
+	Progress func(done, total int64)
+}
 
 // Rehash migrates all records to a new hash algorithm. Blocks all readers
 // and writers because every _id in the file is being rewritten.
 func (db *DB) Rehash(newAlg int) error {
+	return db.RehashWith(newAlg, RehashOptions{})
+}
+
+// RehashWith is Rehash with options.
+func (db *DB) RehashWith(newAlg int, opts RehashOptions) (err error) {
+	defer db.observe(OpRehash, "", time.Now(), &err)
+
+	if db.config.ReadOnly {
+		return ErrReadOnly
+	}
+	db.maint.Lock()
+	defer db.maint.Unlock()
+	if db.state.Load() == StateClosed {
+		return ErrClosed
+	}
 	db.state.Store(StateNone)
 	defer func() {
 		db.cond.L.Lock()
@@ -26,56 +57,51 @@ func (db *DB) Rehash(newAlg int) error {
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	if n := db.snapshots.Load(); n > 0 && !replaceOpen && db.root != nil {
+		return fmt.Errorf("rehash: %d open snapshots hold the file", n)
+	}
+	db.dropPartial() // its IDs are about to change
 
-	info, err := db.reader.Stat()
+	tmp, err := db.createTemp()
 	if err != nil {
-		return fmt.Errorf("rehash: stat: %w", err)
+		return fmt.Errorf("rehash: create temp: %w", err)
 	}
-	entries := scanm(db.reader, HeaderSize, info.Size(), 0)
-
-	// Set dirty flag so a crash mid-patch triggers automatic Repair.
-	if err := dirty(db.writer, true); err != nil {
-		return fmt.Errorf("rehash: set dirty: %w", err)
+	o := &CompactOptions{
+		PreserveInsertionOrder: db.header.Flags&flagInsertionOrder != 0,
+		Progress:               opts.Progress,
 	}
-	db.header.Error = 1
-
-	cache := map[string]string{} // label→newID, avoids rehashing the same label twice
+	tail, err := db.rebuild(tmp, o, newAlg)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("rehash: %w", err)
+	}
+	return db.swap(tmp, tail)
+}
 
-	for _, entry := range entries {
+// relabel replaces the ID of every entry with its label's, or for a tag
+// record its tag's, hash under alg.
+func (db *DB) relabel(entries []Entry, alg int) error {
+	ids := map[string]string{} // label→new ID, avoids rehashing the same label twice
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Type == TypeMeta || entry.Type == TypeTxn {
+			continue // fixed placeholder ID, not derived from a label
+		}
 		lbl := entry.Label
 		if lbl == "" {
 			record, err := line(db.reader, entry.SrcOff)
 			if err != nil {
-				return fmt.Errorf("rehash: read record: %w", err)
+				return fmt.Errorf("read record at %d: %w", entry.SrcOff, err)
 			}
 			lbl = label(record)
+			if entry.Type == TypeTag {
+				lbl = tagOf(record) // a tag record's ID is its tag's hash
+			}
 		}
-		if cache[lbl] == "" {
-			cache[lbl] = hash(lbl, newAlg)
-		}
-		if _, err := db.writer.WriteAt([]byte(cache[lbl]), entry.SrcOff+IDStart); err != nil {
-			return fmt.Errorf("rehash: write id: %w", err)
+		if ids[lbl] == "" {
+			ids[lbl] = hash(db.fold(lbl), alg)
 		}
+		entry.ID = ids[lbl]
 	}
-
-	db.header.Algorithm = newAlg
-	db.header.Timestamp = now()
-	hdrBytes, err := db.header.encode()
-	if err != nil {
-		return fmt.Errorf("rehash: encode header: %w", err)
-	}
-	if _, err := db.writer.WriteAt(hdrBytes, 0); err != nil {
-		return fmt.Errorf("rehash: write header: %w", err)
-	}
-	if err := db.writer.Sync(); err != nil {
-		return fmt.Errorf("rehash: sync: %w", err)
-	}
-
-	// All patches and the header are on disk — clear the dirty flag.
-	if err := dirty(db.writer, false); err != nil {
-		return fmt.Errorf("rehash: clear dirty: %w", err)
-	}
-	db.header.Error = 0
-
 	return nil
 }
@@ -1,8 +1,9 @@
 // Hash algorithm migration (Rehash) tests.
 //
-// Rehash rebuilds the entire database with a different hash algorithm.
+// Rehash rewrites the entire database with a different hash algorithm.
 // Every record's ID is recomputed from its label using the new
-// algorithm, then the file is rebuilt with the new IDs. This is a
+// algorithm, patched into a copy of the file, and the copy renamed into
+// place. This is a
 // destructive operation — if Rehash failed to recompute even one ID,
 // that document would become unreachable because Get computes the ID
 // from the label using the header's current algorithm.
@@ -10,10 +11,13 @@
 // These tests verify: the algorithm field changes in the header, all
 // documents remain accessible after migration, version history
 // survives, the timestamp is updated, every pairwise algorithm
-// migration works, and Rehash composes correctly with Compact.
+// migration works, Rehash composes correctly with Compact, and a crash
+// mid-rehash leaves the original whole.
 package folio
 
 import (
+	"bytes"
+	"os"
 	"path/filepath"
 	"testing"
 )
@@ -75,7 +79,7 @@ func TestRehashHistoryAccessible(t *testing.T) {
 
 	db.Rehash(AlgBlake2b)
 
-	versions, _ := collect(db.History("doc"))
+	versions, _ := Collect(db.History("doc"))
 	if len(versions) != 3 {
 		t.Errorf("History after rehash: got %d, want 3", len(versions))
 	}
@@ -186,3 +190,98 @@ func TestRehashAfterCompact(t *testing.T) {
 		t.Errorf("Get after compact+rehash = %q, want %q", data, "content")
 	}
 }
+
+// TestRehashLeavesOriginal verifies that Rehash writes its copy to a
+// temp file and renames it into place, so the original on disk is never
+// patched. A crash between the copy and the rename is simulated by
+// leaving an unfinished copy, half its IDs already rewritten, beside a
+// closed database: Open must discard it and keep the original whole,
+// every document reachable under the old algorithm.
+func TestRehashLeavesOriginal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, lbl := range []string{"a", "b", "c", "d"} {
+		db.Set(lbl, "content-"+lbl)
+	}
+	db.Tag("c", "third")
+	entries := scanm(db.reader, HeaderSize, db.tail, 0)
+	db.Close()
+
+	orig, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := bytes.Clone(orig)
+	copy(torn, make([]byte, HeaderSize)) // the header is written last
+	for _, e := range entries[:len(entries)/2] {
+		copy(torn[e.SrcOff+IDStart:], hash("x", AlgFNV1a))
+	}
+	if err := os.WriteFile(path+".tmp", torn, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(path, Config{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("unfinished copy left behind: %v", err)
+	}
+	if db.header.Algorithm != AlgXXHash3 {
+		t.Errorf("algorithm = %d, want the original %d", db.header.Algorithm, AlgXXHash3)
+	}
+	for _, lbl := range []string{"a", "b", "c", "d"} {
+		if got, err := db.Get(lbl); err != nil || got != "content-"+lbl {
+			t.Errorf("Get(%q) = %q, %v", lbl, got, err)
+		}
+	}
+
+	// Open rebuilt the original, so the rehash below is of a sorted file,
+	// whose sections must be sorted again by the new IDs. A finished
+	// Rehash leaves no temp file and a clean header.
+	if err := db.Rehash(AlgFNV1a); err != nil {
+		t.Fatalf("Rehash: %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temp file left after Rehash: %v", err)
+	}
+	if hdr, err := header(db.reader); err != nil || hdr.Error != 0 || hdr.Algorithm != AlgFNV1a {
+		t.Errorf("header after Rehash = %+v, %v", hdr, err)
+	}
+	for _, lbl := range []string{"a", "b", "c", "d"} {
+		if got, err := db.Get(lbl); err != nil || got != "content-"+lbl {
+			t.Errorf("Get(%q) after Rehash = %q, %v", lbl, got, err)
+		}
+	}
+	if labels, _ := Collect(db.ByTag("third")); len(labels) != 1 || labels[0] != "c" {
+		t.Errorf("ByTag after Rehash = %v, want c", labels)
+	}
+	if r := mustVerify(t, db, VerifyOptions{}); !r.OK() {
+		t.Errorf("Verify after Rehash: %v", r.Problems)
+	}
+}
+
+// TestRehashMemory verifies that a memory database rehashes into a new
+// buffer and keeps writing to it.
+func TestRehashMemory(t *testing.T) {
+	db, err := Open(memoryPath, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	db.Set("doc", "content")
+	if err := db.Rehash(AlgBlake2b); err != nil {
+		t.Fatalf("Rehash: %v", err)
+	}
+	db.Set("doc", "more")
+	if got, _ := db.Get("doc"); got != "more" {
+		t.Errorf("Get = %q, want more", got)
+	}
+	if versions, _ := Collect(db.History("doc")); len(versions) != 2 {
+		t.Errorf("History = %d versions, want 2", len(versions))
+	}
+}
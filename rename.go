@@ -1,33 +1,40 @@
 // Label renaming with in-place patching when possible.
 //
-// When old and new labels have the same byte length, Rename patches
-// _id and _l directly in the data record and index record — no new
-// version is created and no history entry is added. When lengths
-// differ, it falls back to appending a new record+index and blanking
-// the old ones (equivalent to Set+Delete but under a single lock hold).
+// When old and new labels have the same byte length and the data record
+// and index record both lie in the unsealed tail of the sparse region,
+// Rename patches _id and _l directly in them — no new version is
+// created and no history entry is added. Otherwise it falls back to
+// appending a new record+index and blanking the old ones (equivalent to
+// Set+Delete but under a single lock hold). The heap, the index section
+// and sealed segments (see segment.go) are sorted by ID, so a line in
+// them patched to a new ID would sit out of order, where binary search
+// could not find it.
 //
 // History records are not patched in either path: they retain the old
 // ID and become unreachable via History(newLabel). This matches the
 // behaviour callers would get from the manual Get+Set+Delete approach.
+// Tags, in contrast, move with the document (see tag.go). Either way a
+// tombstone naming both labels is appended for Changes (see journal.go).
 package folio
 
 import (
 	"bytes"
 	"fmt"
-	"strings"
+	"time"
 )
 
 // Rename changes a document's label. Returns ErrNotFound if old does
-// not exist, or ErrExists if new already exists.
-func (db *DB) Rename(old, new string) error {
-	if old == "" || new == "" {
+// not exist, or ErrExists if new already exists. A document that has
+// expired does not exist: it cannot be renamed, and one under new is
+// replaced.
+func (db *DB) Rename(old, new string) (err error) {
+	defer db.observe(OpRename, old, time.Now(), &err)
+
+	if old == "" {
 		return ErrInvalidLabel
 	}
-	if len(new) > MaxLabelSize {
-		return ErrLabelTooLong
-	}
-	if strings.Contains(new, `"`) {
-		return ErrInvalidLabel
+	if err := db.checkLabel(new); err != nil {
+		return err
 	}
 	if old == new {
 		return nil
@@ -37,13 +44,21 @@ func (db *DB) Rename(old, new string) error {
 		return err
 	}
 
-	err := db.rename(old, new)
+	err = db.rename(old, new)
+	if err == nil {
+		db.usage.writes.Add(1)
+	}
 
 	// Check threshold under lock, compact after release (see set.go).
 	compact := err == nil && db.shouldCompact()
+	events := db.takeEvents()
 	db.mu.Unlock()
 	db.lock.Unlock()
 
+	if err == nil {
+		err = db.durable()
+	}
+	db.after(events)
 	if compact {
 		db.Compact()
 	}
@@ -57,55 +72,114 @@ func (db *DB) rename(old, new string) error {
 		return fmt.Errorf("rename: stat: %w", err)
 	}
 
-	// Find old document's index.
-	oldID := hash(old, db.header.Algorithm)
+	// Find old document's index. One that has expired is absent, as it
+	// is to Get and Delete.
+	ts := now()
+	oldID := db.id(old)
 	idxResult, idx, err := db.findIndex(oldID, old, sz)
 	if err != nil {
 		return fmt.Errorf("rename: %w", err)
 	}
-	if idxResult == nil {
+	if idxResult == nil || idx.expired(ts) {
 		return ErrNotFound
 	}
+	old = idx.Label // as stored, in a case-insensitive file
+	if old == new {
+		return nil
+	}
 
-	// Ensure new label doesn't already exist.
-	newID := hash(new, db.header.Algorithm)
-	newResult, _, err := db.findIndex(newID, new, sz)
+	// Ensure new label doesn't already exist. In a case-insensitive file
+	// it may be old itself, respelled. A document there that has expired
+	// is absent, and is retired below as a Set over it would retire it.
+	newID := db.id(new)
+	newResult, newIdx, err := db.findIndex(newID, new, sz)
 	if err != nil {
 		return fmt.Errorf("rename: %w", err)
 	}
-	if newResult != nil {
+	if newResult != nil && newResult.Offset == idxResult.Offset {
+		newResult = nil
+	}
+	if newResult != nil && !newIdx.expired(ts) {
 		return ErrExists
 	}
 
-	// Same-length labels: patch _id and _l in place.
-	if len(old) == len(new) {
-		return db.patchRename(idx.Offset, idxResult.Offset, newID, new)
-	}
+	// Same-length labels whose record and index are both in the unsealed
+	// tail of the sparse region are patched in place. A line in the
+	// sorted sections or a sealed segment keeps the ID it is sorted by,
+	// or binary search would not find it.
+	tail := db.unsealed()
+	inPlace := len(old) == len(new) && idx.Offset >= tail && idxResult.Offset >= tail
 
-	// Different-length: append new record+index, blank old.
-	content, err := line(db.reader, idx.Offset)
-	if err != nil {
-		return fmt.Errorf("rename: read record: %w", err)
+	// The rewrite needs the content, and so do the set hooks; the
+	// in-place patch otherwise never reads it.
+	var content string
+	if !inPlace || db.config.Hooks.sets() {
+		data, err := line(db.reader, idx.Offset)
+		if err != nil {
+			return fmt.Errorf("rename: read record: %w", faultAt(old, idx.Offset, err))
+		}
+		record, err := db.decode(data)
+		if err != nil {
+			return fmt.Errorf("rename: %w", faultAt(old, idx.Offset, err))
+		}
+		content = record.Data
 	}
-	record, err := decode(content)
-	if err != nil {
-		return fmt.Errorf("rename: %w", err)
+	if err := db.beforeRename(old, new, content); err != nil {
+		return err
+	}
+	if newResult != nil {
+		if err := blank(db, newIdx.Offset, newResult); err != nil {
+			return fmt.Errorf("rename: %w", err)
+		}
+		if err := db.dropTags(newIdx.Label); err != nil {
+			return fmt.Errorf("rename: %w", err)
+		}
+		db.count.Add(^uint64(0)) // unsigned decrement
 	}
 
-	ts := now()
+	if inPlace {
+		if err := db.patchRename(idx.Offset, idxResult.Offset, newID, new); err != nil {
+			return err
+		}
+		if db.smap != nil {
+			db.smap.rename(db.fold(old), db.fold(new), idxResult.Offset)
+		}
+		if db.labels != nil {
+			db.labels.remove(old)
+			db.labels.put(new, idx.Expires)
+		}
+		if db.recent != nil {
+			db.recent.put(new, idx.Timestamp)
+		}
+		if err := db.retag(old, new, idx.Created); err != nil {
+			return fmt.Errorf("rename: %w", err)
+		}
+		db.unindex(old)
+		if err := db.reindex(new); err != nil {
+			return fmt.Errorf("rename: %w", err)
+		}
+		if err := db.tombstone(old, new, ts); err != nil {
+			return fmt.Errorf("rename: %w", err)
+		}
+		db.renamed(old, new, content)
+		return nil
+	}
+
+	// Otherwise: append new record+index, blank old.
 	newRecord := &Record{
 		Type:      TypeRecord,
 		ID:        newID,
 		Label:     new,
 		Timestamp: ts,
-		Data:      record.Data,
-		History:   compress([]byte(record.Data)),
+		Data:      content,
 	}
 	newIndex := &Index{
 		Type:      TypeIndex,
 		ID:        newID,
 		Label:     new,
 		Timestamp: ts,
+		Created:   idx.Created,
+		Expires:   idx.Expires,
 	}
 
 	if _, err := db.append(newRecord, newIndex); err != nil {
@@ -119,36 +193,56 @@ func (db *DB) rename(old, new string) error {
 	if err := blank(db, idx.Offset, idxResult); err != nil {
 		return fmt.Errorf("rename: %w", err)
 	}
+	if db.labels != nil {
+		db.labels.remove(old)
+		db.labels.put(new, idx.Expires)
+	}
+	if err := db.retag(old, new, idx.Created); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	db.unindex(old)
+	if err := db.reindex(new); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	if err := db.tombstone(old, new, ts); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	db.renamed(old, new, content)
 	return nil
 }
 
+// beforeRename runs the before hooks for a rename: a delete of old and
+// a set of new, or only the set if the labels differ just in case.
+func (db *DB) beforeRename(old, new, content string) error {
+	if !db.same(old, new) {
+		if err := db.beforeDelete(old); err != nil {
+			return err
+		}
+	}
+	return db.beforeSet(new, content)
+}
+
+// renamed queues the after hooks for a rename, as beforeRename ran the
+// before hooks.
+func (db *DB) renamed(old, new, content string) {
+	if !db.same(old, new) {
+		db.wroteDelete(old)
+	}
+	db.wroteSet(new, content)
+}
+
 // findIndex locates the current index record for a label. Returns nil
 // Result if the document doesn't exist.
 func (db *DB) findIndex(id, label string, sz int64) (*Result, *Index, error) {
-	result := scan(db.reader, id, db.indexStart(), db.indexEnd(), TypeIndex)
-	if result != nil {
-		idx, err := decodeIndex(result.Data)
-		if err != nil {
-			return nil, nil, err
-		}
-		if idx.Label == label {
-			return result, idx, nil
-		}
+	result, idx, err := db.sorted(id)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	results := sparse(db.reader, id, db.sparseStart(), sz, TypeIndex)
-	for i := len(results) - 1; i >= 0; i-- {
-		idx, err := decodeIndex(results[i].Data)
-		if err != nil {
-			return nil, nil, err
-		}
-		if idx.Label == label {
-			r := results[i]
-			return &r, idx, nil
-		}
+	if result != nil && db.same(idx.Label, label) {
+		return result, idx, nil
 	}
 
-	return nil, nil, nil
+	return db.newest(id, label, sz)
 }
 
 // patchRename patches _id and _l in the data record at dataOff and the
@@ -190,5 +284,8 @@ func (db *DB) patchRename(dataOff, idxOff int64, newID, newLabel string) error {
 	if db.bloom != nil {
 		db.bloom.Add(newID)
 	}
+	if db.misses != nil {
+		db.misses.forget(newID)
+	}
 	return nil
 }
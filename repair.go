@@ -12,26 +12,37 @@
 // mid-rewrite, both the old and new data are gone. Writing to a temp
 // file, syncing, then atomically renaming means the original file is
 // intact until the rename succeeds. A crash during the write phase at
-// worst orphans the .tmp file, which is cleaned up on next Open.
+// worst orphans the .tmp file. The next Open promotes it if it is complete
+// and the original is dirty or unreadable (see salvage.go), otherwise it
+// is discarded.
 //
-// The operation proceeds in two phases to minimise the time readers are
-// blocked:
+// The operation proceeds in two phases to minimise the time readers and
+// writers are blocked:
 //
-//   - Phase 1 (read lock): scan the old file and write the new .tmp file.
-//     Concurrent readers continue using the old file.
-//   - Phase 2 (write lock): swap file handles from the old file to the new
-//     one. This is a brief exclusive lock for the atomic rename.
+//   - Phase 1 (read lock, in chunks): scan the old file and write the new
+//     .tmp file. The lock is released after each CompactOptions.ChunkBytes,
+//     so writers carry on, appending to the old file and patching it in
+//     place; readers continue using it throughout.
+//   - Phase 2 (write lock): bring the writes made during Phase 1 across
+//     to the new file (see incremental.go), then swap file handles from
+//     the old file to the new one. This is a brief exclusive lock unless
+//     Phase 1 saw heavy writing.
 //
 // When called for crash recovery (BlockReaders=true), a write lock is held
-// for the entire operation since the file may be inconsistent.
+// for the entire operation since the file may be inconsistent. A file with
+// delta history is rebuilt in one pass under the read lock, with writers
+// held off, because its history chains are re-chunked as a whole.
 package folio
 
 import (
+	"cmp"
+	"crypto/cipher"
 	"fmt"
 	"io"
 	"maps"
 	"os"
 	"slices"
+	"time"
 
 	json "github.com/goccy/go-json"
 )
@@ -39,13 +50,83 @@ import (
 type CompactOptions struct {
 	BlockReaders bool // hold write lock for entire operation (crash recovery)
 	PurgeHistory bool // drop history records from the output
+
+	// PreserveInsertionOrder lays the heap out by document creation time
+	// instead of by ID, so All and Search yield documents in the order
+	// they were first written. Each document's versions stay contiguous
+	// and the index section is still sorted by ID, so Get is unaffected.
+	// History can no longer binary-search the heap: it follows the sorted
+	// index to the document's group instead, falling back to a linear
+	// heap scan for deleted documents. Compact, Purge, and auto-compaction
+	// keep whichever layout the file already has.
+	PreserveInsertionOrder bool
+
+	// Progress, if set, is called as the heap is copied to the new file,
+	// with done and total in bytes of it (see progress.go).
+	Progress func(done, total int64)
+
+	// MaxBytesPerSecond, if above 0, paces the rebuild to about that many
+	// bytes read and written per second, leaving the disk to other work.
+	// Writers wait longer for each chunk, so keep ChunkBytes small with
+	// it (see incremental.go).
+	MaxBytesPerSecond int64
+
+	// ChunkBytes is how much of the file a rebuild works through before
+	// it releases the read lock to let writers in, and one CompactStep
+	// call's share of it; 4 MiB if 0.
+	ChunkBytes int64
+
+	// rekey, set by Clone, writes every record sealed under key, or in
+	// plain text if key is nil, instead of copying it as it is.
+	rekey bool
+	key   cipher.AEAD
 }
 
 // Repair rebuilds the file. See the package comment for phase details.
-func (db *DB) Repair(opts *CompactOptions) error {
+func (db *DB) Repair(opts *CompactOptions) (err error) {
+	defer db.observe(OpRepair, "", time.Now(), &err)
+
+	return db.repair(opts, false)
+}
+
+// repair implements Repair. With keepLayout set, PreserveInsertionOrder
+// is taken from the current header rather than from opts, so routine
+// compaction never silently undoes a layout the caller chose earlier.
+func (db *DB) repair(opts *CompactOptions, keepLayout bool) (err error) {
 	if opts == nil {
 		opts = &CompactOptions{}
 	}
+	if db.config.ReadOnly {
+		return ErrReadOnly
+	}
+	db.maint.Lock()
+	defer db.maint.Unlock()
+	if db.state.Load() == StateClosed {
+		return ErrClosed
+	}
+	db.abandon()
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			db.log.Error("rebuild failed", "error", err)
+		}
+	}()
+	if n := db.snapshots.Load(); n > 0 && !replaceOpen && db.root != nil {
+		return fmt.Errorf("repair: %d open snapshots hold the file", n)
+	}
+
+	db.mu.RLock()
+	flags := db.header.Flags
+	db.mu.RUnlock()
+	if keepLayout {
+		o := *opts
+		o.PreserveInsertionOrder = flags&flagInsertionOrder != 0
+		opts = &o
+	}
+	if !opts.BlockReaders && !db.config.DeltaHistory && flags&flagDeltaHistory == 0 &&
+		!db.config.DedupContent && flags&flagSharedContent == 0 {
+		return db.online(opts)
+	}
 
 	// Restrict concurrent access for the duration of the rebuild
 	if opts.BlockReaders {
@@ -63,7 +144,7 @@ func (db *DB) Repair(opts *CompactOptions) error {
 		}
 	}()
 
-	tmp, err := db.root.Create(db.name + ".tmp")
+	tmp, err := db.createTemp()
 	if err != nil {
 		return fmt.Errorf("repair: create temp: %w", err)
 	}
@@ -76,8 +157,9 @@ func (db *DB) Repair(opts *CompactOptions) error {
 	} else {
 		db.mu.RLock()
 	}
+	before := db.tail
 
-	indexEnd, err := db.rebuild(tmp, opts)
+	tail, err := db.rebuild(tmp, opts, db.header.Algorithm)
 	if err != nil {
 		db.cond.L.Lock()
 		db.state.Store(StateAll)
@@ -99,24 +181,20 @@ func (db *DB) Repair(opts *CompactOptions) error {
 	}
 	defer db.mu.Unlock()
 
-	// Drain in-flight flock calls before closing the fd (see lock.go)
-	db.lock.setFile(nil)
-
-	db.reader.Close()
-	db.writer.Close()
-
-	if err := db.root.Rename(db.name+".tmp", db.name); err != nil {
-		return fmt.Errorf("repair: rename: %w", err)
+	if err := db.swap(tmp, tail); err != nil {
+		return err
 	}
+	db.rebuilt(start, before, tail, opts)
+	return nil
+}
 
-	reader, err := db.root.OpenFile(db.name, os.O_RDONLY, 0644)
+// swap makes the rebuilt file tmp, tail bytes long, the database, and
+// rebuilds the in-memory state that depends on the layout. The write
+// lock must be held.
+func (db *DB) swap(tmp storage, tail int64) error {
+	reader, writer, err := db.replace(tmp)
 	if err != nil {
-		return fmt.Errorf("repair: reopen reader: %w", err)
-	}
-	writer, err := db.root.OpenFile(db.name, os.O_RDWR, 0644)
-	if err != nil {
-		reader.Close()
-		return fmt.Errorf("repair: reopen writer: %w", err)
+		return err
 	}
 	hdrParsed, err := header(reader)
 	if err != nil {
@@ -127,31 +205,133 @@ func (db *DB) Repair(opts *CompactOptions) error {
 
 	db.reader = reader
 	db.writer = writer
-	db.lock.setFile(db.writer)
+	if f, ok := writer.(*os.File); ok {
+		db.lock.setFile(f)
+	}
+	db.closePool()
+	if err := db.openPool(); err != nil {
+		return fmt.Errorf("repair: %w", err)
+	}
 	db.header = hdrParsed
 	db.count.Store(hdrParsed.State[stCount])
+	db.loadMeta()
+	db.table = db.loadTable()
+	db.width = db.indexWidth()
 
-	db.tail = indexEnd
+	db.tail = tail
 
 	if db.bloom != nil {
 		db.bloom.Reset()
+		for _, e := range scanm(reader, db.sparseStart(), tail, TypeIndex) {
+			db.bloom.Add(e.ID)
+		}
+	}
+	if db.ibloom != nil {
+		db.ibloom = db.indexBloom()
+	}
+	if db.smap != nil {
+		db.smap.reset()
+	}
+	if db.cache != nil {
+		db.cache.reset()
+	}
+	if db.misses != nil {
+		db.misses.reset(tail)
+	}
+	if db.recent != nil {
+		db.recent.reset()
+	}
+	if db.sizes != nil {
+		if db.sizes, err = db.scanSizes(tail); err != nil {
+			return fmt.Errorf("repair: %w", err)
+		}
+	}
+	if db.labels != nil {
+		if err := db.buildTrie(); err != nil {
+			return fmt.Errorf("repair: %w", err)
+		}
+	}
+	if db.text != nil {
+		if err := db.buildText(); err != nil {
+			return fmt.Errorf("repair: full-text index: %w", err)
+		}
 	}
 
 	return nil
 }
 
-// rebuild writes the sorted output to tmp. Called with db.mu held (read or
-// write depending on BlockReaders). On success it syncs and closes tmp, and
-// returns the byte offset of the sparse region start for db.tail.
-func (db *DB) rebuild(tmp *os.File, opts *CompactOptions) (int64, error) {
+// createTemp creates the file rebuild writes to.
+func (db *DB) createTemp() (storage, error) {
+	if db.root == nil {
+		return &memFile{}, nil
+	}
+	return db.root.Create(db.name + ".tmp")
+}
+
+// replace closes the current file and moves the rebuilt one into its
+// place, returning new handles on it. In memory the rebuilt buffer
+// simply becomes the database.
+func (db *DB) replace(tmp storage) (reader, writer storage, err error) {
+	if db.root == nil {
+		return tmp, tmp, nil
+	}
+
+	// Drain in-flight flock calls before closing the fd (see lock.go)
+	db.lock.setFile(nil)
+
+	db.reader.Close()
+	db.writer.Close()
+
+	if err := db.root.Rename(db.name+".tmp", db.name); err != nil {
+		return nil, nil, fmt.Errorf("repair: rename: %w", err)
+	}
+
+	r, err := db.root.OpenFile(db.name, os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("repair: reopen reader: %w", err)
+	}
+	w, err := db.root.OpenFile(db.name, os.O_RDWR, 0644)
+	if err != nil {
+		r.Close()
+		return nil, nil, fmt.Errorf("repair: reopen writer: %w", err)
+	}
+	return mapped(r, db.config), w, nil
+}
+
+// reopen returns a second read handle on the current file, for a
+// Snapshot. In memory it is the buffer itself, which compaction
+// replaces rather than rewrites.
+func (db *DB) reopen() (storage, error) {
+	if db.root == nil {
+		return db.reader, nil
+	}
+	return db.root.Open(db.name)
+}
+
+// rebuild writes the sorted output to tmp, with IDs hashed by alg. Called
+// with db.mu held (read or write depending on BlockReaders). On success it
+// syncs and closes tmp, and returns the end of the written output for
+// db.tail.
+func (db *DB) rebuild(tmp storage, opts *CompactOptions, alg int) (int64, error) {
 	info, err := db.reader.Stat()
 	if err != nil {
 		return 0, fmt.Errorf("repair: stat: %w", err)
 	}
 	entries := scanm(db.reader, HeaderSize, info.Size(), 0)
+	rehash := alg != db.header.Algorithm
+	if rehash {
+		// IDs are replaced before anything is sorted by them, and patched
+		// into each line as it is written (see rehash.go).
+		if err := db.relabel(entries, alg); err != nil {
+			return 0, fmt.Errorf("repair: %w", err)
+		}
+	}
 
 	// Split into heap (data+history) and indexes.
-	exclude := []int{}
+	// The metadata record, tag records, and tombstones are rewritten
+	// separately after the indexes. Transaction records are settled by
+	// Open before any repair and are not needed afterwards.
+	exclude := []int{TypeMeta, TypeTxn, TypeTag, TypeTombstone, TypeTable}
 	if opts.PurgeHistory {
 		exclude = append(exclude, TypeHistory)
 	}
@@ -161,23 +341,60 @@ func (db *DB) rebuild(tmp *os.File, opts *CompactOptions) (int64, error) {
 	// contiguous, oldest first. History records (_r=3) for an ID precede
 	// the current data record (_r=2) because they have earlier timestamps.
 	slices.SortFunc(heap, byIDThenTS)
+	var rewritten map[int64][]byte
+	deltas := false // the output holds delta records
+	if !opts.PurgeHistory && (db.config.DeltaHistory || db.header.Flags&flagDeltaHistory != 0) {
+		// History is re-chunked for what retention keeps, from the whole
+		// chain as it is now (see delta.go).
+		kept := retain(slices.Clone(heap), db.config.HistoryRetention, now())
+		var n int
+		if rewritten, n, heap, err = db.rechunk(heap, kept, db.config.DeltaHistory, opts.BlockReaders); err != nil {
+			return 0, err
+		}
+		deltas = n > 0
+	} else {
+		heap = retain(heap, db.config.HistoryRetention, now())
+	}
+	if opts.PreserveInsertionOrder {
+		heap = byInsertion(heap, indexes)
+	}
+	var total int64
+	for _, e := range heap {
+		total += int64(e.Length) + 1
+	}
+	progress := newProgress(opts.Progress, total, progressStep)
 
-	// Keyed by label so each document keeps exactly one index in the output.
 	// As records are written below, each index's DstOff is updated to the
-	// record's new position in the output file.
-	indexMap := map[string]*Entry{}
-	for i := range indexes {
-		indexMap[indexes[i].Label] = &indexes[i]
-	}
+	// record's new position in the output file. Expired documents are
+	// dropped with all of their versions, their heap records skipped by
+	// label.
+	indexMap, expired := liveIndexes(indexes, now())
 
-	if _, err := tmp.Write(make([]byte, HeaderSize)); err != nil {
+	if _, err := tmp.WriteAt(make([]byte, HeaderSize), 0); err != nil {
 		return 0, fmt.Errorf("repair: write header placeholder: %w", err)
 	}
 	ow := &offsetWriter{w: tmp, off: HeaderSize}
 
+	// Oldest surviving version per label, used to backfill _c for files
+	// written before the field existed. Heap entries are sorted oldest
+	// first within each ID, so the first one seen per label is the minimum.
+	earliest := map[string]int64{}
+	pace := &throttle{rate: opts.MaxBytesPerSecond, start: time.Now()}
+
+	// Shared records are written out in full, and shared afresh if the
+	// option is on (see dedup.go).
+	var dedup *deduper
+	if db.config.DedupContent {
+		dedup = &deduper{out: tmp, aead: db.cipher, bodies: map[uint64]int64{}}
+		if opts.rekey {
+			dedup.aead = opts.key
+		}
+	}
+
 	// Write heap: interleaved data + history sorted by ID then timestamp.
 	for i := range heap {
 		entry := &heap[i]
+		progress.add(int64(entry.Length) + 1)
 		record, err := line(db.reader, entry.SrcOff)
 		if err != nil {
 			if opts.BlockReaders {
@@ -186,6 +403,40 @@ func (db *DB) rebuild(tmp *os.File, opts *CompactOptions) (int64, error) {
 			return 0, fmt.Errorf("repair: read record at %d: %w", entry.SrcOff, err)
 		}
 
+		lbl := label(record)
+		if expired[lbl] {
+			continue
+		}
+		if l, ok := rewritten[entry.SrcOff]; ok {
+			record = l
+		}
+		if shared(record) {
+			if record, err = db.unshare(record); err != nil {
+				if opts.BlockReaders {
+					continue
+				}
+				return 0, fmt.Errorf("repair: record at %d: %w", entry.SrcOff, err)
+			}
+		}
+		if opts.rekey {
+			if record, err = db.reseal(record, opts.key); err != nil {
+				return 0, fmt.Errorf("repair: record at %d: %w", entry.SrcOff, err)
+			}
+		}
+		if rehash {
+			record = slices.Clone(record)
+			copy(record[IDStart:IDEnd], entry.ID)
+		}
+		if dedup != nil && entry.Type == TypeRecord {
+			l, err := dedup.share(record, ow.off)
+			if err != nil && !opts.BlockReaders {
+				return 0, fmt.Errorf("repair: record at %d: %w", entry.SrcOff, err)
+			}
+			if err == nil {
+				record = l
+			}
+		}
+
 		entry.DstOff = ow.off
 		if _, err := ow.Write(record); err != nil {
 			return 0, fmt.Errorf("repair: write record: %w", err)
@@ -193,12 +444,19 @@ func (db *DB) rebuild(tmp *os.File, opts *CompactOptions) (int64, error) {
 		if _, err := ow.Write([]byte{'\n'}); err != nil {
 			return 0, fmt.Errorf("repair: write newline: %w", err)
 		}
+		pace.wait(int64(len(record)) + 1)
+
+		if _, ok := earliest[lbl]; !ok {
+			earliest[lbl] = entry.TS
+		}
 
 		// Only update index offsets for current data records (not history).
+		// The index takes the record's timestamp so _ts on an index always
+		// means "last modified", whether or not the file has been compacted.
 		if entry.Type == TypeRecord {
-			lbl := label(record)
 			if idx, ok := indexMap[lbl]; ok {
 				idx.DstOff = entry.DstOff
+				idx.TS = entry.TS
 			}
 		}
 	}
@@ -207,57 +465,305 @@ func (db *DB) rebuild(tmp *os.File, opts *CompactOptions) (int64, error) {
 
 	// Indexes are rewritten with updated offsets pointing to the records'
 	// new positions in the output file.
+	createdOut, table, err := writeIndexes(ow, indexMap, earliest, db.config.HashTable)
+	if err != nil {
+		return 0, err
+	}
+	indexEnd := ow.off
+
+	tags, err := db.liveTags(entries, indexMap, createdOut, opts.BlockReaders, alg)
+	if err != nil {
+		return 0, err
+	}
+	var tombs []tombstone
+	if !opts.PurgeHistory {
+		if tombs, err = db.liveTombstones(entries, indexMap, opts.BlockReaders, alg); err != nil {
+			return 0, err
+		}
+	}
+	meta := db.meta
+	if opts.rekey && meta != nil && len(meta.Dicts) > 0 {
+		m := *meta
+		if m.Dicts, err = db.resealDicts(m.Dicts, opts.key); err != nil {
+			return 0, fmt.Errorf("repair: %w", err)
+		}
+		meta = &m
+	}
+	metaOff, err := db.writeMeta(ow, meta, tags, tombs, table)
+	if err != nil {
+		return 0, err
+	}
+
+	// Now that all sections are written, we know their boundary offsets.
+	if err := db.writeHeader(tmp, heapEnd, indexEnd, metaOff, len(indexMap), alg, opts.PreserveInsertionOrder, deltas, dedup != nil && dedup.n > 0); err != nil {
+		return 0, err
+	}
+	progress.finish()
+
+	return ow.off, nil
+}
+
+// liveIndexes keys indexes by label, so each document keeps exactly one
+// index in the output, and splits off the documents expired at t.
+func liveIndexes(indexes []Entry, t int64) (live map[string]*Entry, expired map[string]bool) {
+	live = map[string]*Entry{}
+	for i := range indexes {
+		live[indexes[i].Label] = &indexes[i]
+	}
+	expired = map[string]bool{}
+	for lbl, idx := range live {
+		if idx.Expires != 0 && idx.Expires <= t {
+			expired[lbl] = true
+			delete(live, lbl)
+		}
+	}
+	return live, expired
+}
+
+// writeIndexes writes the index section of a rebuild from indexMap,
+// sorted by ID and padded to one width (see fixed.go), followed by a hash
+// table over it if table is set (see hashtable.go). It returns the _c
+// each document's index was written with: its own, or for files written
+// before the field existed, that of its oldest version in earliest; and
+// the offset of the table, or 0 if none was written.
+func writeIndexes(ow *offsetWriter, indexMap map[string]*Entry, earliest map[string]int64, table bool) (map[string]int64, int64, error) {
 	sorted := slices.SortedFunc(maps.Values(indexMap), byID)
+	createdOut := make(map[string]int64, len(sorted))
+	lines := make([][]byte, 0, len(sorted))
 	for _, idx := range sorted {
+		ct := idx.Created
+		if ct == 0 {
+			ct = earliest[idx.Label]
+		}
+		createdOut[idx.Label] = ct
 		indexRecord, err := json.Marshal(Index{
 			Type:      TypeIndex,
 			ID:        idx.ID,
 			Offset:    idx.DstOff,
 			Label:     idx.Label,
-			Timestamp: now(),
+			Timestamp: idx.TS,
+			Created:   ct,
+			Expires:   idx.Expires,
 		})
 		if err != nil {
-			return 0, fmt.Errorf("repair: marshal index: %w", err)
+			return nil, 0, fmt.Errorf("repair: marshal index: %w", err)
 		}
+		lines = append(lines, indexRecord)
+	}
+
+	padIndexes(lines)
+	start := ow.off
+	for _, indexRecord := range lines {
 		if _, err := ow.Write(indexRecord); err != nil {
-			return 0, fmt.Errorf("repair: write index: %w", err)
+			return nil, 0, fmt.Errorf("repair: write index: %w", err)
 		}
 		if _, err := ow.Write([]byte{'\n'}); err != nil {
-			return 0, fmt.Errorf("repair: write newline: %w", err)
+			return nil, 0, fmt.Errorf("repair: write newline: %w", err)
 		}
 	}
 
-	indexEnd := ow.off
+	if !table || len(sorted) == 0 {
+		return createdOut, 0, nil
+	}
+	tableStart := ow.off
+	ids := make([]string, len(sorted))
+	offs := make([]int64, len(sorted))
+	for i, idx := range sorted {
+		ids[i] = idx.ID
+		offs[i] = start + int64(i)*int64(len(lines[i])+1)
+	}
+	if err := writeTable(ow, ids, offs); err != nil {
+		return nil, 0, err
+	}
+	return createdOut, tableStart, nil
+}
 
-	// Now that all sections are written, we know their boundary offsets.
+// writeMeta writes tags and tombs, each sorted by ID, merged into the
+// tag section of a rebuild, then the metadata record carried over from
+// meta, pointing at the hash table at table if there is one, returning
+// its offset, or 0 if none is needed.
+func (db *DB) writeMeta(ow *offsetWriter, meta *Meta, tags []tagRecord, tombs []tombstone, table int64) (int64, error) {
+	for i, j := 0, 0; i < len(tags) || j < len(tombs); {
+		var v any
+		if j == len(tombs) || i < len(tags) && tags[i].ID <= tombs[j].ID {
+			v, i = tags[i], i+1
+		} else {
+			v, j = tombs[j], j+1
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return 0, fmt.Errorf("repair: marshal tag: %w", err)
+		}
+		if _, err := ow.Write(append(data, '\n')); err != nil {
+			return 0, fmt.Errorf("repair: write tag: %w", err)
+		}
+	}
+
+	// Carry the header extension forward as the first line after the tag
+	// section, recording where that section ends. Only the stored record
+	// is copied: session counters not yet saved stay in memory and are
+	// folded in at the next save, never twice.
+	var metaOff int64
+	m := Meta{Type: TypeMeta, ID: metaID}
+	if meta != nil {
+		m = *meta
+	}
+	m.Tags = 0
+	if len(tags) > 0 || len(tombs) > 0 {
+		m.Tags = ow.off
+	}
+	m.Table = table
+	m.Sizes = nil    // saved only at Close, for the file as it ends then
+	m.Segments = nil // sorted into the heap
+	if m.Usage != nil || m.Tags != 0 || m.Table != 0 || len(m.Dicts) > 0 {
+		m.Timestamp = now()
+		metaRecord, err := json.Marshal(m)
+		if err != nil {
+			return 0, fmt.Errorf("repair: marshal meta: %w", err)
+		}
+		metaOff = ow.off
+		if _, err := ow.Write(append(metaRecord, '\n')); err != nil {
+			return 0, fmt.Errorf("repair: write meta: %w", err)
+		}
+	}
+
+	return metaOff, nil
+}
+
+// writeHeader writes the header of a rebuild, with sections ending at
+// heapEnd, indexEnd, and metaOff, count documents, and IDs hashed by
+// alg, then syncs and closes tmp.
+func (db *DB) writeHeader(tmp storage, heapEnd, indexEnd, metaOff int64, count, alg int, insertion, deltas, shared bool) error {
+	flags := db.header.Flags & flagFoldLabels // fixed at creation
+	if insertion {
+		flags |= flagInsertionOrder
+	}
+	if deltas {
+		flags |= flagDeltaHistory
+	}
+	if shared {
+		flags |= flagSharedContent
+	}
 	hdr := Header{
-		Version:   1,
-		Timestamp: now(),
-		Algorithm: db.header.Algorithm,
+		Version:    FormatVersion,
+		Timestamp:  max(now(), db.header.Timestamp+1), // advances even within a millisecond
+		Algorithm:  alg,
+		Generation: db.header.Generation + 1,
+		Flags:      flags,
+		Codec:      db.header.Codec,
 		State: [6]uint64{
 			uint64(heapEnd),              // stHeap
 			uint64(indexEnd),             // stIndex
-			0,                            // stReserved
-			uint64(len(indexMap)),        // stCount
+			uint64(metaOff),              // stMeta
+			uint64(count),                // stCount
 			0,                            // stWrites (reset after compaction)
 			db.header.State[stThreshold], // stThreshold (preserve setting)
 		},
 	}
 	hdrBytes, err := hdr.encode()
 	if err != nil {
-		return 0, fmt.Errorf("repair: encode header: %w", err)
+		return fmt.Errorf("repair: encode header: %w", err)
 	}
 	if _, err := tmp.WriteAt(hdrBytes, 0); err != nil {
-		return 0, fmt.Errorf("repair: write header: %w", err)
+		return fmt.Errorf("repair: write header: %w", err)
 	}
 	if err := tmp.Sync(); err != nil {
-		return 0, fmt.Errorf("repair: sync: %w", err)
+		return fmt.Errorf("repair: sync: %w", err)
 	}
 	if err := tmp.Close(); err != nil {
-		return 0, fmt.Errorf("repair: close temp: %w", err)
+		return fmt.Errorf("repair: close temp: %w", err)
 	}
+	return nil
+}
 
-	return indexEnd, nil
+// liveTags returns the tag records to write after the index section:
+// one per tag and label, for documents that are still current under
+// the _c they were tagged with, sorted by ID. Each takes the _c its
+// document's index is rewritten with, which backfilling may change.
+func (db *DB) liveTags(entries []Entry, indexMap map[string]*Entry, created map[string]int64, salvage bool, alg int) ([]tagRecord, error) {
+	type pair struct{ tag, label string }
+	seen := map[pair]bool{}
+	var tags []tagRecord
+	for _, e := range entries {
+		if e.Type != TypeTag {
+			continue
+		}
+		data, err := line(db.reader, e.SrcOff)
+		if err != nil {
+			if salvage {
+				continue
+			}
+			return nil, fmt.Errorf("repair: read tag at %d: %w", e.SrcOff, err)
+		}
+		var t tagRecord
+		if err := json.Unmarshal(data, &t); err != nil {
+			if salvage {
+				continue
+			}
+			return nil, fmt.Errorf("repair: tag at %d: %w", e.SrcOff, ErrCorruptRecord)
+		}
+		lbl := label(data) // escaped, like the keys of indexMap
+		idx, ok := indexMap[lbl]
+		if !ok || idx.Created != t.Created || seen[pair{t.Tag, lbl}] {
+			continue
+		}
+		seen[pair{t.Tag, lbl}] = true
+		t.ID = hash(t.Tag, alg)
+		t.Created = created[lbl]
+		tags = append(tags, t)
+	}
+	slices.SortFunc(tags, func(a, b tagRecord) int {
+		return cmp.Or(cmp.Compare(a.ID, b.ID), cmp.Compare(a.Tag, b.Tag), cmp.Compare(a.Label, b.Label))
+	})
+	return tags, nil
+}
+
+// byInsertion reorders ID-sorted heap entries so that each document's
+// contiguous group of versions is placed by creation time. A group's
+// key is the _c of its index, or its oldest version when there is no
+// index (deleted documents) or the index predates _c. Ties fall back to
+// ID order so the layout is deterministic.
+func byInsertion(heap, indexes []Entry) []Entry {
+	createdByID := map[string]int64{}
+	for _, idx := range indexes {
+		if idx.Created == 0 {
+			continue
+		}
+		if ct, ok := createdByID[idx.ID]; !ok || idx.Created < ct {
+			createdByID[idx.ID] = idx.Created
+		}
+	}
+
+	type span struct {
+		ID         string
+		key        int64
+		start, end int
+	}
+	var spans []span
+	for i := 0; i < len(heap); {
+		j := i
+		for j < len(heap) && heap[j].ID == heap[i].ID {
+			j++
+		}
+		key, ok := createdByID[heap[i].ID]
+		if !ok {
+			key = heap[i].TS
+		}
+		spans = append(spans, span{heap[i].ID, key, i, j})
+		i = j
+	}
+	slices.SortStableFunc(spans, func(a, b span) int {
+		if c := cmp.Compare(a.key, b.key); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	out := make([]Entry, 0, len(heap))
+	for _, s := range spans {
+		out = append(out, heap[s.start:s.end]...)
+	}
+	return out
 }
 
 // offsetWriter adapts WriterAt to sequential writes. Repair needs WriterAt
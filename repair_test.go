@@ -16,7 +16,10 @@
 package folio
 
 import (
+	"errors"
+	"slices"
 	"testing"
+	"time"
 )
 
 // TestRepairSortsData verifies that Repair produces a sorted heap
@@ -62,7 +65,7 @@ func TestRepairPreservesHistory(t *testing.T) {
 
 	db.Repair(nil)
 
-	versions, _ := collect(db.History("doc"))
+	versions, _ := Collect(db.History("doc"))
 	if len(versions) != 3 {
 		t.Errorf("History: got %d versions, want 3", len(versions))
 	}
@@ -82,7 +85,7 @@ func TestRepairWithPurgeHistory(t *testing.T) {
 
 	db.Repair(&CompactOptions{PurgeHistory: true})
 
-	versions, _ := collect(db.History("doc"))
+	versions, _ := Collect(db.History("doc"))
 	if len(versions) != 1 {
 		t.Errorf("History after purge: got %d versions, want 1", len(versions))
 	}
@@ -167,7 +170,7 @@ func TestCompactPreservesHistory(t *testing.T) {
 
 	db.Compact()
 
-	versions, _ := collect(db.History("doc"))
+	versions, _ := Collect(db.History("doc"))
 	if len(versions) != 2 {
 		t.Errorf("History after Compact: got %d, want 2", len(versions))
 	}
@@ -187,7 +190,7 @@ func TestPurgeRemovesHistory(t *testing.T) {
 
 	db.Purge()
 
-	versions, _ := collect(db.History("doc"))
+	versions, _ := Collect(db.History("doc"))
 	if len(versions) != 1 {
 		t.Errorf("History after Purge: got %d, want 1", len(versions))
 	}
@@ -234,7 +237,7 @@ func TestRepairAfterDelete(t *testing.T) {
 	db.Repair(nil)
 
 	_, err := db.Get("a")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Get deleted after repair: got %v, want ErrNotFound", err)
 	}
 
@@ -290,7 +293,7 @@ func TestRepairBlockReaders(t *testing.T) {
 		t.Errorf("Get(b) = %q, want %q", data, "content-b")
 	}
 
-	versions, _ := collect(db.History("a"))
+	versions, _ := Collect(db.History("a"))
 	if len(versions) != 2 {
 		t.Errorf("History(a): got %d, want 2", len(versions))
 	}
@@ -317,7 +320,7 @@ func TestRepairBlockReadersPurge(t *testing.T) {
 		t.Errorf("Get = %q, want %q", data, "v3")
 	}
 
-	versions, _ := collect(db.History("doc"))
+	versions, _ := Collect(db.History("doc"))
 	if len(versions) != 1 {
 		t.Errorf("History: got %d, want 1", len(versions))
 	}
@@ -380,3 +383,99 @@ func TestCompactThenUpdate(t *testing.T) {
 		t.Errorf("Get = %q, want %q", data, "v2")
 	}
 }
+
+// insertionFixture writes documents whose IDs sort differently from
+// their creation order, with a mix of history, deletion, and rename.
+func insertionFixture(t *testing.T) (*DB, []string) {
+	t.Helper()
+	db := openTestDB(t)
+	order := []string{"zebra", "apple", "mango", "kiwi", "banana"}
+	for _, label := range order {
+		db.Set(label, label+"-v1")
+		time.Sleep(time.Millisecond)
+	}
+	db.Set("apple", "apple-v2")
+	db.Set("zebra", "zebra-v2")
+	db.Delete("kiwi")
+	return db, []string{"zebra", "apple", "mango", "banana"}
+}
+
+// TestRepairPreserveInsertionOrder verifies All yields documents in the
+// order they were first written after an insertion-ordered compaction,
+// and that Get and History still find every document. The index section
+// must stay sorted by ID or Get's binary search would miss documents.
+func TestRepairPreserveInsertionOrder(t *testing.T) {
+	db, want := insertionFixture(t)
+
+	if err := db.Repair(&CompactOptions{PreserveInsertionOrder: true}); err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if db.header.Flags&flagInsertionOrder == 0 {
+		t.Fatal("header flag not set")
+	}
+
+	docs, _ := Collect(db.All())
+	var got []string
+	for _, d := range docs {
+		got = append(got, d.Label)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("All order = %v, want %v", got, want)
+	}
+
+	for _, label := range want {
+		if _, err := db.Get(label); err != nil {
+			t.Errorf("Get(%s): %v", label, err)
+		}
+	}
+	versions, _ := Collect(db.History("apple"))
+	if len(versions) != 2 || versions[0].Data != "apple-v1" || versions[1].Data != "apple-v2" {
+		t.Errorf("History(apple) = %+v", versions)
+	}
+	deleted, _ := Collect(db.History("kiwi"))
+	if len(deleted) != 1 || deleted[0].Data != "kiwi-v1" {
+		t.Errorf("History(kiwi) = %+v, want the deleted version", deleted)
+	}
+
+	// Updated since compaction: index points into sparse, heap
+	// versions are found by the linear fallback.
+	db.Set("apple", "apple-v3")
+	versions, _ = Collect(db.History("apple"))
+	if len(versions) != 3 || versions[2].Data != "apple-v3" {
+		t.Errorf("History(apple) after update = %+v", versions)
+	}
+}
+
+// TestCompactKeepsInsertionOrder verifies that routine compaction keeps
+// the layout chosen by an explicit Repair, and that a Repair without the
+// option returns the heap to ID order.
+func TestCompactKeepsInsertionOrder(t *testing.T) {
+	db, want := insertionFixture(t)
+	db.Repair(&CompactOptions{PreserveInsertionOrder: true})
+
+	db.Set("cherry", "cherry-v1")
+	want = append(want, "cherry")
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if db.header.Flags&flagInsertionOrder == 0 {
+		t.Fatal("Compact dropped the insertion-order flag")
+	}
+	docs, _ := Collect(db.All())
+	var got []string
+	for _, d := range docs {
+		got = append(got, d.Label)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("All order after Compact = %v, want %v", got, want)
+	}
+
+	db.Repair(nil)
+	if db.header.Flags != 0 {
+		t.Errorf("Flags after Repair(nil) = %d, want 0", db.header.Flags)
+	}
+	versions, _ := Collect(db.History("apple"))
+	if len(versions) != 2 {
+		t.Errorf("History(apple) after returning to ID order = %d versions, want 2", len(versions))
+	}
+}
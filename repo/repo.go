@@ -0,0 +1,305 @@
+// Package repo provides a typed data-access layer over a folio database.
+//
+// A Repository[T] stores values of a struct type T as JSON documents under
+// a common label prefix. The struct declares which field supplies the
+// document label with a `folio:"label"` tag, so callers work with their
+// own types instead of building labels and marshalling by hand:
+//
+//	type User struct {
+//		Email string `json:"email" folio:"label"`
+//		Name  string `json:"name"`
+//		Ver   int64  `json:"-" folio:"version"`
+//	}
+//
+//	users, _ := repo.New[User](db, "users/")
+//	users.Put(&User{Email: "a@example.com", Name: "Ann"})
+//	u, _ := users.Get("a@example.com")
+//
+// An int64 field tagged `folio:"version"` opts in to optimistic
+// concurrency. Get fills it with the version of the stored document, the
+// xxHash3 of its content (the value httpd serves as its ETag), and Put
+// refuses to write unless the stored document still has that version (or,
+// when the field is zero, unless no document exists yet). After a
+// successful Put the field holds the new version. Tag the field `json:"-"`
+// so the version is not stored inside the JSON it is the hash of.
+//
+// Put makes the check and the write in one folio transaction, under the
+// database's write lock, so no other write to the file, from this process
+// or another, can land between them. Because the version is a hash of the
+// content rather than a time, two writes in the same millisecond still give
+// different versions; a write that restores a document's earlier content
+// gives its earlier version back, which is safe, since a value read from
+// that content is then current again.
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"reflect"
+	"strings"
+	"time"
+
+	json "github.com/goccy/go-json"
+	"github.com/jpl-au/folio"
+	"github.com/zeebo/xxh3"
+)
+
+// ErrConflict is returned by Put when the stored document has changed
+// since the value's version was read.
+var ErrConflict = errors.New("repo: document modified concurrently")
+
+// Repository stores values of type T under a label prefix.
+type Repository[T any] struct {
+	db     *folio.DB
+	prefix string
+	label  []int // field index path of the `folio:"label"` field
+	ver    []int // field index path of the `folio:"version"` field, nil if absent
+}
+
+// New returns a repository for T. T must be a struct with exactly one
+// string field tagged `folio:"label"`, and at most one int64 field tagged
+// `folio:"version"`.
+func New[T any](db *folio.DB, prefix string) (*Repository[T], error) {
+	rt := reflect.TypeFor[T]()
+	if rt.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("repo: %s is not a struct", rt)
+	}
+
+	r := &Repository[T]{db: db, prefix: prefix}
+	for _, f := range reflect.VisibleFields(rt) {
+		switch f.Tag.Get("folio") {
+		case "label":
+			if f.Type.Kind() != reflect.String {
+				return nil, fmt.Errorf("repo: label field %s must be a string", f.Name)
+			}
+			if r.label != nil {
+				return nil, fmt.Errorf("repo: %s has more than one label field", rt)
+			}
+			r.label = f.Index
+		case "version":
+			if f.Type.Kind() != reflect.Int64 {
+				return nil, fmt.Errorf("repo: version field %s must be an int64", f.Name)
+			}
+			if r.ver != nil {
+				return nil, fmt.Errorf("repo: %s has more than one version field", rt)
+			}
+			r.ver = f.Index
+		}
+	}
+	if r.label == nil {
+		return nil, fmt.Errorf("repo: %s has no `folio:\"label\"` field", rt)
+	}
+	return r, nil
+}
+
+// Key returns the key of v: the value of its label field.
+func (r *Repository[T]) Key(v *T) string {
+	return reflect.ValueOf(v).Elem().FieldByIndex(r.label).String()
+}
+
+// Get returns the value stored under key, or folio.ErrNotFound.
+func (r *Repository[T]) Get(key string) (T, error) {
+	var v T
+	data, err := r.db.Get(r.prefix + key)
+	if err != nil {
+		return v, err
+	}
+	if err := r.decode(key, data, &v); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// Put stores v under its key. With a version field, Put first checks that
+// the stored document is unchanged since v was read and returns
+// ErrConflict otherwise; on success the field is updated to the new
+// version.
+func (r *Repository[T]) Put(v *T) error {
+	key := r.Key(v)
+	if key == "" {
+		return fmt.Errorf("repo: put: empty key")
+	}
+	label := r.prefix + key
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("repo: put %s: %w", key, err)
+	}
+
+	if r.ver == nil {
+		return r.db.Set(label, string(data))
+	}
+
+	field := reflect.ValueOf(v).Elem().FieldByIndex(r.ver)
+	err = r.db.Txn(func(tx *folio.Txn) error {
+		var current int64
+		content, err := tx.Get(label)
+		switch {
+		case err == nil:
+			current = version(content)
+		case !errors.Is(err, folio.ErrNotFound):
+			return err
+		}
+		if current != field.Int() {
+			return ErrConflict
+		}
+		return tx.Set(label, string(data))
+	})
+	if err != nil {
+		if errors.Is(err, ErrConflict) {
+			return err
+		}
+		return fmt.Errorf("repo: put %s: %w", key, err)
+	}
+	field.SetInt(version(string(data)))
+	return nil
+}
+
+// Delete removes the value stored under key, or returns folio.ErrNotFound.
+func (r *Repository[T]) Delete(key string) error {
+	return r.db.Delete(r.prefix + key)
+}
+
+// List yields every value in the repository, with the version field
+// filled in. Documents under the prefix that do not decode as T are reported as
+// errors and iteration continues.
+func (r *Repository[T]) List() iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for doc, err := range r.db.AllInfo() {
+			var v T
+			if err != nil {
+				yield(v, err)
+				return
+			}
+			key, ok := strings.CutPrefix(doc.Label, r.prefix)
+			if !ok {
+				continue
+			}
+			if err := r.decode(key, doc.Data, &v); err != nil {
+				if !yield(v, err) {
+					return
+				}
+				continue
+			}
+			if !yield(v, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Event describes a change observed by Watch. Value is the zero T when
+// Deleted is set.
+type Event[T any] struct {
+	Key      string
+	Value    T
+	Deleted  bool
+	Modified int64 // unix ms of the change; 0 for deletions
+}
+
+// Watch polls the repository every interval and yields an Event for each
+// document created, updated, or deleted since the previous poll. Changes
+// made before Watch is called are not reported. Each poll reads every
+// document under the prefix and compares content hashes, so an update in
+// the same millisecond as the one before it is not missed, and writes
+// from other processes are observed too. Iteration ends when ctx is
+// cancelled or the caller stops.
+func (r *Repository[T]) Watch(ctx context.Context, interval time.Duration) iter.Seq2[Event[T], error] {
+	return func(yield func(Event[T], error) bool) {
+		seen, err := r.snapshot()
+		if err != nil {
+			yield(Event[T]{}, err)
+			return
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			next, err := r.snapshot()
+			if err != nil {
+				if !yield(Event[T]{}, err) {
+					return
+				}
+				continue
+			}
+			for key, st := range next {
+				if seen[key] == st {
+					continue
+				}
+				v, err := r.Get(key)
+				if errors.Is(err, folio.ErrNotFound) {
+					// Deleted before it could be read: treat it as
+					// absent, so a previously seen key is reported
+					// as deleted below.
+					delete(next, key)
+					continue
+				}
+				if !yield(Event[T]{Key: key, Value: v, Modified: st.modified}, err) {
+					return
+				}
+			}
+			for key := range seen {
+				if _, ok := next[key]; !ok {
+					if !yield(Event[T]{Key: key, Deleted: true}, nil) {
+						return
+					}
+				}
+			}
+			seen = next
+		}
+	}
+}
+
+// state is what Watch compares between polls to tell that a document
+// changed.
+type state struct {
+	modified int64
+	hash     string
+}
+
+// snapshot maps each key under the prefix to its state.
+func (r *Repository[T]) snapshot() (map[string]state, error) {
+	out := make(map[string]state)
+	for info, err := range r.db.AllInfo() {
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := strings.CutPrefix(info.Label, r.prefix); ok {
+			out[key] = state{info.Timestamp, info.ContentHash}
+		}
+	}
+	return out, nil
+}
+
+// decode unmarshals a stored document and restores its label field, so
+// values read back carry their key even if the field is tagged json:"-",
+// and its version field.
+func (r *Repository[T]) decode(key, data string, v *T) error {
+	if err := json.Unmarshal([]byte(data), v); err != nil {
+		return fmt.Errorf("repo: decode %s: %w", key, err)
+	}
+	rv := reflect.ValueOf(v).Elem()
+	rv.FieldByIndex(r.label).SetString(key)
+	if r.ver != nil {
+		rv.FieldByIndex(r.ver).SetInt(version(data))
+	}
+	return nil
+}
+
+// version returns the version of a document's content: its xxHash3, with
+// zero, which a version field holds for a document not yet stored, moved
+// to one.
+func version(content string) int64 {
+	if v := int64(xxh3.HashString(content)); v != 0 {
+		return v
+	}
+	return 1
+}
@@ -0,0 +1,273 @@
+// Repository tests.
+//
+// The repository is a thin layer, so these tests focus on the parts that
+// are easy to get subtly wrong: label derivation from struct tags, the
+// optimistic concurrency check, prefix isolation in List, and change
+// detection in Watch.
+package repo
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jpl-au/folio"
+)
+
+type user struct {
+	Email string `json:"-" folio:"label"`
+	Name  string `json:"name"`
+	Ver   int64  `json:"-" folio:"version"`
+}
+
+type note struct {
+	ID   string `json:"id" folio:"label"`
+	Body string `json:"body"`
+}
+
+func openRepo[T any](t *testing.T, prefix string) (*folio.DB, *Repository[T]) {
+	t.Helper()
+	db, err := folio.Open(filepath.Join(t.TempDir(), "test.folio"), folio.Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	r, err := New[T](db, prefix)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return db, r
+}
+
+// TestNewRejectsBadTypes verifies tag problems are reported when the
+// repository is built, not as confusing failures on the first Put.
+func TestNewRejectsBadTypes(t *testing.T) {
+	type noLabel struct{ Name string }
+	type intLabel struct {
+		ID int `folio:"label"`
+	}
+	type twoLabels struct {
+		A string `folio:"label"`
+		B string `folio:"label"`
+	}
+	if _, err := New[noLabel](nil, ""); err == nil {
+		t.Error("missing label field accepted")
+	}
+	if _, err := New[intLabel](nil, ""); err == nil {
+		t.Error("non-string label field accepted")
+	}
+	if _, err := New[twoLabels](nil, ""); err == nil {
+		t.Error("duplicate label fields accepted")
+	}
+	if _, err := New[string](nil, ""); err == nil {
+		t.Error("non-struct type accepted")
+	}
+}
+
+// TestPutGetDelete verifies the label comes from the tagged field under
+// the prefix, and that the key is restored on read even when the field
+// is excluded from the JSON.
+func TestPutGetDelete(t *testing.T) {
+	db, users := openRepo[user](t, "users/")
+
+	if err := users.Put(&user{Email: "ann@example.com", Name: "Ann"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if ok, _ := db.Exists("users/ann@example.com"); !ok {
+		t.Fatal("document not stored under prefix + key")
+	}
+
+	u, err := users.Get("ann@example.com")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if u.Email != "ann@example.com" || u.Name != "Ann" || u.Ver == 0 {
+		t.Errorf("Get = %+v", u)
+	}
+
+	if err := users.Delete("ann@example.com"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := users.Get("ann@example.com"); !errors.Is(err, folio.ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+}
+
+// TestPutConflict verifies a stale version is rejected. Without the
+// check, two read-modify-write cycles would silently lose one update.
+func TestPutConflict(t *testing.T) {
+	_, users := openRepo[user](t, "users/")
+
+	users.Put(&user{Email: "a", Name: "v1"})
+	first, _ := users.Get("a")
+	second, _ := users.Get("a")
+
+	first.Name = "v2"
+	if err := users.Put(&first); err != nil {
+		t.Fatalf("Put with current version: %v", err)
+	}
+	second.Name = "v3"
+	if err := users.Put(&second); !errors.Is(err, ErrConflict) {
+		t.Errorf("Put with stale version = %v, want ErrConflict", err)
+	}
+
+	// The successful Put refreshed first.Ver, so it can write again.
+	first.Name = "v4"
+	if err := users.Put(&first); err != nil {
+		t.Errorf("second Put with refreshed version: %v", err)
+	}
+
+	// A zero version means "create": it conflicts with an existing doc.
+	if err := users.Put(&user{Email: "a", Name: "dup"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("create over existing = %v, want ErrConflict", err)
+	}
+}
+
+// TestPutConcurrent verifies that of concurrent Puts made from the same
+// read, exactly one succeeds. Each round, every goroutine reads the
+// counter, waits for the others to have read it, then writes it back
+// incremented, as quickly as it can: a check on the modification time
+// passed every write landing in the millisecond of the read.
+func TestPutConcurrent(t *testing.T) {
+	type counter struct {
+		ID    string `json:"-" folio:"label"`
+		Count int    `json:"count"`
+		Ver   int64  `json:"-" folio:"version"`
+	}
+	_, counters := openRepo[counter](t, "counters/")
+	if err := counters.Put(&counter{ID: "c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, rounds = 8, 50
+	for round := range rounds {
+		var read, wg sync.WaitGroup
+		var ok atomic.Int64
+		read.Add(writers)
+		for range writers {
+			wg.Go(func() {
+				c, err := counters.Get("c")
+				read.Done()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				read.Wait()
+				c.Count++
+				switch err := counters.Put(&c); {
+				case err == nil:
+					ok.Add(1)
+				case !errors.Is(err, ErrConflict):
+					t.Error(err)
+				}
+			})
+		}
+		wg.Wait()
+		if n := ok.Load(); n != 1 {
+			t.Fatalf("round %d: %d Puts from the same read succeeded, want 1", round, n)
+		}
+	}
+	if c, err := counters.Get("c"); err != nil || c.Count != rounds {
+		t.Errorf("count = %d, %v; want %d", c.Count, err, rounds)
+	}
+}
+
+// TestListPrefix verifies List only yields documents under its prefix,
+// so two repositories can share one file.
+func TestListPrefix(t *testing.T) {
+	db, notes := openRepo[note](t, "notes/")
+	db.Set("other/x", `{"id":"x"}`)
+	notes.Put(&note{ID: "1", Body: "one"})
+	notes.Put(&note{ID: "2", Body: "two"})
+
+	got := map[string]string{}
+	for n, err := range notes.List() {
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		got[n.ID] = n.Body
+	}
+	if len(got) != 2 || got["1"] != "one" || got["2"] != "two" {
+		t.Errorf("List = %v", got)
+	}
+}
+
+// TestWatch verifies that documents created and deleted after Watch
+// starts are reported, and that existing documents are not.
+func TestWatch(t *testing.T) {
+	_, notes := openRepo[note](t, "notes/")
+	notes.Put(&note{ID: "old", Body: "before"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	events := make(chan Event[note])
+	go func() {
+		defer close(events)
+		for ev, err := range notes.Watch(ctx, 5*time.Millisecond) {
+			if err != nil {
+				t.Errorf("Watch: %v", err)
+				return
+			}
+			events <- ev
+		}
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	notes.Put(&note{ID: "new", Body: "hello"})
+	ev := <-events
+	if ev.Key != "new" || ev.Value.Body != "hello" || ev.Deleted {
+		t.Errorf("create event = %+v", ev)
+	}
+
+	notes.Delete("old")
+	ev = <-events
+	if ev.Key != "old" || !ev.Deleted {
+		t.Errorf("delete event = %+v", ev)
+	}
+	cancel()
+	for range events {
+	}
+}
+
+// TestWatchSameMillisecond verifies that Watch reports an update written
+// in the same millisecond as the version it replaces, which a comparison
+// of modification times alone would miss.
+func TestWatchSameMillisecond(t *testing.T) {
+	db, notes := openRepo[note](t, "notes/")
+	ts := time.Now().UnixMilli()
+	if err := db.Apply(folio.ChangeEvent{Op: folio.ChangeSet, Label: "notes/x", Data: `{"id":"x","body":"one"}`, TS: ts}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	events := make(chan Event[note])
+	go func() {
+		defer close(events)
+		for ev, err := range notes.Watch(ctx, 5*time.Millisecond) {
+			if err != nil {
+				t.Errorf("Watch: %v", err)
+				return
+			}
+			events <- ev
+		}
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if err := db.Apply(folio.ChangeEvent{Op: folio.ChangeSet, Label: "notes/x", Data: `{"id":"x","body":"two"}`, TS: ts}); err != nil {
+		t.Fatal(err)
+	}
+	ev := <-events
+	if ev.Key != "x" || ev.Value.Body != "two" || ev.Modified != ts {
+		t.Errorf("update event = %+v", ev)
+	}
+	cancel()
+	for range events {
+	}
+}
@@ -0,0 +1,70 @@
+// History retention: bounding the versions kept for each document.
+//
+// Every Set retires the previous version to a history record, so a
+// frequently updated document accumulates history without limit until
+// Purge removes all of it. Config.HistoryRetention sets a middle ground
+// that every rebuild (Compact, Repair, and crash recovery) enforces:
+// history records beyond MaxVersions, or older than MaxAge, are left out
+// of the new file. The current version of a live document is never
+// dropped, however old it is. A deleted document has only history, so
+// its newest versions are kept by the same rules and it disappears
+// entirely once all of them are older than MaxAge.
+//
+// Retention works on the heap entries alone, grouped by ID, so it costs
+// no extra reads. Two labels only share an ID on a hash collision, in
+// which case they share one version budget.
+package folio
+
+import "time"
+
+// Retention limits the history kept across compactions. Zero fields are
+// unlimited, so the zero value keeps everything.
+type Retention struct {
+	MaxVersions int           // versions kept per document, current included
+	MaxAge      time.Duration // history older than this is dropped
+}
+
+// retain returns heap without the history records r does not keep, as of
+// unix ms time t. heap must be sorted by ID then timestamp.
+func retain(heap []Entry, r Retention, t int64) []Entry {
+	if r.MaxVersions <= 0 && r.MaxAge <= 0 {
+		return heap
+	}
+	cutoff := int64(0)
+	if r.MaxAge > 0 {
+		cutoff = t - r.MaxAge.Milliseconds()
+	}
+
+	out := heap[:0]
+	for i := 0; i < len(heap); {
+		j := i
+		current := 0
+		for j < len(heap) && heap[j].ID == heap[i].ID {
+			if heap[j].Type != TypeHistory {
+				current++
+			}
+			j++
+		}
+
+		// Walking the group oldest first, a history record survives if
+		// fewer than MaxVersions versions follow it and it is recent
+		// enough. Records that are not history are always kept.
+		history := j - i - current
+		for k := i; k < j; k++ {
+			e := heap[k]
+			if e.Type == TypeHistory {
+				history--
+				newer := history + current
+				if r.MaxVersions > 0 && newer >= r.MaxVersions {
+					continue
+				}
+				if e.TS < cutoff {
+					continue
+				}
+			}
+			out = append(out, e)
+		}
+		i = j
+	}
+	return out
+}
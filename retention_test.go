@@ -0,0 +1,111 @@
+// History retention tests.
+//
+// Retention discards data permanently, so the tests pin down exactly what
+// survives: the newest versions up to the limit, never a live document's
+// current version, and nothing when the file is opened without a policy.
+package folio
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestRetentionMaxVersions verifies Compact keeps only the newest
+// versions of each document, counting the current one, and leaves
+// documents under the limit untouched.
+func TestRetentionMaxVersions(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{
+		HistoryRetention: Retention{MaxVersions: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, v := range []string{"v1", "v2", "v3", "v4"} {
+		db.Set("busy", v)
+	}
+	db.Set("quiet", "only")
+	db.Set("gone", "g1")
+	db.Set("gone", "g2")
+	db.Set("gone", "g3")
+	db.Delete("gone")
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+
+	want := map[string][]string{
+		"busy":  {"v3", "v4"},
+		"quiet": {"only"},
+		"gone":  {"g2", "g3"},
+	}
+	for lbl, data := range want {
+		versions, err := Collect(db.History(lbl))
+		if err != nil {
+			t.Fatalf("History(%s): %v", lbl, err)
+		}
+		var got []string
+		for _, v := range versions {
+			got = append(got, v.Data)
+		}
+		if !slices.Equal(got, data) {
+			t.Errorf("History(%s) = %v, want %v", lbl, got, data)
+		}
+	}
+	if got, _ := db.Get("busy"); got != "v4" {
+		t.Errorf("Get = %q, want v4", got)
+	}
+}
+
+// TestRetentionDefault verifies that without a policy Compact keeps all
+// history, as it always has.
+func TestRetentionDefault(t *testing.T) {
+	db := openTestDB(t)
+	for _, v := range []string{"v1", "v2", "v3"} {
+		db.Set("doc", v)
+	}
+	db.Compact()
+	if versions, _ := Collect(db.History("doc")); len(versions) != 3 {
+		t.Errorf("History = %d versions, want 3", len(versions))
+	}
+}
+
+// TestRetain verifies the age rule directly, since real timestamps are
+// too close together to age out in a test: old history goes, recent
+// history stays, and a current record is kept however old it is.
+func TestRetain(t *testing.T) {
+	const t0 = 1_700_000_000_000
+	hour := time.Hour.Milliseconds()
+	heap := []Entry{
+		{ID: "a", TS: t0, Type: TypeHistory},
+		{ID: "a", TS: t0 + 5*hour, Type: TypeHistory},
+		{ID: "a", TS: t0 + 6*hour, Type: TypeRecord},
+		{ID: "b", TS: t0, Type: TypeRecord},
+		{ID: "c", TS: t0, Type: TypeHistory},
+	}
+	now := t0 + 7*hour
+
+	got := retain(append([]Entry(nil), heap...), Retention{MaxAge: 3 * time.Hour}, now)
+	want := []Entry{heap[1], heap[2], heap[3]}
+	if len(got) != len(want) {
+		t.Fatalf("retain = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("retain[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// Both limits together: one version per document.
+	got = retain(append([]Entry(nil), heap...), Retention{MaxVersions: 1, MaxAge: 3 * time.Hour}, now)
+	if len(got) != 2 || got[0] != heap[2] || got[1] != heap[3] {
+		t.Errorf("retain with both limits = %v", got)
+	}
+
+	if got := retain(append([]Entry(nil), heap...), Retention{}, now); len(got) != len(heap) {
+		t.Errorf("zero Retention dropped entries: %v", got)
+	}
+}
@@ -0,0 +1,123 @@
+// Salvage of a .tmp left behind by an interrupted Repair.
+//
+// Repair writes the rebuilt file to name.tmp, writes its header last,
+// syncs, and renames it over the original. A crash before the rename
+// orphans the .tmp. Usually the original is intact and the .tmp is simply
+// discarded, but when the original is dirty or its header is unreadable
+// the .tmp may be the better copy: it was built from a consistent
+// snapshot and has never been appended to.
+//
+// A .tmp is only promoted when it is demonstrably complete: a parseable
+// clean header (written last, so a torn rebuild still has the zeroed
+// placeholder), section boundaries inside the file, a final newline, and
+// an index section whose every line decodes and points into the heap.
+// A dirty original also has to be no newer than the .tmp — if the
+// original was modified after the rebuild finished, those writes exist
+// only in the original and the normal repair path must run instead.
+package folio
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// salvage promotes name.tmp over name when the original cannot be trusted
+// and the .tmp is complete. It returns the reader to continue opening
+// with: the original if nothing was promoted, otherwise a fresh handle on
+// the promoted file. The exclusive flock is held across the check and the
+// rename so two processes opening a crashed file cannot both promote.
+// A promotion is logged to log.
+func salvage(root *os.Root, name string, reader *os.File, config Config, log *slog.Logger) (*os.File, error) {
+	tmpInfo, err := root.Stat(name + ".tmp")
+	if err != nil {
+		return reader, nil
+	}
+
+	lock := &fileLock{f: reader, timeout: config.LockTimeout}
+	if err := lock.Lock(LockExclusive); err != nil {
+		return reader, nil
+	}
+	defer lock.Unlock()
+
+	hdr, hdrErr := header(reader)
+	if hdrErr == nil && hdr.Error == 0 || errors.Is(hdrErr, ErrFormatVersion) {
+		return reader, nil // intact, or not ours to judge
+	}
+	if hdrErr == nil {
+		info, err := reader.Stat()
+		if err != nil || info.ModTime().After(tmpInfo.ModTime()) {
+			return reader, nil
+		}
+	}
+
+	tmp, err := root.Open(name + ".tmp")
+	if err != nil {
+		return reader, nil
+	}
+	ok := complete(tmp, config)
+	tmp.Close()
+	if !ok {
+		return reader, nil
+	}
+
+	if err := root.Rename(name+".tmp", name); err != nil {
+		return reader, nil
+	}
+	promoted, err := root.OpenFile(name, os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("salvage: reopen: %w", err)
+	}
+	lock.setFile(nil)
+	reader.Close()
+	log.Warn("recovering from unclean shutdown: promoted finished rebuild", "dirty", hdrErr == nil, "corrupt_header", hdrErr != nil)
+	return promoted, nil
+}
+
+// complete reports whether f is a finished Repair output.
+func complete(f *os.File, config Config) bool {
+	sz, err := size(f)
+	if err != nil || sz < HeaderSize {
+		return false
+	}
+	hdr, err := header(f)
+	if err != nil || hdr.Error != 0 {
+		return false
+	}
+
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, sz-1); err != nil || last[0] != '\n' {
+		return false
+	}
+
+	heapEnd, indexEnd := int64(hdr.State[stHeap]), int64(hdr.State[stIndex])
+	if heapEnd < HeaderSize || indexEnd < heapEnd || indexEnd > sz {
+		return false
+	}
+	if _, err := f.ReadAt(last, indexEnd-1); err != nil || last[0] != '\n' {
+		return false
+	}
+
+	section := io.NewSectionReader(f, heapEnd, indexEnd-heapEnd)
+	scanner := bufio.NewScanner(section)
+	scanner.Buffer(make([]byte, config.ReadBuffer), config.MaxRecordSize)
+	var n uint64
+	for scanner.Scan() {
+		data := scanner.Bytes()
+		if len(data) >= MinRecordSize && data[TypePos] == byte('0'+TypeTable) {
+			break // the hash table follows the index lines
+		}
+		if len(data) < MinRecordSize || data[TypePos] != byte('0'+TypeIndex) {
+			return false
+		}
+		idx, err := decodeIndex(data)
+		if err != nil || idx.Offset < HeaderSize || idx.Offset >= heapEnd {
+			return false
+		}
+		n++
+	}
+	return scanner.Err() == nil && n == hdr.State[stCount]
+}
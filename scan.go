@@ -19,7 +19,6 @@ import (
 	"bufio"
 	"cmp"
 	"io"
-	"os"
 	"slices"
 	"strconv"
 )
@@ -28,38 +27,14 @@ import (
 // matches id. Because records are variable-length, the midpoint may land
 // inside a record, so we align to the nearest newline to find a valid pivot.
 // If the forward alignment fails (e.g. lands past end), we fall back to
-// scanning backwards for a pivot.
-func scan(f *os.File, id string, start, end int64, recordType int) *Result {
+// scanning backwards for a pivot. Both directions read a block at a time,
+// so a step costs a read or two however long the records are.
+func scan(f storage, id string, start, end int64, recordType int) *Result {
 	if start >= end {
 		return nil
 	}
 
-	mid := (start + end) / 2
-
-	// Find a valid record to use as pivot
-	var pivot *Result
-	var pivotEnd int64
-
-	newlinePos, _ := align(f, mid)
-	if newlinePos >= 0 && newlinePos+1 < end {
-		recordStart := newlinePos + 1
-		data, err := line(f, recordStart)
-		if err == nil && len(data) > 0 && valid(data) {
-			if len(data) >= MinRecordSize && (recordType == 0 || data[TypePos] == byte('0'+recordType)) {
-				id := string(data[IDStart:IDEnd])
-				pivot = &Result{recordStart, len(data), data, id}
-				pivotEnd = recordStart + int64(len(data)) + 1
-			}
-		}
-	}
-
-	if pivot == nil {
-		pivot = scanBack(f, mid, start, recordType)
-		if pivot != nil {
-			pivotEnd = pivot.Offset + int64(pivot.Length) + 1
-		}
-	}
-
+	pivot, pivotEnd := pivot(f, start, end, recordType)
 	if pivot == nil {
 		return nil
 	}
@@ -73,25 +48,41 @@ func scan(f *os.File, id string, start, end int64, recordType int) *Result {
 	return scan(f, id, pivotEnd, end, recordType)
 }
 
-// scanBack walks backwards byte-by-byte to find a valid pivot when the
-// forward alignment in scan lands outside the search range.
-func scanBack(f *os.File, pos, start int64, recordType int) *Result {
-	var buf [1]byte
+// pivot finds the record scan compares against when searching between
+// start and end, and the offset just past its line. It returns nil if
+// the range holds no valid record of the type.
+func pivot(f storage, start, end int64, recordType int) (*Result, int64) {
+	mid := (start + end) / 2
+
+	data, recordStart, err := lineAfter(f, mid, end)
+	if err == nil && recordStart >= 0 && len(data) > 0 && valid(data) {
+		if len(data) >= MinRecordSize && (recordType == 0 || data[TypePos] == byte('0'+recordType)) {
+			id := string(data[IDStart:IDEnd])
+			return &Result{recordStart, len(data), data, id}, recordStart + int64(len(data)) + 1
+		}
+	}
+
+	pivot := scanBack(f, mid, start, recordType)
+	if pivot == nil {
+		return nil, 0
+	}
+	return pivot, pivot.Offset + int64(pivot.Length) + 1
+}
+
+// scanBack walks backwards to find a valid pivot when the forward
+// alignment in scan lands outside the search range, finding each earlier
+// record boundary with a block read rather than a byte at a time.
+func scanBack(f storage, pos, start int64, recordType int) *Result {
 	for pos > start {
-		pos--
-		for pos > start {
-			if _, err := f.ReadAt(buf[:], pos); err != nil {
-				return nil
-			}
-			if buf[0] == '\n' {
-				break
-			}
-			pos--
+		nl, err := lastNewline(f, start+1, pos)
+		if err != nil {
+			return nil
 		}
 
-		recordStart := pos + 1
-		if pos == start {
-			recordStart = start
+		recordStart := start
+		pos = start
+		if nl >= 0 {
+			recordStart, pos = nl+1, nl
 		}
 
 		data, err := line(f, recordStart)
@@ -109,7 +100,7 @@ func scanBack(f *os.File, pos, start int64, recordType int) *Result {
 
 // scanFwd walks forward line-by-line. Used when we need the first record
 // of a given type in a region (e.g. finding the start of the index section).
-func scanFwd(f *os.File, pos, end int64, recordType int) *Result {
+func scanFwd(f storage, pos, end int64, recordType int) *Result {
 	for pos < end {
 		data, err := line(f, pos)
 		if err != nil || len(data) == 0 {
@@ -128,11 +119,41 @@ func scanFwd(f *os.File, pos, end int64, recordType int) *Result {
 	return nil
 }
 
+// seek binary-searches a sorted region for the first valid record whose
+// ID is not less than id, returning its offset, or end if there is none.
+// Unlike scan it finds a position rather than a match, so callers can
+// read forward from a point in ID order (ListPage resumes this way).
+func seek(f storage, id string, start, end int64) int64 {
+	found := end
+	for start < end {
+		mid := (start + end) / 2
+		boundary := start
+		if mid > start {
+			nl, err := align(f, mid-1)
+			if err != nil || nl < 0 {
+				return found
+			}
+			boundary = nl + 1
+		}
+		r := scanFwd(f, boundary, end, 0)
+		switch {
+		case r == nil:
+			end = mid
+		case r.ID >= id:
+			found = r.Offset
+			end = mid
+		default:
+			start = r.Offset + int64(r.Length) + 1
+		}
+	}
+	return found
+}
+
 // group binary-searches a sorted region for any record with the given ID
 // (type-agnostic), then forward-scans to collect all contiguous records
 // sharing that ID. Returns them in file order (oldest first after
 // compaction). Used by History to collect all versions from the heap.
-func group(f *os.File, id string, start, end int64) []Result {
+func group(f storage, id string, start, end int64) []Result {
 	if start >= end {
 		return nil
 	}
@@ -141,31 +162,36 @@ func group(f *os.File, id string, start, end int64) []Result {
 	if hit == nil {
 		return nil
 	}
+	return groupAt(f, hit, id, start, end)
+}
 
+// groupAt collects the contiguous run of records sharing id around a
+// known hit inside [start, end). group supplies the hit by binary
+// search; an insertion-ordered heap supplies it from the sorted index.
+func groupAt(f storage, hit *Result, id string, start, end int64) []Result {
 	// Walk backwards from the hit to find the first record in this ID group.
 	first := hit.Offset
 	for first > start {
-		// Find previous newline
-		prev := first - 1
-		var buf [1]byte
-		for prev > start {
-			if _, err := f.ReadAt(buf[:], prev-1); err != nil {
-				break
-			}
-			if buf[0] == '\n' {
-				break
-			}
-			prev--
+		// Find the newline before the one ending the previous record.
+		nl, err := lastNewline(f, start, first-1)
+		if err != nil {
+			break
 		}
-		recordStart := prev
-		if prev > start {
-			recordStart = prev // byte after newline
+		recordStart := start
+		if nl >= 0 {
+			recordStart = nl + 1
 		}
 
 		data, err := line(f, recordStart)
-		if err != nil || !valid(data) || len(data) < MinRecordSize {
+		if err != nil {
 			break
 		}
+		if !valid(data) || len(data) < MinRecordSize {
+			// A line blanked by quarantine may sit inside the group;
+			// the forward scan skips it.
+			first = recordStart
+			continue
+		}
 		rid := string(data[IDStart:IDEnd])
 		if rid != id {
 			break
@@ -201,7 +227,7 @@ func group(f *os.File, id string, start, end int64) []Result {
 // sparse linearly scans an unsorted region. Every record is JSON-parsed
 // because IDs are not in sorted order — there is no way to short-circuit.
 // Pass an empty id to collect all records of the given type (used by List).
-func sparse(f *os.File, id string, start, end int64, recordType int) []Result {
+func sparse(f storage, id string, start, end int64, recordType int) []Result {
 	var results []Result
 
 	section := io.NewSectionReader(f, start, end-start)
@@ -214,7 +240,7 @@ func sparse(f *os.File, id string, start, end int64, recordType int) []Result {
 		length := len(data)
 
 		if valid(data) {
-			record, err := decode(data)
+			record, err := parse(data)
 			if err == nil && record.Type == recordType {
 				if id == "" || record.ID == id {
 					dataCopy := make([]byte, length) // scanner reuses its buffer
@@ -235,7 +261,7 @@ func sparse(f *os.File, id string, start, end int64, recordType int) []Result {
 // and these fields are always serialised in the same order and width.
 // Pass recordType=0 to collect all types. Used by compaction and bloom
 // filter construction where only ID, type, and timestamp are needed.
-func scanm(f *os.File, start, end int64, recordType int) []Entry {
+func scanm(f storage, start, end int64, recordType int) []Entry {
 	var entries []Entry
 
 	section := io.NewSectionReader(f, start, end-start)
@@ -252,11 +278,14 @@ func scanm(f *os.File, start, end int64, recordType int) []Entry {
 			if recordType == 0 || t == recordType {
 				id := string(ln[IDStart:IDEnd])
 				ts, _ := strconv.ParseInt(string(ln[TSStart:TSEnd]), 10, 64)
-				lbl := ""
+				var lbl string
+				var ct, ex int64
 				if t == TypeIndex {
 					lbl = label(ln)
+					ct = created(ln)
+					ex = expires(ln)
 				}
-				entries = append(entries, Entry{id, ts, t, offset, 0, length, lbl})
+				entries = append(entries, Entry{id, ts, t, offset, 0, length, lbl, ct, ex})
 			}
 		}
 
@@ -18,6 +18,7 @@ package folio
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -157,6 +158,44 @@ func TestScanWrongType(t *testing.T) {
 	}
 }
 
+// TestSeek verifies seek lands on the first record whose ID is not less
+// than the target, for IDs present, absent, before the first, and past
+// the last, with a blanked line in the way. ListPage resumes from this
+// position, so an off-by-one-line would skip or repeat a document.
+func TestSeek(t *testing.T) {
+	lines := []string{
+		makeIndex("0000000000000002", "b"),
+		makeIndex("0000000000000004", "d"),
+		strings.Repeat(" ", len(makeIndex("0000000000000005", "e"))),
+		makeIndex("0000000000000006", "f"),
+		makeIndex("0000000000000008", "h"),
+	}
+	content := strings.Join(lines, "\n") + "\n"
+	f := createScanTestFile(t, content)
+	end := fsize(t, f)
+
+	offset := func(i int) int64 {
+		return int64(len(strings.Join(lines[:i], "\n")) + min(i, 1))
+	}
+	tests := []struct {
+		id   string
+		want int64
+	}{
+		{"0000000000000001", offset(0)},
+		{"0000000000000002", offset(0)},
+		{"0000000000000003", offset(1)},
+		{"0000000000000005", offset(3)},
+		{"0000000000000006", offset(3)},
+		{"0000000000000008", offset(4)},
+		{"0000000000000009", end},
+	}
+	for _, tt := range tests {
+		if got := seek(f, tt.id, 0, end); got != tt.want {
+			t.Errorf("seek(%s) = %d, want %d", tt.id, got, tt.want)
+		}
+	}
+}
+
 // TestScanBackFindRecord verifies that scanBack finds the last record
 // when scanning backwards from the end. scanBack is used by the
 // sorted-section search when binary search lands past the target — it
@@ -177,6 +216,36 @@ func TestScanBackFindRecord(t *testing.T) {
 	}
 }
 
+// countingFile counts the reads made through it.
+type countingFile struct {
+	*os.File
+	reads int
+}
+
+func (c *countingFile) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	return c.File.ReadAt(p, off)
+}
+
+// TestScanBackReadsBlocks verifies that scanBack finds each earlier
+// record boundary with a few block reads rather than one read per byte,
+// so walking back over long records stays cheap.
+func TestScanBackReadsBlocks(t *testing.T) {
+	long := strings.Repeat("x", 3000)
+	content := makeIndex("0000000000000001", long) + "\n" +
+		makeRecord("0000000000000002", long) + "\n" +
+		makeRecord("0000000000000003", long) + "\n"
+
+	f := &countingFile{File: createScanTestFile(t, content)}
+	result := scanBack(f, fsize(t, f.File), 0, TypeIndex)
+	if result == nil || result.ID != "0000000000000001" {
+		t.Fatalf("scanBack = %+v, want the index record", result)
+	}
+	if f.reads > 20 {
+		t.Errorf("scanBack made %d reads over %d bytes", f.reads, len(content))
+	}
+}
+
 // TestScanBackNoRecord verifies that scanBack returns nil for an empty
 // file. This is the termination condition — without it, scanBack would
 // read past offset 0 and panic.
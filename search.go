@@ -28,6 +28,35 @@
 // typically short and the allocation is bounded to the _d field, not the
 // full record line. Revisit if profiling shows GC pressure from search.
 //
+// With Config.EncryptionKey set, each encrypted record is decrypted as
+// the scan reaches it and matched as plain text, so the literal path is
+// never used. Binary records are skipped: their _d is base64.
+//
+// Each match is located again in the document's unescaped content, so
+// MatchStart and MatchEnd are offsets into the document as stored by Set
+// rather than into its JSON encoding. Only documents that matched pay
+// for this. Options can ask for more than one match per document and for
+// a snippet of the text around each.
+//
+// IncludeHistory also matches the decompressed _h snapshot of every
+// history record (_r=3) the scan passes, yielding a Match per matching
+// version with its timestamp, so a caller can see when text appeared in
+// or left a document. A data record's own _h holds its current content,
+// already matched through _d, so only history records are decompressed.
+// Retired versions of deleted documents are searched too.
+//
+// With SearchOptions.Parallelism above one, the heap and sparse regions
+// are split into ranges that start on record boundaries and scanned by
+// that many goroutines at once, sharing the read lock the iterator holds.
+// Matches are still yielded in file order: a range's matches are kept
+// until those of the ranges before it have been yielded. Breaking from
+// the loop stops the workers, and Search waits for them before it
+// releases the lock.
+//
+// LabelPattern scopes a search to the documents whose labels match it,
+// checked before the content of a record is looked at, so that the rest
+// cost only the label read.
+//
 // MatchLabel scans index records (_r=1) and matches against _l. It scans
 // only the index section and sparse region, skipping the heap entirely.
 //
@@ -42,7 +71,12 @@ import (
 	"fmt"
 	"io"
 	"iter"
+	"path"
 	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
 
 	json "github.com/goccy/go-json"
 )
@@ -50,21 +84,122 @@ import (
 // SearchOptions configures Search behaviour. Callers control result count
 // by breaking out of the range loop — no Limit field is needed.
 type SearchOptions struct {
-	CaseSensitive bool
-	Decode        bool // unescape JSON string escapes in _d before matching; bypasses literal fast path
+	CaseSensitive    bool
+	Decode           bool // unescape JSON string escapes in _d before matching; bypasses literal fast path
+	Snippet          int  // bytes of context either side of a match in Match.Snippet; 0 for none
+	MaxMatchesPerDoc int  // matches yielded per document; 0 or 1 for the first only, -1 for all
+
+	// LabelPattern, if set, limits the search to documents whose label
+	// matches it: a glob in the syntax of path.Match, where * stops at a
+	// slash ("notes/*" matches notes/a but not notes/a/b), or a regex if
+	// LabelRegex is set. Either way it is case-sensitive, as labels are.
+	LabelPattern string
+	LabelRegex   bool
+
+	// IncludeHistory also matches previous versions of each document,
+	// not only the current one. Matches on them have History set.
+	IncludeHistory bool
+
+	// Parallelism is the number of goroutines scanning the file at once;
+	// 0 or 1 scans it in one. Snapshot.Search ignores it.
+	Parallelism int
 }
 
-// Match is a single search result: a label and the byte offset of the
-// matching record in the file.
+// Match is a single search result: a label, the byte offset of the
+// matching record in the file, and where the match lies in the
+// document's content. Search sets the version fields too, and Snippet if
+// SearchOptions.Snippet asks for it; MatchLabel sets only Label and
+// Offset.
 type Match struct {
-	Label  string
-	Offset int64
+	Label      string
+	Offset     int64
+	Timestamp  int64  // unix ms write time of the matching version
+	History    bool   // a previous version matched, not the current one
+	MatchStart int    // byte offset of the match in the content; -1 if it matched only the escaped form
+	MatchEnd   int    // byte offset just past the match; -1 with MatchStart
+	Snippet    string // the match with up to SearchOptions.Snippet bytes either side
+}
+
+// locate finds the matches of re in content, the unescaped content of a
+// document already known to match, and returns one Match each, copied
+// from m, up to opts.MaxMatchesPerDoc. A pattern that matched only the
+// escaped form, such as one containing a backslash, is not found again:
+// it gets a single Match with no position.
+func locate(m Match, content []byte, re *regexp.Regexp, opts SearchOptions) []Match {
+	n := opts.MaxMatchesPerDoc
+	if n == 0 {
+		n = 1
+	}
+	locs := re.FindAllIndex(content, n)
+	if len(locs) == 0 {
+		m.MatchStart, m.MatchEnd = -1, -1
+		return []Match{m}
+	}
+	out := make([]Match, len(locs))
+	for i, loc := range locs {
+		out[i] = m
+		out[i].MatchStart, out[i].MatchEnd = loc[0], loc[1]
+		if opts.Snippet > 0 {
+			out[i].Snippet = snippet(content, loc[0], loc[1], opts.Snippet)
+		}
+	}
+	return out
+}
+
+// snippet returns content[start:end] with up to n bytes either side,
+// trimmed so that it does not cut a UTF-8 sequence.
+func snippet(content []byte, start, end, n int) string {
+	lo, hi := max(start-n, 0), min(end+n, len(content))
+	for lo < start && !utf8.RuneStart(content[lo]) {
+		lo++
+	}
+	for hi > end && hi < len(content) && !utf8.RuneStart(content[hi]) {
+		hi--
+	}
+	return string(content[lo:hi])
+}
+
+// labelFilter returns a test of labels against opts.LabelPattern, or nil
+// if there is none.
+func labelFilter(opts SearchOptions) (func(string) bool, error) {
+	switch {
+	case opts.LabelPattern == "":
+		return nil, nil
+	case opts.LabelRegex:
+		re, err := regexp.Compile(opts.LabelPattern)
+		if err != nil {
+			return nil, ErrInvalidPattern
+		}
+		return re.MatchString, nil
+	}
+	if _, err := path.Match(opts.LabelPattern, ""); err != nil {
+		return nil, ErrInvalidPattern
+	}
+	return func(lbl string) bool {
+		ok, _ := path.Match(opts.LabelPattern, lbl)
+		return ok
+	}, nil
+}
+
+// locator compiles pattern for locate. A literal pattern has no
+// metacharacters, so it is a regex matching itself.
+func locator(pattern string, opts SearchOptions) (*regexp.Regexp, error) {
+	if !opts.CaseSensitive {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, ErrInvalidPattern
+	}
+	return re, nil
 }
 
 // Search matches a pattern against the _d field of current data records.
 // Results are yielded lazily; break from the range loop to stop early.
 func (db *DB) Search(pattern string, opts SearchOptions) iter.Seq2[Match, error] {
 	return func(yield func(Match, error) bool) {
+		db.beginScan()
+		defer db.endScan()
 		if err := db.blockRead(); err != nil {
 			yield(Match{}, err)
 			return
@@ -74,10 +209,23 @@ func (db *DB) Search(pattern string, opts SearchOptions) iter.Seq2[Match, error]
 			db.lock.Unlock()
 		}()
 
+		re, err := locator(pattern, opts)
+		if err != nil {
+			yield(Match{}, err)
+			return
+		}
+		scope, err := labelFilter(opts)
+		if err != nil {
+			yield(Match{}, err)
+			return
+		}
 		var match func([]byte) bool
 		var decode bool
 
-		if !opts.Decode && regexp.QuoteMeta(pattern) == pattern {
+		// The literal fast path compares against escaped JSON, but
+		// decrypted content is plain text, so encrypted files always
+		// take the regex path.
+		if !opts.Decode && db.cipher == nil && regexp.QuoteMeta(pattern) == pattern {
 			raw, _ := json.Marshal(pattern)
 			needle := raw[1 : len(raw)-1]
 			if opts.CaseSensitive {
@@ -91,14 +239,6 @@ func (db *DB) Search(pattern string, opts SearchOptions) iter.Seq2[Match, error]
 				}
 			}
 		} else {
-			if !opts.CaseSensitive {
-				pattern = "(?i)" + pattern
-			}
-			re, err := regexp.Compile(pattern)
-			if err != nil {
-				yield(Match{}, ErrInvalidPattern)
-				return
-			}
 			match = re.Match
 			decode = opts.Decode
 		}
@@ -112,33 +252,124 @@ func (db *DB) Search(pattern string, opts SearchOptions) iter.Seq2[Match, error]
 		dTag := []byte(`"_d":"`)
 		hTag := []byte(`","_h":"`)
 
+		inScope := func(ln []byte) bool {
+			return scope == nil || scope(string(unescape([]byte(label(ln)))))
+		}
+
+		// restored is the last history version past read in a region, so
+		// a delta right after it is patched onto it without reading the
+		// versions before again.
+		type restored struct {
+			end     int64 // offset of the line after it
+			label   string
+			content []byte
+		}
+
+		// past matches the snapshot of one history record. Returns false
+		// if the caller broke out of the range loop.
+		past := func(ln []byte, offset int64, last *restored, yield func(Match, error) bool) bool {
+			m := Match{Label: label(ln), Offset: offset, History: true}
+			r, err := db.decode(ln)
+			var content []byte
+			switch {
+			case err != nil:
+			case !r.Delta:
+				content, err = db.restore(r, nil)
+			case last.end == offset && db.same(last.label, r.Label):
+				content, err = db.restore(r, last.content)
+			default:
+				var v Version
+				if v, err = db.restoreAt(r.Label, offset); err == nil {
+					content = []byte(v.Data)
+				}
+			}
+			if err != nil {
+				*last = restored{}
+				return yield(m, fmt.Errorf("search: %w", err))
+			}
+			*last = restored{offset + int64(len(ln)) + 1, r.Label, content}
+			if !utf8.Valid(content) || !re.Match(content) {
+				return true // binary versions are skipped, as binary records are
+			}
+			m.Timestamp = r.Timestamp
+			for _, m := range locate(m, content, re, opts) {
+				if !yield(m, nil) {
+					return false
+				}
+			}
+			return true
+		}
+
 		// scanRegion scans [start, end) for data records matching the
 		// pattern. Returns false if the caller broke out of the range loop.
-		scanRegion := func(start, end int64) bool {
+		scanRegion := func(start, end int64, yield func(Match, error) bool) bool {
 			if start >= end {
 				return true
 			}
-			section := io.NewSectionReader(db.reader, start, end-start)
+			f := db.borrow()
+			defer db.giveBack(f)
+			section := io.NewSectionReader(f, start, end-start)
 			scanner := bufio.NewScanner(section)
 			scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
 			offset := start
+			var last restored
 
 			for scanner.Scan() {
 				ln := scanner.Bytes()
 
-				if valid(ln) && len(ln) >= MinRecordSize && ln[TypePos] == byte('0'+TypeRecord) {
+				if opts.IncludeHistory && valid(ln) && len(ln) >= MinRecordSize && ln[TypePos] == byte('0'+TypeHistory) && inScope(ln) {
+					if !past(ln, offset, &last, yield) {
+						return false
+					}
+				} else if valid(ln) && len(ln) >= MinRecordSize && ln[TypePos] == byte('0'+TypeRecord) && !binary(ln) && inScope(ln) {
 					di := bytes.Index(ln, dTag)
 					if di >= 0 {
 						s := di + len(dTag)
 						hi := bytes.Index(ln[s:], hTag)
 						if hi >= 0 {
 							content := ln[s : s+hi]
-							if decode {
-								content = unescape(content)
+							plain := false // content already unescaped
+							if shared(ln) {
+								r, err := db.decode(ln)
+								if err != nil {
+									if !yield(Match{Label: label(ln), Offset: offset}, fmt.Errorf("search: %w", err)) {
+										return false
+									}
+									offset += int64(len(ln)) + 1
+									continue
+								}
+								if !utf8.ValidString(r.Data) {
+									offset += int64(len(ln)) + 1
+									continue // binary, as it would be skipped unshared
+								}
+								// Matched as the unshared _d would be.
+								content, plain = []byte(r.Data), true
+								if !decode && db.cipher == nil {
+									raw, _ := json.Marshal(r.Data)
+									content, plain = raw[1:len(raw)-1], false
+								}
+							} else if encrypted(ln) {
+								p, err := decrypt64(db.cipher, content)
+								if err != nil {
+									if !yield(Match{Label: label(ln), Offset: offset}, fmt.Errorf("search: %w", err)) {
+										return false
+									}
+									offset += int64(len(ln)) + 1
+									continue
+								}
+								content, plain = p, true
+							} else if decode {
+								content, plain = unescape(content), true
 							}
 							if match(content) {
-								if !yield(Match{Label: label(ln), Offset: offset}, nil) {
-									return false
+								if !plain {
+									content = unescape(content)
+								}
+								ts, _ := strconv.ParseInt(string(ln[TSStart:TSEnd]), 10, 64)
+								for _, m := range locate(Match{Label: label(ln), Offset: offset, Timestamp: ts}, content, re, opts) {
+									if !yield(m, nil) {
+										return false
+									}
 								}
 							}
 						}
@@ -155,12 +386,109 @@ func (db *DB) Search(pattern string, opts SearchOptions) iter.Seq2[Match, error]
 			return true
 		}
 
-		// Heap: data + history records. Skip the index section.
-		if !scanRegion(HeaderSize, db.heapEnd()) {
+		regions := [][2]int64{
+			{HeaderSize, db.heapEnd()}, // Heap: data + history records. Skip the index section.
+			{db.sparseStart(), sz},     // Sparse: unsorted appends since last compaction.
+		}
+		if opts.Parallelism > 1 {
+			db.searchParallel(regions, opts.Parallelism, scanRegion, yield)
 			return
 		}
-		// Sparse: unsorted appends since last compaction.
-		scanRegion(db.sparseStart(), sz)
+		for _, r := range regions {
+			if !scanRegion(r[0], r[1], yield) {
+				return
+			}
+		}
+	}
+}
+
+// minChunk is the smallest range a parallel search gives one worker, so
+// that a small file is not split finer than is worth a goroutine.
+const minChunk = 64 << 10
+
+// chunks splits [start, end) into up to n ranges, each starting on a
+// record boundary.
+func chunks(f storage, start, end int64, n int) ([][2]int64, error) {
+	step := max((end-start)/int64(n), minChunk)
+	var out [][2]int64
+	for lo := start; lo < end; {
+		hi := end
+		if lo+step < end {
+			// Extend the range to the end of the record its last byte is in.
+			nl, err := align(f, lo+step-1)
+			if err != nil {
+				return nil, err
+			}
+			if nl >= 0 && nl+1 < end {
+				hi = nl + 1
+			}
+		}
+		out = append(out, [2]int64{lo, hi})
+		lo = hi
+	}
+	return out, nil
+}
+
+// searchParallel runs scan over the chunks of regions with n workers
+// and yields their matches in file order. The read lock must be held,
+// and is still needed until it returns: it waits for every worker.
+func (db *DB) searchParallel(regions [][2]int64, n int, scan func(start, end int64, yield func(Match, error) bool) bool, yield func(Match, error) bool) {
+	var parts [][2]int64
+	for _, r := range regions {
+		c, err := chunks(db.reader, r[0], r[1], n)
+		if err != nil {
+			yield(Match{}, fmt.Errorf("search: %w", err))
+			return
+		}
+		parts = append(parts, c...)
+	}
+
+	type found struct {
+		m   Match
+		err error
+	}
+	results := make([][]found, len(parts))
+	failed := make([]bool, len(parts)) // the scan of a part ended on a read error
+	done := make([]chan struct{}, len(parts))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	var stop atomic.Bool
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range min(n, len(parts)) {
+		wg.Go(func() {
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(parts) || stop.Load() {
+					return
+				}
+				ok := scan(parts[i][0], parts[i][1], func(m Match, err error) bool {
+					results[i] = append(results[i], found{m, err})
+					return !stop.Load()
+				})
+				failed[i] = !ok && !stop.Load()
+				close(done[i])
+			}
+		})
+	}
+	defer func() {
+		stop.Store(true)
+		wg.Wait()
+	}()
+
+	for i := range parts {
+		<-done[i]
+		for _, f := range results[i] {
+			if !yield(f.m, f.err) {
+				return
+			}
+		}
+		results[i] = nil
+		if failed[i] {
+			return // as the sequential scan stops at a read error
+		}
 	}
 }
 
@@ -169,6 +497,8 @@ func (db *DB) Search(pattern string, opts SearchOptions) iter.Seq2[Match, error]
 // entirely using the type byte at TypePos. Results are yielded lazily.
 func (db *DB) MatchLabel(pattern string) iter.Seq2[Match, error] {
 	return func(yield func(Match, error) bool) {
+		db.beginScan()
+		defer db.endScan()
 		if err := db.blockRead(); err != nil {
 			yield(Match{}, err)
 			return
@@ -197,7 +527,9 @@ func (db *DB) MatchLabel(pattern string) iter.Seq2[Match, error] {
 			if start >= end {
 				return true
 			}
-			section := io.NewSectionReader(db.reader, start, end-start)
+			f := db.borrow()
+			defer db.giveBack(f)
+			section := io.NewSectionReader(f, start, end-start)
 			scanner := bufio.NewScanner(section)
 			scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
 			offset := start
@@ -19,6 +19,11 @@
 package folio
 
 import (
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -31,7 +36,7 @@ func TestSearchMatchFound(t *testing.T) {
 
 	db.Set("doc", "hello world")
 
-	matches, err := collect(db.Search("hello", SearchOptions{}))
+	matches, err := Collect(db.Search("hello", SearchOptions{}))
 	if err != nil {
 		t.Fatalf("Search: %v", err)
 	}
@@ -48,7 +53,7 @@ func TestSearchNoMatch(t *testing.T) {
 
 	db.Set("doc", "hello world")
 
-	matches, _ := collect(db.Search("xyz", SearchOptions{}))
+	matches, _ := Collect(db.Search("xyz", SearchOptions{}))
 	if len(matches) != 0 {
 		t.Errorf("expected no matches, got %d", len(matches))
 	}
@@ -63,7 +68,7 @@ func TestSearchCaseInsensitiveDefault(t *testing.T) {
 
 	db.Set("doc", "Hello World")
 
-	matches, _ := collect(db.Search("HELLO", SearchOptions{}))
+	matches, _ := Collect(db.Search("HELLO", SearchOptions{}))
 	if len(matches) == 0 {
 		t.Error("case insensitive search should match")
 	}
@@ -78,12 +83,12 @@ func TestSearchCaseSensitive(t *testing.T) {
 
 	db.Set("doc", "Hello World")
 
-	matches, _ := collect(db.Search("HELLO", SearchOptions{CaseSensitive: true}))
+	matches, _ := Collect(db.Search("HELLO", SearchOptions{CaseSensitive: true}))
 	if len(matches) != 0 {
 		t.Error("case sensitive search should not match")
 	}
 
-	matches, _ = collect(db.Search("Hello", SearchOptions{CaseSensitive: true}))
+	matches, _ = Collect(db.Search("Hello", SearchOptions{CaseSensitive: true}))
 	if len(matches) == 0 {
 		t.Error("case sensitive search should match exact case")
 	}
@@ -122,7 +127,7 @@ func TestSearchRegex(t *testing.T) {
 
 	db.Set("doc", "hello world")
 
-	matches, _ := collect(db.Search("hel.*rld", SearchOptions{}))
+	matches, _ := Collect(db.Search("hel.*rld", SearchOptions{}))
 	if len(matches) == 0 {
 		t.Error("regex should match")
 	}
@@ -137,8 +142,8 @@ func TestSearchInvalidRegex(t *testing.T) {
 
 	db.Set("doc", "content")
 
-	_, err := collect(db.Search("[invalid", SearchOptions{}))
-	if err != ErrInvalidPattern {
+	_, err := Collect(db.Search("[invalid", SearchOptions{}))
+	if !errors.Is(err, ErrInvalidPattern) {
 		t.Errorf("expected ErrInvalidPattern, got %v", err)
 	}
 }
@@ -151,8 +156,8 @@ func TestSearchClosed(t *testing.T) {
 	db.Set("doc", "content")
 	db.Close()
 
-	_, err := collect(db.Search("content", SearchOptions{}))
-	if err != ErrClosed {
+	_, err := Collect(db.Search("content", SearchOptions{}))
+	if !errors.Is(err, ErrClosed) {
 		t.Errorf("expected ErrClosed, got %v", err)
 	}
 }
@@ -166,7 +171,7 @@ func TestMatchLabelFound(t *testing.T) {
 
 	db.Set("my-app", "content")
 
-	matches, err := collect(db.MatchLabel("app"))
+	matches, err := Collect(db.MatchLabel("app"))
 	if err != nil {
 		t.Fatalf("MatchLabel: %v", err)
 	}
@@ -185,7 +190,7 @@ func TestMatchLabelNoMatch(t *testing.T) {
 
 	db.Set("my-app", "content")
 
-	matches, _ := collect(db.MatchLabel("xyz"))
+	matches, _ := Collect(db.MatchLabel("xyz"))
 	if len(matches) != 0 {
 		t.Errorf("expected no matches, got %d", len(matches))
 	}
@@ -198,7 +203,7 @@ func TestMatchLabelCaseInsensitive(t *testing.T) {
 
 	db.Set("MyApp", "content")
 
-	matches, _ := collect(db.MatchLabel("myapp"))
+	matches, _ := Collect(db.MatchLabel("myapp"))
 	if len(matches) == 0 {
 		t.Error("case insensitive match should work")
 	}
@@ -211,8 +216,8 @@ func TestMatchLabelInvalidRegex(t *testing.T) {
 
 	db.Set("doc", "content")
 
-	_, err := collect(db.MatchLabel("(?P<invalid"))
-	if err != ErrInvalidPattern {
+	_, err := Collect(db.MatchLabel("(?P<invalid"))
+	if !errors.Is(err, ErrInvalidPattern) {
 		t.Errorf("expected ErrInvalidPattern, got %v", err)
 	}
 }
@@ -224,8 +229,8 @@ func TestMatchLabelClosed(t *testing.T) {
 	db.Set("doc", "content")
 	db.Close()
 
-	_, err := collect(db.MatchLabel("doc"))
-	if err != ErrClosed {
+	_, err := Collect(db.MatchLabel("doc"))
+	if !errors.Is(err, ErrClosed) {
 		t.Errorf("expected ErrClosed, got %v", err)
 	}
 }
@@ -241,7 +246,7 @@ func TestMatchLabelMultiple(t *testing.T) {
 	db.Set("app-two", "content2")
 	db.Set("other", "content3")
 
-	matches, _ := collect(db.MatchLabel("app"))
+	matches, _ := Collect(db.MatchLabel("app"))
 	if len(matches) != 2 {
 		t.Errorf("expected 2 matches, got %d", len(matches))
 	}
@@ -259,7 +264,7 @@ func TestSearchDecodeQuotes(t *testing.T) {
 	db.Set("doc", `hello "world"`)
 
 	// With Decode, the unescaped quotes should be searchable.
-	matches, err := collect(db.Search(`"world"`, SearchOptions{Decode: true}))
+	matches, err := Collect(db.Search(`"world"`, SearchOptions{Decode: true}))
 	if err != nil {
 		t.Fatalf("Search: %v", err)
 	}
@@ -269,7 +274,7 @@ func TestSearchDecodeQuotes(t *testing.T) {
 
 	// Without Decode, the literal fast path JSON-escapes the query
 	// so it matches the raw \" sequences directly.
-	matches, _ = collect(db.Search(`"world"`, SearchOptions{}))
+	matches, _ = Collect(db.Search(`"world"`, SearchOptions{}))
 	if len(matches) == 0 {
 		t.Error("literal search should match quoted content via escaped query")
 	}
@@ -288,13 +293,13 @@ func TestSearchRegexDecodeQuotes(t *testing.T) {
 
 	// Regex path (pattern has metacharacter) without Decode cannot match
 	// literal quotes because the raw JSON has escaped quotes (\").
-	matches, _ := collect(db.Search(`"wor.d"`, SearchOptions{}))
+	matches, _ := Collect(db.Search(`"wor.d"`, SearchOptions{}))
 	if len(matches) != 0 {
 		t.Error("regex on raw JSON should not match literal quotes")
 	}
 
 	// With Decode, the unescaped content is matched.
-	matches, _ = collect(db.Search(`"wor.d"`, SearchOptions{Decode: true}))
+	matches, _ = Collect(db.Search(`"wor.d"`, SearchOptions{Decode: true}))
 	if len(matches) == 0 {
 		t.Error("regex with decode should match quoted content")
 	}
@@ -312,7 +317,7 @@ func TestSearchLiteralNewline(t *testing.T) {
 
 	// Literal path: the newline in the pattern is JSON-escaped to \n
 	// and matched against the raw JSON bytes without unescaping content.
-	matches, err := collect(db.Search("line1\nline2", SearchOptions{}))
+	matches, err := Collect(db.Search("line1\nline2", SearchOptions{}))
 	if err != nil {
 		t.Fatalf("Search: %v", err)
 	}
@@ -331,13 +336,13 @@ func TestSearchBothPathsNewline(t *testing.T) {
 	db.Set("doc", "line1\nline2")
 
 	// Literal path: "line1" has no metacharacters.
-	matches, _ := collect(db.Search("line1", SearchOptions{}))
+	matches, _ := Collect(db.Search("line1", SearchOptions{}))
 	if len(matches) == 0 {
 		t.Error("literal path should match substring in newline content")
 	}
 
 	// Regex path: "line." has a metacharacter.
-	matches, _ = collect(db.Search("line.", SearchOptions{}))
+	matches, _ = Collect(db.Search("line.", SearchOptions{}))
 	if len(matches) == 0 {
 		t.Error("regex path should match pattern in newline content")
 	}
@@ -352,13 +357,13 @@ func TestSearchBothPathsQuotes(t *testing.T) {
 	db.Set("doc", `say "hello" please`)
 
 	// Literal path: `"hello"` has no regex metacharacters (quote is not one).
-	matches, _ := collect(db.Search(`"hello"`, SearchOptions{}))
+	matches, _ := Collect(db.Search(`"hello"`, SearchOptions{}))
 	if len(matches) == 0 {
 		t.Error("literal path should match quoted substring")
 	}
 
 	// Regex path: `"hel.o"` has a metacharacter.
-	matches, _ = collect(db.Search(`"hel.o"`, SearchOptions{Decode: true}))
+	matches, _ = Collect(db.Search(`"hel.o"`, SearchOptions{Decode: true}))
 	if len(matches) == 0 {
 		t.Error("regex path with decode should match quoted content")
 	}
@@ -374,7 +379,7 @@ func TestSearchDecodePlain(t *testing.T) {
 	db.Set("doc", "hello world")
 
 	// Decode on plain content (fast path, no escapes) should still match.
-	matches, err := collect(db.Search("hello", SearchOptions{Decode: true}))
+	matches, err := Collect(db.Search("hello", SearchOptions{Decode: true}))
 	if err != nil {
 		t.Fatalf("Search: %v", err)
 	}
@@ -393,7 +398,7 @@ func TestSearchDecodeBackslash(t *testing.T) {
 
 	db.Set("doc", `path\to\file`)
 
-	matches, err := collect(db.Search(`path\\to`, SearchOptions{Decode: true}))
+	matches, err := Collect(db.Search(`path\\to`, SearchOptions{Decode: true}))
 	if err != nil {
 		t.Fatalf("Search: %v", err)
 	}
@@ -412,7 +417,7 @@ func TestSearchDecodeNewline(t *testing.T) {
 	db.Set("doc", "line1\nline2")
 
 	// Search for the literal newline character in decoded content.
-	matches, err := collect(db.Search("line1\nline2", SearchOptions{Decode: true}))
+	matches, err := Collect(db.Search("line1\nline2", SearchOptions{Decode: true}))
 	if err != nil {
 		t.Fatalf("Search: %v", err)
 	}
@@ -420,3 +425,228 @@ func TestSearchDecodeNewline(t *testing.T) {
 		t.Error("decoded search should match newline content")
 	}
 }
+
+// TestSearchPositions verifies that MatchStart and MatchEnd locate the
+// match in the document as stored, not in its JSON encoding, on both
+// paths and in a Snapshot. The content has a quote and a newline before
+// the match, each two bytes on disk, so offsets into the escaped form
+// would be two too large.
+func TestSearchPositions(t *testing.T) {
+	db := openTestDB(t)
+	content := "say \"hi\"\nto the world"
+	db.Set("doc", content)
+	want := len("say \"hi\"\nto the ")
+
+	for _, pattern := range []string{"world", "wor.d"} {
+		matches, err := Collect(db.Search(pattern, SearchOptions{}))
+		if err != nil {
+			t.Fatalf("Search(%q): %v", pattern, err)
+		}
+		if len(matches) != 1 || matches[0].MatchStart != want || matches[0].MatchEnd != want+5 {
+			t.Errorf("Search(%q) = %+v, want one match at [%d, %d)", pattern, matches, want, want+5)
+		}
+		if matches[0].Snippet != "" {
+			t.Errorf("Search(%q) Snippet = %q without SearchOptions.Snippet", pattern, matches[0].Snippet)
+		}
+	}
+
+	snap, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	defer snap.Close()
+	matches, err := Collect(snap.Search("WORLD", SearchOptions{}))
+	if err != nil {
+		t.Fatalf("Snapshot.Search: %v", err)
+	}
+	if len(matches) != 1 || content[matches[0].MatchStart:matches[0].MatchEnd] != "world" {
+		t.Errorf("Snapshot.Search = %+v, want the match at world", matches)
+	}
+}
+
+// TestSearchSnippetsAndMaxMatches verifies MaxMatchesPerDoc and the
+// snippet around each match, which is cut short at the ends of the
+// document and never splits a multi-byte character.
+func TestSearchSnippetsAndMaxMatches(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "cat é cat, one more cat")
+
+	matches, err := Collect(db.Search("cat", SearchOptions{MaxMatchesPerDoc: -1, Snippet: 2}))
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	want := []string{"cat ", " cat, ", "e cat"} // é is two bytes: the snippets beside it stop short of it
+	if len(matches) != len(want) {
+		t.Fatalf("got %d matches, want %d: %+v", len(matches), len(want), matches)
+	}
+	for i, m := range matches {
+		if m.Snippet != want[i] {
+			t.Errorf("match %d Snippet = %q, want %q", i, m.Snippet, want[i])
+		}
+	}
+
+	matches, err = Collect(db.Search("cat", SearchOptions{MaxMatchesPerDoc: 2}))
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(matches) != 2 || matches[1].MatchStart != len("cat é ") {
+		t.Errorf("MaxMatchesPerDoc 2 = %+v, want the first two", matches)
+	}
+}
+
+// TestSearchLabelPattern verifies that LabelPattern scopes Search, and
+// Snapshot.Search, to matching labels as a glob, where * stops at a
+// slash, and as a regex, and that a malformed one is rejected.
+func TestSearchLabelPattern(t *testing.T) {
+	db := openTestDB(t)
+	for _, lbl := range []string{"notes/a", "notes/b", "notes/deep/c", "todo/a"} {
+		db.Set(lbl, "shared text")
+	}
+
+	labels := func(seq iter.Seq2[Match, error]) []string {
+		t.Helper()
+		matches, err := Collect(seq)
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		var out []string
+		for _, m := range matches {
+			out = append(out, m.Label)
+		}
+		slices.Sort(out)
+		return out
+	}
+	glob := SearchOptions{LabelPattern: "notes/*"}
+	if got, want := labels(db.Search("shared", glob)), []string{"notes/a", "notes/b"}; !slices.Equal(got, want) {
+		t.Errorf("glob = %v, want %v", got, want)
+	}
+	re := SearchOptions{LabelPattern: "^notes/", LabelRegex: true}
+	if got, want := labels(db.Search("shared", re)), []string{"notes/a", "notes/b", "notes/deep/c"}; !slices.Equal(got, want) {
+		t.Errorf("regex = %v, want %v", got, want)
+	}
+
+	snap, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	defer snap.Close()
+	if got, want := labels(snap.Search("shared", glob)), []string{"notes/a", "notes/b"}; !slices.Equal(got, want) {
+		t.Errorf("Snapshot glob = %v, want %v", got, want)
+	}
+
+	for _, bad := range []SearchOptions{{LabelPattern: "notes/["}, {LabelPattern: "(", LabelRegex: true}} {
+		if _, err := Collect(db.Search("shared", bad)); !errors.Is(err, ErrInvalidPattern) {
+			t.Errorf("LabelPattern %q = %v, want ErrInvalidPattern", bad.LabelPattern, err)
+		}
+	}
+}
+
+// TestSearchIncludeHistory verifies that IncludeHistory matches previous
+// versions with their timestamps, before and after compaction moves them
+// into the heap, that current matches are not marked History, and that
+// Snapshot.Search finds the same versions.
+func TestSearchIncludeHistory(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "draft with typo")
+	db.Set("doc", "draft with typo again")
+	db.Set("doc", "final text")
+	db.Set("other", "no typo here? typo")
+
+	var want []int64
+	for v, err := range db.History("doc") {
+		if err != nil {
+			t.Fatalf("History: %v", err)
+		}
+		want = append(want, v.TS)
+	}
+
+	check := func(when string, seq iter.Seq2[Match, error]) {
+		t.Helper()
+		matches, err := Collect(seq)
+		if err != nil {
+			t.Fatalf("%s: Search: %v", when, err)
+		}
+		var got []int64
+		for _, m := range matches {
+			if m.Label == "other" {
+				if m.History {
+					t.Errorf("%s: current version of other marked History", when)
+				}
+				continue
+			}
+			if !m.History {
+				t.Errorf("%s: doc match %+v not marked History", when, m)
+			}
+			got = append(got, m.Timestamp)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, want[:2]) {
+			t.Errorf("%s: doc versions matched at %v, want %v", when, got, want[:2])
+		}
+	}
+
+	opts := SearchOptions{IncludeHistory: true}
+	check("appended", db.Search("typo", opts))
+	matches, _ := Collect(db.Search("typo", SearchOptions{}))
+	if len(matches) != 1 || matches[0].Label != "other" {
+		t.Errorf("without IncludeHistory = %+v, want only other", matches)
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	check("compacted", db.Search("typo", opts))
+
+	snap, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	defer snap.Close()
+	check("snapshot", snap.Search("typo", opts))
+}
+
+// TestSearchParallel verifies that a parallel search over a file large
+// enough to split into several ranges, in both the heap and the sparse
+// region, yields the same matches in the same order as a sequential
+// one, and stops cleanly when the caller breaks.
+func TestSearchParallel(t *testing.T) {
+	db := openTestDB(t)
+	pad := strings.Repeat("x", 1000)
+	for i := range 300 {
+		db.Set(fmt.Sprintf("doc-%03d", i), fmt.Sprintf("%s needle %d", pad, i%7))
+	}
+	db.Compact()
+	for i := range 200 {
+		db.Set(fmt.Sprintf("new-%03d", i), fmt.Sprintf("%s needle %d", pad, i%7))
+	}
+	if n, _ := chunks(db.reader, HeaderSize, db.heapEnd(), 4); len(n) < 2 {
+		t.Fatalf("heap splits into %d ranges, want several", len(n))
+	}
+
+	for _, pattern := range []string{"needle 3", "needle [35]"} {
+		want, err := Collect(db.Search(pattern, SearchOptions{}))
+		if err != nil {
+			t.Fatalf("Search(%q): %v", pattern, err)
+		}
+		got, err := Collect(db.Search(pattern, SearchOptions{Parallelism: 4}))
+		if err != nil {
+			t.Fatalf("parallel Search(%q): %v", pattern, err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("parallel Search(%q): %d matches differ from the %d sequential ones", pattern, len(got), len(want))
+		}
+	}
+
+	n := 0
+	for _, err := range db.Search("needle", SearchOptions{Parallelism: 4}) {
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if n++; n == 5 {
+			break
+		}
+	}
+	if err := db.Set("after", "x"); err != nil {
+		t.Errorf("Set after breaking from a parallel search: %v", err)
+	}
+}
@@ -0,0 +1,426 @@
+// Sealed segments: the sparse region sorted a run at a time.
+//
+// Compaction sorts the sparse region into the heap and rewrites the whole
+// file to do it. Seal sorts only the unsealed tail of the region. The
+// result is a segment: the tail's data, history, tag and tombstone lines
+// sorted by ID, then its index lines, sorted by ID too. Each ID's lines
+// keep the order they were written in.
+//
+//	[Header][Heap][Index][Tags][Segment 1]...[Segment k][Unsealed tail→EOF]
+//
+// A segment is still part of the sparse region, and every line in it is
+// an ordinary line. The scans that walk the region (All, List, Search,
+// Changes, recovery) read it as before. A lookup scans the tail, then
+// binary searches each segment's index lines, newest segment first.
+// History collects an ID's group from each segment as it does from the
+// heap. The segment bounds are kept in the metadata record (see
+// meta.go). Sealing stamps the file with format version 4, so a library
+// that predates segments cannot patch a rename into one in place.
+//
+// Sealing is generational. The tail is merged with the newest segments
+// for as long as each is no larger than everything newer than it. So a
+// line is rewritten only when the segment it is in at least doubles:
+// about log₂ of the sparse region over the tail's size times, and there
+// are about that many segments. Only the tail and the merged segments
+// are rewritten, never older segments or the heap. Compaction, set off
+// by CompactPolicy.SparseRatio, merges the segments into the heap once
+// they reach that fraction of it. With the tail sealed every s bytes and
+// a ratio r, each byte is copied about log₂(r·heap/s) times while it is
+// in the sparse region, and 1+1/r times by compaction.
+//
+// Sealing drops blanked lines, settled transaction records and
+// superseded metadata records. It rewrites the offset in each index
+// line. Every other line is copied byte for byte.
+//
+// The rewrite happens in place, so it is protected against a crash the
+// same way a transaction is. The new bytes are first written to a .seal
+// file beside the database with their length and CRC-32C, and synced.
+// Only then are they copied over the merged range. If a crash interrupts
+// the copy, Open finishes it from a .seal file that checks out. A .seal
+// file that fails its check is removed: the database was not changed
+// before it was complete.
+//
+// A Snapshot reads the file it was taken from, so Seal refuses to run
+// while one is open. While a CompactStep compaction is in progress Seal
+// does nothing, since that compaction will sort the tail into the heap
+// anyway. Like a rebuild, Seal advances the file's generation, because
+// offsets into the merged range no longer mean anything.
+//
+// Another process reads the segment bounds when it opens the file. If a
+// Seal runs after that, the other process searches the old bounds until
+// it reopens, and may miss documents in the merged range. This is the
+// same limitation a same-length Rename has (see sparsemap.go).
+package folio
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// segmentVersion is the format version that added sealed segments.
+const segmentVersion = 4
+
+// sealExt names the file a Seal writes its output to before copying it
+// over the database.
+const sealExt = ".seal"
+
+// Segment is one sealed run of the sparse region. Its other lines are
+// sorted by ID from Start to Index, then its index lines from Index to
+// End.
+type Segment struct {
+	Start int64 `json:"s"`
+	Index int64 `json:"i"`
+	End   int64 `json:"e"`
+}
+
+// pendingSeal heads the .seal file, space-padded to HeaderSize like the
+// database header. The output follows it.
+type pendingSeal struct {
+	At       int64  `json:"at"` // where the output goes in the database
+	Length   int64  `json:"n"`  // bytes of output
+	Meta     int64  `json:"m"`  // offset of the new metadata record
+	Gen      uint64 `json:"g"`  // the generation the copy makes the file
+	Checksum string `json:"k"`  // CRC-32C of the output, 8 hex digits
+}
+
+// segments returns the sealed segments of the sparse region, oldest
+// first.
+func (db *DB) segments() []Segment {
+	if db.meta == nil || db.header.Version < segmentVersion {
+		return nil
+	}
+	return db.meta.Segments
+}
+
+// unsealed returns where the unsealed tail of the sparse region starts:
+// at the end of the newest segment, or after the sorted tag section if
+// there are no segments.
+func (db *DB) unsealed() int64 {
+	if segs := db.segments(); len(segs) > 0 {
+		return segs[len(segs)-1].End
+	}
+	return db.tagEnd()
+}
+
+// merged returns how many of segs a seal keeps as they are: the others,
+// the newest, are each no larger than the tail of n bytes and every
+// segment newer than them put together.
+func merged(segs []Segment, n int64) int {
+	keep := len(segs)
+	for keep > 0 && segs[keep-1].End-segs[keep-1].Start <= n {
+		keep--
+		n += segs[keep].End - segs[keep].Start
+	}
+	return keep
+}
+
+// Seal sorts the unsealed tail of the sparse region into a segment,
+// merged with the newest segments that are no larger than it; see the
+// package comment. A tail with nothing to seal is left alone, and so is
+// the file while a CompactStep compaction is in progress. Seal fails
+// while a Snapshot is open.
+func (db *DB) Seal() (err error) {
+	defer db.observe(OpSeal, "", time.Now(), &err)
+
+	if db.config.ReadOnly {
+		return ErrReadOnly
+	}
+	db.maint.Lock()
+	defer db.maint.Unlock()
+	if err := db.blockWrite(); err != nil {
+		return err
+	}
+	defer func() {
+		db.mu.Unlock()
+		db.lock.Unlock()
+	}()
+	if db.partial != nil {
+		return nil
+	}
+	if n := db.snapshots.Load(); n > 0 {
+		return fmt.Errorf("seal: %d open snapshots hold the file", n)
+	}
+
+	tmp, p, err := db.sealOutput()
+	if err != nil || tmp == nil {
+		return err
+	}
+	before := db.tail
+	err = finishSeal(db.writer, tmp, p, db.header)
+	tmp.Close()
+	if err != nil {
+		// The .seal file stays, for the next Open to finish the copy.
+		return fmt.Errorf("seal: %w", err)
+	}
+	db.resealed(before, p)
+	if db.root != nil {
+		if err := db.root.Remove(db.name + sealExt); err != nil {
+			return fmt.Errorf("seal: %w", err)
+		}
+	}
+	return nil
+}
+
+// sealOutput writes the segment a seal makes, followed by the new
+// metadata record, to the .seal file and syncs it. It returns a nil
+// file if the tail holds nothing to seal. The write lock must be held.
+func (db *DB) sealOutput() (storage, pendingSeal, error) {
+	segs := db.segments()
+	from := db.unsealed()
+	keep := merged(segs, db.tail-from)
+	at := from
+	if keep < len(segs) {
+		at = segs[keep].Start
+	}
+
+	var lines, indexes []Entry
+	for _, e := range scanm(db.reader, at, db.tail, 0) {
+		switch e.Type {
+		case TypeMeta, TypeTxn, TypeTable:
+			// Written afresh below, or settled.
+		case TypeIndex:
+			indexes = append(indexes, e)
+		default:
+			lines = append(lines, e)
+		}
+	}
+	if len(lines) == 0 && len(indexes) == 0 {
+		return nil, pendingSeal{}, nil
+	}
+	// Stable, so each ID's lines stay in the order they were written.
+	byIDOnly := func(a, b Entry) int { return cmp.Compare(a.ID, b.ID) }
+	slices.SortStableFunc(lines, byIDOnly)
+	slices.SortStableFunc(indexes, byIDOnly)
+
+	db.markDirty()
+	tmp, err := db.createSeal()
+	if err != nil {
+		return nil, pendingSeal{}, fmt.Errorf("seal: create: %w", err)
+	}
+	fail := func(err error) (storage, pendingSeal, error) {
+		tmp.Close()
+		if db.root != nil {
+			db.root.Remove(db.name + sealExt)
+		}
+		return nil, pendingSeal{}, fmt.Errorf("seal: %w", err)
+	}
+
+	sum := crc32.New(castagnoli)
+	w := bufio.NewWriter(io.MultiWriter(&offsetWriter{w: tmp, off: HeaderSize}, sum))
+	var n int64
+	write := func(data []byte) error {
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		n += int64(len(data)) + 1
+		return w.WriteByte('\n')
+	}
+	read := func(e Entry) ([]byte, error) {
+		data := make([]byte, e.Length)
+		if _, err := db.reader.ReadAt(data, e.SrcOff); err != nil {
+			return nil, fmt.Errorf("read line at %d: %w", e.SrcOff, err)
+		}
+		return data, nil
+	}
+
+	dst := make(map[int64]int64, len(lines))
+	for _, e := range lines {
+		data, err := read(e)
+		if err != nil {
+			return fail(err)
+		}
+		dst[e.SrcOff] = at + n
+		if err := write(data); err != nil {
+			return fail(err)
+		}
+	}
+	seg := Segment{Start: at, Index: at + n}
+	for _, e := range indexes {
+		data, err := read(e)
+		if err != nil {
+			return fail(err)
+		}
+		idx, err := decodeIndex(data)
+		if err != nil {
+			return fail(faultAt("", e.SrcOff, err))
+		}
+		if idx.Offset >= at {
+			off, ok := dst[idx.Offset]
+			if !ok {
+				// Its record was blanked, torn or quarantined, so the
+				// index finds nothing.
+				continue
+			}
+			idx.Offset = off
+			if data, err = json.Marshal(idx); err != nil {
+				return fail(err)
+			}
+		}
+		if err := write(data); err != nil {
+			return fail(err)
+		}
+	}
+	seg.End = at + n
+
+	m := Meta{Type: TypeMeta, ID: metaID}
+	if db.meta != nil {
+		m = *db.meta
+	}
+	m.Timestamp = now()
+	m.Sizes = nil // saved only at Close, for the file as it ends then
+	m.Segments = append(slices.Clone(segs[:keep]), seg)
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fail(err)
+	}
+	p := pendingSeal{At: at, Meta: at + n, Gen: db.header.Generation + 1}
+	if err := write(data); err != nil {
+		return fail(err)
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	p.Length = n
+	p.Checksum = fmt.Sprintf("%08x", sum.Sum32())
+
+	head, err := json.Marshal(p)
+	if err != nil {
+		return fail(err)
+	}
+	if len(head) >= HeaderSize {
+		return fail(fmt.Errorf("header of %d bytes", len(head)))
+	}
+	buf := bytes.Repeat([]byte(" "), HeaderSize)
+	copy(buf, head)
+	buf[HeaderSize-1] = '\n'
+	if _, err := tmp.WriteAt(buf, 0); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	return tmp, p, nil
+}
+
+// createSeal creates the file sealOutput writes to.
+func (db *DB) createSeal() (storage, error) {
+	if db.root == nil {
+		return &memFile{}, nil
+	}
+	return db.root.Create(db.name + sealExt)
+}
+
+// finishSeal copies the output of a seal from tmp over w at p.At, cuts
+// the file short after it, and writes hdr pointing at the new metadata
+// record, in the generation p names.
+func finishSeal(w, tmp storage, p pendingSeal, hdr *Header) error {
+	if _, err := io.Copy(&offsetWriter{w: w, off: p.At}, io.NewSectionReader(tmp, HeaderSize, p.Length)); err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+	if err := w.Truncate(p.At + p.Length); err != nil {
+		return fmt.Errorf("truncate: %w", err)
+	}
+	hdr.State[stMeta] = uint64(p.Meta)
+	hdr.Generation = p.Gen
+	hdr.Version = max(hdr.Version, segmentVersion)
+	buf, err := hdr.encode()
+	if err != nil {
+		return fmt.Errorf("encode header: %w", err)
+	}
+	if _, err := w.WriteAt(buf, 0); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+	return w.Sync()
+}
+
+// resealed brings the in-memory state that depends on the layout up to
+// date once the seal in p has replaced the file from p.At on, which was
+// before bytes long. The write lock must be held.
+func (db *DB) resealed(before int64, p pendingSeal) {
+	db.tail = p.At + p.Length
+	db.loadMeta()
+	if m, ok := db.reader.(*mappedFile); ok {
+		// The file may now end inside the mapping.
+		munmap(m.data)
+		db.reader = mapped(m.File, db.config)
+	}
+	if db.smap != nil {
+		db.smap.reset()
+	}
+	if db.misses != nil {
+		db.misses.reset(db.tail)
+	}
+	if db.sizes != nil && db.sizes.at == before {
+		db.sizes.at = db.tail // the same lines, only moved
+	}
+}
+
+// resumeSeal finishes the copy of a seal that a crash interrupted, from
+// its .seal file, and removes the file. If the .seal file is incomplete
+// or damaged, or the copy already finished, the file is only removed.
+// Called by Open before the header is read.
+func resumeSeal(root *os.Root, name string, w storage, lock *fileLock, log *slog.Logger) error {
+	if _, err := root.Stat(name + sealExt); err != nil {
+		return nil
+	}
+	// A Seal in another process holds the lock until its file is gone.
+	if err := lock.Lock(LockExclusive); err != nil {
+		log.Error("recovery skipped: cannot lock file", "error", err)
+		return nil
+	}
+	defer lock.Unlock()
+
+	tmp, err := root.Open(name + sealExt)
+	if err != nil {
+		return nil
+	}
+	p, ok := checkSeal(tmp)
+	var hdr *Header
+	if ok {
+		hdr, err = header(w)
+		ok = err == nil && hdr.Generation+1 == p.Gen
+	}
+	if ok {
+		if err := finishSeal(w, tmp, p, hdr); err != nil {
+			tmp.Close()
+			return fmt.Errorf("seal: %w", err)
+		}
+		log.Warn("recovery: finished an interrupted seal", "at", p.At)
+	}
+	tmp.Close()
+	return root.Remove(name + sealExt)
+}
+
+// checkSeal reads the header of a .seal file and reports whether the
+// output after it is whole.
+func checkSeal(f *os.File) (pendingSeal, bool) {
+	var p pendingSeal
+	buf := make([]byte, HeaderSize)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		return p, false
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(buf), &p); err != nil || p.At < HeaderSize {
+		return p, false
+	}
+	info, err := f.Stat()
+	if err != nil || info.Size() != HeaderSize+p.Length {
+		return p, false
+	}
+	sum := crc32.New(castagnoli)
+	if _, err := io.Copy(sum, io.NewSectionReader(f, HeaderSize, p.Length)); err != nil {
+		return p, false
+	}
+	want, err := strconv.ParseUint(p.Checksum, 16, 32)
+	return p, err == nil && uint32(want) == sum.Sum32()
+}
@@ -0,0 +1,363 @@
+// Sealed segment tests.
+//
+// A seal moves every line it keeps, so each read that finds lines by
+// offset or by scan must find the same documents after it as before:
+// Get, History, tags and deletes, through reopen and compaction. The
+// copy over the file must survive a crash at any point, and the merge
+// plan must keep the rewrites generational.
+package folio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// sealCheck verifies the documents setupSeal leaves, and the file.
+func sealCheck(t *testing.T, db *DB, when string) {
+	t.Helper()
+	for i := range 20 {
+		lbl := fmt.Sprintf("doc-%02d", i)
+		want := fmt.Sprintf("v1-%d", i)
+		if i%3 == 0 {
+			want = fmt.Sprintf("v2-%d", i)
+		}
+		switch got, err := db.Get(lbl); {
+		case i == 5 || i == 7:
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: Get(%s) err = %v, want ErrNotFound", when, lbl, err)
+			}
+		case err != nil || got != want:
+			t.Errorf("%s: Get(%s) = %q, %v; want %q", when, lbl, got, err, want)
+		}
+	}
+	n := 0
+	for _, err := range db.History("doc-03") {
+		if err != nil {
+			t.Fatalf("%s: History: %v", when, err)
+		}
+		n++
+	}
+	if n != 2 {
+		t.Errorf("%s: History(doc-03) has %d versions, want 2", when, n)
+	}
+	var tagged []string
+	for lbl, err := range db.ByTag("red") {
+		if err != nil {
+			t.Fatalf("%s: ByTag: %v", when, err)
+		}
+		tagged = append(tagged, lbl)
+	}
+	if slices.Sort(tagged); !slices.Equal(tagged, []string{"doc-01", "doc-02"}) {
+		t.Errorf("%s: ByTag(red) = %v", when, tagged)
+	}
+	if got, err := db.Get("renamed"); err != nil || got != "v1-5" {
+		t.Errorf("%s: Get(renamed) = %q, %v", when, got, err)
+	}
+	report, err := db.Verify(VerifyOptions{})
+	if err != nil || !report.OK() {
+		t.Errorf("%s: Verify = %v, %v", when, report.Problems, err)
+	}
+}
+
+// setupSeal writes documents, updates, a delete, tags, and a rename
+// to the sparse region.
+func setupSeal(t *testing.T, db *DB) {
+	t.Helper()
+	for i := range 20 {
+		if err := db.Set(fmt.Sprintf("doc-%02d", i), fmt.Sprintf("v1-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 20; i += 3 {
+		if err := db.Set(fmt.Sprintf("doc-%02d", i), fmt.Sprintf("v2-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("doc-07"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Tag("doc-01", "red"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Tag("doc-02", "red"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Rename("doc-05", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSealLookups verifies that every read finds the same documents
+// after a seal, after a second seal that merges the first, after
+// reopening, and after a compaction that folds the segments away.
+func TestSealLookups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupSeal(t, db)
+	sealCheck(t, db, "sparse")
+
+	if err := db.Seal(); err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if s := db.Stats(); s.Segments != 1 || s.Version != FormatVersion || s.SealedBytes == 0 {
+		t.Errorf("after Seal: %d segments, version %d, %d sealed bytes", s.Segments, s.Version, s.SealedBytes)
+	}
+	sealCheck(t, db, "sealed")
+
+	// The tail is smaller than the segment, so this seal adds one.
+	if err := db.Set("doc-01", "v1-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Seal(); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.Stats().Segments; n != 2 {
+		t.Errorf("second seal: %d segments, want 2", n)
+	}
+	sealCheck(t, db, "sealed twice")
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + sealExt); !os.IsNotExist(err) {
+		t.Errorf(".seal file left behind: %v", err)
+	}
+	db, err = Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if n := db.Stats().Segments; n != 2 {
+		t.Errorf("reopened: %d segments, want 2", n)
+	}
+	sealCheck(t, db, "reopened")
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.Stats().Segments; n != 0 {
+		t.Errorf("compacted: %d segments, want 0", n)
+	}
+	sealCheck(t, db, "compacted")
+}
+
+// TestSealMerged verifies the merge plan: a segment is merged only when
+// it is no larger than the tail and every segment newer than it.
+func TestSealMerged(t *testing.T) {
+	seg := func(sizes ...int64) []Segment {
+		var segs []Segment
+		at := int64(HeaderSize)
+		for _, n := range sizes {
+			segs = append(segs, Segment{Start: at, Index: at, End: at + n})
+			at += n
+		}
+		return segs
+	}
+	tests := []struct {
+		sizes []int64
+		tail  int64
+		keep  int
+	}{
+		{nil, 10, 0},
+		{[]int64{100}, 10, 1},
+		{[]int64{10}, 10, 0},
+		{[]int64{40, 20, 10}, 10, 0}, // 10+10, +20, +40: all merge
+		{[]int64{80, 20, 10}, 10, 1}, // 40 so far is less than 80
+		{[]int64{80, 30, 10}, 10, 2},
+	}
+	for _, tt := range tests {
+		if got := merged(seg(tt.sizes...), tt.tail); got != tt.keep {
+			t.Errorf("merged(%v, %d) = %d, want %d", tt.sizes, tt.tail, got, tt.keep)
+		}
+	}
+}
+
+// TestSealRenameNotInPlace verifies that a same-length rename of a
+// document in a segment appends rather than patching the ID a segment
+// is sorted by.
+func TestSealRenameNotInPlace(t *testing.T) {
+	db := openTestDB(t)
+	for _, lbl := range []string{"aa", "mm", "zz"} {
+		if err := db.Set(lbl, lbl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Seal(); err != nil {
+		t.Fatal(err)
+	}
+	before := db.tail
+	if err := db.Rename("mm", "nn"); err != nil {
+		t.Fatal(err)
+	}
+	if db.tail == before {
+		t.Error("rename into a segment was patched in place")
+	}
+	if got, err := db.Get("nn"); err != nil || got != "mm" {
+		t.Errorf("Get(nn) = %q, %v", got, err)
+	}
+	if _, err := db.Get("mm"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(mm) err = %v, want ErrNotFound", err)
+	}
+	report, err := db.Verify(VerifyOptions{})
+	if err != nil || !report.OK() {
+		t.Errorf("Verify = %v, %v", report.Problems, err)
+	}
+}
+
+// TestSealMmap verifies that a seal that shrinks the file remaps it, so
+// a mapped reader does not read past the new end.
+func TestSealMmap(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{MmapReads: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	setupSeal(t, db)
+	before := db.tail
+	if err := db.Seal(); err != nil {
+		t.Fatal(err)
+	}
+	if db.tail >= before {
+		t.Errorf("seal did not drop blanked lines: %d bytes, was %d", db.tail, before)
+	}
+	sealCheck(t, db, "mapped")
+}
+
+// TestSealSnapshot verifies that Seal refuses to move lines a snapshot
+// is reading.
+func TestSealSnapshot(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.Set("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := db.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Seal(); err == nil {
+		t.Error("Seal with an open snapshot succeeded")
+	}
+	snap.Close()
+	if err := db.Seal(); err != nil {
+		t.Errorf("Seal after the snapshot closed: %v", err)
+	}
+}
+
+// TestSealResume verifies crash recovery of a seal: a copy cut short is
+// finished from the .seal file, and a .seal file that fails its check is
+// discarded with the database untouched.
+func TestSealResume(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(src, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupSeal(t, db)
+
+	// Stop a seal after its output is synced: the file as it was, and
+	// the .seal file beside it.
+	db.mu.Lock()
+	tmp, p, err := db.sealOutput()
+	if err != nil || tmp == nil {
+		db.mu.Unlock()
+		t.Fatalf("sealOutput = %v, %v", tmp, err)
+	}
+	tmp.Close()
+	original, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := os.ReadFile(src + sealExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.root.Remove(db.name + sealExt)
+	db.mu.Unlock()
+	db.Close()
+
+	tests := []struct {
+		name   string
+		copied int64 // bytes of the output already copied
+		damage bool  // flip a byte of the output in the .seal file
+		sealed bool  // whether Open should finish the seal
+	}{
+		{"not started", 0, false, true},
+		{"half copied", p.Length / 2, false, true},
+		{"all copied", p.Length, false, true},
+		{"damaged", 0, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.folio")
+			file := bytes.Clone(original)
+			copy(file[p.At:], sealed[HeaderSize:HeaderSize+tt.copied])
+			side := bytes.Clone(sealed)
+			if tt.damage {
+				side[HeaderSize+p.Length/2] ^= 1
+			}
+			if err := os.WriteFile(path, file, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path+sealExt, side, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			db, err := Open(path, Config{})
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer db.Close()
+			if _, err := os.Stat(path + sealExt); !os.IsNotExist(err) {
+				t.Errorf(".seal file left behind: %v", err)
+			}
+			sealCheck(t, db, tt.name)
+		})
+	}
+}
+
+// TestSealResumeReadOnly verifies that a read-only Open refuses a file
+// with an interrupted seal, which only a writable Open can finish, and
+// opens it once one has.
+func TestSealResumeReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setupSeal(t, db)
+	db.mu.Lock()
+	tmp, _, err := db.sealOutput()
+	if err != nil || tmp == nil {
+		db.mu.Unlock()
+		t.Fatalf("sealOutput = %v, %v", tmp, err)
+	}
+	tmp.Close()
+	db.mu.Unlock()
+	db.Close()
+
+	if ro, err := Open(path, Config{ReadOnly: true}); !errors.Is(err, ErrReadOnly) {
+		if err == nil {
+			ro.Close()
+		}
+		t.Fatalf("read-only Open with a .seal file = %v, want ErrReadOnly", err)
+	}
+	db, err = Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	ro, err := Open(path, Config{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("read-only Open after the seal finished: %v", err)
+	}
+	defer ro.Close()
+	sealCheck(t, ro, "read-only")
+}
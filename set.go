@@ -8,49 +8,146 @@
 // This approach avoids rewriting the file on every update while keeping
 // the latest version immediately accessible via the newest index.
 //
-// Batch amortises lock acquisition across multiple documents. All
-// inputs are validated before any writes begin — if validation fails,
-// no documents are written.
+// Create and Update are Set with a condition on the existing document,
+// checked under the same write lock as the write itself, so two
+// processes racing to create one label cannot both succeed. An expired
+// document counts as absent.
+//
+// Batch amortises lock acquisition across multiple operations: puts,
+// which are Documents, mixed with deletes and renames, as a sync job
+// replaying another store's changes needs them. All inputs are
+// validated before any writes begin — if validation fails, nothing is
+// written — and the whole batch is synced once. It is not atomic: an
+// operation that fails on the file's contents, such as deleting a label
+// that does not exist, stops the batch with the operations before it
+// written. A Txn is all or nothing.
 package folio
 
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 // Set creates or updates a document. See the package comment for the
 // append-then-blank strategy.
-func (db *DB) Set(label, content string) error {
-	if err := validateDoc(label, content); err != nil {
+func (db *DB) Set(label, content string) (err error) {
+	defer db.observe(OpSet, label, time.Now(), &err)
+
+	if err := db.checkDoc(label, content); err != nil {
 		return err
 	}
+	return db.single(func() error {
+		return db.setOne(label, content, 0)
+	})
+}
+
+// single runs write, which writes one document, under the write lock,
+// then syncs, runs the after hooks, and compacts if the threshold was
+// reached. A write refused for Config.MaxDatabaseSize is run once more
+// if compacting made room (see quota.go); one document's write that
+// fails has written nothing, so it can be.
+func (db *DB) single(write func() error) error {
+	err := db.locked(write)
+	if db.compactedRoom(err) {
+		err = db.locked(write)
+	}
+	return err
+}
 
+// locked runs write under the write lock, as single does, once.
+func (db *DB) locked(write func() error) error {
 	if err := db.blockWrite(); err != nil {
 		return err
 	}
 
-	err := db.setOne(label, content)
+	err := write()
 
 	// Check the compaction threshold while locks are held so the read
 	// of State is consistent. Compact() is called after releasing both
 	// locks because it acquires its own locks internally — calling it
 	// here would deadlock.
 	compact := err == nil && db.shouldCompact()
+	events := db.takeEvents()
 	db.mu.Unlock()
 	db.lock.Unlock()
 
+	if err == nil {
+		err = db.durable()
+	}
+	db.after(events)
 	if compact {
 		db.Compact()
 	}
 	return err
 }
 
-// Batch creates or updates multiple documents under a single lock
-// hold. All inputs are validated before any writes begin. Documents
-// are processed in slice order.
-func (db *DB) Batch(docs ...Document) error {
-	for _, d := range docs {
-		if err := validateDoc(d.Label, d.Data); err != nil {
+// BatchOp is one operation of a Batch: a Document to create or update,
+// or one of the operations Delete and Rename return.
+type BatchOp interface {
+	check(db *DB) error // validates the operation before any is applied
+	apply(db *DB) error // applies it; the write lock must be held
+}
+
+func (d Document) check(db *DB) error { return db.checkDoc(d.Label, d.Data) }
+func (d Document) apply(db *DB) error { return db.setOne(d.Label, d.Data, 0) }
+
+// Put returns the batch operation that creates or updates label, as Set
+// does. It is the Document itself.
+func Put(label, content string) BatchOp {
+	return Document{Label: label, Data: content}
+}
+
+// deleteOp is a Delete in a Batch.
+type deleteOp struct{ label string }
+
+// Delete returns the batch operation that deletes label, as DB.Delete
+// does.
+func Delete(label string) BatchOp { return deleteOp{label} }
+
+func (o deleteOp) check(*DB) error {
+	if o.label == "" {
+		return ErrInvalidLabel
+	}
+	return nil
+}
+
+func (o deleteOp) apply(db *DB) error { return db.delete(o.label) }
+
+// renameOp is a Rename in a Batch.
+type renameOp struct{ old, new string }
+
+// Rename returns the batch operation that renames old to new, as
+// DB.Rename does.
+func Rename(old, new string) BatchOp { return renameOp{old, new} }
+
+func (o renameOp) check(db *DB) error {
+	if o.old == "" {
+		return ErrInvalidLabel
+	}
+	return db.checkLabel(o.new)
+}
+
+func (o renameOp) apply(db *DB) error {
+	if o.old == o.new {
+		return nil
+	}
+	if err := db.rename(o.old, o.new); err != nil {
+		return err
+	}
+	db.usage.writes.Add(1)
+	return nil
+}
+
+// Batch applies multiple operations under a single lock hold and one
+// sync. All are validated before any is applied, then applied in
+// order; the first that fails stops the batch, with those before it
+// written (see the package comment).
+func (db *DB) Batch(ops ...BatchOp) (err error) {
+	defer db.observe(OpBatch, "", time.Now(), &err)
+
+	for _, op := range ops {
+		if err := op.check(db); err != nil {
 			return err
 		}
 	}
@@ -59,26 +156,65 @@ func (db *DB) Batch(docs ...Document) error {
 		return err
 	}
 
-	var err error
-	for _, d := range docs {
-		if err = db.setOne(d.Label, d.Data); err != nil {
+	for _, op := range ops {
+		if err = op.apply(db); err != nil {
 			break
 		}
 	}
 
 	// Same pattern as Set: check threshold under lock, compact after release.
 	compact := err == nil && db.shouldCompact()
+	events := db.takeEvents()
 	db.mu.Unlock()
 	db.lock.Unlock()
 
+	if err == nil {
+		err = db.durable()
+	}
+	db.after(events)
 	if compact {
 		db.Compact()
 	}
 	return err
 }
 
+// Create writes a new document, failing with ErrExists if the label
+// already exists.
+func (db *DB) Create(label, content string) error {
+	return db.setCond(label, content, condAbsent)
+}
+
+// Update writes a new version of an existing document, failing with
+// ErrNotFound if the label does not exist.
+func (db *DB) Update(label, content string) error {
+	return db.setCond(label, content, condExists)
+}
+
+// setCond is Set with a condition on the existing document.
+func (db *DB) setCond(label, content string, cond int) (err error) {
+	defer db.observe(OpSet, label, time.Now(), &err)
+
+	if err := db.checkDoc(label, content); err != nil {
+		return err
+	}
+	return db.single(func() error {
+		return db.setIf(label, content, 0, cond, 0)
+	})
+}
+
 // validateDoc checks label and content constraints before any write.
 func validateDoc(label, content string) error {
+	if err := validateLabel(label); err != nil {
+		return err
+	}
+	if content == "" {
+		return ErrEmptyContent
+	}
+	return nil
+}
+
+// validateLabel checks the label constraints shared by every write.
+func validateLabel(label string) error {
 	if label == "" {
 		return ErrInvalidLabel
 	}
@@ -88,15 +224,55 @@ func validateDoc(label, content string) error {
 	if strings.Contains(label, `"`) {
 		return ErrInvalidLabel
 	}
-	if content == "" {
-		return ErrEmptyContent
+	return nil
+}
+
+// checkLabel applies validateLabel, then Config.LabelValidator, to a
+// label about to be written. The validator's error is wrapped in
+// ErrInvalidLabel, so callers can test for either.
+func (db *DB) checkLabel(label string) error {
+	if err := validateLabel(label); err != nil {
+		return err
+	}
+	if db.config.LabelValidator != nil {
+		if err := db.config.LabelValidator(label); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidLabel, err)
+		}
 	}
 	return nil
 }
 
-// setOne writes a single document. The write lock must be held.
-func (db *DB) setOne(label, content string) error {
-	id := hash(label, db.header.Algorithm)
+// checkDoc is validateDoc with Config.LabelValidator and
+// Config.Validator applied.
+func (db *DB) checkDoc(label, content string) error {
+	if err := db.checkLabel(label); err != nil {
+		return err
+	}
+	if err := validateDoc(label, content); err != nil {
+		return err
+	}
+	return db.validate(label, content)
+}
+
+// Conditions setIf places on the existing document.
+const (
+	condAny    = iota // Set: create or update
+	condAbsent        // Create
+	condExists        // Update
+)
+
+// setOne writes a single document, expiring at the given unix ms time
+// or never if it is 0. The write lock must be held.
+func (db *DB) setOne(label, content string, expiry int64) error {
+	return db.setIf(label, content, expiry, condAny, 0)
+}
+
+// setIf is setOne with a condition on the existing document, checked
+// before anything is written. A non-zero at dates the version instead
+// of the clock, moved forward to the current version's time if it is
+// older so the versions stay in order.
+func (db *DB) setIf(label, content string, expiry int64, cond int, at int64) error {
+	id := db.id(label)
 
 	sz, err := size(db.reader)
 	if err != nil {
@@ -109,13 +285,32 @@ func (db *DB) setOne(label, content string) error {
 	}
 
 	ts := now()
+	exists := idxResult != nil && !idx.expired(ts)
+	if at != 0 {
+		ts = at
+		if idxResult != nil {
+			ts = max(ts, idx.Timestamp)
+		}
+	}
+	label = stored(label, idx, ts)
+	switch {
+	case cond == condAbsent && exists:
+		return ErrExists
+	case cond == condExists && !exists:
+		return ErrNotFound
+	}
+	if err := db.versionRoom(id, label, sz); err != nil {
+		return fmt.Errorf("set: %w", err)
+	}
+	if err := db.beforeSet(label, content); err != nil {
+		return err
+	}
 	newRecord := &Record{
 		Type:      TypeRecord,
 		ID:        id,
 		Label:     label,
 		Timestamp: ts,
 		Data:      content,
-		History:   compress([]byte(content)),
 	}
 
 	newIndex := &Index{
@@ -123,26 +318,51 @@ func (db *DB) setOne(label, content string) error {
 		ID:        id,
 		Label:     label,
 		Timestamp: ts,
+		Created:   carried(idx, ts),
+		Expires:   expiry,
 	}
 
 	if _, err := db.append(newRecord, newIndex); err != nil {
 		return fmt.Errorf("set: %w", err)
 	}
 
-	if db.bloom != nil {
-		db.bloom.Add(id)
+	if err := db.supersede(id, label, expiry, idxResult, idx); err != nil {
+		return fmt.Errorf("set: %w", err)
 	}
+	db.wroteSet(label, content)
+	return nil
+}
 
-	if idxResult == nil {
-		db.count.Add(1)
+// carried returns the creation time for a new version written at ts.
+// Created carries forward from the previous index. A file written
+// before _c existed has no value to carry; compaction backfills it.
+// An expired document is gone as far as readers are concerned, so
+// writing it again starts a new document.
+func carried(idx *Index, ts int64) int64 {
+	if idx != nil && !idx.expired(ts) {
+		return idx.Created
 	}
+	return ts
+}
 
-	// Retire the previous version.
-	if idxResult != nil {
-		if err := blank(db, idx.Offset, idxResult); err != nil {
-			return fmt.Errorf("set: %w", err)
-		}
+// supersede finishes a write after the new version of id has been
+// appended, expiring at expiry: it updates the bloom filter, label trie,
+// and counters, retires the previous version, if prev is non-nil, and
+// then updates the field indexes from the new one.
+func (db *DB) supersede(id, label string, expiry int64, prev *Result, idx *Index) error {
+	if db.bloom != nil {
+		db.bloom.Add(id)
 	}
+	if db.labels != nil {
+		db.labels.put(label, expiry)
+	}
+	db.usage.writes.Add(1)
+	db.ops.sets.Add(1)
 
-	return nil
+	if prev == nil {
+		db.count.Add(1)
+	} else if err := blank(db, idx.Offset, prev); err != nil {
+		return err
+	}
+	return db.reindex(label)
 }
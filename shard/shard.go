@@ -0,0 +1,470 @@
+// Package shard spreads one database across a directory of folio files.
+//
+// A single file has a practical limit: every compaction rewrites all of
+// it, and a file many times the size of memory takes as long to compact
+// as to copy. A sharded database instead keeps its documents in a fixed
+// set of segment files, each holding the labels whose _id falls in one
+// range, so each file stays a fraction of the whole and is compacted on
+// its own:
+//
+//	db, _ := shard.Open("notes.d", folio.Config{}, shard.Options{Segments: 16})
+//	db.Set("notes/a", "hello")
+//	content, _ := db.Get("notes/a")
+//
+// The segments are named for the range of the first byte of the IDs
+// they hold, seg-00-0f.folio through seg-f0-ff.folio for 16, so the
+// directory describes itself and needs no manifest. Open takes the
+// segment count from the files already there; Options only shapes a new
+// directory. Every segment is opened with the same Config, which must
+// keep the hash algorithm the directory was created with, as the _id
+// decides where a label lives.
+//
+// A DB has the document methods of folio.DB. A call on one label goes to
+// its segment alone, and a listing or search visits each segment in
+// turn, so results are grouped by segment rather than sorted, and the
+// offsets in a Match are within its segment's file. A Rename or Copy
+// between labels in different segments writes the new label's segment
+// and then the old one's, so unlike within one file it is not atomic: a
+// crash between the two leaves the document under both labels.
+// Transactions, snapshots, and tags span only one file, and are reached
+// through Segments.
+//
+// Compact compacts the segments one after another, so the extra disk and
+// the time writers wait are those of one segment. Each segment also
+// compacts itself by Config.AutoCompact and Config.CompactPolicy.
+package shard
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"iter"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	json "github.com/goccy/go-json"
+	"github.com/jpl-au/folio"
+)
+
+// ErrLayout is returned by Open when the segment files in the directory
+// do not cover every ID exactly once, or disagree on how IDs are made.
+var ErrLayout = errors.New("shard: segment files do not match")
+
+// Options shapes a new sharded directory.
+type Options struct {
+	// Segments is the number of segment files, a power of two from 1 to
+	// 256; 16 if 0. It applies when the directory is created; an existing
+	// one keeps the segments it has.
+	Segments int
+}
+
+// segName matches a segment file and captures its ID range.
+var segName = regexp.MustCompile(`^seg-([0-9a-f]{2})-([0-9a-f]{2})\.folio$`)
+
+// DB is a database sharded across the segment files of a directory. It
+// is safe for concurrent use.
+type DB struct {
+	segs  []*folio.DB // in ID order
+	route [256]uint8  // first ID byte → index in segs
+}
+
+// Open opens the sharded database in dir, creating the directory and its
+// segments if there are none, unless cfg.ReadOnly is set.
+func Open(dir string, cfg folio.Config, opts Options) (*DB, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !(errors.Is(err, os.ErrNotExist) && !cfg.ReadOnly) {
+		return nil, fmt.Errorf("shard: %w", err)
+	}
+
+	type span struct {
+		name   string
+		lo, hi int
+	}
+	var spans []span
+	for _, e := range entries {
+		m := segName.FindStringSubmatch(e.Name())
+		if m == nil || e.IsDir() {
+			continue
+		}
+		lo, _ := strconv.ParseUint(m[1], 16, 8)
+		hi, _ := strconv.ParseUint(m[2], 16, 8)
+		spans = append(spans, span{e.Name(), int(lo), int(hi)})
+	}
+	if len(spans) == 0 {
+		if cfg.ReadOnly {
+			return nil, fmt.Errorf("shard: %s: %w", dir, os.ErrNotExist)
+		}
+		n := cmp.Or(opts.Segments, 16)
+		if n < 1 || n > 256 || n&(n-1) != 0 {
+			return nil, fmt.Errorf("shard: %d segments: not a power of two from 1 to 256", n)
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("shard: %w", err)
+		}
+		width := 256 / n
+		for lo := 0; lo < 256; lo += width {
+			hi := lo + width - 1
+			spans = append(spans, span{fmt.Sprintf("seg-%02x-%02x.folio", lo, hi), lo, hi})
+		}
+	}
+	slices.SortFunc(spans, func(a, b span) int { return cmp.Compare(a.lo, b.lo) })
+
+	db := &DB{}
+	next := 0
+	for _, sp := range spans {
+		if sp.lo != next || sp.hi < sp.lo {
+			return nil, fmt.Errorf("%w: %s does not follow ID %02x", ErrLayout, sp.name, next)
+		}
+		for b := sp.lo; b <= sp.hi; b++ {
+			db.route[b] = uint8(len(db.segs))
+		}
+		seg, err := folio.Open(filepath.Join(dir, sp.name), cfg)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("shard: %s: %w", sp.name, err)
+		}
+		db.segs = append(db.segs, seg)
+		next = sp.hi + 1
+	}
+	if next != 256 {
+		db.Close()
+		return nil, fmt.Errorf("%w: no segment holds ID %02x", ErrLayout, next)
+	}
+
+	// A label must hash alike in every segment, or it would be looked
+	// for in one and stored in another.
+	const probe = "shard/probe"
+	for _, seg := range db.segs[1:] {
+		if seg.ID(probe) != db.segs[0].ID(probe) {
+			db.Close()
+			return nil, fmt.Errorf("%w: segments use different hash algorithms", ErrLayout)
+		}
+	}
+	return db, nil
+}
+
+// Close closes every segment.
+func (db *DB) Close() error {
+	var errs []error
+	for _, seg := range db.segs {
+		errs = append(errs, seg.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Segments returns the segment databases in ID order, for the calls
+// that work on one file at a time: Txn, Snapshot, tags, Verify, Backup.
+// Writing a label to a segment other than its own hides it from DB.
+func (db *DB) Segments() []*folio.DB {
+	return slices.Clone(db.segs)
+}
+
+// segment returns the segment that holds label.
+func (db *DB) segment(label string) *folio.DB {
+	id := db.segs[0].ID(label)
+	b, _ := strconv.ParseUint(id[:2], 16, 8)
+	return db.segs[db.route[b]]
+}
+
+// Get returns the current content of a document. See folio.DB.Get.
+func (db *DB) Get(label string) (string, error) {
+	return db.segment(label).Get(label)
+}
+
+// GetOr returns the content of a document, or def if it does not exist.
+// See folio.DB.GetOr.
+func (db *DB) GetOr(label, def string) (string, error) {
+	return db.segment(label).GetOr(label, def)
+}
+
+// GetOrSet returns the content of a document, creating it with initial
+// if it does not exist. See folio.DB.GetOrSet.
+func (db *DB) GetOrSet(label, initial string) (string, error) {
+	return db.segment(label).GetOrSet(label, initial)
+}
+
+// GetBytes returns the content of a document written with SetBytes. See
+// folio.DB.GetBytes.
+func (db *DB) GetBytes(label string) ([]byte, error) {
+	return db.segment(label).GetBytes(label)
+}
+
+// GetAt returns the content as it was at ts. See folio.DB.GetAt.
+func (db *DB) GetAt(label string, ts int64) (string, error) {
+	return db.segment(label).GetAt(label, ts)
+}
+
+// GetVersion returns the nth version of a document. See
+// folio.DB.GetVersion.
+func (db *DB) GetVersion(label string, n int) (string, error) {
+	return db.segment(label).GetVersion(label, n)
+}
+
+// GetMany returns the content of each label that exists, asking each
+// segment once for its share. See folio.DB.GetMany.
+func (db *DB) GetMany(labels ...string) (map[string]string, error) {
+	bySeg := map[*folio.DB][]string{}
+	for _, lbl := range labels {
+		seg := db.segment(lbl)
+		bySeg[seg] = append(bySeg[seg], lbl)
+	}
+	out := map[string]string{}
+	for seg, lbls := range bySeg {
+		m, err := seg.GetMany(lbls...)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(out, m)
+	}
+	return out, nil
+}
+
+// Exists reports whether a document exists. See folio.DB.Exists.
+func (db *DB) Exists(label string) (bool, error) {
+	return db.segment(label).Exists(label)
+}
+
+// Info returns a document's metadata. See folio.DB.Info.
+func (db *DB) Info(label string) (folio.DocInfo, error) {
+	return db.segment(label).Info(label)
+}
+
+// History yields every version of a document, oldest first. See
+// folio.DB.History.
+func (db *DB) History(label string) iter.Seq2[folio.Version, error] {
+	return db.segment(label).History(label)
+}
+
+// Set writes a document. See folio.DB.Set.
+func (db *DB) Set(label, content string) error {
+	return db.segment(label).Set(label, content)
+}
+
+// SetAsync queues a write on the document's segment. See
+// folio.DB.SetAsync.
+func (db *DB) SetAsync(label, content string) <-chan error {
+	return db.segment(label).SetAsync(label, content)
+}
+
+// Flush waits for the writes queued on every segment. See
+// folio.DB.Flush.
+func (db *DB) Flush() error {
+	for _, seg := range db.segs {
+		if err := seg.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetBytes writes binary content. See folio.DB.SetBytes.
+func (db *DB) SetBytes(label string, data []byte) error {
+	return db.segment(label).SetBytes(label, data)
+}
+
+// SetWithTTL writes a document that expires after ttl. See
+// folio.DB.SetWithTTL.
+func (db *DB) SetWithTTL(label, content string, ttl time.Duration) error {
+	return db.segment(label).SetWithTTL(label, content, ttl)
+}
+
+// Create writes a document only if it does not exist. See
+// folio.DB.Create.
+func (db *DB) Create(label, content string) error {
+	return db.segment(label).Create(label, content)
+}
+
+// Update writes a document only if it exists. See folio.DB.Update.
+func (db *DB) Update(label, content string) error {
+	return db.segment(label).Update(label, content)
+}
+
+// Delete removes a document. See folio.DB.Delete.
+func (db *DB) Delete(label string) error {
+	return db.segment(label).Delete(label)
+}
+
+// DeleteMany removes the labels, every one or none within each segment.
+// A missing label fails the call before any segment is written; the
+// segments are otherwise written one after another.
+func (db *DB) DeleteMany(labels ...string) error {
+	bySeg := map[*folio.DB][]string{}
+	for _, lbl := range labels {
+		ok, err := db.Exists(lbl)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("delete %s: %w", lbl, folio.ErrNotFound)
+		}
+		seg := db.segment(lbl)
+		bySeg[seg] = append(bySeg[seg], lbl)
+	}
+	for _, seg := range db.segs {
+		if lbls := bySeg[seg]; len(lbls) > 0 {
+			if err := seg.DeleteMany(lbls...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Rename moves a document to a new label, with its history. Between
+// segments the document is imported into the new label's segment, with
+// its versions and their timestamps, its creation time and its expiry,
+// before the old label is deleted (see the package comment). See
+// folio.DB.Rename.
+func (db *DB) Rename(old, new string) error {
+	from, to := db.segment(old), db.segment(new)
+	if from == to {
+		return from.Rename(old, new)
+	}
+	info, err := from.Info(old)
+	if err != nil {
+		return err
+	}
+	versions, err := gather(from.History(old))
+	if err != nil {
+		return err
+	}
+	dump, err := exported(new, info, versions)
+	if err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	if err := to.Import(bytes.NewReader(dump)); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return from.Delete(old)
+}
+
+// exported returns a dump in the format of folio.DB.Export holding one
+// document: versions under label, created and expiring as info says.
+func exported(label string, info folio.DocInfo, versions []folio.Version) ([]byte, error) {
+	type version struct {
+		TS     int64  `json:"ts"`
+		Data   string `json:"d,omitempty"`
+		Binary string `json:"b,omitempty"`
+	}
+	doc := struct {
+		Label    string    `json:"l"`
+		Created  int64     `json:"c,omitempty"`
+		Expires  int64     `json:"ex,omitempty"`
+		Versions []version `json:"v"`
+	}{Label: label, Created: info.Created, Expires: info.Expires}
+	for _, v := range versions {
+		if utf8.ValidString(v.Data) {
+			doc.Versions = append(doc.Versions, version{TS: v.TS, Data: v.Data})
+		} else {
+			doc.Versions = append(doc.Versions, version{TS: v.TS, Binary: base64.StdEncoding.EncodeToString([]byte(v.Data))})
+		}
+	}
+	line, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(`{"folio_export":1}`+"\n"), line...), '\n'), nil
+}
+
+// Copy writes src's current content to dst. See folio.DB.Copy.
+func (db *DB) Copy(src, dst string) error {
+	from, to := db.segment(src), db.segment(dst)
+	if from == to {
+		return from.Copy(src, dst)
+	}
+	content, err := from.Get(src)
+	if err != nil {
+		return err
+	}
+	return to.Set(dst, content)
+}
+
+// Touch refreshes a document's timestamp. See folio.DB.Touch.
+func (db *DB) Touch(label string) error {
+	return db.segment(label).Touch(label)
+}
+
+// Patch applies a JSON merge patch to a document. See folio.DB.Patch.
+func (db *DB) Patch(label string, patch []byte) error {
+	return db.segment(label).Patch(label, patch)
+}
+
+// Revert restores the version written at ts. See folio.DB.Revert.
+func (db *DB) Revert(label string, ts int64) error {
+	return db.segment(label).Revert(label, ts)
+}
+
+// Count returns the number of documents in every segment.
+func (db *DB) Count() int {
+	n := 0
+	for _, seg := range db.segs {
+		n += seg.Count()
+	}
+	return n
+}
+
+// Compact compacts each segment in turn, so no more than one is being
+// rebuilt at a time. It stops at the first that fails.
+func (db *DB) Compact() error {
+	for _, seg := range db.segs {
+		if err := seg.Compact(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List yields the label of every document, segment by segment. See
+// folio.DB.List.
+func (db *DB) List() iter.Seq2[string, error] {
+	return each(db, (*folio.DB).List)
+}
+
+// ListPrefix yields the labels under prefix. See folio.DB.ListPrefix.
+func (db *DB) ListPrefix(prefix string) iter.Seq2[string, error] {
+	return each(db, func(seg *folio.DB) iter.Seq2[string, error] { return seg.ListPrefix(prefix) })
+}
+
+// Glob yields the labels matching a shell pattern. See folio.DB.Glob.
+func (db *DB) Glob(pattern string) iter.Seq2[string, error] {
+	return each(db, func(seg *folio.DB) iter.Seq2[string, error] { return seg.Glob(pattern) })
+}
+
+// Search yields the documents whose content matches pattern, segment by
+// segment. See folio.DB.Search.
+func (db *DB) Search(pattern string, opts folio.SearchOptions) iter.Seq2[folio.Match, error] {
+	return each(db, func(seg *folio.DB) iter.Seq2[folio.Match, error] { return seg.Search(pattern, opts) })
+}
+
+// each chains the iterator f returns for every segment, in ID order.
+func each[T any](db *DB, f func(*folio.DB) iter.Seq2[T, error]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for _, seg := range db.segs {
+			for v, err := range f(seg) {
+				if !yield(v, err) || err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// gather collects an iterator into a slice, stopping at its first error.
+func gather[T any](seq iter.Seq2[T, error]) ([]T, error) {
+	var out []T
+	for v, err := range seq {
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
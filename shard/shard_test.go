@@ -0,0 +1,237 @@
+package shard
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jpl-au/folio"
+)
+
+// open opens a sharded database in a temp directory.
+func open(t *testing.T, n int) (*DB, string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "db.d")
+	db, err := Open(dir, folio.Config{}, Options{Segments: n})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, dir
+}
+
+// TestOpenLayout verifies that a new directory gets one file per ID
+// range, and that reopening it keeps them whatever Options say.
+func TestOpenLayout(t *testing.T) {
+	db, dir := open(t, 4)
+	db.Close()
+	names, _ := filepath.Glob(filepath.Join(dir, "*.folio"))
+	for i := range names {
+		names[i] = filepath.Base(names[i])
+	}
+	want := []string{"seg-00-3f.folio", "seg-40-7f.folio", "seg-80-bf.folio", "seg-c0-ff.folio"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("segments = %v, want %v", names, want)
+	}
+
+	db, err := Open(dir, folio.Config{}, Options{Segments: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if n := len(db.Segments()); n != 4 {
+		t.Errorf("reopened with %d segments, want 4", n)
+	}
+
+	if _, err := Open(filepath.Join(t.TempDir(), "x"), folio.Config{}, Options{Segments: 3}); err == nil {
+		t.Error("Open with 3 segments succeeded")
+	}
+	if _, err := Open(filepath.Join(t.TempDir(), "x"), folio.Config{ReadOnly: true}, Options{}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("read-only Open of a missing directory = %v, want ErrNotExist", err)
+	}
+}
+
+// TestOpenGap verifies that a missing segment is refused rather than
+// leaving its labels with nowhere to go.
+func TestOpenGap(t *testing.T) {
+	db, dir := open(t, 4)
+	db.Close()
+	os.Remove(filepath.Join(dir, "seg-40-7f.folio"))
+	if _, err := Open(dir, folio.Config{}, Options{}); !errors.Is(err, ErrLayout) {
+		t.Errorf("Open = %v, want ErrLayout", err)
+	}
+}
+
+// TestOpenHashMismatch verifies that a segment written under another
+// hash algorithm is refused, as its labels would be routed elsewhere.
+func TestOpenHashMismatch(t *testing.T) {
+	db, dir := open(t, 2)
+	db.Close()
+	path := filepath.Join(dir, "seg-80-ff.folio")
+	os.Remove(path)
+	seg, err := folio.Open(path, folio.Config{HashAlgorithm: folio.AlgFNV1a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg.Close()
+	if _, err := Open(dir, folio.Config{}, Options{}); !errors.Is(err, ErrLayout) {
+		t.Errorf("Open = %v, want ErrLayout", err)
+	}
+}
+
+// TestRouting verifies that documents land in the segment for their ID
+// and are found again through the directory, after a reopen too.
+func TestRouting(t *testing.T) {
+	db, dir := open(t, 4)
+	for i := range 40 {
+		if err := db.Set(fmt.Sprintf("doc/%d", i), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	used := 0
+	for _, seg := range db.Segments() {
+		if seg.Count() > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		t.Errorf("documents in %d segments, want them spread", used)
+	}
+	db.Close()
+
+	db, err := Open(dir, folio.Config{}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Count() != 40 {
+		t.Errorf("Count = %d, want 40", db.Count())
+	}
+	labels, err := gather(db.ListPrefix("doc/"))
+	if err != nil || len(labels) != 40 {
+		t.Errorf("ListPrefix = %d labels, %v; want 40", len(labels), err)
+	}
+	got, err := db.GetMany("doc/1", "doc/2", "missing")
+	if err != nil || len(got) != 2 {
+		t.Errorf("GetMany = %v, %v; want 2 documents", got, err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if content, _ := db.Get("doc/7"); content != "v" {
+		t.Errorf("Get after Compact = %q, want v", content)
+	}
+}
+
+// crossing returns a label that routes to a different segment than
+// label does.
+func crossing(t *testing.T, db *DB, label string) string {
+	t.Helper()
+	for i := range 1000 {
+		other := fmt.Sprintf("other/%d", i)
+		if db.segment(other) != db.segment(label) {
+			return other
+		}
+	}
+	t.Fatal("no label in another segment")
+	return ""
+}
+
+// TestRenameAcross verifies that a rename between segments moves the
+// history with its timestamps and leaves nothing under the old label.
+func TestRenameAcross(t *testing.T) {
+	db, _ := open(t, 16)
+	db.Set("doc", "one")
+	db.Set("doc", "two")
+	before, _ := gather(db.History("doc"))
+	to := crossing(t, db, "doc")
+
+	if err := db.Rename("doc", to); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if ok, _ := db.Exists("doc"); ok {
+		t.Error("old label still exists")
+	}
+	after, _ := gather(db.History(to))
+	if len(after) != len(before) {
+		t.Fatalf("History = %+v, want %+v", after, before)
+	}
+	for i := range before {
+		if after[i].Data != before[i].Data || after[i].TS != before[i].TS {
+			t.Errorf("version %d = %+v, want %+v", i, after[i], before[i])
+		}
+	}
+	if err := db.Rename("missing", "x"); !errors.Is(err, folio.ErrNotFound) {
+		t.Errorf("Rename(missing) = %v, want ErrNotFound", err)
+	}
+	db.Set("doc", "again")
+	if err := db.Rename("doc", to); !errors.Is(err, folio.ErrExists) {
+		t.Errorf("Rename onto an existing label = %v, want ErrExists", err)
+	}
+}
+
+// TestRenameAcrossDeleted verifies that a deleted label cannot be
+// renamed into another segment: its history alone must not bring it back
+// under the new label.
+func TestRenameAcrossDeleted(t *testing.T) {
+	db, _ := open(t, 16)
+	db.Set("a", "gone")
+	db.Delete("a")
+	to := crossing(t, db, "a")
+
+	if err := db.Rename("a", to); !errors.Is(err, folio.ErrNotFound) {
+		t.Errorf("Rename of a deleted label = %v, want ErrNotFound", err)
+	}
+	if got, err := db.Get(to); !errors.Is(err, folio.ErrNotFound) {
+		t.Errorf("Get(%s) = %q, %v; want ErrNotFound", to, got, err)
+	}
+}
+
+// TestRenameAcrossKeepsInfo verifies that a rename between segments keeps
+// the document's creation time and expiry.
+func TestRenameAcrossKeepsInfo(t *testing.T) {
+	db, _ := open(t, 16)
+	db.SetWithTTL("doc", "one", time.Hour)
+	time.Sleep(2 * time.Millisecond)
+	db.SetWithTTL("doc", "two", time.Hour)
+	before, err := db.Info("doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := crossing(t, db, "doc")
+
+	if err := db.Rename("doc", to); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	after, err := db.Info(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Created != before.Created || after.Expires != before.Expires || after.Expires == 0 {
+		t.Errorf("Info after Rename = %+v, want the created time and expiry of %+v", after, before)
+	}
+}
+
+// TestDeleteMany verifies that a missing label fails the call before
+// any segment is written.
+func TestDeleteMany(t *testing.T) {
+	db, _ := open(t, 16)
+	db.Set("a", "1")
+	db.Set(crossing(t, db, "a"), "2")
+	if err := db.DeleteMany("a", "missing"); !errors.Is(err, folio.ErrNotFound) {
+		t.Fatalf("DeleteMany = %v, want ErrNotFound", err)
+	}
+	if db.Count() != 2 {
+		t.Errorf("Count = %d, want 2", db.Count())
+	}
+	if err := db.DeleteMany("a", crossing(t, db, "a")); err != nil {
+		t.Fatalf("DeleteMany: %v", err)
+	}
+	if db.Count() != 0 {
+		t.Errorf("Count = %d, want 0", db.Count())
+	}
+}
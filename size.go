@@ -0,0 +1,270 @@
+// Content and history size accounting.
+//
+// ContentBytes and HistoryBytes report how much the current content of
+// every document adds up to, and how many bytes their history records
+// take in the file, for quotas and growth alerts. The first call scans
+// the file once; from then on the handle keeps the totals as it writes,
+// adding each data or history line it appends and moving a version's
+// content to history when a write or delete retires it, and a rebuild
+// counts them afresh.
+//
+// The totals are saved in the metadata record (see meta.go) at Close,
+// with the offset at which the record was written. The next Open takes
+// them up if the file was closed cleanly and ends with that record, so
+// a process that asks for them need not scan at all. A file found
+// longer or shorter than the totals last knew it, by another process's
+// writes or a cut-short stream, or patched by recovery, is scanned
+// again when next asked.
+//
+// SizeOf reports the same two figures for one document, from its lines
+// alone.
+package folio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Sizes are content and history totals as saved in the metadata record.
+// At is the record's own offset: they describe everything before it.
+type Sizes struct {
+	At      int64 `json:"at"`
+	Content int64 `json:"c"`
+	History int64 `json:"h"`
+}
+
+// DocSize is how much of the file a document takes.
+type DocSize struct {
+	Content int64 // bytes of current content, as Stat reports Size
+	History int64 // bytes of its history records in the file, newlines included
+}
+
+// sizes is the running content and history totals of a file at bytes
+// long. at is -1 once a write the totals cannot follow has been made.
+// Writes update it under the write lock; readers only read it, under
+// the read lock, except the first count, which DB.sizesMu serialises.
+type sizes struct {
+	at      int64
+	content int64
+	history int64
+}
+
+// appended adds the data and history lines among lines, written at off.
+// A write anywhere but the known end of the file loses the totals.
+func (s *sizes) appended(off int64, lines []byte) {
+	if off != s.at {
+		s.at = -1
+		return
+	}
+	s.at = off + int64(len(lines))
+	for ln := range bytes.SplitSeq(lines, []byte{'\n'}) {
+		if len(ln) < MinRecordSize {
+			continue
+		}
+		switch ln[TypePos] {
+		case '0' + TypeRecord:
+			s.content += contentSize(ln)
+		case '0' + TypeHistory:
+			s.history += int64(len(ln)) + 1
+		}
+	}
+}
+
+// retired moves a version, whose data line was n bytes long and held
+// content bytes, from content to history.
+func (s *sizes) retired(content int64, n int) {
+	s.content -= content
+	s.history += int64(n) + 1
+}
+
+// lost notes a patch the totals cannot follow.
+func (s *sizes) lost() {
+	s.at = -1
+}
+
+// contentSize returns the length of a data line's content from its _d
+// value, without decoding or decrypting it.
+func contentSize(ln []byte) int64 {
+	d := between(ln, `"_d":"`, `","_h":"`)
+	switch {
+	case binary(ln) || encrypted(ln):
+		return decodedSize(d, encrypted(ln))
+	case bytes.IndexByte(d, '\\') >= 0:
+		return int64(len(unescape(d)))
+	}
+	return int64(len(d))
+}
+
+// decodedSize returns the length of base64 content d once decoded, less
+// the nonce and tag if it is encrypted.
+func decodedSize(d []byte, encrypted bool) int64 {
+	n := base64.StdEncoding.DecodedLen(len(d)) - bytes.Count(d[max(len(d)-2, 0):], []byte("="))
+	if encrypted {
+		n -= chacha20poly1305.NonceSizeX + chacha20poly1305.Overhead
+	}
+	return int64(max(n, 0))
+}
+
+// between returns the bytes of ln between start and the end marker after
+// it, or nil.
+func between(ln []byte, start, end string) []byte {
+	i := bytes.Index(ln, []byte(start))
+	if i < 0 {
+		return nil
+	}
+	v := ln[i+len(start):]
+	j := bytes.Index(v, []byte(end))
+	if j < 0 {
+		return nil
+	}
+	return v[:j]
+}
+
+// ContentBytes returns the total size of every current document's
+// content, as Stat reports each. Expired documents whose lines remain
+// are counted until a rebuild drops them.
+func (db *DB) ContentBytes() (int64, error) {
+	s, err := db.totals()
+	return s.Content, err
+}
+
+// HistoryBytes returns the bytes the file's history records take, which
+// Purge would reclaim along with the tombstones.
+func (db *DB) HistoryBytes() (int64, error) {
+	s, err := db.totals()
+	return s.History, err
+}
+
+// totals returns the running totals, scanning the file first if they are
+// not known for it as it is now.
+func (db *DB) totals() (Sizes, error) {
+	db.beginScan()
+	defer db.endScan()
+	if err := db.blockRead(); err != nil {
+		return Sizes{}, err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+
+	sz, err := size(db.reader)
+	if err != nil {
+		return Sizes{}, fmt.Errorf("sizes: stat: %w", err)
+	}
+	// Readers share the lock, so one scans while the others wait for it.
+	db.sizesMu.Lock()
+	defer db.sizesMu.Unlock()
+	if saved := db.savedSizes(sz); saved != nil {
+		return *saved, nil
+	}
+	s, err := db.scanSizes(sz)
+	if err != nil {
+		return Sizes{}, err
+	}
+	db.sizes = s
+	return Sizes{At: sz, Content: s.content, History: s.history}, nil
+}
+
+// scanSizes counts the totals of the first sz bytes of the file. The
+// caller must hold the read lock.
+func (db *DB) scanSizes(sz int64) (*sizes, error) {
+	s := &sizes{at: sz}
+	scanner := bufio.NewScanner(io.NewSectionReader(db.reader, HeaderSize, sz-HeaderSize))
+	scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
+	for scanner.Scan() {
+		ln := scanner.Bytes()
+		if !valid(ln) || len(ln) < MinRecordSize {
+			continue
+		}
+		switch ln[TypePos] {
+		case '0' + TypeRecord:
+			n, err := db.dataSize(ln)
+			if err != nil {
+				return nil, fmt.Errorf("sizes: %w", err)
+			}
+			s.content += n
+		case '0' + TypeHistory:
+			s.history += int64(len(ln)) + 1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("sizes: %w", err)
+	}
+	return s, nil
+}
+
+// loadSizes takes up the totals saved in the metadata record if the file
+// was closed cleanly and still ends with that record, size bytes long.
+func (db *DB) loadSizes(size int64) {
+	if db.meta == nil || db.meta.Sizes == nil || db.header.Error != 0 {
+		return
+	}
+	saved := db.meta.Sizes
+	if saved.At != int64(db.header.State[stMeta]) {
+		return
+	}
+	data, err := line(db.reader, saved.At)
+	if err != nil || saved.At+int64(len(data))+1 != size {
+		return
+	}
+	db.sizes = &sizes{at: size, content: saved.Content, history: saved.History}
+}
+
+// savedSizes returns the totals for the file as it ends at off, to store
+// in a metadata record written there, or nil if they are not known.
+func (db *DB) savedSizes(off int64) *Sizes {
+	s := db.sizes
+	if s == nil || s.at != off {
+		return nil
+	}
+	return &Sizes{At: off, Content: s.content, History: s.history}
+}
+
+// SizeOf returns how much of the file label takes: its current content
+// and its history records. It reads only the document's own lines, and
+// decompresses nothing. Returns ErrNotFound if label does not exist.
+func (db *DB) SizeOf(label string) (DocSize, error) {
+	if err := db.blockRead(); err != nil {
+		return DocSize{}, err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+	db.usage.reads.Add(1)
+
+	sz, err := size(db.reader)
+	if err != nil {
+		return DocSize{}, fmt.Errorf("sizeof: stat: %w", err)
+	}
+	result, idx, err := db.findIndex(db.id(label), label, sz)
+	if err != nil {
+		return DocSize{}, fmt.Errorf("sizeof: %w", err)
+	}
+	if result == nil || idx.expired(now()) {
+		return DocSize{}, ErrNotFound
+	}
+
+	var ds DocSize
+	for _, r := range db.lines(idx.ID, sz) {
+		ln := r.Data
+		if len(ln) < MinRecordSize || !db.same(string(unescape(between(ln, `"_l":"`, `"`))), idx.Label) {
+			continue
+		}
+		switch ln[TypePos] {
+		case '0' + TypeRecord:
+			if ds.Content, err = db.dataSize(ln); err != nil {
+				return DocSize{}, fmt.Errorf("sizeof: %w", err)
+			}
+		case '0' + TypeHistory:
+			ds.History += int64(len(ln)) + 1
+		}
+	}
+	return ds, nil
+}
@@ -0,0 +1,137 @@
+package folio
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// checkSizes verifies that the running totals match a fresh scan, and
+// that the content total matches the Stat sizes of every document.
+func checkSizes(t *testing.T, db *DB, when string) {
+	t.Helper()
+	content, err := db.ContentBytes()
+	if err != nil {
+		t.Fatalf("%s: ContentBytes: %v", when, err)
+	}
+	history, err := db.HistoryBytes()
+	if err != nil {
+		t.Fatalf("%s: HistoryBytes: %v", when, err)
+	}
+	scanned, err := db.scanSizes(db.tail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != scanned.content || history != scanned.history {
+		t.Errorf("%s: totals = %d, %d; scan = %d, %d", when, content, history, scanned.content, scanned.history)
+	}
+	var want int64
+	for lbl, err := range db.List() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		st, err := db.Stat(lbl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want += st.Size
+	}
+	if content != want {
+		t.Errorf("%s: ContentBytes = %d, Stat sizes add up to %d", when, content, want)
+	}
+}
+
+// TestSizes verifies that the totals follow sets, updates, deletes and
+// renames without a rescan, and are counted afresh by compaction.
+func TestSizes(t *testing.T) {
+	db := openTestDB(t)
+	checkSizes(t, db, "empty")
+
+	db.Set("a", "hello")
+	db.Set("b", `quoted "wörld"`)
+	db.SetBytes("bin", []byte{0, 1, 2, 3, 4})
+	checkSizes(t, db, "sets")
+
+	db.Set("a", "hello again")
+	db.Delete("b")
+	db.Rename("bin", "moved")
+	db.Txn(func(tx *Txn) error { return tx.Set("c", "in a txn") })
+	if db.sizes.at != db.tail {
+		t.Fatalf("writes lost the totals: at %d, file %d bytes", db.sizes.at, db.tail)
+	}
+	checkSizes(t, db, "writes")
+	if h, _ := db.HistoryBytes(); h == 0 {
+		t.Error("HistoryBytes = 0 after an update and a delete")
+	}
+
+	if err := db.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	checkSizes(t, db, "purged")
+	if h, _ := db.HistoryBytes(); h != 0 {
+		t.Errorf("HistoryBytes = %d after Purge, want 0", h)
+	}
+}
+
+// TestSizesSaved verifies that the totals are taken up at Open after a
+// clean Close, and counted again if the file has changed since.
+func TestSizesSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Set("a", "one")
+	db.Set("a", "two")
+	want, _ := db.ContentBytes()
+	db.Close()
+
+	db, err = Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.sizes == nil || db.sizes.content != want {
+		t.Fatalf("totals not taken up at Open: %+v, want content %d", db.sizes, want)
+	}
+	checkSizes(t, db, "reopened")
+	db.Close()
+
+	// A handle that never asks does not save them, so the file as it is
+	// now has none that describe it.
+	other, err := Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.sizes = nil
+	other.Set("b", "more")
+	other.Close()
+
+	db, err = Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.sizes != nil {
+		t.Errorf("totals taken up after the file grew: %+v", db.sizes)
+	}
+	checkSizes(t, db, "grown")
+}
+
+// TestSizeOf verifies one document's content and history sizes.
+func TestSizeOf(t *testing.T) {
+	db := openTestDB(t)
+
+	db.Set("doc", "v1")
+	s, err := db.SizeOf("doc")
+	if err != nil || s.Content != 2 || s.History != 0 {
+		t.Errorf("SizeOf = %+v, %v; want 2 bytes of content, no history", s, err)
+	}
+	db.Set("doc", "version two")
+	s, _ = db.SizeOf("doc")
+	if s.Content != int64(len("version two")) || s.History == 0 {
+		t.Errorf("SizeOf after update = %+v", s)
+	}
+	if _, err := db.SizeOf("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SizeOf(missing) = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,404 @@
+// Read-only snapshots.
+//
+// Every read method takes the read lock for its own duration only, so a
+// caller that lists labels and then fetches them, or pages through a
+// long iteration while writers run, can see a Set that landed in
+// between. A Snapshot pins the documents that were current when it was
+// taken: later writes, deletes, and compactions are invisible to it.
+//
+// Nothing is copied. Taking a snapshot records, for every live index,
+// the offset of the data record it points at, and opens a second file
+// handle. Records never move within a file, and a retired record keeps
+// its compressed _h snapshot, so the content at a pinned offset can
+// always be recovered: from _d while the record is current, from _h
+// once a later write has retired it. Compaction writes a new file and
+// renames it into place; the snapshot's handle keeps the old one, which
+// nothing writes to again, alive until Close. The cost is the memory
+// for one offset per document, and on disk the space of a replaced file
+// until the last snapshot holding it is closed. Windows will not rename
+// over an open file, so there Compact and Repair fail while a snapshot
+// is open.
+//
+// A snapshot only reads, one record at a time under the read lock, so
+// it never holds writers up for longer than a Get would.
+package folio
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"iter"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Snapshot is a read-only view of the documents that were current at
+// the moment DB.Snapshot returned. It is safe for concurrent use and
+// must be closed to release its file handle.
+type Snapshot struct {
+	db     *DB
+	reader storage // handle on the file as it was when taken
+	tail   int64   // end of the file when taken
+	docs   map[string]snapDoc
+	labels []string          // sorted
+	folded map[string]string // folded → stored label, in a case-insensitive file
+}
+
+// snapDoc is what a snapshot remembers about one document.
+type snapDoc struct {
+	offset  int64 // data record
+	created int64
+}
+
+// Snapshot pins the current state of the database. Expired documents
+// are left out; a document that expires after the snapshot is taken
+// stays visible in it.
+func (db *DB) Snapshot() (*Snapshot, error) {
+	db.beginScan()
+	defer db.endScan()
+	if err := db.blockRead(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+
+	reader, err := db.reopen()
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+	s := &Snapshot{db: db, reader: reader, tail: db.tail, docs: map[string]snapDoc{}}
+
+	// Later index lines supersede earlier ones, as in the sparse scan.
+	t := now()
+	scanner := bufio.NewScanner(io.NewSectionReader(reader, HeaderSize, s.tail-HeaderSize))
+	scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
+	for scanner.Scan() {
+		ln := scanner.Bytes()
+		if !valid(ln) || len(ln) < MinRecordSize || ln[TypePos] != byte('0'+TypeIndex) {
+			continue
+		}
+		idx, err := decodeIndex(ln)
+		if err != nil {
+			reader.Close()
+			return nil, fmt.Errorf("snapshot: %w", err)
+		}
+		if idx.expired(t) {
+			delete(s.docs, idx.Label)
+			continue
+		}
+		s.docs[idx.Label] = snapDoc{idx.Offset, idx.Created}
+	}
+	if err := scanner.Err(); err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+
+	s.labels = make([]string, 0, len(s.docs))
+	for lbl := range s.docs {
+		s.labels = append(s.labels, lbl)
+	}
+	slices.Sort(s.labels)
+	if db.header.Flags&flagFoldLabels != 0 {
+		s.folded = make(map[string]string, len(s.labels))
+		for _, lbl := range s.labels {
+			s.folded[foldCase(lbl)] = lbl
+		}
+	}
+	db.snapshots.Add(1)
+	return s, nil
+}
+
+// Close releases the snapshot's file handle. Further reads fail.
+func (s *Snapshot) Close() error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.reader == nil {
+		return nil
+	}
+	s.db.snapshots.Add(-1)
+	err := s.reader.Close()
+	s.reader = nil
+	return err
+}
+
+// Count returns the number of documents in the snapshot.
+func (s *Snapshot) Count() int { return len(s.docs) }
+
+// Exists reports whether label was a current document.
+func (s *Snapshot) Exists(label string) bool {
+	_, ok := s.find(label)
+	return ok
+}
+
+// find returns the document at label, matching labels as the file does.
+func (s *Snapshot) find(label string) (snapDoc, bool) {
+	if s.folded != nil {
+		label = s.folded[foldCase(label)]
+	}
+	d, ok := s.docs[label]
+	return d, ok
+}
+
+// Get returns the content label had when the snapshot was taken.
+func (s *Snapshot) Get(label string) (_ string, err error) {
+	defer s.db.observe(OpGet, label, time.Now(), &err)
+
+	d, ok := s.find(label)
+	if !ok {
+		return "", ErrNotFound
+	}
+	s.db.usage.reads.Add(1)
+	s.db.ops.gets.Add(1)
+	r, err := s.read(d.offset, nil)
+	if err != nil {
+		return "", fmt.Errorf("get: %s: %w", label, err)
+	}
+	return r.Data, nil
+}
+
+// List yields the snapshot's labels in sorted order.
+func (s *Snapshot) List() iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for _, lbl := range s.labels {
+			if !yield(lbl, nil) {
+				return
+			}
+		}
+	}
+}
+
+// All yields every document in the snapshot, in file order.
+func (s *Snapshot) All() iter.Seq2[Document, error] {
+	return func(yield func(Document, error) bool) {
+		for _, lbl := range s.ordered() {
+			r, err := s.read(s.docs[lbl].offset, nil)
+			if err != nil {
+				if !yield(Document{Label: lbl}, fmt.Errorf("all: %s: %w", lbl, err)) {
+					return
+				}
+				continue
+			}
+			if !yield(Document{Label: lbl, Data: r.Data}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Search matches pattern against the content of each document in the
+// snapshot, with the same options as DB.Search. Content is always
+// matched decoded, so opts.Decode has no effect, and binary documents
+// are skipped. Match.Offset is the record's offset in the file the
+// snapshot holds, which a compaction since may have replaced. With
+// opts.IncludeHistory, the versions of each document before its pinned
+// one are searched too, as Export finds them.
+func (s *Snapshot) Search(pattern string, opts SearchOptions) iter.Seq2[Match, error] {
+	return func(yield func(Match, error) bool) {
+		re, err := locator(pattern, opts)
+		if err != nil {
+			yield(Match{}, err)
+			return
+		}
+		scope, err := labelFilter(opts)
+		if err != nil {
+			yield(Match{}, err)
+			return
+		}
+		match := re.Match
+		if regexp.QuoteMeta(pattern) == pattern {
+			needle := []byte(pattern)
+			if opts.CaseSensitive {
+				match = func(c []byte) bool { return bytes.Contains(c, needle) }
+			} else {
+				lower := bytes.ToLower(needle)
+				match = func(c []byte) bool { return bytes.Contains(bytes.ToLower(c), lower) }
+			}
+		}
+
+		var offsets map[string][]int64
+		if opts.IncludeHistory {
+			wanted := map[string]int64{}
+			for _, lbl := range s.labels {
+				if scope == nil || scope(lbl) {
+					wanted[lbl] = s.docs[lbl].created
+				}
+			}
+			if offsets, err = s.revisions(wanted); err != nil {
+				yield(Match{}, fmt.Errorf("search: %w", err))
+				return
+			}
+		}
+
+		for _, lbl := range s.ordered() {
+			if scope != nil && !scope(lbl) {
+				continue
+			}
+			pinned := s.docs[lbl].offset
+			offs := offsets[lbl]
+			if len(offs) == 0 {
+				offs = []int64{pinned}
+			}
+			var prev *Record
+			for _, off := range offs {
+				r, err := s.read(off, prev)
+				prev = r
+				if err != nil {
+					if !yield(Match{Label: lbl, Offset: off}, fmt.Errorf("search: %s: %w", lbl, err)) {
+						return
+					}
+					continue
+				}
+				past := off != pinned
+				content := []byte(r.Data)
+				if r.Binary || past && !utf8.Valid(content) || !match(content) {
+					continue
+				}
+				m := Match{Label: lbl, Offset: off, Timestamp: r.Timestamp, History: past}
+				for _, m := range locate(m, content, re, opts) {
+					if !yield(m, nil) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// Export writes the snapshot's documents to w in the format of
+// DB.Export, with each document's history up to its pinned version.
+func (s *Snapshot) Export(w io.Writer, opts ExportOptions) (err error) {
+	defer s.db.observe(OpExport, "", time.Now(), &err)
+
+	var labels []string
+	for _, lbl := range s.labels {
+		if strings.HasPrefix(lbl, opts.Prefix) {
+			labels = append(labels, lbl)
+		}
+	}
+	created := make(map[string]int64, len(labels))
+	for _, lbl := range labels {
+		created[lbl] = s.docs[lbl].created
+	}
+
+	// One pass finds every version's offset. Records of a label never
+	// follow its pinned version except as later writes, which are left
+	// out; offsets are write order, as in revisions.
+	offsets := map[string][]int64{}
+	if !opts.CurrentOnly {
+		if offsets, err = s.revisions(created); err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+	}
+	history := func(lbl string) ([]Version, error) {
+		offs := offsets[lbl]
+		if len(offs) == 0 {
+			offs = []int64{s.docs[lbl].offset}
+		}
+		versions := make([]Version, 0, len(offs))
+		var prev *Record
+		for _, off := range offs {
+			r, err := s.read(off, prev)
+			if err != nil {
+				return nil, err
+			}
+			versions = append(versions, Version{r.Data, r.Timestamp})
+			prev = r
+		}
+		return versions, nil
+	}
+	return s.db.dump(w, labels, created, nil, history, opts)
+}
+
+// revisions returns the offsets of each wanted label's data and history
+// records up to and including its pinned version, in write order.
+func (s *Snapshot) revisions(wanted map[string]int64) (map[string][]int64, error) {
+	s.db.beginScan()
+	defer s.db.endScan()
+	if err := s.db.blockRead(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		s.db.mu.RUnlock()
+		s.db.lock.Unlock()
+	}()
+	if s.reader == nil {
+		return nil, ErrClosed
+	}
+
+	out := map[string][]int64{}
+	scanner := bufio.NewScanner(io.NewSectionReader(s.reader, HeaderSize, s.tail-HeaderSize))
+	scanner.Buffer(make([]byte, s.db.config.ReadBuffer), s.db.config.MaxRecordSize)
+	off := int64(HeaderSize)
+	for scanner.Scan() {
+		ln := scanner.Bytes()
+		at := off
+		off += int64(len(ln)) + 1
+		if !valid(ln) || len(ln) < MinRecordSize {
+			continue
+		}
+		if t := int(ln[TypePos] - '0'); t != TypeRecord && t != TypeHistory {
+			continue
+		}
+		lbl := label(ln)
+		if _, ok := wanted[lbl]; ok && at <= s.docs[lbl].offset {
+			out[lbl] = append(out[lbl], at)
+		}
+	}
+	return out, scanner.Err()
+}
+
+// ordered returns the labels sorted by record offset, so a walk over
+// every document reads the file front to back.
+func (s *Snapshot) ordered() []string {
+	labels := slices.Clone(s.labels)
+	slices.SortFunc(labels, func(a, b string) int {
+		return cmp.Compare(s.docs[a].offset, s.docs[b].offset)
+	})
+	return labels
+}
+
+// read returns the record at off with Data set to the content it held
+// when the snapshot was taken, whether or not it has been retired since.
+// prev is the record read before it among its document's versions, which
+// a delta is patched onto, or nil.
+func (s *Snapshot) read(off int64, prev *Record) (*Record, error) {
+	if err := s.db.blockRead(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		s.db.mu.RUnlock()
+		s.db.lock.Unlock()
+	}()
+	if s.reader == nil {
+		return nil, ErrClosed
+	}
+
+	data, err := line(s.reader, off)
+	if err != nil {
+		return nil, fmt.Errorf("read record: %w", err)
+	}
+	r, err := s.db.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if r.Type != TypeRecord {
+		var base []byte
+		if prev != nil {
+			base = []byte(prev.Data)
+		}
+		content, err := s.db.restore(r, base)
+		if err != nil {
+			return nil, fmt.Errorf("history: %w", err)
+		}
+		r.Data = string(content)
+	}
+	s.db.usage.bytesRead.Add(uint64(len(r.Data)))
+	return r, nil
+}
@@ -0,0 +1,144 @@
+// Snapshot tests.
+//
+// A snapshot is only useful if nothing that happens after it is taken
+// leaks into it. Each test writes through the DB after taking one —
+// updates, deletes, creates, compaction — and checks the snapshot still
+// answers with the state it pinned, through every read it offers.
+package folio
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestSnapshotIsolation verifies that writes and a compaction after the
+// snapshot is taken are invisible to Get, Exists, List, and All.
+func TestSnapshotIsolation(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("a", "a1")
+	db.Set("b", "b1")
+	db.Compact()
+	db.Set("c", "c1")
+
+	s, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	defer s.Close()
+
+	db.Set("a", "a2")
+	db.Delete("b")
+	db.Set("d", "d1")
+	db.Set("c", "c2")
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact with open snapshot: %v", err)
+	}
+	db.Set("a", "a3")
+
+	want := map[string]string{"a": "a1", "b": "b1", "c": "c1"}
+	for lbl, data := range want {
+		if got, err := s.Get(lbl); err != nil || got != data {
+			t.Errorf("Get %s = %q, %v; want %q", lbl, got, err, data)
+		}
+	}
+	if _, err := s.Get("d"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get d: got %v, want ErrNotFound", err)
+	}
+	if s.Exists("d") || !s.Exists("b") || s.Count() != 3 {
+		t.Errorf("Exists d %v, b %v, Count %d", s.Exists("d"), s.Exists("b"), s.Count())
+	}
+	if labels, _ := Collect(s.List()); !slices.Equal(labels, []string{"a", "b", "c"}) {
+		t.Errorf("List = %v", labels)
+	}
+	got := map[string]string{}
+	for doc, err := range s.All() {
+		if err != nil {
+			t.Fatalf("All: %v", err)
+		}
+		got[doc.Label] = doc.Data
+	}
+	if len(got) != len(want) {
+		t.Errorf("All = %v, want %v", got, want)
+	}
+	for lbl, data := range want {
+		if got[lbl] != data {
+			t.Errorf("All %s = %q, want %q", lbl, got[lbl], data)
+		}
+	}
+
+	if got, _ := db.Get("a"); got != "a3" {
+		t.Errorf("db.Get a = %q, want a3", got)
+	}
+}
+
+// TestSnapshotSearchExport verifies Search and Export see the pinned
+// content and history, not what was written afterwards.
+func TestSnapshotSearchExport(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "first Needle")
+	db.Set("doc", "second needle")
+	db.Set("other", "hay")
+
+	s, err := db.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db.Set("doc", "third")
+	db.Set("other", "needle now")
+
+	var labels []string
+	for m, err := range s.Search("needle", SearchOptions{}) {
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		labels = append(labels, m.Label)
+	}
+	if !slices.Equal(labels, []string{"doc"}) {
+		t.Errorf("Search = %v, want [doc]", labels)
+	}
+	if _, err := Collect(s.Search("[", SearchOptions{})); !errors.Is(err, ErrInvalidPattern) {
+		t.Errorf("Search bad pattern: got %v, want ErrInvalidPattern", err)
+	}
+
+	var buf bytes.Buffer
+	if err := s.Export(&buf, ExportOptions{}); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	dump := buf.String()
+	if !strings.Contains(dump, "first Needle") || !strings.Contains(dump, "second needle") || strings.Contains(dump, "third") || strings.Contains(dump, "needle now") {
+		t.Errorf("Export = %s", dump)
+	}
+
+	buf.Reset()
+	if err := s.Export(&buf, ExportOptions{CurrentOnly: true}); err != nil {
+		t.Fatalf("Export CurrentOnly: %v", err)
+	}
+	if strings.Contains(buf.String(), "first") || !strings.Contains(buf.String(), "second needle") {
+		t.Errorf("Export CurrentOnly = %s", buf.String())
+	}
+}
+
+// TestSnapshotClose verifies a closed snapshot refuses reads rather
+// than reading from a closed handle.
+func TestSnapshotClose(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "content")
+
+	s, err := db.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := s.Get("doc"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Get after Close: got %v, want ErrClosed", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+// Optional in-memory map of the sparse region's index lines.
+//
+// Without it, a lookup that misses the sorted index section parses every
+// line of the sparse region, since nothing there is in order. When
+// enabled (Config.SparseMap), the map holds the offset of the newest
+// index line for each label in the sparse region, so a lookup reads one
+// line instead. It is built at Open and extended as indexes are
+// appended; before each lookup, any bytes past the point it has covered
+// are scanned with scanm, so appends from other paths and other
+// processes are picked up too. Retirement blanks index lines in place
+// rather than appending, so every hit is read back and checked: a line
+// that is no longer an index for the label means the document is gone.
+// Compaction empties the sparse region, and the map with it; Seal moves
+// the lines it sorts, and empties the map too.
+//
+// The one change the map cannot see is a same-length Rename made by
+// another process, which patches the label in place without growing the
+// file. A lookup of the new name misses until the next compaction.
+package folio
+
+import "sync"
+
+// sparseMap maps labels, folded in a case-insensitive file, to the offset of their newest sparse index line.
+type sparseMap struct {
+	mu      sync.Mutex
+	start   int64 // sparse region start the map was built from
+	end     int64 // offsets below end have been indexed
+	offsets map[string]int64
+}
+
+func newSparseMap() *sparseMap {
+	return &sparseMap{offsets: map[string]int64{}}
+}
+
+// reset empties the map. Called after compaction replaces the file.
+func (m *sparseMap) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.start, m.end = 0, 0
+	clear(m.offsets)
+}
+
+// add records an index appended at off, ending at end, if it directly
+// follows what the map has covered. Otherwise the next lookup scans it.
+func (m *sparseMap) add(label string, off, end int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.end == off && m.start != 0 {
+		m.offsets[label] = off
+		m.end = end
+	}
+}
+
+// rename moves the entry for old to new after an in-place rename
+// patched the index line at off.
+func (m *sparseMap) rename(old, new string, off int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if o, ok := m.offsets[old]; ok && o == off {
+		delete(m.offsets, old)
+		m.offsets[new] = off
+	}
+}
+
+// sparseOffset returns the offset of label's newest sparse index line,
+// first indexing whatever lies between the covered point and sz. The
+// caller must hold db.mu.
+func (db *DB) sparseOffset(label string, sz int64) (int64, bool) {
+	m := db.smap
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.extend(db, sz)
+	off, ok := m.offsets[db.fold(label)]
+	return off, ok
+}
+
+// extend indexes the sparse region up to sz, starting over if a
+// compaction has moved it. The caller holds m.mu.
+func (m *sparseMap) extend(db *DB, sz int64) {
+	if start := db.sparseStart(); m.start != start {
+		m.start, m.end = start, start
+		clear(m.offsets)
+	}
+	if sz > m.end {
+		for _, e := range scanm(db.reader, m.end, sz, TypeIndex) {
+			// scanm leaves the label JSON-escaped.
+			m.offsets[db.fold(string(unescape([]byte(e.Label))))] = e.SrcOff
+		}
+		m.end = sz
+	}
+}
+
+// newest returns the newest live index for label in the sparse region,
+// or a nil Result if there is none. It uses the sparse map if enabled,
+// and otherwise scans the unsealed tail backwards, then binary searches
+// each sealed segment, newest first (see segment.go). The caller must
+// hold db.mu.
+func (db *DB) newest(id, label string, sz int64) (*Result, *Index, error) {
+	if db.smap != nil {
+		off, ok := db.sparseOffset(label, sz)
+		if !ok {
+			return nil, nil, nil
+		}
+		data, err := line(db.reader, off)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !valid(data) || len(data) < MinRecordSize || data[TypePos] != byte('0'+TypeIndex) {
+			return nil, nil, nil // blanked since: retired or deleted
+		}
+		idx, err := decodeIndex(data)
+		if err != nil {
+			return nil, nil, faultAt("", off, err)
+		}
+		if !db.same(idx.Label, label) {
+			return nil, nil, nil
+		}
+		return &Result{off, len(data), data, idx.ID}, idx, nil
+	}
+
+	f := db.borrow()
+	defer db.giveBack(f)
+	results := sparse(f, id, db.unsealed(), sz, TypeIndex)
+	for i := len(results) - 1; i >= 0; i-- {
+		idx, err := decodeIndex(results[i].Data)
+		if err != nil {
+			return nil, nil, faultAt("", results[i].Offset, err)
+		}
+		if db.same(idx.Label, label) {
+			r := results[i]
+			return &r, idx, nil
+		}
+	}
+	segs := db.segments()
+	for i := len(segs) - 1; i >= 0; i-- {
+		results := group(f, id, segs[i].Index, segs[i].End)
+		for j := len(results) - 1; j >= 0; j-- {
+			idx, err := decodeIndex(results[j].Data)
+			if err != nil {
+				return nil, nil, faultAt("", results[j].Offset, err)
+			}
+			if db.same(idx.Label, label) {
+				r := results[j]
+				return &r, idx, nil
+			}
+		}
+	}
+	return nil, nil, nil
+}
@@ -0,0 +1,114 @@
+// Sparse map tests.
+//
+// The sparse map replaces a scan with one read, so it must give the
+// answer the scan would have: after overwrites, deletes, renames of
+// either kind, compaction, and writes made through another handle that
+// the map never saw.
+package folio
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// TestSparseMapLookups verifies Get, Exists, and GetMany through the
+// map after every kind of write that moves or erases an index.
+func TestSparseMapLookups(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{SparseMap: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, lbl := range []string{"a", "b", "c", "d"} {
+		if err := db.Set(lbl, lbl+"1"); err != nil {
+			t.Fatalf("Set(%s): %v", lbl, err)
+		}
+	}
+	if err := db.Set("a", "a2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Rename("c", "e"); err != nil { // same length: patched in place
+		t.Fatal(err)
+	}
+	if err := db.Rename("d", "dd"); err != nil { // appended
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"a": "a2", "e": "c1", "dd": "d1"}
+	check := func(when string) {
+		t.Helper()
+		for lbl, content := range want {
+			if got, err := db.Get(lbl); err != nil || got != content {
+				t.Errorf("%s: Get(%s) = %q, %v; want %q", when, lbl, got, err, content)
+			}
+		}
+		for _, lbl := range []string{"b", "c", "d"} {
+			if _, err := db.Get(lbl); !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: Get(%s) err = %v, want ErrNotFound", when, lbl, err)
+			}
+			if ok, err := db.Exists(lbl); ok || err != nil {
+				t.Errorf("%s: Exists(%s) = %v, %v", when, lbl, ok, err)
+			}
+		}
+		got, err := db.GetMany("a", "b", "e", "dd")
+		if err != nil || len(got) != 3 || got["e"] != "c1" {
+			t.Errorf("%s: GetMany = %v, %v", when, got, err)
+		}
+	}
+	check("sparse")
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check("compacted")
+	if err := db.Set("f", "f1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.Get("f"); err != nil || got != "f1" {
+		t.Errorf("Get(f) after compact = %q, %v", got, err)
+	}
+}
+
+// TestSparseMapOtherHandle verifies that appends and deletes made
+// through another handle on the same file are seen: the map catches up
+// on bytes it has not covered, and checks every hit against the file.
+func TestSparseMapOtherHandle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{SparseMap: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	other, err := Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	if err := other.Set("x", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.Get("x"); err != nil || got != "1" {
+		t.Fatalf("Get(x) = %q, %v; want appended value", got, err)
+	}
+	// Scanned labels are JSON-escaped on disk; the map must key them
+	// as callers write them.
+	escaped := `dir\file<1>`
+	if err := other.Set(escaped, "2"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.Get(escaped); err != nil || got != "2" {
+		t.Errorf("Get(%s) = %q, %v", escaped, got, err)
+	}
+	if err := other.Delete("x"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(x) after delete err = %v, want ErrNotFound", err)
+	}
+}
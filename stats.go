@@ -0,0 +1,283 @@
+// Database statistics for monitoring and maintenance decisions.
+//
+// Stats is cheap enough to poll: it reads counters and the cached header
+// under the read lock and performs no I/O. Figures that can only be had
+// by reading the file, such as how many history versions it holds and
+// how much of it is blanked, come from Space, which scans it once.
+// CompactEstimate reads the same metadata a rebuild sorts and reports
+// what Compact and Purge would reclaim, for deciding whether to run one.
+package folio
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"sync/atomic"
+
+	json "github.com/goccy/go-json"
+)
+
+// Stats reports counters and section sizes for the database.
+type Stats struct {
+	// Usage is cumulative across sessions when Config.PersistUsage is
+	// set, otherwise it covers the current session only.
+	Usage Usage
+
+	// Documents written, read, and deleted since Open. Unlike Usage
+	// these are never persisted. Every document in a Batch or Txn counts.
+	Gets, Sets, Deletes uint64
+
+	FileSize    int64 // bytes, header included
+	HeapBytes   int64 // sorted heap from the last compaction
+	IndexBytes  int64 // sorted index section from the last compaction
+	TagBytes    int64 // sorted tag section from the last compaction
+	SparseBytes int64 // everything appended since the last compaction
+	SealedBytes int64 // the part of SparseBytes sorted into segments by Seal
+	Segments    int   // sealed segments in the sparse region
+	Documents   int   // as Count
+
+	// Version is the file's format version, below FormatVersion until
+	// Migrate or a rebuild upgrades it. Generation counts the rebuilds
+	// the file has been through, so offsets taken from it are stale once
+	// it changes.
+	Version    int
+	Generation uint64
+
+	// BloomFalsePositive estimates the chance that a lookup for an ID
+	// absent from the sparse region still scans it. 0 without
+	// Config.BloomFilter.
+	BloomFalsePositive float64
+
+	// IndexBloomFalsePositive estimates the chance that a lookup for an
+	// ID absent from the sorted index still binary searches it. 0
+	// without Config.IndexBloom.
+	IndexBloomFalsePositive float64
+}
+
+// ops counts document operations for the current session.
+type ops struct {
+	gets, sets, deletes atomic.Uint64
+}
+
+// Stats returns the current counters. It performs no I/O.
+func (db *DB) Stats() Stats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var base Usage
+	if db.config.PersistUsage && db.meta != nil && db.meta.Usage != nil {
+		base = *db.meta.Usage
+	}
+	s := Stats{
+		Usage:       db.usage.add(base),
+		Gets:        db.ops.gets.Load(),
+		Sets:        db.ops.sets.Load(),
+		Deletes:     db.ops.deletes.Load(),
+		FileSize:    db.tail,
+		TagBytes:    db.tagEnd() - db.sparseStart(),
+		SparseBytes: db.tail - db.tagEnd(),
+		SealedBytes: db.unsealed() - db.tagEnd(),
+		Segments:    len(db.segments()),
+		Documents:   db.Count(),
+		Version:     db.header.Version,
+		Generation:  db.header.Generation,
+	}
+	if heap := db.heapEnd(); heap > 0 {
+		s.HeapBytes = heap - HeaderSize
+		s.IndexBytes = db.indexEnd() - heap
+	}
+	if db.bloom != nil {
+		s.BloomFalsePositive = db.bloom.FalsePositive()
+	}
+	if db.ibloom != nil {
+		s.IndexBloomFalsePositive = db.ibloom.FalsePositive()
+	}
+	return s
+}
+
+// Space reports how the file's bytes are used.
+type Space struct {
+	Records  int // current data records
+	Versions int // history records: superseded and deleted versions
+	Indexes  int // live index lines
+
+	// BlankedBytes counts bytes overwritten with spaces: erased index
+	// and metadata lines, and the blanked content of retired versions.
+	// Compaction reclaims the lines; a version's blanked content goes
+	// only when the version itself does (Purge, Config.HistoryRetention).
+	BlankedBytes int64
+	BlankRatio   float64 // BlankedBytes over the file size
+}
+
+// Space scans the whole file and reports how it is used. Like a scan it
+// counts toward Config.MaxConcurrentScans.
+func (db *DB) Space() (Space, error) {
+	db.beginScan()
+	defer db.endScan()
+	if err := db.blockRead(); err != nil {
+		return Space{}, err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+
+	var s Space
+	scanner := bufio.NewScanner(io.NewSectionReader(db.reader, HeaderSize, db.tail-HeaderSize))
+	scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
+	for scanner.Scan() {
+		ln := scanner.Bytes()
+		if !valid(ln) || len(ln) < MinRecordSize {
+			s.BlankedBytes += int64(len(ln) - len(bytes.TrimLeft(ln, " ")))
+			continue
+		}
+		switch int(ln[TypePos] - '0') {
+		case TypeIndex:
+			s.Indexes++
+		case TypeRecord:
+			s.Records++
+		case TypeHistory:
+			s.Versions++
+			s.BlankedBytes += blanked(ln)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Space{}, fmt.Errorf("space: %w", err)
+	}
+	if db.tail > 0 {
+		s.BlankRatio = float64(s.BlankedBytes) / float64(db.tail)
+	}
+	return s, nil
+}
+
+// CompactEstimate reports what a rebuild would reclaim from the file as
+// it stands. The figures are within a few bytes per document: rewritten
+// index lines gain or lose digits with their new offsets, and history
+// re-chunked under Config.DeltaHistory is counted as it is now.
+type CompactEstimate struct {
+	FileSize int64 // bytes now, header included
+
+	// Blanked counts records retired in place by a later write or a
+	// delete, which only a rebuild moves out of the way. HistoryBytes is
+	// the size of those Compact would keep, after expiry and
+	// Config.HistoryRetention, and Purge would not, tombstones included
+	// (see journal.go).
+	Blanked      int
+	HistoryBytes int64
+
+	Compact int64 // bytes Compact would reclaim
+	Purge   int64 // bytes Purge would reclaim: Compact's and HistoryBytes
+}
+
+// CompactEstimate reads the metadata of every line, as compaction does,
+// and reports what Compact and Purge would reclaim without rewriting
+// anything, so the I/O of a rebuild can be weighed against its gain.
+// Like a scan it counts toward Config.MaxConcurrentScans.
+func (db *DB) CompactEstimate() (CompactEstimate, error) {
+	db.beginScan()
+	defer db.endScan()
+	if err := db.blockRead(); err != nil {
+		return CompactEstimate{}, err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+
+	var e CompactEstimate
+	entries := scanm(db.reader, HeaderSize, db.tail, 0)
+	heap, indexes := unpack(entries, TypeMeta, TypeTxn, TypeTag, TypeTombstone, TypeTable)
+
+	// One index per label survives, and none for an expired document,
+	// whose versions go with it.
+	t := now()
+	live := map[string]Entry{}
+	for _, idx := range indexes {
+		live[idx.Label] = idx
+	}
+	expired := map[string]bool{}
+	kept := int64(HeaderSize)
+	for lbl, idx := range live {
+		if idx.Expires != 0 && idx.Expires <= t {
+			expired[idx.ID] = true
+			delete(live, lbl)
+			continue
+		}
+		kept += int64(idx.Length) + 1
+	}
+
+	for _, h := range heap {
+		if h.Type == TypeHistory {
+			e.Blanked++
+		}
+	}
+	slices.SortFunc(heap, byIDThenTS)
+	for _, h := range retain(heap, db.config.HistoryRetention, t) {
+		if expired[h.ID] {
+			continue
+		}
+		kept += int64(h.Length) + 1
+		if h.Type == TypeHistory {
+			e.HistoryBytes += int64(h.Length) + 1
+		}
+	}
+
+	// With Config.HashTable, the table follows the indexes.
+	var table int64
+	if db.config.HashTable && len(live) > 0 {
+		table = kept
+		kept += int64(tableSize(len(live))/tableSlots) * int64(tableLine+1)
+	}
+
+	// Live tag records and tombstones are rewritten after the indexes,
+	// then the metadata record as rebuild writes it. Purge drops the
+	// tombstones with the history.
+	tags := false
+	for _, m := range entries {
+		switch m.Type {
+		case TypeTag:
+			kept += int64(m.Length) + 1
+			tags = true
+		case TypeTombstone:
+			kept += int64(m.Length) + 1
+			e.HistoryBytes += int64(m.Length) + 1
+			tags = true
+		}
+	}
+	m := Meta{Type: TypeMeta, ID: metaID}
+	if db.meta != nil {
+		m = *db.meta
+	}
+	m.Tags = 0
+	if tags {
+		m.Tags = kept
+	}
+	m.Table = table
+	m.Segments = nil
+	if m.Usage != nil || m.Tags != 0 || m.Table != 0 || len(m.Dicts) > 0 {
+		m.Timestamp = t
+		data, err := json.Marshal(m)
+		if err != nil {
+			return CompactEstimate{}, fmt.Errorf("compact estimate: %w", err)
+		}
+		kept += int64(len(data)) + 1
+	}
+
+	e.FileSize = db.tail
+	e.Compact = max(db.tail-kept, 0)
+	e.Purge = e.Compact + e.HistoryBytes
+	return e, nil
+}
+
+// blanked returns the length of a retired record's blanked _d value.
+func blanked(line []byte) int64 {
+	marker := []byte(`"_d":"`)
+	i := bytes.Index(line, marker)
+	if i < 0 {
+		return 0
+	}
+	d := line[i+len(marker):]
+	return int64(len(d) - len(bytes.TrimLeft(d, " ")))
+}
@@ -0,0 +1,155 @@
+// Statistics tests.
+//
+// Monitoring and compaction decisions are made from these numbers, so
+// each is checked against a file whose shape is known: section sizes
+// before and after compaction, operation counts, and how Space splits
+// the file into live records, history, and blanked bytes.
+package folio
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestStatsSections verifies section sizes add up to the file, and that
+// compaction moves everything out of the sparse region.
+func TestStatsSections(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("a", "1")
+	db.Set("b", "2")
+
+	s := db.Stats()
+	if s.HeapBytes != 0 || s.IndexBytes != 0 || s.SparseBytes != s.FileSize-HeaderSize {
+		t.Errorf("before Compact = %+v", s)
+	}
+	if s.Documents != 2 {
+		t.Errorf("Documents = %d, want 2", s.Documents)
+	}
+
+	db.Compact()
+	s = db.Stats()
+	if s.SparseBytes != 0 || s.HeapBytes == 0 || s.IndexBytes == 0 {
+		t.Errorf("after Compact = %+v", s)
+	}
+	if HeaderSize+s.HeapBytes+s.IndexBytes+s.SparseBytes != s.FileSize {
+		t.Errorf("sections %+v do not add up to FileSize", s)
+	}
+	if s.FileSize != dbsize(t, db) {
+		t.Errorf("FileSize = %d, want %d", s.FileSize, dbsize(t, db))
+	}
+}
+
+// TestStatsOps verifies Get, Set, and Delete are counted per document,
+// and that misses still count as Gets.
+func TestStatsOps(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("a", "1")
+	db.Batch(Document{Label: "b", Data: "2"}, Document{Label: "c", Data: "3"})
+	db.Get("a")
+	db.Get("missing")
+	db.Delete("b")
+
+	s := db.Stats()
+	if s.Sets != 3 || s.Gets != 2 || s.Deletes != 1 {
+		t.Errorf("Sets, Gets, Deletes = %d, %d, %d; want 3, 2, 1", s.Sets, s.Gets, s.Deletes)
+	}
+}
+
+// TestStatsBloom verifies the false positive estimate is reported only
+// with a filter and stays small for a lightly filled one.
+func TestStatsBloom(t *testing.T) {
+	if fp := openTestDB(t).Stats().BloomFalsePositive; fp != 0 {
+		t.Errorf("without filter = %v, want 0", fp)
+	}
+
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{BloomFilter: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := range 100 {
+		db.Set(fmt.Sprintf("doc-%d", i), "x")
+	}
+	if fp := db.Stats().BloomFalsePositive; fp <= 0 || fp > 0.01 {
+		t.Errorf("BloomFalsePositive = %v, want (0, 0.01]", fp)
+	}
+}
+
+// TestSpace verifies record, version, and index counts, and that the
+// bytes blanked by an update are found. Compaction reclaims the erased
+// index line but keeps the retired version with its blanked content.
+func TestSpace(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("a", "first version")
+	db.Set("a", "second")
+	db.Set("b", "other")
+
+	s, err := db.Space()
+	if err != nil {
+		t.Fatalf("Space: %v", err)
+	}
+	if s.Records != 2 || s.Versions != 1 || s.Indexes != 2 {
+		t.Errorf("Space = %+v", s)
+	}
+	// The retired index line and "first version" are blanked.
+	if s.BlankedBytes <= int64(len("first version")) || s.BlankRatio <= 0 {
+		t.Errorf("BlankedBytes = %d, BlankRatio = %v", s.BlankedBytes, s.BlankRatio)
+	}
+
+	db.Compact()
+	s, _ = db.Space()
+	if s.Versions != 1 || s.BlankedBytes != int64(len("first version")) {
+		t.Errorf("after Compact = %+v", s)
+	}
+}
+
+// TestCompactEstimate verifies that the estimate matches, within a few
+// bytes per document, what Compact and Purge then reclaim, and that it
+// writes nothing.
+func TestCompactEstimate(t *testing.T) {
+	db := openTestDB(t)
+	for i := range 20 {
+		db.Set("doc", fmt.Sprintf("version %d of the document", i))
+	}
+	db.Set("gone", "deleted")
+	db.Delete("gone")
+	db.Set("kept", "content")
+	db.Tag("kept", "t")
+	db.SetWithTTL("expired", "content", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	near := func(what string, got, want int64) {
+		t.Helper()
+		if got < want-16 || got > want+16 {
+			t.Errorf("%s estimate = %d, reclaimed %d", what, got, want)
+		}
+	}
+
+	before := db.tail
+	e, err := db.CompactEstimate()
+	if err != nil {
+		t.Fatalf("CompactEstimate: %v", err)
+	}
+	if db.tail != before || e.FileSize != before {
+		t.Errorf("FileSize = %d, file %d -> %d", e.FileSize, before, db.tail)
+	}
+	if e.Blanked != 20 || e.HistoryBytes <= 0 || e.Purge != e.Compact+e.HistoryBytes {
+		t.Errorf("estimate = %+v", e)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	near("Compact", e.Compact, before-db.tail)
+
+	before = db.tail
+	e, _ = db.CompactEstimate()
+	if e.Compact > 16 {
+		t.Errorf("Compact estimate after compaction = %d", e.Compact)
+	}
+	if err := db.Purge(); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	near("Purge", e.Purge, before-db.tail)
+}
@@ -0,0 +1,542 @@
+// Streaming reads and writes of large documents.
+//
+// Get reads the whole record line and then builds the decoded string, so
+// a 16MB document briefly costs twice that in memory. GetReader instead
+// decodes _d straight from the file: a small buffered reader walks the
+// record, resolving JSON escapes (or base64, for binary content) as the
+// caller reads. The CRC-32C in _k is accumulated on the way and checked
+// when the content ends, so a damaged record surfaces as an error from
+// the final Read rather than as silently wrong bytes.
+//
+// The flags that say how _d is encoded (_b, _x) and its checksum (_k)
+// follow _h at the end of the line, so GetReader first finds the line's
+// end and reads just its tail. Encrypted content cannot be streamed —
+// the AEAD tag authenticates the value as a whole — so it is decrypted
+// into memory and served from there.
+//
+// SetReader is the write-side counterpart. It writes the record line at
+// the tail as content arrives: each chunk is JSON-escaped into _d, fed to
+// a streaming Zstd encoder for _h, and added to the running checksum.
+// Only the compressed snapshot is held in memory, and _h, _k, and the
+// index follow once the content ends. Chunks are cut on rune boundaries
+// so escaping matches Set byte for byte. If the content turns out not to
+// be valid UTF-8, _d is rewritten as base64 from the compressed copy and
+// streaming continues in that form, as SetBytes would have stored it.
+// Any failure truncates the file back to where the record began.
+package folio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	json "github.com/goccy/go-json"
+	"github.com/klauspost/compress/zstd"
+)
+
+// tailSize covers the fields after _h: ","_k":4294967295,"_b":true,"_x":true}.
+const tailSize = 64
+
+// GetReader returns a reader over the current content of label. The read
+// lock is held until the reader is closed, so writers wait for it: always
+// Close the reader, and do not write to db while it is open.
+func (db *DB) GetReader(label string) (_ io.ReadCloser, err error) {
+	defer db.observe(OpGet, label, time.Now(), &err)
+
+	if err := db.blockRead(); err != nil {
+		return nil, err
+	}
+	release := func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}
+	db.usage.reads.Add(1)
+	db.ops.gets.Add(1)
+
+	r, err := db.stream(label)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &docReader{r: r, db: db, release: release}, nil
+}
+
+// stream opens the decoding reader for label. The read lock must be held.
+func (db *DB) stream(label string) (io.Reader, error) {
+	idx, err := db.current(label)
+	if err != nil {
+		return nil, err
+	}
+
+	nl, err := align(db.reader, idx.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("get: read record: %w", err)
+	}
+	if nl < 0 {
+		return nil, fmt.Errorf("get: read record: %w", io.ErrUnexpectedEOF)
+	}
+	tail := make([]byte, min(tailSize, nl-idx.Offset))
+	if _, err := db.reader.ReadAt(tail, nl-int64(len(tail))); err != nil {
+		return nil, fmt.Errorf("get: read record: %w", err)
+	}
+
+	if encrypted(tail) || shared(tail) {
+		data, err := line(db.reader, idx.Offset)
+		if err != nil {
+			return nil, fmt.Errorf("get: read record: %w", faultAt(label, idx.Offset, err))
+		}
+		record, err := db.decode(data)
+		if err != nil {
+			return nil, fmt.Errorf("get: %w", faultAt(label, idx.Offset, err))
+		}
+		return bytes.NewReader([]byte(record.Data)), nil
+	}
+
+	src := bufio.NewReaderSize(io.NewSectionReader(db.reader, idx.Offset, nl-idx.Offset), db.config.ReadBuffer)
+	if err := skipTo(src, []byte(`"_d":"`)); err != nil {
+		return nil, fmt.Errorf("get: %w", faultAt(label, idx.Offset, ErrCorruptRecord))
+	}
+	var r io.Reader = &unescaper{src: src}
+	if binary(tail) {
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	}
+	if want := sum(tail); want != 0 {
+		r = &verifier{r: r, want: want}
+	}
+	return r, nil
+}
+
+// skipTo advances src past the first occurrence of marker.
+func skipTo(src *bufio.Reader, marker []byte) error {
+	matched := 0
+	for matched < len(marker) {
+		b, err := src.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case b == marker[matched]:
+			matched++
+		case b == marker[0]:
+			matched = 1
+		default:
+			matched = 0
+		}
+	}
+	return nil
+}
+
+// docReader releases the read lock on Close and counts bytes read.
+type docReader struct {
+	r       io.Reader
+	db      *DB
+	release func()
+}
+
+func (d *docReader) Read(p []byte) (int, error) {
+	if d.release == nil {
+		return 0, ErrClosed
+	}
+	n, err := d.r.Read(p)
+	d.db.usage.bytesRead.Add(uint64(n))
+	return n, err
+}
+
+// Close releases the read lock. Closing twice is a no-op.
+func (d *docReader) Close() error {
+	if d.release != nil {
+		d.release()
+		d.release = nil
+	}
+	return nil
+}
+
+// unescaper decodes a JSON string body from src, stopping at its closing
+// quote. Escapes split across reads are handled because src is consumed
+// one escape sequence at a time.
+type unescaper struct {
+	src     *bufio.Reader
+	pending []byte // decoded bytes not yet returned
+	done    bool
+}
+
+func (u *unescaper) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(u.pending) > 0 {
+			c := copy(p[n:], u.pending)
+			u.pending = u.pending[c:]
+			n += c
+			continue
+		}
+		if u.done {
+			break
+		}
+		b, err := u.src.ReadByte()
+		if err != nil {
+			return n, fmt.Errorf("%w: unterminated content", ErrCorruptRecord)
+		}
+		switch b {
+		case '"':
+			u.done = true
+		case '\\':
+			if err := u.escape(); err != nil {
+				return n, err
+			}
+		default:
+			p[n] = b
+			n++
+		}
+	}
+	if n == 0 && u.done {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// escape decodes the sequence after a backslash into pending.
+func (u *unescaper) escape() error {
+	c, err := u.src.ReadByte()
+	if err != nil {
+		return fmt.Errorf("%w: truncated escape", ErrCorruptRecord)
+	}
+	switch c {
+	case '"', '\\', '/':
+		u.pending = append(u.pending[:0], c)
+	case 'n':
+		u.pending = append(u.pending[:0], '\n')
+	case 'r':
+		u.pending = append(u.pending[:0], '\r')
+	case 't':
+		u.pending = append(u.pending[:0], '\t')
+	case 'b':
+		u.pending = append(u.pending[:0], '\b')
+	case 'f':
+		u.pending = append(u.pending[:0], '\f')
+	case 'u':
+		r, err := u.hex()
+		if err != nil {
+			return err
+		}
+		if utf16.IsSurrogate(r) {
+			// A surrogate pair arrives as two consecutive \u escapes.
+			if next, err := u.src.Peek(2); err == nil && next[0] == '\\' && next[1] == 'u' {
+				u.src.Discard(2)
+				lo, err := u.hex()
+				if err != nil {
+					return err
+				}
+				r = utf16.DecodeRune(r, lo)
+			} else {
+				r = utf8.RuneError
+			}
+		}
+		u.pending = utf8.AppendRune(u.pending[:0], r)
+	default:
+		return fmt.Errorf("%w: invalid escape \\%c", ErrCorruptRecord, c)
+	}
+	return nil
+}
+
+// hex reads the four hex digits of a \u escape.
+func (u *unescaper) hex() (rune, error) {
+	var r rune
+	for range 4 {
+		c, err := u.src.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("%w: truncated escape", ErrCorruptRecord)
+		}
+		switch {
+		case c >= '0' && c <= '9':
+			r = r<<4 | rune(c-'0')
+		case c >= 'a' && c <= 'f':
+			r = r<<4 | rune(c-'a'+10)
+		case c >= 'A' && c <= 'F':
+			r = r<<4 | rune(c-'A'+10)
+		default:
+			return 0, fmt.Errorf("%w: invalid escape", ErrCorruptRecord)
+		}
+	}
+	return r, nil
+}
+
+// verifier checks the CRC-32C of everything read once r is exhausted.
+type verifier struct {
+	r    io.Reader
+	crc  uint32
+	want uint32
+}
+
+func (v *verifier) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.crc = crc32.Update(v.crc, castagnoli, p[:n])
+	if errors.Is(err, io.EOF) && v.crc != v.want {
+		return n, fmt.Errorf("%w: %w", ErrCorruptRecord, ErrChecksum)
+	}
+	return n, err
+}
+
+// SetReader creates or updates a document with content read from r until
+// EOF. The write lock is held while r is read, so r should not block on
+// other work against db. With Config.EncryptionKey set, a codec other
+// than Zstd, a set hook in Config.Hooks, or Config.Validator, the
+// content is read into memory first, as sealing, compressing, or
+// passing it to a hook or validator needs it whole.
+func (db *DB) SetReader(label string, r io.Reader) (err error) {
+	defer db.observe(OpSet, label, time.Now(), &err)
+
+	if err := db.checkLabel(label); err != nil {
+		return err
+	}
+
+	if err := db.blockWrite(); err != nil {
+		return err
+	}
+
+	if db.cipher != nil || db.codec.kind != CodecZstd || db.config.Hooks.sets() || db.config.Validator != nil {
+		err = db.setBuffered(label, r)
+	} else {
+		err = db.setStream(label, r)
+	}
+
+	// Same pattern as Set: check threshold under lock, compact after release.
+	compact := err == nil && db.shouldCompact()
+	events := db.takeEvents()
+	db.mu.Unlock()
+	db.lock.Unlock()
+
+	if err == nil {
+		err = db.durable()
+	}
+	db.after(events)
+	if compact {
+		db.Compact()
+	}
+	return err
+}
+
+// setBuffered reads all of r and writes it with setOne.
+func (db *DB) setBuffered(label string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("set: read: %w", err)
+	}
+	if len(data) == 0 {
+		return ErrEmptyContent
+	}
+	if err := db.validate(label, string(data)); err != nil {
+		return err
+	}
+	return db.setOne(label, string(data), 0)
+}
+
+// setStream writes the record for r directly at the tail. The write
+// lock must be held.
+func (db *DB) setStream(label string, r io.Reader) error {
+	id := db.id(label)
+	sz, err := size(db.reader)
+	if err != nil {
+		return fmt.Errorf("set: stat: %w", err)
+	}
+	prev, idx, err := db.findIndex(id, label, sz)
+	if err != nil {
+		return fmt.Errorf("set: %w", err)
+	}
+	label = stored(label, idx, now())
+	if err := db.versionRoom(id, label, sz); err != nil {
+		return fmt.Errorf("set: %w", err)
+	}
+
+	db.markDirty()
+	start := db.tail
+	w := &tailWriter{db: db, off: start}
+	end, err := db.streamRecord(w, id, label, idx, r)
+	if err != nil {
+		db.writer.Truncate(start)
+		return err
+	}
+	if w.high > end {
+		if err := db.writer.Truncate(end); err != nil {
+			return fmt.Errorf("set: %w", err)
+		}
+	}
+	if err := db.sync(); err != nil {
+		return fmt.Errorf("set: %w", err)
+	}
+	db.header.State[stWrites]++
+	db.usage.bytesWritten.Add(uint64(end - start))
+	db.tail = end
+
+	if err := db.supersede(id, label, 0, prev, idx); err != nil {
+		return fmt.Errorf("set: %w", err)
+	}
+	return nil
+}
+
+// streamRecord writes the data record and index for r through w and
+// returns the offset just past them.
+func (db *DB) streamRecord(w *tailWriter, id, label string, idx *Index, r io.Reader) (int64, error) {
+	ts := now()
+	start := w.off
+	lbl, err := json.Marshal(label)
+	if err != nil {
+		return 0, fmt.Errorf("set: %w", err)
+	}
+	prefix := fmt.Appendf(nil, `{"_r":%d,"_id":"%s","_ts":%d,"_l":%s,"_d":"`, TypeRecord, id, ts, lbl)
+	if _, err := w.Write(prefix); err != nil {
+		return 0, fmt.Errorf("set: %w", err)
+	}
+	dataStart := w.off
+
+	var comp bytes.Buffer
+	zw, err := zstd.NewWriter(&comp, db.codec.writerOptions()...)
+	if err != nil {
+		return 0, fmt.Errorf("set: %w", err)
+	}
+	defer zw.Close()
+
+	var (
+		crc   uint32
+		total int
+		b64   io.WriteCloser // set once the content proves to be binary
+		carry []byte         // an incomplete rune held over to the next chunk
+	)
+	buf := make([]byte, db.config.ReadBuffer)
+	for {
+		n, rerr := r.Read(buf)
+		if rerr != nil && !errors.Is(rerr, io.EOF) {
+			return 0, fmt.Errorf("set: read: %w", rerr)
+		}
+		eof := rerr != nil
+		chunk := buf[:n]
+		total += n
+		crc = crc32.Update(crc, castagnoli, chunk)
+		if _, err := zw.Write(chunk); err != nil {
+			return 0, fmt.Errorf("set: %w", err)
+		}
+
+		if b64 != nil {
+			if _, err := b64.Write(chunk); err != nil {
+				return 0, fmt.Errorf("set: %w", err)
+			}
+		} else {
+			text := append(carry, chunk...)
+			cut := len(text)
+			if !eof {
+				cut = runeCut(text)
+			}
+			if !utf8.Valid(text[:cut]) {
+				// Rewrite everything so far as base64 from the
+				// compressed copy, then carry on in that form.
+				if err := zw.Flush(); err != nil {
+					return 0, fmt.Errorf("set: %w", err)
+				}
+				w.off = dataStart
+				b64 = base64.NewEncoder(base64.StdEncoding, w)
+				zr, err := zstd.NewReader(bytes.NewReader(comp.Bytes()), db.codec.readerOptions()...)
+				if err != nil {
+					return 0, fmt.Errorf("set: %w", err)
+				}
+				// The frame is still open, so copy exactly what has
+				// been read rather than waiting for its end.
+				_, err = io.CopyN(b64, zr, int64(total))
+				zr.Close()
+				if err != nil {
+					return 0, fmt.Errorf("set: %w", err)
+				}
+				carry = nil
+			} else {
+				escaped, err := json.Marshal(string(text[:cut]))
+				if err != nil {
+					return 0, fmt.Errorf("set: %w", err)
+				}
+				if _, err := w.Write(escaped[1 : len(escaped)-1]); err != nil {
+					return 0, fmt.Errorf("set: %w", err)
+				}
+				carry = append([]byte(nil), text[cut:]...)
+			}
+		}
+		if eof {
+			break
+		}
+	}
+	if total == 0 {
+		return 0, ErrEmptyContent
+	}
+	if b64 != nil {
+		if err := b64.Close(); err != nil {
+			return 0, fmt.Errorf("set: %w", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return 0, fmt.Errorf("set: %w", err)
+	}
+
+	history, err := json.Marshal(encode85(comp.Bytes()))
+	if err != nil {
+		return 0, fmt.Errorf("set: %w", err)
+	}
+	rest := append([]byte(`","_h":`), history...)
+	if crc != 0 {
+		rest = fmt.Appendf(rest, `,"_k":%d`, crc)
+	}
+	if b64 != nil {
+		rest = append(rest, `,"_b":true`...)
+	}
+	rest = append(rest, "}\n"...)
+
+	index, err := json.Marshal(&Index{
+		Type:      TypeIndex,
+		ID:        id,
+		Label:     label,
+		Timestamp: ts,
+		Offset:    start,
+		Created:   carried(idx, ts),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("set: %w", err)
+	}
+	rest = append(append(rest, index...), '\n')
+	if _, err := w.Write(rest); err != nil {
+		return 0, fmt.Errorf("set: %w", err)
+	}
+	return w.off, nil
+}
+
+// runeCut returns the length of b without a trailing incomplete rune.
+func runeCut(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(b)
+}
+
+// tailWriter writes sequentially from off without moving db.tail, and
+// remembers the furthest byte written.
+type tailWriter struct {
+	db   *DB
+	off  int64
+	high int64
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	if limit := w.db.config.MaxDatabaseSize; limit > 0 && w.off+int64(len(p)) > limit {
+		return 0, fmt.Errorf("%w: record would pass %d bytes", errFull, limit)
+	}
+	n, err := w.db.writer.WriteAt(p, w.off)
+	w.off += int64(n)
+	w.high = max(w.high, w.off)
+	return n, err
+}
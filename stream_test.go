@@ -0,0 +1,288 @@
+// Streaming read and write tests.
+//
+// GetReader must return exactly what Get returns for every encoding a
+// record can carry — escaped text, base64 binary, encrypted — while
+// holding the read lock only until Close. The escape decoder is also
+// tested directly on input the writer never produces but a port might.
+// SetReader must store exactly what Set would, however the content is
+// split across reads, and leave nothing behind when it fails.
+package folio
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// readAll drains GetReader for label and closes it.
+func readAll(t *testing.T, db *DB, label string) ([]byte, error) {
+	t.Helper()
+	r, err := db.GetReader(label)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// TestGetReaderMatchesGet verifies streamed content equals Get for text
+// full of escapes, large enough to span many buffer fills, in both the
+// sorted and sparse regions.
+func TestGetReaderMatchesGet(t *testing.T) {
+	db := openTestDB(t)
+	content := strings.Repeat("line \"quoted\" \\ tab\t <tag> & é 😀 \x01\u2028\n", 20000)
+	db.Set("big", content)
+	db.Set("small", "x")
+
+	check := func(stage string) {
+		for _, lbl := range []string{"big", "small"} {
+			got, err := readAll(t, db, lbl)
+			want, _ := db.Get(lbl)
+			if err != nil || string(got) != want {
+				t.Errorf("%s: GetReader(%s) = %d bytes, %v; want %d bytes", stage, lbl, len(got), err, len(want))
+			}
+		}
+	}
+	check("sparse")
+	db.Compact()
+	check("sorted")
+
+	if _, err := db.GetReader("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetReader(missing) = %v, want ErrNotFound", err)
+	}
+}
+
+// TestGetReaderBinaryAndEncrypted verifies the base64 and encrypted
+// paths decode to the stored bytes.
+func TestGetReaderBinaryAndEncrypted(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{EncryptionKey: testKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Set("secret", "hidden text")
+	if got, err := readAll(t, db, "secret"); err != nil || string(got) != "hidden text" {
+		t.Errorf("encrypted = %q, %v", got, err)
+	}
+
+	plain := openTestDB(t)
+	raw := []byte{0xff, 0xfe, 0x00, 0x80, 'a'}
+	plain.SetBytes("bin", raw)
+	if got, err := readAll(t, plain, "bin"); err != nil || !bytes.Equal(got, raw) {
+		t.Errorf("binary = %v, %v; want %v", got, err, raw)
+	}
+}
+
+// TestGetReaderChecksum verifies damaged content is reported by the
+// final Read instead of ending with a clean EOF.
+func TestGetReaderChecksum(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "content")
+	db.Compact()
+	flipContent(t, db, HeaderSize)
+
+	_, err := readAll(t, db, "doc")
+	if !errors.Is(err, ErrChecksum) {
+		t.Errorf("got %v, want ErrChecksum", err)
+	}
+}
+
+// TestGetReaderHoldsLock verifies writers wait while a reader is open
+// and proceed once it is closed.
+func TestGetReaderHoldsLock(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "v1")
+
+	r, err := db.GetReader("doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		db.Set("doc", "v2")
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Set completed while a reader was open")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if got, _ := io.ReadAll(r); string(got) != "v1" {
+		t.Errorf("read %q, want v1", got)
+	}
+	r.Close()
+	r.Close()
+	<-done
+	if _, err := r.Read(make([]byte, 1)); !errors.Is(err, ErrClosed) {
+		t.Errorf("Read after Close = %v, want ErrClosed", err)
+	}
+}
+
+// TestUnescaper verifies escapes the writer never emits, such as
+// surrogate pairs and uppercase hex, still decode correctly.
+func TestUnescaper(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{`plain"`, "plain"},
+		{`a\"b\\c\/d\n\t\r\b\f"`, "a\"b\\c/d\n\t\r\b\f"},
+		{`\u00e9\u00C9"`, "éÉ"},
+		{`\ud83d\ude00"`, "😀"},
+		{`\ud83dx"`, "\ufffdx"},
+		{`"rest`, ""},
+	}
+	for _, tt := range tests {
+		u := &unescaper{src: bufio.NewReader(strings.NewReader(tt.in))}
+		got, err := io.ReadAll(u)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("unescape(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+	u := &unescaper{src: bufio.NewReader(strings.NewReader(`no end`))}
+	if _, err := io.ReadAll(u); !errors.Is(err, ErrCorruptRecord) {
+		t.Errorf("unterminated = %v, want ErrCorruptRecord", err)
+	}
+}
+
+// current returns the raw record line of label's current version.
+func current(t *testing.T, db *DB, label string) []byte {
+	t.Helper()
+	idx, err := db.current(label)
+	if err != nil {
+		t.Fatalf("current(%s): %v", label, err)
+	}
+	data, err := line(db.reader, idx.Offset)
+	if err != nil {
+		t.Fatalf("line: %v", err)
+	}
+	return data
+}
+
+// field returns the raw bytes of a string field in a record line.
+func field(line []byte, name string) []byte {
+	marker := []byte(`"` + name + `":"`)
+	i := bytes.Index(line, marker)
+	if i < 0 {
+		return nil
+	}
+	rest := line[i+len(marker):]
+	for j := 0; j < len(rest); j++ {
+		switch rest[j] {
+		case '\\':
+			j++
+		case '"':
+			return rest[:j]
+		}
+	}
+	return nil
+}
+
+// TestSetReaderMatchesSet verifies the streamed _d is byte-identical to
+// what Set writes, even when every read splits a multi-byte rune, and
+// that history and checksums come out right.
+func TestSetReaderMatchesSet(t *testing.T) {
+	db := openTestDB(t)
+	content := strings.Repeat("line \"quoted\" \\ tab\t <tag> & é 😀 \x01\u2028\n", 500)
+	db.Set("set", content)
+	if err := db.SetReader("stream", iotest.OneByteReader(strings.NewReader(content))); err != nil {
+		t.Fatalf("SetReader: %v", err)
+	}
+
+	if got, err := db.Get("stream"); err != nil || got != content {
+		t.Fatalf("Get = %d bytes, %v; want %d bytes", len(got), err, len(content))
+	}
+	a, b := current(t, db, "set"), current(t, db, "stream")
+	if !bytes.Equal(field(a, "_d"), field(b, "_d")) {
+		t.Error("streamed _d differs from Set")
+	}
+	if sum(a) != sum(b) {
+		t.Errorf("checksum = %d, want %d", sum(b), sum(a))
+	}
+
+	db.SetReader("stream", strings.NewReader("v2"))
+	versions, err := Collect(db.History("stream"))
+	if err != nil || len(versions) != 2 || versions[0].Data != content || versions[1].Data != "v2" {
+		t.Errorf("History = %d versions, %v", len(versions), err)
+	}
+	if n := db.Count(); n != 2 {
+		t.Errorf("Count = %d, want 2", n)
+	}
+	if err := db.Repair(nil); err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if got, _ := db.Get("stream"); got != "v2" {
+		t.Errorf("after Repair = %q, want v2", got)
+	}
+}
+
+// TestSetReaderBinary verifies content that stops being valid UTF-8
+// partway through is stored as base64, as SetBytes would store it.
+func TestSetReaderBinary(t *testing.T) {
+	db := openTestDB(t)
+	raw := append(bytes.Repeat([]byte("text "), 20000), 0xff, 0xfe, 'a')
+	if err := db.SetReader("bin", bytes.NewReader(raw)); err != nil {
+		t.Fatalf("SetReader: %v", err)
+	}
+	db.SetBytes("ref", raw)
+
+	if got, err := db.GetBytes("bin"); err != nil || !bytes.Equal(got, raw) {
+		t.Fatalf("GetBytes = %d bytes, %v", len(got), err)
+	}
+	a, b := current(t, db, "ref"), current(t, db, "bin")
+	if !binary(b) || !bytes.Equal(field(a, "_d"), field(b, "_d")) {
+		t.Error("streamed binary _d differs from SetBytes")
+	}
+}
+
+// TestSetReaderEncrypted verifies the buffered path used when a key is
+// configured.
+func TestSetReaderEncrypted(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{EncryptionKey: testKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.SetReader("secret", strings.NewReader("hidden")); err != nil {
+		t.Fatalf("SetReader: %v", err)
+	}
+	if got, err := db.Get("secret"); err != nil || got != "hidden" {
+		t.Errorf("Get = %q, %v", got, err)
+	}
+}
+
+// TestSetReaderFailure verifies an empty or failing reader writes
+// nothing and leaves the previous version current.
+func TestSetReaderFailure(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "original")
+	before := dbsize(t, db)
+
+	if err := db.SetReader("doc", strings.NewReader("")); !errors.Is(err, ErrEmptyContent) {
+		t.Errorf("empty = %v, want ErrEmptyContent", err)
+	}
+	boom := errors.New("boom")
+	r := io.MultiReader(strings.NewReader(strings.Repeat("x", 100000)), iotest.ErrReader(boom))
+	if err := db.SetReader("doc", r); !errors.Is(err, boom) {
+		t.Errorf("failing reader = %v, want %v", err, boom)
+	}
+	if err := db.SetReader("", strings.NewReader("x")); !errors.Is(err, ErrInvalidLabel) {
+		t.Errorf("empty label = %v, want ErrInvalidLabel", err)
+	}
+
+	if after := dbsize(t, db); after != before {
+		t.Errorf("size = %d, want %d", after, before)
+	}
+	if got, _ := db.Get("doc"); got != "original" {
+		t.Errorf("Get = %q, want original", got)
+	}
+	if err := db.Set("next", "ok"); err != nil {
+		t.Fatalf("Set after failure: %v", err)
+	}
+	if got, _ := db.Get("next"); got != "ok" {
+		t.Errorf("Get(next) = %q", got)
+	}
+}
@@ -0,0 +1,413 @@
+// Document tags.
+//
+// A tag is a short string attached to a document, for grouping labels
+// that share no prefix: every document tagged "draft", say. Each
+// (label, tag) pair is one tag record (_r=6) whose _id is the hash of
+// the tag rather than of the label, so ByTag can look a tag up the way
+// Get looks up a label:
+//
+//	{"_r":6,"_id":"a1b2c3d4e5f60718","_ts":1706000000000,"_l":"doc","_t":"draft","_c":1705000000000}
+//
+// Tag appends records to the sparse region like any other write, and
+// Untag erases them in place with spaces. Compaction gathers the live
+// ones into a sorted tag section between the index section and the
+// metadata record, whose _g field holds where the section ends, so a
+// lookup binary searches the section and scans only the tag records
+// appended since.
+//
+// A tag record carries the _c of the document it was given to, and only
+// applies while that document is current, so a document that expires
+// loses its tags and one later created under the same label starts
+// untagged. Compaction leaves such records out. Tag records are found
+// by tag, so those of one document are found by scanning the tag
+// section and the sparse region: Delete, a Txn, and the source of a
+// Transfer erase the tags of the documents they remove, and Rename
+// moves them to the new label. A rename inside a Txn drops them.
+package folio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// TypeTag marks a tag record. It has no data record and no index, and
+// is never returned by document lookups or scans.
+const TypeTag = 6
+
+// tagRecord attaches one tag to one document.
+type tagRecord struct {
+	Type      int    `json:"_r"`
+	ID        string `json:"_id"` // hash of Tag
+	Timestamp int64  `json:"_ts"`
+	Label     string `json:"_l"`
+	Tag       string `json:"_t"`
+	Created   int64  `json:"_c"` // _c of the document when tagged
+}
+
+// validateTag applies the rules of validateLabel to a tag: neither may
+// be empty, longer than MaxLabelSize, or contain a quote.
+func validateTag(tag string) error {
+	if tag == "" || len(tag) > MaxLabelSize || strings.Contains(tag, `"`) {
+		return ErrInvalidTag
+	}
+	return nil
+}
+
+// Tag attaches tags to the document at label. Tags it already has are
+// skipped. Returns ErrNotFound if the document does not exist.
+func (db *DB) Tag(label string, tags ...string) (err error) {
+	defer db.observe(OpTag, label, time.Now(), &err)
+
+	if err := validateLabel(label); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if err := validateTag(tag); err != nil {
+			return err
+		}
+	}
+
+	if err := db.blockWrite(); err != nil {
+		return err
+	}
+
+	err = db.tag(label, tags)
+
+	// Check threshold under lock, compact after release (see set.go).
+	compact := err == nil && db.shouldCompact()
+	db.mu.Unlock()
+	db.lock.Unlock()
+
+	if err == nil {
+		err = db.durable()
+	}
+	if compact {
+		db.Compact()
+	}
+	return err
+}
+
+// tag appends a record for each tag the document lacks, in one write.
+// The write lock must be held.
+func (db *DB) tag(label string, tags []string) error {
+	idx, err := db.current(label)
+	if errors.Is(err, ErrNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("tag: %w", err)
+	}
+	label = idx.Label // as stored, in a case-insensitive file
+	sz, err := size(db.reader)
+	if err != nil {
+		return fmt.Errorf("tag: stat: %w", err)
+	}
+
+	var buf []byte
+	done := map[string]bool{}
+	ts := now()
+	for _, tag := range tags {
+		if done[tag] {
+			continue
+		}
+		done[tag] = true
+		has, err := db.tagged(tag, sz)
+		if err != nil {
+			return fmt.Errorf("tag: %w", err)
+		}
+		if slices.ContainsFunc(has, func(t tagLine) bool { return t.Label == label && t.Created == idx.Created }) {
+			continue
+		}
+		rec, err := json.Marshal(tagRecord{
+			Type:      TypeTag,
+			ID:        hash(tag, db.header.Algorithm),
+			Timestamp: ts,
+			Label:     label,
+			Tag:       tag,
+			Created:   idx.Created,
+		})
+		if err != nil {
+			return fmt.Errorf("tag: %w", err)
+		}
+		if buf != nil {
+			buf = append(buf, '\n')
+		}
+		buf = append(buf, rec...)
+	}
+	if buf == nil {
+		return nil
+	}
+	if _, err := db.raw(buf); err != nil {
+		return fmt.Errorf("tag: %w", err)
+	}
+	return nil
+}
+
+// Untag removes tags from the document at label. Tags it does not have,
+// and a label with no document, are not an error.
+func (db *DB) Untag(label string, tags ...string) (err error) {
+	defer db.observe(OpUntag, label, time.Now(), &err)
+
+	if err := validateLabel(label); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if err := validateTag(tag); err != nil {
+			return err
+		}
+	}
+
+	if err := db.blockWrite(); err != nil {
+		return err
+	}
+
+	err = db.untag(label, tags)
+	db.mu.Unlock()
+	db.lock.Unlock()
+
+	if err == nil {
+		err = db.durable()
+	}
+	return err
+}
+
+// untag erases every record of the tags for label, live or stale. The
+// write lock must be held.
+func (db *DB) untag(label string, tags []string) error {
+	sz, err := size(db.reader)
+	if err != nil {
+		return fmt.Errorf("untag: stat: %w", err)
+	}
+	for _, tag := range tags {
+		has, err := db.tagged(tag, sz)
+		if err != nil {
+			return fmt.Errorf("untag: %w", err)
+		}
+		for _, t := range has {
+			if !db.same(t.Label, label) {
+				continue
+			}
+			if err := db.erase([]tagLine{t}); err != nil {
+				return fmt.Errorf("untag: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// ByTag yields, in sorted order, the labels of current documents that
+// have tag.
+func (db *DB) ByTag(tag string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		if err := validateTag(tag); err != nil {
+			yield("", err)
+			return
+		}
+		if err := db.blockRead(); err != nil {
+			yield("", err)
+			return
+		}
+		defer func() {
+			db.mu.RUnlock()
+			db.lock.Unlock()
+		}()
+
+		sz, err := size(db.reader)
+		if err != nil {
+			yield("", fmt.Errorf("bytag: stat: %w", err))
+			return
+		}
+		has, err := db.tagged(tag, sz)
+		if err != nil {
+			yield("", fmt.Errorf("bytag: %w", err))
+			return
+		}
+
+		created := map[string]int64{} // label → _c of its current document, -1 if none
+		seen := map[string]bool{}
+		var labels []string
+		for _, t := range has {
+			ct, ok := created[t.Label]
+			if !ok {
+				idx, err := db.current(t.Label)
+				switch {
+				case errors.Is(err, ErrNotFound):
+					ct = -1
+				case err != nil:
+					yield("", fmt.Errorf("bytag: %w", err))
+					return
+				default:
+					ct = idx.Created
+				}
+				created[t.Label] = ct
+			}
+			if ct == t.Created && !seen[t.Label] {
+				seen[t.Label] = true
+				labels = append(labels, t.Label)
+			}
+		}
+		slices.Sort(labels)
+		for _, lbl := range labels {
+			if !yield(lbl, nil) {
+				return
+			}
+		}
+	}
+}
+
+// tagLine is a tag record and where it lies.
+type tagLine struct {
+	tagRecord
+	off int64
+	n   int
+}
+
+// tagged returns every record of tag, whether or not its document is
+// still current: a binary search of the sorted tag section, then a
+// scan of the sparse region after it. The caller must hold db.mu.
+func (db *DB) tagged(tag string, sz int64) ([]tagLine, error) {
+	id := hash(tag, db.header.Algorithm)
+	start, end := db.sparseStart(), db.tagEnd()
+
+	var found []Result
+	for off := seek(db.reader, id, start, end); off < end; {
+		data, err := line(db.reader, off)
+		if err != nil {
+			return nil, err
+		}
+		if valid(data) && len(data) >= MinRecordSize {
+			if string(data[IDStart:IDEnd]) != id {
+				break
+			}
+			found = append(found, Result{off, len(data), data, id})
+		}
+		off += int64(len(data)) + 1
+	}
+	found = append(found, sparse(db.reader, id, end, sz, TypeTag)...)
+
+	var out []tagLine
+	for _, r := range found {
+		var t tagRecord
+		if err := json.Unmarshal(r.Data, &t); err != nil {
+			return nil, ErrCorruptRecord
+		}
+		if t.Tag == tag {
+			out = append(out, tagLine{t, r.Offset, r.Length})
+		}
+	}
+	return out, nil
+}
+
+// tagOf extracts the _t value of a tag record by byte scanning, as label
+// does _l.
+func tagOf(line []byte) string {
+	marker := []byte(`"_t":"`)
+	start := bytes.Index(line, marker)
+	if start == -1 {
+		return ""
+	}
+	start += len(marker)
+	end := bytes.IndexByte(line[start:], '"')
+	if end == -1 {
+		return ""
+	}
+	return string(line[start : start+end])
+}
+
+// tagEnd returns the end of the sorted tag section, which starts where
+// the sparse region does. Without one it is the start of the sparse
+// region, and every tag record is scanned linearly.
+func (db *DB) tagEnd() int64 {
+	start := db.sparseStart()
+	if db.meta != nil && db.meta.Tags > start {
+		return db.meta.Tags
+	}
+	return start
+}
+
+// tagsOf returns every tag record given to label. Tag records are found
+// by tag, not by label, so this scans the tag section and the sparse
+// region. The caller must hold db.mu.
+func (db *DB) tagsOf(label string) ([]tagLine, error) {
+	sz, err := size(db.reader)
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+	var out []tagLine
+	for _, e := range scanm(db.reader, db.sparseStart(), sz, TypeTag) {
+		data, err := line(db.reader, e.SrcOff)
+		if err != nil {
+			return nil, err
+		}
+		var t tagRecord
+		if err := json.Unmarshal(data, &t); err != nil {
+			return nil, ErrCorruptRecord
+		}
+		if db.same(t.Label, label) {
+			out = append(out, tagLine{t, e.SrcOff, e.Length})
+		}
+	}
+	return out, nil
+}
+
+// erase blanks tag records in place.
+func (db *DB) erase(lines []tagLine) error {
+	for _, t := range lines {
+		if err := db.writeAt(t.off, []byte(strings.Repeat(" ", t.n))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropTags erases the tag records of label once its document is gone,
+// so that a document created under the label in the same millisecond,
+// and so with the same _c, does not inherit them. The write lock must
+// be held.
+func (db *DB) dropTags(label string) error {
+	lines, err := db.tagsOf(label)
+	if err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+	return db.erase(lines)
+}
+
+// retag appends the live tag records of old under new, and erases the
+// old ones, after Rename has moved the document. The write lock must
+// be held.
+func (db *DB) retag(old, new string, created int64) error {
+	lines, err := db.tagsOf(old)
+	if err != nil {
+		return fmt.Errorf("tags: %w", err)
+	}
+
+	var buf []byte
+	ts := now()
+	for _, t := range lines {
+		if t.Created != created {
+			continue // stale: given to an earlier document
+		}
+		t.Label, t.Timestamp = new, ts
+		rec, err := json.Marshal(t.tagRecord)
+		if err != nil {
+			return err
+		}
+		if buf != nil {
+			buf = append(buf, '\n')
+		}
+		buf = append(buf, rec...)
+	}
+	if buf != nil {
+		if _, err := db.raw(buf); err != nil {
+			return err
+		}
+	}
+	return db.erase(lines)
+}
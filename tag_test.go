@@ -0,0 +1,189 @@
+// Tag tests.
+//
+// A tag lookup reads two places: the sorted tag section that compaction
+// writes and the tag records appended since. Each test checks ByTag
+// before and after a compaction so both are exercised, and that a tag
+// follows its document through deletes, renames, and recreation.
+package folio
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// byTag collects ByTag or fails the test.
+func byTag(t *testing.T, db *DB, tag string) []string {
+	t.Helper()
+	labels, err := Collect(db.ByTag(tag))
+	if err != nil {
+		t.Fatalf("ByTag(%q): %v", tag, err)
+	}
+	return labels
+}
+
+// TestTag verifies Tag, Untag, and ByTag on appended tag records and
+// on the sorted section compaction builds from them.
+func TestTag(t *testing.T) {
+	db := openTestDB(t)
+	for _, lbl := range []string{"a", "b", "c", "d"} {
+		if err := db.Set(lbl, "content of "+lbl); err != nil {
+			t.Fatalf("Set(%q): %v", lbl, err)
+		}
+	}
+	db.Tag("c", "red", "blue")
+	db.Tag("a", "red")
+	db.Tag("a", "red") // already tagged: no second record
+	db.Tag("d", "blue")
+
+	check := func(when string) {
+		t.Helper()
+		if got, want := byTag(t, db, "red"), []string{"a", "c"}; !slices.Equal(got, want) {
+			t.Errorf("%s: ByTag(red) = %v, want %v", when, got, want)
+		}
+		if got, want := byTag(t, db, "blue"), []string{"c", "d"}; !slices.Equal(got, want) {
+			t.Errorf("%s: ByTag(blue) = %v, want %v", when, got, want)
+		}
+		if got := byTag(t, db, "green"); len(got) != 0 {
+			t.Errorf("%s: ByTag(green) = %v, want none", when, got)
+		}
+	}
+	check("appended")
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	check("compacted")
+	if s := db.Stats(); s.TagBytes == 0 {
+		t.Error("TagBytes = 0 after compaction, want the tag section")
+	}
+
+	// Untag erases a record in the sorted section; new tags go to sparse.
+	if err := db.Untag("c", "red"); err != nil {
+		t.Fatalf("Untag: %v", err)
+	}
+	db.Tag("b", "red")
+	if got, want := byTag(t, db, "red"), []string{"a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("ByTag(red) = %v, want %v", got, want)
+	}
+	if err := db.Untag("missing", "red"); err != nil {
+		t.Errorf("Untag(missing) = %v, want nil", err)
+	}
+	mustVerify(t, db, VerifyOptions{})
+}
+
+// TestTagErrors verifies validation and that only existing documents
+// can be tagged.
+func TestTagErrors(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "x")
+
+	if err := db.Tag("missing", "red"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Tag(missing) = %v, want ErrNotFound", err)
+	}
+	for _, tag := range []string{"", `a"b`, string(make([]byte, MaxLabelSize+1))} {
+		if err := db.Tag("doc", tag); !errors.Is(err, ErrInvalidTag) {
+			t.Errorf("Tag(%q) = %v, want ErrInvalidTag", tag, err)
+		}
+	}
+	if _, err := Collect(db.ByTag("")); !errors.Is(err, ErrInvalidTag) {
+		t.Errorf("ByTag(\"\") = %v, want ErrInvalidTag", err)
+	}
+}
+
+// TestTagLifecycle verifies that tags stop applying when a document is
+// deleted, are not inherited by a document recreated under the label
+// directly or in a Txn, move with Rename on both of its paths, and that
+// compaction drops the records that no longer apply.
+func TestTagLifecycle(t *testing.T) {
+	db := openTestDB(t)
+	for _, lbl := range []string{"gone", "same", "longer"} {
+		db.Set(lbl, "v1")
+		db.Tag(lbl, "t")
+	}
+
+	db.Delete("gone")
+	if got := byTag(t, db, "t"); slices.Contains(got, "gone") {
+		t.Errorf("ByTag after delete = %v, still has gone", got)
+	}
+	db.Set("gone", "v2")
+	if got := byTag(t, db, "t"); slices.Contains(got, "gone") {
+		t.Errorf("ByTag after recreate = %v, inherited the old tag", got)
+	}
+
+	db.Set("txn", "v1")
+	db.Tag("txn", "t")
+	err := db.Txn(func(tx *Txn) error {
+		tx.Delete("txn")
+		return tx.Set("txn", "v2")
+	})
+	if err != nil {
+		t.Fatalf("Txn: %v", err)
+	}
+	if got := byTag(t, db, "t"); slices.Contains(got, "txn") {
+		t.Errorf("ByTag after Txn recreate = %v, inherited the old tag", got)
+	}
+
+	if err := db.Rename("same", "emas"); err != nil {
+		t.Fatalf("Rename same length: %v", err)
+	}
+	if err := db.Rename("longer", "longer-still"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	want := []string{"emas", "longer-still"}
+	if got := byTag(t, db, "t"); !slices.Equal(got, want) {
+		t.Errorf("ByTag after renames = %v, want %v", got, want)
+	}
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if got := byTag(t, db, "t"); !slices.Equal(got, want) {
+		t.Errorf("ByTag after compaction = %v, want %v", got, want)
+	}
+	db.mu.RLock()
+	n := len(scanm(db.reader, HeaderSize, db.tail, TypeTag))
+	db.mu.RUnlock()
+	if n != len(want) {
+		t.Errorf("%d tag records after compaction, want %d", n, len(want))
+	}
+	mustVerify(t, db, VerifyOptions{})
+}
+
+// TestTagReopen verifies that the tag section is found again through
+// the metadata record after Close and Open, and survives Rehash and the
+// compaction that follows it.
+func TestTagReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, lbl := range []string{"x", "y", "z"} {
+		db.Set(lbl, lbl)
+	}
+	db.Tag("x", "k")
+	db.Tag("z", "k")
+	db.Compact()
+	db.Close()
+
+	db, err = Open(path, Config{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	want := []string{"x", "z"}
+	if got := byTag(t, db, "k"); !slices.Equal(got, want) {
+		t.Errorf("ByTag after reopen = %v, want %v", got, want)
+	}
+
+	if err := db.Rehash(AlgFNV1a); err != nil {
+		t.Fatalf("Rehash: %v", err)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if got := byTag(t, db, "k"); !slices.Equal(got, want) {
+		t.Errorf("ByTag after rehash = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,75 @@
+// Timestamp refresh without a new version.
+//
+// Sync tooling often needs to mark a document as seen or refreshed when
+// its content has not changed, and a Set of the same content would
+// append a whole new version for it. Touch instead overwrites the
+// timestamp of the current version, which sits at a fixed position and
+// width in every line (see record.go), in the data record and then in
+// its index, so the file does not grow.
+//
+// The touched version takes the new time everywhere: Info reports it as
+// Modified, History lists it, and GetAt for a time between the old and
+// new timestamps now finds the version before it. The creation time,
+// expiry, and content are unchanged.
+package folio
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Touch sets the timestamp of label's current version to now, in place.
+// Returns ErrNotFound if label does not exist.
+func (db *DB) Touch(label string) (err error) {
+	defer db.observe(OpTouch, label, time.Now(), &err)
+
+	if err := db.blockWrite(); err != nil {
+		return err
+	}
+
+	// Nothing is appended, so the auto-compaction counter is unchanged
+	// and there is no threshold to check.
+	err = db.touch(label)
+	db.mu.Unlock()
+	db.lock.Unlock()
+
+	if err == nil {
+		err = db.durable()
+	}
+	return err
+}
+
+// touch patches the timestamps. The write lock must be held.
+func (db *DB) touch(label string) error {
+	sz, err := size(db.reader)
+	if err != nil {
+		return fmt.Errorf("touch: stat: %w", err)
+	}
+	result, idx, err := db.findIndex(db.id(label), label, sz)
+	if err != nil {
+		return fmt.Errorf("touch: %w", err)
+	}
+	ts := now()
+	if result == nil || idx.expired(ts) {
+		return ErrNotFound
+	}
+
+	// A clock that has stepped back must not date the version before
+	// its history, which the heap is sorted by.
+	stamp := []byte(strconv.FormatInt(max(ts, idx.Timestamp), 10))
+
+	// The record first, so a crash between the two never leaves an
+	// index newer than the version it points at.
+	if err := db.writeAt(idx.Offset+TSStart, stamp); err != nil {
+		return fmt.Errorf("touch: patch record: %w", err)
+	}
+	if err := db.writeAt(result.Offset+TSStart, stamp); err != nil {
+		return fmt.Errorf("touch: patch index: %w", err)
+	}
+	if db.recent != nil {
+		db.recent.put(idx.Label, max(ts, idx.Timestamp))
+	}
+	db.usage.writes.Add(1)
+	return nil
+}
@@ -0,0 +1,71 @@
+// Touch tests.
+//
+// Touch patches timestamps in place, so these check both that the new
+// time is seen by every reader of it and that nothing else changed: the
+// file size, the content, the number of versions, and the creation time.
+package folio
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestTouch verifies that Touch moves the current version's timestamp
+// forward without appending, for a document in the sparse region and,
+// after compaction, in the sorted sections.
+func TestTouch(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "v1")
+	time.Sleep(2 * time.Millisecond)
+	db.Set("doc", "v2")
+
+	for _, where := range []string{"sparse", "sorted"} {
+		if where == "sorted" {
+			if err := db.Compact(); err != nil {
+				t.Fatalf("Compact: %v", err)
+			}
+		}
+		before, _ := db.Info("doc")
+		tail := db.tail
+		time.Sleep(5 * time.Millisecond)
+
+		if err := db.Touch("doc"); err != nil {
+			t.Fatalf("%s: Touch: %v", where, err)
+		}
+		if db.tail != tail {
+			t.Errorf("%s: file grew by %d bytes", where, db.tail-tail)
+		}
+		after, _ := db.Info("doc")
+		if after.Modified <= before.Modified || after.Created != before.Created {
+			t.Errorf("%s: Info = %+v after Touch, was %+v: want only Modified later", where, after, before)
+		}
+		versions, _ := Collect(db.History("doc"))
+		if len(versions) != 2 || versions[1].Data != "v2" || versions[1].TS != after.Modified {
+			t.Errorf("%s: History = %v, want v1 then v2 at %d", where, versions, after.Modified)
+		}
+		if data, _ := db.Get("doc"); data != "v2" {
+			t.Errorf("%s: Get = %q, want v2", where, data)
+		}
+		if data, _ := db.GetAt("doc", before.Modified); data != "v1" {
+			t.Errorf("%s: GetAt(old time) = %q, want v1", where, data)
+		}
+		mustVerify(t, db, VerifyOptions{})
+	}
+}
+
+// TestTouchNotFound verifies that Touch reports a missing, deleted, or
+// expired document as ErrNotFound.
+func TestTouchNotFound(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("gone", "x")
+	db.Delete("gone")
+	db.SetWithTTL("expired", "x", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	for _, lbl := range []string{"missing", "gone", "expired"} {
+		if err := db.Touch(lbl); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Touch(%s) = %v, want ErrNotFound", lbl, err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Moving a namespace of documents between two databases.
+//
+// Transfer copies every document whose label starts with a prefix from
+// one database to another, history included, then soft-deletes the
+// originals. Both write locks are held for the whole operation so no
+// other writer can observe a half-moved namespace in either file.
+//
+// The destination receives all records in a single append: every
+// history version (as _r=3 with its original timestamp) followed by the
+// current record and index. A crash before that append completes leaves
+// the destination unchanged; a crash after it but before the source is
+// blanked leaves the documents present in both files, which is safe to
+// retry. Each side is therefore atomic from its own perspective.
+package folio
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Transfer moves all documents under prefix from src to dst, preserving
+// history and expiry. Source documents are soft-deleted, leaving their
+// history in place; one that has expired is left behind. Returns
+// ErrExists without writing anything if any label being moved already
+// exists in dst, unless the document there has expired, and an error if
+// src and dst are the same file, through one handle or two.
+func Transfer(src, dst *DB, prefix string) error {
+	if src == dst || src.path() == dst.path() {
+		return errors.New("transfer: source and destination are the same file")
+	}
+
+	// Lock in a stable order so two concurrent Transfers in opposite
+	// directions cannot deadlock waiting on each other.
+	first, second := src, dst
+	if dst.path() < src.path() {
+		first, second = dst, src
+	}
+	if err := first.blockWrite(); err != nil {
+		return err
+	}
+	if err := second.blockWrite(); err != nil {
+		first.mu.Unlock()
+		first.lock.Unlock()
+		return err
+	}
+
+	err := transfer(src, dst, prefix)
+
+	compactSrc := err == nil && src.shouldCompact()
+	compactDst := err == nil && dst.shouldCompact()
+	srcEvents, dstEvents := src.takeEvents(), dst.takeEvents()
+	second.mu.Unlock()
+	second.lock.Unlock()
+	first.mu.Unlock()
+	first.lock.Unlock()
+
+	if err == nil {
+		err = errors.Join(src.durable(), dst.durable())
+	}
+	dst.after(dstEvents)
+	src.after(srcEvents)
+	if compactSrc {
+		src.Compact()
+	}
+	if compactDst {
+		dst.Compact()
+	}
+	return err
+}
+
+// transfer performs the move. Both write locks must be held.
+func transfer(src, dst *DB, prefix string) error {
+	srcSize, err := size(src.reader)
+	if err != nil {
+		return fmt.Errorf("transfer: stat: %w", err)
+	}
+	dstSize, err := size(dst.reader)
+	if err != nil {
+		return fmt.Errorf("transfer: stat: %w", err)
+	}
+
+	// existing is an expired document under the label in dst, retired
+	// once the move lands.
+	type moved struct {
+		result      *Result
+		idx         *Index
+		existing    *Result
+		existingIdx *Index
+	}
+	var labels []string
+	found := map[string]moved{}
+	ts := now()
+
+	for _, e := range scanm(src.reader, HeaderSize, srcSize, TypeIndex) {
+		lbl := string(unescape([]byte(e.Label)))
+		if !strings.HasPrefix(lbl, prefix) {
+			continue
+		}
+		if _, ok := found[lbl]; ok {
+			continue
+		}
+		if err := dst.checkLabel(lbl); err != nil {
+			return fmt.Errorf("transfer: %s: %w", lbl, err)
+		}
+		result, idx, err := src.findIndex(e.ID, lbl, srcSize)
+		if err != nil {
+			return fmt.Errorf("transfer: %w", err)
+		}
+		if result == nil || idx.expired(ts) {
+			continue
+		}
+		existing, existingIdx, err := dst.findIndex(dst.id(lbl), lbl, dstSize)
+		if err != nil {
+			return fmt.Errorf("transfer: %w", err)
+		}
+		if existing != nil && !existingIdx.expired(ts) {
+			return ErrExists
+		}
+		labels = append(labels, lbl)
+		found[lbl] = moved{result, idx, existing, existingIdx}
+	}
+
+	if len(labels) == 0 {
+		return nil
+	}
+
+	// Build the destination append in memory so it lands in one write.
+	var buf []byte
+	var ids, contents []string
+	for _, lbl := range labels {
+		m := found[lbl]
+		versions, err := src.versions(lbl)
+		if err != nil {
+			return fmt.Errorf("transfer: %w", err)
+		}
+		if len(versions) == 0 {
+			return fmt.Errorf("transfer: %s: %w", lbl, ErrCorruptRecord)
+		}
+		content := versions[len(versions)-1].Data
+		if err := dst.beforeSet(lbl, content); err != nil {
+			return fmt.Errorf("transfer: %w", err)
+		}
+		if err := src.beforeDelete(lbl); err != nil {
+			return fmt.Errorf("transfer: %w", err)
+		}
+		contents = append(contents, content)
+
+		// Files written before _c existed fall back to the oldest version.
+		ct := m.idx.Created
+		if ct == 0 {
+			ct = versions[0].TS
+		}
+
+		id := dst.id(lbl)
+		ids = append(ids, id)
+		buf, err = dst.encodeDoc(buf, dst.tail, id, lbl, versions, ct, m.idx.Expires)
+		if err != nil {
+			return fmt.Errorf("transfer: %w", err)
+		}
+	}
+
+	// raw() appends the final newline.
+	if _, err := dst.raw(buf[:len(buf)-1]); err != nil {
+		return fmt.Errorf("transfer: %w", err)
+	}
+	// An expired document at dst is retired as Set would retire it.
+	for i, lbl := range labels {
+		m := found[lbl]
+		if err := dst.supersede(ids[i], lbl, m.idx.Expires, m.existing, m.existingIdx); err != nil {
+			return fmt.Errorf("transfer: %w", err)
+		}
+		dst.wroteSet(lbl, contents[i])
+	}
+
+	for _, lbl := range labels {
+		m := found[lbl]
+		if err := blank(src, m.idx.Offset, m.result); err != nil {
+			return fmt.Errorf("transfer: %w", err)
+		}
+		if src.labels != nil {
+			src.labels.remove(lbl)
+		}
+		if err := src.dropTags(lbl); err != nil {
+			return fmt.Errorf("transfer: %w", err)
+		}
+		src.unindex(lbl)
+		src.count.Add(^uint64(0)) // unsigned decrement
+		src.usage.writes.Add(1)
+		if err := src.tombstone(lbl, "", ts); err != nil {
+			return fmt.Errorf("transfer: %w", err)
+		}
+		src.wroteDelete(lbl)
+	}
+	return nil
+}
+
+// path returns the database file's location, used to order locks.
+func (db *DB) path() string {
+	if db.root == nil {
+		return fmt.Sprintf("%s%p", memoryPath, db)
+	}
+	return filepath.Join(db.root.Name(), db.name)
+}
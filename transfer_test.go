@@ -0,0 +1,219 @@
+// Transfer tests.
+//
+// Transfer moves a namespace of documents between two open databases.
+// The guarantees under test are that every moved document arrives with
+// its full history, that nothing outside the prefix is touched, that
+// the source keeps its history as a tombstone, and that a conflict in
+// the destination aborts the move before either file is modified.
+package folio
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// openPair opens two databases in separate directories so Transfer
+// tests have a distinct source and destination.
+func openPair(t *testing.T) (*DB, *DB) {
+	t.Helper()
+	src, err := Open(filepath.Join(t.TempDir(), "src.folio"), Config{})
+	if err != nil {
+		t.Fatalf("Open src: %v", err)
+	}
+	t.Cleanup(func() { src.Close() })
+	dst, err := Open(filepath.Join(t.TempDir(), "dst.folio"), Config{})
+	if err != nil {
+		t.Fatalf("Open dst: %v", err)
+	}
+	t.Cleanup(func() { dst.Close() })
+	return src, dst
+}
+
+// TestTransferMovesPrefix verifies that documents under the prefix
+// appear in the destination and vanish from the source, while labels
+// outside the prefix stay where they are. If the prefix filter were
+// wrong, a tenant migration would either leave data behind or take a
+// neighbouring tenant's documents with it.
+func TestTransferMovesPrefix(t *testing.T) {
+	src, dst := openPair(t)
+
+	src.Set("tenant-a/one", "1")
+	src.Set("tenant-a/two", "2")
+	src.Set("tenant-b/one", "other")
+
+	if err := Transfer(src, dst, "tenant-a/"); err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+
+	for _, lbl := range []string{"tenant-a/one", "tenant-a/two"} {
+		if _, err := src.Get(lbl); !errors.Is(err, ErrNotFound) {
+			t.Errorf("src.Get(%q) = %v, want ErrNotFound", lbl, err)
+		}
+	}
+	if got, _ := dst.Get("tenant-a/one"); got != "1" {
+		t.Errorf("dst.Get(tenant-a/one) = %q, want %q", got, "1")
+	}
+	if got, _ := dst.Get("tenant-a/two"); got != "2" {
+		t.Errorf("dst.Get(tenant-a/two) = %q, want %q", got, "2")
+	}
+	if got, _ := src.Get("tenant-b/one"); got != "other" {
+		t.Errorf("src.Get(tenant-b/one) = %q, want %q", got, "other")
+	}
+	if _, err := dst.Get("tenant-b/one"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("dst.Get(tenant-b/one) = %v, want ErrNotFound", err)
+	}
+	if src.Count() != 1 || dst.Count() != 2 {
+		t.Errorf("counts = src %d dst %d, want 1 and 2", src.Count(), dst.Count())
+	}
+}
+
+// TestTransferPreservesHistory verifies that every version travels with
+// the document and that the source retains its history after the move.
+// A migration that only copied current content would silently discard
+// the audit trail.
+func TestTransferPreservesHistory(t *testing.T) {
+	src, dst := openPair(t)
+
+	src.Set("ns/doc", "v1")
+	src.Set("ns/doc", "v2")
+	src.Set("ns/doc", "v3")
+
+	if err := Transfer(src, dst, "ns/"); err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+
+	versions, err := Collect(dst.History("ns/doc"))
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("dst History: got %d versions, want 3", len(versions))
+	}
+	for i, want := range []string{"v1", "v2", "v3"} {
+		if versions[i].Data != want {
+			t.Errorf("version %d = %q, want %q", i, versions[i].Data, want)
+		}
+	}
+
+	old, _ := Collect(src.History("ns/doc"))
+	if len(old) != 3 {
+		t.Errorf("src History after transfer: got %d versions, want 3", len(old))
+	}
+
+	// The moved history must survive compaction in the destination.
+	if err := dst.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	versions, _ = Collect(dst.History("ns/doc"))
+	if len(versions) != 3 {
+		t.Errorf("dst History after compact: got %d versions, want 3", len(versions))
+	}
+	if got, _ := dst.Get("ns/doc"); got != "v3" {
+		t.Errorf("dst.Get after compact = %q, want %q", got, "v3")
+	}
+}
+
+// TestTransferConflict verifies that a label already present in the
+// destination aborts the move with ErrExists and leaves both files
+// untouched. Overwriting would destroy the destination's copy;
+// a partial move would split the namespace across shards.
+func TestTransferConflict(t *testing.T) {
+	src, dst := openPair(t)
+
+	src.Set("ns/a", "src-a")
+	src.Set("ns/b", "src-b")
+	dst.Set("ns/b", "dst-b")
+
+	if err := Transfer(src, dst, "ns/"); !errors.Is(err, ErrExists) {
+		t.Fatalf("Transfer = %v, want ErrExists", err)
+	}
+	if got, _ := src.Get("ns/a"); got != "src-a" {
+		t.Errorf("src.Get(ns/a) = %q, want %q", got, "src-a")
+	}
+	if _, err := dst.Get("ns/a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("dst.Get(ns/a) = %v, want ErrNotFound", err)
+	}
+	if got, _ := dst.Get("ns/b"); got != "dst-b" {
+		t.Errorf("dst.Get(ns/b) = %q, want %q", got, "dst-b")
+	}
+}
+
+// TestTransferEscapedLabel verifies that a label with characters JSON
+// escapes is matched against the prefix and moved as written, not as
+// it is stored in the file.
+func TestTransferEscapedLabel(t *testing.T) {
+	src, dst := openPair(t)
+	for _, lbl := range []string{`ns\one`, "ns\ttwo"} {
+		if err := src.Set(lbl, lbl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Transfer(src, dst, "ns"); err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+	for _, lbl := range []string{`ns\one`, "ns\ttwo"} {
+		if got, err := dst.Get(lbl); err != nil || got != lbl {
+			t.Errorf("dst.Get(%q) = %q, %v; want %q", lbl, got, err, lbl)
+		}
+		if _, err := src.Get(lbl); !errors.Is(err, ErrNotFound) {
+			t.Errorf("src.Get(%q) = %v, want ErrNotFound", lbl, err)
+		}
+	}
+}
+
+// TestTransferSameFile verifies that a transfer from a database to
+// itself fails the same way whether it is given one handle twice or two
+// handles on one file, rather than reporting the move as done.
+func TestTransferSameFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Set("ns/a", "a")
+
+	same := Transfer(db, db, "ns/")
+	if same == nil {
+		t.Fatal("Transfer to the same handle succeeded")
+	}
+	other, err := Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := Transfer(db, other, "ns/"); err == nil || err.Error() != same.Error() {
+		t.Errorf("Transfer to another handle = %v, want %v", err, same)
+	}
+	if got, _ := db.Get("ns/a"); got != "a" {
+		t.Errorf("Get(ns/a) = %q, want %q", got, "a")
+	}
+}
+
+// TestTransferAcrossAlgorithms verifies that documents are re-hashed
+// with the destination's algorithm. Copying IDs verbatim would make
+// every moved document unreachable when the two files differ.
+func TestTransferAcrossAlgorithms(t *testing.T) {
+	src, err := Open(filepath.Join(t.TempDir(), "src.folio"), Config{HashAlgorithm: AlgXXHash3})
+	if err != nil {
+		t.Fatalf("Open src: %v", err)
+	}
+	defer src.Close()
+	dst, err := Open(filepath.Join(t.TempDir(), "dst.folio"), Config{HashAlgorithm: AlgFNV1a})
+	if err != nil {
+		t.Fatalf("Open dst: %v", err)
+	}
+	defer dst.Close()
+
+	src.Set("ns/doc", "content")
+	src.Compact()
+
+	if err := Transfer(src, dst, "ns/"); err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+	if got, err := dst.Get("ns/doc"); err != nil || got != "content" {
+		t.Errorf("dst.Get = %q, %v; want %q", got, err, "content")
+	}
+}
@@ -0,0 +1,207 @@
+// Optional in-memory trie of labels for prefix counts.
+//
+// CountPrefix otherwise scans the index section and sparse region the
+// way ListPrefix does. When enabled (Config.LabelTrie), a radix trie of
+// every current label is built at Open and kept up to date by each
+// write that creates, removes, or renames a document; compaction
+// rebuilds it from the new index section. Each node counts the labels
+// at or below it, so a count is a walk down the prefix, and kids are
+// kept in byte order, so ListSorted is a walk of the whole trie. Documents with
+// a TTL are also kept in a side map, usually small, so that those which
+// have expired can be left out of a count as they are from a listing.
+//
+// Like the bloom filter, the trie only sees writes made through this
+// handle, so it suits a process that is the file's only writer.
+package folio
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// trie is the set of current labels.
+type trie struct {
+	root     trieNode
+	expiring map[string]int64 // labels with a TTL → unix ms expiry
+}
+
+// trieNode is one node of the radix trie. edge is the label bytes from
+// its parent; kids are ordered by the first byte of their edge.
+type trieNode struct {
+	edge string
+	kids []*trieNode
+	leaf bool // a label ends here
+	n    int  // labels ending at or below this node
+}
+
+func newTrie() *trie {
+	return &trie{expiring: map[string]int64{}}
+}
+
+// put adds label, or updates its expiry if present. expires is 0 for a
+// document without a TTL.
+func (t *trie) put(label string, expires int64) {
+	if n := t.root.find(label); n == nil || !n.leaf {
+		t.root.insert(label)
+	}
+	if expires != 0 {
+		t.expiring[label] = expires
+	} else {
+		delete(t.expiring, label)
+	}
+}
+
+// remove deletes label if present.
+func (t *trie) remove(label string) {
+	if n := t.root.find(label); n != nil && n.leaf {
+		t.root.remove(label)
+	}
+	delete(t.expiring, label)
+}
+
+// count returns the number of labels starting with prefix that have not
+// expired at ts.
+func (t *trie) count(prefix string, ts int64) int {
+	n := t.root.count(prefix)
+	for lbl, ex := range t.expiring {
+		if ex <= ts && strings.HasPrefix(lbl, prefix) {
+			n--
+		}
+	}
+	return n
+}
+
+// walk calls fn with each label that has not expired at ts, in byte
+// order, until fn returns false.
+func (t *trie) walk(ts int64, fn func(string) bool) {
+	t.root.walk("", func(lbl string) bool {
+		if ex, ok := t.expiring[lbl]; ok && ex <= ts {
+			return true
+		}
+		return fn(lbl)
+	})
+}
+
+// kid returns the position of the kid whose edge starts with b, or where
+// it would be inserted.
+func (n *trieNode) kid(b byte) (int, bool) {
+	return slices.BinarySearchFunc(n.kids, b, func(k *trieNode, b byte) int {
+		return cmp.Compare(k.edge[0], b)
+	})
+}
+
+// find returns the node at which s ends, or nil if s runs off the trie.
+func (n *trieNode) find(s string) *trieNode {
+	for s != "" {
+		i, ok := n.kid(s[0])
+		if !ok || !strings.HasPrefix(s, n.kids[i].edge) {
+			return nil
+		}
+		n = n.kids[i]
+		s = s[len(n.edge):]
+	}
+	return n
+}
+
+// insert adds s, which must not be present, splitting an edge where s
+// leaves it.
+func (n *trieNode) insert(s string) {
+	for {
+		n.n++
+		if s == "" {
+			n.leaf = true
+			return
+		}
+		i, ok := n.kid(s[0])
+		if !ok {
+			n.kids = slices.Insert(n.kids, i, &trieNode{edge: s, leaf: true, n: 1})
+			return
+		}
+		k := n.kids[i]
+		c := 0
+		for c < len(s) && c < len(k.edge) && s[c] == k.edge[c] {
+			c++
+		}
+		if c < len(k.edge) {
+			mid := &trieNode{edge: k.edge[:c], kids: []*trieNode{k}, n: k.n}
+			k.edge = k.edge[c:]
+			n.kids[i] = mid
+			k = mid
+		}
+		n, s = k, s[c:]
+	}
+}
+
+// remove deletes s, which must be present, pruning empty nodes and
+// merging a node left with one kid and no label into that kid.
+func (n *trieNode) remove(s string) {
+	n.n--
+	if s == "" {
+		n.leaf = false
+		return
+	}
+	i, _ := n.kid(s[0])
+	k := n.kids[i]
+	k.remove(s[len(k.edge):])
+	switch {
+	case k.n == 0:
+		n.kids = slices.Delete(n.kids, i, i+1)
+	case !k.leaf && len(k.kids) == 1:
+		only := k.kids[0]
+		only.edge = k.edge + only.edge
+		n.kids[i] = only
+	}
+}
+
+// walk calls fn with each label at or below n, prefixed by the edges
+// above it, in byte order: a label before those it is a prefix of, and
+// kids in the order of their first byte. It returns false if fn did.
+func (n *trieNode) walk(prefix string, fn func(string) bool) bool {
+	if n.leaf && !fn(prefix) {
+		return false
+	}
+	for _, k := range n.kids {
+		if !k.walk(prefix+k.edge, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// count returns the number of labels in the trie starting with p.
+func (n *trieNode) count(p string) int {
+	for p != "" {
+		i, ok := n.kid(p[0])
+		if !ok {
+			return 0
+		}
+		k := n.kids[i]
+		if len(p) <= len(k.edge) {
+			if strings.HasPrefix(k.edge, p) {
+				return k.n
+			}
+			return 0
+		}
+		if !strings.HasPrefix(p, k.edge) {
+			return 0
+		}
+		n, p = k, p[len(k.edge):]
+	}
+	return n.n
+}
+
+// buildTrie fills the label trie from the index lines after the heap.
+// Called at Open and after compaction replaces the file.
+func (db *DB) buildTrie() error {
+	sz, err := size(db.reader)
+	if err != nil {
+		return err
+	}
+	db.labels = newTrie()
+	for _, e := range scanm(db.reader, max(db.indexStart(), HeaderSize), sz, TypeIndex) {
+		// scanm leaves the label JSON-escaped.
+		db.labels.put(string(unescape([]byte(e.Label))), e.Expires)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+// Label trie tests.
+//
+// CountPrefix and ListSorted give the same answer with or without the
+// trie, so each test checks the trie against the scan it replaces: first the trie on
+// its own against a brute-force count, then a database through every
+// kind of write that creates, removes, or renames a document.
+package folio
+
+import (
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTrieCounts verifies prefix counts and the ordered walk through
+// random inserts and removals, which split and merge edges in every
+// combination.
+func TestTrieCounts(t *testing.T) {
+	tr := newTrie()
+	live := map[string]bool{}
+	rng := rand.New(rand.NewPCG(1, 2))
+	alphabet := "ab/"
+	randLabel := func() string {
+		var b strings.Builder
+		for range 1 + rng.IntN(6) {
+			b.WriteByte(alphabet[rng.IntN(len(alphabet))])
+		}
+		return b.String()
+	}
+
+	for i := range 2000 {
+		lbl := randLabel()
+		if rng.IntN(3) == 0 {
+			tr.remove(lbl)
+			delete(live, lbl)
+		} else {
+			tr.put(lbl, 0)
+			live[lbl] = true
+		}
+		if i%50 != 0 {
+			continue
+		}
+		for _, p := range []string{"", "a", "ab", "b/", "a/b", randLabel()} {
+			want := 0
+			for l := range live {
+				if strings.HasPrefix(l, p) {
+					want++
+				}
+			}
+			if got := tr.count(p, 0); got != want {
+				t.Fatalf("step %d: count(%q) = %d, want %d", i, p, got, want)
+			}
+		}
+		var walked []string
+		tr.walk(0, func(lbl string) bool {
+			walked = append(walked, lbl)
+			return true
+		})
+		want := slices.Sorted(maps.Keys(live))
+		if !slices.Equal(walked, want) {
+			t.Fatalf("step %d: walk = %v, want %v", i, walked, want)
+		}
+	}
+}
+
+// TestCountPrefix verifies that CountPrefix with the trie matches the
+// scan after sets, deletes, renames, transactions, expiry, compaction,
+// and reopening.
+func TestCountPrefix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{LabelTrie: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { db.Close() }()
+
+	check := func(when string, want map[string]int) {
+		t.Helper()
+		for prefix, n := range want {
+			got, err := db.CountPrefix(prefix)
+			if err != nil || got != n {
+				t.Errorf("%s: CountPrefix(%q) = %d, %v; want %d", when, prefix, got, err, n)
+			}
+			saved := db.labels
+			db.labels = nil
+			scanned, err := db.CountPrefix(prefix)
+			db.labels = saved
+			if err != nil || scanned != n {
+				t.Errorf("%s: scanned CountPrefix(%q) = %d, %v; want %d", when, prefix, scanned, err, n)
+			}
+		}
+	}
+
+	for i := range 5 {
+		db.Set(fmt.Sprintf("config/db/%d", i), "x")
+		db.Set(fmt.Sprintf("config/app/%d", i), "x")
+		db.Set(fmt.Sprintf("users/%d", i), "x")
+	}
+	db.Set("config/db/0", "updated")
+	check("sets", map[string]int{"": 15, "config/": 10, "config/db/": 5, "users/": 5, "none": 0})
+
+	db.Delete("config/db/1")
+	db.DeleteMany("users/0", "users/1")
+	db.Rename("config/app/0", "config/xyz/0")  // same length
+	db.Rename("config/app/1", "archive/app/1") // appended
+	db.Txn(func(tx *Txn) error {
+		tx.Set("users/new", "x")
+		return tx.Delete("config/db/2")
+	})
+	want := map[string]int{"": 12, "config/": 7, "config/db/": 3, "config/app/": 3, "config/xyz/": 1, "users/": 4, "archive/": 1}
+	check("writes", want)
+
+	db.SetWithTTL("tmp/a", "x", time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	check("expired", map[string]int{"": 12, "tmp/": 0})
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check("compacted", want)
+
+	db.Close()
+	if db, err = Open(path, Config{LabelTrie: true}); err != nil {
+		t.Fatal(err)
+	}
+	check("reopened", want)
+}
+
+// TestListSorted verifies that ListSorted yields labels in byte order,
+// the same from the trie as from the scan, leaving out deleted and
+// expired documents.
+func TestListSorted(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{LabelTrie: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, lbl := range []string{"b", "a/2", "a", "c/x", "a/10", "B"} {
+		db.Set(lbl, "x")
+	}
+	db.Compact()
+	db.Set("a/1", "x")
+	db.Delete("c/x")
+	db.SetWithTTL("tmp", "x", time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	want := []string{"B", "a", "a/1", "a/10", "a/2", "b"}
+	got, err := Collect(db.ListSorted())
+	if err != nil || !slices.Equal(got, want) {
+		t.Errorf("ListSorted = %v, %v; want %v", got, err, want)
+	}
+	saved := db.labels
+	db.labels = nil
+	got, err = Collect(db.ListSorted())
+	db.labels = saved
+	if err != nil || !slices.Equal(got, want) {
+		t.Errorf("scanned ListSorted = %v, %v; want %v", got, err, want)
+	}
+}
@@ -0,0 +1,39 @@
+// Document expiry.
+//
+// SetWithTTL stores an absolute expiry time in the document's index
+// record as _ex (unix ms). Once that time has passed, Get, Exists, Info,
+// List, and ListInfo behave as if the document did not exist. Nothing is
+// written at expiry: the records stay on disk until the next Compact or
+// Purge drops the document with all of its history. All, AllInfo, and
+// Search read data records without their indexes, so they keep yielding
+// an expired document until then.
+//
+// Expiry belongs to a version, not to the label. A plain Set clears it,
+// and setting an expired label again starts a new document with a fresh
+// creation time. Rename carries the expiry to the new label; it refuses
+// an expired document as missing, and replaces one under the new label.
+// Transfer carries it to the destination in the same way.
+package folio
+
+import "time"
+
+// SetWithTTL creates or updates a document that expires ttl from now.
+func (db *DB) SetWithTTL(label, content string, ttl time.Duration) (err error) {
+	defer db.observe(OpSet, label, time.Now(), &err)
+
+	if err := db.checkDoc(label, content); err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	expiry := time.Now().Add(ttl).UnixMilli()
+	return db.single(func() error {
+		return db.setOne(label, content, expiry)
+	})
+}
+
+// expired reports whether the document had expired at unix ms time t.
+func (idx *Index) expired(t int64) bool {
+	return idx.Expires != 0 && idx.Expires <= t
+}
@@ -0,0 +1,198 @@
+// Document expiry tests.
+//
+// Expiry is enforced at read time and only made physical by compaction,
+// so both halves are checked: an expired document must vanish from every
+// lookup immediately, and Compact must remove its records from the file.
+package folio
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestTTLExpires verifies an expired document reads as absent from Get,
+// Exists, Info, List, and ListInfo, while a live one is unaffected.
+func TestTTLExpires(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := db.SetWithTTL("short", "gone soon", 10*time.Millisecond); err != nil {
+		t.Fatalf("SetWithTTL: %v", err)
+	}
+	db.SetWithTTL("long", "stays", time.Hour)
+	db.Set("plain", "forever")
+
+	if data, err := db.Get("short"); err != nil || data != "gone soon" {
+		t.Fatalf("Get before expiry = %q, %v", data, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := db.Get("short"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get = %v, want ErrNotFound", err)
+	}
+	if ok, _ := db.Exists("short"); ok {
+		t.Error("Exists = true for expired document")
+	}
+	if _, err := db.Info("short"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Info = %v, want ErrNotFound", err)
+	}
+	labels, _ := Collect(db.List())
+	if len(labels) != 2 {
+		t.Errorf("List = %v, want long and plain", labels)
+	}
+	infos, _ := Collect(db.ListInfo())
+	if len(infos) != 2 {
+		t.Errorf("ListInfo = %v, want long and plain", infos)
+	}
+	if data, _ := db.Get("long"); data != "stays" {
+		t.Errorf("Get(long) = %q", data)
+	}
+}
+
+// TestTTLCompactDrops verifies Compact removes an expired document with
+// its history and keeps the expiry of documents that are still live.
+func TestTTLCompactDrops(t *testing.T) {
+	db := openTestDB(t)
+
+	db.Set("cache", "v1")
+	db.SetWithTTL("cache", "v2", 10*time.Millisecond)
+	db.SetWithTTL("live", "data", time.Hour)
+	time.Sleep(20 * time.Millisecond)
+
+	if err := db.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if versions, _ := Collect(db.History("cache")); len(versions) != 0 {
+		t.Errorf("History after Compact = %d versions, want 0", len(versions))
+	}
+	if docs, _ := Collect(db.All()); len(docs) != 1 || docs[0].Label != "live" {
+		t.Errorf("All after Compact = %v, want only live", docs)
+	}
+	if n := db.count.Load(); n != 1 {
+		t.Errorf("count = %d, want 1", n)
+	}
+
+	sz, _ := size(db.reader)
+	result, idx, err := db.findIndex(hash("live", db.header.Algorithm), "live", sz)
+	if err != nil || result == nil {
+		t.Fatalf("findIndex(live) = %v, %v", result, err)
+	}
+	if idx.Expires == 0 {
+		t.Error("Compact cleared the expiry of a live document")
+	}
+}
+
+// TestTTLSetClears verifies a plain Set removes the expiry, and that
+// rewriting an expired label starts a new document.
+func TestTTLSetClears(t *testing.T) {
+	db := openTestDB(t)
+
+	db.SetWithTTL("a", "v1", 10*time.Millisecond)
+	db.Set("a", "v2")
+	db.SetWithTTL("b", "v1", 10*time.Millisecond)
+	first, _ := db.Info("b")
+	time.Sleep(20 * time.Millisecond)
+
+	if data, err := db.Get("a"); err != nil || data != "v2" {
+		t.Errorf("Get(a) = %q, %v; Set should clear the TTL", data, err)
+	}
+
+	db.Set("b", "v2")
+	second, err := db.Info("b")
+	if err != nil {
+		t.Fatalf("Info: %v", err)
+	}
+	if second.Created <= first.Created {
+		t.Errorf("Created = %d, want a fresh time after %d", second.Created, first.Created)
+	}
+}
+
+// TestTTLRename verifies Rename treats an expired document as absent on
+// both sides, as Get, Set, and Txn.Rename do: an expired source is not
+// found, and an expired target is replaced, whether it lies in the
+// sparse region or the sorted index and whether the rename patches in
+// place or appends.
+func TestTTLRename(t *testing.T) {
+	for _, tc := range []struct {
+		name, from string
+		compact    bool
+	}{
+		{"sparse in place", "aa", false},
+		{"sparse appended", "a", false},
+		{"sorted in place", "aa", true},
+		{"sorted appended", "a", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := openTestDB(t)
+			db.SetWithTTL("gone", "expired", 10*time.Millisecond)
+			db.SetWithTTL("bb", "expired", 10*time.Millisecond)
+			if tc.compact {
+				db.Compact()
+			}
+			time.Sleep(20 * time.Millisecond)
+			db.Set(tc.from, "live")
+
+			if err := db.Rename("gone", "new"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Rename of an expired source = %v, want ErrNotFound", err)
+			}
+			if err := db.Rename(tc.from, "bb"); err != nil {
+				t.Fatalf("Rename onto an expired target: %v", err)
+			}
+			if data, err := db.Get("bb"); err != nil || data != "live" {
+				t.Errorf("Get(bb) = %q, %v; want the renamed document", data, err)
+			}
+			if ok, _ := db.Exists(tc.from); ok {
+				t.Errorf("%s still exists", tc.from)
+			}
+			if got := db.Count(); got != 2 {
+				t.Errorf("Count = %d, want 2: the renamed document and the expired source", got)
+			}
+			if rep, err := db.Verify(VerifyOptions{}); err != nil || !rep.OK() {
+				t.Errorf("Verify = %+v, %v", rep.Problems, err)
+			}
+		})
+	}
+}
+
+// TestTTLTransfer verifies Transfer carries a document's expiry to the
+// destination, leaves an expired source behind rather than reviving it,
+// and replaces an expired document under the label in the destination.
+func TestTTLTransfer(t *testing.T) {
+	src, dst := openPair(t)
+	src.SetWithTTL("ns/gone", "expired", 10*time.Millisecond)
+	src.SetWithTTL("ns/later", "live", time.Hour)
+	src.Set("ns/bb", "live")
+	dst.SetWithTTL("ns/bb", "expired", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	if err := Transfer(src, dst, "ns/"); err != nil {
+		t.Fatalf("Transfer onto an expired document: %v", err)
+	}
+	if _, err := dst.Get("ns/gone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("dst.Get(ns/gone) = %v, want ErrNotFound", err)
+	}
+	if info, err := dst.Info("ns/later"); err != nil || info.Expires == 0 {
+		t.Errorf("dst.Info(ns/later) = %+v, %v; want the expiry carried", info, err)
+	}
+	if data, err := dst.Get("ns/bb"); err != nil || data != "live" {
+		t.Errorf("dst.Get(ns/bb) = %q, %v; want the moved document", data, err)
+	}
+	if got := dst.Count(); got != 2 {
+		t.Errorf("dst.Count = %d, want 2", got)
+	}
+	if rep, err := dst.Verify(VerifyOptions{}); err != nil || !rep.OK() {
+		t.Errorf("Verify = %+v, %v", rep.Problems, err)
+	}
+}
+
+// TestTTLInvalid verifies a non-positive TTL is rejected rather than
+// writing a document that is already expired.
+func TestTTLInvalid(t *testing.T) {
+	db := openTestDB(t)
+	if err := db.SetWithTTL("doc", "data", 0); !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("SetWithTTL(0) = %v, want ErrInvalidTTL", err)
+	}
+	if ok, _ := db.Exists("doc"); ok {
+		t.Error("document written despite invalid TTL")
+	}
+}
@@ -0,0 +1,532 @@
+// Atomic multi-document transactions.
+//
+// A Txn stages Set, Delete, and Rename operations in memory while the
+// caller's function runs, then commits them with a single append: a
+// transaction record (_r=5) followed by the new data and index records,
+// and a tombstone for each delete (see journal.go).
+// The superseded versions are retired afterwards with the usual in-place
+// patches (see delete.go), synced once between them. Readers never observe a partial transaction
+// because the write lock is held throughout.
+//
+// A crash is what could tear one apart, so the transaction record makes
+// the append self-checking and the retirements replayable. It carries
+// the byte length and CRC-32C of the lines that follow it, and the labels
+// whose earlier versions the transaction retires:
+//
+//	{"_r":5,"_id":"0000000000000000","_ts":1706000000000,"_n":412,"_k":"1a2b3c4d","_t":["a","b"]}
+//
+// When Open finds a dirty file it settles every transaction record in the
+// sparse region before repairing. If the following bytes do not match,
+// the append was torn: the record and everything after it are blanked,
+// so none of the transaction survives. Otherwise every live index for a
+// listed label that precedes the record is retired, which completes any
+// patches the crash interrupted and is a no-op for those already done.
+// Compaction drops transaction records once settled.
+//
+// Only the final state of each label is written. Setting a label twice
+// in one transaction records one version, not two.
+package folio
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// TypeTxn marks a transaction record. Like the metadata record it has
+// no label and no index, and is never returned by lookups or scans.
+const TypeTxn = 5
+
+// txnRecord heads the lines appended by one transaction.
+type txnRecord struct {
+	Type      int      `json:"_r"`
+	ID        string   `json:"_id"` // metaID placeholder
+	Timestamp int64    `json:"_ts"`
+	Length    int64    `json:"_n"`           // bytes of the lines that follow
+	Checksum  string   `json:"_k"`           // CRC-32C of those bytes, 8 hex digits
+	Retire    []string `json:"_t,omitempty"` // labels whose earlier versions are retired
+}
+
+// Txn stages operations for Txn. Its methods validate eagerly and see
+// the effect of earlier operations in the same transaction.
+type Txn struct {
+	db    *DB
+	docs  map[string]*txnDoc // by label, folded in a case-insensitive file
+	order []string           // keys of docs in first-touch order, for a deterministic layout
+}
+
+// txnDoc is the staged state of one label.
+type txnDoc struct {
+	label   string  // as it will be written: the stored one while the document exists
+	result  *Result // index of the version the transaction retires, nil if none
+	idx     *Index
+	live    bool // result is a current, unexpired document
+	present bool // the label exists once the transaction commits
+	loaded  bool // content holds the document's content
+	content string
+	created int64
+	expires int64
+	written bool // content must be written at commit
+}
+
+// Txn runs fn and commits the operations it staged as one atomic write.
+// If fn returns an error, nothing is written and the error is returned.
+// The write lock is held while fn runs, so fn must not call methods on
+// db itself; use tx for reads and writes instead.
+func (db *DB) Txn(fn func(tx *Txn) error) (err error) {
+	defer db.observe(OpTxn, "", time.Now(), &err)
+
+	if err := db.blockWrite(); err != nil {
+		return err
+	}
+
+	tx := &Txn{db: db, docs: map[string]*txnDoc{}}
+	err = fn(tx)
+	if err == nil {
+		err = tx.commit()
+	}
+
+	// Same pattern as Set: check threshold under lock, compact after release.
+	compact := err == nil && db.shouldCompact()
+	events := db.takeEvents()
+	db.mu.Unlock()
+	db.lock.Unlock()
+
+	if err == nil {
+		err = db.durable()
+	}
+	db.after(events)
+	if compact {
+		db.Compact()
+	}
+	return err
+}
+
+// lookup returns the staged state of label, loading it from the file
+// the first time the label is touched.
+func (tx *Txn) lookup(label string) (*txnDoc, error) {
+	key := tx.db.fold(label)
+	if d, ok := tx.docs[key]; ok {
+		return d, nil
+	}
+	sz, err := size(tx.db.reader)
+	if err != nil {
+		return nil, fmt.Errorf("txn: stat: %w", err)
+	}
+	result, idx, err := tx.db.findIndex(tx.db.id(label), label, sz)
+	if err != nil {
+		return nil, fmt.Errorf("txn: %w", err)
+	}
+	d := &txnDoc{label: label, result: result, idx: idx}
+	if result != nil && !idx.expired(now()) {
+		d.live, d.present = true, true
+		d.label, d.created, d.expires = idx.Label, idx.Created, idx.Expires
+	}
+	tx.docs[key] = d
+	tx.order = append(tx.order, key)
+	return d, nil
+}
+
+// load reads the content of a document that exists in the file.
+func (tx *Txn) load(d *txnDoc) error {
+	if d.loaded {
+		return nil
+	}
+	data, err := line(tx.db.reader, d.idx.Offset)
+	if err != nil {
+		return fmt.Errorf("txn: read record: %w", faultAt(d.label, d.idx.Offset, err))
+	}
+	record, err := tx.db.decode(data)
+	if err != nil {
+		return fmt.Errorf("txn: %w", faultAt(d.label, d.idx.Offset, err))
+	}
+	d.content, d.loaded = record.Data, true
+	return nil
+}
+
+// Get returns the content of label as the transaction currently sees it.
+func (tx *Txn) Get(label string) (string, error) {
+	d, err := tx.lookup(label)
+	if err != nil {
+		return "", err
+	}
+	if !d.present {
+		return "", ErrNotFound
+	}
+	if err := tx.load(d); err != nil {
+		return "", err
+	}
+	return d.content, nil
+}
+
+// Set stages a create or update of label. A plain Set clears any expiry,
+// as it does outside a transaction.
+func (tx *Txn) Set(label, content string) error {
+	if err := tx.db.checkDoc(label, content); err != nil {
+		return err
+	}
+	d, err := tx.lookup(label)
+	if err != nil {
+		return err
+	}
+	if !d.present {
+		d.label, d.created = label, 0 // created is stamped at commit
+	}
+	d.present, d.loaded, d.written = true, true, true
+	d.content, d.expires = content, 0
+	return nil
+}
+
+// Delete stages the removal of label, or returns ErrNotFound.
+func (tx *Txn) Delete(label string) error {
+	d, err := tx.lookup(label)
+	if err != nil {
+		return err
+	}
+	if !d.present {
+		return ErrNotFound
+	}
+	d.present, d.written = false, false
+	return nil
+}
+
+// Rename stages a label change with the same rules as DB.Rename. Inside
+// a transaction the document is always rewritten under the new label,
+// never patched in place, so the change commits with everything else.
+func (tx *Txn) Rename(old, new string) error {
+	if old == "" {
+		return ErrInvalidLabel
+	}
+	if err := tx.db.checkLabel(new); err != nil {
+		return err
+	}
+	if old == new {
+		return nil
+	}
+	src, err := tx.lookup(old)
+	if err != nil {
+		return err
+	}
+	if !src.present {
+		return ErrNotFound
+	}
+	if tx.db.same(old, new) {
+		// A case-insensitive file, and only the case changes.
+		if err := tx.load(src); err != nil {
+			return err
+		}
+		src.label, src.written = new, true
+		return nil
+	}
+	dst, err := tx.lookup(new)
+	if err != nil {
+		return err
+	}
+	if dst.present {
+		return ErrExists
+	}
+	if err := tx.load(src); err != nil {
+		return err
+	}
+
+	dst.present, dst.loaded, dst.written = true, true, true
+	dst.label, dst.content, dst.created, dst.expires = new, src.content, src.created, src.expires
+	src.present, src.written = false, false
+	return nil
+}
+
+// commit writes the staged state. The write lock must be held.
+func (tx *Txn) commit() error {
+	db := tx.db
+	ts := now()
+	if err := tx.before(); err != nil {
+		return err
+	}
+
+	// Record lines are sealed once: with encryption each seal draws a
+	// fresh nonce, so they must not be rebuilt while offsets settle.
+	type pending struct {
+		key    string
+		record []byte
+		index  *Index
+	}
+	var writes []pending
+	var retire []string
+	for _, key := range tx.order {
+		d := tx.docs[key]
+		// A rewritten label retires whatever version it had, expired or
+		// not; a label that was only read is left alone.
+		if d.result != nil && (d.written || d.live && !d.present) {
+			retire = append(retire, key)
+		}
+		if !d.written {
+			continue
+		}
+		id := db.id(d.label)
+		if d.live {
+			if err := db.versionRoom(id, d.label, db.tail); err != nil {
+				return fmt.Errorf("txn: %w", err)
+			}
+		}
+		record := &Record{Type: TypeRecord, ID: id, Label: d.label, Timestamp: ts}
+		db.seal(record, d.content)
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("txn: %w", err)
+		}
+		ct := d.created
+		if ct == 0 {
+			ct = ts
+		}
+		writes = append(writes, pending{key, data, &Index{
+			Type:      TypeIndex,
+			ID:        id,
+			Label:     d.label,
+			Timestamp: ts,
+			Created:   ct,
+			Expires:   d.expires,
+		}})
+	}
+	if len(writes) == 0 && len(retire) == 0 {
+		return nil
+	}
+	// Deletes are dated by tombstones after the new records, inside the
+	// checked body, so a torn transaction loses them with the rest.
+	var tombs []byte
+	for _, key := range tx.order {
+		if d := tx.docs[key]; d.live && !d.present {
+			data, err := json.Marshal(tombstone{Type: TypeTombstone, ID: db.id(d.idx.Label), Timestamp: ts, Label: d.idx.Label})
+			if err != nil {
+				return fmt.Errorf("txn: %w", err)
+			}
+			tombs = append(append(tombs, data...), '\n')
+		}
+	}
+	if len(tombs) > 0 {
+		if err := db.stamp(); err != nil {
+			return fmt.Errorf("txn: %w", err)
+		}
+	}
+
+	// Index offsets depend on the transaction record's length, which
+	// depends on the body length. The checksum is fixed-width, so the
+	// length only changes when _n gains a digit; a few passes settle it.
+	head := txnRecord{Type: TypeTxn, ID: metaID, Timestamp: ts, Retire: retire}
+	var headLine, body []byte
+	for {
+		var err error
+		if headLine, err = json.Marshal(head); err != nil {
+			return fmt.Errorf("txn: %w", err)
+		}
+		base := db.tail + int64(len(headLine)) + 1
+		body = body[:0]
+		for _, w := range writes {
+			w.index.Offset = base + int64(len(body))
+			iData, err := json.Marshal(w.index)
+			if err != nil {
+				return fmt.Errorf("txn: %w", err)
+			}
+			body = append(body, w.record...)
+			body = append(body, '\n')
+			body = append(body, iData...)
+			body = append(body, '\n')
+		}
+		body = append(body, tombs...)
+		sum := fmt.Sprintf("%08x", checksum(body))
+		if head.Length == int64(len(body)) && head.Checksum == sum {
+			break
+		}
+		head.Length, head.Checksum = int64(len(body)), sum
+	}
+
+	buf := make([]byte, 0, len(headLine)+1+len(body))
+	buf = append(buf, headLine...)
+	buf = append(buf, '\n')
+	buf = append(buf, body...)
+	// raw() appends the final newline of the last line.
+	if _, err := db.raw(buf[:len(buf)-1]); err != nil {
+		return fmt.Errorf("txn: %w", err)
+	}
+
+	// The transaction record is durable (with SyncWrites) before any
+	// retirement, and settle replays them after a crash, so the patches
+	// need only one sync between them.
+	if err := db.retire(retire, tx.docs); err != nil {
+		return fmt.Errorf("txn: %w", err)
+	}
+	for _, key := range retire {
+		d := tx.docs[key]
+		old := d.idx.Label
+		gone := !d.present || d.label != old // no document has the old label now
+		if d.live && !d.present {
+			db.count.Add(^uint64(0)) // unsigned decrement
+			db.ops.deletes.Add(1)
+		}
+		if d.live && gone && db.labels != nil {
+			db.labels.remove(old)
+		}
+		// Deleted, renamed away, or deleted and created afresh: the
+		// document its tags were given to is gone.
+		if d.live && (gone || d.created != d.idx.Created) {
+			if err := db.dropTags(old); err != nil {
+				return fmt.Errorf("txn: %w", err)
+			}
+		}
+		if gone {
+			db.unindex(old)
+		}
+		db.usage.writes.Add(1)
+	}
+	for _, w := range writes {
+		db.ops.sets.Add(1)
+		if tx.docs[w.key].result == nil {
+			db.count.Add(1)
+			db.usage.writes.Add(1)
+		}
+		if db.bloom != nil {
+			db.bloom.Add(w.index.ID)
+		}
+		if db.labels != nil {
+			db.labels.put(w.index.Label, w.index.Expires)
+		}
+		if err := db.reindex(w.index.Label); err != nil {
+			return fmt.Errorf("txn: %w", err)
+		}
+	}
+	tx.wrote()
+	return nil
+}
+
+// before runs the before hooks for every label the transaction changes,
+// ahead of writing any of them.
+func (tx *Txn) before() error {
+	for _, key := range tx.order {
+		d := tx.docs[key]
+		var err error
+		switch {
+		case d.written:
+			err = tx.db.beforeSet(d.label, d.content)
+		case d.live && !d.present:
+			err = tx.db.beforeDelete(d.idx.Label)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wrote queues the after hooks for every label the transaction changed.
+func (tx *Txn) wrote() {
+	for _, key := range tx.order {
+		switch d := tx.docs[key]; {
+		case d.written:
+			tx.db.wroteSet(d.label, d.content)
+		case d.live && !d.present:
+			tx.db.wroteDelete(d.idx.Label)
+		}
+	}
+}
+
+// retire blanks the retired version of each label, syncing once at the
+// end rather than after every patch.
+func (db *DB) retire(labels []string, docs map[string]*txnDoc) error {
+	db.lazySync = true
+	defer func() { db.lazySync = false }()
+	for _, lbl := range labels {
+		d := docs[lbl]
+		if err := blank(db, d.idx.Offset, d.result); err != nil {
+			return err
+		}
+	}
+	if len(labels) > 0 {
+		return db.sync()
+	}
+	return nil
+}
+
+// settle completes or discards the transactions in the sparse region
+// after a crash. See the package comment. Called by Open before repair,
+// with exclusive access to the file.
+func (db *DB) settle() error {
+	sz, err := size(db.reader)
+	if err != nil {
+		return fmt.Errorf("settle: stat: %w", err)
+	}
+	for _, e := range scanm(db.reader, db.sparseStart(), sz, TypeTxn) {
+		data, err := line(db.reader, e.SrcOff)
+		if err != nil {
+			return fmt.Errorf("settle: %w", err)
+		}
+		var head txnRecord
+		if err := json.Unmarshal(data, &head); err != nil {
+			return db.wipe(e.SrcOff, sz)
+		}
+
+		start := e.SrcOff + int64(e.Length) + 1
+		body := make([]byte, head.Length)
+		if start+head.Length > sz {
+			return db.wipe(e.SrcOff, sz)
+		}
+		if _, err := db.reader.ReadAt(body, start); err != nil {
+			return fmt.Errorf("settle: %w", err)
+		}
+		if want, err := strconv.ParseUint(head.Checksum, 16, 32); err != nil || uint32(want) != checksum(body) {
+			return db.wipe(e.SrcOff, sz)
+		}
+
+		for _, lbl := range head.Retire {
+			if err := db.retireBefore(lbl, e.SrcOff, sz); err != nil {
+				return fmt.Errorf("settle: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// retireBefore retires every live index for label that lies before off.
+func (db *DB) retireBefore(label string, off, sz int64) error {
+	id := db.id(label)
+	var found []Result
+	if r := db.scanIndex(id); r != nil {
+		found = append(found, *r)
+	}
+	found = append(found, sparse(db.reader, id, db.sparseStart(), off, TypeIndex)...)
+	for i := range found {
+		idx, err := decodeIndex(found[i].Data)
+		if err != nil {
+			return faultAt(label, found[i].Offset, err)
+		}
+		if !db.same(idx.Label, label) || found[i].Offset >= off {
+			continue
+		}
+		if err := blank(db, idx.Offset, &found[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wipe blanks everything from off to end, keeping newlines so the
+// remaining lines stay short enough to scan. Used to discard a torn
+// transaction, which is always the last thing written before the crash.
+func (db *DB) wipe(off, end int64) error {
+	buf := make([]byte, end-off)
+	if _, err := db.reader.ReadAt(buf, off); err != nil {
+		return fmt.Errorf("settle: %w", err)
+	}
+	for i, b := range buf {
+		if b != '\n' {
+			buf[i] = ' '
+		}
+	}
+	if err := db.writeAt(off, buf); err != nil {
+		return fmt.Errorf("settle: %w", err)
+	}
+	if db.sizes != nil {
+		db.sizes.lost()
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+// Transaction tests.
+//
+// A transaction is only useful if it is all-or-nothing in both failure
+// modes: the caller's function returning an error, and the process dying
+// partway through the commit. The crash cases rebuild the file as it
+// would be at each point of the commit and check what Open recovers.
+package folio
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestTxnCommit verifies Set, Delete, and Rename all take effect and the
+// document count follows.
+func TestTxnCommit(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("a", "one")
+	db.Set("b", "two")
+
+	err := db.Txn(func(tx *Txn) error {
+		if err := tx.Set("c", "three"); err != nil {
+			return err
+		}
+		if err := tx.Delete("a"); err != nil {
+			return err
+		}
+		return tx.Rename("b", "d")
+	})
+	if err != nil {
+		t.Fatalf("Txn: %v", err)
+	}
+
+	labels, _ := Collect(db.List())
+	slices.Sort(labels)
+	if !slices.Equal(labels, []string{"c", "d"}) {
+		t.Errorf("List = %v, want [c d]", labels)
+	}
+	if data, _ := db.Get("d"); data != "two" {
+		t.Errorf("Get(d) = %q, want two", data)
+	}
+	if db.Count() != 2 {
+		t.Errorf("Count = %d, want 2", db.Count())
+	}
+	if versions, _ := Collect(db.History("a")); len(versions) != 1 {
+		t.Errorf("History(a) = %d versions, want the deleted one kept", len(versions))
+	}
+}
+
+// TestTxnRollback verifies an error from fn leaves the file untouched.
+func TestTxnRollback(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("a", "one")
+	before := dbsize(t, db)
+
+	boom := errors.New("boom")
+	err := db.Txn(func(tx *Txn) error {
+		tx.Set("b", "two")
+		tx.Delete("a")
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("Txn = %v, want boom", err)
+	}
+	if dbsize(t, db) != before {
+		t.Error("file grew after a rolled-back transaction")
+	}
+	if data, _ := db.Get("a"); data != "one" {
+		t.Errorf("Get(a) = %q, want one", data)
+	}
+	if ok, _ := db.Exists("b"); ok {
+		t.Error("b exists after rollback")
+	}
+}
+
+// TestTxnSeesOwnWrites verifies operations observe earlier operations in
+// the same transaction, and that a read alone changes nothing.
+func TestTxnSeesOwnWrites(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("a", "one")
+	db.Set("x", "keep")
+
+	db.Txn(func(tx *Txn) error {
+		if data, _ := tx.Get("x"); data != "keep" {
+			t.Errorf("tx.Get(x) = %q", data)
+		}
+		tx.Set("b", "new")
+		if data, _ := tx.Get("b"); data != "new" {
+			t.Errorf("tx.Get(b) = %q, want the staged value", data)
+		}
+		tx.Delete("a")
+		if _, err := tx.Get("a"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("tx.Get(a) after Delete = %v", err)
+		}
+		if err := tx.Rename("b", "x"); !errors.Is(err, ErrExists) {
+			t.Errorf("Rename onto x = %v, want ErrExists", err)
+		}
+		if err := tx.Delete("a"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("second Delete = %v, want ErrNotFound", err)
+		}
+		return nil
+	})
+
+	if data, err := db.Get("x"); err != nil || data != "keep" {
+		t.Errorf("Get(x) = %q, %v; a read must not retire the document", data, err)
+	}
+}
+
+// crashTxn runs a Set "a" / Delete "b" transaction and returns the file
+// as it was before the commit and the bytes the commit appended. The
+// prefix is still dirty, as it would be after a crash.
+func crashTxn(t *testing.T) (before, appended []byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.folio")
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Set("a", "old")
+	db.Set("b", "doomed")
+
+	before, _ = os.ReadFile(path)
+	if err := db.Txn(func(tx *Txn) error {
+		tx.Set("a", "new")
+		return tx.Delete("b")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.ReadFile(path)
+	return before, after[len(before):]
+}
+
+// reopen writes data to a new file and opens it, running recovery.
+func reopen(t *testing.T, data []byte) *DB {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.folio")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestTxnCrashBeforeRetire verifies a crash after the append but before
+// the old versions are patched still yields the committed state: Open
+// replays the retirements listed in the transaction record.
+func TestTxnCrashBeforeRetire(t *testing.T) {
+	before, appended := crashTxn(t)
+	db := reopen(t, append(before, appended...))
+
+	if data, _ := db.Get("a"); data != "new" {
+		t.Errorf("Get(a) = %q, want new", data)
+	}
+	if ok, _ := db.Exists("b"); ok {
+		t.Error("b survived a committed delete")
+	}
+	docs, _ := Collect(db.All())
+	if len(docs) != 1 {
+		t.Errorf("All = %v, want only the new a", docs)
+	}
+}
+
+// TestTxnCrashTorn verifies a partial append discards the whole
+// transaction, including when the cut falls exactly on a line boundary
+// and every surviving line is well formed.
+func TestTxnCrashTorn(t *testing.T) {
+	before, appended := crashTxn(t)
+	first := slices.Index(appended, '\n') + 1
+	second := first + slices.Index(appended[first:], '\n') + 1
+
+	for name, cut := range map[string]int{
+		"header only":   first,
+		"line boundary": second,
+		"mid line":      second + 10,
+		"last byte":     len(appended) - 1,
+	} {
+		t.Run(name, func(t *testing.T) {
+			data := append(slices.Clone(before), appended[:cut]...)
+			db := reopen(t, data)
+			if got, _ := db.Get("a"); got != "old" {
+				t.Errorf("Get(a) = %q, want old", got)
+			}
+			if got, _ := db.Get("b"); got != "doomed" {
+				t.Errorf("Get(b) = %q, want doomed", got)
+			}
+		})
+	}
+}
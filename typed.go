@@ -0,0 +1,64 @@
+// Typed documents: Go values stored as JSON.
+//
+// SetJSON, GetJSON and AllJSON marshal and unmarshal with go-json, the
+// same encoder the file format uses, so a program storing structs need
+// not write the encoding around every Set and Get. They are functions
+// rather than methods, as Go methods cannot take type parameters. The
+// stored content is ordinary JSON: Search, CreateIndex and Query see it
+// as they would content written by Set.
+package folio
+
+import (
+	"fmt"
+	"iter"
+
+	json "github.com/goccy/go-json"
+)
+
+// JSONDocument is a document whose content has been decoded into a T.
+type JSONDocument[T any] struct {
+	Label string
+	Value T
+}
+
+// SetJSON stores value, marshalled to JSON, as label's content.
+func SetJSON[T any](db *DB, label string, value T) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("setjson: %w", err)
+	}
+	return db.Set(label, string(data))
+}
+
+// GetJSON returns label's content unmarshalled into a T. Returns
+// ErrNotFound if label does not exist.
+func GetJSON[T any](db *DB, label string) (T, error) {
+	var value T
+	content, err := db.Get(label)
+	if err != nil {
+		return value, err
+	}
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return value, fmt.Errorf("getjson: %s: %w", label, err)
+	}
+	return value, nil
+}
+
+// AllJSON yields every current document with its content unmarshalled
+// into a T, as All does. A document that does not decode is yielded with
+// its label and an error, and the scan goes on if the caller does.
+func AllJSON[T any](db *DB) iter.Seq2[JSONDocument[T], error] {
+	return func(yield func(JSONDocument[T], error) bool) {
+		for d, err := range db.All() {
+			doc := JSONDocument[T]{Label: d.Label}
+			if err == nil {
+				if jerr := json.Unmarshal([]byte(d.Data), &doc.Value); jerr != nil {
+					err = fmt.Errorf("alljson: %s: %w", d.Label, jerr)
+				}
+			}
+			if !yield(doc, err) {
+				return
+			}
+		}
+	}
+}
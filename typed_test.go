@@ -0,0 +1,68 @@
+package folio
+
+import (
+	"errors"
+	"testing"
+)
+
+type typedDoc struct {
+	Name  string   `json:"name"`
+	Count int      `json:"count"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// TestJSONRoundTrip verifies that SetJSON and GetJSON store and return
+// a struct, and that the stored content is plain JSON.
+func TestJSONRoundTrip(t *testing.T) {
+	db := openTestDB(t)
+
+	want := typedDoc{Name: "widget", Count: 3, Tags: []string{"a", "b"}}
+	if err := SetJSON(db, "w", want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := GetJSON[typedDoc](db, "w")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != want.Name || got.Count != want.Count || len(got.Tags) != 2 {
+		t.Errorf("GetJSON = %+v, want %+v", got, want)
+	}
+	if raw, _ := db.Get("w"); raw != `{"name":"widget","count":3,"tags":["a","b"]}` {
+		t.Errorf("stored content = %s", raw)
+	}
+
+	if _, err := GetJSON[typedDoc](db, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetJSON(missing) = %v, want ErrNotFound", err)
+	}
+	db.Set("text", "not json")
+	if _, err := GetJSON[typedDoc](db, "text"); err == nil {
+		t.Error("GetJSON of non-JSON content succeeded")
+	}
+}
+
+// TestAllJSON verifies that AllJSON decodes every document and reports
+// the ones that do not decode without stopping.
+func TestAllJSON(t *testing.T) {
+	db := openTestDB(t)
+	SetJSON(db, "a", typedDoc{Name: "a", Count: 1})
+	SetJSON(db, "b", typedDoc{Name: "b", Count: 2})
+	db.Set("c", "not json")
+
+	sum, failed := 0, 0
+	for d, err := range AllJSON[typedDoc](db) {
+		if err != nil {
+			if d.Label != "c" {
+				t.Errorf("error for %q: %v", d.Label, err)
+			}
+			failed++
+			continue
+		}
+		if d.Value.Name != d.Label {
+			t.Errorf("%s decoded as %+v", d.Label, d.Value)
+		}
+		sum += d.Value.Count
+	}
+	if sum != 3 || failed != 1 {
+		t.Errorf("sum = %d, failed = %d; want 3, 1", sum, failed)
+	}
+}
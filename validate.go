@@ -0,0 +1,62 @@
+// Content validation.
+//
+// Config.Validator checks the content of every document about to be
+// written, so data the application considers malformed is never
+// committed. It is called once the built-in checks on the label and
+// content have passed, by Set, Create, Update, SetWithTTL, SetBytes,
+// SetReader, SetAsync, Batch, a Txn, ImportDir, Apply, and Merge, for each
+// document they write, and by Import for the current version of each
+// document; history it carries in is not checked. Copy, Revert, Rename,
+// and Touch write no new content and are not checked.
+//
+// A rejection is a *ValidationError naming the label, which wraps both
+// ErrInvalidContent and the validator's own error, so callers can test
+// for either with errors.Is and read the label with errors.As. A Batch
+// rejected for one document writes none of them; in a Txn, the
+// rejection is returned by Txn.Set.
+//
+// The validator may run with the write lock held, so it must not call
+// db. ValidJSON is a validator for files that hold only JSON; a schema
+// check is a function that unmarshals and inspects the content.
+package folio
+
+import (
+	"fmt"
+
+	json "github.com/goccy/go-json"
+)
+
+// ValidationError is a write rejected by Config.Validator.
+type ValidationError struct {
+	Label string // the document whose content was rejected
+	Err   error  // the validator's error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s: %v", ErrInvalidContent, e.Label, e.Err)
+}
+
+// Unwrap returns ErrInvalidContent and the validator's error.
+func (e *ValidationError) Unwrap() []error {
+	return []error{ErrInvalidContent, e.Err}
+}
+
+// ValidJSON is a Config.Validator that accepts only valid JSON.
+func ValidJSON(label string, content []byte) error {
+	if !json.Valid(content) {
+		return fmt.Errorf("not valid JSON")
+	}
+	return nil
+}
+
+// validate runs Config.Validator on content about to be written as
+// label.
+func (db *DB) validate(label, content string) error {
+	if db.config.Validator == nil {
+		return nil
+	}
+	if err := db.config.Validator(label, []byte(content)); err != nil {
+		return &ValidationError{Label: label, Err: err}
+	}
+	return nil
+}
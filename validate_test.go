@@ -0,0 +1,103 @@
+package folio
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// requireName is a schema check: content must be a JSON object with a
+// non-empty string "name".
+func requireName(label string, content []byte) error {
+	var v struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(content, &v); err != nil {
+		return err
+	}
+	if v.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+// TestValidator verifies that Config.Validator rejects content on every
+// write path that takes it, naming the label, and writes nothing.
+func TestValidator(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{Validator: requireName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Set("ok", `{"name":"a"}`); err != nil {
+		t.Fatalf("valid Set: %v", err)
+	}
+
+	writes := map[string]func() error{
+		"Set":        func() error { return db.Set("bad", `{"count":1}`) },
+		"SetWithTTL": func() error { return db.SetWithTTL("bad", "x", time.Second) },
+		"Create":     func() error { return db.Create("bad", `{}`) },
+		"Update":     func() error { return db.Update("ok", `{"name":""}`) },
+		"SetReader":  func() error { return db.SetReader("bad", strings.NewReader("not json")) },
+		"Batch": func() error {
+			return db.Batch(Document{Label: "fine", Data: `{"name":"b"}`}, Document{Label: "bad", Data: "{}"})
+		},
+		"Txn": func() error { return db.Txn(func(tx *Txn) error { return tx.Set("bad", "{}") }) },
+	}
+	for name, write := range writes {
+		err := write()
+		var ve *ValidationError
+		if !errors.Is(err, ErrInvalidContent) || !errors.As(err, &ve) || ve.Label == "" {
+			t.Errorf("%s = %v, want a ValidationError", name, err)
+		}
+	}
+	if ve := new(ValidationError); errors.As(db.Set("bad", "{}"), &ve) && ve.Label != "bad" {
+		t.Errorf("ValidationError.Label = %q, want bad", ve.Label)
+	}
+	for _, lbl := range []string{"bad", "fine"} {
+		if ok, _ := db.Exists(lbl); ok {
+			t.Errorf("%s was written", lbl)
+		}
+	}
+	if got, _ := db.Get("ok"); got != `{"name":"a"}` {
+		t.Errorf("Get(ok) = %s after a rejected Update", got)
+	}
+}
+
+// TestValidatorImport verifies that Import checks only the current
+// version of each document.
+func TestValidatorImport(t *testing.T) {
+	dump := `{"folio_export":1}
+{"l":"a","c":1706000000000,"v":[{"ts":1706000000000,"d":"old format"},{"ts":1706000500000,"d":"{\"name\":\"a\"}"}]}
+{"l":"b","c":1706000000000,"v":[{"ts":1706000000000,"d":"{\"name\":\"b\"}"},{"ts":1706000500000,"d":"{}"}]}
+`
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{Validator: requireName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = db.Import(strings.NewReader(dump))
+	var ve *ValidationError
+	if !errors.As(err, &ve) || ve.Label != "b" {
+		t.Fatalf("Import = %v, want a ValidationError for b", err)
+	}
+	if ok, _ := db.Exists("a"); !ok {
+		t.Error("a, whose history does not validate, was not imported")
+	}
+}
+
+// TestValidJSON verifies the built-in JSON validator.
+func TestValidJSON(t *testing.T) {
+	if err := ValidJSON("x", []byte(`{"a":[1,2]}`)); err != nil {
+		t.Errorf("ValidJSON(object) = %v", err)
+	}
+	if err := ValidJSON("x", []byte(`{"a":`)); err == nil {
+		t.Error("ValidJSON accepted truncated JSON")
+	}
+}
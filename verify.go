@@ -0,0 +1,440 @@
+// Whole-file consistency check.
+//
+// Reads normally find damage lazily: a flipped byte surfaces as a
+// checksum error from the Get that happens to touch it, possibly months
+// later. Verify walks every line of the file once and reports every
+// problem it finds instead of stopping at the first:
+//
+//   - header: section boundaries in order, inside the file, and on line
+//     boundaries; the metadata offset pointing at a metadata record.
+//   - framing: every line either blank or a JSON record long enough for
+//     the fixed-position fields, which must agree with the parsed JSON,
+//     and of a type that belongs in its section; in a file of version 5
+//     or later, every line of the index section of one width.
+//   - identity: each record's _id is the hash of its _l under the file's
+//     algorithm, and the sorted index section is in ID order.
+//   - segments: each sealed segment (see segment.go) inside the sparse
+//     region, after the one before and on line boundaries; its lines in
+//     ID order, index lines after the others and no others among them.
+//   - indexes: each live index points at the start of a current data
+//     record with the same ID and label, no label has two live indexes,
+//     and no current data record is left without one.
+//   - content: every current record's _d matches its checksum, and every
+//     _h snapshot decompresses (and decrypts) to content matching it,
+//     patched onto the version before for a delta.
+//
+// Verify only reads. Most problems it reports are repaired by Repair,
+// which rebuilds the file from the records that still decode.
+package folio
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+
+	json "github.com/goccy/go-json"
+)
+
+// VerifyOptions controls what Verify checks.
+type VerifyOptions struct {
+	// SkipHistory skips decompressing _h snapshots, the slowest check.
+	// Current content is still verified against its checksum.
+	SkipHistory bool
+
+	// MaxProblems stops the walk once this many problems have been
+	// found. 0 = report them all.
+	MaxProblems int
+
+	// Progress, if set, is called as the walk advances, with done and
+	// total in bytes of the file (see progress.go).
+	Progress func(done, total int64)
+}
+
+// Problem is one inconsistency found by Verify. Err wraps the sentinel
+// a read would have failed with (ErrCorruptHeader, ErrCorruptRecord,
+// ErrCorruptIndex, ErrChecksum, ErrDecompress, or ErrDecrypt).
+type Problem struct {
+	Offset int64 // start of the offending line; 0 for the header
+	Err    error
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("offset %d: %v", p.Offset, p.Err)
+}
+
+// VerifyReport is the result of a Verify walk.
+type VerifyReport struct {
+	Records  int // current data records
+	Versions int // history records
+	Indexes  int // live index lines
+	Problems []Problem
+}
+
+// OK reports whether no problems were found.
+func (r VerifyReport) OK() bool { return len(r.Problems) == 0 }
+
+// errStop ends a walk that has reached MaxProblems.
+var errStop = errors.New("verify: problem limit reached")
+
+// check holds the state of one Verify walk.
+type check struct {
+	db     *DB
+	opts   VerifyOptions
+	report VerifyReport
+
+	// The ID of the last record checked, and the last content restored
+	// for each label with that ID, which a delta is patched onto.
+	group string
+	prev  map[string][]byte
+}
+
+func (c *check) problem(off int64, err error) error {
+	c.report.Problems = append(c.report.Problems, Problem{off, err})
+	if c.opts.MaxProblems > 0 && len(c.report.Problems) >= c.opts.MaxProblems {
+		return errStop
+	}
+	return nil
+}
+
+// Verify checks the whole file and reports what it finds; see the package
+// comment for the list of checks. The error is non-nil only when the
+// walk itself cannot run. Verify holds the read lock throughout and
+// counts toward Config.MaxConcurrentScans.
+func (db *DB) Verify(opts VerifyOptions) (VerifyReport, error) {
+	db.beginScan()
+	defer db.endScan()
+	if err := db.blockRead(); err != nil {
+		return VerifyReport{}, err
+	}
+	defer func() {
+		db.mu.RUnlock()
+		db.lock.Unlock()
+	}()
+
+	c := &check{db: db, opts: opts}
+	if err := c.walk(); err != nil && !errors.Is(err, errStop) {
+		return c.report, err
+	}
+	if !c.report.OK() {
+		db.log.Error("corruption detected", "op", "verify", "problems", len(c.report.Problems))
+	}
+	return c.report, nil
+}
+
+// lineInfo is what the walk remembers about a line, for resolving
+// indexes once it is done.
+type lineInfo struct {
+	typ   int
+	id    string
+	label string
+}
+
+// walk runs every check. It returns errStop at the problem limit.
+func (c *check) walk() error {
+	db := c.db
+	sz, err := size(db.reader)
+	if err != nil {
+		return fmt.Errorf("verify: stat: %w", err)
+	}
+	heap, index := int64(db.header.State[stHeap]), int64(db.header.State[stIndex])
+	if err := c.header(sz, heap, index); err != nil {
+		return err
+	}
+	segs := db.segments()
+	if err := c.segments(segs, sz); err != nil {
+		return err
+	}
+
+	lines := map[int64]lineInfo{}
+	type liveIndex struct {
+		off int64
+		idx *Index
+	}
+	var indexes []liveIndex
+	var prevID string
+	// The segment the walk is in, the part of it (its start or its
+	// index lines), and the ID of the last line in that part.
+	var seg int
+	var part int64
+	var segID string
+	progress := newProgress(c.opts.Progress, sz, progressStep)
+
+	scanner := bufio.NewScanner(io.NewSectionReader(db.reader, HeaderSize, sz-HeaderSize))
+	scanner.Buffer(make([]byte, db.config.ReadBuffer), db.config.MaxRecordSize)
+	off := int64(HeaderSize)
+	for scanner.Scan() {
+		ln := scanner.Bytes()
+		at := off
+		off += int64(len(ln)) + 1
+		progress.to(off)
+
+		if db.width > 0 && at >= heap && at < db.indexEnd() && int64(len(ln)) != db.width {
+			if err := c.problem(at, fmt.Errorf("%w: index line of %d bytes in a section of %d", ErrCorruptIndex, len(ln), db.width)); err != nil {
+				return err
+			}
+		}
+		if len(ln) > 0 && len(bytes.TrimLeft(ln, " ")) == 0 {
+			continue // blanked
+		}
+		if !valid(ln) || len(ln) < MinRecordSize {
+			if err := c.problem(at, fmt.Errorf("%w: malformed line", ErrCorruptRecord)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		typ := int(ln[TypePos] - '0')
+		if err := c.sealed(segs, &seg, &part, &segID, at, ln, typ); err != nil {
+			return err
+		}
+		sorted := heap != 0 && at >= heap && at < db.indexEnd()
+		inTable := db.table != nil && at >= db.indexEnd() && at < index
+		inHeap := heap != 0 && at < heap
+		if sorted && typ != TypeIndex || inTable && typ != TypeTable || inHeap && typ != TypeRecord && typ != TypeHistory {
+			if err := c.problem(at, fmt.Errorf("%w: type %d outside its section", ErrCorruptRecord, typ)); err != nil {
+				return err
+			}
+		}
+
+		switch typ {
+		case TypeIndex:
+			idx, err := decodeIndex(ln)
+			if err != nil {
+				if err := c.problem(at, err); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := c.identity(at, ln, idx.ID, idx.Label, idx.Timestamp, ErrCorruptIndex); err != nil {
+				return err
+			}
+			if sorted {
+				if idx.ID < prevID {
+					if err := c.problem(at, fmt.Errorf("%w: sorted index out of order", ErrCorruptIndex)); err != nil {
+						return err
+					}
+				}
+				prevID = idx.ID
+			}
+			c.report.Indexes++
+			indexes = append(indexes, liveIndex{at, idx})
+
+		case TypeRecord, TypeHistory:
+			r, err := parse(ln)
+			if err != nil {
+				if err := c.problem(at, err); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := c.identity(at, ln, r.ID, r.Label, r.Timestamp, ErrCorruptRecord); err != nil {
+				return err
+			}
+			if typ == TypeRecord {
+				c.report.Records++
+			} else {
+				c.report.Versions++
+			}
+			lines[at] = lineInfo{typ, r.ID, r.Label}
+			if err := c.content(at, ln, r); err != nil {
+				return err
+			}
+
+		case TypeMeta, TypeTxn, TypeTag, TypeTombstone, TypeTable:
+			lines[at] = lineInfo{typ: typ}
+			if !json.Valid(ln) {
+				if err := c.problem(at, ErrCorruptRecord); err != nil {
+					return err
+				}
+			}
+
+		default:
+			if err := c.problem(at, fmt.Errorf("%w: unknown type %d", ErrCorruptRecord, typ)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		// A line longer than MaxRecordSize ends the walk.
+		return c.problem(off, fmt.Errorf("%w: %w", ErrCorruptRecord, err))
+	}
+	progress.finish()
+
+	if meta := int64(db.header.State[stMeta]); meta != 0 && lines[meta].typ != TypeMeta {
+		if err := c.problem(0, fmt.Errorf("%w: metadata offset %d is not a metadata record", ErrCorruptHeader, meta)); err != nil {
+			return err
+		}
+	}
+
+	// Every live index must resolve, once per label, and every current
+	// record must be reachable from one.
+	seen := map[string]bool{}
+	reached := map[int64]bool{}
+	for _, li := range indexes {
+		target, ok := lines[li.idx.Offset]
+		switch {
+		case !ok || target.typ != TypeRecord:
+			err = fmt.Errorf("%w: offset %d is not a current record", ErrCorruptIndex, li.idx.Offset)
+		case target.id != li.idx.ID || target.label != li.idx.Label:
+			err = fmt.Errorf("%w: points at a record for %q", ErrCorruptIndex, target.label)
+		case seen[li.idx.Label]:
+			err = fmt.Errorf("%w: second live index for %q", ErrCorruptIndex, li.idx.Label)
+		default:
+			err = nil
+		}
+		seen[li.idx.Label] = true
+		reached[li.idx.Offset] = true
+		if err != nil {
+			if err := c.problem(li.off, err); err != nil {
+				return err
+			}
+		}
+	}
+	var orphans []int64
+	for at, l := range lines {
+		if l.typ == TypeRecord && !reached[at] {
+			orphans = append(orphans, at)
+		}
+	}
+	slices.Sort(orphans)
+	for _, at := range orphans {
+		if err := c.problem(at, fmt.Errorf("%w: current record for %q has no index", ErrCorruptRecord, lines[at].label)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// header checks the section boundaries against the file.
+func (c *check) header(sz, heap, index int64) error {
+	var bad string
+	switch {
+	case heap == 0 && index != 0:
+		bad = "index section without a heap"
+	case heap != 0 && heap < HeaderSize:
+		bad = "heap ends inside the header"
+	case index < heap:
+		bad = "index section ends before the heap"
+	case index > sz:
+		bad = "index section ends past the end of the file"
+	case c.db.header.Version >= fixedIndexVersion && heap != 0 && index > heap && c.db.width == 0:
+		bad = "index section is not of one line width"
+	}
+	if bad == "" {
+		nl := make([]byte, 1)
+		for _, b := range []int64{heap, index} {
+			if b <= HeaderSize {
+				continue
+			}
+			if _, err := c.db.reader.ReadAt(nl, b-1); err != nil || nl[0] != '\n' {
+				bad = fmt.Sprintf("boundary %d is not at a line start", b)
+				break
+			}
+		}
+	}
+	if bad != "" {
+		return c.problem(0, fmt.Errorf("%w: %s", ErrCorruptHeader, bad))
+	}
+	return nil
+}
+
+// segments checks the bounds of the sealed segments against the file.
+func (c *check) segments(segs []Segment, sz int64) error {
+	from := c.db.tagEnd()
+	nl := make([]byte, 1)
+	for _, s := range segs {
+		var bad string
+		switch {
+		case s.Start < from:
+			bad = "starts before the segment or section ahead of it"
+		case s.Index < s.Start || s.End < s.Index:
+			bad = "has its bounds out of order"
+		case s.End > sz:
+			bad = "ends past the end of the file"
+		}
+		if bad == "" {
+			for _, b := range []int64{s.Start, s.Index, s.End} {
+				if _, err := c.db.reader.ReadAt(nl, b-1); err != nil || nl[0] != '\n' {
+					bad = fmt.Sprintf("boundary %d is not at a line start", b)
+					break
+				}
+			}
+		}
+		if bad != "" {
+			if err := c.problem(0, fmt.Errorf("%w: segment at %d %s", ErrCorruptHeader, s.Start, bad)); err != nil {
+				return err
+			}
+		}
+		from = s.End
+	}
+	return nil
+}
+
+// sealed checks a line of type typ at at against the segment it lies
+// in, if any. seg, part and id carry the walk's place from line to line.
+func (c *check) sealed(segs []Segment, seg *int, part *int64, id *string, at int64, ln []byte, typ int) error {
+	for *seg < len(segs) && at >= segs[*seg].End {
+		*seg++
+	}
+	if *seg == len(segs) || at < segs[*seg].Start {
+		return nil
+	}
+	s := segs[*seg]
+	start := s.Start
+	if at >= s.Index {
+		start = s.Index
+	}
+	if *part != start {
+		*part, *id = start, ""
+	}
+	rid := string(ln[IDStart:IDEnd])
+	switch {
+	case (typ == TypeIndex) != (at >= s.Index):
+		return c.problem(at, fmt.Errorf("%w: type %d in the wrong part of a segment", ErrCorruptRecord, typ))
+	case rid < *id:
+		return c.problem(at, fmt.Errorf("%w: segment out of order", ErrCorruptRecord))
+	}
+	*id = rid
+	return nil
+}
+
+// identity checks that the fixed-position fields agree with the parsed
+// ones and that the ID is the label's hash.
+func (c *check) identity(at int64, ln []byte, id, label string, ts int64, sentinel error) error {
+	fixedTS, _ := strconv.ParseInt(string(ln[TSStart:TSEnd]), 10, 64)
+	if string(ln[IDStart:IDEnd]) != id || fixedTS != ts {
+		return c.problem(at, fmt.Errorf("%w: fixed-position fields disagree with the record", sentinel))
+	}
+	if want := c.db.id(label); want != id {
+		return c.problem(at, fmt.Errorf("%w: _id %s is not the hash of %q", sentinel, id, label))
+	}
+	return nil
+}
+
+// content verifies a record's current content and its snapshot.
+func (c *check) content(at int64, ln []byte, r *Record) error {
+	if r.Type == TypeRecord {
+		if _, err := c.db.decode(ln); err != nil {
+			if err := c.problem(at, err); err != nil {
+				return err
+			}
+		}
+	}
+	if c.opts.SkipHistory {
+		return nil
+	}
+	if r.ID != c.group {
+		c.group, c.prev = r.ID, map[string][]byte{}
+	}
+	key := c.db.fold(r.Label)
+	content, err := c.db.restore(r, c.prev[key])
+	c.prev[key] = content
+	if err != nil {
+		return c.problem(at, fmt.Errorf("snapshot: %w", err))
+	}
+	return nil
+}
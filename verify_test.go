@@ -0,0 +1,151 @@
+// Consistency check tests.
+//
+// Verify earns its keep only if it is quiet on every file the library
+// writes and loud on each kind of damage it claims to detect. The clean
+// cases therefore cover every record type and layout; the damaged cases
+// each break one invariant and check it is reported at the right line
+// with the sentinel a read would have returned.
+package folio
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// mustVerify runs Verify and fails the test if the walk cannot run.
+func mustVerify(t *testing.T, db *DB, opts VerifyOptions) VerifyReport {
+	t.Helper()
+	r, err := db.Verify(opts)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	return r
+}
+
+// TestVerifyClean verifies a file exercised by every write path, before
+// and after compaction, has no problems.
+func TestVerifyClean(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{PersistUsage: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Set("a", "v1")
+	db.Set("a", "v2")
+	db.SetBytes("bin", []byte{0xff, 0x00})
+	db.Set("gone", "x")
+	db.Delete("gone")
+	db.Rename("a", "renamed")
+	db.Txn(func(tx *Txn) error {
+		tx.Set("t1", "one")
+		return tx.Set("t2", "two")
+	})
+
+	check := func(stage string, records, versions int) {
+		r := mustVerify(t, db, VerifyOptions{})
+		if !r.OK() {
+			t.Errorf("%s: problems %v", stage, r.Problems)
+		}
+		if r.Records != records || r.Versions != versions || r.Indexes != records {
+			t.Errorf("%s: %d records, %d versions, %d indexes", stage, r.Records, r.Versions, r.Indexes)
+		}
+	}
+	// A rename to a longer label rewrites the document, retiring v2.
+	check("sparse", 4, 3)
+	db.Compact()
+	check("compacted", 4, 3)
+
+	db.Close()
+	db, _ = Open(filepath.Join(t.TempDir(), "enc.folio"), Config{EncryptionKey: testKey})
+	defer db.Close()
+	db.Set("secret", "hidden")
+	check("encrypted", 1, 0)
+}
+
+// TestVerifyChecksum verifies damaged current content is reported as
+// a checksum failure at the record's offset.
+func TestVerifyChecksum(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "content")
+	db.Compact()
+	flipContent(t, db, HeaderSize)
+
+	r := mustVerify(t, db, VerifyOptions{})
+	if len(r.Problems) == 0 || r.Problems[0].Offset != HeaderSize || !errors.Is(r.Problems[0].Err, ErrChecksum) {
+		t.Errorf("problems = %v, want ErrChecksum at %d", r.Problems, HeaderSize)
+	}
+}
+
+// TestVerifySnapshot verifies damage to a history snapshot is found,
+// and that SkipHistory skips exactly that check.
+func TestVerifySnapshot(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "first version of the content")
+	db.Set("doc", "second")
+	db.Compact()
+
+	data, _ := line(db.reader, HeaderSize)
+	i := bytes.Index(data, []byte(`"_h":"`)) + len(`"_h":"`)
+	db.writeAt(HeaderSize+int64(i)+2, []byte("zz"))
+
+	r := mustVerify(t, db, VerifyOptions{})
+	if len(r.Problems) != 1 || r.Problems[0].Offset != HeaderSize {
+		t.Fatalf("problems = %v, want one at %d", r.Problems, HeaderSize)
+	}
+	if r := mustVerify(t, db, VerifyOptions{SkipHistory: true}); !r.OK() {
+		t.Errorf("SkipHistory: problems %v", r.Problems)
+	}
+}
+
+// TestVerifyIdentity verifies a record whose ID is not its label's hash
+// is reported, along with the index that no longer resolves to it.
+func TestVerifyIdentity(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "content")
+	off := db.tail
+	db.Set("other", "content")
+
+	data, _ := line(db.reader, off)
+	c := data[IDStart] ^ 0x01
+	if c < '0' || c > 'f' {
+		c = '0'
+	}
+	db.writeAt(off+IDStart, []byte{c})
+
+	r := mustVerify(t, db, VerifyOptions{})
+	var identity, index bool
+	for _, p := range r.Problems {
+		identity = identity || p.Offset == off && errors.Is(p.Err, ErrCorruptRecord)
+		index = index || errors.Is(p.Err, ErrCorruptIndex)
+	}
+	if !identity || !index {
+		t.Errorf("problems = %v, want the record and its index", r.Problems)
+	}
+}
+
+// TestVerifyHeaderAndFraming verifies a bad boundary and a garbage line
+// are both reported, and that MaxProblems truncates the report.
+func TestVerifyHeaderAndFraming(t *testing.T) {
+	db := openTestDB(t)
+	db.Set("doc", "content")
+	db.Compact()
+	db.raw([]byte("not a record"))
+	db.header.State[stIndex]++
+
+	r := mustVerify(t, db, VerifyOptions{})
+	var hdr, framing bool
+	for _, p := range r.Problems {
+		hdr = hdr || p.Offset == 0 && errors.Is(p.Err, ErrCorruptHeader)
+		framing = framing || errors.Is(p.Err, ErrCorruptRecord)
+	}
+	if !hdr || !framing {
+		t.Errorf("problems = %v, want header and framing", r.Problems)
+	}
+
+	if r := mustVerify(t, db, VerifyOptions{MaxProblems: 1}); len(r.Problems) != 1 {
+		t.Errorf("MaxProblems 1: %d problems", len(r.Problems))
+	}
+}
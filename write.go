@@ -13,35 +13,62 @@ import (
 // raw appends bytes at db.tail and advances the tail. The dirty flag is
 // set on the first write so that a crash before Close triggers repair.
 func (db *DB) raw(line []byte) (int64, error) {
-	if db.header.Error == 0 {
-		db.header.Error = 1
-		dirty(db.writer, true)
-	}
 	// Every raw write increments the write counter so shouldCompact()
 	// can fire auto-compaction when the counter hits the threshold modulus.
 	// The counter resets to 0 after each compaction (see rebuild).
+	if err := db.room(int64(len(line)) + 1); err != nil {
+		return 0, err
+	}
 	db.header.State[stWrites]++
+	return db.put(line)
+}
 
+// put appends line without counting it as a write, for the records a
+// write leaves beside what it changed, such as a delete's tombstone.
+func (db *DB) put(line []byte) (int64, error) {
+	db.markDirty()
 	offset := db.tail
 	data := append(line, '\n')
 	if _, err := db.writer.WriteAt(data, offset); err != nil {
 		return 0, err
 	}
 	db.tail += int64(len(data))
+	db.usage.bytesWritten.Add(uint64(len(data)))
+	if db.misses != nil {
+		db.misses.appended(offset, data)
+	}
+	if db.recent != nil {
+		db.recent.appended(data)
+	}
+	if db.sizes != nil {
+		db.sizes.appended(offset, data)
+	}
 
-	if db.config.SyncWrites {
-		if err := db.writer.Sync(); err != nil {
-			return 0, err
-		}
+	if db.lazySync {
+		return offset, nil
+	}
+	if err := db.sync(); err != nil {
+		return 0, err
 	}
 	return offset, nil
 }
 
-// append writes a data Record and its Index as a single batch. Both are
+// markDirty sets the dirty flag on the first write of a session.
+func (db *DB) markDirty() {
+	if db.header.Error == 0 {
+		db.header.Error = 1
+		dirty(db.writer, true)
+	}
+}
+
+// append writes a data Record and its Index as a single batch. The
+// record's Data is its plain content; seal fills in the stored form. Both are
 // concatenated into one buffer so a single WriteAt call places them
-// adjacently — if the process crashes mid-write, repair will discard
-// any incomplete trailing line.
+// adjacently, the index last: it is the write's commit marker, and if
+// the process crashes mid-write, recovery discards a record without one
+// (see commit.go).
 func (db *DB) append(record *Record, idx *Index) (int64, error) {
+	db.seal(record, record.Data)
 	rData, err := json.Marshal(record)
 	if err != nil {
 		return 0, err
@@ -64,6 +91,9 @@ func (db *DB) append(record *Record, idx *Index) (int64, error) {
 	if _, err := db.raw(combined); err != nil {
 		return 0, err
 	}
+	if db.smap != nil {
+		db.smap.add(db.fold(idx.Label), dataOffset+int64(len(rData))+1, db.tail)
+	}
 
 	return dataOffset, nil
 }
@@ -83,13 +113,72 @@ func (db *DB) shouldCompact() bool {
 // Used for in-place modifications: toggling the type byte (2→3), blanking
 // content, and overwriting invalidated index records with spaces.
 func (db *DB) writeAt(offset int64, data []byte) error {
+	db.uncache(offset, data)
+	if db.partial != nil {
+		db.partial.touch(offset)
+	}
 	if _, err := db.writer.WriteAt(data, offset); err != nil {
 		return err
 	}
-	if db.config.SyncWrites {
-		if err := db.writer.Sync(); err != nil {
+	if db.lazySync {
+		return nil
+	}
+	return db.sync()
+}
+
+// encodeDoc appends the lines for one whole document to buf: every
+// version but the last as a history record, then the last as the current
+// record followed by its index, expiring at expires if it is non-zero.
+// base is the file offset buf will be written at, so the index can point
+// at the record. Used by Transfer and Import, which recreate documents
+// with their history rather than writing a single new version.
+func (db *DB) encodeDoc(buf []byte, base int64, id, label string, versions []Version, created, expires int64) ([]byte, error) {
+	put := func(v any) error {
+		b, err := json.Marshal(v)
+		if err != nil {
 			return err
 		}
+		buf = append(buf, b...)
+		buf = append(buf, '\n')
+		return nil
+	}
+
+	last := len(versions) - 1
+	for _, v := range versions[:last] {
+		rec := &Record{
+			Type:      TypeHistory,
+			ID:        id,
+			Timestamp: v.TS,
+			Label:     label,
+		}
+		db.seal(rec, v.Data)
+		if err := put(rec); err != nil {
+			return nil, err
+		}
+	}
+
+	current := versions[last]
+	dataOff := base + int64(len(buf))
+	rec := &Record{
+		Type:      TypeRecord,
+		ID:        id,
+		Timestamp: current.TS,
+		Label:     label,
+	}
+	db.seal(rec, current.Data)
+	if err := put(rec); err != nil {
+		return nil, err
+	}
+	if err := put(&Index{
+		Type:      TypeIndex,
+		ID:        id,
+		Timestamp: current.TS,
+		Offset:    dataOff,
+		Label:     label,
+		Created:   created,
+		Expires:   expires,
+	}); err != nil {
+		return nil, err
 	}
-	return nil
+	return buf, nil
 }
@@ -17,6 +17,7 @@
 package folio
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -255,7 +256,7 @@ func TestDeleteWithSyncWrites(t *testing.T) {
 	}
 
 	_, err := db.Get("doc")
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Get deleted: got %v, want ErrNotFound", err)
 	}
 }
@@ -274,3 +275,58 @@ func TestRawWithSyncWrites(t *testing.T) {
 		t.Errorf("raw with SyncWrites: %v", err)
 	}
 }
+
+// countingWriter counts the writes and syncs made through it.
+type countingWriter struct {
+	*os.File
+	writes, syncs int
+}
+
+func (c *countingWriter) WriteAt(p []byte, off int64) (int, error) {
+	c.writes++
+	return c.File.WriteAt(p, off)
+}
+
+func (c *countingWriter) Sync() error {
+	c.syncs++
+	return c.File.Sync()
+}
+
+// TestSetWrites verifies how many writes and syncs a Set makes with
+// SyncWrites: a new document is one append of its record and index, and
+// an update adds one patch retiring the old record and one erasing its
+// index, synced together after the append is.
+func TestSetWrites(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.folio"), Config{SyncWrites: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Set("warm", "content") // the first write also marks the header dirty
+
+	w := &countingWriter{File: db.writer.(*os.File)}
+	db.writer = w
+	defer func() { db.writer = w.File }()
+
+	for _, tc := range []struct {
+		name          string
+		writes, syncs int
+	}{
+		{"new", 1, 1},
+		{"update", 3, 2},
+	} {
+		w.writes, w.syncs = 0, 0
+		if err := db.Set("doc", tc.name); err != nil {
+			t.Fatal(err)
+		}
+		if w.writes != tc.writes || w.syncs != tc.syncs {
+			t.Errorf("%s: %d writes, %d syncs; want %d, %d", tc.name, w.writes, w.syncs, tc.writes, tc.syncs)
+		}
+	}
+	if got, err := db.Get("doc"); err != nil || got != "update" {
+		t.Errorf("Get = %q, %v", got, err)
+	}
+	if rep, err := db.Verify(VerifyOptions{}); err != nil || !rep.OK() {
+		t.Errorf("Verify = %+v, %v", rep.Problems, err)
+	}
+}